@@ -0,0 +1,114 @@
+// Command replay replays requests recorded by the server (see RECORD_DIR)
+// through a fresh in-process server configured from the .env file in the
+// working directory, and reports the ones whose response differs from the
+// recorded one.
+//
+// Usage:
+//
+//	replay [-ignore Date,X-Request-ID] [-v] recording-or-dir...
+//
+// Directories are searched for recordings, non-recursively. The exit
+// status is 1 if any recording fails to replay or differs.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+	"github.com/Abb133Se/httpServer/internal/replay"
+	"github.com/Abb133Se/httpServer/internal/server"
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+func main() {
+	ignore := flag.String("ignore", strings.Join(replay.DefaultIgnoreHeaders, ","), "comma-separated response headers not compared")
+	verbose := flag.Bool("v", false, "log what the server does at the configured LOG_LEVEL")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: replay [-ignore headers] [-v] recording-or-dir...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := config.LoadConfig()
+	if *verbose {
+		utils.InitLogger(cfg.LogLevel)
+	} else {
+		utils.InitLogger("error")
+		cfg.AccessLog = "off"
+	}
+	opts := replay.Options{IgnoreHeaders: []string{}}
+	for _, name := range strings.Split(*ignore, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			opts.IgnoreHeaders = append(opts.IgnoreHeaders, name)
+		}
+	}
+
+	paths, err := recordings(flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	failed := 0
+	for _, path := range paths {
+		if !replayOne(cfg, path, opts) {
+			failed++
+		}
+	}
+	fmt.Printf("%d recordings, %d failed\n", len(paths), failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// replayOne replays the recording at path through a fresh server and
+// prints the outcome, reporting whether it matched.
+func replayOne(cfg *config.Config, path string, opts replay.Options) bool {
+	srv, err := server.NewServerFromConfig(cfg)
+	if err != nil {
+		fmt.Printf("FAIL %s: %v\n", path, err)
+		return false
+	}
+	result, err := replay.ReplayFile(srv, path, opts)
+	if err != nil {
+		fmt.Printf("FAIL %s: %v\n", path, err)
+		return false
+	}
+	if !result.OK() {
+		fmt.Printf("FAIL %s\n", path)
+		for _, diff := range result.Diffs {
+			fmt.Printf("    %s\n", diff)
+		}
+		return false
+	}
+	fmt.Printf("ok   %s\n", path)
+	return true
+}
+
+// recordings expands args, files and directories, into recording paths.
+func recordings(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(arg, "*"+server.RecordingExt))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
@@ -1,15 +1,29 @@
 package main
 
 import (
+	"flag"
+	"os"
+
 	"github.com/Abb133Se/httpServer/internal/config"
 	"github.com/Abb133Se/httpServer/internal/server"
 	"github.com/Abb133Se/httpServer/internal/utils"
 )
 
 func main() {
+	check := flag.Bool("check", false, "check the configuration, routes, TLS files and directories, then exit without serving")
+	flag.Parse()
+
 	config := config.LoadConfig()
 	utils.InitLogger(config.LogLevel)
 
+	if *check {
+		if err := server.DryRun(config); err != nil {
+			utils.Error("Check failed:\n%v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	utils.Info("Server starting")
 
 	if err := server.StartServer(config.Port, config); err != nil {
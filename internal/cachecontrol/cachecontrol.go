@@ -0,0 +1,181 @@
+// Package cachecontrol parses Cache-Control header values (RFC 9111
+// section 5.2) and answers the questions a cache asks of them: may this
+// response be stored, for how long is it fresh, and must a stored copy be
+// revalidated. It is shared by anything that caches responses, such as a
+// response cache middleware or a proxy.
+//
+//	d := cachecontrol.Parse(resp.Headers["Cache-Control"])
+//	if d.Storable(true) {
+//	    ttl := d.TTL(true, time.Minute) // s-maxage, else max-age, else a minute
+//	    ...
+//	}
+package cachecontrol
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxDeltaSeconds is the value RFC 9111 section 1.2.2 has caches use for
+// delta-seconds too large to represent.
+const maxDeltaSeconds = 1 << 31
+
+// Directives are the parsed directives of a Cache-Control value.
+type Directives struct {
+	// MaxAge and SMaxAge are the max-age and s-maxage values; HasMaxAge
+	// and HasSMaxAge say whether they were given. An invalid value reads
+	// as zero, making the response stale at once.
+	MaxAge     time.Duration
+	HasMaxAge  bool
+	SMaxAge    time.Duration
+	HasSMaxAge bool
+
+	NoCache        bool
+	NoStore        bool
+	Private        bool
+	Public         bool
+	MustRevalidate bool
+
+	// Other holds the remaining directives by lower-case name, with their
+	// unquoted values, or "" for directives without one.
+	Other map[string]string
+}
+
+// Parse parses a Cache-Control value. Directive names are matched
+// case-insensitively; unknown directives land in Other, and when a
+// directive is repeated the first occurrence wins. Qualified forms such as
+// private="Set-Cookie" are treated like the bare directive, which is the
+// stricter reading.
+func Parse(value string) Directives {
+	var d Directives
+	seen := make(map[string]bool)
+	for _, item := range splitList(value) {
+		name, arg, _ := strings.Cut(item, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		arg = unquote(strings.TrimSpace(arg))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		switch name {
+		case "max-age":
+			d.MaxAge, d.HasMaxAge = deltaSeconds(arg), true
+		case "s-maxage":
+			d.SMaxAge, d.HasSMaxAge = deltaSeconds(arg), true
+		case "no-cache":
+			d.NoCache = true
+		case "no-store":
+			d.NoStore = true
+		case "private":
+			d.Private = true
+		case "public":
+			d.Public = true
+		case "must-revalidate":
+			d.MustRevalidate = true
+		default:
+			if d.Other == nil {
+				d.Other = make(map[string]string)
+			}
+			d.Other[name] = arg
+		}
+	}
+	return d
+}
+
+// Storable reports whether a response with these directives may be
+// stored: never with no-store, and not by a shared cache when private.
+// no-cache responses may be stored, but see MustRevalidateStored.
+func (d Directives) Storable(shared bool) bool {
+	return !d.NoStore && !(shared && d.Private)
+}
+
+// TTL returns how long a stored response stays fresh: s-maxage for a
+// shared cache, else max-age, else def, the cache's own default. A
+// response carrying no-cache is stale at once.
+func (d Directives) TTL(shared bool, def time.Duration) time.Duration {
+	switch {
+	case d.NoCache:
+		return 0
+	case shared && d.HasSMaxAge:
+		return d.SMaxAge
+	case d.HasMaxAge:
+		return d.MaxAge
+	}
+	return def
+}
+
+// MustRevalidateStored reports whether a stored copy must be validated
+// with the origin before every use, because the response said no-cache,
+// or, for a request's directives, because the client asked for a fresh
+// answer with no-cache or max-age=0.
+func (d Directives) MustRevalidateStored() bool {
+	return d.NoCache || d.HasMaxAge && d.MaxAge == 0
+}
+
+// Age returns the value of the Age header for a response stored at
+// stored and served at now, in whole seconds.
+func Age(stored, now time.Time) string {
+	age := now.Sub(stored)
+	if age < 0 {
+		age = 0
+	}
+	return strconv.FormatInt(int64(age/time.Second), 10)
+}
+
+// deltaSeconds parses a delta-seconds value, clamping large ones and
+// reading invalid ones as zero.
+func deltaSeconds(arg string) time.Duration {
+	if arg == "" {
+		return 0
+	}
+	for _, c := range arg {
+		if c < '0' || c > '9' {
+			return 0
+		}
+	}
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n > maxDeltaSeconds {
+		n = maxDeltaSeconds
+	}
+	return time.Duration(n) * time.Second
+}
+
+// splitList splits a comma-separated list, leaving commas inside quoted
+// strings alone.
+func splitList(value string) []string {
+	var items []string
+	start, quoted := 0, false
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				items = append(items, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(items, value[start:])
+}
+
+// unquote strips the quotes and escapes of a quoted-string.
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	s = s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,112 @@
+package cachecontrol
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Directives
+	}{
+		{"", Directives{}},
+		{"max-age=60", Directives{MaxAge: time.Minute, HasMaxAge: true}},
+		{"Max-Age=60, S-MAXAGE=120", Directives{MaxAge: time.Minute, HasMaxAge: true, SMaxAge: 2 * time.Minute, HasSMaxAge: true}},
+		{`max-age="30"`, Directives{MaxAge: 30 * time.Second, HasMaxAge: true}},
+		{"max-age=abc", Directives{HasMaxAge: true}},
+		{"max-age=-5", Directives{HasMaxAge: true}},
+		{"max-age=99999999999999999999", Directives{MaxAge: maxDeltaSeconds * time.Second, HasMaxAge: true}},
+		{"max-age=10, max-age=20", Directives{MaxAge: 10 * time.Second, HasMaxAge: true}},
+		{"no-cache, no-store", Directives{NoCache: true, NoStore: true}},
+		{"public, must-revalidate", Directives{Public: true, MustRevalidate: true}},
+		{`private="Set-Cookie, X-Token", max-age=5`, Directives{Private: true, MaxAge: 5 * time.Second, HasMaxAge: true}},
+		{"no-transform, stale-while-revalidate=30", Directives{Other: map[string]string{"no-transform": "", "stale-while-revalidate": "30"}}},
+		{" , ,max-age=1,", Directives{MaxAge: time.Second, HasMaxAge: true}},
+	}
+	for _, tt := range tests {
+		if got := Parse(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestStorable(t *testing.T) {
+	tests := []struct {
+		value           string
+		shared, private bool // storable by a shared and a private cache
+	}{
+		{"max-age=60", true, true},
+		{"private, max-age=60", false, true},
+		{"no-store", false, false},
+		{"no-cache", true, true},
+		{"public", true, true},
+	}
+	for _, tt := range tests {
+		d := Parse(tt.value)
+		if got := d.Storable(true); got != tt.shared {
+			t.Errorf("Parse(%q).Storable(shared) = %v, want %v", tt.value, got, tt.shared)
+		}
+		if got := d.Storable(false); got != tt.private {
+			t.Errorf("Parse(%q).Storable(private) = %v, want %v", tt.value, got, tt.private)
+		}
+	}
+}
+
+func TestTTL(t *testing.T) {
+	const def = 5 * time.Minute
+	tests := []struct {
+		value  string
+		shared bool
+		want   time.Duration
+	}{
+		{"", true, def},
+		{"public", true, def},
+		{"max-age=60", true, time.Minute},
+		{"max-age=60, s-maxage=10", true, 10 * time.Second},
+		{"max-age=60, s-maxage=10", false, time.Minute},
+		{"s-maxage=10", false, def},
+		{"no-cache, max-age=60", true, 0},
+		{"max-age=0", true, 0},
+	}
+	for _, tt := range tests {
+		if got := Parse(tt.value).TTL(tt.shared, def); got != tt.want {
+			t.Errorf("Parse(%q).TTL(%v) = %v, want %v", tt.value, tt.shared, got, tt.want)
+		}
+	}
+}
+
+func TestMustRevalidateStored(t *testing.T) {
+	for value, want := range map[string]bool{
+		"":                 false,
+		"no-cache":         true,
+		"max-age=0":        true,
+		"max-age=10":       false,
+		"must-revalidate":  false,
+		"NO-CACHE, public": true,
+	} {
+		if got := Parse(value).MustRevalidateStored(); got != want {
+			t.Errorf("Parse(%q).MustRevalidateStored() = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestAge(t *testing.T) {
+	stored := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		elapsed time.Duration
+		want    string
+	}{
+		{0, "0"},
+		{999 * time.Millisecond, "0"},
+		{time.Second, "1"},
+		{90*time.Second + 500*time.Millisecond, "90"},
+		{-time.Second, "0"}, // clock stepped back
+	}
+	for _, tt := range tests {
+		if got := Age(stored, stored.Add(tt.elapsed)); got != tt.want {
+			t.Errorf("Age after %v = %q, want %q", tt.elapsed, got, tt.want)
+		}
+	}
+}
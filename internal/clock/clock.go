@@ -0,0 +1,167 @@
+// Package clock abstracts the time source so that time-dependent code such
+// as rate limiting and connection lifetimes can be driven deterministically.
+//
+// Production code uses Real. Tests use a Manual clock and move it forward
+// with Advance instead of sleeping:
+//
+//	c := clock.NewManual(time.Unix(0, 0))
+//	limiter.SetClock(c)
+//	c.Advance(time.Minute) // the rate limit window rolls over instantly
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is a source of the current time and of timers.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the subset of *time.Timer behavior components rely on.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Real is the wall clock, backed by the time package.
+var Real Clock = realClock{}
+
+// Since returns the time elapsed on c since t.
+func Since(c Clock, t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (r realTimer) C() <-chan time.Time        { return r.t.C }
+func (r realTimer) Stop() bool                 { return r.t.Stop() }
+func (r realTimer) Reset(d time.Duration) bool { return r.t.Reset(d) }
+
+// Manual is a Clock that only moves when told to. Timers and After
+// channels fire, in deadline order, when Advance or Set moves the clock to
+// or past their deadline. It is safe for concurrent use.
+type Manual struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+// NewManual creates a Manual clock reading start.
+func NewManual(start time.Time) *Manual {
+	return &Manual{now: start}
+}
+
+// Now returns the clock's current time.
+func (m *Manual) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+// Advance moves the clock forward by d and fires any timers that are due.
+func (m *Manual) Advance(d time.Duration) {
+	m.mu.Lock()
+	now := m.now.Add(d)
+	m.mu.Unlock()
+	m.Set(now)
+}
+
+// Set moves the clock to t and fires any timers that are due. Moving the
+// clock backwards fires nothing.
+func (m *Manual) Set(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = t
+
+	sort.Slice(m.timers, func(i, j int) bool { return m.timers[i].deadline.Before(m.timers[j].deadline) })
+	pending := m.timers[:0]
+	for _, timer := range m.timers {
+		if timer.deadline.After(t) {
+			pending = append(pending, timer)
+			continue
+		}
+		select {
+		case timer.ch <- t:
+		default:
+		}
+	}
+	m.timers = pending
+}
+
+// After returns a channel that receives the clock's time once it has been
+// advanced by d.
+func (m *Manual) After(d time.Duration) <-chan time.Time {
+	return m.NewTimer(d).C()
+}
+
+// NewTimer creates a timer firing once the clock has been advanced by d. A
+// non-positive d fires on the next Advance or Set.
+func (m *Manual) NewTimer(d time.Duration) Timer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	timer := &manualTimer{clock: m, ch: make(chan time.Time, 1)}
+	m.schedule(timer, d)
+	return timer
+}
+
+// Pending returns the number of timers waiting to fire, so tests can wait
+// until a component has armed its timer before advancing.
+func (m *Manual) Pending() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.timers)
+}
+
+// schedule arms timer to fire d after now. m.mu must be held.
+func (m *Manual) schedule(timer *manualTimer, d time.Duration) {
+	timer.deadline = m.now.Add(d)
+	m.timers = append(m.timers, timer)
+}
+
+// unschedule removes timer, reporting whether it was armed. m.mu must be
+// held.
+func (m *Manual) unschedule(timer *manualTimer) bool {
+	for i, t := range m.timers {
+		if t == timer {
+			m.timers = append(m.timers[:i], m.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type manualTimer struct {
+	clock    *Manual
+	ch       chan time.Time
+	deadline time.Time
+}
+
+func (t *manualTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *manualTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.unschedule(t)
+}
+
+func (t *manualTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	wasActive := t.clock.unschedule(t)
+	t.clock.schedule(t, d)
+	return wasActive
+}
@@ -4,8 +4,10 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Abb133Se/httpServer/internal/utils"
@@ -20,18 +22,349 @@ import (
 // Environment variables:
 //   - PORT:          Server listening port (default: "4221")
 //   - READ_TIMEOUT:  Maximum duration for reading a request (default: 5 seconds)
-//   - WRITE_TIMEOUT: Maximum duration for writing a response (default: 5 seconds)
-//   - IDLE_TIMEOUT:  Maximum time to keep an idle connection open (default: 30 seconds)
+//   - WRITE_TIMEOUT: Maximum duration for writing a response, after which the connection is closed;
+//     streamed responses get it anew for every chunk, so they run as long as the client keeps reading
+//     (default: 5 seconds)
+//   - IDLE_TIMEOUT:  Maximum time a keep-alive connection may wait for its next request to start;
+//     READ_TIMEOUT then applies to reading it (default: 30 seconds)
+//   - MAX_REQUESTS_PER_CONN: Requests served on one connection, the last answered with
+//     "Connection: close", 0 for unlimited (default: 100)
 //   - LOG_LEVEL:     Logging verbosity level ("debug", "info", "warn", default: "info")
+//   - BASE_PATH:     Path prefix all routes are mounted under, e.g. "/svc/files-api" (default: none)
+//   - NORMALIZE_PATHS: What to do with request paths that are not in canonical form (duplicate slashes,
+//     dot segments, needlessly percent-encoded characters): "off", "rewrite" to route them by the
+//     canonical path, or "redirect" to answer with a redirect to it (default: "off")
+//   - NORMALIZE_HOST: "true" to lowercase the Host header while NORMALIZE_PATHS is on (default: "false")
+//   - MAX_CONNECTION_LIFETIME: Age after which keep-alive connections are closed, 0 for unlimited (default: 0)
+//   - CONNECTION_LIFETIME_JITTER: Random spread applied to MAX_CONNECTION_LIFETIME, in percent (default: 10)
+//   - BODY_POLICIES: Per-method request body handling overrides in the form
+//     "METHOD=action:limit,..." with action allow, drain or reject
+//     (default: POST/PUT/PATCH allow, DELETE/OPTIONS drain, GET/HEAD reject above 64 KB)
+//   - FILES_TENANTS: Comma-separated tenant mounts for /files/ in the form
+//     "name=dir:maxBytes" (maxBytes 0 means unlimited, default: none)
+//   - STATIC_MOUNTS: Comma-separated static directory mounts in the form
+//     "prefix=dir[;option...]", registered ahead of every other route. Options: "listing" for
+//     directory listings, "hidden" to list dotfiles too, "index=name" (default "index.html"), "max-age=duration" such as 3600,
+//     30d or 1y, "attachment" to force downloads, and "writable" to accept PUT, POST and DELETE;
+//     e.g. "/assets=./assets;max-age=1y,/downloads=/srv/files;listing;attachment" (default: none)
+//   - PROXY_MOUNTS: Comma-separated reverse-proxy mounts in the form "prefix=upstream URL", forwarding
+//     requests under prefix to the upstream with the prefix replaced by the URL's path,
+//     e.g. "/api=http://127.0.0.1:8080,/legacy=http://old.internal/v1" (default: none)
+//   - PROXY_DIAL_TIMEOUT: Seconds to connect to an upstream, TLS handshake included (default: 10)
+//   - PROXY_HEADER_TIMEOUT: Seconds to wait for an upstream's response headers (default: 30)
+//   - PROXY_BODY_TIMEOUT: Seconds an upstream may pause while sending a body (default: 30);
+//     exceeding any of the three answers 504 naming the phase
+//   - FILES_TRASH_DIR: Directory DELETE on /files/ moves files into instead of removing them;
+//     it must be on the same filesystem as the public directory (default: none, delete permanently)
+//   - FILES_TRASH_TTL: Time trashed files are kept before being purged, 0 to keep them (default: 604800 seconds)
+//   - FILES_META_ENABLED: "true" to serve file metadata at /files-meta/{filename}, kept in hidden
+//     sidecar files, and delete files past their "expires" key (default: "false")
+//   - FILES_META_MAX_BYTES: Largest metadata of one file, encoded as JSON, 0 for no limit (default: 4096)
+//   - FILES_META_HEADERS: Comma-separated metadata keys sent as X-Meta-{Key} headers on downloads (default: none)
+//   - FILES_META_SWEEP_INTERVAL: Time between sweeps for expired files (default: 60 seconds)
+//   - FILES_NEGATIVE_CACHE_SIZE: Missing /files/ paths remembered to answer repeated 404s without
+//     touching the disk, 0 to disable (default: 1024)
+//   - FILES_NEGATIVE_CACHE_TTL: Time a missing path is remembered (default: 5 seconds)
+//   - FILES_HEALTH_INTERVAL: Time between checks that the public directory is available, 0 to disable (default: 10 seconds)
+//   - FILES_HEALTH_SENTINEL: File in the public directory the health check reads, empty to list the directory instead (default: none)
+//   - ENABLE_DIR_LISTING: "true" to answer GET /files/ on a directory without an index.html with an HTML
+//     or JSON listing of its entries, rather than 403 (default: "false")
+//   - FILES_READ_ONLY: "true" to refuse every modification through /files/ with 403; can be
+//     switched at runtime through /admin/files (default: "false")
+//   - FILES_CROSS_PROCESS_LOCKING: "true" to also lock writes and deletes through /files/ with
+//     a lock file per target, for server instances sharing the public directory (default: "false")
+//   - FILES_LOCK_TIMEOUT: Time a write waits for another process's lock before 423 Locked (default: 5 seconds)
+//   - FILES_UPLOAD_SPACE_MARGIN: Bytes that must stay free on the public directory's disk beyond an
+//     upload's Content-Length, or the upload is refused with 507 before its body is read (default: 10485760)
+//   - FILES_LOW_SPACE_BYTES: Free space on the public directory's disk below which the health check
+//     reports /readyz as degraded, 0 to disable (default: 0)
+//   - DRAIN_TIMEOUT: Drain window announced via Retry-After while shutting down (default: 10 seconds)
+//   - SHUTDOWN_REPORT_FILE: File the JSON shutdown report is written to on exit (default: none)
+//   - TLS_CERT_FILE, TLS_KEY_FILE: Serve HTTPS with this key pair when both are set
+//   - TLS_HANDSHAKE_TIMEOUT: Maximum time for a client to complete the TLS handshake (default: 10 seconds)
+//   - TLS_EXPIRY_WARNING: Time before the certificate expires from which startup and -check warn
+//     about it (default: 2592000 seconds, 30 days)
+//   - TLS_CLIENT_CA_FILE: PEM bundle of CAs trusted to sign client certificates
+//   - TLS_CLIENT_AUTH: Client certificate policy ("none", "verify_if_given",
+//     "require_and_verify", default: "none")
+//   - WARMUP_TIMEOUT: Maximum time for warm-up functions before readiness (default: 30 seconds)
+//   - WARMUP_FAILURE: "fatal" to abort startup when a warm-up fails, or "warn" (default: "warn")
+//   - ERROR_PAGES_DIR: Directory of {status}.html pages served to clients accepting HTML (default: none)
+//   - CLIENT_CERT_ACL: Certificate identity to route prefix mapping in the form
+//     "identity=/prefix|/other,identity2=/prefix2" (default: none)
+//   - RATE_LIMIT: Requests allowed per client IP per window, 0 disables (default: 0)
+//   - RATE_LIMIT_GLOBAL: Requests allowed across all clients per window, 0 disables (default: 0)
+//   - RATE_LIMIT_WINDOW: Rate limit window length (default: 60 seconds)
+//   - PRIORITY_LANE_PATHS: Comma-separated path prefixes, such as "/healthz,/readyz,/admin/", whose requests skip RATE_LIMIT and full routes (default: none)
+//   - PRIORITY_LANE_LOOPBACK: "true" gives requests from loopback peers the same priority (default: false)
+//   - PRIORITY_LANE_CAPACITY: Priority requests allowed to run past full routes at once (default: 4)
+//   - PRIORITY_LANE_RATE: Priority requests admitted per second, more are handled normally (default: 20)
+//   - MAX_CONCURRENT_REQUESTS: Handlers each route may run at once, 0 for unlimited; requests beyond it
+//     get 503 (default: 0)
+//   - FAIR_QUEUE: "true" to share each route's MAX_CONCURRENT_REQUESTS slots fairly between client IPs,
+//     queueing requests instead of refusing them (default: "false")
+//   - FAIR_QUEUE_CLIENT_IN_FLIGHT: Requests of a route one client may run while other clients wait (default: 2)
+//   - FAIR_QUEUE_CLIENT_DEPTH: Requests of a route one client may have queued; more get 429 (default: 8)
+//   - FAIR_QUEUE_WAIT: How long a queued request waits for a slot before getting 503 (default: 5 seconds)
+//   - FAIR_QUEUE_TOP_CLIENTS: Busiest clients per route whose queue stats are exposed in /metrics (default: 10)
+//   - ROUTE_RATE_LIMITS: Per-route rate limits in the form "[METHOD ]pattern=rate:burst[:principal],..."
+//     where rate is requests per second, or per minute or hour as "5/m" or "100/h", and
+//     ":principal" keys clients by authenticated identity instead of IP,
+//     e.g. "POST /login=5/m:5,/user/:id=100:200" (default: none)
+//   - MAX_BUFFERED_BYTES: Memory all connections together may hold in buffered request and response
+//     bodies; request bodies over 64KB that would exceed it are refused with 503, 0 for no limit (default: 0)
+//   - MEMORY_HEAP_LIMIT: Go heap size in bytes above which request bodies over 64KB are refused with 503
+//     until it drops again, checked every second, 0 to disable (default: 0)
+//   - BODY_PREVIEW_BYTES: Request body bytes included in dumps and crash reports (default: 4096)
+//   - DUMP_REQUESTS: "true" to log every request with a body preview at debug level (default: "false")
+//   - DEBUG_CAPTURE_RAW: "true" to keep each request's raw request line and header lines,
+//     in wire order, for dumps and crash reports (default: "false")
+//   - LENIENT_PARSING: "true" to accept sloppy requests, such as bare LF line endings, lowercase methods
+//     and stray whitespace, counting each tolerance in a lenient_parse_* metric (default: "false")
+//   - SSE_ENDPOINT: "true" to serve GET /events, streaming file changes and admin broadcasts
+//     (POST /admin/events) as Server-Sent Events (default: "false")
+//   - STREAM_STALL_AFTER: Seconds a streamed response's client may take to accept a write before the
+//     stream counts as stalled, listed at /admin/streams and in the streams_stalled metric (default: 10)
+//   - STREAM_STALL_TIMEOUT: Seconds after which a write to a stalled client ends the stream; 0 uses
+//     WRITE_TIMEOUT (default: 0)
+//   - BATCH_ENDPOINT: "true" to serve POST /batch, running a JSON array of requests in one round trip (default: "false")
+//   - BATCH_MAX_REQUESTS: Most requests one batch may hold (default: 20)
+//   - METRICS_ENABLED: "true" to serve counters and latency histograms at /metrics (default: "false")
+//   - VERSION_ENDPOINT: "true" to serve build version, commit and Go runtime as JSON at /version (default: "false")
+//   - SERVER_HEADER: "true" to send "Server: httpServer/<version>" on responses (default: "false")
+//   - JSON_FIELDS: Field selection on JSON responses through "?fields=id,name,files.size":
+//     "off", "lenient" to drop unknown fields, or "strict" to answer them with 400 (default: "off")
+//   - COMPRESSION: "true" to gzip buffered responses for clients that accept it (default: "false")
+//   - COMPRESS_EXCLUDE_TYPES: Comma-separated content types never compressed, "type/*"
+//     matching a whole type (default: "image/*,video/*,application/zip,text/event-stream")
+//   - ALT_SVC: Alt-Svc header value advertising an alternative service, e.g.
+//     'h2=":8443"; ma=86400' (default: "", none)
+//   - H2C_ADVERTISE: "true" to advertise "Upgrade: h2c" on plaintext responses (default: "false")
+//   - EXTRA_RESPONSE_HEADERS: Comma-separated Name=Value headers added to every response
+//     unless the handler set them, e.g. "X-Frame-Options=DENY" (default: "")
+//   - DOCS_ENABLED: "true" to serve human-readable route documentation at /docs (default: "false")
+//   - QUERY_DUPLICATES: Policy for repeated query parameters: "first", "last",
+//     "reject" or "all" (default: "first")
+//   - QUERY_MAX_PARAMS: Maximum number of query parameters, 0 for no limit (default: 100)
+//   - QUERY_MAX_LENGTH: Maximum query string length in bytes, 0 for no limit (default: 8192)
+//   - METHOD_MODE: "strict" to reject invalid (400) and unregistered (501) request
+//     methods, or "lenient" to route any method (default: "lenient")
+//   - MAX_RESPONSE_SIZE: Largest body in bytes a handler may produce, 0 for unlimited (default: 0)
+//   - MAX_RESPONSE_HEADER_BYTES: Largest response header block; bigger ones are replaced
+//     by a 500, 0 for unlimited (default: 65536)
+//   - MAX_RESPONSE_HEADERS: Most header fields a response may carry, 0 for unlimited (default: 100)
+//   - STREAM_CHUNK_SIZE: Bytes of small streamed writes aggregated into one chunk before it is
+//     sent, 1 to send every write as its own chunk (default: 8192)
+//   - GENERATE_MAX_BYTES: Largest payload /generate will produce (default: 1073741824)
+//   - BANNED_IPS: Comma-separated client IPs or CIDRs whose connections are closed on accept (default: none)
+//   - MAX_CONNS_PER_IP: Open connections allowed per client IP, 0 for unlimited (default: 0)
+//   - ALLOWED_HOSTS: Comma-separated hostnames, "*.example.com" subdomain patterns and IPs the
+//     server answers for; other Host headers get 421, guarding against DNS rebinding (default: any)
+//   - DEV_MODE: "true" for development: ALLOWED_HOSTS also admits localhost and loopback IPs (default: "false")
+//   - TRUSTED_PROXIES: Comma-separated proxy IPs or CIDRs whose X-Forwarded-For header
+//     is believed when determining the client IP; the client is the rightmost entry that is
+//     not one of them (default: none)
+//   - CORS_ALLOWED_ORIGINS: Comma-separated origins allowed cross-origin access, "*" for any;
+//     CORS is off when unset (default: none)
+//   - CORS_ALLOWED_METHODS: Methods advertised for routes accepting any method (default: "GET,HEAD,POST,PUT,DELETE")
+//   - CORS_ALLOWED_HEADERS: Request headers cross-origin clients may send, "*" for any
+//     (default: "Content-Type,Authorization")
+//   - CORS_EXPOSED_HEADERS: Response headers cross-origin clients may read (default: none)
+//   - CORS_ALLOW_CREDENTIALS: "true" to allow cookies and HTTP authentication cross-origin (default: "false")
+//   - CORS_MAX_AGE: Seconds browsers may cache a preflight response (default: 600)
+//   - MIDDLEWARE_TRACE: "off", "header" to trace requests sending "X-Debug-Middleware: 1" from loopback
+//     or with ADMIN_TOKEN, or "always"; traces go to the X-Middleware-Trace header and the log (default: "off")
+//   - EVENT_LOG: "stdout" or a file to append one JSON event per request to, for SIEM ingestion (default: none)
+//   - EVENT_LOG_HEADERS: Comma-separated request headers copied into EVENT_LOG events
+//     (default: "user-agent,referer,content-type,content-length")
+//   - ACCESS_LOG: "stdout", a file to append one access log line per request to, or "off" (default: "stdout")
+//   - ACCESS_LOG_FORMAT: "plain" for Common Log Format lines with the duration appended, or "json" (default: "plain")
+//   - RECORD_DIR: Spool directory for recorded requests and responses, for replay with cmd/replay;
+//     recording is unavailable when unset (default: none)
+//   - RECORD_ENABLED: "true" to record from startup instead of after POST /admin/recorder (default: "false")
+//   - RECORD_SAMPLE_PERCENT: Percentage of requests recorded (default: 100)
+//   - RECORD_MAX_BYTES: Bytes kept of each recorded request and response (default: 1MB)
+//   - RECORD_SPOOL_MAX_BYTES: Size RECORD_DIR may grow to before recordings are dropped (default: 100MB)
+//   - RECORD_REDACT_HEADERS: Comma-separated headers redacted in recordings besides Authorization,
+//     Proxy-Authorization, Cookie, Set-Cookie and X-API-Key (default: none)
+//   - ADMIN_TOKEN: Bearer token for the /admin/ API; the API is disabled when unset (default: none)
+//   - ADMIN_ADDR: Separate address, such as "127.0.0.1:9090", serving the /admin/ API and /metrics
+//     with their own middleware instead of the main port (default: none, served on the main port)
+//   - ADMIN_READ_TIMEOUT: READ_TIMEOUT for ADMIN_ADDR connections in seconds, 0 to inherit (default: 0)
+//   - ADMIN_CONNECTION_TIMEOUT: CONNECTION_TIMEOUT for ADMIN_ADDR connections in seconds, 0 to inherit (default: 0)
+//   - MAINTENANCE_ALLOW_IPS: Comma-separated client IPs or CIDRs served normally during maintenance (default: none)
+//   - MAINTENANCE_ALLOW_PATHS: Comma-separated path prefixes served normally during maintenance,
+//     matched by whole segments (default: "/healthz,/readyz,/admin/")
+//   - MAINTENANCE_STATE_FILE: File persisting the maintenance state across restarts (default: none)
 
 type Config struct {
-	Port              string
-	ReadTimeout       time.Duration
-	WriteTimeout      time.Duration
-	IdleTimeout       time.Duration
-	LogLevel          string
-	MaxRequestPerConn int
-	ConnectionTimeout time.Duration
+	Port                     string
+	ReadTimeout              time.Duration
+	WriteTimeout             time.Duration
+	IdleTimeout              time.Duration
+	LogLevel                 string
+	MaxRequestPerConn        int
+	ConnectionTimeout        time.Duration
+	MaxConnectionLifetime    time.Duration
+	ConnectionLifetimeJitter int
+	StaticMounts             []StaticMountConfig
+	FilesTenants             []TenantConfig
+	ProxyMounts              []ProxyMountConfig
+	ProxyDialTimeout         time.Duration
+	ProxyHeaderTimeout       time.Duration
+	ProxyBodyTimeout         time.Duration
+	FilesReadOnly            bool
+	EnableDirListing         bool
+	FilesCrossProcessLocking bool
+	FilesLockTimeout         time.Duration
+	FilesUploadSpaceMargin   int64
+	FilesLowSpaceBytes       int64
+	FilesTrashDir            string
+	FilesTrashTTL            time.Duration
+	FilesMetaEnabled         bool
+	FilesMetaMaxBytes        int
+	FilesMetaHeaders         []string
+	FilesMetaSweepInterval   time.Duration
+	FilesNegativeCacheSize   int
+	FilesNegativeCacheTTL    time.Duration
+	FilesHealthInterval      time.Duration
+	FilesHealthSentinel      string
+	BodyPolicies             map[string]BodyPolicyConfig
+	BasePath                 string
+	NormalizePaths           string
+	NormalizeHost            bool
+	DrainTimeout             time.Duration
+	ShutdownReportFile       string
+	TLSCertFile              string
+	TLSKeyFile               string
+	TLSClientCAFile          string
+	TLSClientAuth            string
+	TLSHandshakeTimeout      time.Duration
+	TLSExpiryWarning         time.Duration
+	ClientCertACL            map[string][]string
+	RouteRateLimits          []RouteRateLimitConfig
+	WarmUpTimeout            time.Duration
+	WarmUpFailFatal          bool
+	ErrorPagesDir            string
+	RateLimit                int
+	RateLimitGlobal          int
+	RateLimitWindow          time.Duration
+	PriorityLanePaths        []string
+	PriorityLaneLoopback     bool
+	PriorityLaneCapacity     int
+	PriorityLaneRate         int
+	MaxConcurrentRequests    int
+	FairQueue                bool
+	FairQueueClientInFlight  int
+	FairQueueClientDepth     int
+	FairQueueWait            time.Duration
+	FairQueueTopClients      int
+	GenerateMaxBytes         int64
+	BodyPreviewBytes         int
+	MaxBufferedBytes         int64
+	MemoryHeapLimit          int64
+	DumpRequests             bool
+	DebugCaptureRaw          bool
+	LenientParsing           bool
+	CORSAllowedOrigins       []string
+	CORSAllowedMethods       []string
+	CORSAllowedHeaders       []string
+	CORSExposedHeaders       []string
+	CORSAllowCredentials     bool
+	CORSMaxAge               time.Duration
+	MiddlewareTrace          string
+	EventLog                 string
+	EventLogHeaders          []string
+	AccessLog                string
+	AccessLogFormat          string
+	RecordDir                string
+	RecordEnabled            bool
+	RecordSamplePercent      int
+	RecordMaxBytes           int
+	RecordSpoolMaxBytes      int64
+	RecordRedactHeaders      []string
+	AdminToken               string
+	AdminAddr                string
+	AdminReadTimeout         time.Duration
+	AdminConnectionTimeout   time.Duration
+	BannedIPs                []string
+	MaxConnsPerIP            int
+	AllowedHosts             []string
+	DevMode                  bool
+	TrustedProxies           []string
+	MaintenanceAllowIPs      []string
+	MaintenanceAllowPaths    []string
+	MaintenanceStateFile     string
+	DocsEnabled              bool
+	MetricsEnabled           bool
+	BatchEnabled             bool
+	SSEEnabled               bool
+	StreamStallAfter         time.Duration
+	StreamStallTimeout       time.Duration
+	BatchMaxRequests         int
+	VersionEndpoint          bool
+	ServerHeader             bool
+	JSONFields               string
+	Compression              bool
+	CompressExcludeTypes     []string
+	AltSvc                   string
+	H2CAdvertise             bool
+	ExtraResponseHeaders     string
+	MethodMode               string
+	QueryDuplicates          string
+	QueryMaxParams           int
+	QueryMaxLength           int
+	MaxResponseSize          int64
+	StreamChunkSize          int
+	MaxResponseHeaderBytes   int
+	MaxResponseHeaders       int
+}
+
+// RouteRateLimitConfig is one ROUTE_RATE_LIMITS entry.
+type RouteRateLimitConfig struct {
+	Method      string // empty for every method
+	Pattern     string
+	RPS         float64
+	Burst       int
+	ByPrincipal bool
+}
+
+// BodyPolicyConfig overrides how requests of one method treat a body.
+//
+// Action is "allow" (read normally), "drain" (read and discard at most
+// Limit bytes) or "reject" (refuse bodies larger than Limit).
+type BodyPolicyConfig struct {
+	Action string
+	Limit  int64
+}
+
+// TenantConfig describes a single tenant of the multi-tenant files API.
+//
+// Requests to /files/{Name}/... are served from Root, and uploads are
+// rejected once the tenant's total stored bytes would exceed MaxBytes.
+// A MaxBytes of 0 disables the quota.
+// StaticMountConfig is one STATIC_MOUNTS entry.
+type StaticMountConfig struct {
+	Prefix     string
+	Dir        string
+	Listing    bool
+	ShowHidden bool
+	Index      string
+	MaxAge     time.Duration
+	Attachment bool
+	Writable   bool
+}
+
+type TenantConfig struct {
+	Name     string
+	Root     string
+	MaxBytes int64
+}
+
+// ProxyMountConfig is one PROXY_MOUNTS entry.
+type ProxyMountConfig struct {
+	Prefix   string
+	Upstream string
 }
 
 // LoadConfig loads configuration settings from environment variables or a .env file.
@@ -69,16 +402,136 @@ func LoadConfig() *Config {
 	}
 
 	cfg := &Config{
-		Port:         getEnv("PORT", "4221"),
-		ReadTimeout:  time.Duration(readTimeout) * time.Second,
-		WriteTimeout: time.Duration(writeTimeout) * time.Second,
-		IdleTimeout:  time.Duration(idleTimeout) * time.Second,
-		LogLevel:     getEnv("LOG_LEVEL", "Info"),
-	}
+		Port:                     getEnv("PORT", "4221"),
+		ReadTimeout:              time.Duration(readTimeout) * time.Second,
+		WriteTimeout:             time.Duration(writeTimeout) * time.Second,
+		IdleTimeout:              time.Duration(idleTimeout) * time.Second,
+		MaxRequestPerConn:        getEnvInt("MAX_REQUESTS_PER_CONN", 100),
+		LogLevel:                 getEnv("LOG_LEVEL", "Info"),
+		FilesTenants:             parseTenants(getEnv("FILES_TENANTS", "")),
+		StaticMounts:             parseStaticMounts(getEnv("STATIC_MOUNTS", "")),
+		ProxyMounts:              parseProxyMounts(getEnv("PROXY_MOUNTS", "")),
+		FilesTrashDir:            getEnv("FILES_TRASH_DIR", ""),
+		FilesTrashTTL:            getEnvSeconds("FILES_TRASH_TTL", 7*24*60*60),
+		FilesMetaEnabled:         strings.EqualFold(getEnv("FILES_META_ENABLED", "false"), "true"),
+		FilesMetaMaxBytes:        getEnvInt("FILES_META_MAX_BYTES", 4096),
+		FilesMetaHeaders:         parseList(getEnv("FILES_META_HEADERS", "")),
+		FilesMetaSweepInterval:   getEnvSeconds("FILES_META_SWEEP_INTERVAL", 60),
+		FilesNegativeCacheSize:   getEnvInt("FILES_NEGATIVE_CACHE_SIZE", 1024),
+		FilesNegativeCacheTTL:    getEnvSeconds("FILES_NEGATIVE_CACHE_TTL", 5),
+		FilesHealthInterval:      getEnvSeconds("FILES_HEALTH_INTERVAL", 10),
+		FilesHealthSentinel:      getEnv("FILES_HEALTH_SENTINEL", ""),
+		FilesReadOnly:            strings.EqualFold(getEnv("FILES_READ_ONLY", "false"), "true"),
+		EnableDirListing:         strings.EqualFold(getEnv("ENABLE_DIR_LISTING", "false"), "true"),
+		FilesCrossProcessLocking: strings.EqualFold(getEnv("FILES_CROSS_PROCESS_LOCKING", "false"), "true"),
+		FilesLockTimeout:         getEnvSeconds("FILES_LOCK_TIMEOUT", 5),
+		FilesUploadSpaceMargin:   int64(getEnvInt("FILES_UPLOAD_SPACE_MARGIN", 10<<20)),
+		FilesLowSpaceBytes:       int64(getEnvInt("FILES_LOW_SPACE_BYTES", 0)),
+		BodyPolicies:             parseBodyPolicies(getEnv("BODY_POLICIES", "")),
+		BasePath:                 getEnv("BASE_PATH", ""),
+		NormalizePaths:           getEnv("NORMALIZE_PATHS", "off"),
+		NormalizeHost:            strings.EqualFold(getEnv("NORMALIZE_HOST", "false"), "true"),
+
+		MaxConnectionLifetime:    getEnvSeconds("MAX_CONNECTION_LIFETIME", 0),
+		ConnectionLifetimeJitter: getEnvInt("CONNECTION_LIFETIME_JITTER", 10),
+		ShutdownReportFile:       getEnv("SHUTDOWN_REPORT_FILE", ""),
+		DrainTimeout:             getEnvSeconds("DRAIN_TIMEOUT", 10),
+
+		ProxyDialTimeout:    getEnvSeconds("PROXY_DIAL_TIMEOUT", 10),
+		ProxyHeaderTimeout:  getEnvSeconds("PROXY_HEADER_TIMEOUT", 30),
+		ProxyBodyTimeout:    getEnvSeconds("PROXY_BODY_TIMEOUT", 30),
+		TLSCertFile:         getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:          getEnv("TLS_KEY_FILE", ""),
+		TLSClientCAFile:     getEnv("TLS_CLIENT_CA_FILE", ""),
+		TLSClientAuth:       strings.ToLower(getEnv("TLS_CLIENT_AUTH", "none")),
+		TLSHandshakeTimeout: getEnvSeconds("TLS_HANDSHAKE_TIMEOUT", 10),
+		TLSExpiryWarning:    getEnvSeconds("TLS_EXPIRY_WARNING", 30*24*60*60),
+		ClientCertACL:       parseClientCertACL(getEnv("CLIENT_CERT_ACL", "")),
+		RouteRateLimits:     parseRouteRateLimits(getEnv("ROUTE_RATE_LIMITS", "")),
+
+		WarmUpTimeout:   getEnvSeconds("WARMUP_TIMEOUT", 30),
+		WarmUpFailFatal: strings.EqualFold(getEnv("WARMUP_FAILURE", "warn"), "fatal"),
+		ErrorPagesDir:   getEnv("ERROR_PAGES_DIR", ""),
+
+		RateLimit:       getEnvInt("RATE_LIMIT", 0),
+		RateLimitGlobal: getEnvInt("RATE_LIMIT_GLOBAL", 0),
+		RateLimitWindow: getEnvSeconds("RATE_LIMIT_WINDOW", 60),
+
+		PriorityLanePaths:    parseList(getEnv("PRIORITY_LANE_PATHS", "")),
+		PriorityLaneLoopback: strings.EqualFold(getEnv("PRIORITY_LANE_LOOPBACK", "false"), "true"),
+		PriorityLaneCapacity: getEnvInt("PRIORITY_LANE_CAPACITY", 4),
+		PriorityLaneRate:     getEnvInt("PRIORITY_LANE_RATE", 20),
 
-	if cfg.MaxRequestPerConn == 0 {
-		cfg.MaxRequestPerConn = 100
+		MaxConcurrentRequests:   getEnvInt("MAX_CONCURRENT_REQUESTS", 0),
+		FairQueue:               strings.EqualFold(getEnv("FAIR_QUEUE", "false"), "true"),
+		FairQueueClientInFlight: getEnvInt("FAIR_QUEUE_CLIENT_IN_FLIGHT", 2),
+		FairQueueClientDepth:    getEnvInt("FAIR_QUEUE_CLIENT_DEPTH", 8),
+		FairQueueWait:           getEnvSeconds("FAIR_QUEUE_WAIT", 5),
+		FairQueueTopClients:     getEnvInt("FAIR_QUEUE_TOP_CLIENTS", 10),
+
+		GenerateMaxBytes:     int64(getEnvInt("GENERATE_MAX_BYTES", 1<<30)),
+		BodyPreviewBytes:     getEnvInt("BODY_PREVIEW_BYTES", 4096),
+		MaxBufferedBytes:     int64(getEnvInt("MAX_BUFFERED_BYTES", 0)),
+		MemoryHeapLimit:      int64(getEnvInt("MEMORY_HEAP_LIMIT", 0)),
+		DumpRequests:         strings.EqualFold(getEnv("DUMP_REQUESTS", "false"), "true"),
+		DebugCaptureRaw:      strings.EqualFold(getEnv("DEBUG_CAPTURE_RAW", "false"), "true"),
+		LenientParsing:       strings.EqualFold(getEnv("LENIENT_PARSING", "false"), "true"),
+		DocsEnabled:          strings.EqualFold(getEnv("DOCS_ENABLED", "false"), "true"),
+		MetricsEnabled:       strings.EqualFold(getEnv("METRICS_ENABLED", "false"), "true"),
+		BatchEnabled:         strings.EqualFold(getEnv("BATCH_ENDPOINT", "false"), "true"),
+		SSEEnabled:           strings.EqualFold(getEnv("SSE_ENDPOINT", "false"), "true"),
+		StreamStallAfter:     getEnvSeconds("STREAM_STALL_AFTER", 10),
+		StreamStallTimeout:   getEnvSeconds("STREAM_STALL_TIMEOUT", 0),
+		BatchMaxRequests:     getEnvInt("BATCH_MAX_REQUESTS", 20),
+		VersionEndpoint:      strings.EqualFold(getEnv("VERSION_ENDPOINT", "false"), "true"),
+		ServerHeader:         strings.EqualFold(getEnv("SERVER_HEADER", "false"), "true"),
+		JSONFields:           strings.ToLower(getEnv("JSON_FIELDS", "off")),
+		Compression:          strings.EqualFold(getEnv("COMPRESSION", "false"), "true"),
+		CompressExcludeTypes: parseList(getEnv("COMPRESS_EXCLUDE_TYPES", "image/*,video/*,application/zip,text/event-stream")),
+		AltSvc:               getEnv("ALT_SVC", ""),
+		H2CAdvertise:         strings.EqualFold(getEnv("H2C_ADVERTISE", "false"), "true"),
+		ExtraResponseHeaders: getEnv("EXTRA_RESPONSE_HEADERS", ""),
+
+		QueryDuplicates:        getEnv("QUERY_DUPLICATES", "first"),
+		MethodMode:             getEnv("METHOD_MODE", "lenient"),
+		QueryMaxParams:         getEnvInt("QUERY_MAX_PARAMS", 100),
+		QueryMaxLength:         getEnvInt("QUERY_MAX_LENGTH", 8192),
+		MaxResponseSize:        int64(getEnvInt("MAX_RESPONSE_SIZE", 0)),
+		StreamChunkSize:        getEnvInt("STREAM_CHUNK_SIZE", 8192),
+		MaxResponseHeaderBytes: getEnvInt("MAX_RESPONSE_HEADER_BYTES", 64<<10),
+		MaxResponseHeaders:     getEnvInt("MAX_RESPONSE_HEADERS", 100),
+
+		CORSAllowedOrigins:     parseList(getEnv("CORS_ALLOWED_ORIGINS", "")),
+		CORSAllowedMethods:     parseList(getEnv("CORS_ALLOWED_METHODS", "GET,HEAD,POST,PUT,DELETE")),
+		CORSAllowedHeaders:     parseList(getEnv("CORS_ALLOWED_HEADERS", "Content-Type,Authorization")),
+		CORSExposedHeaders:     parseList(getEnv("CORS_EXPOSED_HEADERS", "")),
+		CORSAllowCredentials:   strings.EqualFold(getEnv("CORS_ALLOW_CREDENTIALS", "false"), "true"),
+		CORSMaxAge:             getEnvSeconds("CORS_MAX_AGE", 600),
+		MiddlewareTrace:        getEnv("MIDDLEWARE_TRACE", "off"),
+		EventLog:               getEnv("EVENT_LOG", ""),
+		EventLogHeaders:        parseList(getEnv("EVENT_LOG_HEADERS", "user-agent,referer,content-type,content-length")),
+		AccessLog:              getEnv("ACCESS_LOG", "stdout"),
+		AccessLogFormat:        getEnv("ACCESS_LOG_FORMAT", "plain"),
+		RecordDir:              getEnv("RECORD_DIR", ""),
+		RecordEnabled:          strings.EqualFold(getEnv("RECORD_ENABLED", "false"), "true"),
+		RecordSamplePercent:    getEnvInt("RECORD_SAMPLE_PERCENT", 100),
+		RecordMaxBytes:         getEnvInt("RECORD_MAX_BYTES", 1<<20),
+		RecordSpoolMaxBytes:    int64(getEnvInt("RECORD_SPOOL_MAX_BYTES", 100<<20)),
+		RecordRedactHeaders:    parseList(getEnv("RECORD_REDACT_HEADERS", "")),
+		AdminToken:             getEnv("ADMIN_TOKEN", ""),
+		AdminAddr:              getEnv("ADMIN_ADDR", ""),
+		AdminReadTimeout:       getEnvSeconds("ADMIN_READ_TIMEOUT", 0),
+		AdminConnectionTimeout: getEnvSeconds("ADMIN_CONNECTION_TIMEOUT", 0),
+		BannedIPs:              parseList(getEnv("BANNED_IPS", "")),
+		MaxConnsPerIP:          getEnvInt("MAX_CONNS_PER_IP", 0),
+		AllowedHosts:           parseList(getEnv("ALLOWED_HOSTS", "")),
+		DevMode:                strings.EqualFold(getEnv("DEV_MODE", "false"), "true"),
+		TrustedProxies:         parseList(getEnv("TRUSTED_PROXIES", "")),
+		MaintenanceAllowIPs:    parseList(getEnv("MAINTENANCE_ALLOW_IPS", "")),
+		MaintenanceAllowPaths:  parseList(getEnv("MAINTENANCE_ALLOW_PATHS", "")),
+		MaintenanceStateFile:   getEnv("MAINTENANCE_STATE_FILE", ""),
 	}
+
 	if cfg.ConnectionTimeout == 0 {
 		cfg.ConnectionTimeout = 2 * time.Minute
 	}
@@ -88,6 +541,17 @@ func LoadConfig() *Config {
 	return cfg
 }
 
+// ReloadConfig re-reads the .env file, letting the values it sets override
+// the environment, and loads the configuration again. Unlike LoadConfig it
+// fails if there is no .env file. A variable removed from the file keeps
+// the value it was last loaded with.
+func ReloadConfig() (*Config, error) {
+	if err := godotenv.Overload(); err != nil {
+		return nil, fmt.Errorf("failed to read .env file: %w", err)
+	}
+	return LoadConfig(), nil
+}
+
 // getEnv returns the value of the specified environment variable.
 // If the variable is not set, it returns the provided fallback value.
 //
@@ -98,3 +562,272 @@ func getEnv(key, fallBack string) string {
 	}
 	return fallBack
 }
+
+// getEnvSeconds reads an environment variable holding a whole number of
+// seconds. Missing, invalid or negative values fall back to def.
+func getEnvSeconds(key string, def int) time.Duration {
+	seconds, err := strconv.Atoi(getEnv(key, strconv.Itoa(def)))
+	if err != nil || seconds < 0 {
+		utils.Warn("Invalid %s value, using default %ds", key, def)
+		seconds = def
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// getEnvInt reads a non-negative integer from the environment, falling
+// back to def when the variable is unset or invalid.
+func getEnvInt(key string, def int) int {
+	n, err := strconv.Atoi(getEnv(key, strconv.Itoa(def)))
+	if err != nil || n < 0 {
+		utils.Warn("Invalid %s value, using default %d", key, def)
+		n = def
+	}
+	return n
+}
+
+// parseList splits a comma-separated value, dropping empty entries.
+func parseList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseTenants parses the FILES_TENANTS value into tenant definitions.
+//
+// Entries have the form "name=dir:maxBytes" and are separated by commas.
+// The quota suffix is optional; malformed entries are skipped with a warning.
+func parseTenants(raw string) []TenantConfig {
+	var tenants []TenantConfig
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, spec, ok := strings.Cut(entry, "=")
+		if !ok || name == "" || spec == "" {
+			utils.Warn("Invalid FILES_TENANTS entry %q, skipping", entry)
+			continue
+		}
+
+		tenant := TenantConfig{Name: name, Root: spec}
+		if idx := strings.LastIndex(spec, ":"); idx > 0 {
+			if maxBytes, err := strconv.ParseInt(spec[idx+1:], 10, 64); err == nil {
+				tenant.Root = spec[:idx]
+				tenant.MaxBytes = maxBytes
+			}
+		}
+		if tenant.MaxBytes < 0 {
+			utils.Warn("Negative quota for tenant %s, disabling quota", name)
+			tenant.MaxBytes = 0
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants
+}
+
+// parseStaticMounts parses the STATIC_MOUNTS value. Entries have the form
+// "prefix=dir[;option...]". Malformed entries and unknown options are
+// skipped with a warning; whether the directories exist and the prefixes
+// are distinct is checked when the server starts.
+func parseStaticMounts(raw string) []StaticMountConfig {
+	var mounts []StaticMountConfig
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		spec, options, _ := strings.Cut(entry, ";")
+		prefix, dir, ok := strings.Cut(spec, "=")
+		prefix, dir = strings.TrimSpace(prefix), strings.TrimSpace(dir)
+		if !ok || prefix == "" || dir == "" {
+			utils.Warn("Skipping malformed STATIC_MOUNTS entry: %s", entry)
+			continue
+		}
+		mount := StaticMountConfig{Prefix: prefix, Dir: dir, Index: "index.html"}
+		for _, option := range strings.Split(options, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+			switch strings.ToLower(name) {
+			case "":
+			case "listing":
+				mount.Listing = true
+			case "hidden":
+				mount.ShowHidden = true
+			case "index":
+				mount.Index = value
+			case "max-age":
+				maxAge, err := parseMaxAge(value)
+				if err != nil {
+					utils.Warn("Ignoring invalid max-age in STATIC_MOUNTS entry %s: %v", entry, err)
+					continue
+				}
+				mount.MaxAge = maxAge
+			case "attachment":
+				mount.Attachment = true
+			case "writable":
+				mount.Writable = true
+			default:
+				utils.Warn("Ignoring unknown option %q in STATIC_MOUNTS entry %s", option, entry)
+			}
+		}
+		mounts = append(mounts, mount)
+	}
+	return mounts
+}
+
+// parseMaxAge parses a cache lifetime: seconds ("3600") or a number with
+// one of the units s, m, h, d or y ("30d", "1y").
+func parseMaxAge(raw string) (time.Duration, error) {
+	units := map[byte]time.Duration{'s': time.Second, 'm': time.Minute, 'h': time.Hour, 'd': 24 * time.Hour, 'y': 365 * 24 * time.Hour}
+	raw = strings.TrimSpace(raw)
+	unit := time.Second
+	if raw != "" {
+		if u, ok := units[raw[len(raw)-1]]; ok {
+			unit, raw = u, raw[:len(raw)-1]
+		}
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a duration", raw)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// parseBodyPolicies parses the BODY_POLICIES value into per-method
+// overrides. Entries have the form "METHOD=action:limit"; the limit is
+// optional. Malformed entries are skipped with a warning.
+func parseBodyPolicies(raw string) map[string]BodyPolicyConfig {
+	policies := make(map[string]BodyPolicyConfig)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		method, spec, ok := strings.Cut(entry, "=")
+		if !ok || method == "" {
+			utils.Warn("Skipping malformed BODY_POLICIES entry: %s", entry)
+			continue
+		}
+		action, limitStr, hasLimit := strings.Cut(spec, ":")
+		policy := BodyPolicyConfig{Action: strings.ToLower(strings.TrimSpace(action))}
+		if hasLimit {
+			limit, err := strconv.ParseInt(strings.TrimSpace(limitStr), 10, 64)
+			if err != nil || limit < 0 {
+				utils.Warn("Skipping BODY_POLICIES entry with invalid limit: %s", entry)
+				continue
+			}
+			policy.Limit = limit
+		}
+		policies[strings.ToUpper(strings.TrimSpace(method))] = policy
+	}
+	return policies
+}
+
+// parseRouteRateLimits parses the ROUTE_RATE_LIMITS value. Entries have the
+// form "[METHOD ]pattern=rate:burst[:principal]"; the pattern may itself
+// contain colons, so the spec starts after the last "=". Malformed entries
+// are skipped with a warning.
+func parseRouteRateLimits(raw string) []RouteRateLimitConfig {
+	var limits []RouteRateLimitConfig
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		eq := strings.LastIndex(entry, "=")
+		if eq <= 0 {
+			utils.Warn("Skipping malformed ROUTE_RATE_LIMITS entry: %s", entry)
+			continue
+		}
+		limit := RouteRateLimitConfig{Pattern: strings.TrimSpace(entry[:eq])}
+		if method, pattern, ok := strings.Cut(limit.Pattern, " "); ok {
+			limit.Method, limit.Pattern = strings.ToUpper(method), strings.TrimSpace(pattern)
+		}
+
+		spec := strings.Split(entry[eq+1:], ":")
+		if len(spec) < 2 || len(spec) > 3 || (len(spec) == 3 && strings.TrimSpace(spec[2]) != "principal") {
+			utils.Warn("Skipping malformed ROUTE_RATE_LIMITS entry: %s", entry)
+			continue
+		}
+		rps, err := parseRate(spec[0])
+		burst, burstErr := strconv.Atoi(strings.TrimSpace(spec[1]))
+		if err != nil || burstErr != nil || rps <= 0 || burst <= 0 {
+			utils.Warn("Skipping ROUTE_RATE_LIMITS entry with invalid rate or burst: %s", entry)
+			continue
+		}
+		limit.RPS, limit.Burst, limit.ByPrincipal = rps, burst, len(spec) == 3
+		limits = append(limits, limit)
+	}
+	return limits
+}
+
+// parseRate parses a request rate, "10" or "10/s" per second, "10/m" per
+// minute or "10/h" per hour, into requests per second.
+func parseRate(raw string) (float64, error) {
+	count, unit, _ := strings.Cut(strings.TrimSpace(raw), "/")
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil {
+		return 0, err
+	}
+	switch unit {
+	case "", "s":
+		return n, nil
+	case "m":
+		return n / 60, nil
+	case "h":
+		return n / 3600, nil
+	}
+	return 0, fmt.Errorf("unknown rate unit %q", unit)
+}
+
+// parseClientCertACL parses the CLIENT_CERT_ACL value into a map from
+// certificate identity (subject CN or SAN) to allowed route prefixes.
+func parseClientCertACL(raw string) map[string][]string {
+	acl := make(map[string][]string)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		identity, prefixes, ok := strings.Cut(entry, "=")
+		if !ok || identity == "" || prefixes == "" {
+			utils.Warn("Invalid CLIENT_CERT_ACL entry %q, skipping", entry)
+			continue
+		}
+		for _, prefix := range strings.Split(prefixes, "|") {
+			if prefix = strings.TrimSpace(prefix); prefix != "" {
+				acl[identity] = append(acl[identity], prefix)
+			}
+		}
+	}
+	return acl
+}
+
+// parseProxyMounts parses the PROXY_MOUNTS value. Entries have the form
+// "prefix=upstream URL"; malformed ones are skipped with a warning. The
+// prefixes and URLs are checked when the server starts.
+func parseProxyMounts(raw string) []ProxyMountConfig {
+	var mounts []ProxyMountConfig
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, upstream, ok := strings.Cut(entry, "=")
+		prefix, upstream = strings.TrimSpace(prefix), strings.TrimSpace(upstream)
+		if !ok || prefix == "" || upstream == "" {
+			utils.Warn("Skipping malformed PROXY_MOUNTS entry: %s", entry)
+			continue
+		}
+		mounts = append(mounts, ProxyMountConfig{Prefix: prefix, Upstream: upstream})
+	}
+	return mounts
+}
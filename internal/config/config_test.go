@@ -0,0 +1,129 @@
+package config
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRouteRateLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []RouteRateLimitConfig
+	}{
+		{"empty", "", nil},
+		{"per second", "/api=100:200", []RouteRateLimitConfig{
+			{Pattern: "/api", RPS: 100, Burst: 200},
+		}},
+		{"explicit per second", "/api=10/s:10", []RouteRateLimitConfig{
+			{Pattern: "/api", RPS: 10, Burst: 10},
+		}},
+		{"method is uppercased", "post /login=6/m:5", []RouteRateLimitConfig{
+			{Method: "POST", Pattern: "/login", RPS: 0.1, Burst: 5},
+		}},
+		{"per hour", "GET /export=36/h:2", []RouteRateLimitConfig{
+			{Method: "GET", Pattern: "/export", RPS: 0.01, Burst: 2},
+		}},
+		{"principal keyed", "/user/:id=100:200:principal", []RouteRateLimitConfig{
+			{Pattern: "/user/:id", RPS: 100, Burst: 200, ByPrincipal: true},
+		}},
+		{"several with spaces", " POST /login=5/m:5 , /user/:id=100:200 ", []RouteRateLimitConfig{
+			{Method: "POST", Pattern: "/login", RPS: 5.0 / 60, Burst: 5},
+			{Pattern: "/user/:id", RPS: 100, Burst: 200},
+		}},
+		{"empty entries ignored", ",/a=1:1,,", []RouteRateLimitConfig{
+			{Pattern: "/a", RPS: 1, Burst: 1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseRouteRateLimits(tt.raw)
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseRouteRateLimits(%q) = %+v, want %+v", tt.raw, got, tt.want)
+			}
+			for i := range got {
+				// Rates per minute and hour are not exact in binary.
+				if math.Abs(got[i].RPS-tt.want[i].RPS) > 1e-9 {
+					t.Errorf("entry %d: RPS = %v, want %v", i, got[i].RPS, tt.want[i].RPS)
+				}
+				got[i].RPS, tt.want[i].RPS = 0, 0
+				if !reflect.DeepEqual(got[i], tt.want[i]) {
+					t.Errorf("entry %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseRouteRateLimitsSkipsMalformed(t *testing.T) {
+	for _, raw := range []string{
+		"/api",                 // no "="
+		"=5:5",                 // no pattern
+		"/api=5",               // no burst
+		"/api=5:5:ip",          // unknown keying
+		"/api=5:5:principal:x", // too many fields
+		"/api=fast:5",          // rate not a number
+		"/api=5/d:5",           // unknown rate unit
+		"/api=0:5",             // zero rate
+		"/api=-1:5",            // negative rate
+		"/api=5:0",             // zero burst
+		"/api=5:1.5",           // fractional burst
+	} {
+		if got := parseRouteRateLimits(raw); len(got) != 0 {
+			t.Errorf("parseRouteRateLimits(%q) = %+v, want it skipped", raw, got)
+		}
+	}
+
+	// A bad entry does not take the good ones with it.
+	got := parseRouteRateLimits("/a=1:1,/b=oops,/c=2:2")
+	if len(got) != 2 || got[0].Pattern != "/a" || got[1].Pattern != "/c" {
+		t.Errorf("parseRouteRateLimits kept %+v, want /a and /c", got)
+	}
+}
+
+func TestParseStaticMounts(t *testing.T) {
+	got := parseStaticMounts("/assets=./assets;max-age=1y, /downloads = /srv/files ; listing ; hidden ; attachment ; index=home.htm ; writable")
+	want := []StaticMountConfig{
+		{Prefix: "/assets", Dir: "./assets", Index: "index.html", MaxAge: 365 * 24 * time.Hour},
+		{Prefix: "/downloads", Dir: "/srv/files", Listing: true, ShowHidden: true, Index: "home.htm", Attachment: true, Writable: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseStaticMounts = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseStaticMountsSkipsMalformed(t *testing.T) {
+	// Entries without a prefix or directory are dropped; bad options are
+	// dropped on their own, keeping the mount.
+	got := parseStaticMounts("/nodir=,=./dir,/plain,/a=./a;max-age=soon;colour=blue;LISTING,/b=./b;index=")
+	want := []StaticMountConfig{
+		{Prefix: "/a", Dir: "./a", Index: "index.html", Listing: true},
+		{Prefix: "/b", Dir: "./b", Index: ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseStaticMounts = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseMaxAge(t *testing.T) {
+	for raw, want := range map[string]time.Duration{
+		"3600": time.Hour,
+		" 90s": 90 * time.Second,
+		"15m":  15 * time.Minute,
+		"2h":   2 * time.Hour,
+		"30d":  30 * 24 * time.Hour,
+		"1y":   365 * 24 * time.Hour,
+		"0":    0,
+	} {
+		if got, err := parseMaxAge(raw); err != nil || got != want {
+			t.Errorf("parseMaxAge(%q) = %v, %v; want %v", raw, got, err, want)
+		}
+	}
+	for _, raw := range []string{"", "y", "-5", "1.5h", "1w", "soon"} {
+		if got, err := parseMaxAge(raw); err == nil {
+			t.Errorf("parseMaxAge(%q) = %v, want an error", raw, got)
+		}
+	}
+}
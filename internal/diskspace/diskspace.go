@@ -0,0 +1,32 @@
+// Package diskspace reports how much space is left on the filesystem
+// holding a path: statfs on Unix and GetDiskFreeSpaceEx on Windows.
+//
+//	usage, err := diskspace.Stat("./public")
+//	if err == nil && usage.Free < 1<<30 {
+//	    log.Printf("less than 1GB left")
+//	}
+package diskspace
+
+import "errors"
+
+// ErrUnsupported is returned on platforms where free space cannot be read.
+var ErrUnsupported = errors.New("diskspace: not supported on this platform")
+
+// Usage describes a filesystem's size and free space, in bytes.
+type Usage struct {
+	Total uint64
+	// Free is the space available to the calling process, which excludes
+	// blocks reserved for the superuser.
+	Free uint64
+}
+
+// Stat returns the usage of the filesystem holding path.
+func Stat(path string) (Usage, error) {
+	return stat(path)
+}
+
+// IsFull reports whether err is the error a write returns when the
+// filesystem has run out of space (ENOSPC, or ERROR_DISK_FULL on Windows).
+func IsFull(err error) bool {
+	return err != nil && isFull(err)
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !windows
+
+package diskspace
+
+import (
+	"errors"
+	"syscall"
+)
+
+func stat(path string) (Usage, error) {
+	return Usage{}, ErrUnsupported
+}
+
+func isFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
@@ -0,0 +1,92 @@
+// Package filelock provides advisory, exclusive locks on files, shared
+// between processes: flock on Unix and LockFileEx on Windows. Processes
+// that do not take the lock are not stopped from touching the file.
+//
+// A lock is held on a lock file of its own, created on Acquire and removed
+// on Release, so the file it guards can be replaced by a rename while
+// locked:
+//
+//	lock, err := filelock.Acquire(dir+"/.report.lock", 5*time.Second)
+//	if err != nil {
+//	    return err
+//	}
+//	defer lock.Release()
+package filelock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrTimeout is returned by Acquire when the lock stayed held by someone
+// else for the whole wait.
+var ErrTimeout = errors.New("filelock: timed out waiting for lock")
+
+// ErrUnsupported is returned on platforms without file locking.
+var ErrUnsupported = errors.New("filelock: not supported on this platform")
+
+// pollInterval is how often Acquire retries a held lock.
+const pollInterval = 10 * time.Millisecond
+
+// Lock is a held lock, see Acquire.
+type Lock struct {
+	f    *os.File
+	path string
+}
+
+// Acquire creates the lock file at path if needed and locks it, waiting up
+// to timeout for a holder in this or another process to release it.
+// Locks are not reentrant: a second Acquire of a path waits even within
+// the same process, so callers serialize in-process users themselves.
+func Acquire(path string, timeout time.Duration) (*Lock, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("filelock: %w", err)
+		}
+		locked, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("filelock: %w", err)
+		}
+		if locked {
+			// A holder removes the file before unlocking it, so a lock won
+			// on a file that is no longer at path guards nothing: retry on
+			// the current one.
+			if current(f, path) {
+				return &Lock{f: f, path: path}, nil
+			}
+			unlock(f)
+		}
+		f.Close()
+		if !time.Now().Before(deadline) {
+			return nil, ErrTimeout
+		}
+		time.Sleep(min(pollInterval, time.Until(deadline)))
+	}
+}
+
+// Release removes the lock file and unlocks it. Where an open file cannot
+// be removed, as on Windows while another process waits on it, the file is
+// left for a later holder to remove.
+func (l *Lock) Release() error {
+	os.Remove(l.path)
+	err := unlock(l.f)
+	if closeErr := l.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// current reports whether f is still the file at path.
+func current(f *os.File, path string) bool {
+	held, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	onDisk, err := os.Stat(path)
+	return err == nil && os.SameFile(held, onDisk)
+}
@@ -0,0 +1,13 @@
+//go:build !unix && !windows
+
+package filelock
+
+import "os"
+
+func tryLock(f *os.File) (bool, error) {
+	return false, ErrUnsupported
+}
+
+func unlock(f *os.File) error {
+	return ErrUnsupported
+}
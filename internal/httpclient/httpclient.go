@@ -0,0 +1,582 @@
+// Package httpclient is a minimal HTTP/1.1 client for the server's own
+// outbound requests, such as proxying, health probes and webhooks. It keeps
+// connections alive in a pool per host, so repeated requests to one
+// upstream do not pay for a TCP (and TLS) handshake each time:
+//
+//	client := httpclient.New(httpclient.Options{MaxIdlePerHost: 4})
+//	defer client.CloseIdleConnections()
+//	resp, err := client.Do(ctx, &httpclient.Request{Method: "GET", URL: "http://backend:8080/healthz"})
+//	if err == nil && resp.Status == 200 { ... }
+//
+// Do buffers response bodies in full, up to Options.MaxResponseBytes, for
+// small control-plane exchanges. DoStream leaves the body on the
+// connection for the caller to read as it arrives, as proxying does.
+package httpclient
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/clock"
+)
+
+// Defaults applied to zero Options fields.
+const (
+	DefaultMaxIdlePerHost   = 2
+	DefaultIdleTimeout      = 90 * time.Second
+	DefaultDialTimeout      = 10 * time.Second
+	DefaultMaxResponseBytes = 32 << 20
+)
+
+// Options configure a Client.
+type Options struct {
+	// MaxIdlePerHost caps the idle connections kept per host; the oldest
+	// is closed when another one is returned to a full pool. A negative
+	// value turns keep-alive off, sending "Connection: close".
+	MaxIdlePerHost int
+
+	// IdleTimeout is how long an idle connection is kept before it is
+	// closed rather than reused.
+	IdleTimeout time.Duration
+
+	// DialTimeout bounds connecting, including the TLS handshake.
+	DialTimeout time.Duration
+
+	// HeaderTimeout bounds the wait for the response headers once the
+	// request is sent, and BodyIdleTimeout the wait for each read of the
+	// response body, so a slow but steady body is not cut off. Zero
+	// leaves the phase bounded only by the context. Exceeding either, or
+	// DialTimeout, fails the request with a *TimeoutError.
+	HeaderTimeout   time.Duration
+	BodyIdleTimeout time.Duration
+
+	// MaxResponseBytes caps the body of a response; larger ones fail
+	// with ErrResponseTooLarge.
+	MaxResponseBytes int64
+
+	// TLSConfig is used for https URLs. Nil means the default
+	// configuration; ServerName is taken from the URL unless set.
+	TLSConfig *tls.Config
+
+	// Clock measures how long connections have been idle. Nil means
+	// clock.Real.
+	Clock clock.Clock
+}
+
+// Request is an outbound request.
+type Request struct {
+	Method string
+	// URL is absolute, with an http or https scheme.
+	URL string
+	// Header holds extra request headers. Host defaults to the URL's
+	// host; Content-Length is computed from Body and may not be set.
+	Header map[string]string
+	Body   []byte
+}
+
+// Response is a response read in full by Do, or up to its body by
+// DoStream.
+type Response struct {
+	Status int
+	Reason string
+	// Header holds the response headers keyed by lower-case name; a
+	// header repeated on the wire keeps its values joined by ", ".
+	Header map[string]string
+	Body   []byte
+
+	// BodyStream reads the body from the connection, for a response
+	// returned by DoStream; it is nil otherwise. It fails with a
+	// *TimeoutError for the body phase, ErrResponseTooLarge past
+	// MaxResponseBytes, or the context's error once it is done. Close
+	// must be called, and is safe to call from another goroutine: the
+	// connection returns to the pool if the body was read to its end and
+	// is closed otherwise.
+	BodyStream io.ReadCloser
+}
+
+// Get returns the value of the named header, case-insensitively.
+func (r *Response) Get(name string) string {
+	return r.Header[strings.ToLower(name)]
+}
+
+// Client sends requests over pooled keep-alive connections. It is safe
+// for concurrent use; concurrent requests to one host use separate
+// connections.
+type Client struct {
+	opts Options
+
+	mu   sync.Mutex
+	idle map[string][]*conn // by host key, most recently used last
+}
+
+// Phases of an exchange, as named by TimeoutError.
+const (
+	PhaseDial    = "dial"
+	PhaseHeaders = "headers"
+	PhaseBody    = "body"
+)
+
+// TimeoutError reports that the upstream took longer than the client's
+// Options allow in one phase of an exchange. Proxies answer it with 504
+// Gateway Timeout, naming the phase.
+type TimeoutError struct {
+	Phase string        // PhaseDial, PhaseHeaders or PhaseBody
+	Limit time.Duration // the timeout that expired
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("upstream %s timeout after %v", e.Phase, e.Limit)
+}
+
+// Timeout reports true, so the error counts as a timeout as net errors do.
+func (e *TimeoutError) Timeout() bool { return true }
+
+// conn is a connection to one host with its read buffer, which must stay
+// with it across requests.
+type conn struct {
+	net.Conn
+	reader    *bufio.Reader
+	key       string
+	idleSince time.Time
+
+	// The deadlines of the exchange in progress, applied by Read; see
+	// startPhase. mu guards them against cancellation, which sets a past
+	// deadline that no later Read may undo.
+	mu            sync.Mutex
+	cancelled     bool
+	ctxDeadline   time.Time
+	phase         string
+	phaseDeadline time.Time     // fixed deadline of the headers phase
+	idleLimit     time.Duration // per-read limit of the body phase
+}
+
+// Read reads from the connection under the deadline of the current
+// phase, or of the exchange's context if that comes first.
+func (cn *conn) Read(p []byte) (int, error) {
+	cn.mu.Lock()
+	if !cn.cancelled {
+		deadline := cn.phaseDeadline
+		if cn.idleLimit > 0 {
+			deadline = time.Now().Add(cn.idleLimit)
+		}
+		if deadline.IsZero() || !cn.ctxDeadline.IsZero() && cn.ctxDeadline.Before(deadline) {
+			deadline = cn.ctxDeadline
+		}
+		cn.Conn.SetReadDeadline(deadline)
+	}
+	cn.mu.Unlock()
+	return cn.Conn.Read(p)
+}
+
+// startPhase moves the exchange on cn to phase, bounded by limit: from
+// now on for the headers, for every read for the body.
+func (cn *conn) startPhase(phase string, limit time.Duration) {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	cn.phase, cn.phaseDeadline, cn.idleLimit = phase, time.Time{}, 0
+	switch {
+	case limit <= 0:
+	case phase == PhaseBody:
+		cn.idleLimit = limit
+	default:
+		cn.phaseDeadline = time.Now().Add(limit)
+	}
+}
+
+// cancel makes every pending and future read and write on cn fail at once.
+func (cn *conn) cancel() {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	cn.cancelled = true
+	cn.Conn.SetDeadline(time.Unix(1, 0))
+}
+
+// New creates a Client with opts, filling in defaults for zero fields.
+func New(opts Options) *Client {
+	if opts.MaxIdlePerHost == 0 {
+		opts.MaxIdlePerHost = DefaultMaxIdlePerHost
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = DefaultIdleTimeout
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = DefaultDialTimeout
+	}
+	if opts.MaxResponseBytes <= 0 {
+		opts.MaxResponseBytes = DefaultMaxResponseBytes
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real
+	}
+	return &Client{opts: opts, idle: make(map[string][]*conn)}
+}
+
+// Do sends req and reads its response. ctx bounds the whole exchange,
+// including waiting for a connection.
+//
+// A request sent on a pooled connection that the upstream closed while it
+// sat idle fails without a response; an idempotent request (GET, HEAD,
+// OPTIONS, PUT, DELETE) is then retried once on a new connection.
+func (c *Client) Do(ctx context.Context, req *Request) (*Response, error) {
+	resp, err := c.DoStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.BodyStream)
+	resp.BodyStream.Close()
+	resp.BodyStream = nil
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL, err)
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+// DoStream is Do, but returns once the response headers have been read,
+// with the body left to read through Response.BodyStream. ctx keeps
+// bounding the exchange while the body is read.
+func (c *Client) DoStream(ctx context.Context, req *Request) (*Response, error) {
+	target, err := parseTarget(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	head, err := writeRequestHead(req, target, c.opts.MaxIdlePerHost < 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		cn, reused, err := c.getConn(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.roundTrip(ctx, cn, head, req)
+		if err != nil {
+			cn.Close()
+			if reused && attempt == 0 && errors.Is(err, errNoResponse) && idempotent(req.Method) && ctx.Err() == nil {
+				continue
+			}
+			return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL, err)
+		}
+		return resp, nil
+	}
+}
+
+// errNoResponse marks a failure before any byte of the response arrived,
+// which on a reused connection usually means the upstream had closed it.
+var errNoResponse = errors.New("connection closed before a response")
+
+// roundTrip writes the request on cn and reads the response head. The body
+// is left for the returned response's BodyStream, which owns cn from then
+// on.
+func (c *Client) roundTrip(ctx context.Context, cn *conn, head []byte, req *Request) (*Response, error) {
+	deadline, _ := ctx.Deadline()
+	cn.mu.Lock()
+	cn.cancelled, cn.ctxDeadline = false, deadline
+	cn.mu.Unlock()
+	cn.SetWriteDeadline(deadline)
+	// Cancelling ctx unblocks the reads and writes below, and those of the
+	// body.
+	stop := context.AfterFunc(ctx, cn.cancel)
+
+	if _, err := cn.Write(append(head, req.Body...)); err != nil {
+		stop()
+		return nil, fmt.Errorf("%w: %w", errNoResponse, err)
+	}
+	cn.startPhase(PhaseHeaders, c.opts.HeaderTimeout)
+	if _, err := cn.reader.Peek(1); err != nil {
+		stop()
+		if err := c.timeoutError(ctx, cn, err); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %w", errNoResponse, err)
+	}
+	resp, keepAlive, err := readResponseHead(cn.reader, func() {
+		cn.startPhase(PhaseBody, c.opts.BodyIdleTimeout)
+	})
+	if err == nil {
+		var delimited bool
+		var body io.Reader
+		body, delimited, err = newBodyReader(cn.reader, req.Method, resp, c.opts.MaxResponseBytes)
+		if err == nil {
+			resp.BodyStream = &responseBody{
+				r:         body,
+				client:    c,
+				ctx:       ctx,
+				cn:        cn,
+				keepAlive: keepAlive && delimited && c.opts.MaxIdlePerHost >= 0,
+				stop:      stop,
+			}
+			return resp, nil
+		}
+	}
+	stop()
+	if err := c.timeoutError(ctx, cn, err); err != nil {
+		return nil, err
+	}
+	return nil, err
+}
+
+// responseBody is a Response.BodyStream.
+type responseBody struct {
+	r         io.Reader
+	client    *Client
+	ctx       context.Context
+	cn        *conn
+	keepAlive bool        // cn may carry another request once the body is read
+	stop      func() bool // stops cancelling cn with the context
+
+	mu     sync.Mutex
+	err    error // ended the body: io.EOF once it was read to its end
+	closed bool
+}
+
+// errBodyClosed is returned by reads of a closed BodyStream.
+var errBodyClosed = errors.New("response body closed")
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	err := b.err
+	b.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	n, err := b.r.Read(p)
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			if timeout := b.client.timeoutError(b.ctx, b.cn, err); timeout != nil {
+				err = timeout
+			}
+		}
+		b.mu.Lock()
+		if b.err == nil {
+			b.err = err
+		}
+		b.mu.Unlock()
+	}
+	return n, err
+}
+
+// Close returns the connection to the pool if the body was read to its
+// end, and closes it otherwise.
+func (b *responseBody) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	complete := b.err == io.EOF
+	if b.err == nil {
+		b.err = errBodyClosed
+	}
+	b.mu.Unlock()
+
+	// Bytes past the response mean the framing is off, so the connection
+	// cannot be trusted for another request.
+	if complete && b.keepAlive && b.cn.reader.Buffered() == 0 && b.stop() {
+		b.client.putConn(b.cn)
+		return nil
+	}
+	b.stop()
+	return b.cn.Close()
+}
+
+// timeoutError returns the error to report for err, from a read in the
+// current phase of the exchange on cn: the context's error once it is
+// done or past its deadline, a *TimeoutError if the phase's own deadline
+// expired, or nil if err is not a timeout.
+func (c *Client) timeoutError(ctx context.Context, cn *conn, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		return nil
+	}
+	cn.mu.Lock()
+	phase, ctxDeadline := cn.phase, cn.ctxDeadline
+	cn.mu.Unlock()
+	if !ctxDeadline.IsZero() && !time.Now().Before(ctxDeadline) {
+		return context.DeadlineExceeded
+	}
+	limit := c.opts.HeaderTimeout
+	if phase == PhaseBody {
+		limit = c.opts.BodyIdleTimeout
+	}
+	return &TimeoutError{Phase: phase, Limit: limit}
+}
+
+// getConn returns an idle connection to target, or dials a new one. It
+// reports whether the connection was reused.
+func (c *Client) getConn(ctx context.Context, target *target) (*conn, bool, error) {
+	if cn := c.takeIdle(target.key); cn != nil {
+		return cn, true, nil
+	}
+	cn, err := c.dial(ctx, target)
+	return cn, false, err
+}
+
+// takeIdle removes and returns the most recently used idle connection for
+// key, closing any that have been idle for IdleTimeout.
+func (c *Client) takeIdle(key string) *conn {
+	now := c.opts.Clock.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pool := c.idle[key]
+	fresh := pool[:0]
+	for _, cn := range pool {
+		if now.Sub(cn.idleSince) >= c.opts.IdleTimeout {
+			cn.Close()
+			continue
+		}
+		fresh = append(fresh, cn)
+	}
+	if len(fresh) == 0 {
+		delete(c.idle, key)
+		return nil
+	}
+	cn := fresh[len(fresh)-1]
+	c.idle[key] = fresh[:len(fresh)-1]
+	return cn
+}
+
+// putConn returns cn to its host's pool, closing the oldest idle
+// connection if the pool is full.
+func (c *Client) putConn(cn *conn) {
+	cn.idleSince = c.opts.Clock.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pool := append(c.idle[cn.key], cn)
+	if len(pool) > c.opts.MaxIdlePerHost {
+		pool[0].Close()
+		pool = pool[1:]
+	}
+	c.idle[cn.key] = pool
+}
+
+// CloseIdleConnections closes every pooled connection. Connections in use
+// are closed once their response has been read instead of being pooled.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, pool := range c.idle {
+		for _, cn := range pool {
+			cn.Close()
+		}
+		delete(c.idle, key)
+	}
+}
+
+// IdleConnections returns the number of pooled connections to the host of
+// rawURL.
+func (c *Client) IdleConnections(rawURL string) int {
+	target, err := parseTarget(rawURL)
+	if err != nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.idle[target.key])
+}
+
+// dial connects to target, completing the TLS handshake for https. Taking
+// longer than DialTimeout fails with a *TimeoutError.
+func (c *Client) dial(ctx context.Context, target *target) (*conn, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, c.opts.DialTimeout)
+	defer cancel()
+	nc, err := c.connect(dialCtx, target)
+	if err != nil {
+		if ctx.Err() == nil && dialCtx.Err() != nil {
+			return nil, &TimeoutError{Phase: PhaseDial, Limit: c.opts.DialTimeout}
+		}
+		return nil, err
+	}
+	cn := &conn{Conn: nc, key: target.key}
+	cn.reader = bufio.NewReader(cn)
+	return cn, nil
+}
+
+// connect opens the connection to target for dial.
+func (c *Client) connect(ctx context.Context, target *target) (net.Conn, error) {
+	dialer := net.Dialer{}
+	raw, err := dialer.DialContext(ctx, "tcp", target.addr)
+	if err != nil {
+		return nil, err
+	}
+	if !target.tls {
+		return raw, nil
+	}
+	cfg := &tls.Config{}
+	if c.opts.TLSConfig != nil {
+		cfg = c.opts.TLSConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = target.hostname
+	}
+	tlsConn := tls.Client(raw, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("TLS handshake with %s: %w", target.addr, err)
+	}
+	return tlsConn, nil
+}
+
+// target is a parsed request URL.
+type target struct {
+	key      string // scheme and address, the pool key
+	addr     string // host:port to dial
+	hostname string // for TLS ServerName
+	host     string // Host header
+	path     string // request target, with query
+	tls      bool
+}
+
+func parseTarget(rawURL string) (*target, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	port := ""
+	switch u.Scheme {
+	case "http":
+		port = "80"
+	case "https":
+		port = "443"
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme %q in %s", u.Scheme, rawURL)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("no host in %s", rawURL)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+	return &target{
+		key:      u.Scheme + "://" + addr,
+		addr:     addr,
+		hostname: u.Hostname(),
+		host:     u.Host,
+		path:     u.RequestURI(),
+		tls:      u.Scheme == "https",
+	}, nil
+}
+
+// idempotent reports whether a request with method may be sent twice.
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,376 @@
+package httpclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/clock"
+)
+
+// upstream is a stub HTTP/1.1 server counting the connections it accepts.
+type upstream struct {
+	listener net.Listener
+	accepted atomic.Int32
+	// hangups receives once for every connection that ended, closed by
+	// either side.
+	hangups chan struct{}
+}
+
+// startUpstream serves every request with respond, which returns the raw
+// response and whether to close the connection after sending it.
+func startUpstream(t *testing.T, respond func(path string) (string, bool)) *upstream {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &upstream{listener: listener, hangups: make(chan struct{}, 16)}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			u.accepted.Add(1)
+			go u.serve(conn, respond)
+		}
+	}()
+	return u
+}
+
+func (u *upstream) serve(conn net.Conn, respond func(path string) (string, bool)) {
+	defer func() {
+		conn.Close()
+		u.hangups <- struct{}{}
+	}()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		length := 0
+		for {
+			header, err := r.ReadString('\n')
+			if err != nil || header == "\r\n" {
+				break
+			}
+			name, value, _ := strings.Cut(header, ":")
+			if strings.EqualFold(name, "Content-Length") {
+				length, _ = strconv.Atoi(strings.TrimSpace(value))
+			}
+		}
+		r.Discard(length)
+		resp, hangUp := respond(fields[1])
+		conn.Write([]byte(resp))
+		if hangUp {
+			return
+		}
+	}
+}
+
+func (u *upstream) url(path string) string {
+	return "http://" + u.listener.Addr().String() + path
+}
+
+func ok(body string) string {
+	return "HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+}
+
+func get(t *testing.T, c *Client, url string) *Response {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := c.Do(ctx, &Request{Method: "GET", URL: url})
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	return resp
+}
+
+func TestClientReusesConnection(t *testing.T) {
+	u := startUpstream(t, func(path string) (string, bool) { return ok(path), false })
+	c := New(Options{})
+	defer c.CloseIdleConnections()
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		if resp := get(t, c, u.url(path)); resp.Status != 200 || string(resp.Body) != path {
+			t.Fatalf("GET %s = %d %q", path, resp.Status, resp.Body)
+		}
+	}
+	if n := u.accepted.Load(); n != 1 {
+		t.Errorf("upstream accepted %d connections, want 1", n)
+	}
+	if n := c.IdleConnections(u.url("/")); n != 1 {
+		t.Errorf("idle connections = %d, want 1", n)
+	}
+}
+
+func TestClientEvictsIdleConnections(t *testing.T) {
+	u := startUpstream(t, func(path string) (string, bool) { return ok("ok"), false })
+	fake := clock.NewManual(time.Unix(0, 0))
+	c := New(Options{IdleTimeout: 30 * time.Second, Clock: fake})
+	defer c.CloseIdleConnections()
+
+	get(t, c, u.url("/"))
+	fake.Advance(29 * time.Second)
+	get(t, c, u.url("/"))
+	if n := u.accepted.Load(); n != 1 {
+		t.Fatalf("upstream accepted %d connections before the idle timeout, want 1", n)
+	}
+
+	fake.Advance(30 * time.Second)
+	get(t, c, u.url("/"))
+	if n := u.accepted.Load(); n != 2 {
+		t.Errorf("upstream accepted %d connections after the idle timeout, want 2", n)
+	}
+	select {
+	case <-u.hangups:
+	case <-time.After(5 * time.Second):
+		t.Error("the expired connection was not closed")
+	}
+}
+
+func TestClientHonorsConnectionClose(t *testing.T) {
+	u := startUpstream(t, func(path string) (string, bool) {
+		return "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 2\r\n\r\nok", true
+	})
+	c := New(Options{})
+
+	get(t, c, u.url("/"))
+	if n := c.IdleConnections(u.url("/")); n != 0 {
+		t.Errorf("idle connections after Connection: close = %d, want 0", n)
+	}
+	get(t, c, u.url("/"))
+	if n := u.accepted.Load(); n != 2 {
+		t.Errorf("upstream accepted %d connections, want 2", n)
+	}
+}
+
+func TestClientRetriesStaleConnection(t *testing.T) {
+	// The upstream drops every connection after one response without
+	// announcing it, as servers do when their keep-alive timeout expires.
+	u := startUpstream(t, func(path string) (string, bool) { return ok("ok"), true })
+	c := New(Options{})
+	defer c.CloseIdleConnections()
+
+	get(t, c, u.url("/"))
+	<-u.hangups
+	if resp := get(t, c, u.url("/")); string(resp.Body) != "ok" {
+		t.Errorf("retried GET body = %q, want %q", resp.Body, "ok")
+	}
+	if n := u.accepted.Load(); n != 2 {
+		t.Errorf("upstream accepted %d connections, want 2", n)
+	}
+}
+
+func TestClientDoesNotRetryPost(t *testing.T) {
+	u := startUpstream(t, func(path string) (string, bool) { return ok("ok"), true })
+	c := New(Options{})
+	defer c.CloseIdleConnections()
+
+	get(t, c, u.url("/"))
+	<-u.hangups
+	_, err := c.Do(context.Background(), &Request{Method: "POST", URL: u.url("/"), Body: []byte("x")})
+	if err == nil {
+		t.Fatal("POST on a stale connection succeeded, want an error rather than a resend")
+	}
+}
+
+func TestClientReadsChunkedBody(t *testing.T) {
+	u := startUpstream(t, func(path string) (string, bool) {
+		return "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\nX-Trailer: 1\r\n\r\n", false
+	})
+	c := New(Options{})
+	defer c.CloseIdleConnections()
+
+	for range 2 {
+		if resp := get(t, c, u.url("/")); string(resp.Body) != "hello world" {
+			t.Fatalf("body = %q, want %q", resp.Body, "hello world")
+		}
+	}
+	if n := u.accepted.Load(); n != 1 {
+		t.Errorf("upstream accepted %d connections, want 1", n)
+	}
+}
+
+func TestClientDoStream(t *testing.T) {
+	u := startUpstream(t, func(path string) (string, bool) {
+		if path == "/chunked" {
+			return "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n", false
+		}
+		return ok("hello world"), false
+	})
+	c := New(Options{})
+	defer c.CloseIdleConnections()
+	stream := func(path string) *Response {
+		t.Helper()
+		resp, err := c.DoStream(context.Background(), &Request{Method: "GET", URL: u.url(path)})
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		if resp.Body != nil {
+			t.Errorf("GET %s: DoStream buffered %q", path, resp.Body)
+		}
+		return resp
+	}
+
+	for _, path := range []string{"/sized", "/chunked"} {
+		resp := stream(path)
+		body, err := io.ReadAll(resp.BodyStream)
+		if err != nil || string(body) != "hello world" {
+			t.Errorf("GET %s: body %q, %v", path, body, err)
+		}
+		resp.BodyStream.Close()
+		if n := c.IdleConnections(u.url("/")); n != 1 {
+			t.Errorf("GET %s read to the end: idle connections = %d, want it pooled", path, n)
+		}
+	}
+
+	resp := stream("/sized")
+	if _, err := io.ReadFull(resp.BodyStream, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	resp.BodyStream.Close()
+	if n := c.IdleConnections(u.url("/")); n != 0 {
+		t.Errorf("body closed half read: idle connections = %d, want the connection closed", n)
+	}
+	if _, err := resp.BodyStream.Read(make([]byte, 1)); err == nil {
+		t.Error("read after Close succeeded")
+	}
+}
+
+func TestClientResponseTooLarge(t *testing.T) {
+	u := startUpstream(t, func(path string) (string, bool) { return ok("0123456789"), false })
+	c := New(Options{MaxResponseBytes: 4})
+
+	_, err := c.Do(context.Background(), &Request{Method: "GET", URL: u.url("/")})
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("err = %v, want %v", err, ErrResponseTooLarge)
+	}
+}
+
+func TestClientRejectsHeaderInjection(t *testing.T) {
+	c := New(Options{})
+	_, err := c.Do(context.Background(), &Request{
+		Method: "GET",
+		URL:    "http://127.0.0.1:1/",
+		Header: map[string]string{"X-Test": "a\r\nInjected: yes"},
+	})
+	if err == nil {
+		t.Fatal("header with CRLF accepted")
+	}
+}
+
+// slowUpstream accepts connections on a loopback port, reads one request
+// head from each and answers it with parts, pausing gap before each one.
+// It then holds the connection open, silent, until the test ends.
+func slowUpstream(t *testing.T, gap time.Duration, parts ...string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+		listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == "\r\n" {
+						break
+					}
+				}
+				for _, part := range parts {
+					select {
+					case <-time.After(gap):
+					case <-done:
+						return
+					}
+					conn.Write([]byte(part))
+				}
+				<-done
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestClientPhaseTimeouts(t *testing.T) {
+	c := New(Options{
+		DialTimeout:     100 * time.Millisecond,
+		HeaderTimeout:   100 * time.Millisecond,
+		BodyIdleTimeout: 100 * time.Millisecond,
+	})
+	defer c.CloseIdleConnections()
+
+	for _, tt := range []struct {
+		name, url string
+		phase     string
+	}{
+		// The upstream accepts the connection but never completes the
+		// TLS handshake, so the dial stalls.
+		{"dial", "https://" + slowUpstream(t, 0) + "/", PhaseDial},
+		{"headers", "http://" + slowUpstream(t, 0) + "/", PhaseHeaders},
+		{"headers half sent", "http://" + slowUpstream(t, 0, "HTTP/1.1 200 OK\r\nContent-") + "/", PhaseHeaders},
+		{"body", "http://" + slowUpstream(t, 0, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello") + "/", PhaseBody},
+		{"chunked body", "http://" + slowUpstream(t, 0, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n") + "/", PhaseBody},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := c.Do(ctx, &Request{Method: "GET", URL: tt.url})
+		cancel()
+		var timeout *TimeoutError
+		if !errors.As(err, &timeout) || timeout.Phase != tt.phase {
+			t.Errorf("%s: err = %v, want a %s timeout", tt.name, err, tt.phase)
+		}
+	}
+}
+
+func TestClientBodyTimeoutIsPerRead(t *testing.T) {
+	// The body takes longer than BodyIdleTimeout in all, but never pauses
+	// that long.
+	addr := slowUpstream(t, 40*time.Millisecond,
+		"HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n", "12", "34", "56", "78", "90")
+	c := New(Options{HeaderTimeout: time.Second, BodyIdleTimeout: 150 * time.Millisecond})
+	defer c.CloseIdleConnections()
+
+	if resp := get(t, c, "http://"+addr+"/"); string(resp.Body) != "1234567890" {
+		t.Errorf("body = %q, want 1234567890", resp.Body)
+	}
+}
+
+func TestClientContextDeadlineIsNotAPhaseTimeout(t *testing.T) {
+	addr := slowUpstream(t, 0)
+	c := New(Options{HeaderTimeout: 5 * time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := c.Do(ctx, &Request{Method: "GET", URL: "http://" + addr + "/"})
+	var timeout *TimeoutError
+	if errors.As(err, &timeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the context's deadline", err)
+	}
+}
@@ -0,0 +1,348 @@
+package httpclient
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrResponseTooLarge is returned for a response whose body or header
+// block exceeds the client's limits.
+var ErrResponseTooLarge = errors.New("response too large")
+
+// maxHeaderBytes caps the status line and headers of a response.
+const maxHeaderBytes = 64 << 10
+
+// writeRequestHead returns the request line and headers of req, ending
+// with the blank line. With close set it asks the upstream to close the
+// connection after responding.
+func writeRequestHead(req *Request, target *target, close bool) ([]byte, error) {
+	method := req.Method
+	if method == "" {
+		method = "GET"
+	}
+	if !isToken(method) {
+		return nil, fmt.Errorf("invalid method %q", method)
+	}
+	host := target.host
+	names := make([]string, 0, len(req.Header))
+	for name, value := range req.Header {
+		if !isToken(name) || strings.ContainsAny(value, "\r\n\x00") {
+			return nil, fmt.Errorf("invalid header %q", name)
+		}
+		switch strings.ToLower(name) {
+		case "host":
+			host = value
+			continue
+		case "content-length", "transfer-encoding":
+			return nil, fmt.Errorf("header %s is set by the client", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\nHost: %s\r\n", method, target.path, host)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s: %s\r\n", name, req.Header[name])
+	}
+	if len(req.Body) > 0 || method == "POST" || method == "PUT" || method == "PATCH" {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n", len(req.Body))
+	}
+	if close {
+		b.WriteString("Connection: close\r\n")
+	}
+	b.WriteString("\r\n")
+	return b.Bytes(), nil
+}
+
+// readResponseHead reads the head of one response to a request with
+// method from r, skipping interim 1xx responses, and calls onHead, if set,
+// once the final response's headers are read. It reports whether the
+// connection may carry another request as far as the head tells, which
+// the upstream rules out with "Connection: close" or by speaking HTTP/1.0
+// without keep-alive.
+func readResponseHead(r *bufio.Reader, onHead func()) (*Response, bool, error) {
+	for {
+		resp, version, err := readHead(r)
+		if err != nil {
+			return nil, false, err
+		}
+		if resp.Status >= 100 && resp.Status < 200 && resp.Status != 101 {
+			continue
+		}
+		if onHead != nil {
+			onHead()
+		}
+
+		connection := strings.ToLower(resp.Header["connection"])
+		keepAlive := !hasToken(connection, "close")
+		if version == "HTTP/1.0" {
+			keepAlive = hasToken(connection, "keep-alive")
+		}
+		return resp, keepAlive, nil
+	}
+}
+
+// newBodyReader returns a reader for the body of resp, the response to a
+// request with method, from r as its headers frame it. The reader fails
+// with ErrResponseTooLarge once the body grows past maxBody bytes, and
+// with io.ErrUnexpectedEOF if the connection ends before the body does.
+// It reports false if the body is delimited by the end of the connection,
+// which then cannot carry another request.
+func newBodyReader(r *bufio.Reader, method string, resp *Response, maxBody int64) (io.Reader, bool, error) {
+	if method == "HEAD" || resp.Status < 200 || resp.Status == 204 || resp.Status == 304 {
+		return bytes.NewReader(nil), true, nil
+	}
+	if coding := resp.Header["transfer-encoding"]; coding != "" {
+		if !strings.EqualFold(lastItem(coding), "chunked") {
+			return nil, false, fmt.Errorf("unsupported Transfer-Encoding %q", coding)
+		}
+		return &chunkedReader{r: r, max: maxBody}, true, nil
+	}
+	if value, ok := resp.Header["content-length"]; ok {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return nil, false, fmt.Errorf("invalid Content-Length %q", value)
+		}
+		if n > maxBody {
+			return nil, false, ErrResponseTooLarge
+		}
+		return &lengthReader{r: r, remaining: n}, true, nil
+	}
+	return &cappedReader{r: r, remaining: maxBody}, false, nil
+}
+
+// readHead reads a status line and headers, returning the response
+// without its body and its HTTP version.
+//
+// This does not share the server's request head parser (readRequestHead
+// and readHeadLine in package server) on purpose; the two read opposite
+// sides of the protocol under different rules:
+//
+//   - The server is the recipient of untrusted requests and parses
+//     strictly: a bare LF, obsolete line folding or whitespace before a
+//     colon is a 400 unless lenient parsing is on, and it answers with
+//     HTTPErrors, metrics and a raw capture of the head. The client reads
+//     responses from upstreams it was pointed at and, as RFC 9112 section
+//     2.2 allows a recipient to, tolerates a bare LF.
+//   - The server limits each line (MaxRequestLineLength,
+//     MaxHeaderLineLength); the client charges the whole head to one
+//     budget, maxHeaderBytes, so an upstream cannot hold it with many
+//     short lines.
+//   - The server keeps the last of repeated headers and refuses
+//     conflicting Content-Length values; the client joins repeated
+//     headers into one list, as a proxy relaying them must.
+//
+// Sharing the code would also need a third package, since server imports
+// httpclient for the proxy.
+func readHead(r *bufio.Reader) (*Response, string, error) {
+	budget := maxHeaderBytes
+	line, err := readLine(r, &budget)
+	if err != nil {
+		return nil, "", fmt.Errorf("reading status line: %w", err)
+	}
+	version, rest, _ := strings.Cut(line, " ")
+	code, reason, _ := strings.Cut(rest, " ")
+	status, err := strconv.Atoi(code)
+	if !strings.HasPrefix(version, "HTTP/1.") || len(code) != 3 || err != nil {
+		return nil, "", fmt.Errorf("malformed status line %q", line)
+	}
+
+	resp := &Response{Status: status, Reason: reason, Header: make(map[string]string)}
+	if err := readHeaders(r, resp.Header, &budget); err != nil {
+		return nil, "", err
+	}
+	return resp, version, nil
+}
+
+// readHeaders reads header lines into headers up to and including the
+// blank line, charging them to budget.
+func readHeaders(r *bufio.Reader, headers map[string]string, budget *int) error {
+	for {
+		line, err := readLine(r, budget)
+		if err != nil {
+			return fmt.Errorf("reading headers: %w", err)
+		}
+		if line == "" {
+			return nil
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !isToken(name) {
+			return fmt.Errorf("malformed header line %q", line)
+		}
+		name, value = strings.ToLower(name), strings.TrimSpace(value)
+		if prev, ok := headers[name]; ok {
+			value = prev + ", " + value
+		}
+		headers[name] = value
+	}
+}
+
+// lengthReader reads a body of a declared length.
+type lengthReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	if l.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining == 0 {
+		return n, nil
+	}
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return n, fmt.Errorf("reading body: %w", err)
+	}
+	return n, nil
+}
+
+// cappedReader reads a body delimited by the end of the connection.
+type cappedReader struct {
+	r         io.Reader
+	remaining int64 // bytes left before the body is too large
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > c.remaining+1 {
+		p = p[:c.remaining+1]
+	}
+	n, err := c.r.Read(p)
+	if c.remaining -= int64(n); c.remaining < 0 {
+		return 0, ErrResponseTooLarge
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return n, fmt.Errorf("reading body: %w", err)
+	}
+	return n, err
+}
+
+// chunkedReader decodes a chunked body, discarding its trailers.
+type chunkedReader struct {
+	r         *bufio.Reader
+	remaining int64 // bytes left in the current chunk
+	total     int64
+	max       int64
+	err       error // ended the body: io.EOF once it is complete
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.remaining == 0 {
+		if c.err = c.nextChunk(); c.err != nil {
+			return 0, c.err
+		}
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if c.remaining > 0 && errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		c.err = fmt.Errorf("reading chunk: %w", err)
+		return n, c.err
+	}
+	if c.remaining == 0 {
+		budget := maxHeaderBytes
+		if crlf, err := readLine(c.r, &budget); err != nil || crlf != "" {
+			c.err = errors.New("missing CRLF after chunk data")
+			return n, c.err
+		}
+	}
+	return n, nil
+}
+
+// nextChunk reads the next chunk-size line. It returns io.EOF, having read
+// the trailers, after the last chunk.
+func (c *chunkedReader) nextChunk() error {
+	budget := maxHeaderBytes
+	line, err := readLine(c.r, &budget)
+	if err != nil {
+		return fmt.Errorf("reading chunk size: %w", err)
+	}
+	sizeField, _, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid chunk size %q", line)
+	}
+	if size == 0 {
+		if err := readHeaders(c.r, map[string]string{}, &budget); err != nil {
+			return fmt.Errorf("reading trailers: %w", err)
+		}
+		return io.EOF
+	}
+	if size > c.max-c.total {
+		return ErrResponseTooLarge
+	}
+	c.total += size
+	c.remaining = size
+	return nil
+}
+
+// readLine reads a line ending in LF, tolerating a missing CR, and
+// charges it to budget.
+func readLine(r *bufio.Reader, budget *int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if *budget -= len(chunk); *budget < 0 {
+			return "", ErrResponseTooLarge
+		}
+		if err == nil {
+			break
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if errors.Is(err, io.EOF) && len(line) > 0 {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
+// hasToken reports whether the comma-separated list contains token.
+func hasToken(list, token string) bool {
+	for _, item := range strings.Split(list, ",") {
+		if strings.TrimSpace(item) == token {
+			return true
+		}
+	}
+	return false
+}
+
+// lastItem returns the last element of a comma-separated list.
+func lastItem(list string) string {
+	items := strings.Split(list, ",")
+	return strings.TrimSpace(items[len(items)-1])
+}
+
+func isToken(s string) bool {
+	for _, c := range s {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune("()<>@,;:\\\"/[]?={}", c) {
+			return false
+		}
+	}
+	return s != ""
+}
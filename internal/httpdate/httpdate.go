@@ -0,0 +1,104 @@
+// Package httpdate parses and formats the date and time values used in HTTP
+// headers such as Date, Last-Modified, If-Modified-Since, If-Range, Expires
+// and Retry-After, following RFC 9110 section 5.6.7.
+//
+// All conditional and caching code in the server should go through this
+// package so that every header compares dates the same way.
+package httpdate
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// IMFFixdate is the preferred HTTP date format and the only one emitted.
+	IMFFixdate = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+	// RFC850 is the obsolete RFC 850 format with a two-digit year.
+	RFC850 = "Monday, 02-Jan-06 15:04:05 GMT"
+
+	// ASCTime is the obsolete ANSI C asctime() format.
+	ASCTime = "Mon Jan _2 15:04:05 2006"
+)
+
+// MaxRetryAfter is the longest delay ParseRetryAfter returns, the largest
+// whole number of seconds a time.Duration holds.
+const MaxRetryAfter = time.Duration(math.MaxInt64) / time.Second * time.Second
+
+// ErrInvalidDate is returned when a value matches none of the HTTP date formats.
+var ErrInvalidDate = errors.New("invalid HTTP date")
+
+// ParseHTTPDate parses an HTTP date in any of the three formats recipients
+// must accept: IMF-fixdate, RFC 850 and asctime. The result is in UTC.
+//
+// Two-digit RFC 850 years are resolved relative to the current year: a year
+// that would lie more than 50 years in the future is taken to be in the
+// previous century.
+func ParseHTTPDate(value string) (time.Time, error) {
+	return parseAt(value, time.Now())
+}
+
+// FormatHTTPDate formats t as an IMF-fixdate in GMT, the only format a
+// sender may generate.
+func FormatHTTPDate(t time.Time) string {
+	return t.UTC().Format(IMFFixdate)
+}
+
+// ParseRetryAfter parses a Retry-After header value, which is either a
+// non-negative number of delay-seconds or an HTTP date.
+//
+// Returns:
+//   - time.Duration: The delay relative to now. Dates in the past yield 0,
+//     and delays too long for a time.Duration are capped at MaxRetryAfter.
+//   - error: ErrInvalidDate if the value is neither form.
+func ParseRetryAfter(value string, now time.Time) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value != "" && strings.Trim(value, "0123456789") == "" {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seconds > int64(MaxRetryAfter/time.Second) {
+			// Only a value out of int64 range fails to parse here.
+			return MaxRetryAfter, nil
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	date, err := parseAt(value, now)
+	if err != nil {
+		return 0, err
+	}
+	if delay := date.Sub(now); delay > 0 {
+		return delay, nil
+	}
+	return 0, nil
+}
+
+// parseAt parses value, resolving two-digit years relative to now.
+func parseAt(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+
+	if t, err := time.Parse(IMFFixdate, value); err == nil {
+		return t.UTC(), nil
+	}
+	if t, err := time.Parse(RFC850, value); err == nil {
+		return pivotYear(t, now).UTC(), nil
+	}
+	if t, err := time.Parse(ASCTime, value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Time{}, ErrInvalidDate
+}
+
+// pivotYear rewrites the century of an RFC 850 date per RFC 9110: the
+// two-digit year is placed in the current century unless that would put it
+// more than 50 years in the future.
+func pivotYear(t, now time.Time) time.Time {
+	year := now.UTC().Year()/100*100 + t.Year()%100
+	if year > now.UTC().Year()+50 {
+		year -= 100
+	}
+	return time.Date(year, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
@@ -0,0 +1,126 @@
+package httpdate
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// rfcExample is the instant of the examples in RFC 9110 section 5.6.7.
+var rfcExample = time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC)
+
+func TestParseHTTPDate(t *testing.T) {
+	for _, value := range []string{
+		"Sun, 06 Nov 1994 08:49:37 GMT",    // IMF-fixdate
+		"Sunday, 06-Nov-94 08:49:37 GMT",   // obsolete RFC 850 format
+		"Sun Nov  6 08:49:37 1994",         // ANSI C's asctime() format
+		"  Sun, 06 Nov 1994 08:49:37 GMT ", // surrounding whitespace
+	} {
+		got, err := ParseHTTPDate(value)
+		if err != nil || !got.Equal(rfcExample) {
+			t.Errorf("ParseHTTPDate(%q) = %v, %v; want %v", value, got, err, rfcExample)
+		}
+		if got.Location() != time.UTC {
+			t.Errorf("ParseHTTPDate(%q) is in %v, want UTC", value, got.Location())
+		}
+	}
+}
+
+func TestParseHTTPDateRejectsGarbage(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"garbage",
+		"0",
+		"Sun, 06 Nov 1994 08:49:37",
+		"Sun, 06 Nov 1994 08:49:37 UTC",
+		"Sun, 06 Nov 1994 08:49:37 +0000",
+		"Sun, 6 Nov 1994 08:49:37 GMT",
+		"Sun, 31 Nov 1994 08:49:37 GMT",
+		"Sun, 06 Nov 1994 24:49:37 GMT",
+		"Sun, 06 Foo 1994 08:49:37 GMT",
+		"Sun, 06 Nov 94 08:49:37 GMT",
+		"Sunday, 06-Nov-1994 08:49:37 GMT",
+		"Sun Nov 6 08:49:37 1994 GMT",
+		"1994-11-06T08:49:37Z",
+		"Sun, 06 Nov 1994 08:49:37 GMT; extra",
+	} {
+		if got, err := ParseHTTPDate(value); !errors.Is(err, ErrInvalidDate) {
+			t.Errorf("ParseHTTPDate(%q) = %v, %v; want ErrInvalidDate", value, got, err)
+		}
+	}
+}
+
+func TestRFC850YearPivot(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		year string
+		want int
+	}{
+		{"26", 2026},
+		{"00", 2000},
+		{"75", 2075}, // 49 years ahead
+		{"76", 2076}, // exactly 50 years ahead
+		{"77", 1977}, // more than 50 years ahead
+		{"94", 1994},
+		{"99", 1999},
+		{"25", 2025},
+	} {
+		got, err := parseAt("Sunday, 06-Nov-"+tt.year+" 08:49:37 GMT", now)
+		if err != nil || got.Year() != tt.want {
+			t.Errorf("year %s = %v, %v; want %d", tt.year, got, err, tt.want)
+		}
+	}
+
+	// The pivot moves with the current year.
+	later := time.Date(2080, time.January, 1, 0, 0, 0, 0, time.UTC)
+	if got, _ := parseAt("Sunday, 06-Nov-94 08:49:37 GMT", later); got.Year() != 2094 {
+		t.Errorf("year 94 in 2080 = %d, want 2094", got.Year())
+	}
+}
+
+func TestFormatHTTPDate(t *testing.T) {
+	if got := FormatHTTPDate(rfcExample); got != "Sun, 06 Nov 1994 08:49:37 GMT" {
+		t.Errorf("FormatHTTPDate = %q", got)
+	}
+	// Any zone is converted to GMT, and sub-second precision dropped.
+	zone := time.FixedZone("UTC+2", 2*60*60)
+	local := rfcExample.In(zone).Add(900 * time.Millisecond)
+	if got := FormatHTTPDate(local); got != "Sun, 06 Nov 1994 08:49:37 GMT" {
+		t.Errorf("FormatHTTPDate in UTC+2 = %q", got)
+	}
+	if got, err := ParseHTTPDate(FormatHTTPDate(local)); err != nil || !got.Equal(rfcExample) {
+		t.Errorf("round trip = %v, %v", got, err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := rfcExample
+	for _, tt := range []struct {
+		value string
+		want  time.Duration
+		err   error
+	}{
+		{"120", 2 * time.Minute, nil},
+		{"0", 0, nil},
+		{" 5 ", 5 * time.Second, nil},
+		{"Sun, 06 Nov 1994 08:51:37 GMT", 2 * time.Minute, nil},
+		{"Sunday, 06-Nov-94 08:50:37 GMT", time.Minute, nil},
+		{"Sun Nov  6 08:49:47 1994", 10 * time.Second, nil},
+		{"Sun, 06 Nov 1994 08:00:00 GMT", 0, nil}, // in the past
+		{"9223372036", 9223372036 * time.Second, nil},
+		{"9223372037", MaxRetryAfter, nil},
+		{"99999999999999999999999", MaxRetryAfter, nil},
+		{"", 0, ErrInvalidDate},
+		{"-5", 0, ErrInvalidDate},
+		{"1.5", 0, ErrInvalidDate},
+		{"soon", 0, ErrInvalidDate},
+	} {
+		got, err := ParseRetryAfter(tt.value, now)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("ParseRetryAfter(%q) = %v, %v; want %v, %v", tt.value, got, err, tt.want, tt.err)
+		}
+	}
+	if MaxRetryAfter <= 0 || MaxRetryAfter%time.Second != 0 {
+		t.Errorf("MaxRetryAfter = %v, want a positive whole number of seconds", MaxRetryAfter)
+	}
+}
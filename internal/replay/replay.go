@@ -0,0 +1,233 @@
+// Package replay feeds requests recorded by server.Recorder back through a
+// server and compares the responses with the recorded ones, to turn a
+// request that broke a deployment into a regression test:
+//
+//	func TestUploadRegression(t *testing.T) {
+//		srv, err := server.NewServerFromConfig(cfg)
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		replay.Check(t, srv, "testdata/upload.rec.json", replay.Options{})
+//	}
+//
+// CheckDir does the same for every recording in a directory.
+//
+// Each replay serves the server on a loopback listener of its own, so it
+// sees the request arrive from 127.0.0.1 rather than the recorded client,
+// and redacted headers, such as Authorization, arrive as server.Redacted.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/server"
+	"github.com/Abb133Se/httpServer/internal/testclient"
+)
+
+// DefaultIgnoreHeaders are the response headers left out of comparisons
+// unless Options.IgnoreHeaders says otherwise; they differ on every
+// response.
+var DefaultIgnoreHeaders = []string{"Date", "X-Request-ID"}
+
+// Options adjust how responses are compared.
+type Options struct {
+	// IgnoreHeaders names the response headers not compared, matched
+	// case-insensitively. Nil means DefaultIgnoreHeaders.
+	IgnoreHeaders []string
+
+	// Timeout bounds each replay. Zero means testclient.DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Result is the outcome of one replay.
+type Result struct {
+	Recorded *testclient.Response
+	Replayed *testclient.Response
+
+	// Diffs lists how the replayed response differs from the recorded
+	// one, after normalization; it is empty when they match.
+	Diffs []string
+}
+
+// OK reports whether the replayed response matched the recorded one.
+func (r *Result) OK() bool {
+	return len(r.Diffs) == 0
+}
+
+// ReplayFile replays the recording at path through srv, see Replay.
+func ReplayFile(srv *server.Server, path string, opts Options) (*Result, error) {
+	rec, err := server.ReadRecording(path)
+	if err != nil {
+		return nil, err
+	}
+	return Replay(srv, rec, opts)
+}
+
+// Replay sends the recorded request, byte for byte, to srv and compares
+// the response with the recorded one. srv must not be serving yet; it is
+// served on a loopback listener for the duration of the replay.
+//
+// A response recorded only in part, because it hit the recorder's size
+// cap, is compared by status and headers alone. A request recorded only
+// in part cannot be replayed.
+func Replay(srv *server.Server, rec *server.Recording, opts Options) (*Result, error) {
+	if rec.RequestTruncated {
+		return nil, errors.New("the request was cut at the recorder's size cap and cannot be replayed")
+	}
+	method, _, _ := strings.Cut(string(rec.Request), " ")
+	readMethod := method
+	if rec.ResponseTruncated {
+		readMethod = "HEAD"
+	}
+	recorded, err := testclient.ReadResponse(bufio.NewReader(bytes.NewReader(rec.Response)), readMethod)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recorded response: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(listener) }()
+	defer func() {
+		listener.Close()
+		<-served
+	}()
+
+	client, err := testclient.Dial(listener.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	if opts.Timeout > 0 {
+		client.SetTimeout(opts.Timeout)
+	}
+	if err := client.SendRaw(rec.Request); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if err := client.CloseWrite(); err != nil {
+		return nil, err
+	}
+	replayed, err := client.ReadResponse()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if rec.ResponseTruncated {
+		replayed.Body, replayed.Trailers = nil, nil
+		recorded.Body, recorded.Trailers = nil, nil
+	}
+
+	ignore := opts.IgnoreHeaders
+	if ignore == nil {
+		ignore = DefaultIgnoreHeaders
+	}
+	return &Result{Recorded: recorded, Replayed: replayed, Diffs: compare(recorded, replayed, ignore)}, nil
+}
+
+// Check replays the recording at path through srv, see Replay, and fails
+// t if it cannot be replayed or the response differs.
+func Check(t testing.TB, srv *server.Server, path string, opts Options) {
+	t.Helper()
+	result, err := ReplayFile(srv, path, opts)
+	if err != nil {
+		t.Fatalf("replay %s: %v", path, err)
+	}
+	for _, diff := range result.Diffs {
+		t.Errorf("replay %s: %s", path, diff)
+	}
+}
+
+// CheckDir runs Check as a subtest for every recording in dir, named
+// after its file, so a directory of recordings kept under testdata becomes
+// a regression suite. Each recording is replayed through a fresh server
+// from newServer, since a server is served only once.
+func CheckDir(t *testing.T, dir string, newServer func(t *testing.T) *server.Server, opts Options) {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+server.RecordingExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatalf("no recordings in %s", dir)
+	}
+	for _, path := range paths {
+		t.Run(strings.TrimSuffix(filepath.Base(path), server.RecordingExt), func(t *testing.T) {
+			Check(t, newServer(t), path, opts)
+		})
+	}
+}
+
+// compare lists the differences between the recorded and replayed
+// responses, leaving out the ignored headers and the values of headers
+// redacted in the recording.
+func compare(recorded, replayed *testclient.Response, ignore []string) []string {
+	var diffs []string
+	if recorded.Status != replayed.Status || recorded.Reason != replayed.Reason {
+		diffs = append(diffs, fmt.Sprintf("status: recorded %d %s, replayed %d %s", recorded.Status, recorded.Reason, replayed.Status, replayed.Reason))
+	}
+	skip := func(name string) bool {
+		return slices.ContainsFunc(ignore, func(ignored string) bool { return strings.EqualFold(ignored, name) })
+	}
+	for _, name := range sortedKeys(recorded.Headers, replayed.Headers) {
+		want, inRecorded := recorded.Headers[name]
+		got, inReplayed := replayed.Headers[name]
+		switch {
+		case skip(name):
+		case !inReplayed:
+			diffs = append(diffs, fmt.Sprintf("header %s: recorded %q, missing from replay", name, want))
+		case !inRecorded:
+			diffs = append(diffs, fmt.Sprintf("header %s: not recorded, replayed %q", name, got))
+		case want != got && want != server.Redacted:
+			diffs = append(diffs, fmt.Sprintf("header %s: recorded %q, replayed %q", name, want, got))
+		}
+	}
+	if !bytes.Equal(recorded.Body, replayed.Body) {
+		diffs = append(diffs, describeBodyDiff(recorded.Body, replayed.Body))
+	}
+	for _, name := range sortedKeys(recorded.Trailers, replayed.Trailers) {
+		if want, got := recorded.Trailers[name], replayed.Trailers[name]; want != got && !skip(name) {
+			diffs = append(diffs, fmt.Sprintf("trailer %s: recorded %q, replayed %q", name, want, got))
+		}
+	}
+	return diffs
+}
+
+// describeBodyDiff says where two differing bodies part.
+func describeBodyDiff(recorded, replayed []byte) string {
+	at := 0
+	for at < len(recorded) && at < len(replayed) && recorded[at] == replayed[at] {
+		at++
+	}
+	return fmt.Sprintf("body: recorded %d bytes, replayed %d, first difference at byte %d: recorded %q, replayed %q",
+		len(recorded), len(replayed), at, excerpt(recorded, at), excerpt(replayed, at))
+}
+
+// excerpt returns up to 32 bytes of b from offset at.
+func excerpt(b []byte, at int) []byte {
+	return b[at:min(at+32, len(b))]
+}
+
+// sortedKeys returns the keys of a and b, sorted, without duplicates.
+func sortedKeys(a, b map[string]string) []string {
+	var keys []string
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,71 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+	"github.com/Abb133Se/httpServer/internal/server"
+)
+
+// newServer builds the server the binary runs with the default
+// configuration, less the access log, stopping its background tasks when
+// the test ends.
+func newServer(t *testing.T) *server.Server {
+	t.Helper()
+	cfg := config.LoadConfig()
+	cfg.AccessLog = "off"
+	srv, err := server.NewServerFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("building server: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.StopTasks(ctx)
+	})
+	return srv
+}
+
+// TestRecordedSession replays a session recorded with RECORD_DIR against
+// the default configuration.
+func TestRecordedSession(t *testing.T) {
+	CheckDir(t, "testdata", newServer, Options{})
+}
+
+func TestReplayReportsDifferences(t *testing.T) {
+	rec, err := server.ReadRecording("testdata/root.rec.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Response = bytes.Replace(rec.Response, []byte("Welcome"), []byte("Goodbye"), 1)
+	rec.Response = bytes.Replace(rec.Response, []byte("Content-Type: text/plain"), []byte("Content-Type: text/html"), 1)
+
+	result, err := Replay(newServer(t), rec, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.OK() {
+		t.Fatal("a changed response replayed as a match")
+	}
+	diffs := strings.Join(result.Diffs, "\n")
+	for _, want := range []string{"header content-type", "body: recorded 25 bytes, replayed 25, first difference at byte 0"} {
+		if !strings.Contains(diffs, want) {
+			t.Errorf("diffs lack %q:\n%s", want, diffs)
+		}
+	}
+}
+
+func TestReplayRefusesTruncatedRequest(t *testing.T) {
+	rec, err := server.ReadRecording("testdata/root.rec.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.RequestTruncated = true
+	if _, err := Replay(newServer(t), rec, Options{}); err == nil {
+		t.Error("replayed a truncated request")
+	}
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// AcceptDecision is the verdict of an AcceptFilter on a new connection.
+type AcceptDecision int
+
+const (
+	// Accept serves the connection, subject to the remaining filters.
+	Accept AcceptDecision = iota
+
+	// AcceptTrusted serves the connection and marks it as coming from a
+	// trusted proxy, so Request.ClientIP honors X-Forwarded-For on it.
+	AcceptTrusted
+
+	// Reject closes the connection immediately, before anything is read.
+	Reject
+)
+
+// AcceptFilter decides whether a freshly accepted connection is served.
+//
+// Filters run on the accept goroutine, before the connection gets a
+// goroutine of its own, so they must be cheap and must never block or
+// read from conn: a slow filter stalls every new connection.
+type AcceptFilter func(conn net.Conn) AcceptDecision
+
+// OnAccept adds an accept filter. Filters run in the order they were
+// added; the first Reject closes the connection and skips the rest, and
+// an AcceptTrusted from any filter marks the connection as trusted.
+//
+// Example:
+//
+//	srv.OnAccept(func(conn net.Conn) server.AcceptDecision {
+//	    if strings.HasPrefix(conn.RemoteAddr().String(), "10.") {
+//	        return server.AcceptTrusted
+//	    }
+//	    return server.Accept
+//	})
+func (s *Server) OnAccept(filter AcceptFilter) {
+	s.acceptFilters = append(s.acceptFilters, filter)
+}
+
+// runAcceptFilters applies the accept filters to conn. It reports whether
+// the connection may be served and whether it is trusted.
+func (s *Server) runAcceptFilters(conn net.Conn) (ok, trusted bool) {
+	for _, filter := range s.acceptFilters {
+		switch filter(conn) {
+		case Reject:
+			Metrics.Counter("connections_rejected_total").Inc()
+			utils.Debug("Rejected connection from %s", conn.RemoteAddr())
+			return false, false
+		case AcceptTrusted:
+			trusted = true
+		}
+	}
+	return true, trusted
+}
+
+// trackConn registers an open connection, counting it against its client
+// IP, and returns the function that releases it once it is closed.
+func (s *Server) trackConn(conn net.Conn) func() {
+	ip := connIP(conn)
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if s.connsByIP == nil {
+		s.connsByIP = make(map[string]int)
+		s.openConns = make(map[net.Conn]struct{})
+	}
+	s.connsByIP[ip]++
+	s.openConns[conn] = struct{}{}
+	s.connWG.Add(1)
+	if open := s.stats.openConns.Add(1); open > s.stats.peakConns.Load() {
+		s.stats.peakConns.Store(open)
+	}
+
+	return func() {
+		s.connsMu.Lock()
+		defer s.connsMu.Unlock()
+		if s.connsByIP[ip]--; s.connsByIP[ip] <= 0 {
+			delete(s.connsByIP, ip)
+		}
+		delete(s.openConns, conn)
+		s.stats.openConns.Add(-1)
+		s.connWG.Done()
+	}
+}
+
+// ConnsFrom returns the number of open connections from ip.
+func (s *Server) ConnsFrom(ip string) int {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	return s.connsByIP[ip]
+}
+
+// MaxConnsPerIP returns a filter rejecting connections from a client IP
+// that already has max connections open.
+func (s *Server) MaxConnsPerIP(max int) AcceptFilter {
+	return func(conn net.Conn) AcceptDecision {
+		if s.ConnsFrom(connIP(conn)) >= max {
+			Metrics.Counter("connections_rejected_per_ip_limit_total").Inc()
+			return Reject
+		}
+		return Accept
+	}
+}
+
+// BanFilter returns a filter rejecting connections from the given IPs or
+// CIDR ranges.
+func BanFilter(banned []string) (AcceptFilter, error) {
+	nets, err := parseIPNets(banned)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ban list: %w", err)
+	}
+	return func(conn net.Conn) AcceptDecision {
+		if ipInNets(connIP(conn), nets) {
+			Metrics.Counter("connections_rejected_banned_total").Inc()
+			return Reject
+		}
+		return Accept
+	}, nil
+}
+
+// TrustFilter returns a filter marking connections from the given IPs or
+// CIDR ranges, such as a load balancer's, as trusted. Prefer
+// Server.TrustProxies, which also lets ClientIP see through chains of
+// these proxies.
+func TrustFilter(trusted []string) (AcceptFilter, error) {
+	nets, err := parseIPNets(trusted)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy list: %w", err)
+	}
+	return func(conn net.Conn) AcceptDecision {
+		if ipInNets(connIP(conn), nets) {
+			return AcceptTrusted
+		}
+		return Accept
+	}, nil
+}
+
+// TrustProxies marks connections from the given IPs or CIDR ranges as
+// trusted, like a TrustFilter, and has ClientIP skip addresses in them
+// when it walks X-Forwarded-For, so a request relayed by several trusted
+// proxies resolves to the client in front of the first.
+func (s *Server) TrustProxies(proxies []string) error {
+	nets, err := parseIPNets(proxies)
+	if err != nil {
+		return fmt.Errorf("invalid trusted proxy list: %w", err)
+	}
+	s.trustedProxies = append(s.trustedProxies, nets...)
+	s.OnAccept(func(conn net.Conn) AcceptDecision {
+		if ipInNets(connIP(conn), nets) {
+			return AcceptTrusted
+		}
+		return Accept
+	})
+	return nil
+}
+
+// connIP returns the IP of conn's peer.
+func connIP(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// parseIPNets parses IPs and CIDR ranges; a bare IP covers only itself.
+func parseIPNets(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("entry %q: %w", entry, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// ipInNets reports whether the textual IP ip lies in any of nets.
+func ipInNets(ip string, nets []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range nets {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
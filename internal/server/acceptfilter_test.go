@@ -0,0 +1,52 @@
+package server
+
+import "testing"
+
+func TestServeBanFilter(t *testing.T) {
+	for _, tt := range []struct {
+		banned string
+		served bool
+	}{
+		{"127.0.0.1", false},
+		{"127.0.0.0/8", false},
+		{"192.0.2.0/24", true},
+	} {
+		t.Setenv("BANNED_IPS", tt.banned)
+		_, addr := startServer(t)
+		c := dial(t, addr)
+
+		if !tt.served {
+			if err := c.ExpectClose(); err != nil {
+				t.Errorf("banned %s: %v", tt.banned, err)
+			}
+			continue
+		}
+		if resp := roundTrip(t, c, "GET", "/", nil, nil); resp.Status != 200 {
+			t.Errorf("banned %s: GET / = %d, want 200", tt.banned, resp.Status)
+		}
+	}
+	if _, err := BanFilter([]string{"not-an-ip"}); err == nil {
+		t.Error("BanFilter accepted an invalid entry")
+	}
+}
+
+func TestServeMaxConnsPerIP(t *testing.T) {
+	t.Setenv("MAX_CONNS_PER_IP", "1")
+	srv, addr := startServer(t)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+
+	first := dial(t, addr)
+	if resp := roundTrip(t, first, "GET", "/", keepAlive, nil); resp.Status != 200 {
+		t.Fatalf("first connection: GET / = %d, want 200", resp.Status)
+	}
+	if err := dial(t, addr).ExpectClose(); err != nil {
+		t.Errorf("second connection: %v", err)
+	}
+
+	// Once the first connection is gone its slot is free again.
+	first.Close()
+	waitFor(t, "the first connection to be released", func() bool { return srv.ConnsFrom("127.0.0.1") == 0 })
+	if resp := roundTrip(t, dial(t, addr), "GET", "/", nil, nil); resp.Status != 200 {
+		t.Errorf("after the first closed: GET / = %d, want 200", resp.Status)
+	}
+}
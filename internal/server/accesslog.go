@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// Access log formats, see NewAccessLogSink.
+const (
+	AccessLogPlain = "plain"
+	AccessLogJSON  = "json"
+)
+
+// AccessLogSink is an EventSink writing one access log line per request:
+// the client, request line, status, bytes sent and duration, timed from
+// the request head being read to the response being written. Requests
+// that could not be parsed are logged with "-" for their request line.
+type AccessLogSink struct {
+	mu   sync.Mutex
+	w    io.Writer
+	json bool
+}
+
+// accessLogEntry is the line AccessLogSink writes in the JSON format.
+type accessLogEntry struct {
+	Time       time.Time `json:"time"`
+	ClientIP   string    `json:"client_ip"`
+	Principal  string    `json:"principal,omitempty"`
+	Method     string    `json:"method,omitempty"`
+	Target     string    `json:"target,omitempty"`
+	Version    string    `json:"version,omitempty"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMS float64   `json:"duration_ms"`
+	RequestID  string    `json:"request_id,omitempty"`
+	ErrorCode  string    `json:"error_code,omitempty"`
+}
+
+// NewAccessLogSink returns a sink writing access log lines to w in format:
+// AccessLogPlain, the Common Log Format followed by the duration, e.g.
+//
+//	203.0.113.7 - - [16/Oct/2026:09:30:00 +0000] "GET /files/a.txt HTTP/1.1" 200 1532 0.412ms
+//
+// or AccessLogJSON, one JSON object per line.
+func NewAccessLogSink(w io.Writer, format string) (*AccessLogSink, error) {
+	switch format {
+	case AccessLogPlain, AccessLogJSON:
+		return &AccessLogSink{w: w, json: format == AccessLogJSON}, nil
+	default:
+		return nil, fmt.Errorf("unknown access log format %q, want %s or %s", format, AccessLogPlain, AccessLogJSON)
+	}
+}
+
+// Emit writes the access log line of event. Failures are logged and the
+// line dropped, so a full disk never fails requests.
+func (s *AccessLogSink) Emit(event *RequestEvent) {
+	var line []byte
+	if s.json {
+		var err error
+		line, err = json.Marshal(accessLogEntry{
+			Time:       event.Start,
+			ClientIP:   event.ClientIP,
+			Principal:  event.Principal,
+			Method:     event.Method,
+			Target:     event.Target,
+			Version:    event.Version,
+			Status:     event.Status,
+			Bytes:      event.BytesSent,
+			DurationMS: event.DurationMS,
+			RequestID:  event.RequestID,
+			ErrorCode:  event.ErrorCode,
+		})
+		if err != nil {
+			utils.Error("Failed to encode access log line: %v", err)
+			return
+		}
+	} else {
+		requestLine := "-"
+		if event.Method != "" {
+			requestLine = event.Method + " " + event.Target + " " + event.Version
+		}
+		line = fmt.Appendf(nil, "%s - %s [%s] %q %d %d %.3fms",
+			orDash(event.ClientIP), orDash(event.Principal), event.Start.Format("02/Jan/2006:15:04:05 -0700"),
+			requestLine, event.Status, event.BytesSent, event.DurationMS)
+	}
+	line = append(line, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(line); err != nil {
+		Metrics.Counter("access_log_dropped_total").Inc()
+		utils.Warn("Failed to write access log line: %v", err)
+	}
+}
+
+// orDash returns s, or "-" for an empty s, as the Common Log Format writes
+// missing fields.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// multiSink emits every event to each of its sinks in turn.
+type multiSink []EventSink
+
+func (m multiSink) Emit(event *RequestEvent) {
+	for _, sink := range m {
+		sink.Emit(event)
+	}
+}
+
+// MultiEventSink returns a sink emitting every event to each of sinks, so
+// that, say, an access log and a SIEM feed share one RequestEvent per
+// request. Sinks must not change the events they receive.
+func MultiEventSink(sinks ...EventSink) EventSink {
+	if len(sinks) == 1 {
+		return sinks[0]
+	}
+	return multiSink(sinks)
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+)
+
+// accessEvent returns the event of a served GET, as the server emits it.
+func accessEvent() *RequestEvent {
+	return &RequestEvent{
+		Start:      time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC),
+		ClientIP:   "203.0.113.7",
+		Principal:  "alice",
+		Method:     "GET",
+		Target:     "/files/a.txt?x=1",
+		Version:    "HTTP/1.1",
+		Status:     200,
+		BytesSent:  1532,
+		DurationMS: 0.412,
+		RequestID:  "req-1",
+	}
+}
+
+func TestAccessLogPlain(t *testing.T) {
+	unparsed := &RequestEvent{
+		Start:      accessEvent().Start,
+		ClientIP:   "203.0.113.8",
+		Status:     400,
+		BytesSent:  90,
+		DurationMS: 1,
+		ErrorCode:  EventErrorMalformed,
+	}
+	quoted := accessEvent()
+	quoted.Principal = ""
+	quoted.Target = `/a"b`
+
+	for _, tt := range []struct {
+		name  string
+		event *RequestEvent
+		want  string
+	}{
+		{"served", accessEvent(), `203.0.113.7 - alice [16/Oct/2026:09:30:00 +0000] "GET /files/a.txt?x=1 HTTP/1.1" 200 1532 0.412ms`},
+		{"unparsed", unparsed, `203.0.113.8 - - [16/Oct/2026:09:30:00 +0000] "-" 400 90 1.000ms`},
+		{"quote in the target", quoted, `203.0.113.7 - - [16/Oct/2026:09:30:00 +0000] "GET /a\"b HTTP/1.1" 200 1532 0.412ms`},
+	} {
+		var buf bytes.Buffer
+		sink, err := NewAccessLogSink(&buf, AccessLogPlain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sink.Emit(tt.event)
+		if got := buf.String(); got != tt.want+"\n" {
+			t.Errorf("%s: line = %q, want %q", tt.name, got, tt.want+"\n")
+		}
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	var buf bytes.Buffer
+	sink, err := NewAccessLogSink(&buf, AccessLogJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Emit(accessEvent())
+	sink.Emit(&RequestEvent{Start: accessEvent().Start, ClientIP: "203.0.113.8", Status: 400, ErrorCode: EventErrorMalformed})
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("wrote %q, want two lines", buf.String())
+	}
+	var served map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &served); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"time":        "2026-10-16T09:30:00Z",
+		"client_ip":   "203.0.113.7",
+		"principal":   "alice",
+		"method":      "GET",
+		"target":      "/files/a.txt?x=1",
+		"version":     "HTTP/1.1",
+		"status":      200.0,
+		"bytes":       1532.0,
+		"duration_ms": 0.412,
+		"request_id":  "req-1",
+	}
+	for key, value := range want {
+		if served[key] != value {
+			t.Errorf("%s = %v, want %v", key, served[key], value)
+		}
+	}
+	if len(served) != len(want) {
+		t.Errorf("line = %s, want only the fields %v", lines[0], want)
+	}
+
+	var unparsed map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &unparsed); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"method", "target", "version", "principal"} {
+		if _, ok := unparsed[key]; ok {
+			t.Errorf("unparsed request logged with %s: %s", key, lines[1])
+		}
+	}
+	if unparsed["error_code"] != EventErrorMalformed {
+		t.Errorf("error_code = %v, want %s", unparsed["error_code"], EventErrorMalformed)
+	}
+}
+
+func TestAccessLogUnknownFormat(t *testing.T) {
+	for _, format := range []string{"", "combined"} {
+		if _, err := NewAccessLogSink(&bytes.Buffer{}, format); err == nil {
+			t.Errorf("NewAccessLogSink(%q) succeeded, want an error", format)
+		}
+	}
+	cfg := config.LoadConfig()
+	cfg.AccessLog = filepath.Join(t.TempDir(), "access.log")
+	cfg.AccessLogFormat = "xml"
+	if _, err := NewServerFromConfig(cfg); err == nil || !strings.Contains(err.Error(), "ACCESS_LOG_FORMAT") {
+		t.Errorf("NewServerFromConfig with ACCESS_LOG_FORMAT=xml = %v, want an error naming it", err)
+	}
+}
+
+func TestAccessLogWriteFailure(t *testing.T) {
+	dropped := Metrics.Counter("access_log_dropped_total")
+	before := dropped.Value()
+	sink, err := NewAccessLogSink(&failingWriter{}, AccessLogPlain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Emit(accessEvent())
+	if got := dropped.Value() - before; got != 1 {
+		t.Errorf("access_log_dropped_total grew by %v, want 1", got)
+	}
+}
+
+func TestAccessLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	t.Setenv("ACCESS_LOG", path)
+	t.Setenv("ACCESS_LOG_FORMAT", "json")
+	_, addr := startServer(t)
+
+	c := dial(t, addr)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+	roundTrip(t, c, "GET", "/healthz", keepAlive, nil)
+	roundTrip(t, c, "GET", "/no/such/route?q=1", keepAlive, nil)
+
+	var lines []string
+	waitFor(t, "both lines to be written", func() bool {
+		data, _ := os.ReadFile(path)
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		return len(lines) == 2 && strings.HasSuffix(string(data), "\n")
+	})
+	for i, want := range []struct {
+		target string
+		status int
+	}{
+		{"/healthz", 200},
+		{"/no/such/route?q=1", 404},
+	} {
+		var entry accessLogEntry
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			t.Fatalf("line %d: %v", i+1, err)
+		}
+		if entry.Method != "GET" || entry.Target != want.target || entry.Status != want.status || entry.ClientIP != "127.0.0.1" {
+			t.Errorf("line %d = %s, want GET %s answered %d for 127.0.0.1", i+1, lines[i], want.target, want.status)
+		}
+		if entry.Bytes <= 0 || entry.DurationMS <= 0 {
+			t.Errorf("line %d = %s, want the bytes sent and duration", i+1, lines[i])
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseExtraHeaders parses EXTRA_RESPONSE_HEADERS, a comma-separated list
+// of "Name=Value" pairs, e.g. "X-Frame-Options=DENY,X-Robots-Tag=noindex".
+// Values cannot contain commas. Names must be valid header tokens and
+// values must not contain control characters, so configuration cannot
+// inject extra header lines.
+func ParseExtraHeaders(raw string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || !isToken(name) {
+			return nil, fmt.Errorf("invalid header name in %q", entry)
+		}
+		if err := validateHeaderValue(value); err != nil {
+			return nil, fmt.Errorf("header %s: %w", name, err)
+		}
+		headers[canonicalHeaderKey(name)] = value
+	}
+	return headers, nil
+}
+
+// validateHeaderValue rejects values that are empty or contain control
+// characters other than horizontal tab.
+func validateHeaderValue(value string) error {
+	if value == "" {
+		return fmt.Errorf("empty value")
+	}
+	if hasControlChar(value) {
+		return fmt.Errorf("value contains a control character")
+	}
+	return nil
+}
+
+// Advertisement configures the headers AdvertiseHeaders adds.
+type Advertisement struct {
+	// AltSvc is sent as Alt-Svc, e.g. `h2=":8443"; ma=86400`, to point
+	// clients at an alternative service. Empty sends nothing.
+	AltSvc string
+
+	// H2C advertises an upgrade to HTTP/2 over cleartext with
+	// "Upgrade: h2c" on plaintext responses.
+	H2C bool
+
+	// Extra headers are sent on every response, see ParseExtraHeaders.
+	Extra map[string]string
+}
+
+// AdvertiseHeaders returns a post-processor adding the advertisement
+// headers to every response. Headers a handler set itself are kept.
+func AdvertiseHeaders(ad Advertisement) PostProcessor {
+	return func(req *Request, resp *Response) {
+		for name, value := range ad.Extra {
+			setDefaultHeader(resp, name, value)
+		}
+		if ad.AltSvc != "" {
+			setDefaultHeader(resp, "Alt-Svc", ad.AltSvc)
+		}
+		if ad.H2C && req.TLSState == nil && resp.Status != 101 {
+			if setDefaultHeader(resp, "Upgrade", "h2c") {
+				// Upgrade is hop-by-hop and must be listed in Connection.
+				if conn := resp.Headers["Connection"]; conn != "" {
+					resp.Headers["Connection"] = conn + ", Upgrade"
+				} else {
+					resp.Headers["Connection"] = "Upgrade"
+				}
+			}
+		}
+	}
+}
+
+// setDefaultHeader sets name on resp unless it is already present in any
+// letter case, reporting whether it did.
+func setDefaultHeader(resp *Response, name, value string) bool {
+	for key := range resp.Headers {
+		if strings.EqualFold(key, name) {
+			return false
+		}
+	}
+	resp.Headers[name] = value
+	return true
+}
+
+// connectionCloses reports whether a Connection header value asks for the
+// connection to be closed.
+func connectionCloses(value string) bool {
+	for _, option := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(option), "close") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"maps"
+	"strings"
+	"testing"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+)
+
+func TestParseExtraHeaders(t *testing.T) {
+	for _, tt := range []struct {
+		raw  string
+		want map[string]string
+	}{
+		{"", map[string]string{}},
+		{"x-frame-options=DENY", map[string]string{"X-Frame-Options": "DENY"}},
+		{" X-Robots-Tag = noindex , ,x-a=b=c", map[string]string{"X-Robots-Tag": "noindex", "X-A": "b=c"}},
+		{"X-A=1\t2", map[string]string{"X-A": "1\t2"}},
+	} {
+		got, err := ParseExtraHeaders(tt.raw)
+		if err != nil || !maps.Equal(got, tt.want) {
+			t.Errorf("ParseExtraHeaders(%q) = %v, %v; want %v", tt.raw, got, err, tt.want)
+		}
+	}
+
+	for _, raw := range []string{
+		"X-Frame-Options",
+		"=DENY",
+		"X Frame=DENY",
+		"X-Frame:Options=DENY",
+		"X-Frame-Options=",
+		"X-A=1\r\nSet-Cookie: session=stolen",
+		"X-A=1\nX-B: 2",
+		"X-A=\x00",
+		"X-A=ok,X-B=\x7f",
+	} {
+		if got, err := ParseExtraHeaders(raw); err == nil {
+			t.Errorf("ParseExtraHeaders(%q) = %v, want an error", raw, got)
+		}
+	}
+}
+
+func TestAdvertiseHeaders(t *testing.T) {
+	apply := func(ad Advertisement, tls bool, resp Response) Response {
+		req := &Request{Method: "GET", Headers: map[string]string{}}
+		if tls {
+			req.TLSState = &TLSInfo{}
+		}
+		AdvertiseHeaders(ad)(req, &resp)
+		return resp
+	}
+	ok := func(headers map[string]string) Response {
+		return Response{Status: 200, Headers: headers}
+	}
+
+	resp := apply(Advertisement{}, false, ok(map[string]string{}))
+	if len(resp.Headers) != 0 {
+		t.Errorf("nothing configured: headers %v, want none added", resp.Headers)
+	}
+
+	resp = apply(Advertisement{AltSvc: `h2=":8443"; ma=86400`, Extra: map[string]string{"X-Frame-Options": "DENY"}}, false, ok(map[string]string{}))
+	if resp.Headers["Alt-Svc"] != `h2=":8443"; ma=86400` || resp.Headers["X-Frame-Options"] != "DENY" {
+		t.Errorf("Alt-Svc and extra headers: %v", resp.Headers)
+	}
+	if _, ok := resp.Headers["Upgrade"]; ok {
+		t.Errorf("Upgrade sent without H2C: %v", resp.Headers)
+	}
+
+	resp = apply(Advertisement{AltSvc: "clear", Extra: map[string]string{"X-Frame-Options": "DENY"}}, false,
+		ok(map[string]string{"alt-svc": `h3=":443"`, "x-frame-options": "SAMEORIGIN"}))
+	if resp.Headers["alt-svc"] != `h3=":443"` || resp.Headers["x-frame-options"] != "SAMEORIGIN" || len(resp.Headers) != 2 {
+		t.Errorf("handler headers: %v, want the handler's own values kept", resp.Headers)
+	}
+
+	h2c := Advertisement{H2C: true}
+	for _, tt := range []struct {
+		name       string
+		tls        bool
+		resp       Response
+		upgrade    string
+		connection string
+	}{
+		{"plaintext", false, ok(map[string]string{}), "h2c", "Upgrade"},
+		{"plaintext keep-alive", false, ok(map[string]string{"Connection": "keep-alive"}), "h2c", "keep-alive, Upgrade"},
+		{"TLS", true, ok(map[string]string{}), "", ""},
+		{"switching protocols", false, Response{Status: 101, Headers: map[string]string{"Upgrade": "websocket", "Connection": "Upgrade"}}, "websocket", "Upgrade"},
+		{"handler's own Upgrade", false, ok(map[string]string{"Upgrade": "TLS/1.2", "Connection": "Upgrade"}), "TLS/1.2", "Upgrade"},
+	} {
+		resp := apply(h2c, tt.tls, tt.resp)
+		if resp.Headers["Upgrade"] != tt.upgrade || resp.Headers["Connection"] != tt.connection {
+			t.Errorf("H2C, %s: Upgrade %q, Connection %q; want %q, %q",
+				tt.name, resp.Headers["Upgrade"], resp.Headers["Connection"], tt.upgrade, tt.connection)
+		}
+	}
+}
+
+func TestServeAdvertisement(t *testing.T) {
+	_, addr := startServer(t)
+	if resp := roundTrip(t, dial(t, addr), "GET", "/", nil, nil); resp.Header("Alt-Svc") != "" || resp.Header("Upgrade") != "" {
+		t.Errorf("unconfigured: Alt-Svc %q, Upgrade %q; want neither", resp.Header("Alt-Svc"), resp.Header("Upgrade"))
+	}
+
+	t.Setenv("ALT_SVC", `h2=":8443"; ma=86400`)
+	t.Setenv("H2C_ADVERTISE", "true")
+	t.Setenv("EXTRA_RESPONSE_HEADERS", "X-Frame-Options=DENY")
+	_, addr = startServer(t)
+	c := dial(t, addr)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+	for range 2 {
+		resp := roundTrip(t, c, "GET", "/", keepAlive, nil)
+		if resp.Header("Alt-Svc") != `h2=":8443"; ma=86400` || resp.Header("X-Frame-Options") != "DENY" || resp.Header("Upgrade") != "h2c" {
+			t.Errorf("configured: Alt-Svc %q, X-Frame-Options %q, Upgrade %q", resp.Header("Alt-Svc"), resp.Header("X-Frame-Options"), resp.Header("Upgrade"))
+		}
+		// Advertising the upgrade must not end the keep-alive connection.
+		if got := resp.Header("Connection"); !strings.Contains(got, "keep-alive") || !strings.Contains(got, "Upgrade") {
+			t.Errorf("Connection = %q, want keep-alive and Upgrade", got)
+		}
+	}
+}
+
+func TestAdvertisementConfigValidation(t *testing.T) {
+	for name, env := range map[string][2]string{
+		"extra header without value": {"EXTRA_RESPONSE_HEADERS", "X-Frame-Options"},
+		"extra header injection":     {"EXTRA_RESPONSE_HEADERS", "X-A=1\r\nSet-Cookie: a=b"},
+		"Alt-Svc injection":          {"ALT_SVC", "h2=\":8443\"\r\nX-Injected: 1"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(env[0], env[1])
+			if _, err := NewServerFromConfig(config.LoadConfig()); err == nil || !strings.Contains(err.Error(), env[0]) {
+				t.Errorf("NewServerFromConfig = %v, want an error naming %s", err, env[0])
+			}
+		})
+	}
+}
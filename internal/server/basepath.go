@@ -0,0 +1,53 @@
+package server
+
+import "strings"
+
+// SetBasePath mounts every route under prefix, e.g. "/svc/files-api", for
+// deployments behind a gateway that forwards paths unchanged. Incoming
+// paths must start with the prefix, which is stripped before matching;
+// other requests get 404. Handlers see the stripped path and can build
+// links with req.BasePath(). An empty prefix or "/" disables mounting.
+func (r *Router) SetBasePath(prefix string) {
+	r.basePath = strings.TrimRight(prefix, "/")
+	if r.basePath != "" && !strings.HasPrefix(r.basePath, "/") {
+		r.basePath = "/" + r.basePath
+	}
+}
+
+// BasePath returns the prefix the server is mounted under, without a
+// trailing slash, or "" if it is not mounted under one.
+func (r *Request) BasePath() string {
+	return r.basePath
+}
+
+// stripBasePath removes the router's base path from req.Path. It reports
+// false if the path lies outside the base path. Both "/base" and "/base/"
+// address the root route.
+func (r *Router) stripBasePath(req *Request) bool {
+	if r.basePath == "" {
+		return true
+	}
+	rest, ok := strings.CutPrefix(req.Path, r.basePath)
+	if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		return false
+	}
+	if rest == "" {
+		rest = "/"
+	}
+	req.Path = rest
+	req.basePath = r.basePath
+	return true
+}
+
+// prefixLocation prepends the base path to a root-relative Location header
+// so redirects issued by handlers stay inside the mount.
+func (r *Router) prefixLocation(resp *Response) {
+	if r.basePath == "" {
+		return
+	}
+	for k, v := range resp.Headers {
+		if strings.EqualFold(k, "Location") && strings.HasPrefix(v, "/") && !strings.HasPrefix(v, "//") {
+			resp.Headers[k] = r.basePath + v
+		}
+	}
+}
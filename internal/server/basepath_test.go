@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+// basePathRouter returns a router mounted under base with a root route, a
+// parameterized route echoing what its handler sees, and redirects to a
+// root-relative, a protocol-relative and an absolute location.
+func basePathRouter(base string) *Router {
+	r := NewRouter()
+	r.SetBasePath(base)
+	r.Handle("/", "GET", func(req *Request) Response { return textResponse("root " + req.BasePath()) })
+	r.Handle("/items/:id", "GET", func(req *Request) Response {
+		return textResponse(fmt.Sprintf("%s %s %s", req.Path, req.Params["id"], req.BasePath()))
+	})
+	redirect := func(location string) HandlerFunc {
+		return func(*Request) Response {
+			return Response{Version: HTTPVersion, Status: 302, Reason: "Found", Headers: map[string]string{"location": location}}
+		}
+	}
+	r.Handle("/go", "GET", redirect("/items/1"))
+	r.Handle("/away", "GET", redirect("//other.example/x"))
+	r.Handle("/abs", "GET", redirect("https://other.example/x"))
+	return r
+}
+
+func routeGET(r *Router, target string) Response {
+	req := &Request{Method: "GET", Headers: map[string]string{}}
+	req.setTarget(target)
+	return r.Route(req)
+}
+
+func TestBasePath(t *testing.T) {
+	for _, tt := range []struct {
+		name, setting, mount string
+	}{
+		{"not mounted", "", ""},
+		{"root only", "/", ""},
+		{"mounted", "/svc/api", "/svc/api"},
+		{"mounted, trailing slash and no leading one", "svc/api/", "/svc/api"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := basePathRouter(tt.setting)
+			for _, c := range []struct {
+				path     string
+				status   int
+				body     string
+				location string
+			}{
+				{"/items/7", 200, "/items/7 7 " + tt.mount, ""},
+				{"/items/7?x=1", 200, "/items/7 7 " + tt.mount, ""},
+				{"/", 200, "root " + tt.mount, ""},
+				{"/go", 302, "", tt.mount + "/items/1"},
+				{"/away", 302, "", "//other.example/x"},
+				{"/abs", 302, "", "https://other.example/x"},
+				{"/missing", 404, "", ""},
+			} {
+				resp := routeGET(r, tt.mount+c.path)
+				if resp.Status != c.status || (c.body != "" && string(resp.Body) != c.body) || resp.Headers["location"] != c.location {
+					t.Errorf("GET %s = %d %q Location %q, want %d %q %q",
+						tt.mount+c.path, resp.Status, resp.Body, resp.Headers["location"], c.status, c.body, c.location)
+				}
+			}
+		})
+	}
+}
+
+func TestBasePathOutsideMount(t *testing.T) {
+	r := basePathRouter("/svc/api")
+	if resp := routeGET(r, "/svc/api"); resp.Status != 200 || string(resp.Body) != "root /svc/api" {
+		t.Errorf("GET /svc/api = %d %q, want the root route", resp.Status, resp.Body)
+	}
+	for _, path := range []string{"/items/7", "/", "/svc", "/svc/apix/items/7", "/other/svc/api/items/7", "/SVC/API/items/7"} {
+		if resp := routeGET(r, path); resp.Status != 404 {
+			t.Errorf("GET %s = %d %q, want 404 outside the base path", path, resp.Status, resp.Body)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// Batch modes, selected with the "mode" query parameter of the batch
+// endpoint.
+const (
+	// BatchIndependent runs every sub-request whatever the others return.
+	// It is the default.
+	BatchIndependent = "independent"
+
+	// BatchSequential stops at the first sub-request answered with a 4xx
+	// or 5xx status; the ones after it are skipped with 424.
+	BatchSequential = "sequential"
+)
+
+// batchAuthHeaders are copied from the batch request onto sub-requests
+// that do not set them, so sub-requests run as the same client.
+var batchAuthHeaders = []string{"authorization", "cookie", "host"}
+
+// batchForwardedHeaders are always those of the batch request: a trusted
+// proxy vouched for them there, while a sub-request's own headers come
+// from the client and would let it pick its ClientIP.
+var batchForwardedHeaders = []string{"forwarded", "x-forwarded-for", "x-forwarded-host", "x-forwarded-proto"}
+
+// BatchItem describes one sub-request of a batch. A body that is not
+// text is sent base64-encoded in BodyBase64 instead of Body.
+type BatchItem struct {
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       string            `json:"body,omitempty"`
+	BodyBase64 string            `json:"body_base64,omitempty"`
+}
+
+// BatchResult is the outcome of one sub-request. Body holds a UTF-8 body,
+// BodyBase64 any other. Error explains a sub-request that was refused or
+// skipped without reaching its handler.
+type BatchResult struct {
+	Status     int               `json:"status"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       string            `json:"body,omitempty"`
+	BodyBase64 string            `json:"body_base64,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// Unbatchable excludes the route from the batch endpoint, for routes that
+// stream their response or must not be nested in a batch.
+func (rt *Route) Unbatchable() *Route {
+	rt.unbatchable = true
+	return rt
+}
+
+// BatchHandler returns a handler that runs a JSON array of BatchItem
+// through router in one round trip and answers with a JSON array of
+// BatchResult in the same order. Sub-requests go through the router's
+// middleware, pre-body checks and rate limits as if sent on their own.
+// They carry the batch request's Authorization, Cookie and Host headers,
+// unless they set their own, its Forwarded and X-Forwarded-* headers,
+// which they cannot override, and its request-scoped values, such as the
+// principal. See BatchIndependent and BatchSequential for the modes.
+//
+// A batch of more than maxRequests items is refused with 413. Routes
+// marked Unbatchable, which must include the batch route itself, and
+// responses that turn out to stream are answered with 422 per item.
+func BatchHandler(router *Router, maxRequests int) HandlerFunc {
+	return func(req *Request) Response {
+		mode, _, err := req.queryParam("mode")
+		if err != nil {
+			return BadRequestErrorResponse(err)
+		}
+		switch mode {
+		case "":
+			mode = BatchIndependent
+		case BatchIndependent, BatchSequential:
+		default:
+			return BadRequestErrorResponse(&ParamError{Source: "query", Name: "mode", Value: mode, Err: ErrParamInvalid, Reason: "must be independent or sequential"})
+		}
+
+		var items []BatchItem
+		if err := json.Unmarshal(req.Body, &items); err != nil {
+			return BadRequestErrorResponse(fmt.Errorf("invalid batch: %w", err))
+		}
+		if len(items) == 0 {
+			return BadRequestErrorResponse(fmt.Errorf("empty batch"))
+		}
+		if len(items) > maxRequests {
+			return NewHTTPError(413, fmt.Sprintf("batch has %d requests, at most %d are allowed", len(items), maxRequests)).Response()
+		}
+
+		results := make([]BatchResult, len(items))
+		failed := false
+		for i, item := range items {
+			if failed {
+				results[i] = BatchResult{Status: 424, Error: "skipped after an earlier request failed"}
+				continue
+			}
+			results[i] = runBatchItem(router, req, item)
+			failed = mode == BatchSequential && results[i].Status >= 400
+		}
+		Metrics.Counter("batch_requests_total").Inc()
+		Metrics.Counter("batch_subrequests_total").Add(int64(len(items)))
+		return jsonNoStore(results)
+	}
+}
+
+// runBatchItem runs one sub-request of the batch request outer.
+func runBatchItem(router *Router, outer *Request, item BatchItem) BatchResult {
+	sub, err := newBatchRequest(outer, item)
+	if err != nil {
+		return BatchResult{Status: 400, Error: err.Error()}
+	}
+	ctx, cancel := context.WithCancel(outer.Context())
+	defer cancel()
+	sub.ctx = ctx
+
+	route, errResp := router.resolve(sub)
+	if errResp != nil {
+		return batchResult(sub, *errResp)
+	}
+	if route.unbatchable {
+		return BatchResult{Status: 422, Error: fmt.Sprintf("%s %s cannot be batched", sub.Method, item.Path)}
+	}
+
+	resp := router.Route(sub)
+	if resp.StreamFunc != nil {
+		// Let the stream release what it holds, such as a bulkhead slot,
+		// now that its context is cancelled.
+		cancel()
+		go resp.StreamFunc(io.Discard)
+		return BatchResult{Status: 422, Error: fmt.Sprintf("%s %s streams its response and cannot be batched", sub.Method, item.Path)}
+	}
+	return batchResult(sub, resp)
+}
+
+// newBatchRequest builds the sub-request for item, inheriting the
+// connection details, authentication and values of outer.
+func newBatchRequest(outer *Request, item BatchItem) (*Request, error) {
+	if !strings.HasPrefix(item.Path, "/") {
+		return nil, fmt.Errorf("path %q must start with /", item.Path)
+	}
+	method := item.Method
+	if method == "" {
+		method = "GET"
+	}
+	body := []byte(item.Body)
+	if item.BodyBase64 != "" {
+		if item.Body != "" {
+			return nil, fmt.Errorf("body and body_base64 are mutually exclusive")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(item.BodyBase64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body_base64: %w", err)
+		}
+		body = decoded
+	}
+
+	sub := &Request{
+		Method:         strings.ToUpper(method),
+		Version:        outer.Version,
+		Headers:        make(map[string]string, len(item.Headers)+len(batchAuthHeaders)),
+		Body:           body,
+		RemoteAddr:     outer.RemoteAddr,
+		TLSState:       outer.TLSState,
+		trusted:        outer.trusted,
+		trustedProxies: outer.trustedProxies,
+		tasks:          outer.tasks,
+		values:         maps.Clone(outer.values),
+	}
+	sub.setTarget(item.Path)
+	for name, value := range item.Headers {
+		sub.Headers[strings.ToLower(name)] = value
+	}
+	for _, name := range batchAuthHeaders {
+		if _, ok := sub.Headers[name]; !ok && outer.Headers[name] != "" {
+			sub.Headers[name] = outer.Headers[name]
+		}
+	}
+	for _, name := range batchForwardedHeaders {
+		if value, ok := outer.Headers[name]; ok {
+			sub.Headers[name] = value
+		} else {
+			delete(sub.Headers, name)
+		}
+	}
+	if len(body) > 0 {
+		sub.Headers["content-length"] = fmt.Sprint(len(body))
+	} else {
+		delete(sub.Headers, "content-length")
+	}
+	utils.Debug("Batch sub-request: %s %s", sub.Method, sub.Path)
+	return sub, nil
+}
+
+// batchResult converts a buffered response to sub into its batch result.
+// Like the connection handler, it answers HEAD without the body but with
+// the Content-Length GET would send, see stripHEADBody.
+func batchResult(sub *Request, resp Response) BatchResult {
+	if sub.Method == "HEAD" {
+		stripHEADBody(&resp)
+		if resp.ContentLength > 0 && statusAllowsBody(resp.Status) {
+			resp.Headers = maps.Clone(resp.Headers)
+			if resp.Headers == nil {
+				resp.Headers = map[string]string{}
+			}
+			resp.Headers["Content-Length"] = strconv.FormatInt(resp.ContentLength, 10)
+		}
+	}
+	result := BatchResult{Status: resp.Status, Headers: resp.Headers}
+	if utf8.Valid(resp.Body) {
+		result.Body = string(resp.Body)
+	} else {
+		result.BodyBase64 = base64.StdEncoding.EncodeToString(resp.Body)
+	}
+	return result
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+// batchRouter serves a few routes and the batch endpoint, limited to
+// maxRequests items, at /batch.
+func batchRouter(maxRequests int) *Router {
+	r := NewRouter()
+	r.Handle("/echo", "POST", func(req *Request) Response {
+		return textResponse(string(req.Body))
+	})
+	r.Handle("/bytes", "GET", func(req *Request) Response {
+		resp := textResponse("")
+		resp.Body = []byte{0xff, 0x00, 0xfe}
+		return resp
+	})
+	r.Handle("/whoami", "GET", func(req *Request) Response {
+		return textResponse(req.Headers["authorization"] + "|" + req.Headers["x-forwarded-for"])
+	})
+	r.Handle("/fail", "GET", func(req *Request) Response {
+		return NewHTTPError(500, "broken").Response()
+	})
+	r.Handle("/stream", "GET", func(req *Request) Response {
+		return Response{
+			Version:    HTTPVersion,
+			Status:     200,
+			Reason:     "OK",
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			StreamFunc: func(w io.Writer) error { _, err := io.WriteString(w, "data"); return err },
+		}
+	})
+	r.Handle("/events", "GET", func(req *Request) Response { return textResponse("events") }).Unbatchable()
+	r.Handle("/batch", "POST", BatchHandler(r, maxRequests)).Unbatchable()
+	return r
+}
+
+// runBatch posts items to the batch endpoint of r at target, with the
+// given outer headers, and returns the status and decoded results.
+func runBatch(t *testing.T, r *Router, target string, headers map[string]string, items any) (int, []BatchResult) {
+	t.Helper()
+	body, err := json.Marshal(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &Request{Method: "POST", Version: HTTPVersion, Headers: map[string]string{}, Body: body}
+	for name, value := range headers {
+		req.Headers[name] = value
+	}
+	req.setTarget(target)
+	resp := r.Route(req)
+	if resp.Status != 200 {
+		return resp.Status, nil
+	}
+	var results []BatchResult
+	if err := json.Unmarshal(resp.Body, &results); err != nil {
+		t.Fatalf("decoding %s: %v", resp.Body, err)
+	}
+	return resp.Status, results
+}
+
+func TestBatchPerItemStatus(t *testing.T) {
+	r := batchRouter(20)
+	status, results := runBatch(t, r, "/batch", nil, []BatchItem{
+		{Method: "POST", Path: "/echo", Body: "hello"},
+		{Path: "/missing"},
+		{Method: "DELETE", Path: "/echo"},
+		{Path: "/fail"},
+		{Path: "no-slash"},
+		{Method: "post", Path: "/echo", BodyBase64: base64.StdEncoding.EncodeToString([]byte("from base64"))},
+		{Method: "POST", Path: "/echo", Body: "a", BodyBase64: "Yg=="},
+		{Method: "POST", Path: "/echo", BodyBase64: "%%%"},
+		{Path: "/bytes"},
+	})
+	if status != 200 {
+		t.Fatalf("batch status = %d, want 200", status)
+	}
+	want := []struct {
+		status int
+		body   string // body, or the error for a refused item
+	}{
+		{200, "hello"},
+		{404, ""},
+		{405, ""},
+		{500, ""},
+		{400, "must start with /"},
+		{200, "from base64"},
+		{400, "mutually exclusive"},
+		{400, "invalid body_base64"},
+		{200, ""},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, w := range want {
+		got := results[i]
+		if got.Status != w.status {
+			t.Errorf("item %d: status %d, want %d", i, got.Status, w.status)
+		}
+		if w.body != "" && !strings.Contains(got.Body+got.Error, w.body) {
+			t.Errorf("item %d: body %q error %q, want %q", i, got.Body, got.Error, w.body)
+		}
+	}
+	if got := results[8].BodyBase64; got != base64.StdEncoding.EncodeToString([]byte{0xff, 0x00, 0xfe}) {
+		t.Errorf("binary body_base64 = %q", got)
+	}
+}
+
+func TestBatchHEAD(t *testing.T) {
+	r := batchRouter(20)
+	r.Handle("/page", "GET", func(req *Request) Response { return textResponse("twelve bytes") })
+	_, results := runBatch(t, r, "/batch", nil, []BatchItem{{Method: "HEAD", Path: "/page"}})
+	if len(results) != 1 || results[0].Status != 200 || results[0].Body != "" {
+		t.Fatalf("HEAD result = %+v, want 200 without a body", results)
+	}
+	if got := results[0].Headers["Content-Length"]; got != "12" {
+		t.Errorf("HEAD Content-Length = %q, want 12", got)
+	}
+}
+
+func TestBatchSequential(t *testing.T) {
+	r := batchRouter(20)
+	items := []BatchItem{
+		{Method: "POST", Path: "/echo", Body: "one"},
+		{Path: "/fail"},
+		{Method: "POST", Path: "/echo", Body: "three"},
+	}
+
+	_, results := runBatch(t, r, "/batch?mode=sequential", nil, items)
+	for i, want := range []int{200, 500, 424} {
+		if results[i].Status != want {
+			t.Errorf("sequential item %d: status %d, want %d", i, results[i].Status, want)
+		}
+	}
+	if results[2].Error == "" {
+		t.Error("skipped item has no error")
+	}
+
+	_, results = runBatch(t, r, "/batch?mode=independent", nil, items)
+	if results[2].Status != 200 || results[2].Body != "three" {
+		t.Errorf("independent item 2 = %+v, want it run", results[2])
+	}
+
+	if status, _ := runBatch(t, r, "/batch?mode=eventually", nil, items); status != 400 {
+		t.Errorf("unknown mode: status %d, want 400", status)
+	}
+}
+
+func TestBatchUnbatchable(t *testing.T) {
+	r := batchRouter(20)
+	_, results := runBatch(t, r, "/batch", nil, []BatchItem{
+		{Path: "/events"},
+		{Method: "POST", Path: "/batch", Body: "[]"},
+		{Path: "/stream"},
+	})
+	for i, want := range []string{"cannot be batched", "cannot be batched", "streams its response"} {
+		if results[i].Status != 422 || !strings.Contains(results[i].Error, want) {
+			t.Errorf("item %d = %+v, want 422 %q", i, results[i], want)
+		}
+	}
+}
+
+func TestBatchLimits(t *testing.T) {
+	r := batchRouter(2)
+	item := BatchItem{Method: "POST", Path: "/echo", Body: "x"}
+
+	if status, results := runBatch(t, r, "/batch", nil, []BatchItem{item, item}); status != 200 || len(results) != 2 {
+		t.Errorf("batch at the limit: status %d, %d results", status, len(results))
+	}
+	if status, _ := runBatch(t, r, "/batch", nil, []BatchItem{item, item, item}); status != 413 {
+		t.Errorf("batch over the limit: status %d, want 413", status)
+	}
+	if status, _ := runBatch(t, r, "/batch", nil, []BatchItem{}); status != 400 {
+		t.Errorf("empty batch: status %d, want 400", status)
+	}
+	if status, _ := runBatch(t, r, "/batch", nil, map[string]string{"method": "GET"}); status != 400 {
+		t.Errorf("batch that is not an array: status %d, want 400", status)
+	}
+}
+
+func TestBatchInheritsHeaders(t *testing.T) {
+	r := batchRouter(20)
+	outer := map[string]string{"authorization": "Bearer outer", "x-forwarded-for": "203.0.113.7"}
+	_, results := runBatch(t, r, "/batch", outer, []BatchItem{
+		{Path: "/whoami"},
+		{Path: "/whoami", Headers: map[string]string{"Authorization": "Bearer inner"}},
+		// A sub-request cannot pick its own forwarded address.
+		{Path: "/whoami", Headers: map[string]string{"X-Forwarded-For": "10.0.0.1"}},
+	})
+	for i, want := range []string{"Bearer outer|203.0.113.7", "Bearer inner|203.0.113.7", "Bearer outer|203.0.113.7"} {
+		if results[i].Body != want {
+			t.Errorf("item %d saw %q, want %q", i, results[i].Body, want)
+		}
+	}
+
+	_, results = runBatch(t, r, "/batch", nil, []BatchItem{
+		{Path: "/whoami", Headers: map[string]string{"X-Forwarded-For": "10.0.0.1"}},
+	})
+	if results[0].Body != "|" {
+		t.Errorf("sub-request without forwarding on the batch saw %q, want none", results[0].Body)
+	}
+}
+
+func TestServeBatch(t *testing.T) {
+	t.Setenv("BATCH_ENDPOINT", "true")
+	t.Setenv("BATCH_MAX_REQUESTS", "2")
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	keepAlive := map[string]string{"Connection": "keep-alive", "Content-Type": "application/json"}
+
+	resp := roundTrip(t, c, "POST", "/batch", keepAlive, []byte(`[{"path":"/healthz"},{"path":"/nowhere"}]`))
+	if resp.Status != 200 {
+		t.Fatalf("POST /batch = %d %s", resp.Status, resp.Body)
+	}
+	var results []BatchResult
+	if err := json.Unmarshal(resp.Body, &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Status != 200 || results[1].Status != 404 {
+		t.Errorf("results = %+v, want 200 and 404", results)
+	}
+
+	resp = roundTrip(t, c, "POST", "/batch", keepAlive, []byte(`[{"path":"/healthz"},{"path":"/healthz"},{"path":"/healthz"}]`))
+	if resp.Status != 413 {
+		t.Errorf("batch over BATCH_MAX_REQUESTS = %d, want 413", resp.Status)
+	}
+}
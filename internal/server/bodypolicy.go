@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// BodyAction is what the parser does with a request body for a method.
+type BodyAction int
+
+const (
+	// BodyAllow reads the body into Request.Body, up to MaxBodySize.
+	BodyAllow BodyAction = iota
+
+	// BodyDrain reads and discards a body of at most Limit bytes, so the
+	// handler sees an empty Body. Larger bodies are refused with 413.
+	BodyDrain
+
+	// BodyReject refuses a body larger than Limit without reading it: 400
+	// when Limit is 0, 413 otherwise. Smaller bodies are drained.
+	BodyReject
+)
+
+// BodyPolicy decides how requests of one method treat a declared body.
+type BodyPolicy struct {
+	Action BodyAction
+	Limit  int64
+}
+
+// defaultBodyLimit bounds drained and tolerated bodies for methods that
+// do not normally carry one.
+const defaultBodyLimit = 64 << 10
+
+// DefaultBodyPolicies lists the policy for methods that are not simply
+// allowed a body. Methods missing from the map use BodyAllow.
+var DefaultBodyPolicies = map[string]BodyPolicy{
+	"GET":     {Action: BodyReject, Limit: defaultBodyLimit},
+	"HEAD":    {Action: BodyReject, Limit: defaultBodyLimit},
+	"DELETE":  {Action: BodyDrain, Limit: defaultBodyLimit},
+	"OPTIONS": {Action: BodyDrain, Limit: defaultBodyLimit},
+}
+
+// BodyPoliciesFromConfig applies the BODY_POLICIES overrides to
+// DefaultBodyPolicies. An override without a limit keeps the default limit.
+func BodyPoliciesFromConfig(overrides map[string]config.BodyPolicyConfig) (map[string]BodyPolicy, error) {
+	policies := make(map[string]BodyPolicy, len(DefaultBodyPolicies)+len(overrides))
+	for method, policy := range DefaultBodyPolicies {
+		policies[method] = policy
+	}
+
+	for method, override := range overrides {
+		policy := BodyPolicy{Limit: defaultBodyLimit}
+		switch override.Action {
+		case "allow":
+			policy.Action = BodyAllow
+		case "drain":
+			policy.Action = BodyDrain
+		case "reject":
+			policy.Action = BodyReject
+		default:
+			return nil, fmt.Errorf("unknown body policy %q for %s", override.Action, method)
+		}
+		if override.Limit > 0 || override.Action == "reject" {
+			policy.Limit = override.Limit
+		}
+		policies[strings.ToUpper(method)] = policy
+	}
+	return policies, nil
+}
+
+// checkBodyPolicy checks a declared body of contentLength bytes against
+// the method's policy before any of it is read, and returns the policy.
+// Allowed bodies are capped at MaxBodySize.
+//
+// Returns:
+//   - BodyPolicy: The method's policy; BodyAllow if none is configured.
+//   - error: An *HTTPError if the body is refused. The body has not been
+//     read, so the connection must be closed after responding.
+func checkBodyPolicy(method string, contentLength int64, policies map[string]BodyPolicy) (BodyPolicy, error) {
+	policy, ok := policies[strings.ToUpper(method)]
+	if !ok {
+		policy = BodyPolicy{Action: BodyAllow}
+	}
+
+	if policy.Action == BodyAllow {
+		if contentLength > MaxBodySize {
+			utils.Warn("Request body too large: %d bytes", contentLength)
+			return policy, NewHTTPError(413, fmt.Sprintf("request body exceeds %d bytes", MaxBodySize))
+		}
+		return policy, nil
+	}
+
+	if contentLength > policy.Limit {
+		utils.Warn("Refusing %d byte body on %s (limit %d)", contentLength, method, policy.Limit)
+		if policy.Action == BodyReject && policy.Limit == 0 {
+			return policy, NewHTTPError(400, fmt.Sprintf("%s requests must not have a body", method))
+		}
+		return policy, NewHTTPError(413, fmt.Sprintf("%s request body exceeds %d bytes", method, policy.Limit))
+	}
+	return policy, nil
+}
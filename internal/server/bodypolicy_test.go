@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+)
+
+// next is a second request pipelined after the one under test; reading it
+// after the first shows how much of the first request's body was consumed.
+const next = "GET /next HTTP/1.1\r\nHost: x\r\n\r\n"
+
+func TestReadRequestBodyPolicies(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		head    string
+		body    string
+		want    string // Request.Body
+		status  int    // of the refusal, 0 when the request is accepted
+		drained bool   // the body was consumed, so next follows
+	}{
+		{"GET without a length", "GET / HTTP/1.1\r\nHost: x\r\n\r\n", "", "", 0, true},
+		{"GET with zero length", "GET / HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\n\r\n", "", "", 0, true},
+		{"HEAD with zero length", "HEAD / HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\n\r\n", "", "", 0, true},
+		{"DELETE with zero length", "DELETE /a HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\n\r\n", "", "", 0, true},
+		{"OPTIONS with zero length", "OPTIONS * HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\n\r\n", "", "", 0, true},
+		{"POST with zero length", "POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\n\r\n", "", "", 0, true},
+		{"POST with a body", "POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\n", "hello", "hello", 0, true},
+		{"GET with a small body", "GET / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\n", "hello", "", 0, true},
+		{"DELETE with a small body", "DELETE /a HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\n", "hello", "", 0, true},
+		{"GET declaring 5 MB", "GET / HTTP/1.1\r\nHost: x\r\nContent-Length: 5242880\r\n\r\n", "hello", "", 413, false},
+		{"HEAD declaring 5 MB", "HEAD / HTTP/1.1\r\nHost: x\r\nContent-Length: 5242880\r\n\r\n", "hello", "", 413, false},
+		{"DELETE declaring 5 MB", "DELETE /a HTTP/1.1\r\nHost: x\r\nContent-Length: 5242880\r\n\r\n", "hello", "", 413, false},
+		{"POST over MaxBodySize", "POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 1073741824\r\n\r\n", "hello", "", 413, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReader(strings.NewReader(tt.head + tt.body + next))
+			req, err := readRequestHead(reader, headOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = readRequestBody(reader, io.Discard, req, DefaultBodyPolicies)
+			var httpErr *HTTPError
+			switch {
+			case tt.status == 0 && err != nil:
+				t.Fatalf("readRequestBody = %v, want the request accepted", err)
+			case tt.status != 0 && (!errors.As(err, &httpErr) || httpErr.Status != tt.status):
+				t.Fatalf("readRequestBody = %v, want a %d", err, tt.status)
+			}
+			if string(req.Body) != tt.want {
+				t.Errorf("Body = %q, want %q", req.Body, tt.want)
+			}
+
+			rest, _ := io.ReadAll(reader)
+			if tt.drained && string(rest) != next {
+				t.Errorf("left %q unread, want the next request", rest)
+			}
+			if !tt.drained && string(rest) != tt.body+next {
+				t.Errorf("left %q unread, want the refused body untouched", rest)
+			}
+		})
+	}
+}
+
+func TestCheckBodyPolicyRejectWithoutLimit(t *testing.T) {
+	policies := map[string]BodyPolicy{"GET": {Action: BodyReject}}
+	if _, err := checkBodyPolicy("GET", 0, policies); err != nil {
+		t.Errorf("GET with Content-Length: 0 = %v, want it accepted", err)
+	}
+	var httpErr *HTTPError
+	if _, err := checkBodyPolicy("get", 1, policies); !errors.As(err, &httpErr) || httpErr.Status != 400 {
+		t.Errorf("GET with a 1 byte body = %v, want 400", err)
+	}
+}
+
+func TestBodyPoliciesFromConfig(t *testing.T) {
+	policies, err := BodyPoliciesFromConfig(map[string]config.BodyPolicyConfig{
+		"get":    {Action: "reject"},
+		"DELETE": {Action: "allow"},
+		"PATCH":  {Action: "drain"},
+		"POST":   {Action: "drain", Limit: 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for method, want := range map[string]BodyPolicy{
+		"GET":     {Action: BodyReject, Limit: 0},
+		"HEAD":    {Action: BodyReject, Limit: defaultBodyLimit},
+		"DELETE":  {Action: BodyAllow, Limit: defaultBodyLimit},
+		"OPTIONS": {Action: BodyDrain, Limit: defaultBodyLimit},
+		"PATCH":   {Action: BodyDrain, Limit: defaultBodyLimit},
+		"POST":    {Action: BodyDrain, Limit: 10},
+	} {
+		if got := policies[method]; got != want {
+			t.Errorf("policy for %s = %+v, want %+v", method, got, want)
+		}
+	}
+	if DefaultBodyPolicies["GET"].Limit != defaultBodyLimit {
+		t.Error("overrides changed DefaultBodyPolicies")
+	}
+
+	if _, err := BodyPoliciesFromConfig(map[string]config.BodyPolicyConfig{"GET": {Action: "ignore"}}); err == nil {
+		t.Error("unknown action accepted")
+	}
+}
+
+func TestServeBodyPolicies(t *testing.T) {
+	deleted := make(chan string, 1)
+	_, addr := startServerWithRoutes(t, func(r *Router) {
+		r.Handle("/a", "DELETE", func(req *Request) Response {
+			deleted <- string(req.Body)
+			return textResponse("deleted")
+		})
+		r.Handle("/a", "GET", func(*Request) Response { return textResponse("a") })
+	})
+	c := dial(t, addr)
+
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+	resp := roundTrip(t, c, "DELETE", "/a", keepAlive, []byte("hello"))
+	if body := <-deleted; resp.Status != 200 || body != "" {
+		t.Fatalf("DELETE with a small body = %d, handler saw %q; want 200 with the body drained", resp.Status, body)
+	}
+
+	if err := c.SendRaw([]byte("GET /a HTTP/1.1\r\nHost: x\r\nContent-Length: 5242880\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.ReadResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != 413 {
+		t.Errorf("GET declaring 5 MB = %d, want 413 without sending the body", resp.Status)
+	}
+	if err := c.ExpectClose(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,311 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// DefaultBrokerBuffer is the number of events a subscriber may fall behind
+// before the oldest are dropped.
+const DefaultBrokerBuffer = 64
+
+// sseKeepAlive is how often an idle SSE stream sends a comment line, so
+// proxies keep it open and a departed client is noticed.
+const sseKeepAlive = 15 * time.Second
+
+// Event is a message published through a Broker and sent to SSE clients.
+type Event struct {
+	Topic string // set by Publish
+	ID    string // optional, sent as the SSE "id" field
+	Type  string // optional, sent as the SSE "event" field
+	Data  string
+}
+
+// Broker fans events out to subscribers in memory. Topics are
+// dot-separated, such as "files.written"; subscriptions may use "*" for
+// one segment, so "files.*" receives "files.written" and "files.deleted"
+// but not "files" or "files.a.b".
+//
+// Every subscriber has its own buffer of DefaultBrokerBuffer events. A
+// subscriber that falls further behind loses its oldest events, counted
+// in broker_events_dropped_total, so a slow client never holds up
+// publishers or other subscribers.
+type Broker struct {
+	mu     sync.Mutex
+	subs   map[*subscriber]struct{}
+	buffer int
+	closed bool
+}
+
+type subscriber struct {
+	pattern []string
+	mu      sync.Mutex // serializes sends, drops and close
+	ch      chan Event
+	closed  bool
+}
+
+// NewBroker returns an empty broker.
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[*subscriber]struct{}), buffer: DefaultBrokerBuffer}
+}
+
+// Subscribe returns a channel receiving the events published to topics
+// matching pattern, and the function ending the subscription, which
+// closes the channel. The channel is also closed when the broker is.
+func (b *Broker) Subscribe(pattern string) (<-chan Event, func()) {
+	sub := &subscriber{pattern: strings.Split(pattern, "."), ch: make(chan Event, b.buffer)}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		close(sub.ch)
+		return sub.ch, func() {}
+	}
+	b.subs[sub] = struct{}{}
+	Metrics.Gauge("broker_subscribers").Set(int64(len(b.subs)))
+	b.mu.Unlock()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, sub)
+			Metrics.Gauge("broker_subscribers").Set(int64(len(b.subs)))
+			b.mu.Unlock()
+			sub.close()
+		})
+	}
+}
+
+// Publish sends event to every subscriber of topic. It never blocks on a
+// subscriber.
+func (b *Broker) Publish(topic string, event Event) {
+	event.Topic = topic
+	segments := strings.Split(topic, ".")
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		if topicMatches(sub.pattern, segments) {
+			sub.send(event)
+		}
+	}
+	Metrics.Counter("broker_events_published_total").Inc()
+}
+
+// Close ends every subscription, closing the subscribers' channels, and
+// makes later subscriptions end at once. The server closes its broker
+// when it shuts down, see Server.SetBroker.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for sub := range b.subs {
+		sub.close()
+	}
+	utils.Info("Event broker closed, %d subscribers disconnected", len(b.subs))
+	clear(b.subs)
+	Metrics.Gauge("broker_subscribers").Set(0)
+}
+
+// send queues event, dropping the oldest queued event if the buffer is
+// full.
+func (s *subscriber) send(event Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	for {
+		select {
+		case s.ch <- event:
+			return
+		default:
+		}
+		select {
+		case <-s.ch:
+			Metrics.Counter("broker_events_dropped_total").Inc()
+		default:
+		}
+	}
+}
+
+func (s *subscriber) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
+
+// topicMatches reports whether the segments of a topic match those of a
+// subscription pattern, where "*" matches any one segment.
+func topicMatches(pattern, topic []string) bool {
+	if len(pattern) != len(topic) {
+		return false
+	}
+	for i := range pattern {
+		if pattern[i] != "*" && pattern[i] != topic[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SSEHandler returns a handler streaming the broker's events as
+// Server-Sent Events. Clients choose the topic pattern with the "topic"
+// query parameter, defaulting to defaultTopic. The subscription lasts as
+// long as the connection: it ends when the client disconnects, which the
+// periodic keep-alive comments detect on idle streams, or when the broker
+// is closed.
+func (b *Broker) SSEHandler(defaultTopic string) HandlerFunc {
+	return func(req *Request) Response {
+		topic, ok, err := req.queryParam("topic")
+		if err != nil {
+			return BadRequestErrorResponse(err)
+		}
+		if !ok || topic == "" {
+			topic = defaultTopic
+		}
+		return Response{
+			Version: HTTPVersion,
+			Status:  200,
+			Reason:  "OK",
+			Headers: map[string]string{
+				"Content-Type":  "text/event-stream",
+				"Cache-Control": "no-cache",
+			},
+			StreamFunc: func(w io.Writer) error {
+				events, cancel := b.Subscribe(topic)
+				defer cancel()
+				return streamSSE(req, w, events)
+			},
+		}
+	}
+}
+
+// streamSSE writes events to w until the channel is closed or the request
+// context ends.
+func streamSSE(req *Request, w io.Writer, events <-chan Event) error {
+	flusher, _ := w.(interface{ Flush() error })
+	flush := func() error {
+		if flusher == nil {
+			return nil
+		}
+		return flusher.Flush()
+	}
+	// Send the headers and a first line right away, so the client knows
+	// the subscription is live.
+	if _, err := io.WriteString(w, ": subscribed\n\n"); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := WriteSSE(w, event); err != nil {
+				return err
+			}
+		case <-keepAlive.C:
+			// A stalled client has data waiting already; queueing a
+			// keep-alive behind it serves no purpose.
+			if sw, ok := AsStreamWriter(w); ok && sw.Stalled() {
+				continue
+			}
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return err
+			}
+		case <-req.Context().Done():
+			return nil
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// WriteSSE writes event to w in the Server-Sent Events format. Multi-line
+// data is split into one "data" field per line.
+func WriteSSE(w io.Writer, event Event) error {
+	var sb strings.Builder
+	if event.ID != "" {
+		fmt.Fprintf(&sb, "id: %s\n", sseField(event.ID))
+	}
+	if event.Type != "" {
+		fmt.Fprintf(&sb, "event: %s\n", sseField(event.Type))
+	}
+	for _, line := range strings.Split(strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(event.Data), "\n") {
+		fmt.Fprintf(&sb, "data: %s\n", line)
+	}
+	sb.WriteString("\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// sseField strips line breaks, which would end a single-line field early.
+func sseField(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// SetBroker registers b as the server's event broker, closed by Shutdown
+// as soon as draining starts so SSE streams end instead of holding their
+// connections open until the drain deadline.
+func (s *Server) SetBroker(b *Broker) {
+	s.broker = b
+}
+
+// PublishFileEvents wraps a files handler so successful writes and deletes
+// publish "files.written" and "files.deleted" events to b, with the
+// request path as data.
+func PublishFileEvents(b *Broker, next HandlerFunc) HandlerFunc {
+	return func(req *Request) Response {
+		resp := next(req)
+		if resp.Status < 200 || resp.Status >= 300 {
+			return resp
+		}
+		switch req.Method {
+		case "PUT", "POST":
+			b.Publish("files.written", Event{Type: "written", Data: req.Path})
+		case "DELETE":
+			b.Publish("files.deleted", Event{Type: "deleted", Data: req.Path})
+		}
+		return resp
+	}
+}
+
+// handleAdminEvents handles POST /admin/events, publishing the JSON
+// {"topic": ..., "type": ..., "id": ..., "data": ...} to the broker.
+func (s *Server) handleAdminEvents(req *Request) Response {
+	var msg struct {
+		Topic string `json:"topic"`
+		Type  string `json:"type"`
+		ID    string `json:"id"`
+		Data  string `json:"data"`
+	}
+	if err := json.Unmarshal(req.Body, &msg); err != nil {
+		return BadRequestErrorResponse(fmt.Errorf("invalid event: %w", err))
+	}
+	if msg.Topic == "" || strings.Contains(msg.Topic, "*") {
+		return BadRequestErrorResponse(errors.New("topic is required and must not contain wildcards"))
+	}
+	s.broker.Publish(msg.Topic, Event{Type: msg.Type, ID: msg.ID, Data: msg.Data})
+	utils.Info("Admin published event to %s", msg.Topic)
+	return Response{Version: HTTPVersion, Status: 202, Reason: "Accepted", Headers: map[string]string{}}
+}
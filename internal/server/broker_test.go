@@ -0,0 +1,254 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// receive returns the next event on ch, failing t if none arrives.
+func receive(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+	select {
+	case event, ok := <-ch:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return Event{}
+	}
+}
+
+// expectNone fails t if ch holds an event.
+func expectNone(t *testing.T, ch <-chan Event) {
+	t.Helper()
+	select {
+	case event, ok := <-ch:
+		if ok {
+			t.Errorf("received %+v, want nothing", event)
+		}
+	default:
+	}
+}
+
+func TestBrokerFanOut(t *testing.T) {
+	b := NewBroker()
+	defer b.Close()
+	var subs []<-chan Event
+	for range 3 {
+		ch, cancel := b.Subscribe("files.written")
+		defer cancel()
+		subs = append(subs, ch)
+	}
+	other, cancel := b.Subscribe("files.deleted")
+	defer cancel()
+
+	b.Publish("files.written", Event{ID: "1", Data: "/a.txt"})
+	for i, ch := range subs {
+		if got := receive(t, ch); got != (Event{Topic: "files.written", ID: "1", Data: "/a.txt"}) {
+			t.Errorf("subscriber %d got %+v", i, got)
+		}
+	}
+	expectNone(t, other)
+}
+
+func TestBrokerWildcards(t *testing.T) {
+	tests := []struct {
+		pattern, topic string
+		want           bool
+	}{
+		{"files.*", "files.written", true},
+		{"files.*", "files.deleted", true},
+		{"files.*", "files", false},
+		{"files.*", "files.a.b", false},
+		{"*.written", "files.written", true},
+		{"*", "files", true},
+		{"*", "files.written", false},
+		{"files.written", "files.written", true},
+		{"files.written", "files.writtenx", false},
+		{"files.*.done", "files.upload.done", true},
+	}
+	for _, tt := range tests {
+		b := NewBroker()
+		ch, _ := b.Subscribe(tt.pattern)
+		b.Publish(tt.topic, Event{Data: "x"})
+		b.Close()
+		_, got := <-ch
+		if got != tt.want {
+			t.Errorf("pattern %q, topic %q: received = %v, want %v", tt.pattern, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestBrokerSlowSubscriberDropsOldest(t *testing.T) {
+	b := NewBroker()
+	defer b.Close()
+	slow, cancel := b.Subscribe("ticks")
+	defer cancel()
+	fast, cancel := b.Subscribe("ticks")
+	defer cancel()
+
+	dropped := Metrics.Counter("broker_events_dropped_total")
+	before := dropped.Value()
+	const extra = 10
+	// Publish never waits for the slow subscriber, which does not read, and
+	// does not hold back the one keeping up.
+	for i := range DefaultBrokerBuffer + extra {
+		b.Publish("ticks", Event{ID: fmt.Sprint(i)})
+		if got := receive(t, fast); got.ID != fmt.Sprint(i) {
+			t.Fatalf("reading subscriber got event %s, want %d", got.ID, i)
+		}
+	}
+	if got := dropped.Value() - before; got != extra {
+		t.Errorf("broker_events_dropped_total grew by %d, want %d", got, extra)
+	}
+	// The slow subscriber keeps the newest events, in order.
+	if len(slow) != DefaultBrokerBuffer {
+		t.Fatalf("slow subscriber holds %d events, want %d", len(slow), DefaultBrokerBuffer)
+	}
+	for i := extra; i < DefaultBrokerBuffer+extra; i++ {
+		if got := receive(t, slow); got.ID != fmt.Sprint(i) {
+			t.Fatalf("slow subscriber got event %s, want %d", got.ID, i)
+		}
+	}
+}
+
+func TestBrokerUnsubscribe(t *testing.T) {
+	b := NewBroker()
+	defer b.Close()
+	subscribers := Metrics.Gauge("broker_subscribers")
+	ch, cancel := b.Subscribe("files.*")
+	kept, cancelKept := b.Subscribe("files.*")
+	defer cancelKept()
+	if got := subscribers.Value(); got != 2 {
+		t.Errorf("broker_subscribers = %d, want 2", got)
+	}
+
+	cancel()
+	cancel() // a second call does nothing
+	if _, ok := <-ch; ok {
+		t.Error("channel still open after unsubscribing")
+	}
+	if got := subscribers.Value(); got != 1 {
+		t.Errorf("broker_subscribers = %d after unsubscribing, want 1", got)
+	}
+	b.Publish("files.written", Event{Data: "/a"})
+	if got := receive(t, kept); got.Data != "/a" {
+		t.Errorf("remaining subscriber got %+v", got)
+	}
+}
+
+func TestBrokerClose(t *testing.T) {
+	b := NewBroker()
+	ch, cancel := b.Subscribe("files.*")
+	b.Close()
+	b.Close()
+	if _, ok := <-ch; ok {
+		t.Error("channel still open after Close")
+	}
+	cancel() // unsubscribing after Close does nothing
+	if got := Metrics.Gauge("broker_subscribers").Value(); got != 0 {
+		t.Errorf("broker_subscribers = %d after Close, want 0", got)
+	}
+
+	late, _ := b.Subscribe("files.*")
+	if _, ok := <-late; ok {
+		t.Error("subscription after Close is open")
+	}
+	b.Publish("files.written", Event{}) // must not panic on closed channels
+}
+
+func TestWriteSSE(t *testing.T) {
+	tests := []struct {
+		event Event
+		want  string
+	}{
+		{Event{Data: "hello"}, "data: hello\n\n"},
+		{Event{ID: "7", Type: "written", Data: "/a"}, "id: 7\nevent: written\ndata: /a\n\n"},
+		{Event{Data: "one\r\ntwo\rthree\nfour"}, "data: one\ndata: two\ndata: three\ndata: four\n\n"},
+		{Event{ID: "a\nb", Type: "x\r\ny"}, "id: ab\nevent: xy\ndata: \n\n"},
+	}
+	for _, tt := range tests {
+		var sb strings.Builder
+		if err := WriteSSE(&sb, tt.event); err != nil {
+			t.Fatal(err)
+		}
+		if sb.String() != tt.want {
+			t.Errorf("WriteSSE(%+v) = %q, want %q", tt.event, sb.String(), tt.want)
+		}
+	}
+}
+
+// readUntil reads lines from reader until one starts with prefix.
+func readUntil(t *testing.T, reader *bufio.Reader, prefix string) {
+	t.Helper()
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("waiting for %q: %v", prefix, err)
+		}
+		if strings.HasPrefix(line, prefix) {
+			return
+		}
+	}
+}
+
+func TestServeSSE(t *testing.T) {
+	b := NewBroker()
+	srv, addr := startServerWithRoutes(t, func(r *Router) {
+		r.Handle("/events", "GET", b.SSEHandler("files.*"))
+	})
+	srv.SetBroker(b)
+	subscribers := Metrics.Gauge("broker_subscribers")
+
+	subscribe := func(t *testing.T, target string) *bufio.Reader {
+		_, reader := sendHead(t, addr, "GET "+target+" HTTP/1.1\r\nHost: x\r\n\r\n")
+		readUntil(t, reader, ": subscribed")
+		return reader
+	}
+
+	t.Run("topics", func(t *testing.T) {
+		files := subscribe(t, "/events")
+		admin := subscribe(t, "/events?topic=admin.*")
+		b.Publish("admin.notice", Event{Type: "notice", Data: "maintenance at noon"})
+		b.Publish("files.written", Event{Type: "written", Data: "/a.txt"})
+		readUntil(t, files, "data: /a.txt")
+		readUntil(t, admin, "data: maintenance at noon")
+	})
+
+	t.Run("client disconnect unsubscribes", func(t *testing.T) {
+		waitFor(t, "earlier streams to end", func() bool { return subscribers.Value() == 0 })
+		conn, reader := sendHead(t, addr, "GET /events HTTP/1.1\r\nHost: x\r\n\r\n")
+		readUntil(t, reader, ": subscribed")
+		if got := subscribers.Value(); got != 1 {
+			t.Fatalf("broker_subscribers = %d, want 1", got)
+		}
+		conn.Close()
+		waitFor(t, "the subscription to end", func() bool { return subscribers.Value() == 0 })
+	})
+
+	t.Run("shutdown ends streams", func(t *testing.T) {
+		reader := subscribe(t, "/events")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done := make(chan ShutdownReport, 1)
+		go func() { done <- srv.Shutdown(ctx, "test") }()
+
+		// The stream ends with the last chunk rather than at the deadline.
+		readUntil(t, reader, "0\r")
+		if _, err := reader.ReadString('\n'); err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		select {
+		case <-done:
+		case <-time.After(4 * time.Second):
+			t.Fatal("Shutdown waited on an SSE stream")
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"github.com/Abb133Se/httpServer/internal/config"
+	"github.com/Abb133Se/httpServer/internal/utils"
+	"github.com/Abb133Se/httpServer/internal/version"
+)
+
+// serverProduct is the product token sent in the Server header.
+const serverProduct = "httpServer"
+
+// ServerHeader returns a post-processor that sets "Server: httpServer/<version>"
+// on responses whose handler did not set its own Server header.
+func ServerHeader() PostProcessor {
+	value := serverProduct + "/" + version.Get().Version
+	return func(req *Request, resp *Response) {
+		if resp.Headers == nil {
+			resp.Headers = make(map[string]string)
+		}
+		if _, ok := resp.Headers["Server"]; !ok {
+			resp.Headers["Server"] = value
+		}
+	}
+}
+
+// effectiveLimits is the subset of the configuration reported by the
+// startup banner and /admin/config. Durations are rendered as strings.
+type effectiveLimits struct {
+	ReadTimeout       string `json:"read_timeout"`
+	WriteTimeout      string `json:"write_timeout"`
+	IdleTimeout       string `json:"idle_timeout"`
+	ConnectionTimeout string `json:"connection_timeout"`
+	MaxRequestPerConn int    `json:"max_requests_per_conn"`
+	MaxResponseSize   int64  `json:"max_response_size"`
+	QueryMaxParams    int    `json:"query_max_params"`
+	QueryMaxLength    int    `json:"query_max_length"`
+	RateLimit         int    `json:"rate_limit"`
+	RateLimitGlobal   int    `json:"rate_limit_global"`
+	RateLimitWindow   string `json:"rate_limit_window"`
+	GenerateMaxBytes  int64  `json:"generate_max_bytes"`
+}
+
+func limitsFromConfig(cfg *config.Config) effectiveLimits {
+	return effectiveLimits{
+		ReadTimeout:       cfg.ReadTimeout.String(),
+		WriteTimeout:      cfg.WriteTimeout.String(),
+		IdleTimeout:       cfg.IdleTimeout.String(),
+		ConnectionTimeout: cfg.ConnectionTimeout.String(),
+		MaxRequestPerConn: cfg.MaxRequestPerConn,
+		MaxResponseSize:   cfg.MaxResponseSize,
+		QueryMaxParams:    cfg.QueryMaxParams,
+		QueryMaxLength:    cfg.QueryMaxLength,
+		RateLimit:         cfg.RateLimit,
+		RateLimitGlobal:   cfg.RateLimitGlobal,
+		RateLimitWindow:   cfg.RateLimitWindow.String(),
+		GenerateMaxBytes:  cfg.GenerateMaxBytes,
+	}
+}
+
+// logBanner logs what is starting and with which limits, so the first
+// lines of every log identify the build.
+func logBanner(addr string, cfg *config.Config) {
+	info := version.Get()
+	limits := limitsFromConfig(cfg)
+	utils.Info("httpServer %s go=%s os=%s arch=%s", info, info.GoVersion, info.OS, info.Arch)
+	utils.Info("  listen=%s tls=%t base_path=%q public_dir=%s",
+		addr, cfg.TLSCertFile != "" && cfg.TLSKeyFile != "", cfg.BasePath, getPublicDir())
+	utils.Info("  read_timeout=%s write_timeout=%s idle_timeout=%s connection_timeout=%s max_requests_per_conn=%d",
+		limits.ReadTimeout, limits.WriteTimeout, limits.IdleTimeout, limits.ConnectionTimeout, limits.MaxRequestPerConn)
+	utils.Info("  max_response_size=%d query_max_params=%d query_max_length=%d rate_limit=%d rate_limit_global=%d rate_limit_window=%s",
+		limits.MaxResponseSize, limits.QueryMaxParams, limits.QueryMaxLength, limits.RateLimit, limits.RateLimitGlobal, limits.RateLimitWindow)
+}
+
+// handleVersion handles "/version" with the build information as JSON.
+func handleVersion(req *Request) Response {
+	return jsonNoStore(version.Get())
+}
+
+// handleAdminConfig handles "/admin/config" with the build, the effective
+// limits and runtime switches such as files read-only mode. Secrets such
+// as ADMIN_TOKEN are never included.
+func (s *Server) handleAdminConfig(req *Request) Response {
+	return jsonNoStore(struct {
+		Version       version.Info    `json:"version"`
+		Port          string          `json:"port"`
+		BasePath      string          `json:"base_path"`
+		Limits        effectiveLimits `json:"limits"`
+		FilesReadOnly bool            `json:"files_read_only"`
+	}{
+		Version:       version.Get(),
+		Port:          s.config.Port,
+		BasePath:      s.config.BasePath,
+		Limits:        limitsFromConfig(s.config),
+		FilesReadOnly: s.filesReadOnly.Enabled(),
+	})
+}
+
+// jsonNoStore renders v as an uncacheable 200 JSON response.
+func jsonNoStore(v any) Response {
+	resp := JSONResponse(200, v)
+	if resp.Status == 200 {
+		resp.Headers["Cache-Control"] = "no-store"
+	}
+	return resp
+}
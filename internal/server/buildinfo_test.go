@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/Abb133Se/httpServer/internal/version"
+)
+
+func TestVersionEndpointOptIn(t *testing.T) {
+	_, addr := startServer(t)
+	if resp := roundTrip(t, dial(t, addr), "GET", "/version", nil, nil); resp.Status != 404 {
+		t.Errorf("GET /version by default = %d, want 404", resp.Status)
+	}
+}
+
+func TestVersionEndpoint(t *testing.T) {
+	t.Setenv("VERSION_ENDPOINT", "true")
+	_, addr := startServer(t)
+	resp := roundTrip(t, dial(t, addr), "GET", "/version", nil, nil)
+	if resp.Status != 200 || resp.Header("Cache-Control") != "no-store" {
+		t.Fatalf("GET /version = %d Cache-Control %q, want an uncacheable 200", resp.Status, resp.Header("Cache-Control"))
+	}
+
+	var fields map[string]string
+	if err := json.Unmarshal(resp.Body, &fields); err != nil {
+		t.Fatalf("body %s: %v", resp.Body, err)
+	}
+	want := []string{"arch", "build_date", "commit", "go_version", "os", "version"}
+	if got := slices.Sorted(maps.Keys(fields)); !slices.Equal(got, want) {
+		t.Errorf("fields = %q, want %q", got, want)
+	}
+	for name, value := range fields {
+		if value == "" {
+			t.Errorf("%s is empty, want a value or %q", name, version.Unknown)
+		}
+	}
+	if info := version.Get(); fields["version"] != info.Version || fields["go_version"] != info.GoVersion {
+		t.Errorf("body %s, want it to match %+v", resp.Body, info)
+	}
+}
+
+func TestServerHeader(t *testing.T) {
+	saved := version.Version
+	version.Version = "1.4.0"
+	t.Cleanup(func() { version.Version = saved })
+
+	process := ServerHeader()
+	resp := Response{Headers: map[string]string{}}
+	process(nil, &resp)
+	if got := resp.Headers["Server"]; got != "httpServer/1.4.0" {
+		t.Errorf("Server = %q, want httpServer/1.4.0", got)
+	}
+	resp = Response{Headers: map[string]string{"Server": "custom"}}
+	process(nil, &resp)
+	if got := resp.Headers["Server"]; got != "custom" {
+		t.Errorf("Server = %q, want the handler's own value kept", got)
+	}
+	resp = Response{}
+	process(nil, &resp)
+	if got := resp.Headers["Server"]; got != "httpServer/1.4.0" {
+		t.Errorf("Server on a response without headers = %q, want it set", got)
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"fmt"
+	"time"
+)
+
+// BulkheadPolicy decides what happens to a request when its route is
+// already running the maximum number of concurrent handlers.
+type BulkheadPolicy int
+
+const (
+	// BulkheadReject answers immediately with 503 and Retry-After.
+	BulkheadReject BulkheadPolicy = iota
+
+	// BulkheadQueue waits up to the route's queue timeout for a free slot
+	// before answering with 503.
+	BulkheadQueue
+)
+
+// bulkheadRetryAfter is the Retry-After hint sent when a bulkhead is full.
+const bulkheadRetryAfter = 1 * time.Second
+
+// bulkhead limits the number of concurrently executing handlers of a route.
+type bulkhead struct {
+	slots    chan struct{}
+	policy   BulkheadPolicy
+	wait     time.Duration
+	inFlight *Gauge
+	fair     *fairQueue // replaces slots and policy, see Router.SetFairQueue
+}
+
+// newBulkhead creates a bulkhead with n slots for the given route.
+func newBulkhead(route *Route, n int) *bulkhead {
+	name := fmt.Sprintf(`route_in_flight{method=%q,pattern=%q}`, route.method, route.pattern)
+	return &bulkhead{
+		slots:    make(chan struct{}, n),
+		policy:   route.bulkheadPolicy,
+		wait:     route.bulkheadWait,
+		inFlight: Metrics.Gauge(name),
+	}
+}
+
+// acquire takes a slot for a request from client according to the
+// bulkhead policy. It returns errRouteSaturated if no slot became
+// available, or errClientQueueFull if a fair queue turned client away.
+func (b *bulkhead) acquire(client string) error {
+	if b.fair != nil {
+		if err := b.fair.acquire(client); err != nil {
+			return err
+		}
+		b.inFlight.Inc()
+		return nil
+	}
+	select {
+	case b.slots <- struct{}{}:
+		b.inFlight.Inc()
+		return nil
+	default:
+	}
+
+	if b.policy != BulkheadQueue || b.wait <= 0 {
+		return errRouteSaturated
+	}
+
+	timer := time.NewTimer(b.wait)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		b.inFlight.Inc()
+		return nil
+	case <-timer.C:
+		return errRouteSaturated
+	}
+}
+
+// release returns a slot taken by acquire for client.
+func (b *bulkhead) release(client string) {
+	b.inFlight.Dec()
+	if b.fair != nil {
+		b.fair.release(client)
+		return
+	}
+	<-b.slots
+}
+
+// MaxConcurrent limits the route to n concurrently executing handlers.
+// Streaming responses keep their slot until the stream completes. A value
+// of 0 falls back to the router default set with SetDefaultMaxConcurrent.
+func (rt *Route) MaxConcurrent(n int) *Route {
+	rt.maxConcurrent = n
+	return rt
+}
+
+// OnSaturated sets how requests are treated when the route's concurrency
+// limit is reached. For BulkheadQueue, wait bounds how long a request may
+// queue for a slot.
+func (rt *Route) OnSaturated(policy BulkheadPolicy, wait time.Duration) *Route {
+	rt.bulkheadPolicy = policy
+	rt.bulkheadWait = wait
+	return rt
+}
+
+// SetDefaultMaxConcurrent sets the concurrency limit applied to every route
+// that does not declare its own with MaxConcurrent. 0 disables the default.
+func (r *Router) SetDefaultMaxConcurrent(n int) {
+	r.defaultMaxConcurrent = n
+}
+
+// bulkheadFor returns the bulkhead guarding route, creating it on first
+// use, or nil if the route is unlimited.
+func (r *Router) bulkheadFor(route *Route) *bulkhead {
+	route.bulkheadOnce.Do(func() {
+		n := route.maxConcurrent
+		if n == 0 {
+			n = r.defaultMaxConcurrent
+		}
+		if n > 0 {
+			route.bulkhead = newBulkhead(route, n)
+			if r.fairQueue != nil {
+				fair := newFairQueue(n, *r.fairQueue)
+				route.bulkhead.fair = fair
+				label := routeLabel(route)
+				Metrics.Collect("fair_queue "+label, func() map[string]int64 { return fair.topClients(label) })
+			}
+		}
+	})
+	return route.bulkhead
+}
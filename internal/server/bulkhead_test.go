@@ -0,0 +1,183 @@
+package server
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+	"github.com/Abb133Se/httpServer/internal/testclient"
+)
+
+// inFlight returns the in-flight gauge of the route method pattern.
+func inFlight(method, pattern string) int64 {
+	return Metrics.Gauge(`route_in_flight{method="` + method + `",pattern="` + pattern + `"}`).Value()
+}
+
+func TestBulkheadSaturation(t *testing.T) {
+	slow, entered, release := blockingHandler("slow")
+	_, addr := startServerWithRoutes(t, func(r *Router) {
+		r.Handle("/bh/slow", "GET", slow).MaxConcurrent(2)
+		r.Handle("/bh/fast", "GET", func(*Request) Response { return textResponse("fast") })
+	})
+
+	var busy []*testclient.Client
+	for range 2 {
+		c := dial(t, addr)
+		if err := c.SendRequest("GET", "/bh/slow", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		<-entered
+		busy = append(busy, c)
+	}
+	if n := inFlight("GET", "/bh/slow"); n != 2 {
+		t.Errorf("in flight = %d, want 2", n)
+	}
+
+	resp := roundTrip(t, dial(t, addr), "GET", "/bh/slow", nil, nil)
+	if resp.Status != 503 || resp.Header("Retry-After") != "1" {
+		t.Errorf("saturated route = %d, Retry-After %q; want 503, 1", resp.Status, resp.Header("Retry-After"))
+	}
+	if resp := roundTrip(t, dial(t, addr), "GET", "/bh/fast", nil, nil); resp.Status != 200 {
+		t.Errorf("other route while saturated = %d, want 200", resp.Status)
+	}
+
+	close(release)
+	for _, c := range busy {
+		if resp, err := c.ReadResponse(); err != nil || resp.Status != 200 {
+			t.Errorf("held request = %v, %v; want 200", resp, err)
+		}
+	}
+	waitFor(t, "slots to be released", func() bool { return inFlight("GET", "/bh/slow") == 0 })
+	if resp := roundTrip(t, dial(t, addr), "GET", "/bh/slow", nil, nil); resp.Status != 200 {
+		t.Errorf("route after the slots were released = %d, want 200", resp.Status)
+	}
+}
+
+func TestBulkheadQueue(t *testing.T) {
+	slow, entered, release := blockingHandler("slow")
+	_, addr := startServerWithRoutes(t, func(r *Router) {
+		r.Handle("/bhq/slow", "GET", slow).MaxConcurrent(1).OnSaturated(BulkheadQueue, 100*time.Millisecond)
+	})
+	holder := dial(t, addr)
+	if err := holder.SendRequest("GET", "/bhq/slow", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-entered
+
+	start := time.Now()
+	resp := roundTrip(t, dial(t, addr), "GET", "/bhq/slow", nil, nil)
+	if resp.Status != 503 || time.Since(start) < 100*time.Millisecond {
+		t.Errorf("queued past the wait: %d after %v, want 503 after 100ms", resp.Status, time.Since(start))
+	}
+
+	// A request queued while the slot frees up gets it.
+	waiter := dial(t, addr)
+	if err := waiter.SendRequest("GET", "/bhq/slow", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	for _, c := range []*testclient.Client{holder, waiter} {
+		if resp, err := c.ReadResponse(); err != nil || resp.Status != 200 {
+			t.Errorf("request = %v, %v; want 200", resp, err)
+		}
+	}
+}
+
+// streamingHandler returns a handler whose response streams "streamed"
+// once release is closed, signalling on started when the stream begins.
+func streamingHandler() (h HandlerFunc, started chan struct{}, release chan struct{}) {
+	started, release = make(chan struct{}, 16), make(chan struct{})
+	h = func(*Request) Response {
+		return Response{
+			Version: HTTPVersion,
+			Status:  200,
+			Reason:  "OK",
+			Headers: map[string]string{"Content-Type": "text/plain"},
+			StreamFunc: func(w io.Writer) error {
+				started <- struct{}{}
+				<-release
+				_, err := io.WriteString(w, "streamed")
+				return err
+			},
+		}
+	}
+	return h, started, release
+}
+
+func TestBulkheadStreamHoldsSlot(t *testing.T) {
+	stream, started, release := streamingHandler()
+	_, addr := startServerWithRoutes(t, func(r *Router) {
+		r.Handle("/bhs/stream", "GET", stream).MaxConcurrent(1)
+	})
+
+	c := dial(t, addr)
+	if err := c.SendRequest("GET", "/bhs/stream", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if resp := roundTrip(t, dial(t, addr), "GET", "/bhs/stream", nil, nil); resp.Status != 503 {
+		t.Errorf("second stream while the first runs = %d, want 503", resp.Status)
+	}
+	close(release)
+	if resp, err := c.ReadResponse(); err != nil || string(resp.Body) != "streamed" {
+		t.Fatalf("stream = %v, %v", resp, err)
+	}
+	waitFor(t, "the stream's slot to be released", func() bool { return inFlight("GET", "/bhs/stream") == 0 })
+}
+
+func TestBulkheadReleasesDroppedStreams(t *testing.T) {
+	stream, _, release := streamingHandler()
+	close(release)
+	oversized := func(req *Request) Response {
+		resp := stream(req)
+		resp.Headers["X-Huge"] = strings.Repeat("x", DefaultMaxResponseHeaderBytes)
+		return resp
+	}
+	_, addr := startServerWithRoutes(t, func(r *Router) {
+		r.Handle("/bhd/stream", "HEAD", stream).MaxConcurrent(1)
+		r.Handle("/bhd/oversized", "GET", oversized).MaxConcurrent(1)
+	})
+
+	// A HEAD response and one whose headers are too large never run their
+	// stream; each must still give its slot back.
+	for _, tt := range []struct {
+		method, path string
+		status       int
+	}{
+		{"HEAD", "/bhd/stream", 200},
+		{"GET", "/bhd/oversized", 500},
+	} {
+		for i := range 3 {
+			if resp := roundTrip(t, dial(t, addr), tt.method, tt.path, nil, nil); resp.Status != tt.status {
+				t.Fatalf("%s %s #%d = %d, want %d", tt.method, tt.path, i+1, resp.Status, tt.status)
+			}
+		}
+		if n := inFlight(tt.method, tt.path); n != 0 {
+			t.Errorf("%s %s: %d slots still held", tt.method, tt.path, n)
+		}
+	}
+}
+
+func TestBulkheadReleasesReplacedStreams(t *testing.T) {
+	stream, _, release := streamingHandler()
+	close(release)
+	router := NewRouter()
+	router.Handle("/bhr/stream", "GET", stream).MaxConcurrent(1)
+	srv := NewServer(config.LoadConfig(), router)
+	srv.AfterResponse(func(req *Request, resp *Response) {
+		*resp = InternalServerErrorResponse()
+	})
+	addr := serve(t, srv)
+
+	// A response replaced before it is sent never runs its stream either.
+	for i := range 3 {
+		if resp := roundTrip(t, dial(t, addr), "GET", "/bhr/stream", nil, nil); resp.Status != 500 {
+			t.Fatalf("GET /bhr/stream #%d = %d, want 500", i+1, resp.Status)
+		}
+	}
+	if n := inFlight("GET", "/bhr/stream"); n != 0 {
+		t.Errorf("%d slots still held", n)
+	}
+}
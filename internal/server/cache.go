@@ -0,0 +1,184 @@
+package server
+
+import (
+	"container/list"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/cachecontrol"
+	"github.com/Abb133Se/httpServer/internal/clock"
+)
+
+// ResponseCache is a bounded in-memory cache of GET responses, for
+// handlers whose answers are costly to build and change rarely. It acts as
+// a shared cache in the sense of RFC 9111:
+//
+//   - Only complete 200 responses with a buffered Body are stored, and not
+//     those marked no-store or private, those setting cookies, those with
+//     a Vary header, or answers to requests carrying Authorization unless
+//     marked public.
+//   - A response is fresh for its s-maxage, else its max-age, else the
+//     cache's default TTL; no-cache responses are not stored, since every
+//     use would have to run the handler anyway.
+//   - Hits carry an Age header counting the seconds since the response
+//     was stored. Stale entries are dropped rather than served.
+//   - A request with Cache-Control no-cache or max-age=0 revalidates: the
+//     handler runs and its answer replaces the stored one. A request with
+//     no-store neither reads nor fills the cache.
+//   - HEAD is answered from the stored GET response.
+//   - A successful request with any other method drops the entry for its
+//     target, so a PUT is visible to the next GET.
+//
+// Entries are keyed by Host and request target.
+type ResponseCache struct {
+	mu         sync.Mutex
+	size       int
+	defaultTTL time.Duration
+	entries    map[string]*list.Element
+	order      list.List // front is most recently used; values are *cacheEntry
+	clock      clock.Clock
+}
+
+type cacheEntry struct {
+	key     string
+	resp    Response
+	stored  time.Time
+	expires time.Time
+}
+
+// NewResponseCache creates a cache holding up to size responses, fresh for
+// defaultTTL unless their Cache-Control says otherwise.
+func NewResponseCache(size int, defaultTTL time.Duration) *ResponseCache {
+	return &ResponseCache{size: size, defaultTTL: defaultTTL, entries: make(map[string]*list.Element), clock: clock.Real}
+}
+
+// SetClock replaces the time source freshness and Age are computed with.
+func (c *ResponseCache) SetClock(clk clock.Clock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clock = clk
+}
+
+// Middleware serves requests from the cache and fills it with the
+// responses of next.
+//
+// Example:
+//
+//	cache := server.NewResponseCache(256, time.Minute)
+//	router.Handle("/report", "GET", handleReport).Use(cache.Middleware)
+//	router.Handle("/report", "HEAD", handleReport).Use(cache.Middleware)
+func (c *ResponseCache) Middleware(next HandlerFunc) HandlerFunc {
+	return func(req *Request) Response {
+		key := strings.ToLower(req.Headers["host"]) + " " + req.target()
+		if req.Method != "GET" && req.Method != "HEAD" {
+			resp := next(req)
+			if resp.Status < 400 && req.Method != "OPTIONS" && req.Method != "TRACE" {
+				c.forget(key)
+			}
+			return resp
+		}
+
+		directives := cachecontrol.Parse(req.Headers["cache-control"])
+		if !directives.NoStore && !directives.MustRevalidateStored() {
+			if resp, ok := c.lookup(key); ok {
+				Metrics.Counter("response_cache_hits_total").Inc()
+				return resp
+			}
+		}
+		Metrics.Counter("response_cache_misses_total").Inc()
+		resp := next(req)
+		if req.Method == "GET" && !directives.NoStore {
+			c.store(key, req, resp)
+		}
+		return resp
+	}
+}
+
+// lookup returns a copy of the fresh response stored under key, with its
+// Age set.
+func (c *ResponseCache) lookup(key string) (Response, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return Response{}, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	now := c.clock.Now()
+	if !now.Before(entry.expires) {
+		c.remove(elem)
+		Metrics.Gauge("response_cache_entries").Set(int64(c.order.Len()))
+		return Response{}, false
+	}
+	c.order.MoveToFront(elem)
+	resp := entry.resp
+	resp.Headers = maps.Clone(entry.resp.Headers)
+	resp.Headers["Age"] = cachecontrol.Age(entry.stored, now)
+	return resp, true
+}
+
+// store keeps resp under key if it may be cached, see ResponseCache.
+func (c *ResponseCache) store(key string, req *Request, resp Response) {
+	if resp.Status != 200 || resp.StreamFunc != nil || resp.Body == nil {
+		return
+	}
+	if responseHeader(resp, "Vary") != "" || responseHeader(resp, "Set-Cookie") != "" {
+		return
+	}
+	directives := cachecontrol.Parse(responseHeader(resp, "Cache-Control"))
+	if !directives.Storable(true) {
+		return
+	}
+	if _, ok := req.Headers["authorization"]; ok && !directives.Public && !directives.HasSMaxAge {
+		return
+	}
+	ttl := directives.TTL(true, c.defaultTTL)
+	if ttl <= 0 || c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	entry := &cacheEntry{key: key, resp: resp, stored: now, expires: now.Add(ttl)}
+	entry.resp.Headers = maps.Clone(resp.Headers)
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+	Metrics.Gauge("response_cache_entries").Set(int64(c.order.Len()))
+}
+
+// forget drops the entry stored under key, if any.
+func (c *ResponseCache) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+		Metrics.Gauge("response_cache_entries").Set(int64(c.order.Len()))
+	}
+}
+
+// remove drops elem. c.mu must be held.
+func (c *ResponseCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
+
+// responseHeader returns the value of the response header name, looked up
+// in any letter case.
+func responseHeader(resp Response, name string) string {
+	for key, value := range resp.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
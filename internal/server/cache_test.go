@@ -0,0 +1,140 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/clock"
+)
+
+// cachedHandler returns a handler behind cache answering with the headers
+// from headers and a body counting its calls, and the call counter.
+func cachedHandler(cache *ResponseCache, headers map[string]string) (HandlerFunc, *int) {
+	calls := 0
+	return cache.Middleware(func(req *Request) Response {
+		calls++
+		resp := textResponse(fmt.Sprint(calls))
+		for k, v := range headers {
+			resp.Headers[k] = v
+		}
+		return resp
+	}), &calls
+}
+
+func cacheRequest(method, target string, headers map[string]string) *Request {
+	req := &Request{Method: method, Headers: map[string]string{"host": "example.com"}}
+	for k, v := range headers {
+		req.Headers[k] = v
+	}
+	req.setTarget(target)
+	return req
+}
+
+func TestResponseCacheStorable(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		headers map[string]string
+		req     map[string]string
+		cached  bool
+	}{
+		{"default TTL", nil, nil, true},
+		{"max-age", map[string]string{"Cache-Control": "max-age=60"}, nil, true},
+		{"private", map[string]string{"Cache-Control": "private, max-age=60"}, nil, false},
+		{"no-store", map[string]string{"cache-control": "no-store"}, nil, false},
+		{"no-cache", map[string]string{"Cache-Control": "no-cache"}, nil, false},
+		{"max-age=0", map[string]string{"Cache-Control": "max-age=0"}, nil, false},
+		{"s-maxage overrides max-age=0", map[string]string{"Cache-Control": "max-age=0, s-maxage=60"}, nil, true},
+		{"Set-Cookie", map[string]string{"Set-Cookie": "a=b"}, nil, false},
+		{"Vary", map[string]string{"Vary": "Accept-Encoding"}, nil, false},
+		{"Authorization", nil, map[string]string{"authorization": "Bearer x"}, false},
+		{"Authorization, public", map[string]string{"Cache-Control": "public"}, map[string]string{"authorization": "Bearer x"}, true},
+		{"request no-store", nil, map[string]string{"cache-control": "no-store"}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, calls := cachedHandler(NewResponseCache(8, time.Minute), tt.headers)
+			handler(cacheRequest("GET", "/r", tt.req))
+			handler(cacheRequest("GET", "/r", tt.req))
+			if cached := *calls == 1; cached != tt.cached {
+				t.Errorf("handler ran %d times for two requests, want cached=%t", *calls, tt.cached)
+			}
+		})
+	}
+}
+
+func TestResponseCacheAge(t *testing.T) {
+	clk := clock.NewManual(time.Unix(1000, 0))
+	cache := NewResponseCache(8, time.Minute)
+	cache.SetClock(clk)
+	handler, calls := cachedHandler(cache, map[string]string{"Cache-Control": "max-age=100"})
+
+	if resp := handler(cacheRequest("GET", "/r", nil)); resp.Headers["Age"] != "" {
+		t.Errorf("miss carries Age %q", resp.Headers["Age"])
+	}
+	for _, tt := range []struct {
+		advance time.Duration
+		age     string
+	}{
+		{0, "0"},
+		{1500 * time.Millisecond, "1"},
+		{98 * time.Second, "99"},
+	} {
+		clk.Advance(tt.advance)
+		resp := handler(cacheRequest("GET", "/r", nil))
+		if string(resp.Body) != "1" || resp.Headers["Age"] != tt.age {
+			t.Errorf("hit after %v: body %q Age %q, want the stored body with Age %s", tt.advance, resp.Body, resp.Headers["Age"], tt.age)
+		}
+	}
+
+	// At 100 seconds the entry is stale and the handler runs again.
+	clk.Advance(500 * time.Millisecond)
+	if resp := handler(cacheRequest("GET", "/r", nil)); string(resp.Body) != "2" || resp.Headers["Age"] != "" {
+		t.Errorf("stale entry served: body %q Age %q", resp.Body, resp.Headers["Age"])
+	}
+	if *calls != 2 {
+		t.Errorf("handler ran %d times, want 2", *calls)
+	}
+}
+
+func TestResponseCacheRevalidation(t *testing.T) {
+	handler, calls := cachedHandler(NewResponseCache(8, time.Minute), nil)
+	handler(cacheRequest("GET", "/r", nil))
+
+	for _, value := range []string{"no-cache", "max-age=0"} {
+		resp := handler(cacheRequest("GET", "/r", map[string]string{"cache-control": value}))
+		if string(resp.Body) != fmt.Sprint(*calls) || resp.Headers["Age"] != "" {
+			t.Errorf("Cache-Control: %s: body %q Age %q, want a fresh answer", value, resp.Body, resp.Headers["Age"])
+		}
+	}
+	// The revalidated answer replaced the stored one.
+	if resp := handler(cacheRequest("GET", "/r", nil)); string(resp.Body) != "3" || resp.Headers["Age"] != "0" {
+		t.Errorf("after revalidation: body %q Age %q, want the latest answer from the cache", resp.Body, resp.Headers["Age"])
+	}
+}
+
+func TestResponseCacheKeysAndInvalidation(t *testing.T) {
+	handler, calls := cachedHandler(NewResponseCache(2, time.Minute), nil)
+	handler(cacheRequest("GET", "/r?a=1", nil))
+	if resp := handler(cacheRequest("HEAD", "/r?a=1", nil)); string(resp.Body) != "1" {
+		t.Errorf("HEAD body %q, want it answered from the stored GET", resp.Body)
+	}
+	if resp := handler(cacheRequest("GET", "/r?a=2", nil)); string(resp.Body) != "2" {
+		t.Errorf("other query: body %q, want its own answer", resp.Body)
+	}
+	other := cacheRequest("GET", "/r?a=1", map[string]string{"host": "other.example"})
+	if resp := handler(other); string(resp.Body) != "3" {
+		t.Errorf("other host: body %q, want its own answer", resp.Body)
+	}
+	// The cache holds two entries: "/r?a=1" on example.com was evicted.
+	if resp := handler(cacheRequest("GET", "/r?a=1", nil)); string(resp.Body) != "4" {
+		t.Errorf("evicted entry: body %q, want a fresh answer", resp.Body)
+	}
+
+	handler(cacheRequest("PUT", "/r?a=1", nil))
+	if resp := handler(cacheRequest("GET", "/r?a=1", nil)); string(resp.Body) != "6" {
+		t.Errorf("after PUT: body %q, want a fresh answer", resp.Body)
+	}
+	if *calls != 6 {
+		t.Errorf("handler ran %d times, want 6", *calls)
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// MaxTrailerLines caps the trailer fields after the last chunk of a
+// chunked body, as each costs a map entry kept for the whole request.
+const MaxTrailerLines = 64
+
+// errChunkedTooLarge is returned by readChunkedBody for a body longer than
+// its limit.
+var errChunkedTooLarge = errors.New("chunked body exceeds limit")
+
+// isChunked reports whether the request body is sent with the chunked
+// transfer coding.
+func (r *Request) isChunked() bool {
+	return strings.EqualFold(strings.TrimSpace(r.Headers["transfer-encoding"]), "chunked")
+}
+
+// readChunkedRequestBody reads a chunked body the way readRequestBody reads
+// one with a Content-Length. As its length is only known once it has been
+// read, the method's body policy is applied while it arrives: a body
+// outgrowing MaxBodySize, or the policy's limit, is refused as soon as it
+// does, leaving the rest unread.
+func readChunkedRequestBody(reader *bufio.Reader, w io.Writer, req *Request, bodyPolicies map[string]BodyPolicy) error {
+	policy, _ := checkBodyPolicy(req.Method, 0, bodyPolicies)
+	limit := int64(MaxBodySize)
+	var body bytes.Buffer
+	var dst io.Writer = &budgetWriter{w: &body, req: req}
+	if policy.Action != BodyAllow {
+		limit = policy.Limit
+		dst = io.Discard
+	}
+
+	if err := sendContinue(w, req); err != nil {
+		return err
+	}
+	n, trailers, err := readChunkedBody(reader, dst, limit)
+	if errors.Is(err, errChunkedTooLarge) {
+		_, err = checkBodyPolicy(req.Method, limit+1, bodyPolicies)
+		return err
+	}
+	if err != nil {
+		logReadError("chunked request body", err)
+		return err
+	}
+	req.Trailers = trailers
+	if policy.Action != BodyAllow {
+		utils.Debug("Drained %d byte chunked body on %s", n, req.Method)
+		return nil
+	}
+	utils.Debug("Request body size: %d bytes (chunked)", n)
+	req.Body = body.Bytes()
+	return nil
+}
+
+// readChunkedBody decodes a chunked body from reader into dst, up to and
+// including the terminating zero-size chunk and any trailer fields, which
+// it returns keyed by lowercased name. Chunk extensions are ignored. It
+// returns errChunkedTooLarge once the body grows past limit bytes, and an
+// *HTTPError for malformed framing.
+func readChunkedBody(reader *bufio.Reader, dst io.Writer, limit int64) (int64, map[string]string, error) {
+	var total int64
+	for {
+		size, err := readChunkSize(reader)
+		if err != nil {
+			return total, nil, err
+		}
+		if size == 0 {
+			break
+		}
+		if size > limit-total {
+			return total, nil, errChunkedTooLarge
+		}
+		if _, err := io.CopyN(dst, reader, size); err != nil {
+			return total, nil, fmt.Errorf("failed to read chunk: %w", err)
+		}
+		total += size
+		line, _, err := readHeadLine(reader, false)
+		if err != nil && !errors.Is(err, errBareLF) {
+			return total, nil, fmt.Errorf("failed to read chunk: %w", err)
+		}
+		if err != nil || line != "" {
+			return total, nil, NewHTTPError(400, "chunk data not followed by CRLF")
+		}
+	}
+
+	trailers, err := readTrailers(reader)
+	return total, trailers, err
+}
+
+// readChunkSize reads a chunk-size line, "1a" or "1a;name=value", and
+// returns the size it declares.
+func readChunkSize(reader *bufio.Reader) (int64, error) {
+	line, _, err := readHeadLine(reader, false)
+	if errors.Is(err, errBareLF) {
+		return 0, NewHTTPError(400, "chunk size line not terminated by CRLF")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read chunk size: %w", err)
+	}
+	if len(line) > MaxHeaderLineLength {
+		return 0, NewHTTPError(400, "chunk size line too long")
+	}
+	sizeField, _, _ := strings.Cut(line, ";")
+	sizeField = strings.TrimRight(sizeField, " \t")
+	size, err := strconv.ParseInt(sizeField, 16, 64)
+	if err != nil || size < 0 || strings.ContainsAny(sizeField[:1], "+-") {
+		utils.Warn("Malformed chunk size: %q", line)
+		return 0, NewHTTPError(400, fmt.Sprintf("malformed chunk size %q", sizeField))
+	}
+	return size, nil
+}
+
+// readTrailers reads the trailer fields after the last chunk, up to the
+// empty line ending the body. More than MaxTrailerLines lines are refused.
+func readTrailers(reader *bufio.Reader) (map[string]string, error) {
+	var trailers map[string]string
+	for lines := 0; ; lines++ {
+		line, _, err := readHeadLine(reader, false)
+		if errors.Is(err, errBareLF) {
+			return nil, NewHTTPError(400, "trailer line not terminated by CRLF")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trailer: %w", err)
+		}
+		if line == "" {
+			return trailers, nil
+		}
+		if lines == MaxTrailerLines {
+			utils.Warn("More than %d trailer lines", MaxTrailerLines)
+			return nil, NewHTTPError(400, "too many trailer lines")
+		}
+		if len(line) > MaxHeaderLineLength {
+			return nil, NewHTTPError(400, "trailer line too long")
+		}
+		key, value, ok, err := parseHeaderLine(line, false)
+		if err != nil || !ok {
+			return nil, NewHTTPError(400, fmt.Sprintf("malformed trailer line %q", line))
+		}
+		if trailers == nil {
+			trailers = make(map[string]string)
+		}
+		trailers[key] = value
+	}
+}
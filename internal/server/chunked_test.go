@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestReadChunkedBody(t *testing.T) {
+	manyTrailers := strings.Repeat("X-T: v\r\n", MaxTrailerLines)
+	for _, tt := range []struct {
+		name     string
+		wire     string
+		body     string
+		trailers map[string]string
+		status   int // of the *HTTPError expected, 0 for none
+	}{
+		{"single chunk", "5\r\nhello\r\n0\r\n\r\n", "hello", nil, 0},
+		{"several chunks", "5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n", "hello world", nil, 0},
+		{"empty body", "0\r\n\r\n", "", nil, 0},
+		{"upper-case hex", "A\r\n0123456789\r\n0\r\n\r\n", "0123456789", nil, 0},
+		{"leading zeros", "0005\r\nhello\r\n000\r\n\r\n", "hello", nil, 0},
+		{"extension", "5;name=value\r\nhello\r\n0;last\r\n\r\n", "hello", nil, 0},
+		{"quoted extension", "5;name=\"a;b\"\r\nhello\r\n0\r\n\r\n", "hello", nil, 0},
+		{"whitespace before extension", "5 \t;ext\r\nhello\r\n0\r\n\r\n", "hello", nil, 0},
+		{"trailers", "5\r\nhello\r\n0\r\nX-Checksum: abc\r\nExpires:  never \r\n\r\n", "hello",
+			map[string]string{"x-checksum": "abc", "expires": "never"}, 0},
+		{"as many trailers as allowed", "0\r\n" + manyTrailers + "\r\n", "", map[string]string{"x-t": "v"}, 0},
+
+		{"not hex", "zz\r\nhello\r\n0\r\n\r\n", "", nil, 400},
+		{"empty size", "\r\nhello\r\n0\r\n\r\n", "", nil, 400},
+		{"negative size", "-5\r\nhello\r\n0\r\n\r\n", "", nil, 400},
+		{"signed size", "+5\r\nhello\r\n0\r\n\r\n", "", nil, 400},
+		{"hex prefix", "0x5\r\nhello\r\n0\r\n\r\n", "", nil, 400},
+		{"overflowing size", "fffffffffffffffff\r\n", "", nil, 400},
+		{"size line with bare LF", "5\nhello\r\n0\r\n\r\n", "", nil, 400},
+		{"size line too long", "5;" + strings.Repeat("x", MaxHeaderLineLength) + "\r\nhello\r\n0\r\n\r\n", "", nil, 400},
+		{"data longer than its size", "3\r\nhello\r\n0\r\n\r\n", "", nil, 400},
+		{"data without CRLF", "5\r\nhello0\r\n\r\n", "", nil, 400},
+		{"malformed trailer", "0\r\nno colon\r\n\r\n", "", nil, 400},
+		{"trailer with bare LF", "0\r\nX-A: b\n\r\n", "", nil, 400},
+		{"trailer line too long", "0\r\nX-A: " + strings.Repeat("b", MaxHeaderLineLength) + "\r\n\r\n", "", nil, 400},
+		{"too many trailers", "0\r\n" + manyTrailers + "X-T: v\r\n\r\n", "", nil, 400},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var body bytes.Buffer
+			n, trailers, err := readChunkedBody(bufio.NewReader(strings.NewReader(tt.wire)), &body, 1<<20)
+			if tt.status != 0 {
+				var httpErr *HTTPError
+				if !errors.As(err, &httpErr) || httpErr.Status != tt.status {
+					t.Fatalf("err = %v, want a %d HTTPError", err, tt.status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body.String() != tt.body || n != int64(len(tt.body)) {
+				t.Errorf("body = %q (%d bytes), want %q", body.String(), n, tt.body)
+			}
+			if !maps.Equal(trailers, tt.trailers) {
+				t.Errorf("trailers = %v, want %v", trailers, tt.trailers)
+			}
+		})
+	}
+}
+
+func TestReadChunkedBodyLimit(t *testing.T) {
+	wire := "5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n"
+	for _, tt := range []struct {
+		limit int64
+		err   error
+	}{
+		{11, nil},
+		{10, errChunkedTooLarge},
+		{4, errChunkedTooLarge},
+	} {
+		_, _, err := readChunkedBody(bufio.NewReader(strings.NewReader(wire)), &bytes.Buffer{}, tt.limit)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("limit %d: err = %v, want %v", tt.limit, err, tt.err)
+		}
+	}
+}
+
+func TestReadChunkedBodyTruncated(t *testing.T) {
+	for _, wire := range []string{"", "5\r\nhel", "5\r\nhello\r\n", "0\r\nX-A: b\r\n"} {
+		_, _, err := readChunkedBody(bufio.NewReader(strings.NewReader(wire)), &bytes.Buffer{}, 1<<20)
+		var httpErr *HTTPError
+		if err == nil || errors.As(err, &httpErr) {
+			t.Errorf("%q: err = %v, want a read error", wire, err)
+		}
+	}
+}
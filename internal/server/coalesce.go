@@ -0,0 +1,102 @@
+package server
+
+import (
+	"os"
+	"sync"
+)
+
+// coalesceMaxBytes is the largest file whose contents are shared between
+// coalesced readers. Larger files only share the stat result, and each
+// request reads the file itself to avoid pinning big buffers in memory.
+const coalesceMaxBytes = 1 << 20 // 1 MB
+
+// fileLoad is the result of a coalesced file read.
+//
+// data is nil when the file exceeds coalesceMaxBytes; callers must then
+// read the contents themselves.
+type fileLoad struct {
+	info os.FileInfo
+	data []byte
+}
+
+// fileCall is an in-flight or completed load shared by concurrent callers.
+type fileCall struct {
+	wg   sync.WaitGroup
+	load fileLoad
+	err  error
+}
+
+// fileCoalescer collapses concurrent reads of the same path into a single
+// stat and, for small files, a single disk read.
+type fileCoalescer struct {
+	mu    sync.Mutex
+	calls map[string]*fileCall
+
+	// read loads a file; nil means readFileForServing.
+	read func(path string) (fileLoad, error)
+}
+
+func newFileCoalescer() *fileCoalescer {
+	return &fileCoalescer{calls: make(map[string]*fileCall)}
+}
+
+// load stats and reads path, sharing the work with any concurrent callers
+// asking for the same path. Only cold reads are coalesced: once the leading
+// call completes, the next request for the path performs a fresh read so
+// modifications are always observed.
+func (c *fileCoalescer) load(path string) (fileLoad, error) {
+	c.mu.Lock()
+	if call, ok := c.calls[path]; ok {
+		c.mu.Unlock()
+		Metrics.Counter("files_coalesced_waiters_total").Inc()
+		call.wg.Wait()
+		return call.load, call.err
+	}
+	call := &fileCall{}
+	call.wg.Add(1)
+	c.calls[path] = call
+	c.mu.Unlock()
+
+	read := c.read
+	if read == nil {
+		read = readFileForServing
+	}
+	call.load, call.err = read(path)
+	call.wg.Done()
+
+	c.mu.Lock()
+	if c.calls[path] == call {
+		delete(c.calls, path)
+	}
+	c.mu.Unlock()
+
+	return call.load, call.err
+}
+
+// forget detaches the in-flight load of path, if any, after a write or
+// delete of path, so that requests arriving from now on read the file
+// afresh rather than share a load that may have seen the old contents.
+// Requests already waiting on the load still get its result.
+func (c *fileCoalescer) forget(path string) {
+	c.mu.Lock()
+	delete(c.calls, path)
+	c.mu.Unlock()
+}
+
+// readFileForServing performs the actual stat and, for small files, read.
+func readFileForServing(path string) (fileLoad, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileLoad{}, err
+	}
+	if info.Size() > coalesceMaxBytes {
+		return fileLoad{info: info}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fileLoad{}, err
+	}
+	Metrics.Counter("files_disk_reads_total").Inc()
+	return fileLoad{info: info, data: data}, nil
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFileCoalescerSharesOneRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "foo.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var reads atomic.Int32
+	release := make(chan struct{})
+	c := newFileCoalescer()
+	c.read = func(path string) (fileLoad, error) {
+		reads.Add(1)
+		<-release
+		return readFileForServing(path)
+	}
+
+	const readers = 100
+	waiters := Metrics.Counter("files_coalesced_waiters_total")
+	before := waiters.Value()
+	loads := make([]fileLoad, readers)
+	var wg sync.WaitGroup
+	for i := range readers {
+		wg.Go(func() {
+			load, err := c.load(path)
+			if err != nil {
+				t.Errorf("load: %v", err)
+			}
+			loads[i] = load
+		})
+	}
+	// Hold the first read until every other reader has joined it.
+	waitFor(t, "readers to coalesce", func() bool { return waiters.Value()-before == readers-1 })
+	close(release)
+	wg.Wait()
+
+	if n := reads.Load(); n != 1 {
+		t.Errorf("disk reads = %d, want 1", n)
+	}
+	for i, load := range loads {
+		if string(load.data) != "hello" {
+			t.Fatalf("reader %d got %q, want %q", i, load.data, "hello")
+		}
+	}
+}
+
+func TestFileCoalescerForgetStartsFreshRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "foo.txt")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var reads atomic.Int32
+	release := make(chan struct{})
+	c := newFileCoalescer()
+	c.read = func(path string) (fileLoad, error) {
+		load, err := readFileForServing(path)
+		if reads.Add(1) == 1 {
+			<-release // the first load has read the old contents and stalls
+		}
+		return load, err
+	}
+
+	stale := make(chan fileLoad)
+	go func() {
+		load, _ := c.load(path)
+		stale <- load
+	}()
+	waitFor(t, "the first read", func() bool { return reads.Load() == 1 })
+
+	if err := os.WriteFile(path, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.forget(path)
+
+	fresh, err := c.load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(fresh.data) != "new" {
+		t.Errorf("load after forget got %q, want %q", fresh.data, "new")
+	}
+	close(release)
+	if old := <-stale; string(old.data) != "old" {
+		t.Errorf("stalled load got %q, want %q", old.data, "old")
+	}
+}
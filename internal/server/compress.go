@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strconv"
+	"strings"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// DefaultCompressExcludeTypes are the content types never compressed:
+// media and archives are compressed already, and event streams must reach
+// the client as soon as each event is written.
+var DefaultCompressExcludeTypes = []string{"image/*", "video/*", "application/zip", "text/event-stream"}
+
+// compressMinSize is the smallest body worth compressing; below it the
+// gzip framing outweighs the savings.
+const compressMinSize = 256
+
+// EnableCompression gzips buffered response bodies for clients that
+// accept it. Content types matching an entry of exclude ("type/subtype"
+// or "type/*") are sent as-is, as are routes marked with NoCompression,
+// streaming and partial responses, and responses that already carry a
+// Content-Encoding or Content-Range. A nil exclude uses
+// DefaultCompressExcludeTypes.
+//
+// Compression runs after the AfterResponse post-processors, so they see
+// the uncompressed body. "Vary: Accept-Encoding" is added only to
+// responses that were eligible for compression, whether or not this
+// client got a compressed body.
+func (s *Server) EnableCompression(exclude []string) {
+	if exclude == nil {
+		exclude = DefaultCompressExcludeTypes
+	}
+	s.compressExclude = make([]string, 0, len(exclude))
+	for _, t := range exclude {
+		s.compressExclude = append(s.compressExclude, strings.ToLower(strings.TrimSpace(t)))
+	}
+	s.compressEnabled = true
+}
+
+// NoCompression opts the route out of response compression.
+func (rt *Route) NoCompression() *Route {
+	rt.noCompression = true
+	return rt
+}
+
+// compress gzips resp.Body if compression is enabled and applies to resp.
+func (s *Server) compress(req *Request, resp *Response) {
+	if !s.compressEnabled || resp.StreamFunc != nil || len(resp.Body) < compressMinSize {
+		return
+	}
+	if req.route != nil && req.route.noCompression {
+		return
+	}
+	if resp.Status == 206 || resp.Headers["Content-Range"] != "" || resp.Headers["Content-Encoding"] != "" {
+		return
+	}
+	if compressExcluded(resp.Headers["Content-Type"], s.compressExclude) {
+		return
+	}
+
+	addVary(resp, "Accept-Encoding")
+	if coding, ok := req.PreferredEncoding("gzip"); !ok || coding != "gzip" {
+		return
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(resp.Body); err != nil {
+		utils.Warn("Failed to compress response for %s: %v", req.Path, err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		utils.Warn("Failed to compress response for %s: %v", req.Path, err)
+		return
+	}
+	if buf.Len() >= len(resp.Body) {
+		return
+	}
+
+	Metrics.Counter("responses_compressed_total").Inc()
+	Metrics.Counter("response_bytes_saved_total").Add(int64(len(resp.Body) - buf.Len()))
+	resp.Body = buf.Bytes()
+	resp.Headers["Content-Encoding"] = "gzip"
+	if _, ok := resp.Headers["Content-Length"]; ok {
+		resp.Headers["Content-Length"] = strconv.Itoa(len(resp.Body))
+	}
+	// The compressed bytes differ from the identity representation, so a
+	// strong validator no longer describes them.
+	if etag := resp.Headers["ETag"]; strings.HasPrefix(etag, `"`) {
+		resp.Headers["ETag"] = "W/" + etag
+	}
+}
+
+// compressExcluded reports whether contentType matches one of exclude.
+func compressExcluded(contentType string, exclude []string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, pattern := range exclude {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(mediaType, prefix) {
+				return true
+			}
+		} else if mediaType == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// addVary adds field to resp's Vary header unless it is already listed.
+func addVary(resp *Response, field string) {
+	vary := resp.Headers["Vary"]
+	for _, listed := range strings.Split(vary, ",") {
+		if listed = strings.TrimSpace(listed); listed == "*" || strings.EqualFold(listed, field) {
+			return
+		}
+	}
+	if vary == "" {
+		resp.Headers["Vary"] = field
+	} else {
+		resp.Headers["Vary"] = vary + ", " + field
+	}
+}
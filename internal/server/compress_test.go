@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+)
+
+// compressible is a body large and repetitive enough to be worth gzipping.
+var compressible = `{"items":[` + strings.Repeat(`{"name":"item","value":42},`, 40) + `{}]}`
+
+// startCompressionServer serves routes answering compressible with
+// various content types and headers, with compression enabled for the
+// configured COMPRESS_EXCLUDE_TYPES.
+func startCompressionServer(t *testing.T) string {
+	t.Helper()
+	respond := func(status int, headers map[string]string) HandlerFunc {
+		return func(*Request) Response {
+			resp := textResponse(compressible)
+			resp.Status = status
+			for name, value := range headers {
+				resp.Headers[name] = value
+			}
+			return resp
+		}
+	}
+	r := NewRouter()
+	r.Handle("/json", "GET", respond(200, map[string]string{"Content-Type": "application/json", "ETag": `"v1"`}))
+	r.Handle("/json-plain", "GET", respond(200, map[string]string{"Content-Type": "application/json"})).NoCompression()
+	r.Handle("/events", "GET", respond(200, map[string]string{"Content-Type": "text/event-stream"}))
+	r.Handle("/image", "GET", respond(200, map[string]string{"Content-Type": "image/svg+xml"}))
+	r.Handle("/zip", "GET", respond(200, map[string]string{"Content-Type": "Application/ZIP; name=a.zip"}))
+	r.Handle("/range", "GET", respond(206, map[string]string{"Content-Range": "bytes 0-1099/5000"}))
+	r.Handle("/range-200", "GET", respond(200, map[string]string{"Content-Range": "bytes 0-1099/5000"}))
+	r.Handle("/encoded", "GET", respond(200, map[string]string{"Content-Encoding": "br"}))
+	r.Handle("/small", "GET", func(*Request) Response { return textResponse("tiny") })
+	cfg := config.LoadConfig()
+	srv := NewServer(cfg, r)
+	srv.EnableCompression(cfg.CompressExcludeTypes)
+	return serve(t, srv)
+}
+
+func TestCompression(t *testing.T) {
+	addr := startCompressionServer(t)
+	gzipOK := map[string]string{"Accept-Encoding": "gzip"}
+
+	resp := roundTrip(t, dial(t, addr), "GET", "/json", gzipOK, nil)
+	if resp.Header("Content-Encoding") != "gzip" || resp.Header("Vary") != "Accept-Encoding" {
+		t.Fatalf("GET /json: Content-Encoding %q, Vary %q; want gzip, Accept-Encoding", resp.Header("Content-Encoding"), resp.Header("Vary"))
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(resp.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err := io.ReadAll(zr); err != nil || string(body) != compressible {
+		t.Errorf("decompressed body = %q, %v; want the handler's", body, err)
+	}
+	if got := resp.Header("ETag"); got != `W/"v1"` {
+		t.Errorf("ETag = %q, want the strong validator weakened", got)
+	}
+
+	// Eligible but not accepted: identity, and still Vary.
+	for _, accept := range []map[string]string{nil, {"Accept-Encoding": "br"}, {"Accept-Encoding": "gzip;q=0"}} {
+		resp := roundTrip(t, dial(t, addr), "GET", "/json", accept, nil)
+		if resp.Header("Content-Encoding") != "" || string(resp.Body) != compressible || resp.Header("Vary") != "Accept-Encoding" {
+			t.Errorf("GET /json with %v: Content-Encoding %q, Vary %q; want identity varying on Accept-Encoding",
+				accept, resp.Header("Content-Encoding"), resp.Header("Vary"))
+		}
+	}
+}
+
+func TestCompressionBypass(t *testing.T) {
+	addr := startCompressionServer(t)
+	gzipOK := map[string]string{"Accept-Encoding": "gzip"}
+	for _, tt := range []struct {
+		path, why, encoding string
+	}{
+		{"/json-plain", "route opted out", ""},
+		{"/events", "event stream", ""},
+		{"/image", "image/*", ""},
+		{"/zip", "archive, in any letter case and with parameters", ""},
+		{"/range", "partial content", ""},
+		{"/range-200", "Content-Range", ""},
+		{"/encoded", "already encoded", "br"},
+		{"/small", "too small", ""},
+	} {
+		resp := roundTrip(t, dial(t, addr), "GET", tt.path, gzipOK, nil)
+		if got := resp.Header("Content-Encoding"); got != tt.encoding {
+			t.Errorf("GET %s (%s): Content-Encoding %q, want %q", tt.path, tt.why, got, tt.encoding)
+		}
+		if got := resp.Header("Vary"); got != "" {
+			t.Errorf("GET %s (%s): Vary %q, want none since compression was never considered", tt.path, tt.why, got)
+		}
+	}
+}
+
+func TestCompressionCustomExcludeList(t *testing.T) {
+	t.Setenv("COMPRESS_EXCLUDE_TYPES", "application/json")
+	addr := startCompressionServer(t)
+	gzipOK := map[string]string{"Accept-Encoding": "gzip"}
+	if resp := roundTrip(t, dial(t, addr), "GET", "/json", gzipOK, nil); resp.Header("Content-Encoding") != "" {
+		t.Errorf("excluded JSON: Content-Encoding %q, want none", resp.Header("Content-Encoding"))
+	}
+	if resp := roundTrip(t, dial(t, addr), "GET", "/events", gzipOK, nil); resp.Header("Content-Encoding") != "gzip" {
+		t.Errorf("event stream no longer excluded: Content-Encoding %q, want gzip", resp.Header("Content-Encoding"))
+	}
+}
+
+func TestCompressExcluded(t *testing.T) {
+	for _, tt := range []struct {
+		contentType string
+		want        bool
+	}{
+		{"image/png", true},
+		{"IMAGE/PNG", true},
+		{"video/mp4", true},
+		{"application/zip", true},
+		{"text/event-stream; charset=utf-8", true},
+		{"application/json", false},
+		{"application/zip+xml", false},
+		{"imagery/x", false},
+		{"", false},
+	} {
+		if got := compressExcluded(tt.contentType, DefaultCompressExcludeTypes); got != tt.want {
+			t.Errorf("compressExcluded(%q) = %t, want %t", tt.contentType, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// CORSPolicy is a router's cross-origin resource sharing policy, see
+// Router.SetCORS. Routes and groups may override parts of it with
+// CORSOptions.
+type CORSPolicy struct {
+	// AllowedOrigins lists the origins, such as "https://app.example.com",
+	// allowed to read responses. "*" allows any origin.
+	AllowedOrigins []string
+
+	// AllowedMethods is advertised for routes registered without a method,
+	// which accept every method. Other routes advertise the methods they
+	// are registered for.
+	AllowedMethods []string
+
+	// AllowedHeaders lists the request headers clients may send. "*"
+	// allows whatever a preflight asks for.
+	AllowedHeaders []string
+
+	// ExposedHeaders lists the response headers clients may read beyond
+	// the CORS-safelisted ones.
+	ExposedHeaders []string
+
+	// AllowCredentials lets clients send cookies and HTTP authentication.
+	// The exact origin is then echoed even when AllowedOrigins is "*".
+	AllowCredentials bool
+
+	// MaxAge is how long browsers may cache a preflight response.
+	MaxAge time.Duration
+}
+
+// CORSOptions overrides parts of the router's CORSPolicy for a route or
+// group. Nil or zero fields keep the policy's value.
+type CORSOptions struct {
+	// AllowedMethods restricts the methods advertised for the route, e.g.
+	// to keep DELETE same-origin.
+	AllowedMethods []string
+
+	// AllowedHeaders replaces the policy's allowed request headers.
+	AllowedHeaders []string
+
+	// MaxAge replaces the policy's preflight cache lifetime.
+	MaxAge time.Duration
+}
+
+// SetCORS enables CORS for the router's routes under policy. Preflight
+// requests, an OPTIONS carrying Origin and Access-Control-Request-Method,
+// are answered by the router before any route, advertising only methods
+// registered for the target path so a preflight never promises a method
+// that would get 405. Other requests from an allowed origin get
+// Access-Control-Allow-Origin and the exposed headers. A nil policy
+// disables CORS.
+func (r *Router) SetCORS(policy *CORSPolicy) {
+	r.cors = policy
+}
+
+// CORS overrides the router's CORS policy for the route, see CORSOptions.
+// It takes effect in preflights whose Access-Control-Request-Method the
+// route handles.
+//
+// Example:
+//
+//	router.Handle("/upload", "PUT", handleUpload).CORS(server.CORSOptions{
+//	    AllowedHeaders: []string{"Content-Type", "X-Upload-Checksum"},
+//	    MaxAge:         24 * time.Hour,
+//	})
+func (rt *Route) CORS(opts CORSOptions) *Route {
+	rt.cors = &opts
+	return rt
+}
+
+// CORS applies Route.CORS to every route in the group, including routes
+// added later.
+func (g *RouteGroup) CORS(opts CORSOptions) *RouteGroup {
+	g.cors = &opts
+	for _, route := range g.routes {
+		route.CORS(opts)
+	}
+	return g
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or
+// "" if the policy does not allow it.
+func (p *CORSPolicy) allowOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" {
+			if p.AllowCredentials {
+				return origin
+			}
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// preflight answers a CORS preflight for path, or returns nil if req is not
+// one, its origin is not allowed or no route serves path.
+func (r *Router) preflight(req *Request, path string) *Response {
+	origin := req.Headers["origin"]
+	requested := strings.ToUpper(strings.TrimSpace(req.Headers["access-control-request-method"]))
+	if origin == "" || requested == "" {
+		return nil
+	}
+	allowOrigin := r.cors.allowOrigin(origin)
+	if allowOrigin == "" {
+		utils.Debug("CORS preflight from disallowed origin %s: %s", origin, path)
+		return nil
+	}
+	methods := r.corsMethods(path)
+	if methods == nil {
+		return nil
+	}
+
+	headers := r.cors.AllowedHeaders
+	maxAge := r.cors.MaxAge
+	target := r.match(req, requested, path)
+	if target == nil && requested == "HEAD" {
+		target = r.match(req, "GET", path)
+	}
+	if target != nil && target.cors != nil {
+		if target.cors.AllowedMethods != nil {
+			allowed := func(m string) bool {
+				return slices.ContainsFunc(target.cors.AllowedMethods, func(a string) bool { return strings.EqualFold(a, m) })
+			}
+			methods = slices.DeleteFunc(methods, func(m string) bool {
+				return m != "OPTIONS" && !allowed(m) && !(m == "HEAD" && allowed("GET"))
+			})
+		}
+		if target.cors.AllowedHeaders != nil {
+			headers = target.cors.AllowedHeaders
+		}
+		if target.cors.MaxAge > 0 {
+			maxAge = target.cors.MaxAge
+		}
+	}
+	if slices.Contains(headers, "*") {
+		headers = nil
+		if requestedHeaders := req.Headers["access-control-request-headers"]; requestedHeaders != "" {
+			headers = []string{requestedHeaders}
+		}
+	}
+
+	Metrics.Counter("cors_preflights_total").Inc()
+	resp := Response{Version: HTTPVersion, Status: 204, Reason: "No Content", Headers: map[string]string{
+		"Access-Control-Allow-Origin":  allowOrigin,
+		"Access-Control-Allow-Methods": strings.Join(methods, ", "),
+		"Vary":                         "Origin, Access-Control-Request-Method, Access-Control-Request-Headers",
+	}}
+	if len(headers) > 0 {
+		resp.Headers["Access-Control-Allow-Headers"] = strings.Join(headers, ", ")
+	}
+	if maxAge > 0 {
+		resp.Headers["Access-Control-Max-Age"] = strconv.FormatInt(int64(maxAge/time.Second), 10)
+	}
+	if r.cors.AllowCredentials {
+		resp.Headers["Access-Control-Allow-Credentials"] = "true"
+	}
+	return &resp
+}
+
+// corsMethods lists the methods a preflight for path advertises: those of
+// the routes registered for it, plus the policy's AllowedMethods if a
+// route accepting every method matches it. It returns nil if no route
+// matches path.
+func (r *Router) corsMethods(path string) []string {
+	methods := r.allowedMethods(path)
+	anyMethod := slices.ContainsFunc(r.routes, func(route *Route) bool {
+		return route.method == "" && routeMatchesPath(route, path)
+	})
+	// Grouped routes accept every method.
+	for _, group := range r.groups {
+		anyMethod = anyMethod || slices.ContainsFunc(group.routes, func(route *Route) bool {
+			return route.pattern == path
+		})
+	}
+	if !anyMethod {
+		return methods
+	}
+	for _, method := range slices.Concat(r.cors.AllowedMethods, []string{"OPTIONS"}) {
+		method = strings.ToUpper(method)
+		if !slices.Contains(methods, method) {
+			methods = append(methods, method)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// addCORSHeaders adds the CORS headers for req's origin to a response
+// other than a preflight.
+func (r *Router) addCORSHeaders(req *Request, resp *Response) {
+	if r.cors == nil || resp.Status == 0 {
+		return
+	}
+	if resp.Headers == nil {
+		resp.Headers = make(map[string]string)
+	}
+	if _, ok := resp.Headers["Access-Control-Allow-Origin"]; ok {
+		return
+	}
+	addVary(resp, "Origin")
+	allowOrigin := r.cors.allowOrigin(req.Headers["origin"])
+	if allowOrigin == "" {
+		return
+	}
+	resp.Headers["Access-Control-Allow-Origin"] = allowOrigin
+	if r.cors.AllowCredentials {
+		resp.Headers["Access-Control-Allow-Credentials"] = "true"
+	}
+	if len(r.cors.ExposedHeaders) > 0 {
+		resp.Headers["Access-Control-Expose-Headers"] = strings.Join(r.cors.ExposedHeaders, ", ")
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+// preflight sends r a CORS preflight from https://app.example.com asking
+// to use method on target.
+func preflight(r *Router, method, target string) Response {
+	req := &Request{Method: "OPTIONS", Path: target, Headers: map[string]string{
+		"origin":                        "https://app.example.com",
+		"access-control-request-method": method,
+	}}
+	req.setTarget(target)
+	return r.Route(req)
+}
+
+// corsRouter has a ten minute preflight policy, overridden to a day for
+// PUT /upload and to an hour for the /api group.
+func corsRouter() *Router {
+	r := NewRouter()
+	r.SetCORS(&CORSPolicy{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedHeaders: []string{"Content-Type"},
+		MaxAge:         10 * time.Minute,
+	})
+	noop := func(req *Request) Response { return textResponse("ok") }
+	r.Handle("/upload", "GET", noop)
+	r.Handle("/upload", "PUT", noop).CORS(CORSOptions{
+		AllowedHeaders: []string{"Content-Type", "X-Upload-Checksum"},
+		MaxAge:         24 * time.Hour,
+	})
+	r.Handle("/reports", "GET", noop).CORS(CORSOptions{AllowedMethods: []string{"GET"}})
+	r.Handle("/reports", "DELETE", noop)
+	api := r.Group("/api")
+	api.Handle("/before", noop)
+	api.CORS(CORSOptions{MaxAge: time.Hour})
+	api.Handle("/after", noop)
+	return r
+}
+
+func TestPreflightMaxAge(t *testing.T) {
+	r := corsRouter()
+	for _, tt := range []struct {
+		method, target string
+		want           string
+	}{
+		{"PUT", "/upload", "86400"},         // route override
+		{"GET", "/upload", "600"},           // the path's other route keeps the policy
+		{"HEAD", "/upload", "600"},          // HEAD takes GET's
+		{"POST", "/upload", "600"},          // no route for the method: the policy's
+		{"DELETE", "/reports", "600"},       // an override without MaxAge keeps the policy's
+		{"POST", "/api/before", "3600"},     // group override, route added before
+		{"POST", "/api/after", "3600"},      // and after it
+		{"PUT", "/upload?draft=1", "86400"}, // the query plays no part
+	} {
+		resp := preflight(r, tt.method, tt.target)
+		if resp.Status != 204 {
+			t.Fatalf("preflight %s %s = %d, want 204", tt.method, tt.target, resp.Status)
+		}
+		if got := resp.Headers["Access-Control-Max-Age"]; got != tt.want {
+			t.Errorf("preflight %s %s: Access-Control-Max-Age = %q, want %q", tt.method, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestPreflightMaxAgeOmitted(t *testing.T) {
+	r := NewRouter()
+	r.SetCORS(&CORSPolicy{AllowedOrigins: []string{"*"}})
+	noop := func(req *Request) Response { return textResponse("ok") }
+	r.Handle("/plain", "GET", noop)
+	r.Handle("/cached", "GET", noop).CORS(CORSOptions{MaxAge: 90 * time.Second})
+
+	if got, ok := preflight(r, "GET", "/plain").Headers["Access-Control-Max-Age"]; ok {
+		t.Errorf("Access-Control-Max-Age = %q with no max age set, want it left out", got)
+	}
+	if got := preflight(r, "GET", "/cached").Headers["Access-Control-Max-Age"]; got != "90" {
+		t.Errorf("route override without a policy max age: Access-Control-Max-Age = %q, want 90", got)
+	}
+}
+
+func TestPreflightRouteOverrides(t *testing.T) {
+	r := corsRouter()
+
+	resp := preflight(r, "PUT", "/upload")
+	if got := resp.Headers["Access-Control-Allow-Headers"]; got != "Content-Type, X-Upload-Checksum" {
+		t.Errorf("PUT /upload: Access-Control-Allow-Headers = %q, want the route's", got)
+	}
+	if got := preflight(r, "GET", "/upload").Headers["Access-Control-Allow-Headers"]; got != "Content-Type" {
+		t.Errorf("GET /upload: Access-Control-Allow-Headers = %q, want the policy's", got)
+	}
+
+	// The GET route keeps DELETE same-origin; only preflights for GET see
+	// its override.
+	if got := preflight(r, "GET", "/reports").Headers["Access-Control-Allow-Methods"]; got != "GET, HEAD, OPTIONS" {
+		t.Errorf("GET /reports: Access-Control-Allow-Methods = %q, want DELETE left out", got)
+	}
+	if got := preflight(r, "DELETE", "/reports").Headers["Access-Control-Allow-Methods"]; got != "DELETE, GET, HEAD, OPTIONS" {
+		t.Errorf("DELETE /reports: Access-Control-Allow-Methods = %q, want every registered method", got)
+	}
+}
+
+func TestPreflightNotAnswered(t *testing.T) {
+	r := corsRouter()
+
+	req := &Request{Method: "OPTIONS", Path: "/upload", Headers: map[string]string{
+		"origin":                        "https://evil.example.com",
+		"access-control-request-method": "PUT",
+	}}
+	req.setTarget("/upload")
+	if resp := r.Route(req); resp.Headers["Access-Control-Max-Age"] != "" || resp.Headers["Access-Control-Allow-Origin"] != "" {
+		t.Errorf("preflight from a disallowed origin got CORS headers %v", resp.Headers)
+	}
+	if resp := preflight(r, "GET", "/nowhere"); resp.Status != 404 {
+		t.Errorf("preflight for an unrouted path = %d, want 404", resp.Status)
+	}
+	// Only preflights carry Max-Age.
+	req = &Request{Method: "PUT", Headers: map[string]string{"origin": "https://app.example.com"}}
+	req.setTarget("/upload")
+	resp := r.Route(req)
+	if resp.Headers["Access-Control-Allow-Origin"] != "https://app.example.com" {
+		t.Errorf("actual request: Access-Control-Allow-Origin = %q", resp.Headers["Access-Control-Allow-Origin"])
+	}
+	if got, ok := resp.Headers["Access-Control-Max-Age"]; ok {
+		t.Errorf("actual request: Access-Control-Max-Age = %q, want it left out", got)
+	}
+}
+
+func TestServePreflightMaxAge(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com")
+	t.Setenv("CORS_MAX_AGE", "120")
+	_, addr := startServer(t)
+
+	resp := roundTrip(t, dial(t, addr), "OPTIONS", "/healthz", map[string]string{
+		"Origin":                        "https://app.example.com",
+		"Access-Control-Request-Method": "GET",
+	}, nil)
+	if resp.Status != 204 {
+		t.Fatalf("preflight = %d, want 204", resp.Status)
+	}
+	if got := resp.Header("Access-Control-Max-Age"); got != "120" {
+		t.Errorf("Access-Control-Max-Age = %q, want 120 from CORS_MAX_AGE", got)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// errClientClosed is the cause of a request context cancelled because the
+// client closed the connection before the response was sent, see watch.
+var errClientClosed = errors.New("client closed the connection")
+
+// isPeerDisconnect reports whether err means the client went away: the
+// connection was reset or closed under us. Browsers do this routinely,
+// e.g. when a tab is closed mid-download, so these errors are expected
+// and not worth a warning.
+func isPeerDisconnect(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, errClientClosed)
+}
+
+// logSendError logs a failure to send a response: at Debug level, counted
+// in client_disconnects_total, when the client disconnected, and as a
+// warning otherwise, counting write timeouts in write_timeouts_total.
+func logSendError(what string, err error) {
+	if isPeerDisconnect(err) {
+		Metrics.Counter("client_disconnects_total").Inc()
+		utils.Debug("Client disconnected during %s: %v", what, err)
+		return
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		Metrics.Counter("write_timeouts_total").Inc()
+		utils.Warn("Write timeout sending %s, closing connection: %v", what, err)
+		return
+	}
+	utils.Warn("Failed to send %s: %v", what, err)
+}
+
+// logReadError logs a failure to read part of a request, at Debug level,
+// and counted like a failed send, when the client disconnected.
+func logReadError(what string, err error) {
+	if isPeerDisconnect(err) {
+		Metrics.Counter("client_disconnects_total").Inc()
+		utils.Debug("Client disconnected while sending %s: %v", what, err)
+		return
+	}
+	utils.Error("Failed to read %s: %v", what, err)
+}
+
+// watch reads from the connection in the background while a request is
+// handled and its response sent, and calls cancel if the client closes the
+// connection, with errClientClosed as the cause, so Request.Context is
+// cancelled even before a write fails.
+// It is only worth calling with nothing buffered ahead of the source: a
+// client that already pipelined its next request is still there. A byte
+// the client does send is kept for the next request.
+//
+// The returned stop ends the read by moving the read deadline into the
+// past, and must be called before the source is read again. It leaves no
+// read deadline set.
+func (s *connSource) watch(cancel context.CancelCauseFunc) (stop func()) {
+	done := make(chan struct{})
+	s.conn.SetReadDeadline(time.Time{})
+	go func() {
+		defer close(done)
+		var buf [1]byte
+		n, err := s.conn.Read(buf[:])
+		if n > 0 {
+			s.stash = append(s.stash, buf[0])
+			return
+		}
+		if err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+			return
+		}
+		utils.Debug("Client went away while its request was handled: %v", err)
+		s.err = err
+		cancel(errClientClosed)
+	}()
+	return func() {
+		s.conn.SetReadDeadline(time.Unix(1, 0))
+		<-done
+		s.conn.SetReadDeadline(time.Time{})
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsPeerDisconnect(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{syscall.ECONNRESET, true},
+		{&net.OpError{Op: "write", Err: syscall.EPIPE}, true},
+		{fmt.Errorf("sending: %w", net.ErrClosed), true},
+		{io.ErrClosedPipe, true},
+		{errClientClosed, true},
+		{io.ErrUnexpectedEOF, false},
+		{errors.New("connection reset by peer"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := isPeerDisconnect(tt.err); got != tt.want {
+			t.Errorf("isPeerDisconnect(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestStreamStopsAfterClientReset(t *testing.T) {
+	cancelled := make(chan bool, 1)
+	accepted := make(chan int, 1) // writes accepted after the reset
+	stopped := make(chan error, 1)
+	logAtLevel(t, "warn")
+	_, addr := startServerWithRoutes(t, func(r *Router) {
+		r.Handle("/stream", "GET", func(req *Request) Response {
+			return Response{
+				Version: HTTPVersion,
+				Status:  200,
+				Reason:  "OK",
+				Headers: map[string]string{"Content-Type": "text/plain"},
+				StreamFunc: func(w io.Writer) error {
+					sw, _ := AsStreamWriter(w)
+					io.WriteString(w, "first\n")
+					sw.Flush()
+
+					select {
+					case <-req.Context().Done():
+						cancelled <- true
+					case <-time.After(2 * time.Second):
+						cancelled <- false
+					}
+					// Give each write time to reach the socket, so the
+					// one that finds the client gone fails the next.
+					for n := 0; n < 100; n++ {
+						if _, err := io.WriteString(w, "more\n"); err != nil {
+							accepted <- n
+							stopped <- err
+							return err
+						}
+						sw.Flush()
+						time.Sleep(10 * time.Millisecond)
+					}
+					accepted <- 100
+					stopped <- nil
+					return nil
+				},
+			}
+		})
+	})
+
+	disconnects := Metrics.Counter("client_disconnects_total")
+	before := disconnects.Value()
+	logged := captureLog(t, func() {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.WriteString(conn, "GET /stream HTTP/1.1\r\nHost: x\r\n\r\n"); err != nil {
+			t.Fatal(err)
+		}
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("reading the stream: %v", err)
+			}
+			if strings.HasPrefix(line, "first") {
+				break
+			}
+		}
+		// Closing with a zero linger sends RST rather than FIN, as a
+		// browser tab being closed mid-download often does.
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+
+		if !<-cancelled {
+			t.Error("request context not cancelled after the client reset")
+		}
+		if n := <-accepted; n > 1 {
+			t.Errorf("StreamFunc wrote %d more times after the reset, want at most 1", n)
+		}
+		if err := <-stopped; !isPeerDisconnect(err) {
+			t.Errorf("StreamFunc write error = %v, want a peer disconnect", err)
+		}
+		waitFor(t, "the disconnect to be counted", func() bool { return disconnects.Value() > before })
+	})
+
+	for _, line := range strings.Split(logged, "\n") {
+		if strings.HasPrefix(line, "[WARN]") || strings.HasPrefix(line, "[ERROR]") {
+			t.Errorf("client reset logged %q, want nothing above Debug", line)
+		}
+	}
+}
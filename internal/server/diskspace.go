@@ -0,0 +1,55 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/Abb133Se/httpServer/internal/diskspace"
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// DefaultUploadSpaceMargin is how many bytes the files API keeps free
+// beyond an upload's size unless FILES_UPLOAD_SPACE_MARGIN says otherwise,
+// see DiskSpaceGuard.
+const DefaultUploadSpaceMargin = 10 << 20
+
+// statDisk reads the usage of the filesystem holding a path. It is a
+// variable so the space checks can be driven without filling a disk.
+var statDisk = diskspace.Stat
+
+// DiskSpaceGuard is pre-body middleware (see Route.BeforeBody) that answers
+// 507 Insufficient Storage, without reading the body, to uploads whose
+// declared Content-Length would leave less than margin bytes free on the
+// filesystem holding dir. Bodies of unknown length, and platforms where
+// free space cannot be read, are let through; a write that then runs out
+// of space fails with 507 as well, see CopyBodyToFile.
+func DiskSpaceGuard(dir string, margin int64) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) Response {
+			size, err := req.declaredLength()
+			if err != nil || size == 0 {
+				return next(req)
+			}
+			usage, err := statDisk(dir)
+			if err != nil {
+				utils.Debug("Not checking free space for %s: %v", dir, err)
+				return next(req)
+			}
+			if need := size + margin; need > 0 && uint64(need) > usage.Free {
+				Metrics.Counter("files_insufficient_storage_total").Inc()
+				utils.Warn("Refused %s %s: %d byte upload, %d bytes free", req.Method, req.Path, size, usage.Free)
+				return NewHTTPError(507, fmt.Sprintf("upload of %d bytes does not fit in the free space", size)).Response()
+			}
+			return next(req)
+		}
+	}
+}
+
+// storageError turns an error from a write that ran out of space into a
+// 507 *HTTPError, and returns any other error unchanged.
+func storageError(err error) error {
+	if !diskspace.IsFull(err) {
+		return err
+	}
+	Metrics.Counter("files_insufficient_storage_total").Inc()
+	return NewHTTPError(507, "out of disk space")
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/Abb133Se/httpServer/internal/diskspace"
+)
+
+// fakeFreeSpace makes the disk space checks see free bytes available.
+func fakeFreeSpace(t *testing.T, free uint64) {
+	t.Helper()
+	saved := statDisk
+	statDisk = func(string) (diskspace.Usage, error) {
+		return diskspace.Usage{Total: free * 2, Free: free}, nil
+	}
+	t.Cleanup(func() { statDisk = saved })
+}
+
+func TestDiskSpaceGuard(t *testing.T) {
+	fakeFreeSpace(t, 1000)
+	upload := func(req *Request) Response { return Response{Status: 201} }
+	tests := []struct {
+		margin int64
+		length string
+		want   int
+	}{
+		{0, "1000", 201},
+		{0, "1001", 507},
+		{100, "900", 201},
+		{100, "901", 507},
+		{100, "", 201}, // unknown length is let through
+	}
+	for _, tt := range tests {
+		req := &Request{Method: "PUT", Path: "/files/a", Headers: map[string]string{}}
+		if tt.length != "" {
+			req.Headers["content-length"] = tt.length
+		}
+		if got := DiskSpaceGuard("/data", tt.margin)(upload)(req); got.Status != tt.want {
+			t.Errorf("margin %d, Content-Length %q: status %d, want %d", tt.margin, tt.length, got.Status, tt.want)
+		}
+	}
+}
+
+func TestUploadSpaceMarginPerServer(t *testing.T) {
+	chdirPublic(t)
+	fakeFreeSpace(t, 1<<20)
+	t.Setenv("FILES_UPLOAD_SPACE_MARGIN", strconv.Itoa(1<<20))
+	_, strict := startServer(t)
+	t.Setenv("FILES_UPLOAD_SPACE_MARGIN", "0")
+	_, lenient := startServer(t)
+
+	body := make([]byte, 10)
+	if resp := roundTrip(t, dial(t, strict), "PUT", "/files/a.bin", nil, body); resp.Status != 507 {
+		t.Errorf("PUT with a 1MB margin = %d, want 507", resp.Status)
+	}
+	if resp := roundTrip(t, dial(t, lenient), "PUT", "/files/a.bin", nil, body); resp.Status != 200 {
+		t.Errorf("PUT without a margin = %d, want 200: the first server's margin leaked", resp.Status)
+	}
+}
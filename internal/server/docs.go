@@ -0,0 +1,298 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// RouteDoc is human-oriented metadata attached to a route with Route.Doc.
+type RouteDoc struct {
+	Summary     string
+	Description string
+	Params      []ParamDoc // documented parameters; path params are also inferred
+}
+
+// ParamDoc documents one path or query parameter.
+type ParamDoc struct {
+	Name        string
+	In          string // "path" or "query"
+	Description string
+}
+
+// Doc attaches documentation to the route for the /docs page.
+func (rt *Route) Doc(doc RouteDoc) *Route {
+	rt.doc = doc
+	return rt
+}
+
+// DocEntry describes one documented path and all methods registered on it.
+type DocEntry struct {
+	Pattern string
+	Methods []string
+	Prefix  bool
+	Doc     RouteDoc
+	Params  []ParamDoc
+	Anchor  string
+	Example string // curl command line
+}
+
+// DocSection is a group of entries sharing a route group or first path
+// segment.
+type DocSection struct {
+	Title   string
+	Entries []DocEntry
+}
+
+// DocsPage is the data passed to the docs template.
+type DocsPage struct {
+	BaseURL  string
+	Sections []DocSection
+}
+
+// DefaultDocsTemplate renders the /docs page unless the application
+// supplies its own template to DocsHandler. The template is executed with
+// a DocsPage.
+var DefaultDocsTemplate = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>API documentation</title></head>
+<body>
+<h1>API documentation</h1>
+{{range .Sections}}<section>
+<h2>{{.Title}}</h2>
+{{range .Entries}}<article id="{{.Anchor}}">
+<h3><a href="#{{.Anchor}}">{{range $i, $m := .Methods}}{{if $i}}, {{end}}{{$m}}{{end}} {{.Pattern}}{{if .Prefix}}*{{end}}</a></h3>
+{{with .Doc.Summary}}<p><strong>{{.}}</strong></p>{{end}}
+{{with .Doc.Description}}<p>{{.}}</p>{{end}}
+{{with .Params}}<table>
+<tr><th>Parameter</th><th>In</th><th>Description</th></tr>
+{{range .}}<tr><td><code>{{.Name}}</code></td><td>{{.In}}</td><td>{{.Description}}</td></tr>
+{{end}}</table>{{end}}
+<pre><code>{{.Example}}</code></pre>
+</article>
+{{end}}</section>
+{{end}}</body>
+</html>
+`))
+
+// DocsHandler returns a handler that documents every route registered on
+// the router at request time. Clients accepting text/html get tmpl (or
+// DefaultDocsTemplate if nil); everyone else, such as curl, gets plain
+// text.
+func (r *Router) DocsHandler(tmpl *template.Template) HandlerFunc {
+	if tmpl == nil {
+		tmpl = DefaultDocsTemplate
+	}
+	return func(req *Request) Response {
+		baseURL := "http://" + req.Headers["host"]
+		if req.TLSState != nil {
+			baseURL = "https://" + req.Headers["host"]
+		}
+		table := r.current()
+		baseURL += table.basePath
+		page := DocsPage{BaseURL: baseURL, Sections: table.docSections(baseURL)}
+
+		if negotiateErrorFormat(req.Headers["accept"]) != "html" {
+			return Response{
+				Version: HTTPVersion,
+				Status:  200,
+				Reason:  "OK",
+				Headers: map[string]string{"Content-Type": "text/plain", "Vary": "Accept"},
+				Body:    renderDocsText(page),
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, page); err != nil {
+			utils.Error("Failed to render docs: %v", err)
+			return InternalServerErrorResponse()
+		}
+		return Response{
+			Version: HTTPVersion,
+			Status:  200,
+			Reason:  "OK",
+			Headers: map[string]string{"Content-Type": "text/html", "Vary": "Accept"},
+			Body:    buf.Bytes(),
+		}
+	}
+}
+
+// docSections collects routes into documentation sections. Routes of a
+// group are listed under the group prefix; other routes under their first
+// path segment. Examples use baseURL as the server address.
+func (r *Router) docSections(baseURL string) []DocSection {
+	var titles []string
+	sections := make(map[string][]*DocEntry)
+	index := make(map[string]*DocEntry)
+
+	add := func(title string, rt *Route) {
+		key := rt.pattern
+		if rt.regex != nil {
+			key = rt.regex.String()
+		}
+		if entry, ok := index[key]; ok {
+			if rt.method != "" {
+				entry.Methods = append(entry.Methods, rt.method)
+			}
+			if entry.Doc.Summary == "" && entry.Doc.Description == "" {
+				entry.Doc = rt.doc
+				entry.Params = routeParams(rt)
+			}
+			return
+		}
+
+		entry := &DocEntry{
+			Pattern: key,
+			Prefix:  rt.isPrefix,
+			Doc:     rt.doc,
+			Params:  routeParams(rt),
+			Anchor:  docAnchor(key),
+		}
+		if rt.method != "" {
+			entry.Methods = []string{rt.method}
+		}
+		index[key] = entry
+		if _, ok := sections[title]; !ok {
+			titles = append(titles, title)
+		}
+		sections[title] = append(sections[title], entry)
+	}
+
+	for _, rt := range r.routes {
+		add(docSectionTitle(rt.pattern), rt)
+	}
+	for _, g := range r.groups {
+		for _, rt := range g.routes {
+			add(g.prefix, rt)
+		}
+	}
+
+	sort.Strings(titles)
+	result := make([]DocSection, 0, len(titles))
+	for _, title := range titles {
+		section := DocSection{Title: title}
+		for _, entry := range sections[title] {
+			if len(entry.Methods) == 0 {
+				entry.Methods = []string{"ANY"}
+			}
+			entry.Example = docExample(entry, baseURL)
+			section.Entries = append(section.Entries, *entry)
+		}
+		result = append(result, section)
+	}
+	return result
+}
+
+// routeParams merges the parameters inferred from a route pattern with
+// those documented on the route, keeping documented descriptions.
+func routeParams(rt *Route) []ParamDoc {
+	documented := make(map[string]bool)
+	for _, p := range rt.doc.Params {
+		documented[p.In+":"+p.Name] = true
+	}
+
+	var params []ParamDoc
+	for _, segment := range strings.Split(rt.pattern, "/") {
+		name, ok := strings.CutPrefix(segment, ":")
+		name = strings.TrimSuffix(name, "?")
+		if wildcard, isWildcard := wildcardParam(segment); isWildcard {
+			name, ok = wildcard, true
+		}
+		if ok && !documented["path:"+name] {
+			params = append(params, ParamDoc{Name: name, In: "path"})
+		}
+	}
+	return append(params, rt.doc.Params...)
+}
+
+// docSectionTitle returns the first path segment of pattern, e.g. "/user/".
+func docSectionTitle(pattern string) string {
+	trimmed := strings.TrimPrefix(pattern, "/")
+	first, _, found := strings.Cut(trimmed, "/")
+	if !found {
+		return "/"
+	}
+	return "/" + first + "/"
+}
+
+// docAnchor turns a pattern into an HTML id, e.g. "/user/:id" -> "user-id".
+func docAnchor(pattern string) string {
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(pattern) {
+		if ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(c)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return "root"
+	}
+	return b.String()
+}
+
+// docExample builds a curl command for the entry's first method, with
+// path parameters shown as {name} placeholders.
+func docExample(entry *DocEntry, baseURL string) string {
+	segments := strings.Split(entry.Pattern, "/")
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			segments[i] = "{" + strings.TrimSuffix(name, "?") + "}"
+		} else if name, ok := wildcardParam(segment); ok {
+			segments[i] = "{" + name + "}"
+		}
+	}
+	path := strings.Join(segments, "/")
+
+	var query []string
+	for _, p := range entry.Params {
+		if p.In == "query" {
+			query = append(query, p.Name+"={"+p.Name+"}")
+		}
+	}
+	if len(query) > 0 {
+		path += "?" + strings.Join(query, "&")
+	}
+
+	method := entry.Methods[0]
+	if method == "GET" || method == "ANY" {
+		return fmt.Sprintf("curl '%s%s'", baseURL, path)
+	}
+	return fmt.Sprintf("curl -X %s '%s%s'", method, baseURL, path)
+}
+
+// renderDocsText renders the docs page as plain text for non-HTML clients.
+func renderDocsText(page DocsPage) []byte {
+	var b strings.Builder
+	b.WriteString("API documentation\n")
+	for _, section := range page.Sections {
+		fmt.Fprintf(&b, "\n== %s ==\n", section.Title)
+		for _, entry := range section.Entries {
+			pattern := entry.Pattern
+			if entry.Prefix {
+				pattern += "*"
+			}
+			fmt.Fprintf(&b, "\n%s %s\n", strings.Join(entry.Methods, ", "), pattern)
+			if entry.Doc.Summary != "" {
+				fmt.Fprintf(&b, "  %s\n", entry.Doc.Summary)
+			}
+			if entry.Doc.Description != "" {
+				fmt.Fprintf(&b, "  %s\n", entry.Doc.Description)
+			}
+			for _, p := range entry.Params {
+				fmt.Fprintf(&b, "  - %s (%s) %s\n", p.Name, p.In, p.Description)
+			}
+			fmt.Fprintf(&b, "  $ %s\n", entry.Example)
+		}
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"html/template"
+	"strings"
+	"testing"
+)
+
+// docsRouter returns a router with a documented parameterized route, a
+// grouped route and an undocumented one, and its docs handler.
+func docsRouter(tmpl *template.Template) HandlerFunc {
+	r := NewRouter()
+	noop := func(*Request) Response { return textResponse("") }
+	r.Handle("/users/:id", "GET", noop).Doc(RouteDoc{
+		Summary:     "Fetch a <user>",
+		Description: `Returns the user as JSON & <script>alert("x")</script>`,
+		Params:      []ParamDoc{{Name: "fields", In: "query", Description: "Comma-separated <fields>"}},
+	})
+	r.Handle("/users/:id", "DELETE", noop)
+	r.Group("/api/v1").Handle("/items/:item?", noop).Doc(RouteDoc{Summary: "Grouped items"})
+	r.Handle("/health", "GET", noop)
+	return r.DocsHandler(tmpl)
+}
+
+func docsRequest(accept string) *Request {
+	return &Request{Method: "GET", Path: "/docs", Headers: map[string]string{"host": "api.example", "accept": accept}}
+}
+
+func TestDocsHTML(t *testing.T) {
+	resp := docsRouter(nil)(docsRequest("text/html"))
+	body := string(resp.Body)
+	if resp.Status != 200 || !strings.HasPrefix(resp.Headers["Content-Type"], "text/html") || resp.Headers["Vary"] != "Accept" {
+		t.Fatalf("GET /docs = %d %v", resp.Status, resp.Headers)
+	}
+	for _, want := range []string{
+		`<article id="users-id">`,
+		`<a href="#users-id">GET, DELETE /users/:id</a>`,
+		"Fetch a &lt;user&gt;",
+		"Returns the user as JSON &amp; &lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;",
+		"<td><code>id</code></td><td>path</td>",
+		"<td><code>fields</code></td><td>query</td><td>Comma-separated &lt;fields&gt;</td>",
+		"curl &#39;http://api.example/users/{id}?fields={fields}&#39;",
+		"<h2>/api/v1</h2>",
+		"ANY /api/v1/items/:item?",
+		"<td><code>item</code></td><td>path</td>",
+		"<h2>/</h2>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("docs page lacks %q", want)
+		}
+	}
+	if strings.Contains(body, "<script>") || strings.Contains(body, "<user>") {
+		t.Error("docs page contains unescaped HTML from the route docs")
+	}
+}
+
+func TestDocsText(t *testing.T) {
+	for _, accept := range []string{"", "*/*", "text/plain"} {
+		resp := docsRouter(nil)(docsRequest(accept))
+		body := string(resp.Body)
+		if !strings.HasPrefix(resp.Headers["Content-Type"], "text/plain") {
+			t.Errorf("Accept %q: Content-Type %q, want plain text", accept, resp.Headers["Content-Type"])
+		}
+		for _, want := range []string{
+			"GET, DELETE /users/:id\n  Fetch a <user>\n",
+			"  - id (path) \n  - fields (query) Comma-separated <fields>\n",
+			"  $ curl 'http://api.example/users/{id}?fields={fields}'\n",
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("Accept %q: text docs lack %q in\n%s", accept, want, body)
+			}
+		}
+	}
+}
+
+func TestDocsCustomTemplate(t *testing.T) {
+	tmpl := template.Must(template.New("docs").Parse(`{{range .Sections}}[{{.Title}}]{{end}}`))
+	resp := docsRouter(tmpl)(docsRequest("text/html"))
+	if got := string(resp.Body); got != "[/][/api/v1][/users/]" {
+		t.Errorf("custom template rendered %q", got)
+	}
+}
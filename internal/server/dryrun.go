@@ -0,0 +1,311 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/clock"
+	"github.com/Abb133Se/httpServer/internal/config"
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// DryRun checks config the way StartServer would start with it, short of
+// serving: every setting is parsed, the routes are registered on a scratch
+// router and validated, the TLS key pair and client CAs are loaded, the
+// directories and files the server reads and writes are checked for
+// existence and permissions, and the listen addresses for syntax.
+//
+// Nothing is changed: no socket is bound, no directory created, no log
+// opened and no background task such as the trash sweep started. Warnings,
+// such as a certificate about to expire, are listed with the summary
+// logged on success.
+//
+// Returns:
+//   - error: nil if the server would start, otherwise an error joining
+//     every problem found.
+//
+// Example:
+//
+//	if err := server.DryRun(config.LoadConfig()); err != nil {
+//	    log.Fatalf("bad configuration:\n%v", err)
+//	}
+func DryRun(config *config.Config) error {
+	var problems []error
+	var warnings []string
+	invalid := func(setting string, err error) {
+		if err != nil {
+			problems = append(problems, fmt.Errorf("invalid %s: %w", setting, err))
+		}
+	}
+
+	invalid("PORT", checkListenAddr(config.Port))
+	if config.AdminAddr != "" {
+		invalid("ADMIN_ADDR", checkListenAddr(config.AdminAddr))
+	}
+
+	router := NewRouter()
+	queryPolicy, err := ParseQueryPolicy(config.QueryDuplicates)
+	invalid("QUERY_DUPLICATES", err)
+	router.SetQueryPolicy(queryPolicy)
+	methodMode, err := ParseMethodMode(config.MethodMode)
+	invalid("METHOD_MODE", err)
+	router.SetMethodMode(methodMode)
+	_, err = ParseMiddlewareTraceMode(config.MiddlewareTrace)
+	invalid("MIDDLEWARE_TRACE", err)
+	_, err = ParseNormalizePolicy(config.NormalizePaths)
+	invalid("NORMALIZE_PATHS", err)
+	_, err = BodyPoliciesFromConfig(config.BodyPolicies)
+	invalid("BODY_POLICIES", err)
+	if len(config.BannedIPs) > 0 {
+		_, err := BanFilter(config.BannedIPs)
+		invalid("BANNED_IPS", err)
+	}
+	if len(config.TrustedProxies) > 0 {
+		_, err := TrustFilter(config.TrustedProxies)
+		invalid("TRUSTED_PROXIES", err)
+	}
+	_, err = ParseExtraHeaders(config.ExtraResponseHeaders)
+	invalid("EXTRA_RESPONSE_HEADERS", err)
+	if config.AltSvc != "" {
+		invalid("ALT_SVC", validateHeaderValue(config.AltSvc))
+	}
+	if config.AccessLog != "" && config.AccessLog != "off" {
+		_, err := NewAccessLogSink(nil, config.AccessLogFormat)
+		invalid("ACCESS_LOG_FORMAT", err)
+	}
+	if len(config.AllowedHosts) > 0 {
+		_, err := NewHostAllowList(config.AllowedHosts, config.DevMode)
+		invalid("ALLOWED_HOSTS", err)
+	}
+	switch config.JSONFields {
+	case "off", "lenient", "strict":
+	default:
+		invalid("JSON_FIELDS", fmt.Errorf("%q is not off, lenient or strict", config.JSONFields))
+	}
+	allowPaths := config.MaintenanceAllowPaths
+	if len(allowPaths) == 0 {
+		allowPaths = DefaultMaintenanceAllowPaths
+	}
+	if _, err := NewMaintenance(config.MaintenanceAllowIPs, allowPaths, config.MaintenanceStateFile); err != nil {
+		problems = append(problems, err)
+	}
+	if len(config.FilesTenants) > 0 {
+		if config.FilesMetaEnabled {
+			problems = append(problems, errors.New("FILES_META_ENABLED is not supported together with FILES_TENANTS"))
+		}
+		if config.FilesTrashDir != "" {
+			problems = append(problems, errors.New("FILES_TRASH_DIR is not supported together with FILES_TENANTS"))
+		}
+	}
+
+	if config.TLSCertFile != "" && config.TLSKeyFile != "" {
+		tlsConfig, err := buildTLSConfig(config)
+		if err != nil {
+			problems = append(problems, err)
+		} else if warning, err := certValidity(tlsConfig, time.Now(), config.TLSExpiryWarning); err != nil {
+			problems = append(problems, err)
+		} else if warning != "" {
+			warnings = append(warnings, warning)
+		}
+	} else if config.TLSCertFile != "" || config.TLSKeyFile != "" {
+		warnings = append(warnings, "only one of TLS_CERT_FILE and TLS_KEY_FILE is set; the server will serve plain HTTP")
+	}
+
+	problems = append(problems, checkPaths(config)...)
+
+	routes, err := dryRunRoutes(config, router)
+	if err != nil {
+		problems = append(problems, err)
+	}
+
+	if len(problems) > 0 {
+		return errors.Join(problems...)
+	}
+	utils.Info("Check passed: listen=%s tls=%t routes=%d public_dir=%s warnings=%d",
+		config.Port, config.TLSCertFile != "" && config.TLSKeyFile != "", routes, getPublicDir(), len(warnings))
+	for _, warning := range warnings {
+		utils.Info("  warning: %s", warning)
+	}
+	return nil
+}
+
+// dryRunRoutes registers the routes StartServer would on router, and on a
+// router of their own for ADMIN_ADDR, and validates them. It returns the
+// number of routes. Tenant roots are left out, as setting them up creates
+// them, and so are static mounts of missing directories; checkPaths
+// reports on both.
+func dryRunRoutes(cfg *config.Config, router *Router) (int, error) {
+	scratch := *cfg
+	scratch.FilesTenants = nil
+	scratch.StaticMounts = slices.DeleteFunc(slices.Clone(cfg.StaticMounts), func(mount config.StaticMountConfig) bool {
+		return checkDir(mount.Dir, false) != nil
+	})
+	srv := NewServer(&scratch, router)
+	defer srv.cancelBase()
+	if cfg.FilesTrashDir != "" && len(cfg.FilesTenants) == 0 {
+		srv.trash = &Trash{dir: cfg.FilesTrashDir, ttl: cfg.FilesTrashTTL, clock: clock.Real, files: srv.files}
+	}
+	if cfg.FilesMetaEnabled {
+		srv.filesMeta = NewFileMetadata(srv.files.meta, cfg.FilesMetaMaxBytes, cfg.FilesMetaHeaders)
+		srv.filesMeta.files = srv.files
+	}
+	if cfg.SSEEnabled {
+		srv.SetBroker(NewBroker())
+	}
+
+	var problems []error
+	routes := 0
+	adminRouter := router
+	if cfg.AdminAddr != "" {
+		adminRouter = NewRouter()
+		srv.registerAdminRoutes(adminRouter, &scratch)
+		if err := adminRouter.Validate(); err != nil {
+			problems = append(problems, fmt.Errorf("invalid admin route configuration:\n%w", err))
+		}
+		routes += len(adminRouter.routes)
+	}
+	if err := srv.registerRoutes(router, &scratch, adminRouter == router); err != nil {
+		problems = append(problems, err)
+	} else if err := router.Validate(); err != nil {
+		problems = append(problems, fmt.Errorf("invalid route configuration:\n%w", err))
+	}
+	routes += len(router.routes)
+	for _, group := range router.groups {
+		routes += len(group.routes)
+	}
+	return routes, errors.Join(problems...)
+}
+
+// checkPaths checks the directories and files config names: those read
+// must exist and be readable, those written must be writable, or be
+// creatable where startup creates them.
+func checkPaths(config *config.Config) []error {
+	var problems []error
+	add := func(setting string, err error) {
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", setting, err))
+		}
+	}
+
+	if len(config.FilesTenants) == 0 {
+		add("public directory", checkDir(getPublicDir(), !config.FilesReadOnly))
+	}
+	for _, tenant := range config.FilesTenants {
+		add("FILES_TENANTS "+tenant.Name, checkCreatableDir(tenant.Root))
+	}
+	for _, mount := range config.StaticMounts {
+		add("STATIC_MOUNTS "+mount.Prefix, checkDir(mount.Dir, false))
+	}
+	if config.ErrorPagesDir != "" {
+		add("ERROR_PAGES_DIR", checkDir(config.ErrorPagesDir, false))
+	}
+	if config.FilesTrashDir != "" {
+		add("FILES_TRASH_DIR", checkCreatableDir(config.FilesTrashDir))
+	}
+	if config.RecordDir != "" {
+		add("RECORD_DIR", checkCreatableDir(config.RecordDir))
+	}
+	if config.EventLog != "" && config.EventLog != "stdout" {
+		add("EVENT_LOG", checkWritableFile(config.EventLog))
+	}
+	if config.AccessLog != "" && config.AccessLog != "stdout" && config.AccessLog != "off" {
+		add("ACCESS_LOG", checkWritableFile(config.AccessLog))
+	}
+	if config.ShutdownReportFile != "" {
+		add("SHUTDOWN_REPORT_FILE", checkWritableFile(config.ShutdownReportFile))
+	}
+	if config.MaintenanceStateFile != "" {
+		add("MAINTENANCE_STATE_FILE", checkWritableFile(config.MaintenanceStateFile))
+	}
+	return problems
+}
+
+// checkDir checks that dir is a readable directory, and a writable one if
+// write is set.
+func checkDir(dir string, write bool) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return access(dir, write)
+}
+
+// checkCreatableDir checks that dir is a writable directory or, if it does
+// not exist yet, that its closest existing ancestor is one, so that startup
+// can create it.
+func checkCreatableDir(dir string) error {
+	for {
+		_, err := os.Stat(dir)
+		if err == nil {
+			return checkDir(dir, true)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return err
+		}
+		dir = parent
+	}
+}
+
+// checkWritableFile checks that file is a writable regular file or, if it
+// does not exist yet, that its directory is writable.
+func checkWritableFile(file string) error {
+	info, err := os.Stat(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return checkDir(filepath.Dir(file), true)
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", file)
+	}
+	return access(file, true)
+}
+
+// checkListenAddr checks that addr is a "host:port" address net.Listen
+// accepts, with an optional host, without listening on it.
+func checkListenAddr(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		if !strings.Contains(addr, ":") {
+			return fmt.Errorf("%w; did you mean %q?", err, ":"+addr)
+		}
+		return err
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return err
+	}
+	if host != "" && net.ParseIP(host) == nil && !isHostname(host) {
+		return fmt.Errorf("%q is not an IP address or host name", host)
+	}
+	return nil
+}
+
+// isHostname reports whether host is made of dot-separated labels of
+// letters, digits and hyphens.
+func isHostname(host string) bool {
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for _, c := range label {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+)
+
+func TestDryRunAcceptsDefaults(t *testing.T) {
+	chdirPublic(t)
+	t.Setenv("PORT", ":4221")
+	if err := DryRun(config.LoadConfig()); err != nil {
+		t.Errorf("DryRun = %v, want nil", err)
+	}
+}
+
+func TestDryRunReportsEveryProblem(t *testing.T) {
+	chdirPublic(t)
+	t.Setenv("PORT", "4221")
+	t.Setenv("QUERY_DUPLICATES", "sometimes")
+	t.Setenv("TRUSTED_PROXIES", "not-an-ip")
+	t.Setenv("JSON_FIELDS", "maybe")
+	t.Setenv("ERROR_PAGES_DIR", filepath.Join(t.TempDir(), "missing"))
+
+	err := DryRun(config.LoadConfig())
+	if err == nil {
+		t.Fatal("DryRun accepted an invalid configuration")
+	}
+	for _, want := range []string{
+		`invalid PORT: address 4221: missing port in address; did you mean ":4221"?`,
+		"invalid QUERY_DUPLICATES",
+		"invalid TRUSTED_PROXIES",
+		`invalid JSON_FIELDS: "maybe" is not off, lenient or strict`,
+		"ERROR_PAGES_DIR: stat ",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("DryRun error lacks %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestDryRunChangesNothing(t *testing.T) {
+	chdirPublic(t)
+	root := t.TempDir()
+	trash, record := filepath.Join(root, "trash"), filepath.Join(root, "spool", "record")
+	t.Setenv("PORT", ":4221")
+	t.Setenv("FILES_TRASH_DIR", trash)
+	t.Setenv("RECORD_DIR", record)
+
+	if err := DryRun(config.LoadConfig()); err != nil {
+		t.Fatalf("DryRun = %v, want nil", err)
+	}
+	for _, dir := range []string{trash, record} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("DryRun created %s", dir)
+		}
+	}
+}
+
+func TestCheckListenAddr(t *testing.T) {
+	for addr, ok := range map[string]bool{
+		":4221":          true,
+		"127.0.0.1:8080": true,
+		"[::1]:443":      true,
+		"localhost:http": true,
+		"4221":           false,
+		":99999":         false,
+		"bad_host!:80":   false,
+	} {
+		if err := checkListenAddr(addr); (err == nil) != ok {
+			t.Errorf("checkListenAddr(%q) = %v, want ok=%v", addr, err, ok)
+		}
+	}
+}
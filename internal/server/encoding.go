@@ -0,0 +1,107 @@
+package server
+
+import (
+	"strings"
+)
+
+// acceptEncoding is a parsed Accept-Encoding header.
+type acceptEncoding struct {
+	present bool               // the header was sent at all
+	q       map[string]float64 // explicitly listed codings
+	star    float64            // quality of "*", if hasStar
+	hasStar bool
+}
+
+// parseAcceptEncoding parses an Accept-Encoding header value. Codings are
+// lowercased, "x-gzip" and "x-compress" are folded into their standard
+// names, and a coding listed twice keeps its highest quality.
+func parseAcceptEncoding(header string, present bool) acceptEncoding {
+	ae := acceptEncoding{present: present, q: make(map[string]float64)}
+	for _, part := range strings.Split(header, ",") {
+		coding, q := parseMediaRange(part)
+		switch coding {
+		case "":
+			continue
+		case "x-gzip":
+			coding = "gzip"
+		case "x-compress":
+			coding = "compress"
+		}
+		if coding == "*" {
+			ae.star, ae.hasStar = max(ae.star, q), true
+			continue
+		}
+		if prev, ok := ae.q[coding]; !ok || q > prev {
+			ae.q[coding] = q
+		}
+	}
+	return ae
+}
+
+// quality returns the client's preference for coding, from 0 (refused)
+// to 1, following RFC 9110 section 12.5.3:
+//   - no header: every coding is acceptable.
+//   - listed codings use their q-value; "*" covers codings not listed.
+//   - identity is acceptable unless refused explicitly or through "*;q=0",
+//     so an empty header means identity only.
+func (ae acceptEncoding) quality(coding string) float64 {
+	coding = strings.ToLower(coding)
+	if !ae.present {
+		return 1
+	}
+	if q, ok := ae.q[coding]; ok {
+		return q
+	}
+	if ae.hasStar {
+		return ae.star
+	}
+	if coding == "identity" {
+		return 1
+	}
+	return 0
+}
+
+// AcceptsEncoding reports whether the client accepts a response with the
+// given content coding, e.g. "gzip" or "identity".
+func (r *Request) AcceptsEncoding(name string) bool {
+	return r.acceptEncoding().quality(name) > 0
+}
+
+// PreferredEncoding picks the content coding for a response from the
+// codings the server can produce, listed in the server's order of
+// preference. Identity is always a candidate.
+//
+// The coding with the highest client quality wins; ties go to the earlier
+// entry in available. Identity wins only if the client rated it above
+// every available coding, or if no available coding is acceptable. Without
+// an Accept-Encoding header, identity is chosen. If the client refuses
+// every candidate, including identity (as with "identity;q=0" and no
+// supported coding), it returns false and the caller should answer 406 Not
+// Acceptable.
+func (r *Request) PreferredEncoding(available ...string) (string, bool) {
+	ae := r.acceptEncoding()
+	if !ae.present {
+		return "identity", true
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range available {
+		if q := ae.quality(coding); q > bestQ {
+			best, bestQ = strings.ToLower(coding), q
+		}
+	}
+
+	// Identity competes with its own q-value only when the client rated
+	// it; when merely acceptable by default it is the fallback.
+	_, rated := ae.q["identity"]
+	if identityQ := ae.quality("identity"); identityQ > 0 && (best == "" || (rated || ae.hasStar) && identityQ > bestQ) {
+		best = "identity"
+	}
+	return best, best != ""
+}
+
+// acceptEncoding parses the request's Accept-Encoding header.
+func (r *Request) acceptEncoding() acceptEncoding {
+	header, present := r.Headers["accept-encoding"]
+	return parseAcceptEncoding(header, present)
+}
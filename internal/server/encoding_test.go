@@ -0,0 +1,74 @@
+package server
+
+import "testing"
+
+// noHeader stands for a request without Accept-Encoding in the tables
+// below.
+const noHeader = "<none>"
+
+// encodingRequest returns a request with the given Accept-Encoding.
+func encodingRequest(header string) *Request {
+	req := &Request{Headers: map[string]string{}}
+	if header != noHeader {
+		req.Headers["accept-encoding"] = header
+	}
+	return req
+}
+
+func TestPreferredEncoding(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		header string
+		want   string // "" when every candidate is refused, a 406
+	}{
+		{"no header", noHeader, "identity"},
+		{"empty header", "", "identity"},
+		{"single coding", "gzip", "gzip"},
+		{"legacy alias", "X-GZIP", "gzip"},
+		{"higher quality wins", "gzip;q=0.5, br", "br"},
+		{"ties go to the server's order", "br, gzip", "gzip"},
+		{"star", "*", "gzip"},
+		{"star with a listed coding", "br;q=0.2, *;q=0.8", "gzip"},
+		{"star refusing everything", "*;q=0", ""},
+		{"star refusing all but identity", "*;q=0, identity", "identity"},
+		{"identity refused", "identity;q=0", ""},
+		{"identity refused, coding accepted", "gzip, identity;q=0", "gzip"},
+		{"coding refused", "gzip;q=0", "identity"},
+		{"only unsupported codings", "deflate, zstd", "identity"},
+		{"identity rated above the coding", "gzip;q=0.5, identity", "identity"},
+		{"identity rated below the coding", "gzip, identity;q=0.5", "gzip"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := encodingRequest(tt.header).PreferredEncoding("gzip", "br")
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("PreferredEncoding = %q, %t; want %q, %t", got, ok, tt.want, tt.want != "")
+			}
+		})
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	for _, tt := range []struct {
+		header string
+		coding string
+		want   bool
+	}{
+		{noHeader, "gzip", true},
+		{noHeader, "identity", true},
+		{"", "gzip", false},
+		{"", "identity", true},
+		{"gzip", "GZIP", true},
+		{"gzip", "br", false},
+		{"gzip;q=0", "gzip", false},
+		{"gzip;q=0, gzip;q=0.5", "gzip", true},
+		{"*", "br", true},
+		{"*;q=0", "identity", false},
+		{"*;q=0, br", "br", true},
+		{"identity;q=0", "identity", false},
+		{"x-compress", "compress", true},
+	} {
+		if got := encodingRequest(tt.header).AcceptsEncoding(tt.coding); got != tt.want {
+			t.Errorf("Accept-Encoding %q: AcceptsEncoding(%q) = %t, want %t", tt.header, tt.coding, got, tt.want)
+		}
+	}
+}
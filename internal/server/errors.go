@@ -1,6 +1,13 @@
 package server
 
-import "github.com/Abb133Se/httpServer/internal/utils"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
 
 // Standard error responses
 func BadRequestResponse() Response {
@@ -10,6 +17,28 @@ func BadRequestResponse() Response {
 		Reason:  "Bad Request",
 		Headers: map[string]string{"Content-Type": "text/plain"},
 		Body:    []byte("400 Bad Request"),
+		problem: newProblem(400, "Bad Request", ""),
+	}
+}
+
+// BadRequestErrorResponse returns a 400 response whose body explains err,
+// such as a *ParamError naming the offending parameter.
+func BadRequestErrorResponse(err error) Response {
+	problem := newProblem(400, "Bad Request", err.Error())
+	var paramErr *ParamError
+	if errors.As(err, &paramErr) {
+		problem.Extensions = map[string]any{
+			"parameter": paramErr.Name,
+			"source":    paramErr.Source,
+		}
+	}
+	return Response{
+		Version: HTTPVersion,
+		Status:  400,
+		Reason:  "Bad Request",
+		Headers: map[string]string{"Content-Type": "text/plain"},
+		Body:    []byte("400 Bad Request: " + err.Error()),
+		problem: problem,
 	}
 }
 
@@ -20,6 +49,7 @@ func InternalServerErrorResponse() Response {
 		Reason:  "Internal Server Error",
 		Headers: map[string]string{"Content-Type": "text/plain"},
 		Body:    []byte("500 Internal Server Error"),
+		problem: newProblem(500, "Internal Server Error", ""),
 	}
 }
 
@@ -30,6 +60,70 @@ func NotFoundResponse() Response {
 		Reason:  "Not Found",
 		Headers: map[string]string{"Content-Type": "text/plain"},
 		Body:    []byte("404 Not Found"),
+		problem: newProblem(404, "Not Found", ""),
+	}
+}
+
+func ForbiddenResponse() Response {
+	return Response{
+		Version: HTTPVersion,
+		Status:  403,
+		Reason:  "Forbidden",
+		Headers: map[string]string{"Content-Type": "text/plain"},
+		Body:    []byte("403 Forbidden"),
+		problem: newProblem(403, "Forbidden", ""),
+	}
+}
+
+// MisdirectedRequestResponse is sent for a request whose Host this server
+// does not answer for, such as one outside ALLOWED_HOSTS.
+func MisdirectedRequestResponse() Response {
+	return Response{
+		Version: HTTPVersion,
+		Status:  421,
+		Reason:  "Misdirected Request",
+		Headers: map[string]string{"Content-Type": "text/plain"},
+		Body:    []byte("421 Misdirected Request"),
+		problem: newProblem(421, "Misdirected Request", ""),
+	}
+}
+
+func InsufficientStorageResponse() Response {
+	return Response{
+		Version: HTTPVersion,
+		Status:  507,
+		Reason:  "Insufficient Storage",
+		Headers: map[string]string{"Content-Type": "text/plain"},
+		Body:    []byte("507 Insufficient Storage"),
+		problem: newProblem(507, "Insufficient Storage", ""),
+	}
+}
+
+func RangeNotSatisfiableResponse(size int64) Response {
+	return Response{
+		Version: HTTPVersion,
+		Status:  416,
+		Reason:  "Range Not Satisfiable",
+		Headers: map[string]string{
+			"Content-Type":  "text/plain",
+			"Content-Range": fmt.Sprintf("bytes */%d", size),
+		},
+		Body:    []byte("416 Range Not Satisfiable"),
+		problem: newProblem(416, "Range Not Satisfiable", ""),
+	}
+}
+
+func ServiceUnavailableResponse(retryAfter time.Duration) Response {
+	return Response{
+		Version: HTTPVersion,
+		Status:  503,
+		Reason:  "Service Unavailable",
+		Headers: map[string]string{
+			"Content-Type": "text/plain",
+			"Retry-After":  strconv.Itoa(int(retryAfter.Seconds())),
+		},
+		Body:    []byte("503 Service Unavailable"),
+		problem: newProblem(503, "Service Unavailable", ""),
 	}
 }
 
@@ -42,7 +136,8 @@ func MethodNotAllowedResponse(allow string) Response {
 			"Content-Type": "text/plain",
 			"Allow":        allow,
 		},
-		Body: []byte("405 Method Not Allowed"),
+		Body:    []byte("405 Method Not Allowed"),
+		problem: newProblem(405, "Method Not Allowed", ""),
 	}
 }
 
@@ -55,6 +150,5 @@ func OptionsResponse(allow string) Response {
 		Headers: map[string]string{
 			"Allow": allow,
 		},
-		Body: []byte(allow),
 	}
 }
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// RequestEvent is the canonical record of one request, emitted once it has
+// been answered (see Server.SetEventSink). It is filled in as the request
+// moves through the parser, router, middleware and SendResponse, so a
+// request that failed early still produces an event with what was known:
+// a malformed request has no Method or Route, but its client, timestamps,
+// status and ErrorCode are set.
+type RequestEvent struct {
+	// Timestamps of the request's phases. Phases a request never reached
+	// are left out.
+	Start      time.Time         `json:"start"`                 // request head read, or parsing failed
+	BodyRead   time.Time         `json:"body_read,omitzero"`    // body read or drained
+	Handled    time.Time         `json:"handled,omitzero"`      // response produced
+	Sent       time.Time         `json:"sent,omitzero"`         // response written
+	DurationMS float64           `json:"duration_ms"`           // Start to Sent, or to the failure
+	RequestID  string            `json:"request_id,omitempty"`  // see RequestIDMiddleware
+	ClientIP   string            `json:"client_ip"`             // see Request.ClientIP
+	ClientPort int               `json:"client_port,omitempty"` // of the TCP peer
+	TLS        *EventTLS         `json:"tls,omitempty"`         // nil on plaintext connections
+	Method     string            `json:"method,omitempty"`      // request line
+	Target     string            `json:"target,omitempty"`      // request line, with the query
+	Version    string            `json:"version,omitempty"`     // request line
+	Host       string            `json:"host,omitempty"`        // see Request.Host
+	Headers    map[string]string `json:"headers,omitempty"`     // allow-listed request headers
+	Route      string            `json:"route,omitempty"`       // "METHOD pattern" of the matched route
+	Principal  string            `json:"principal,omitempty"`   // see PrincipalKey
+	RateLimit  *EventRateLimit   `json:"rate_limit,omitempty"`  // last rate limit applied
+	Status     int               `json:"status"`
+	BytesSent  int64             `json:"bytes_sent"`           // status line, headers and body
+	ErrorCode  string            `json:"error_code,omitempty"` // see EventError* constants
+	Error      string            `json:"error,omitempty"`
+}
+
+// EventTLS describes the TLS session of a RequestEvent.
+type EventTLS struct {
+	Version       string `json:"version"`
+	Cipher        string `json:"cipher"`
+	ServerName    string `json:"sni,omitempty"`
+	ClientSubject string `json:"client_subject,omitempty"` // verified client certificate
+}
+
+// EventRateLimit is the RateDecision of a RequestEvent.
+type EventRateLimit struct {
+	Allowed   bool   `json:"allowed"`
+	Scope     string `json:"scope"`
+	Route     string `json:"route,omitempty"`
+	Limit     int    `json:"limit"`
+	Remaining int    `json:"remaining"`
+}
+
+// Error codes of RequestEvent.ErrorCode.
+const (
+	EventErrorMalformed        = "malformed_request"   // unparsable request line or header
+	EventErrorBareLF           = "bare_lf"             // line not terminated by CRLF
+	EventErrorBodyRefused      = "body_refused"        // refused by the body policy or too large
+	EventErrorBodyRead         = "body_read_failed"    // client sent less body than declared
+	EventErrorExpectation      = "expectation_failed"  // unsupported Expect header
+	EventErrorSendFailed       = "send_failed"         // writing the response failed
+	EventErrorClientDisconnect = "client_disconnected" // client went away mid-request
+)
+
+// EventSink receives one RequestEvent per request. Emit is called from
+// connection goroutines and must be safe for concurrent use.
+type EventSink interface {
+	Emit(event *RequestEvent)
+}
+
+// JSONEventSink writes each event as one line of JSON to w.
+type JSONEventSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONEventSink returns a sink writing JSON lines to w, such as
+// os.Stdout or an append-only file.
+func NewJSONEventSink(w io.Writer) *JSONEventSink {
+	return &JSONEventSink{w: w}
+}
+
+// Emit writes event to the sink's writer. Failures are logged and the
+// event dropped, so a full disk never fails requests.
+func (s *JSONEventSink) Emit(event *RequestEvent) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		utils.Error("Failed to encode request event: %v", err)
+		return
+	}
+	line = append(line, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(line); err != nil {
+		Metrics.Counter("request_events_dropped_total").Inc()
+		utils.Warn("Failed to write request event: %v", err)
+	}
+}
+
+// eventConfig is the sink and header allow-list set by SetEventSink.
+type eventConfig struct {
+	sink    EventSink
+	headers []string // lowercased
+}
+
+// SetEventSink makes the server emit a RequestEvent per request to sink,
+// independently of the human-readable log, including events for requests
+// that could not be parsed. headers lists the request headers copied into
+// events; all others are left out, so credentials never reach the sink
+// unless listed. A nil sink turns events off.
+func (s *Server) SetEventSink(sink EventSink, headers []string) {
+	if sink == nil {
+		s.events.Store(nil)
+		return
+	}
+	cfg := &eventConfig{sink: sink}
+	for _, h := range headers {
+		cfg.headers = append(cfg.headers, strings.ToLower(h))
+	}
+	s.events.Store(cfg)
+}
+
+// startEvent begins the event of a request read from conn, or returns nil
+// while events are off. All event methods accept a nil receiver.
+func (s *Server) startEvent(conn net.Conn) *RequestEvent {
+	if s.events.Load() == nil {
+		return nil
+	}
+	ev := &RequestEvent{Start: s.clock.Now()}
+	if host, port, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil {
+		ev.ClientIP = host
+		ev.ClientPort, _ = strconv.Atoi(port)
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		ev.setTLS(newTLSInfo(tlsConn.ConnectionState()))
+	}
+	return ev
+}
+
+// setTLS records the TLS session described by info.
+func (ev *RequestEvent) setTLS(info *TLSInfo) {
+	if ev == nil || info == nil {
+		return
+	}
+	ev.TLS = &EventTLS{
+		Version:       tls.VersionName(info.Version),
+		Cipher:        tls.CipherSuiteName(info.CipherSuite),
+		ServerName:    info.ServerName,
+		ClientSubject: info.PeerSubject,
+	}
+}
+
+// setEventRequest records the request line, client and allow-listed
+// headers of a parsed request.
+func (s *Server) setEventRequest(ev *RequestEvent, req *Request) {
+	if ev == nil {
+		return
+	}
+	ev.Method, ev.Target, ev.Version = req.Method, req.target(), req.Version
+	ev.Host = req.Host()
+	ev.ClientIP = req.ClientIP()
+	if cfg := s.events.Load(); cfg != nil {
+		for _, name := range cfg.headers {
+			if value, ok := req.Headers[name]; ok {
+				if ev.Headers == nil {
+					ev.Headers = make(map[string]string)
+				}
+				ev.Headers[name] = value
+			}
+		}
+	}
+}
+
+// fail records err as the reason the request failed, classified as code.
+func (ev *RequestEvent) fail(code string, err error) {
+	if ev == nil || err == nil {
+		return
+	}
+	ev.ErrorCode = code
+	ev.Error = err.Error()
+}
+
+// sendResponse writes resp to conn like SendResponse, within b's write
+// timeout, recording in ev when it was sent, its size and any failure.
+func (s *Server) sendResponse(conn net.Conn, b *binding, resp Response, ev *RequestEvent) error {
+	setWriteDeadline(conn, b.writeTimeout)
+	// Lift it again so it cannot fail a later 100 Continue.
+	defer setWriteDeadline(conn, 0)
+	written, err := SendResponse(conn, resp)
+	if ev == nil {
+		return err
+	}
+	ev.Sent = s.clock.Now()
+	ev.Status = resp.Status
+	ev.BytesSent = written
+	if err != nil {
+		ev.fail(EventErrorSendFailed, err)
+	}
+	return err
+}
+
+// finishEvent records what the router and middleware left on req, then
+// emits the event. req may be nil for a request that could not be parsed.
+func (s *Server) finishEvent(ev *RequestEvent, req *Request) {
+	if ev == nil {
+		return
+	}
+	cfg := s.events.Load()
+	if cfg == nil {
+		return
+	}
+	if req != nil {
+		ev.RequestID = req.GetString(RequestIDKey)
+		ev.Principal = req.GetString(PrincipalKey)
+		if d, ok := req.Get(RateDecisionKey); ok {
+			decision := d.(RateDecision)
+			ev.RateLimit = &EventRateLimit{
+				Allowed:   decision.Allowed,
+				Scope:     decision.Scope,
+				Route:     decision.Route,
+				Limit:     decision.Limit,
+				Remaining: max(decision.Remaining, 0),
+			}
+		}
+		if req.route != nil {
+			ev.Route = strings.TrimSpace(req.route.method + " " + req.route.pattern)
+		}
+	}
+	end := ev.Sent
+	if end.IsZero() {
+		end = s.clock.Now()
+	}
+	ev.DurationMS = float64(end.Sub(ev.Start).Microseconds()) / 1000
+	cfg.sink.Emit(ev)
+}
+
+// headErrorCode classifies an error reading the request head.
+func headErrorCode(err error) string {
+	var httpErr *HTTPError
+	switch {
+	case errors.Is(err, errBareLF):
+		return EventErrorBareLF
+	case isPeerDisconnect(err):
+		return EventErrorClientDisconnect
+	case errors.As(err, &httpErr) && httpErr.Status == 417:
+		return EventErrorExpectation
+	}
+	return EventErrorMalformed
+}
+
+// bodyErrorCode classifies an error reading the request body.
+func bodyErrorCode(err error) string {
+	var httpErr *HTTPError
+	switch {
+	case isPeerDisconnect(err):
+		return EventErrorClientDisconnect
+	case errors.As(err, &httpErr):
+		return EventErrorBodyRefused
+	}
+	return EventErrorBodyRead
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eventLines is an io.Writer handing each line a JSONEventSink writes to
+// a channel, so tests can wait for the event of a request.
+type eventLines chan []byte
+
+func (c eventLines) Write(p []byte) (int, error) {
+	c <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+// nextEvent decodes the next event written to lines, keyed by JSON field.
+func nextEvent(t *testing.T, lines eventLines) map[string]any {
+	t.Helper()
+	select {
+	case line := <-lines:
+		var event map[string]any
+		if err := json.Unmarshal(line, &event); err != nil {
+			t.Fatalf("event %q is not JSON: %v", line, err)
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event emitted")
+		return nil
+	}
+}
+
+// checkFields fails t unless event has exactly the fields want.
+func checkFields(t *testing.T, event map[string]any, want ...string) {
+	t.Helper()
+	got := slices.Sorted(maps.Keys(event))
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("event fields = %v\nwant %v", got, want)
+	}
+}
+
+// checkTimestamps fails t unless the named fields of event are RFC 3339
+// times in order.
+func checkTimestamps(t *testing.T, event map[string]any, names ...string) {
+	t.Helper()
+	var last time.Time
+	for _, name := range names {
+		s, _ := event[name].(string)
+		ts, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			t.Errorf("%s = %v, want an RFC 3339 time", name, event[name])
+			continue
+		}
+		if ts.Before(last) {
+			t.Errorf("%s = %v is before the previous phase", name, ts)
+		}
+		last = ts
+	}
+}
+
+func startEventServer(t *testing.T) (string, eventLines) {
+	t.Helper()
+	lines := make(eventLines, 4)
+	srv, addr := startServerWithRoutes(t, func(r *Router) {
+		r.Handle("/items/:id", "GET", func(req *Request) Response {
+			return textResponse("item " + req.Params["id"])
+		})
+	})
+	srv.SetEventSink(NewJSONEventSink(lines), []string{"User-Agent", "X-Trace"})
+	return addr, lines
+}
+
+func TestRequestEventSchema(t *testing.T) {
+	addr, lines := startEventServer(t)
+
+	t.Run("success", func(t *testing.T) {
+		headers := map[string]string{"User-Agent": "probe/1", "Authorization": "Bearer secret"}
+		resp := roundTrip(t, dial(t, addr), "GET", "/items/7?full=1", headers, nil)
+		event := nextEvent(t, lines)
+		checkFields(t, event,
+			"start", "body_read", "handled", "sent", "duration_ms",
+			"client_ip", "client_port", "method", "target", "version", "host",
+			"headers", "route", "status", "bytes_sent")
+		checkTimestamps(t, event, "start", "body_read", "handled", "sent")
+		for name, want := range map[string]any{
+			"client_ip": "127.0.0.1",
+			"method":    "GET",
+			"target":    "/items/7?full=1",
+			"version":   "HTTP/1.1",
+			"route":     "GET /items/:id",
+			"status":    float64(200),
+		} {
+			if event[name] != want {
+				t.Errorf("%s = %v, want %v", name, event[name], want)
+			}
+		}
+		// Only allow-listed headers are copied, so the token stays out.
+		if h, _ := event["headers"].(map[string]any); len(h) != 1 || h["user-agent"] != "probe/1" {
+			t.Errorf("headers = %v, want only user-agent", event["headers"])
+		}
+		if port, _ := event["client_port"].(float64); port <= 0 {
+			t.Errorf("client_port = %v, want the peer's port", event["client_port"])
+		}
+		if n, _ := event["bytes_sent"].(float64); n <= float64(len(resp.Body)) {
+			t.Errorf("bytes_sent = %v, want the body of %d bytes and the head", n, len(resp.Body))
+		}
+		if d, _ := event["duration_ms"].(float64); d < 0 {
+			t.Errorf("duration_ms = %v, want at least 0", d)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		roundTrip(t, dial(t, addr), "GET", "/nowhere", nil, nil)
+		event := nextEvent(t, lines)
+		// No route matched, so the request was answered before its body
+		// was read, and it sent no allow-listed header.
+		checkFields(t, event,
+			"start", "handled", "sent", "duration_ms",
+			"client_ip", "client_port", "method", "target", "version", "host",
+			"status", "bytes_sent")
+		if event["status"] != float64(404) || event["target"] != "/nowhere" {
+			t.Errorf("status, target = %v, %v; want 404, /nowhere", event["status"], event["target"])
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		c := dial(t, addr)
+		if err := c.SendRaw([]byte("GET /items/7\r\n\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		if resp, err := c.ReadResponse(); err != nil || resp.Status != 400 {
+			t.Fatalf("malformed request: %v, %v; want 400", resp, err)
+		}
+		event := nextEvent(t, lines)
+		// The request line was never accepted, so only the client, the
+		// timestamps and the failure are known.
+		checkFields(t, event,
+			"start", "sent", "duration_ms", "client_ip", "client_port",
+			"status", "bytes_sent", "error_code", "error")
+		checkTimestamps(t, event, "start", "sent")
+		if event["status"] != float64(400) || event["error_code"] != EventErrorMalformed {
+			t.Errorf("status, error_code = %v, %v; want 400, %s", event["status"], event["error_code"], EventErrorMalformed)
+		}
+	})
+}
+
+func TestEventLogFromConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	t.Setenv("EVENT_LOG", path)
+	t.Setenv("EVENT_LOG_HEADERS", "x-trace")
+	_, addr := startServer(t)
+
+	roundTrip(t, dial(t, addr), "GET", "/healthz", map[string]string{"X-Trace": "abc"}, nil)
+	var event RequestEvent
+	waitFor(t, "the event to be written", func() bool {
+		data, _ := os.ReadFile(path)
+		return strings.HasSuffix(string(data), "\n") && json.Unmarshal(data, &event) == nil
+	})
+	if event.Route != "GET /healthz" || event.Status != 200 {
+		t.Errorf("route, status = %q, %d; want GET /healthz, 200", event.Route, event.Status)
+	}
+	if event.RequestID == "" {
+		t.Error("request_id missing with RequestIDMiddleware installed")
+	}
+	if event.Headers["x-trace"] != "abc" {
+		t.Errorf("headers = %v, want x-trace from EVENT_LOG_HEADERS", event.Headers)
+	}
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FairQueueOptions configure fair admission to saturated routes, see
+// Router.SetFairQueue.
+type FairQueueOptions struct {
+	// ClientInFlight is how many handlers of a route one client may run
+	// while other clients are waiting for the route. Zero means 1.
+	ClientInFlight int
+
+	// ClientQueue is how many requests of one client may wait for a
+	// route; more get 429. Zero means none may wait.
+	ClientQueue int
+
+	// Wait bounds how long a request waits for a slot before getting 503.
+	Wait time.Duration
+
+	// TopClients is how many clients, the busiest, each route reports in
+	// /metrics. Zero reports none.
+	TopClients int
+}
+
+var (
+	// errRouteSaturated is returned by bulkhead.acquire when the route has
+	// no slot for the request.
+	errRouteSaturated = errors.New("route saturated")
+
+	// errClientQueueFull is returned by bulkhead.acquire when the client
+	// already has as many requests waiting for the route as it may.
+	errClientQueueFull = errors.New("too many queued requests from client")
+)
+
+// fairQueue shares a route's slots between clients. While slots are free
+// and nobody waits, requests take them in arrival order. Once requests
+// wait, freed slots go round-robin to the waiting clients, skipping
+// clients already running ClientInFlight handlers unless no other client
+// waits, so a client sending many requests at once gets no more than its
+// share while others want the route too.
+type fairQueue struct {
+	opts     FairQueueOptions
+	capacity int
+
+	mu      sync.Mutex
+	running int
+	clients map[string]*fairClient
+	ring    []string // clients with waiting requests, in round-robin order
+	next    int      // position in ring to serve next
+}
+
+// fairClient is one client's share of a route.
+type fairClient struct {
+	running int
+	waiting []chan struct{} // closed when the waiter is given a slot
+}
+
+func newFairQueue(capacity int, opts FairQueueOptions) *fairQueue {
+	if opts.ClientInFlight <= 0 {
+		opts.ClientInFlight = 1
+	}
+	return &fairQueue{opts: opts, capacity: capacity, clients: make(map[string]*fairClient)}
+}
+
+// acquire takes a slot for a request of client, waiting for one if
+// needed. It returns errClientQueueFull or errRouteSaturated if it got
+// none.
+func (q *fairQueue) acquire(client string) error {
+	q.mu.Lock()
+	c := q.client(client)
+	if q.running < q.capacity && (len(q.ring) == 0 || c.running < q.opts.ClientInFlight) {
+		q.grant(c)
+		q.mu.Unlock()
+		return nil
+	}
+	if q.opts.Wait <= 0 || q.opts.ClientQueue <= 0 {
+		q.forget(client, c)
+		q.mu.Unlock()
+		return errRouteSaturated
+	}
+	if len(c.waiting) >= q.opts.ClientQueue {
+		q.mu.Unlock()
+		Metrics.Counter("fair_queue_client_rejected_total").Inc()
+		return errClientQueueFull
+	}
+	ready := make(chan struct{})
+	if len(c.waiting) == 0 {
+		q.ring = append(q.ring, client)
+	}
+	c.waiting = append(c.waiting, ready)
+	Metrics.Gauge("fair_queue_waiting").Inc()
+	q.mu.Unlock()
+
+	timer := time.NewTimer(q.opts.Wait)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case <-ready:
+		// Given a slot just as the wait ran out.
+		return nil
+	default:
+	}
+	for i, w := range c.waiting {
+		if w == ready {
+			c.waiting = append(c.waiting[:i], c.waiting[i+1:]...)
+			break
+		}
+	}
+	Metrics.Gauge("fair_queue_waiting").Dec()
+	Metrics.Counter("fair_queue_timeouts_total").Inc()
+	if len(c.waiting) == 0 {
+		q.leaveRing(client)
+	}
+	q.forget(client, c)
+	return errRouteSaturated
+}
+
+// release returns a slot taken by client and hands freed slots to waiting
+// clients.
+func (q *fairQueue) release(client string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	c := q.clients[client]
+	c.running--
+	q.running--
+	q.forget(client, c)
+	for q.running < q.capacity && len(q.ring) > 0 {
+		q.grant(q.nextWaiter())
+	}
+}
+
+// nextWaiter picks the client to get the next free slot, round-robin over
+// the waiting clients under their in-flight cap, or over all waiting
+// clients if every one is at its cap, and dequeues its oldest waiter.
+// Callers hold q.mu, and grant the returned client a slot.
+func (q *fairQueue) nextWaiter() *fairClient {
+	pick := -1
+	for i := range q.ring {
+		at := (q.next + i) % len(q.ring)
+		if q.clients[q.ring[at]].running < q.opts.ClientInFlight {
+			pick = at
+			break
+		}
+	}
+	if pick < 0 {
+		pick = q.next % len(q.ring)
+	}
+	name := q.ring[pick]
+	c := q.clients[name]
+	close(c.waiting[0])
+	c.waiting = c.waiting[1:]
+	Metrics.Gauge("fair_queue_waiting").Dec()
+	if len(c.waiting) == 0 {
+		q.leaveRing(name)
+		q.next = pick
+	} else {
+		q.next = pick + 1
+	}
+	if len(q.ring) > 0 {
+		q.next %= len(q.ring)
+	} else {
+		q.next = 0
+	}
+	return c
+}
+
+// grant gives c a slot. Callers hold q.mu.
+func (q *fairQueue) grant(c *fairClient) {
+	c.running++
+	q.running++
+}
+
+// client returns the state of client, creating it. Callers hold q.mu.
+func (q *fairQueue) client(client string) *fairClient {
+	c, ok := q.clients[client]
+	if !ok {
+		c = &fairClient{}
+		q.clients[client] = c
+	}
+	return c
+}
+
+// forget drops the state of client once it has nothing running or
+// waiting. Callers hold q.mu.
+func (q *fairQueue) forget(client string, c *fairClient) {
+	if c.running == 0 && len(c.waiting) == 0 {
+		delete(q.clients, client)
+	}
+}
+
+// leaveRing removes client from the round-robin ring. Callers hold q.mu.
+func (q *fairQueue) leaveRing(client string) {
+	for i, name := range q.ring {
+		if name == client {
+			q.ring = append(q.ring[:i], q.ring[i+1:]...)
+			if i < q.next {
+				q.next--
+			}
+			return
+		}
+	}
+}
+
+// topClients returns, for /metrics, the running and waiting requests of
+// the opts.TopClients clients with the most of them, labelled with route.
+func (q *fairQueue) topClients(route string) map[string]int64 {
+	type share struct {
+		client           string
+		running, waiting int
+	}
+	q.mu.Lock()
+	shares := make([]share, 0, len(q.clients))
+	for name, c := range q.clients {
+		shares = append(shares, share{name, c.running, len(c.waiting)})
+	}
+	q.mu.Unlock()
+
+	sort.Slice(shares, func(i, j int) bool {
+		a, b := shares[i].running+shares[i].waiting, shares[j].running+shares[j].waiting
+		if a != b {
+			return a > b
+		}
+		return shares[i].client < shares[j].client
+	})
+	values := make(map[string]int64)
+	for _, s := range shares[:min(len(shares), q.opts.TopClients)] {
+		labels := MetricLabel("route", route) + "," + MetricLabel("client", s.client)
+		values[fmt.Sprintf("fair_queue_client_in_flight{%s}", labels)] = int64(s.running)
+		values[fmt.Sprintf("fair_queue_client_waiting{%s}", labels)] = int64(s.waiting)
+	}
+	return values
+}
+
+// SetFairQueue makes every route with a concurrency limit (see
+// Route.MaxConcurrent and SetDefaultMaxConcurrent) share its slots fairly
+// between client IPs, as described by opts, instead of following its
+// OnSaturated policy. A client with too many requests queued for a route
+// gets 429, while 503 still means the route itself is overloaded. Set it
+// before the routes serve requests.
+//
+// Example:
+//
+//	router.SetDefaultMaxConcurrent(64)
+//	router.SetFairQueue(&server.FairQueueOptions{ClientInFlight: 4, ClientQueue: 16, Wait: 5 * time.Second})
+func (r *Router) SetFairQueue(opts *FairQueueOptions) {
+	r.fairQueue = opts
+}
+
+// ClientQueueFullResponse answers a request whose client has too many
+// requests queued for a saturated route.
+func ClientQueueFullResponse(retryAfter time.Duration) Response {
+	problem := NewHTTPError(429, "too many requests from this client are waiting for this route")
+	resp := problem.Response()
+	resp.Headers["Retry-After"] = fmt.Sprint(ceilSeconds(retryAfter))
+	return resp
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waiting returns how many requests of client wait in q.
+func (q *fairQueue) waiting(client string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if c, ok := q.clients[client]; ok {
+		return len(c.waiting)
+	}
+	return 0
+}
+
+// enqueue makes client wait for a slot of q in the background, returning
+// once it is queued. The client's name is sent on granted when it gets
+// the slot.
+func enqueue(t *testing.T, q *fairQueue, client string, granted chan<- string) {
+	t.Helper()
+	before := q.waiting(client)
+	go func() {
+		if err := q.acquire(client); err != nil {
+			t.Errorf("acquire(%q) = %v after waiting, want a slot", client, err)
+			return
+		}
+		granted <- client
+	}()
+	waitFor(t, client+" to queue", func() bool { return q.waiting(client) > before })
+}
+
+// mustAcquire takes a slot of q for client, failing t if none is free.
+func mustAcquire(t *testing.T, q *fairQueue, client string) {
+	t.Helper()
+	if err := q.acquire(client); err != nil {
+		t.Fatalf("acquire(%q) = %v, want a free slot", client, err)
+	}
+}
+
+func TestFairQueueFreeSlots(t *testing.T) {
+	q := newFairQueue(2, FairQueueOptions{ClientInFlight: 1})
+
+	// With nobody waiting, slots go in arrival order, even several to one
+	// client.
+	mustAcquire(t, q, "a")
+	mustAcquire(t, q, "a")
+	if err := q.acquire("b"); !errors.Is(err, errRouteSaturated) {
+		t.Errorf("acquire with every slot taken and no queue = %v, want errRouteSaturated", err)
+	}
+	q.release("a")
+	mustAcquire(t, q, "b")
+	q.release("a")
+	q.release("b")
+	if len(q.clients) != 0 || q.running != 0 {
+		t.Errorf("after every release: %d clients, %d running, want none", len(q.clients), q.running)
+	}
+}
+
+func TestFairQueueRoundRobin(t *testing.T) {
+	q := newFairQueue(1, FairQueueOptions{ClientQueue: 10, Wait: 5 * time.Second})
+	granted := make(chan string, 8)
+	mustAcquire(t, q, "holder")
+
+	// One client floods the route before two others ask once each.
+	for _, client := range []string{"a", "a", "a", "b", "c"} {
+		enqueue(t, q, client, granted)
+	}
+
+	var order []string
+	holder := "holder"
+	for range 5 {
+		q.release(holder)
+		holder = <-granted
+		order = append(order, holder)
+	}
+	if got, want := strings.Join(order, " "), "a b c a a"; got != want {
+		t.Errorf("slots granted to %q, want %q", got, want)
+	}
+	q.release(holder)
+	if len(q.clients) != 0 || len(q.ring) != 0 {
+		t.Errorf("after every release: clients %v, ring %v, want none", q.clients, q.ring)
+	}
+}
+
+func TestFairQueueClientInFlight(t *testing.T) {
+	q := newFairQueue(2, FairQueueOptions{ClientInFlight: 1, ClientQueue: 10, Wait: 5 * time.Second})
+	granted := make(chan string, 4)
+	mustAcquire(t, q, "a")
+	mustAcquire(t, q, "a")
+
+	// a queued first, but already runs its share while b waits.
+	enqueue(t, q, "a", granted)
+	enqueue(t, q, "b", granted)
+	q.release("a")
+	if got := <-granted; got != "b" {
+		t.Errorf("freed slot went to %s, want b, under its in-flight cap", got)
+	}
+
+	// With no other client waiting, a gets slots past its cap.
+	q.release("b")
+	if got := <-granted; got != "a" {
+		t.Errorf("freed slot went to %s, want a, the only one waiting", got)
+	}
+
+	q.release("a")
+	q.release("a")
+}
+
+func TestFairQueueClientQueueFull(t *testing.T) {
+	q := newFairQueue(1, FairQueueOptions{ClientQueue: 2, Wait: 5 * time.Second})
+	granted := make(chan string, 4)
+	rejected := Metrics.Counter("fair_queue_client_rejected_total")
+	before := rejected.Value()
+	mustAcquire(t, q, "holder")
+
+	enqueue(t, q, "a", granted)
+	enqueue(t, q, "a", granted)
+	if err := q.acquire("a"); !errors.Is(err, errClientQueueFull) {
+		t.Errorf("third queued request of a = %v, want errClientQueueFull", err)
+	}
+	if got := rejected.Value() - before; got != 1 {
+		t.Errorf("fair_queue_client_rejected_total grew by %v, want 1", got)
+	}
+	// The limit is per client.
+	enqueue(t, q, "b", granted)
+
+	holder := "holder"
+	for range 3 {
+		q.release(holder)
+		holder = <-granted
+	}
+	q.release(holder)
+}
+
+func TestFairQueueWaitTimeout(t *testing.T) {
+	q := newFairQueue(1, FairQueueOptions{ClientQueue: 4, Wait: 50 * time.Millisecond})
+	timeouts := Metrics.Counter("fair_queue_timeouts_total")
+	before := timeouts.Value()
+	mustAcquire(t, q, "holder")
+
+	start := time.Now()
+	if err := q.acquire("a"); !errors.Is(err, errRouteSaturated) {
+		t.Fatalf("acquire past Wait = %v, want errRouteSaturated", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("gave up after %v, before Wait", elapsed)
+	}
+	if got := timeouts.Value() - before; got != 1 {
+		t.Errorf("fair_queue_timeouts_total grew by %v, want 1", got)
+	}
+	if _, ok := q.clients["a"]; ok || len(q.ring) != 0 {
+		t.Errorf("timed out client left state behind: clients %v, ring %v", q.clients, q.ring)
+	}
+
+	// Without a queue, a saturated route refuses at once.
+	q = newFairQueue(1, FairQueueOptions{Wait: time.Second})
+	mustAcquire(t, q, "holder")
+	start = time.Now()
+	if err := q.acquire("a"); !errors.Is(err, errRouteSaturated) || time.Since(start) > 100*time.Millisecond {
+		t.Errorf("acquire with ClientQueue 0 = %v after %v, want errRouteSaturated at once", err, time.Since(start))
+	}
+}
+
+func TestFairQueueTopClients(t *testing.T) {
+	q := newFairQueue(3, FairQueueOptions{ClientQueue: 4, Wait: 5 * time.Second, TopClients: 2})
+	granted := make(chan string, 4)
+	mustAcquire(t, q, "10.0.0.1")
+	mustAcquire(t, q, "10.0.0.2")
+	mustAcquire(t, q, "10.0.0.2")
+	enqueue(t, q, "10.0.0.3", granted)
+	enqueue(t, q, "10.0.0.2", granted)
+
+	label := `route="GET /slow"`
+	want := map[string]int64{
+		`fair_queue_client_in_flight{` + label + `,client="10.0.0.2"}`: 2,
+		`fair_queue_client_waiting{` + label + `,client="10.0.0.2"}`:   1,
+		`fair_queue_client_in_flight{` + label + `,client="10.0.0.1"}`: 1,
+		`fair_queue_client_waiting{` + label + `,client="10.0.0.1"}`:   0,
+	}
+	if got := q.topClients("GET /slow"); !maps.Equal(got, want) {
+		t.Errorf("topClients = %v, want %v", got, want)
+	}
+
+	holder := "10.0.0.1"
+	for range 2 {
+		q.release(holder)
+		holder = <-granted
+	}
+}
+
+func TestFairQueueRouter(t *testing.T) {
+	slow, entered, release := blockingHandler("slow")
+	r := NewRouter()
+	r.SetDefaultMaxConcurrent(1)
+	r.SetFairQueue(&FairQueueOptions{ClientQueue: 1, Wait: 100 * time.Millisecond})
+	r.Handle("/slow", "GET", slow)
+	from := func(ip string) *Request {
+		req := &Request{Method: "GET", Headers: map[string]string{}, RemoteAddr: ip + ":4000"}
+		req.setTarget("/slow")
+		return req
+	}
+
+	held := make(chan Response, 1)
+	go func() { held <- r.Route(from("192.0.2.1")) }()
+	<-entered
+
+	// A second request of the same client waits its turn; a third is one
+	// too many for its queue.
+	queued := make(chan Response, 1)
+	go func() { queued <- r.Route(from("192.0.2.1")) }()
+	waitFor(t, "the request to queue", func() bool {
+		return Metrics.Gauge("fair_queue_waiting").Value() > 0
+	})
+	resp := r.Route(from("192.0.2.1"))
+	if resp.Status != 429 || resp.Headers["Retry-After"] == "" {
+		t.Errorf("request over the client's queue = %d, Retry-After %q; want 429 with Retry-After", resp.Status, resp.Headers["Retry-After"])
+	}
+
+	// Another client may queue, but the route stays busy past Wait.
+	if resp := r.Route(from("192.0.2.2")); resp.Status != 503 {
+		t.Errorf("request waiting past Wait = %d, want 503", resp.Status)
+	}
+	if resp := <-queued; resp.Status != 503 {
+		t.Errorf("queued request waiting past Wait = %d, want 503", resp.Status)
+	}
+
+	close(release)
+	if resp := <-held; resp.Status != 200 {
+		t.Errorf("held request = %d, want 200", resp.Status)
+	}
+	if resp := r.Route(from("192.0.2.2")); resp.Status != 200 {
+		t.Errorf("request once the route is free = %d, want 200", resp.Status)
+	}
+}
@@ -0,0 +1,398 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/clock"
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// metaFilePrefix starts the names of metadata sidecars, which are kept
+// next to their files and never served.
+const metaFilePrefix = ".filemeta-"
+
+// metaFileSuffix ends the names of metadata sidecars.
+const metaFileSuffix = ".json"
+
+// MetaExpiresKey is the metadata key holding the time, in RFC 3339, after
+// which FileMetadata.Sweep deletes the file.
+const MetaExpiresKey = "expires"
+
+// isMetaFile reports whether name is the base name of a metadata sidecar.
+func isMetaFile(name string) bool {
+	return strings.HasPrefix(name, metaFilePrefix)
+}
+
+// isReservedFile reports whether name is the base name of a file the
+// server keeps for itself next to the public files: a lock file or a
+// metadata sidecar.
+func isReservedFile(name string) bool {
+	return isLockFile(name) || isMetaFile(name)
+}
+
+// MetadataStore keeps the key-value metadata of files, identified by
+// path. Implementations must be safe for concurrent use; callers
+// serialize changes to one file's metadata with publicFiles.lockWrite.
+type MetadataStore interface {
+	// Get returns the metadata of the file at path, or nil if it has
+	// none.
+	Get(path string) (map[string]string, error)
+
+	// Put replaces the metadata of the file at path. Empty metadata
+	// deletes it.
+	Put(path string, meta map[string]string) error
+
+	// Delete removes the metadata of the file at path, if any.
+	Delete(path string) error
+}
+
+// SidecarMetadata is the MetadataStore keeping each file's metadata as a
+// JSON object in a hidden file next to it, so it needs no database and
+// survives restarts.
+type SidecarMetadata struct{}
+
+// sidecarPath returns the path of the sidecar holding the metadata of the
+// file at path.
+func sidecarPath(path string) string {
+	return filepath.Join(filepath.Dir(path), metaFilePrefix+filepath.Base(path)+metaFileSuffix)
+}
+
+func (SidecarMetadata) Get(path string) (map[string]string, error) {
+	data, err := os.ReadFile(sidecarPath(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var meta map[string]string
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("invalid metadata sidecar for %s: %w", path, err)
+	}
+	return meta, nil
+}
+
+func (s SidecarMetadata) Put(path string, meta map[string]string) error {
+	if len(meta) == 0 {
+		return s.Delete(path)
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	// Write a temporary file and rename it over the sidecar, so readers
+	// never see half of it.
+	tmp := sidecarPath(path) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, sidecarPath(path)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (SidecarMetadata) Delete(path string) error {
+	if err := os.Remove(sidecarPath(path)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// FileMetadata serves the metadata of the public files at
+// "/files-meta/{filename}", surfaces chosen keys as X-Meta-* headers on
+// downloads and deletes files past their MetaExpiresKey time.
+type FileMetadata struct {
+	store    MetadataStore
+	maxBytes int
+	headers  []string // lowercased keys sent as X-Meta-* headers
+
+	mu    sync.Mutex
+	clock clock.Clock
+	trash *Trash
+	files *publicFiles // told about swept files
+}
+
+// NewFileMetadata creates a FileMetadata over store accepting metadata of
+// up to maxBytes once encoded as JSON, zero meaning unlimited, and
+// sending the keys in headers as X-Meta-* response headers.
+func NewFileMetadata(store MetadataStore, maxBytes int, headers []string) *FileMetadata {
+	m := &FileMetadata{store: store, maxBytes: maxBytes, clock: clock.Real, files: newPublicFiles()}
+	for _, key := range headers {
+		if key = strings.ToLower(strings.TrimSpace(key)); key != "" {
+			m.headers = append(m.headers, key)
+		}
+	}
+	return m
+}
+
+// SetClock replaces the time source expiry is judged by.
+func (m *FileMetadata) SetClock(c clock.Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clock = c
+}
+
+// SetTrash makes Sweep move expired files into trash instead of removing
+// them.
+func (m *FileMetadata) SetTrash(trash *Trash) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.trash = trash
+}
+
+// Handle handles "/files-meta/{filename}" for the file at
+// "/files/{filename}", which must exist:
+//   - GET: Returns the metadata as a JSON object of strings, {} if none.
+//   - PUT: Replaces the metadata with the JSON object in the body.
+//   - PATCH: Merges the JSON object in the body into the metadata; keys
+//     set to null are removed.
+//   - DELETE: Removes all metadata.
+//
+// Keys are header tokens, matched case-sensitively, and values strings.
+// The value of MetaExpiresKey must be an RFC 3339 time. Metadata larger
+// than the configured cap is refused with 413, and changes with 403 while
+// readOnly is on.
+func (m *FileMetadata) Handle(readOnly *ReadOnlySwitch) HandlerFunc {
+	const allow = "GET, HEAD, PUT, PATCH, DELETE, OPTIONS"
+	return func(req *Request) Response {
+		_, raw, _ := strings.Cut(req.Path, "/files-meta/")
+		filePath, errResp := publicFileAt(req, raw)
+		if errResp != nil {
+			return *errResp
+		}
+
+		switch req.Method {
+		case "GET", "HEAD":
+			if info, err := os.Stat(filePath); err != nil || info.IsDir() {
+				return NotFoundResponse()
+			}
+			meta, err := m.store.Get(filePath)
+			if err != nil {
+				utils.Error("Failed to read metadata of %s: %v", filePath, err)
+				return InternalServerErrorResponse()
+			}
+			if meta == nil {
+				meta = map[string]string{}
+			}
+			return jsonNoStore(meta)
+
+		case "PUT", "PATCH", "DELETE":
+			if readOnly != nil && readOnly.Enabled() {
+				Metrics.Counter("files_read_only_rejections_total").Inc()
+				return NewHTTPError(403, "the files API is in read-only mode; metadata cannot be changed").Response()
+			}
+			unlock, errResp := m.files.lockWrite(filePath)
+			if errResp != nil {
+				return *errResp
+			}
+			defer unlock()
+			if info, err := os.Stat(filePath); err != nil || info.IsDir() {
+				return NotFoundResponse()
+			}
+			meta, err := m.change(req, filePath)
+			if err != nil {
+				var httpErr *HTTPError
+				if errors.As(err, &httpErr) {
+					return httpErr.Response()
+				}
+				utils.Error("Failed to write metadata of %s: %v", filePath, err)
+				return InternalServerErrorResponse()
+			}
+			Metrics.Counter("files_meta_writes_total").Inc()
+			return jsonNoStore(meta)
+
+		case "OPTIONS":
+			return OptionsResponse(allow)
+
+		default:
+			return MethodNotAllowedResponse(allow)
+		}
+	}
+}
+
+// change applies the PUT, PATCH or DELETE req to the metadata of the file
+// at filePath and returns the metadata now stored. Invalid requests fail
+// with an *HTTPError.
+func (m *FileMetadata) change(req *Request, filePath string) (map[string]string, error) {
+	meta := map[string]string{}
+	switch req.Method {
+	case "PUT":
+		if err := json.Unmarshal(req.Body, &meta); err != nil || meta == nil {
+			return nil, NewHTTPError(400, "the body must be a JSON object of string values")
+		}
+	case "PATCH":
+		var patch map[string]*string
+		if err := json.Unmarshal(req.Body, &patch); err != nil || patch == nil {
+			return nil, NewHTTPError(400, "the body must be a JSON object of string or null values")
+		}
+		current, err := m.store.Get(filePath)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range current {
+			meta[key] = value
+		}
+		for key, value := range patch {
+			if value == nil {
+				delete(meta, key)
+			} else {
+				meta[key] = *value
+			}
+		}
+	}
+
+	for key, value := range meta {
+		if !isToken(key) {
+			return nil, NewHTTPError(400, fmt.Sprintf("invalid metadata key %q", key))
+		}
+		if key == MetaExpiresKey {
+			if _, err := time.Parse(time.RFC3339, value); err != nil {
+				return nil, NewHTTPError(400, fmt.Sprintf("%s must be an RFC 3339 time such as 2030-01-02T15:04:05Z", MetaExpiresKey))
+			}
+		}
+	}
+	if m.maxBytes > 0 {
+		if data, _ := json.Marshal(meta); len(data) > m.maxBytes {
+			return nil, NewHTTPError(413, fmt.Sprintf("metadata exceeds %d bytes", m.maxBytes))
+		}
+	}
+	if err := m.store.Put(filePath, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// Headers wraps a "/files/" handler so that successful GET and HEAD
+// responses carry the configured metadata keys of the file as
+// "X-Meta-{Key}" headers. Values that cannot be sent in a header are
+// left out.
+func (m *FileMetadata) Headers(next HandlerFunc) HandlerFunc {
+	if len(m.headers) == 0 {
+		return next
+	}
+	return func(req *Request) Response {
+		resp := next(req)
+		if (req.Method != "GET" && req.Method != "HEAD") || resp.Status < 200 || resp.Status > 299 {
+			return resp
+		}
+		filePath, errResp := publicFilePath(req)
+		if errResp != nil {
+			return resp
+		}
+		meta, err := m.store.Get(filePath)
+		if err != nil {
+			utils.Warn("Failed to read metadata of %s: %v", filePath, err)
+			return resp
+		}
+		for key, value := range meta {
+			if !slices.Contains(m.headers, strings.ToLower(key)) || validateHeaderValue(value) != nil {
+				continue
+			}
+			resp.Headers[canonicalHeaderKey("x-meta-"+key)] = value
+		}
+		return resp
+	}
+}
+
+// Sweep deletes the files under root whose MetaExpiresKey time has
+// passed, with their metadata, and returns how many it deleted. With a
+// trash set, expired files are moved into it instead, without the expiry,
+// so one restored is kept until given a new one.
+func (m *FileMetadata) Sweep(root string) int {
+	m.mu.Lock()
+	now, trash := m.clock.Now(), m.trash
+	m.mu.Unlock()
+
+	swept := 0
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isMetaFile(d.Name()) || !strings.HasSuffix(d.Name(), metaFileSuffix) {
+			return nil
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(d.Name(), metaFilePrefix), metaFileSuffix)
+		if m.sweepFile(filepath.Join(filepath.Dir(path), name), now, trash) {
+			swept++
+		}
+		return nil
+	})
+	if swept > 0 {
+		Metrics.Counter("files_meta_expired_total").Add(int64(swept))
+		utils.Info("Removed %d expired files", swept)
+	}
+	return swept
+}
+
+// sweepFile deletes the file at filePath if its metadata says it expired
+// by now, and reports whether it did.
+func (m *FileMetadata) sweepFile(filePath string, now time.Time, trash *Trash) bool {
+	unlock, errResp := m.files.lockWrite(filePath)
+	if errResp != nil {
+		return false
+	}
+	defer unlock()
+
+	meta, err := m.store.Get(filePath)
+	if err != nil {
+		utils.Warn("Failed to read metadata of %s: %v", filePath, err)
+		return false
+	}
+	expires, err := time.Parse(time.RFC3339, meta[MetaExpiresKey])
+	if err != nil || expires.After(now) {
+		return false
+	}
+	if _, err := os.Stat(filePath); errors.Is(err, fs.ErrNotExist) {
+		// The file went without its metadata, e.g. removed by hand.
+		m.store.Delete(filePath)
+		return false
+	}
+
+	if trash != nil {
+		delete(meta, MetaExpiresKey)
+		if err := m.store.Put(filePath, meta); err != nil {
+			utils.Error("Failed to clear the expiry of %s: %v", filePath, err)
+			return false
+		}
+		entry, err := trash.Move(filePath)
+		if err != nil {
+			utils.Error("Failed to move expired file %s to trash: %v", filePath, err)
+			return false
+		}
+		utils.Info("Moved expired file %s to trash as %s", filePath, entry.ID)
+	} else {
+		if err := os.Remove(filePath); err != nil {
+			utils.Error("Failed to delete expired file %s: %v", filePath, err)
+			return false
+		}
+		m.store.Delete(filePath)
+		utils.Info("Deleted expired file %s", filePath)
+	}
+	m.files.forget(filePath)
+	return true
+}
+
+// Run sweeps root for expired files every interval until ctx is done.
+func (m *FileMetadata) Run(ctx context.Context, root string, interval time.Duration) {
+	for {
+		m.mu.Lock()
+		c := m.clock
+		m.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.After(interval):
+			m.Sweep(root)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/clock"
+)
+
+// memoryMetadata is a MetadataStore in a map, recording deletions.
+type memoryMetadata struct {
+	meta    map[string]map[string]string
+	deleted []string
+}
+
+func (m *memoryMetadata) Get(path string) (map[string]string, error) {
+	return m.meta[path], nil
+}
+
+func (m *memoryMetadata) Put(path string, meta map[string]string) error {
+	m.meta[path] = meta
+	return nil
+}
+
+func (m *memoryMetadata) Delete(path string) error {
+	delete(m.meta, path)
+	m.deleted = append(m.deleted, path)
+	return nil
+}
+
+func TestSidecarMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.pdf")
+	var store SidecarMetadata
+
+	if meta, err := store.Get(path); meta != nil || err != nil {
+		t.Errorf("Get without metadata = %v, %v; want nil, nil", meta, err)
+	}
+	want := map[string]string{"owner": "ana", MetaExpiresKey: "2030-01-02T15:04:05Z"}
+	if err := store.Put(path, want); err != nil {
+		t.Fatal(err)
+	}
+	if meta, err := store.Get(path); err != nil || !maps.Equal(meta, want) {
+		t.Errorf("Get after Put = %v, %v; want %v", meta, err, want)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 || entries[0].Name() != ".filemeta-report.pdf.json" || !isReservedFile(entries[0].Name()) {
+		t.Errorf("directory holds %v, want only the hidden sidecar", entries)
+	}
+
+	// Empty metadata deletes the sidecar, and deleting twice is fine.
+	if err := store.Put(path, map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(sidecarPath(path)); !os.IsNotExist(err) {
+		t.Errorf("sidecar after empty Put: %v, want it removed", err)
+	}
+	if err := store.Delete(path); err != nil {
+		t.Errorf("Delete without metadata = %v", err)
+	}
+
+	if err := os.WriteFile(sidecarPath(path), []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(path); err == nil {
+		t.Error("Get of a corrupt sidecar succeeded")
+	}
+}
+
+func TestFileMetadataAPI(t *testing.T) {
+	public := chdirPublic(t)
+	if err := os.WriteFile(filepath.Join(public, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FILES_META_ENABLED", "true")
+	t.Setenv("FILES_META_MAX_BYTES", "64")
+	t.Setenv("FILES_META_HEADERS", "owner")
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+
+	meta := func(method string, body string) (int, map[string]string) {
+		t.Helper()
+		resp := roundTrip(t, c, method, "/files-meta/a.txt", keepAlive, []byte(body))
+		var got map[string]string
+		if resp.Status == 200 {
+			if err := json.Unmarshal(resp.Body, &got); err != nil {
+				t.Fatalf("%s body %q: %v", method, resp.Body, err)
+			}
+		}
+		return resp.Status, got
+	}
+	for _, tt := range []struct {
+		method, body string
+		status       int
+		want         map[string]string
+	}{
+		{"GET", "", 200, map[string]string{}},
+		{"PUT", `{"owner":"ana","team":"x"}`, 200, map[string]string{"owner": "ana", "team": "x"}},
+		{"PATCH", `{"team":null,"stage":"draft"}`, 200, map[string]string{"owner": "ana", "stage": "draft"}},
+		{"GET", "", 200, map[string]string{"owner": "ana", "stage": "draft"}},
+		{"PUT", `{"bad key":"x"}`, 400, nil},
+		{"PUT", `{"expires":"tomorrow"}`, 400, nil},
+		{"PUT", `["not","an","object"]`, 400, nil},
+		{"PATCH", `{"note":"this value makes the metadata longer than sixty-four bytes"}`, 413, nil},
+		{"GET", "", 200, map[string]string{"owner": "ana", "stage": "draft"}},
+	} {
+		status, got := meta(tt.method, tt.body)
+		if status != tt.status || (tt.want != nil && !maps.Equal(got, tt.want)) {
+			t.Errorf("%s %s = %d %v, want %d %v", tt.method, tt.body, status, got, tt.status, tt.want)
+		}
+	}
+
+	resp := roundTrip(t, c, "GET", "/files/a.txt", keepAlive, nil)
+	if resp.Header("X-Meta-Owner") != "ana" || resp.Header("X-Meta-Stage") != "" {
+		t.Errorf("download headers %v, want only the configured X-Meta-Owner", resp.Headers)
+	}
+	if resp := roundTrip(t, c, "GET", "/files-meta/missing.txt", keepAlive, nil); resp.Status != 404 {
+		t.Errorf("metadata of a missing file = %d, want 404", resp.Status)
+	}
+
+	// Deleting the file takes its metadata along.
+	if resp := roundTrip(t, c, "DELETE", "/files/a.txt", keepAlive, nil); resp.Status != 204 {
+		t.Fatalf("DELETE = %d, want 204", resp.Status)
+	}
+	if _, err := os.Stat(sidecarPath(filepath.Join(public, "a.txt"))); !os.IsNotExist(err) {
+		t.Errorf("sidecar after DELETE: %v, want it removed", err)
+	}
+}
+
+func TestPublicFilesDeleteUsesItsMetadataStore(t *testing.T) {
+	public := chdirPublic(t)
+	path := filepath.Join(public, "a.txt")
+	if err := os.WriteFile(path, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := &memoryMetadata{meta: map[string]map[string]string{path: {"owner": "ana"}}}
+	files := newPublicFiles()
+	files.meta = store
+
+	resp := files.handle(&Request{Method: "DELETE", Path: "/files/a.txt", Headers: map[string]string{}})
+	if resp.Status != 204 {
+		t.Fatalf("DELETE = %d, want 204", resp.Status)
+	}
+	if len(store.deleted) != 1 || store.deleted[0] != path || store.meta[path] != nil {
+		t.Errorf("store deletions %v, metadata %v; want the file's metadata deleted from the handler's store", store.deleted, store.meta)
+	}
+}
+
+func TestFileMetadataSweep(t *testing.T) {
+	root := t.TempDir()
+	clk := clock.NewManual(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := SidecarMetadata{}
+	m := NewFileMetadata(store, 0, nil)
+	m.SetClock(clk)
+
+	files := map[string]string{
+		"expired.txt":     "2029-12-31T23:59:59Z",
+		"sub/expired.txt": "2030-01-01T00:00:00Z",
+		"later.txt":       "2030-01-01T00:00:01Z",
+		"forever.txt":     "",
+	}
+	for name, expires := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		meta := map[string]string{"owner": "ana"}
+		if expires != "" {
+			meta[MetaExpiresKey] = expires
+		}
+		if err := store.Put(path, meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if swept := m.Sweep(root); swept != 2 {
+		t.Errorf("Sweep = %d, want 2", swept)
+	}
+	for name, expires := range files {
+		path := filepath.Join(root, name)
+		_, err := os.Stat(path)
+		_, sidecarErr := os.Stat(sidecarPath(path))
+		gone := expires != "" && expires <= "2030-01-01T00:00:00Z"
+		if gone != os.IsNotExist(err) || gone != os.IsNotExist(sidecarErr) {
+			t.Errorf("%s: file %v, sidecar %v; want removed=%t", name, err, sidecarErr, gone)
+		}
+	}
+
+	clk.Advance(time.Second)
+	if swept := m.Sweep(root); swept != 1 {
+		t.Errorf("Sweep a second later = %d, want 1", swept)
+	}
+}
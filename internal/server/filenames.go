@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// File names travel between URLs and the disk under one policy:
+//   - on disk, and everywhere in between, a name is its decoded UTF-8
+//     text with accents composed (see utils.ComposeLatin);
+//   - a name read from a request path is percent-decoded exactly once,
+//     by decodeFilePath, which refuses names that are not UTF-8;
+//   - a name written into a URL, such as a listing href or a Location
+//     header, is percent-encoded exactly once, segment by segment, by
+//     encodeFilePath;
+//   - Content-Disposition carries it as an RFC 5987 filename* parameter,
+//     see attachmentDisposition.
+
+// decodeFilePath decodes raw, the percent-encoded, slash-separated file
+// path that follows a mount prefix in a request path, into the name it
+// has on disk.
+func decodeFilePath(raw string) (string, error) {
+	name, err := url.PathUnescape(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid path: %w", err)
+	}
+	if !utf8.ValidString(name) {
+		return "", fmt.Errorf("file name %q is not valid UTF-8", raw)
+	}
+	return utils.ComposeLatin(name), nil
+}
+
+// encodeFilePath percent-encodes each segment of the slash-separated file
+// path name for use in a URL path.
+func encodeFilePath(name string) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// attachmentDisposition builds a Content-Disposition header forcing a
+// download of a file called name: an ASCII filename for old clients and
+// the exact name as filename* (RFC 6266, RFC 5987).
+func attachmentDisposition(name string) string {
+	fallback, err := utils.SanitizeFilenameWith(name, utils.FilenameOptions{ASCII: true})
+	if err != nil {
+		fallback = "download"
+	}
+	quoted := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(fallback)
+	return fmt.Sprintf("attachment; filename=\"%s\"; filename*=UTF-8''%s", quoted, encodeRFC5987(name))
+}
+
+// encodeRFC5987 percent-encodes every byte of s that is not an attr-char
+// of RFC 5987.
+func encodeRFC5987(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
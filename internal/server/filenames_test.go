@@ -0,0 +1,145 @@
+package server
+
+import (
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDecodeFilePath(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"plain.txt", "plain.txt"},
+		{"r%C3%A9sum%C3%A9%20(final).pdf", "r\u00e9sum\u00e9 (final).pdf"},
+		{"r\u00e9sum\u00e9.pdf", "r\u00e9sum\u00e9.pdf"},
+		// Accents sent decomposed are stored composed.
+		{"re%CC%81sume%CC%81.pdf", "r\u00e9sum\u00e9.pdf"},
+		{"docs/a%2Fb.txt", "docs/a/b.txt"},
+		{"100%25.txt", "100%.txt"},
+		// Decoded exactly once.
+		{"%2541.txt", "%41.txt"},
+		{"a+b.txt", "a+b.txt"},
+		{"%E6%97%A5%E6%9C%AC.txt", "\u65e5\u672c.txt"},
+	}
+	for _, tt := range tests {
+		got, err := decodeFilePath(tt.raw)
+		if err != nil || got != tt.want {
+			t.Errorf("decodeFilePath(%q) = %q, %v, want %q", tt.raw, got, err, tt.want)
+		}
+	}
+
+	for _, raw := range []string{"%zz.txt", "trailing%", "%ff%fe.txt", "r%E9sum%E9.pdf"} {
+		if got, err := decodeFilePath(raw); err == nil {
+			t.Errorf("decodeFilePath(%q) = %q, want an error", raw, got)
+		}
+	}
+}
+
+func TestFilePathRoundTrip(t *testing.T) {
+	for _, name := range []string{
+		"plain.txt",
+		"r\u00e9sum\u00e9 (final).pdf",
+		"docs/r\u00e9sum\u00e9.pdf",
+		"100% done.txt",
+		"what?#fragment.txt",
+		"a+b&c=d;e.txt",
+		"\u65e5\u672c/\u8a9e.txt",
+		"emoji \U0001F600.txt",
+	} {
+		encoded := encodeFilePath(name)
+		for _, c := range "?# " {
+			if strings.ContainsRune(encoded, c) {
+				t.Errorf("encodeFilePath(%q) = %q, leaving %q unescaped", name, encoded, c)
+			}
+		}
+		if got, err := decodeFilePath(encoded); err != nil || got != name {
+			t.Errorf("decodeFilePath(encodeFilePath(%q)) = %q, %v", name, got, err)
+		}
+		// Encoding the encoded form again must not decode back to the
+		// name: each side applies exactly one layer.
+		if strings.Contains(encoded, "%") {
+			if got, _ := decodeFilePath(encodeFilePath(encoded)); got == name {
+				t.Errorf("%q decoded to %q through two layers of encoding", name, got)
+			}
+		}
+	}
+}
+
+func TestAttachmentDisposition(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"report.pdf", `attachment; filename="report.pdf"; filename*=UTF-8''report.pdf`},
+		{"r\u00e9sum\u00e9 (final).pdf", `attachment; filename="resume (final).pdf"; filename*=UTF-8''r%C3%A9sum%C3%A9%20%28final%29.pdf`},
+		{`say "hi".txt`, `attachment; filename="say hi.txt"; filename*=UTF-8''say%20%22hi%22.txt`},
+	}
+	for _, tt := range tests {
+		if got := attachmentDisposition(tt.name); got != tt.want {
+			t.Errorf("attachmentDisposition(%q) =\n\t%s\nwant\n\t%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFilesUnicodeNameRoundTrip(t *testing.T) {
+	public := chdirPublic(t)
+	if err := os.Mkdir(filepath.Join(public, "cv"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ENABLE_DIR_LISTING", "true")
+	_, addr := startServer(t)
+	const name = "r\u00e9sum\u00e9 (final).pdf"
+
+	// Uploaded with its accents decomposed, as some clients send them.
+	resp := roundTrip(t, dial(t, addr), "POST", "/files/cv/re%CC%81sume%CC%81%20(final).pdf", nil, []byte("%PDF-1.7"))
+	if resp.Status != 201 {
+		t.Fatalf("upload = %d %q, want 201", resp.Status, resp.Body)
+	}
+	if got, want := resp.Header("Location"), "/files/cv/"+encodeFilePath(name); got != want {
+		t.Errorf("Location = %q, want %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(public, "cv", name)); err != nil {
+		t.Fatalf("stored file: %v, want it under its decoded, composed name", err)
+	}
+
+	resp = roundTrip(t, dial(t, addr), "GET", "/files/cv/", nil, nil)
+	if resp.Status != 200 {
+		t.Fatalf("listing = %d, want 200", resp.Status)
+	}
+	var hrefs []string
+	for _, m := range regexp.MustCompile(`<a href="([^"]*)">`).FindAllStringSubmatch(string(resp.Body), -1) {
+		hrefs = append(hrefs, html.UnescapeString(m[1]))
+	}
+	var href string
+	for _, h := range hrefs {
+		if strings.HasSuffix(h, ".pdf") {
+			href = h
+		}
+	}
+	if href == "" {
+		t.Fatalf("listing links %q, want one to the upload", hrefs)
+	}
+	if strings.Contains(href, "%25") {
+		t.Errorf("listing href %q is encoded twice", href)
+	}
+
+	resp = roundTrip(t, dial(t, addr), "GET", href, nil, nil)
+	if resp.Status != 200 || string(resp.Body) != "%PDF-1.7" {
+		t.Fatalf("GET %s = %d %q, want the upload", href, resp.Status, resp.Body)
+	}
+	resp = roundTrip(t, dial(t, addr), "DELETE", href, nil, nil)
+	if resp.Status != 204 {
+		t.Fatalf("DELETE %s = %d, want 204", href, resp.Status)
+	}
+	if _, err := os.Stat(filepath.Join(public, "cv", name)); !os.IsNotExist(err) {
+		t.Errorf("after DELETE, stat = %v, want the file gone", err)
+	}
+
+	resp = roundTrip(t, dial(t, addr), "GET", "/files/cv/r%E9sum%E9.pdf", nil, nil)
+	if resp.Status != 400 {
+		t.Errorf("GET of a name that is not UTF-8 = %d, want 400", resp.Status)
+	}
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/clock"
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// FSProbe periodically checks that a static root is usable, so that a
+// disk that is unmounted or turns unreadable while the server runs shows
+// up as a degraded readiness and clear 503s instead of a stream of 404s,
+// 500s and per-request error logs. It is safe for concurrent use.
+type FSProbe struct {
+	root     string
+	sentinel string
+	interval time.Duration
+
+	mu       sync.Mutex
+	healthy  bool
+	lowSpace uint64 // see SetLowSpaceThreshold
+	low      bool
+	clock    clock.Clock
+}
+
+// NewFSProbe creates a probe for root, checked every interval. The check
+// stats root and reads the sentinel file in it or, without a sentinel,
+// lists the directory. A sentinel catches an unmounted disk whose empty
+// mount point is still readable. The probe starts out healthy.
+func NewFSProbe(root, sentinel string, interval time.Duration) *FSProbe {
+	return &FSProbe{root: root, sentinel: sentinel, interval: interval, healthy: true, clock: clock.Real}
+}
+
+// SetClock replaces the time source that paces Run.
+func (p *FSProbe) SetClock(c clock.Clock) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clock = c
+}
+
+// SetLowSpaceThreshold makes each check also read the free space on the
+// root's filesystem, exported in the files_disk_free_bytes metric, and
+// report LowSpace while fewer than bytes are free. Zero disables the
+// threshold.
+func (p *FSProbe) SetLowSpaceThreshold(bytes int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lowSpace = uint64(max(bytes, 0))
+}
+
+// LowSpace reports whether the last check found less free space than the
+// low space threshold.
+func (p *FSProbe) LowSpace() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.low
+}
+
+// Healthy reports whether the last check succeeded.
+func (p *FSProbe) Healthy() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.healthy
+}
+
+// Check probes the root now and records the result. Only changes of state
+// are logged: one error when the root fails and one line when it recovers.
+func (p *FSProbe) Check() error {
+	err := p.probe()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case err != nil && p.healthy:
+		utils.Error("Static root %s is unavailable, serving 503 until it recovers: %v", p.root, err)
+	case err == nil && !p.healthy:
+		utils.Info("Static root %s has recovered", p.root)
+	}
+	p.healthy = err == nil
+	if p.healthy {
+		Metrics.Gauge("static_root_healthy").Set(1)
+		p.checkSpace()
+	} else {
+		Metrics.Gauge("static_root_healthy").Set(0)
+	}
+	return err
+}
+
+// checkSpace records the free space on the root's filesystem. Only
+// crossing the low space threshold is logged. p.mu is held.
+func (p *FSProbe) checkSpace() {
+	usage, err := statDisk(p.root)
+	if err != nil {
+		return
+	}
+	Metrics.Gauge("files_disk_free_bytes").Set(int64(usage.Free))
+	Metrics.Gauge("files_disk_total_bytes").Set(int64(usage.Total))
+	low := usage.Free < p.lowSpace
+	switch {
+	case low && !p.low:
+		utils.Warn("Static root %s is low on space: %d bytes free, threshold %d", p.root, usage.Free, p.lowSpace)
+	case !low && p.low:
+		utils.Info("Static root %s has %d bytes free again", p.root, usage.Free)
+	}
+	p.low = low
+	if low {
+		Metrics.Gauge("files_disk_low").Set(1)
+	} else {
+		Metrics.Gauge("files_disk_low").Set(0)
+	}
+}
+
+func (p *FSProbe) probe() error {
+	info, err := os.Stat(p.root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", p.root)
+	}
+	if p.sentinel != "" {
+		_, err := os.ReadFile(filepath.Join(p.root, p.sentinel))
+		return err
+	}
+	dir, err := os.Open(p.root)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if _, err := dir.ReadDir(1); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// Run checks the root every interval until ctx is done.
+func (p *FSProbe) Run(ctx context.Context) {
+	for {
+		p.mu.Lock()
+		c := p.clock
+		p.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.After(p.interval):
+			p.Check()
+		}
+	}
+}
+
+// Guard wraps a files handler so it answers 503 with Retry-After while the
+// root is unhealthy, without touching the disk.
+func (p *FSProbe) Guard(next HandlerFunc) HandlerFunc {
+	return func(req *Request) Response {
+		if !p.Healthy() {
+			Metrics.Counter("static_root_unavailable_total").Inc()
+			return ServiceUnavailableResponse(max(p.interval, time.Second))
+		}
+		return next(req)
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFSProbe(t *testing.T) {
+	root := t.TempDir()
+	p := NewFSProbe(root, "", 0)
+	if err := p.Check(); err != nil || !p.Healthy() {
+		t.Fatalf("empty root: Check = %v, Healthy = %v", err, p.Healthy())
+	}
+
+	// A file where the root should be is as bad as no root at all.
+	file := filepath.Join(t.TempDir(), "file")
+	writeTestFile(t, file, "x")
+	for _, missing := range []string{filepath.Join(root, "gone"), file} {
+		p := NewFSProbe(missing, "", 0)
+		if !p.Healthy() {
+			t.Errorf("probe for %s unhealthy before its first check", missing)
+		}
+		if err := p.Check(); err == nil || p.Healthy() {
+			t.Errorf("probe for %s: Check = %v, Healthy = %v; want unhealthy", missing, err, p.Healthy())
+		}
+	}
+}
+
+func TestFSProbeSentinel(t *testing.T) {
+	// An unmounted disk leaves an empty, readable mount point behind;
+	// only the sentinel tells it apart.
+	root := t.TempDir()
+	p := NewFSProbe(root, ".healthy", 0)
+	if err := p.Check(); err == nil || p.Healthy() {
+		t.Fatalf("root without its sentinel: Check = %v, want unhealthy", err)
+	}
+	writeTestFile(t, filepath.Join(root, ".healthy"), "")
+	if err := p.Check(); err != nil || !p.Healthy() {
+		t.Errorf("root with its sentinel: Check = %v, want healthy", err)
+	}
+}
+
+func TestFSProbeLogsTransitions(t *testing.T) {
+	root := t.TempDir()
+	p := NewFSProbe(root, ".healthy", 0)
+	logged := captureLog(t, func() {
+		for i := 0; i < 3; i++ {
+			p.Check()
+		}
+	})
+	if n := strings.Count(logged, "[ERROR]"); n != 1 {
+		t.Errorf("three failed checks logged %d errors, want 1:\n%s", n, logged)
+	}
+}
+
+func TestServeStaticRootUnavailable(t *testing.T) {
+	public := chdirPublic(t)
+	writeTestFile(t, filepath.Join(public, "a.txt"), "hello")
+	// Checks are made by hand below; keep the background ones out of it.
+	t.Setenv("FILES_HEALTH_INTERVAL", "3600")
+	srv, addr := startServer(t)
+	if srv.filesProbe == nil {
+		t.Fatal("no probe for the public directory")
+	}
+	waitFor(t, "readiness", func() bool { return srv.State() == StateReady })
+
+	if status, body := probe(t, addr, "/files/a.txt"); status != 200 || body != "hello" {
+		t.Fatalf("GET while healthy = %d %q", status, body)
+	}
+
+	moved := public + ".unmounted"
+	if err := os.Rename(public, moved); err != nil {
+		t.Fatal(err)
+	}
+	srv.filesProbe.Check()
+
+	unavailable := Metrics.Counter("static_root_unavailable_total")
+	before := unavailable.Value()
+	resp := roundTrip(t, dial(t, addr), "GET", "/files/a.txt", nil, nil)
+	if resp.Status != 503 {
+		t.Errorf("GET while the root is gone = %d, want 503", resp.Status)
+	}
+	if got := resp.Header("Retry-After"); got != "3600" {
+		t.Errorf("Retry-After = %q, want the probe interval", got)
+	}
+	if got := unavailable.Value() - before; got != 1 {
+		t.Errorf("static_root_unavailable_total grew by %d, want 1", got)
+	}
+	if status, body := probe(t, addr, "/readyz"); status != 503 || body != "degraded" {
+		t.Errorf("/readyz while the root is gone = %d %q, want 503 degraded", status, body)
+	}
+	if status, _ := probe(t, addr, "/healthz"); status != 200 {
+		t.Errorf("/healthz while the root is gone = %d, want 200", status)
+	}
+	if got := Metrics.Gauge("static_root_healthy").Value(); got != 0 {
+		t.Errorf("static_root_healthy = %d, want 0", got)
+	}
+
+	if err := os.Rename(moved, public); err != nil {
+		t.Fatal(err)
+	}
+	srv.filesProbe.Check()
+
+	if status, body := probe(t, addr, "/files/a.txt"); status != 200 || body != "hello" {
+		t.Errorf("GET after recovery = %d %q, want 200", status, body)
+	}
+	if status, body := probe(t, addr, "/readyz"); status != 200 || body != "ready" {
+		t.Errorf("/readyz after recovery = %d %q, want 200 ready", status, body)
+	}
+}
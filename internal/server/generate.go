@@ -0,0 +1,251 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+const (
+	// generateWriteSize is the write size used when no chunk size is requested.
+	generateWriteSize = 32 * 1024
+
+	// generateMaxChunk is the largest chunk a client may ask for, as each
+	// response holds a buffer of that size while it streams.
+	generateMaxChunk = 1 << 20
+
+	// generateMaxDelay caps the pause between chunks, and generateMaxPause
+	// the pauses of a whole response, so no request holds its connection
+	// and bulkhead slot for long.
+	generateMaxDelay = 10 * time.Second
+	generateMaxPause = time.Minute
+)
+
+// handleGenerate returns the handler for "/generate", which streams
+// deterministic pseudo-random bytes for load testing clients and proxies.
+//
+// Query parameters:
+//   - size:  Payload size, e.g. "512", "64KB", "10MB" (default: 1MB, at most maxBytes)
+//   - chunk: Write the payload in chunks of this size (default: unchunked, at most 1MB)
+//   - delay: Pause between chunks, as a Go duration such as "10ms" (default:
+//     none, at most 10s, and a minute for all the pauses of the response)
+//   - seed:  Generator seed; equal seeds produce equal payloads (default: 0)
+//
+// The byte at any offset depends only on the seed, so Range requests are
+// served exactly and a resumed download matches a full one. Without chunk
+// or delay the response carries a Content-Length; otherwise it is sent with
+// chunked transfer encoding.
+func handleGenerate(maxBytes int64) HandlerFunc {
+	return func(req *Request) Response {
+		size, err := queryByteSize(req, "size", 1<<20)
+		if err != nil {
+			return BadRequestErrorResponse(err)
+		}
+		if size > maxBytes {
+			return BadRequestErrorResponse(&ParamError{
+				Source: "query", Name: "size", Value: strconv.FormatInt(size, 10),
+				Err: ErrParamInvalid, Reason: fmt.Sprintf("exceeds maximum of %d bytes", maxBytes),
+			})
+		}
+		chunk, err := queryByteSize(req, "chunk", 0)
+		if err != nil {
+			return BadRequestErrorResponse(err)
+		}
+		if chunk > generateMaxChunk {
+			return BadRequestErrorResponse(&ParamError{
+				Source: "query", Name: "chunk", Value: strconv.FormatInt(chunk, 10),
+				Err: ErrParamInvalid, Reason: fmt.Sprintf("exceeds maximum of %d bytes", generateMaxChunk),
+			})
+		}
+		var delay time.Duration
+		raw, ok, err := req.queryParam("delay")
+		if err != nil {
+			return BadRequestErrorResponse(err)
+		}
+		if ok {
+			delay, err = time.ParseDuration(raw)
+			if err != nil || delay < 0 {
+				return BadRequestErrorResponse(&ParamError{Source: "query", Name: "delay", Value: raw, Err: ErrParamInvalid, Reason: "not a valid duration"})
+			}
+			if delay > generateMaxDelay {
+				return BadRequestErrorResponse(&ParamError{Source: "query", Name: "delay", Value: raw, Err: ErrParamInvalid, Reason: fmt.Sprintf("exceeds maximum of %v", generateMaxDelay)})
+			}
+		}
+		var seed uint64
+		raw, ok, err = req.queryParam("seed")
+		if err != nil {
+			return BadRequestErrorResponse(err)
+		}
+		if ok {
+			seed, err = strconv.ParseUint(raw, 10, 64)
+			if err != nil {
+				return BadRequestErrorResponse(invalidParam("query", "seed", raw, err))
+			}
+		}
+
+		etag := fmt.Sprintf(`"gen-%x-%x"`, seed, size)
+		headers := map[string]string{
+			"Content-Type":  "application/octet-stream",
+			"Accept-Ranges": "bytes",
+			"ETag":          etag,
+		}
+		status, reason := 200, "OK"
+		br := byteRange{start: 0, end: size - 1}
+
+		if rangeHeader, ok := req.Headers["range"]; ok && size > 0 && ifRangeMatches(req.Headers["if-range"], etag, time.Time{}, time.Time{}) {
+			parsed, valid, satisfiable := parseByteRange(rangeHeader, size)
+			switch {
+			case valid && !satisfiable:
+				return RangeNotSatisfiableResponse(size)
+			case valid:
+				status, reason = 206, "Partial Content"
+				br = parsed
+				headers["Content-Range"] = br.contentRange(size)
+			}
+		}
+
+		if delay > 0 && generatePauses(br.length(), chunk) > int64(generateMaxPause/delay) {
+			return BadRequestErrorResponse(&ParamError{
+				Source: "query", Name: "delay", Value: delay.String(),
+				Err: ErrParamInvalid, Reason: fmt.Sprintf("pauses would exceed %v in total", generateMaxPause),
+			})
+		}
+
+		if chunk == 0 && delay == 0 {
+			headers["Content-Length"] = strconv.FormatInt(br.length(), 10)
+		}
+		utils.Info("Generating %d bytes (seed=%d, chunk=%d, delay=%v)", br.length(), seed, chunk, delay)
+
+		resp := Response{
+			Version: HTTPVersion,
+			Status:  status,
+			Reason:  reason,
+			Headers: headers,
+		}
+		// HEAD keeps the StreamFunc: the server drops it and keeps the
+		// framing headers GET would send.
+		if br.length() == 0 {
+			headers["Content-Length"] = "0"
+			return resp
+		}
+		ctx := req.Context()
+		resp.StreamFunc = func(w io.Writer) error {
+			return writeGenerated(ctx, w, seed, br, chunk, delay)
+		}
+		return resp
+	}
+}
+
+// generatePauses returns how many pauses writeGenerated makes between the
+// chunks of a length byte payload.
+func generatePauses(length, chunk int64) int64 {
+	writeSize := chunk
+	if writeSize <= 0 {
+		writeSize = generateWriteSize
+	}
+	return max((length+writeSize-1)/writeSize-1, 0)
+}
+
+// writeGenerated writes the generated bytes covered by br to w, in pieces
+// of chunk bytes (or generateWriteSize if chunk is 0) separated by delay.
+// It stops with ctx's error once ctx is done, such as when the client has
+// gone away during a pause.
+func writeGenerated(ctx context.Context, w io.Writer, seed uint64, br byteRange, chunk int64, delay time.Duration) error {
+	writeSize := chunk
+	if writeSize <= 0 {
+		writeSize = generateWriteSize
+	}
+	buf := make([]byte, min(writeSize, br.length()))
+	flusher, canFlush := w.(interface{ Flush() error })
+
+	for offset := br.start; offset <= br.end; {
+		n := min(int64(len(buf)), br.end-offset+1)
+		fillGenerated(buf[:n], seed, offset)
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+		offset += n
+
+		if delay > 0 && offset <= br.end {
+			if canFlush {
+				if err := flusher.Flush(); err != nil {
+					return err
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+	}
+	return nil
+}
+
+// fillGenerated fills p with the generated bytes starting at offset.
+//
+// Every 8-byte word of the payload is an independent splitmix64 output keyed
+// by the seed and the word index, so any range can be produced directly.
+func fillGenerated(p []byte, seed uint64, offset int64) {
+	for i := 0; i < len(p); {
+		pos := offset + int64(i)
+		word := splitmix64(seed + uint64(pos/8)*0x9e3779b97f4a7c15)
+		for b := pos % 8; b < 8 && i < len(p); b++ {
+			p[i] = byte(word >> (8 * b))
+			i++
+		}
+	}
+}
+
+// splitmix64 is the finalizer of the SplitMix64 generator.
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// queryByteSize returns the named query parameter parsed by parseByteSize,
+// or def if it is absent.
+func queryByteSize(req *Request, name string, def int64) (int64, error) {
+	raw, ok, err := req.queryParam(name)
+	if err != nil || !ok {
+		return def, err
+	}
+	n, err := parseByteSize(raw)
+	if err != nil {
+		return def, &ParamError{Source: "query", Name: name, Value: raw, Err: ErrParamInvalid, Reason: "not a valid size"}
+	}
+	return n, nil
+}
+
+// parseByteSize parses a size such as "512", "64KB" or "10MB". Units are
+// binary (1KB = 1024 bytes) and case-insensitive; the trailing "B" is
+// optional.
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		factor int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}} {
+		if trimmed, ok := strings.CutSuffix(s, unit.suffix); ok {
+			s, multiplier = trimmed, unit.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > (1<<62)/multiplier {
+		return 0, fmt.Errorf("size %q out of range", s)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestGenerateLimits(t *testing.T) {
+	handler := handleGenerate(1 << 30)
+	for _, tt := range []struct {
+		query  string
+		status int
+	}{
+		{"size=1GB&chunk=1MB", 200},
+		{"size=1GB&chunk=1GB", 400},
+		{"size=1MB&chunk=1025KB", 400},
+		{"size=64KB&chunk=1KB&delay=10ms", 200},
+		{"size=64KB&chunk=32KB&delay=11s", 400},
+		{"size=1MB&chunk=1&delay=1ms", 400},
+		{"size=64KB&delay=-1s", 400},
+	} {
+		req := &Request{Method: "GET", Headers: map[string]string{}}
+		req.setTarget("/generate?" + tt.query)
+		if resp := handler(req); resp.Status != tt.status {
+			t.Errorf("GET /generate?%s = %d %q, want %d", tt.query, resp.Status, resp.Body, tt.status)
+		}
+	}
+}
+
+func TestWriteGeneratedStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- writeGenerated(ctx, io.Discard, 0, byteRange{start: 0, end: 1023}, 1, time.Hour)
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("writeGenerated = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("writeGenerated kept pausing after its context was cancelled")
+	}
+}
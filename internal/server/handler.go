@@ -1,6 +1,8 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
@@ -24,9 +26,6 @@ func handleRoot(req *Request) Response {
 	case "GET", "HEAD":
 		body := []byte("Welcome to my HTTP server")
 		headers := map[string]string{"Content-Type": "text/plain"}
-		if req.Method == "HEAD" {
-			body = nil
-		}
 		return Response{
 			Version: HTTPVersion,
 			Status:  200,
@@ -57,9 +56,6 @@ func handleEcho(req *Request) Response {
 	case "GET", "HEAD":
 		utils.Info("Echo request: %s %s -> %s", req.Method, req.Path, message)
 		body := []byte(message)
-		if req.Method == "HEAD" {
-			body = nil
-		}
 		return Response{
 			Version: HTTPVersion,
 			Status:  200,
@@ -85,9 +81,6 @@ func handleUserAgent(req *Request) Response {
 		ua := req.Headers["user-agent"]
 		utils.Info("User-Agent request: %s %s -> %s", req.Method, req.Path, ua)
 		body := []byte(ua)
-		if req.Method == "HEAD" {
-			body = nil
-		}
 		return Response{
 			Version: HTTPVersion,
 			Status:  200,
@@ -103,70 +96,171 @@ func handleUserAgent(req *Request) Response {
 	}
 }
 
-// handleFiles handles requests to "/files/{filename}".
+// publicFiles is the "/files/" handler of one server, with the state it
+// keeps between requests. Each server has its own, so servers sharing a
+// process never see each other's cached misses.
+type publicFiles struct {
+	misses *negativeCache // paths found missing, see FILES_NEGATIVE_CACHE_SIZE
+	reads  *fileCoalescer // concurrent reads of one file
+	writes *pathLocks     // writes and deletes in progress, see lockWrite
+
+	// meta holds the metadata of the files. Deleting a file, or moving it
+	// into the trash, takes its metadata along.
+	meta MetadataStore
+
+	// lockTimeout is how long a write waits for another server's lock
+	// file, or zero while cross-process locking is off, see
+	// FILES_CROSS_PROCESS_LOCKING.
+	lockTimeout time.Duration
+
+	// listing answers GET /files/ on directories without an index.html
+	// with a listing rather than 403, see ENABLE_DIR_LISTING.
+	listing bool
+}
+
+// newPublicFiles creates the files handler state with the default
+// settings, which buildServer replaces with the configured ones.
+func newPublicFiles() *publicFiles {
+	return &publicFiles{
+		misses: newNegativeCache(DefaultNegativeCacheSize, DefaultNegativeCacheTTL),
+		reads:  newFileCoalescer(),
+		writes: newPathLocks(),
+		meta:   SidecarMetadata{},
+	}
+}
+
+// forget drops what f remembers about filePath once it was written,
+// deleted, restored or moved, so the next request sees the change.
+func (f *publicFiles) forget(filePath string) {
+	f.misses.forget(filePath)
+	f.reads.forget(filePath)
+}
+
+// handle handles requests to "/files/{filename}".
 //
 // Supported Methods:
 //   - GET: Returns file content from the "public" directory.
 //   - POST/PUT: Creates or overwrites a file with the request body.
-//   - DELETE: Deletes the specified file.
+//   - DELETE: Deletes the specified file and its metadata.
 //   - HEAD: Returns headers only.
 //   - OPTIONS: Returns allowed methods.
 //
+// Writes and deletes of one path run one at a time, and honor If-Match
+// and If-None-Match against the file's ETag, answering a stale writer
+// with 412 Precondition Failed; see checkWritePreconditions. With
+// FILES_CROSS_PROCESS_LOCKING they also hold a lock file, so servers
+// sharing the public directory take turns too; see lockWrite.
+//
+// Paths found missing are remembered for a few seconds and answered
+// without touching the disk until a write through the server touches
+// them, see FILES_NEGATIVE_CACHE_SIZE.
+//
+// GET and HEAD on a directory, "/files/" included, serve its index.html,
+// or else list it or answer 403 depending on ENABLE_DIR_LISTING; see
+// serveDir.
+//
+// The filename is percent-decoded once and stored as UTF-8, see
+// decodeFilePath.
+//
 // Error Handling:
-//   - 400 Bad Request: No filename specified.
+//   - 400 Bad Request: No filename specified for a write or delete, or
+//     one that is unsafe or not valid UTF-8 once decoded.
+//   - 403 Forbidden: The filename reaches outside the public directory,
+//     through "..", an absolute path or an encoded separator, or holds a
+//     NUL byte.
 //   - 404 Not Found: File does not exist (GET/DELETE).
+//   - 412 Precondition Failed: If-Match or If-None-Match does not hold.
+//   - 423 Locked: Another server held the file's lock for FILES_LOCK_TIMEOUT.
 //   - 500 Internal Server Error: Failed to read/write the file.
 //   - 405 Method Not Allowed: Unsupported HTTP method.
 //
 // Returns:
 //
 //	Response struct with status, headers, and body.
-func handleFiles(req *Request) Response {
-	parts := strings.SplitN(req.Path, "/files/", 2)
-	if len(parts) < 2 || parts[1] == "" {
-		utils.Warn("File request with no filename: %s %s", req.Method, req.Path)
-		return Response{
-			Version: HTTPVersion,
-			Status:  400,
-			Reason:  "Bad Request",
-			Headers: map[string]string{"Content-Type": "text/plain"},
-			Body:    []byte("No file specified"),
+func (f *publicFiles) handle(req *Request) Response {
+	if _, raw, _ := strings.Cut(req.Path, "/files/"); strings.HasSuffix("/"+raw, "/") && (req.Method == "GET" || req.Method == "HEAD") {
+		dirPath := getPublicDir()
+		if raw != "" {
+			var errResp *Response
+			if dirPath, errResp = publicFileAt(req, strings.TrimSuffix(raw, "/")); errResp != nil {
+				return *errResp
+			}
 		}
+		if info, err := os.Stat(dirPath); err != nil || !info.IsDir() {
+			return NotFoundResponse()
+		}
+		return f.serveDir(req, dirPath)
+	}
+	filePath, errResp := publicFilePath(req)
+	if errResp != nil {
+		return *errResp
 	}
-
-	filePath := filepath.Join(getPublicDir(), parts[1])
 
 	switch req.Method {
-	case "GET", "HEAD":
-		data, err := os.ReadFile(filePath)
+	case "HEAD":
+		// Only stat the file: HEAD must describe the body GET would send
+		// without the cost of reading it.
+		if f.misses.missing(filePath) {
+			return NotFoundResponse()
+		}
+		gen := f.misses.generation()
+		info, err := os.Stat(filePath)
+		if err == nil && info.IsDir() {
+			return f.serveDir(req, filePath)
+		}
 		if err != nil {
+			f.misses.add(filePath, gen, err)
 			utils.Warn("File not found: %s", filePath)
 			return NotFoundResponse()
 		}
-		mimeType := mime.TypeByExtension(filepath.Ext(filePath))
-		if mimeType == "" {
-			mimeType = "application/octet-stream"
+		return Response{
+			Version:       HTTPVersion,
+			Status:        200,
+			Reason:        "OK",
+			Headers:       fileHeaders(filePath, info),
+			ContentLength: info.Size(),
 		}
-		utils.Info("Serving file: %s (%s)", filePath, mimeType)
 
-		body := data
-		if req.Method == "HEAD" {
-			body = nil
+	case "GET":
+		if f.misses.missing(filePath) {
+			return NotFoundResponse()
 		}
-		return Response{
-			Version: "HTTP/1.1",
-			Status:  200,
-			Reason:  "OK",
-			Headers: map[string]string{
-				"Content-Type":   mimeType,
-				"Content-Length": strconv.Itoa(len(data)),
-			},
-			Body: body,
+		gen := f.misses.generation()
+		loaded, err := f.reads.load(filePath)
+		if err != nil {
+			if info, statErr := os.Stat(filePath); statErr == nil && info.IsDir() {
+				return f.serveDir(req, filePath)
+			}
+			f.misses.add(filePath, gen, err)
+			utils.Warn("File not found: %s", filePath)
+			return NotFoundResponse()
 		}
+		return fileResponse(req, filePath, loaded.info, loaded.data)
 
 	case "POST", "PUT":
-		if err := os.WriteFile(filePath, req.Body, 0644); err != nil {
+		unlock, errResp := f.lockWrite(filePath)
+		if errResp != nil {
+			return *errResp
+		}
+		defer unlock()
+		if resp := checkWritePreconditions(req, filePath); resp != nil {
+			return *resp
+		}
+		var reported int64
+		result, err := CopyBodyToFile(req, filePath, CopyOptions{
+			Progress: func(p UploadProgress) error {
+				Metrics.Counter("files_upload_bytes_total").Add(p.Written - reported)
+				reported = p.Written
+				return nil
+			},
+		})
+		f.forget(filePath)
+		if err != nil {
 			utils.Error("Failed to write file: %s, error: %v", filePath, err)
+			var httpErr *HTTPError
+			if errors.As(err, &httpErr) {
+				return httpErr.Response()
+			}
 			return Response{
 				Version: "HTTP/1.1",
 				Status:  500,
@@ -181,20 +275,45 @@ func handleFiles(req *Request) Response {
 			status = 200
 			reason = "OK"
 		}
-		utils.Info("File %s successfully written", filePath)
+		utils.Info("File %s successfully written (%d bytes, sha256 %s)", filePath, result.Bytes, result.SHA256)
+		headers := map[string]string{"Content-Type": "text/plain"}
+		if info, err := os.Stat(filePath); err == nil {
+			headers["ETag"] = fileETag(info)
+		}
+		if status == 201 {
+			rel, _ := filepath.Rel(getPublicDir(), filePath)
+			if location, err := SafeLocation("/files/", filepath.ToSlash(rel)); err == nil {
+				headers["Location"] = location
+			} else {
+				utils.Warn("Not sending Location for %s: %v", filePath, err)
+			}
+		}
 		return Response{
 			Version: "HTTP/1.1",
 			Status:  status,
 			Reason:  reason,
-			Headers: map[string]string{"Content-Type": "text/plain"},
+			Headers: headers,
 			Body:    []byte("File written successfully"),
 		}
 
 	case "DELETE":
-		if err := os.Remove(filePath); err != nil {
+		unlock, errResp := f.lockWrite(filePath)
+		if errResp != nil {
+			return *errResp
+		}
+		defer unlock()
+		if resp := checkWritePreconditions(req, filePath); resp != nil {
+			return *resp
+		}
+		err := os.Remove(filePath)
+		f.forget(filePath)
+		if err != nil {
 			utils.Error("Failed to delete file: %s, error: %v", filePath, err)
 			return NotFoundResponse()
 		}
+		if err := f.meta.Delete(filePath); err != nil {
+			utils.Warn("Failed to delete metadata of %s: %v", filePath, err)
+		}
 		utils.Info("Deleted file: %s", filePath)
 		return Response{
 			Version: "HTTP/1.1",
@@ -205,39 +324,249 @@ func handleFiles(req *Request) Response {
 		}
 
 	case "OPTIONS":
-		return OptionsResponse("GET, HEAD, OPTIONS")
+		return OptionsResponse(filesAllow)
 
 	default:
 		utils.Warn("Unsupported method on file: %s %s", req.Method, req.Path)
-		return MethodNotAllowedResponse("GET, HEAD, POST, PUT, DELETE, OPTIONS")
+		return MethodNotAllowedResponse(filesAllow)
 	}
 }
 
+// serveDir answers GET and HEAD for dirPath, a directory under the
+// public directory. A request without a trailing slash is redirected to
+// one, so that relative links in the directory's pages resolve inside it.
+// Otherwise its index.html is served if it has one, else a listing with
+// directories first (see dirListing), or 403 when f.listing is off.
+func (f *publicFiles) serveDir(req *Request, dirPath string) Response {
+	if !strings.HasSuffix(req.Path, "/") {
+		location := req.Path + "/"
+		if req.RawQuery != "" {
+			location += "?" + req.RawQuery
+		}
+		return Response{
+			Version: HTTPVersion,
+			Status:  301,
+			Reason:  "Moved Permanently",
+			Headers: map[string]string{"Location": location, "Content-Type": "text/plain"},
+			Body:    []byte("Moved Permanently"),
+		}
+	}
+	root := getPublicDir()
+	rel, err := filepath.Rel(root, dirPath)
+	if err != nil {
+		return NotFoundResponse()
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." {
+		rel = ""
+	}
+	opts := ServeDirOptions{Index: "index.html", Listing: f.listing, DirsFirst: true}
+	resp := serveDirEntry(req, "/files", rel, root, dirPath, opts)
+	if resp.Status == 404 && !opts.Listing && withinRoot(root, dirPath) {
+		utils.Debug("Directory listing disabled: %s", dirPath)
+		return ForbiddenResponse()
+	}
+	return resp
+}
+
+// fileResponse builds the GET response for a file described by info,
+// honoring Range and If-Range. data holds the file's contents, or is nil
+// for a file too large to hold in memory, whose body, or just the
+// requested range of it, is then streamed from disk.
+func fileResponse(req *Request, filePath string, info os.FileInfo, data []byte) Response {
+	headers := fileHeaders(filePath, info)
+	etag := headers["ETag"]
+	utils.Info("Serving file: %s (%s)", filePath, headers["Content-Type"])
+
+	size := info.Size()
+	if data != nil {
+		size = int64(len(data))
+	}
+	status, reason := 200, "OK"
+	br := byteRange{start: 0, end: size - 1}
+	if rangeHeader, ok := req.Headers["range"]; ok {
+		if ifRangeMatches(req.Headers["if-range"], etag, info.ModTime(), time.Now()) {
+			parsed, valid, satisfiable := parseByteRange(rangeHeader, size)
+			switch {
+			case valid && !satisfiable:
+				utils.Warn("Unsatisfiable range %q for %s (%d bytes)", rangeHeader, filePath, size)
+				return RangeNotSatisfiableResponse(size)
+			case valid:
+				status, reason = 206, "Partial Content"
+				br = parsed
+				headers["Content-Range"] = br.contentRange(size)
+				utils.Info("Serving range %s of %s", headers["Content-Range"], filePath)
+			}
+		} else {
+			utils.Info("If-Range validator mismatch for %s, serving full body", filePath)
+		}
+	}
+
+	headers["Content-Length"] = strconv.FormatInt(br.length(), 10)
+	resp := Response{
+		Version: "HTTP/1.1",
+		Status:  status,
+		Reason:  reason,
+		Headers: headers,
+	}
+	if data != nil {
+		resp.Body = data[br.start : br.end+1]
+	} else if br.length() > 0 {
+		resp.StreamFunc = fileSection(filePath, br)
+	}
+	return resp
+}
+
+// fileSection streams the bytes of the file at filePath that br covers.
+// A file that shrank since it was stat'ed aborts the stream, as its
+// Content-Length has already been sent.
+func fileSection(filePath string, br byteRange) func(io.Writer) error {
+	return func(w io.Writer) error {
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		Metrics.Counter("files_disk_reads_total").Inc()
+		if _, err := io.CopyN(w, io.NewSectionReader(f, br.start, br.length()), br.length()); err != nil {
+			return fmt.Errorf("failed to send %s: %w", filePath, err)
+		}
+		return nil
+	}
+}
+
+// publicFilePath maps a "/files/{filename}" request to its file in the
+// public directory. It returns a non-nil error response if the request
+// names no file or an unsafe one, 403 for one reaching outside the public
+// directory, and 404 for the server's own files, lock files (see
+// publicFiles.lockWrite) and metadata sidecars (see FileMetadata).
+func publicFilePath(req *Request) (string, *Response) {
+	_, raw, _ := strings.Cut(req.Path, "/files/")
+	return publicFileAt(req, raw)
+}
+
+// publicFileAt is publicFilePath for raw, the percent-encoded file path
+// following the prefix of the route req matched.
+func publicFileAt(req *Request, raw string) (string, *Response) {
+	if raw == "" {
+		utils.Warn("File request with no filename: %s %s", req.Method, req.Path)
+		return "", &Response{
+			Version: HTTPVersion,
+			Status:  400,
+			Reason:  "Bad Request",
+			Headers: map[string]string{"Content-Type": "text/plain"},
+			Body:    []byte("No file specified"),
+		}
+	}
+
+	name, err := decodeFilePath(raw)
+	if err != nil {
+		utils.Warn("Rejected file name: %s %q: %v", req.Method, raw, err)
+		resp := BadRequestErrorResponse(err)
+		return "", &resp
+	}
+	if isTraversal(name) {
+		Metrics.Counter("files_traversal_rejected_total").Inc()
+		utils.Warn("Rejected path traversal: %s %q", req.Method, name)
+		resp := ForbiddenResponse()
+		return "", &resp
+	}
+	relPath, ok := cleanRelativePath(name)
+	if !ok {
+		utils.Warn("Rejected unsafe file name: %s %q", req.Method, name)
+		resp := BadRequestErrorResponse(fmt.Errorf("invalid file name %q", name))
+		return "", &resp
+	}
+	if isReservedFile(filepath.Base(relPath)) {
+		resp := NotFoundResponse()
+		return "", &resp
+	}
+	filePath, ok := joinWithin(getPublicDir(), relPath)
+	if !ok {
+		Metrics.Counter("files_traversal_rejected_total").Inc()
+		utils.Warn("Rejected file outside the public directory: %s %q", req.Method, name)
+		resp := ForbiddenResponse()
+		return "", &resp
+	}
+	return filePath, nil
+}
+
+// fileHeaders returns the representation headers shared by GET and HEAD
+// responses for a file, so both carry identical validators.
+func fileHeaders(filePath string, info os.FileInfo) map[string]string {
+	mimeType := mime.TypeByExtension(filepath.Ext(filePath))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	return map[string]string{
+		"Content-Type":  mimeType,
+		"Accept-Ranges": "bytes",
+		"ETag":          fileETag(info),
+		"Last-Modified": fileLastModified(info),
+	}
+}
+
+// handleUserByID handles requests to "/user/:id", answering with the user
+// as JSON, e.g. {"id":42}.
+//
+// The id parameter must be an integer; anything else is answered with a
+// 400 naming the parameter.
 func handleUserByID(req *Request) Response {
-	utils.Info("Regex route matched: %s", req.Path)
-	return Response{
-		Version: HTTPVersion,
-		Status:  200,
-		Reason:  "OK",
-		Headers: map[string]string{"Content-Type": "text/plain"},
-		Body:    []byte(fmt.Sprintf("Matched user path: %s", req.Path)),
+	id, err := req.ParamInt("id")
+	if err != nil {
+		utils.Warn("Invalid user id: %v", err)
+		return BadRequestErrorResponse(err)
 	}
+	utils.Info("User route matched: %s -> id=%d", req.Path, id)
+	return JSONResponse(200, struct {
+		ID int `json:"id"`
+	}{ID: id})
 }
 
+// handleStream handles "/stream", sending ten chunks one second apart. The
+// chunks are produced by a Request.Go task, so the producer is tracked and
+// stops as soon as the client disconnects.
 func handleStream(req *Request) Response {
 	utils.Info("Starting streaming response")
 
+	chunks := make(chan string)
+	req.Go(func(ctx context.Context) {
+		defer close(chunks)
+		for i := 1; i <= 10; i++ {
+			select {
+			case chunks <- fmt.Sprintf("Chunk %d\n", i):
+			case <-ctx.Done():
+				return
+			}
+			if i == 10 {
+				return
+			}
+			select {
+			case <-time.After(1 * time.Second):
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+
 	return Response{
 		Version: "HTTP/1.1",
 		Status:  200,
 		Reason:  "OK",
 		Headers: map[string]string{"Content-Type": "text/plain"},
 		StreamFunc: func(w io.Writer) error {
-			for i := 1; i <= 10; i++ {
-				fmt.Fprintf(w, "Chunk %d\n", i)
-				time.Sleep(1 * time.Second)
+			flusher, _ := w.(interface{ Flush() error })
+			for chunk := range chunks {
+				if _, err := io.WriteString(w, chunk); err != nil {
+					return err
+				}
+				if flusher != nil {
+					if err := flusher.Flush(); err != nil {
+						return err
+					}
+				}
 			}
-			return nil
+			return context.Cause(req.Context())
 		},
 	}
 }
@@ -251,3 +580,52 @@ func getPublicDir() string {
 	}
 	return publicDir
 }
+
+// cleanRelativePath checks a client-supplied slash-separated path segment
+// by segment with utils.SanitizeFilename. It reports false unless every
+// segment is already a sanitized name, so traversal ("..") and names that
+// would not survive on another platform are refused rather than silently
+// rewritten.
+func cleanRelativePath(rel string) (string, bool) {
+	segments := strings.Split(rel, "/")
+	for _, segment := range segments {
+		clean, err := utils.SanitizeFilename(segment)
+		if err != nil || clean != segment {
+			return "", false
+		}
+	}
+	return filepath.Join(segments...), true
+}
+
+// isTraversal reports whether the decoded file path name tries to reach
+// outside the directory it is looked up in: it is absolute, names a
+// volume, has a ".." segment with either separator, or holds a NUL byte.
+func isTraversal(name string) bool {
+	if strings.ContainsRune(name, 0) || strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) || filepath.VolumeName(name) != "" {
+		return true
+	}
+	if len(name) >= 2 && name[1] == ':' {
+		return true // a drive letter, even where filepath does not know them
+	}
+	for _, segment := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// joinWithin joins rel onto root and reports whether the cleaned result
+// still lies strictly inside root.
+func joinWithin(root, rel string) (string, bool) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", false
+	}
+	full := filepath.Join(root, rel)
+	back, err := filepath.Rel(root, full)
+	if err != nil || back == "." || back == ".." || strings.HasPrefix(back, ".."+string(filepath.Separator)) || filepath.IsAbs(back) {
+		return "", false
+	}
+	return full, true
+}
@@ -0,0 +1,366 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServersKeepSeparateNegativeCaches(t *testing.T) {
+	public := chdirPublic(t)
+	_, addrA := startServer(t)
+	_, addrB := startServer(t)
+	a, b := dial(t, addrA), dial(t, addrB)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+
+	if resp := roundTrip(t, a, "GET", "/files/late.txt", keepAlive, nil); resp.Status != 404 {
+		t.Fatalf("GET before the file exists = %d, want 404", resp.Status)
+	}
+	// Written behind the servers' backs, so no write invalidates A's miss.
+	if err := os.WriteFile(filepath.Join(public, "late.txt"), []byte("here"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if resp := roundTrip(t, b, "GET", "/files/late.txt", keepAlive, nil); resp.Status != 200 {
+		t.Errorf("GET on the other server = %d, want 200: it must not share the first one's cache", resp.Status)
+	}
+	if resp := roundTrip(t, a, "GET", "/files/late.txt", keepAlive, nil); resp.Status != 404 {
+		t.Errorf("GET on the first server = %d, want its cached 404", resp.Status)
+	}
+}
+
+func TestFilesDirectories(t *testing.T) {
+	public := chdirPublic(t)
+	for path, content := range map[string]string{
+		"site/index.html": "<h1>site</h1>",
+		"docs/b.txt":      "b",
+		"docs/a.txt":      "a",
+		"docs/sub/c.txt":  "c",
+	} {
+		path = filepath.Join(public, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("ENABLE_DIR_LISTING", "false")
+	_, closed := startServer(t)
+	t.Setenv("ENABLE_DIR_LISTING", "true")
+	_, open := startServer(t)
+	acceptJSON := map[string]string{"Accept": "application/json"}
+
+	for _, addr := range []string{closed, open} {
+		resp := roundTrip(t, dial(t, addr), "GET", "/files/site/", nil, nil)
+		if resp.Status != 200 || string(resp.Body) != "<h1>site</h1>" {
+			t.Errorf("GET /files/site/ = %d %q, want its index.html", resp.Status, resp.Body)
+		}
+		resp = roundTrip(t, dial(t, addr), "GET", "/files/site?x=1", nil, nil)
+		if resp.Status != 301 || resp.Header("Location") != "/files/site/?x=1" {
+			t.Errorf("GET /files/site = %d to %q, want a redirect to the slashed path", resp.Status, resp.Header("Location"))
+		}
+	}
+
+	if resp := roundTrip(t, dial(t, closed), "GET", "/files/docs/", acceptJSON, nil); resp.Status != 403 {
+		t.Errorf("GET /files/docs/ with listings off = %d, want 403", resp.Status)
+	}
+	resp := roundTrip(t, dial(t, open), "GET", "/files/docs/", acceptJSON, nil)
+	if resp.Status != 200 {
+		t.Fatalf("GET /files/docs/ with listings on = %d, want 200", resp.Status)
+	}
+	var entries []DirEntry
+	if err := json.Unmarshal(resp.Body, &entries); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	if want := []string{"sub", "a.txt", "b.txt"}; !slices.Equal(names, want) {
+		t.Errorf("listing = %v, want %v with directories first", names, want)
+	}
+}
+
+func TestCleanRelativePath(t *testing.T) {
+	for _, tt := range []struct {
+		rel  string
+		want string
+		ok   bool
+	}{
+		{"a.txt", "a.txt", true},
+		{"docs/a.txt", filepath.Join("docs", "a.txt"), true},
+		{"..", "", false},
+		{"docs/../../etc/passwd", "", false},
+		{"a.txt\x00.jpg", "", false},
+		{"docs//a.txt", "", false},
+		{"CON", "", false},
+		{"name.", "", false},
+	} {
+		if got, ok := cleanRelativePath(tt.rel); got != tt.want || ok != tt.ok {
+			t.Errorf("cleanRelativePath(%q) = %q, %t; want %q, %t", tt.rel, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestEcho(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+
+	for _, tt := range []struct{ path, body string }{
+		{"/echo/abc", "abc"},
+		{"/echo/a/b", "a/b"},
+		{"/echo/", ""},
+	} {
+		resp := roundTrip(t, c, "GET", tt.path, keepAlive, nil)
+		if resp.Status != 200 || string(resp.Body) != tt.body {
+			t.Errorf("GET %s = %d %q, want 200 %q", tt.path, resp.Status, resp.Body, tt.body)
+		}
+	}
+}
+
+func TestHEADMatchesGET(t *testing.T) {
+	public := chdirPublic(t)
+	if err := os.WriteFile(filepath.Join(public, "foo.txt"), []byte("hello, head\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+
+	for _, path := range []string{"/files/foo.txt", "/echo/msg", "/"} {
+		get := roundTrip(t, c, "GET", path, keepAlive, nil)
+		head := roundTrip(t, c, "HEAD", path, keepAlive, nil)
+		if get.Status != 200 || head.Status != 200 {
+			t.Fatalf("%s: GET %d, HEAD %d, want 200", path, get.Status, head.Status)
+		}
+		want := get.Header("Content-Length")
+		if want != strconv.Itoa(len(get.Body)) {
+			t.Errorf("GET %s: Content-Length = %q for a %d byte body", path, want, len(get.Body))
+		}
+		for _, name := range []string{"Content-Length", "Content-Type", "ETag", "Last-Modified", "Cache-Control"} {
+			if got, want := head.Header(name), get.Header(name); got != want {
+				t.Errorf("HEAD %s: %s = %q, want GET's %q", path, name, got, want)
+			}
+		}
+		if len(head.Body) != 0 {
+			t.Errorf("HEAD %s: body %q, want none", path, head.Body)
+		}
+	}
+	file := roundTrip(t, c, "HEAD", "/files/foo.txt", keepAlive, nil)
+	if file.Header("ETag") == "" || file.Header("Last-Modified") == "" {
+		t.Errorf("HEAD /files/foo.txt: ETag %q, Last-Modified %q; want both validators", file.Header("ETag"), file.Header("Last-Modified"))
+	}
+	// The connection is still in step: no body followed a HEAD response.
+	if err := c.ExpectEmpty(50 * time.Millisecond); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHEADWithoutBody(t *testing.T) {
+	_, addr := startServerWithRoutes(t, func(r *Router) {
+		r.Handle("/report", "HEAD", func(*Request) Response {
+			return Response{
+				Version:       HTTPVersion,
+				Status:        200,
+				Reason:        "OK",
+				Headers:       map[string]string{"Content-Type": "text/csv", "ETag": `"r1"`},
+				ContentLength: 1 << 20,
+			}
+		})
+		r.Handle("/feed", "GET", func(*Request) Response {
+			return Response{
+				Version:    HTTPVersion,
+				Status:     200,
+				Reason:     "OK",
+				Headers:    map[string]string{"Content-Type": "text/plain"},
+				StreamFunc: func(w io.Writer) error { _, err := io.WriteString(w, "data"); return err },
+			}
+		})
+	})
+	c := dial(t, addr)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+
+	resp := roundTrip(t, c, "HEAD", "/report", keepAlive, nil)
+	if got := resp.Header("Content-Length"); got != "1048576" || resp.Header("ETag") != `"r1"` {
+		t.Errorf("HEAD /report: Content-Length %q, ETag %q; want the declared 1048576 and the handler's ETag", got, resp.Header("ETag"))
+	}
+	resp = roundTrip(t, c, "HEAD", "/feed", keepAlive, nil)
+	if got := resp.Header("Transfer-Encoding"); got != "chunked" || resp.Header("Content-Length") != "" {
+		t.Errorf("HEAD /feed: Transfer-Encoding %q, Content-Length %q; want chunked like GET", got, resp.Header("Content-Length"))
+	}
+	// Neither declared body was written.
+	if err := c.ExpectEmpty(50 * time.Millisecond); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBatchHEADMatchesGET(t *testing.T) {
+	public := chdirPublic(t)
+	if err := os.WriteFile(filepath.Join(public, "foo.txt"), []byte("hello, head\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BATCH_ENDPOINT", "true")
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	resp := roundTrip(t, c, "POST", "/batch", nil, []byte(`[{"path":"/files/foo.txt"},{"method":"HEAD","path":"/files/foo.txt"}]`))
+	if resp.Status != 200 {
+		t.Fatalf("batch status = %d, want 200", resp.Status)
+	}
+	var results []BatchResult
+	if err := json.Unmarshal(resp.Body, &results); err != nil {
+		t.Fatal(err)
+	}
+	get, head := results[0], results[1]
+	if get.Status != 200 || head.Status != 200 {
+		t.Fatalf("GET %d, HEAD %d, want 200", get.Status, head.Status)
+	}
+	if got, want := head.Headers["Content-Length"], strconv.Itoa(len(get.Body)); got != want {
+		t.Errorf("HEAD Content-Length = %q, want %q", got, want)
+	}
+	if head.Body != "" || head.BodyBase64 != "" {
+		t.Errorf("HEAD body = %q, want none", head.Body+head.BodyBase64)
+	}
+}
+
+func TestIsTraversal(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"docs/a.txt", false},
+		{"a..b.txt", false},
+		{"..hidden", false},
+		{"...", false},
+		{"a/./b.txt", false},
+		{"..", true},
+		{"../etc/passwd", true},
+		{"docs/../../etc/passwd", true},
+		{"docs/..", true},
+		{`..\..\win.ini`, true},
+		{`docs\..\..\win.ini`, true},
+		{`docs/..\x`, true},
+		{"/etc/passwd", true},
+		{`\etc\passwd`, true},
+		{`\\server\share\x`, true},
+		{`C:\Windows\win.ini`, true},
+		{"c:win.ini", true},
+		{"a.txt\x00.jpg", true},
+	}
+	for _, tt := range tests {
+		if got := isTraversal(tt.name); got != tt.want {
+			t.Errorf("isTraversal(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsTraversalEncoded(t *testing.T) {
+	// Separators and dots arrive percent-encoded; the check runs on the
+	// name decodeFilePath produces from them.
+	tests := []struct {
+		raw  string
+		want bool
+	}{
+		{"..%2f..%2fetc%2fpasswd", true},
+		{"%2e%2e/etc/passwd", true},
+		{"%2E%2E%2Fsecret.txt", true},
+		{"..%5C..%5Cwin.ini", true},
+		{"%2Fetc%2Fpasswd", true},
+		{"%5Cetc%5Cpasswd", true},
+		{"C%3A%5Cwin.ini", true},
+		{"a.txt%00.jpg", true},
+		// Decoded once: a double-encoded separator stays in the name.
+		{"..%252f..%252fsecret.txt", false},
+		{"%2e%2e.txt", false},
+	}
+	for _, tt := range tests {
+		name, err := decodeFilePath(tt.raw)
+		if err != nil {
+			t.Errorf("decodeFilePath(%q): %v", tt.raw, err)
+			continue
+		}
+		if got := isTraversal(name); got != tt.want {
+			t.Errorf("isTraversal(%q), decoded from %q, = %v, want %v", name, tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestJoinWithin(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "public")
+	tests := []struct {
+		rel  string
+		want string // "" when the join must be refused
+	}{
+		{"a.txt", filepath.Join(root, "a.txt")},
+		{filepath.Join("docs", "a.txt"), filepath.Join(root, "docs", "a.txt")},
+		{filepath.Join("docs", "..", "a.txt"), filepath.Join(root, "a.txt")},
+		// Joined, an absolute path is relative to root.
+		{string(filepath.Separator) + "a.txt", filepath.Join(root, "a.txt")},
+		{"", ""},
+		{".", ""},
+		{"docs" + string(filepath.Separator) + "..", ""},
+		{"..", ""},
+		{filepath.Join("..", "secret.txt"), ""},
+		{filepath.Join("docs", "..", "..", "secret.txt"), ""},
+		// A sibling sharing root's name as a prefix is still outside.
+		{filepath.Join("..", "public2", "a.txt"), ""},
+	}
+	for _, tt := range tests {
+		got, ok := joinWithin(root, tt.rel)
+		if ok != (tt.want != "") || got != tt.want {
+			t.Errorf("joinWithin(root, %q) = %q, %v, want %q", tt.rel, got, ok, tt.want)
+		}
+	}
+
+	// A relative root is resolved against the working directory.
+	t.Chdir(dir)
+	if got, ok := joinWithin("public", "a.txt"); !ok || got != filepath.Join(root, "a.txt") {
+		t.Errorf("joinWithin(%q, %q) = %q, %v, want %q", "public", "a.txt", got, ok, filepath.Join(root, "a.txt"))
+	}
+	if got, ok := joinWithin("public", filepath.Join("..", "secret.txt")); ok {
+		t.Errorf("joinWithin(%q, %q) = %q, want it refused", "public", filepath.Join("..", "secret.txt"), got)
+	}
+}
+
+func TestFilesTraversalRejected(t *testing.T) {
+	public := chdirPublic(t)
+	secret := filepath.Join(filepath.Dir(public), "secret.txt")
+	if err := os.WriteFile(secret, []byte("top secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, addr := startServer(t)
+	rejected := Metrics.Counter("files_traversal_rejected_total")
+
+	for _, path := range []string{
+		"/files/..%2fsecret.txt",
+		"/files/%2e%2e%2fsecret.txt",
+		"/files/docs%2f..%2f..%2fsecret.txt",
+		"/files/..%5Csecret.txt",
+		"/files/%2F" + url.PathEscape(secret),
+		"/files/C%3A%5Cwin.ini",
+		"/files/secret.txt%00.jpg",
+	} {
+		for _, method := range []string{"GET", "PUT", "DELETE"} {
+			before := rejected.Value()
+			resp := roundTrip(t, dial(t, addr), method, path, nil, []byte("overwritten"))
+			if resp.Status != 403 {
+				t.Errorf("%s %s = %d %q, want 403", method, path, resp.Status, resp.Body)
+			}
+			if rejected.Value() == before {
+				t.Errorf("%s %s: files_traversal_rejected_total did not grow", method, path)
+			}
+		}
+	}
+	if got, err := os.ReadFile(secret); err != nil || string(got) != "top secret" {
+		t.Errorf("file outside the public directory = %q, %v, want it untouched", got, err)
+	}
+}
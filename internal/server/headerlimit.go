@@ -0,0 +1,59 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// Default limits on the header block of a response, see
+// SetResponseHeaderLimits.
+const (
+	DefaultMaxResponseHeaderBytes = 64 << 10
+	DefaultMaxResponseHeaders     = 100
+)
+
+// SetResponseHeaderLimits bounds the serialized header block of every
+// response to maxBytes and its number of fields to maxCount, protecting
+// intermediaries from runaway handlers. 0 disables a limit.
+func (s *Server) SetResponseHeaderLimits(maxBytes, maxCount int) {
+	s.maxHeaderBytes = maxBytes
+	s.maxHeaderCount = maxCount
+}
+
+// enforceHeaderLimits replaces resp with a plain 500 if its header block
+// exceeds the server's limits, logging the route and the largest fields.
+// It runs right before the response is serialized, after post-processors,
+// so nothing has been written to the client yet.
+func (s *Server) enforceHeaderLimits(req *Request, resp *Response) {
+	if s.maxHeaderBytes <= 0 && s.maxHeaderCount <= 0 {
+		return
+	}
+
+	fields := canonicalHeaders(resp.Headers)
+	size := 0
+	for _, f := range fields {
+		size += len(f.name) + len(": ") + len(f.value) + len(CRLF)
+	}
+	tooBig := s.maxHeaderBytes > 0 && size > s.maxHeaderBytes
+	tooMany := s.maxHeaderCount > 0 && len(fields) > s.maxHeaderCount
+	if !tooBig && !tooMany {
+		return
+	}
+
+	sort.Slice(fields, func(i, j int) bool { return len(fields[i].value) > len(fields[j].value) })
+	var largest []string
+	for _, f := range fields[:min(len(fields), 5)] {
+		largest = append(largest, fmt.Sprintf("%s=%dB", f.name, len(f.value)))
+	}
+	utils.Error("Response header block too large for %s %s (route %s): %d fields, %d bytes (limits %d fields, %d bytes); largest: %s",
+		req.Method, req.Path, routeLabel(req.route), len(fields), size,
+		s.maxHeaderCount, s.maxHeaderBytes, strings.Join(largest, ", "))
+	Metrics.Counter("response_headers_too_large_total").Inc()
+
+	connection := resp.Headers["Connection"]
+	*resp = InternalServerErrorResponse()
+	resp.Headers["Connection"] = connection
+}
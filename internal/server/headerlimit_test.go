@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestEnforceHeaderLimits(t *testing.T) {
+	many := map[string]string{}
+	for i := range 101 {
+		many[fmt.Sprintf("X-H%03d", i)] = "v"
+	}
+	for _, tt := range []struct {
+		name               string
+		maxBytes, maxCount int
+		headers            map[string]string
+		replaced           bool
+	}{
+		{"within both limits", 1024, 10, map[string]string{"X-A": "1", "X-B": "2"}, false},
+		{"exactly the byte limit", len("X-A: 12345\r\n"), 10, map[string]string{"X-A": "12345"}, false},
+		{"one byte over", len("X-A: 12345\r\n") - 1, 10, map[string]string{"X-A": "12345"}, true},
+		{"exactly the count", 0, 2, map[string]string{"X-A": "1", "X-B": "2"}, false},
+		{"one field too many", 0, 2, map[string]string{"X-A": "1", "X-B": "2", "X-C": "3"}, true},
+		{"100 KB of header data", DefaultMaxResponseHeaderBytes, DefaultMaxResponseHeaders, map[string]string{"Link": strings.Repeat("l", 100<<10)}, true},
+		{"101 fields under the default count", DefaultMaxResponseHeaderBytes, DefaultMaxResponseHeaders, many, true},
+		{"limits off", 0, 0, many, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{}
+			s.SetResponseHeaderLimits(tt.maxBytes, tt.maxCount)
+			req := &Request{Method: "GET", Path: "/x"}
+			resp := Response{Status: 200, Headers: tt.headers, Body: []byte("body")}
+			s.enforceHeaderLimits(req, &resp)
+			if replaced := resp.Status == 500; replaced != tt.replaced {
+				t.Errorf("status = %d, want replaced with 500: %t", resp.Status, tt.replaced)
+			}
+			if !tt.replaced {
+				return
+			}
+			if string(resp.Body) == "body" {
+				t.Errorf("500 kept the handler's body")
+			}
+			for name := range tt.headers {
+				if _, ok := resp.Headers[name]; ok {
+					t.Errorf("500 kept the handler's %s header", name)
+				}
+			}
+		})
+	}
+}
+
+func TestServeOversizedResponseHeaders(t *testing.T) {
+	_, addr := startServerWithRoutes(t, func(r *Router) {
+		r.Handle("/cookies", "GET", func(*Request) Response {
+			resp := textResponse("ok")
+			resp.Headers["Set-Cookie"] = strings.Repeat("c", 100<<10)
+			resp.Headers["X-Small"] = "s"
+			return resp
+		})
+		r.Handle("/fine", "GET", func(*Request) Response { return textResponse("fine") })
+	})
+	c := dial(t, addr)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+
+	before := Metrics.Counter("response_headers_too_large_total").Value()
+	logged := captureLog(t, func() {
+		resp := roundTrip(t, c, "GET", "/cookies", keepAlive, nil)
+		if resp.Status != 500 || resp.Header("Set-Cookie") != "" {
+			t.Errorf("GET /cookies = %d with a %d byte Set-Cookie, want a clean 500", resp.Status, len(resp.Header("Set-Cookie")))
+		}
+	})
+	if !strings.Contains(logged, "Response header block too large for GET /cookies (route GET /cookies)") ||
+		!strings.Contains(logged, fmt.Sprintf("Set-Cookie=%dB", 100<<10)) {
+		t.Errorf("log %q, want the route and the offending header's size", logged)
+	}
+	if got := Metrics.Counter("response_headers_too_large_total").Value(); got != before+1 {
+		t.Errorf("response_headers_too_large_total = %d, want %d", got, before+1)
+	}
+
+	// The 500 replaced the response before anything was written, so the
+	// connection is still usable.
+	if resp := roundTrip(t, c, "GET", "/fine", keepAlive, nil); resp.Status != 200 || string(resp.Body) != "fine" {
+		t.Errorf("GET /fine after the 500 = %d %q, want 200", resp.Status, resp.Body)
+	}
+}
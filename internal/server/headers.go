@@ -0,0 +1,172 @@
+package server
+
+import (
+	"mime"
+	"sort"
+	"strings"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// headerField is a single header line as written to the wire.
+type headerField struct {
+	name  string
+	value string
+}
+
+// headerExceptions holds canonical spellings that do not follow the
+// Title-Case-on-dashes rule, keyed by lowercase name.
+var headerExceptions = map[string]string{
+	"content-md5":      "Content-MD5",
+	"dnt":              "DNT",
+	"etag":             "ETag",
+	"te":               "TE",
+	"www-authenticate": "WWW-Authenticate",
+	"x-request-id":     "X-Request-ID",
+	"x-xss-protection": "X-XSS-Protection",
+}
+
+// appendableHeaders are merged rather than replaced when a response sets
+// them under several spellings. Set-Cookie cannot be comma-joined, so its
+// values are written as separate lines; the others become one list.
+var appendableHeaders = map[string]bool{
+	"Link":       true,
+	"Set-Cookie": true,
+	"Vary":       true,
+	"Via":        true,
+}
+
+// canonicalHeaderKey returns the canonical form of a header name, e.g.
+// "content-type" -> "Content-Type" and "etag" -> "ETag".
+func canonicalHeaderKey(key string) string {
+	lower := strings.ToLower(key)
+	if canonical, ok := headerExceptions[lower]; ok {
+		return canonical
+	}
+
+	b := []byte(lower)
+	upper := true
+	for i, c := range b {
+		if upper && 'a' <= c && c <= 'z' {
+			b[i] = c - ('a' - 'A')
+		}
+		upper = c == '-'
+	}
+	return string(b)
+}
+
+// canonicalHeaders converts response headers into wire fields with
+// canonical names, sorted by name, merging keys that differ only in case.
+//
+// Merge policy: the last value set wins, where a spelling that is already
+// canonical counts as set last (it is how the server itself sets headers)
+// and other spellings are ordered lexically. Appendable headers such as
+// Vary and Set-Cookie keep every value instead.
+//
+// A header whose name is not a token or whose value holds a control
+// character, such as a CR or LF smuggled in from user input, is dropped
+// with a warning: written out, it could end the header block early and
+// split the response.
+func canonicalHeaders(headers map[string]string) []headerField {
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ci, cj := canonicalHeaderKey(keys[i]), canonicalHeaderKey(keys[j])
+		if ci != cj {
+			return ci < cj
+		}
+		if (keys[i] == ci) != (keys[j] == cj) {
+			return keys[j] == cj
+		}
+		return keys[i] < keys[j]
+	})
+
+	var fields []headerField
+	for _, k := range keys {
+		name, value := canonicalHeaderKey(k), headers[k]
+		if !isToken(k) || hasControlChar(value) {
+			utils.Warn("Dropping unsafe response header %q: %q", k, value)
+			Metrics.Counter("response_headers_dropped_total").Inc()
+			continue
+		}
+		last := len(fields) - 1
+		if last < 0 || fields[last].name != name || name == "Set-Cookie" {
+			fields = append(fields, headerField{name: name, value: value})
+			continue
+		}
+		if appendableHeaders[name] {
+			fields[last].value = mergeHeaderList(fields[last].value, value)
+		} else {
+			fields[last].value = value
+		}
+	}
+	return fields
+}
+
+// mergeHeaderList joins two comma-separated header values, dropping
+// elements of b already present in a (compared case-insensitively).
+func mergeHeaderList(a, b string) string {
+	seen := make(map[string]bool)
+	var merged []string
+	for _, list := range []string{a, b} {
+		for _, item := range strings.Split(list, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" || seen[strings.ToLower(item)] {
+				continue
+			}
+			seen[strings.ToLower(item)] = true
+			merged = append(merged, item)
+		}
+	}
+	return strings.Join(merged, ", ")
+}
+
+// hasHeaderField reports whether fields contains the canonical name.
+func hasHeaderField(fields []headerField, name string) bool {
+	for _, f := range fields {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// withDefaultCharset appends "; charset=utf-8" to text/* and
+// application/json media types that do not already name a charset. Other
+// types are returned unchanged.
+func withDefaultCharset(contentType string) string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	if !strings.HasPrefix(mediaType, "text/") && mediaType != "application/json" {
+		return contentType
+	}
+	if _, ok := params["charset"]; ok {
+		return contentType
+	}
+	return strings.TrimRight(contentType, "; ") + "; charset=utf-8"
+}
+
+// applyDefaultCharset rewrites the Content-Type field, if any, with
+// withDefaultCharset.
+func applyDefaultCharset(fields []headerField) {
+	for i := range fields {
+		if fields[i].name == "Content-Type" {
+			fields[i].value = withDefaultCharset(fields[i].value)
+		}
+	}
+}
+
+// hasControlChar reports whether a header value contains a control
+// character other than horizontal tab.
+func hasControlChar(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; (c < ' ' && c != '\t') || c == 0x7f {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// headerLines returns the header lines of the raw response head.
+func headerLines(raw []byte) []string {
+	head, _, _ := strings.Cut(string(raw), "\r\n\r\n")
+	lines := strings.Split(head, "\r\n")
+	return lines[1:]
+}
+
+func TestCanonicalHeaderKey(t *testing.T) {
+	for in, want := range map[string]string{
+		"content-type":      "Content-Type",
+		"CONTENT-TYPE":      "Content-Type",
+		"Content-Type":      "Content-Type",
+		"x-forwarded-for":   "X-Forwarded-For",
+		"host":              "Host",
+		"etag":              "ETag",
+		"ETAG":              "ETag",
+		"www-authenticate":  "WWW-Authenticate",
+		"content-md5":       "Content-MD5",
+		"x-request-id":      "X-Request-ID",
+		"te":                "TE",
+		"dnt":               "DNT",
+		"x-xss-protection":  "X-XSS-Protection",
+		"x--double":         "X--Double",
+		"-leading":          "-Leading",
+		"x-1st-try":         "X-1st-Try",
+		"accept_encoding":   "Accept_encoding",
+		"x-meta-owner-name": "X-Meta-Owner-Name",
+	} {
+		if got := canonicalHeaderKey(in); got != want {
+			t.Errorf("canonicalHeaderKey(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCanonicalHeadersMergePolicy(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		headers map[string]string
+		want    []string // "Name: value" in wire order
+	}{
+		{"sorted by canonical name",
+			map[string]string{"x-b": "2", "X-A": "1", "content-type": "text/html"},
+			[]string{"Content-Type: text/html", "X-A: 1", "X-B: 2"}},
+		{"canonical spelling wins",
+			map[string]string{"content-type": "text/html", "Content-Type": "text/plain"},
+			[]string{"Content-Type: text/plain"}},
+		{"canonical spelling wins over any other",
+			map[string]string{"Content-Type": "a/a", "CONTENT-TYPE": "b/b", "content-type": "c/c"},
+			[]string{"Content-Type: a/a"}},
+		{"other spellings in lexical order",
+			map[string]string{"CONTENT-TYPE": "b/b", "content-type": "c/c"},
+			[]string{"Content-Type: c/c"}},
+		{"Vary merged without duplicates",
+			map[string]string{"Vary": "Accept-Encoding", "vary": "Origin, accept-encoding"},
+			[]string{"Vary: Origin, accept-encoding"}},
+		{"Link merged",
+			map[string]string{"Link": "</a>; rel=next", "link": "</b>; rel=prev"},
+			[]string{"Link: </b>; rel=prev, </a>; rel=next"}},
+		{"Set-Cookie kept as separate lines",
+			map[string]string{"Set-Cookie": "a=1", "set-cookie": "b=2"},
+			[]string{"Set-Cookie: b=2", "Set-Cookie: a=1"}},
+		{"unsafe value dropped",
+			map[string]string{"X-Safe": "ok", "X-Evil": "a\r\nSet-Cookie: x=y"},
+			[]string{"X-Safe: ok"}},
+		{"unsafe name dropped",
+			map[string]string{"X Bad": "1", "X-Good": "1"},
+			[]string{"X-Good: 1"}},
+		{"tab allowed in values",
+			map[string]string{"X-Tab": "a\tb"},
+			[]string{"X-Tab: a\tb"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, f := range canonicalHeaders(tt.headers) {
+				got = append(got, f.name+": "+f.value)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("canonicalHeaders = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildResponseCanonicalOutput(t *testing.T) {
+	raw := BuildResponse(200, "OK", map[string]string{
+		"content-type":   "text/html; charset=utf-8",
+		"etag":           `"v1"`,
+		"x-request-id":   "abc",
+		"content-length": "5",
+	}, []byte("hello"))
+	want := []string{
+		"Content-Length: 5",
+		"Content-Type: text/html; charset=utf-8",
+		`ETag: "v1"`,
+		"X-Request-ID: abc",
+	}
+	if got := headerLines(raw); !slices.Equal(got, want) {
+		t.Errorf("header lines %q, want %q", got, want)
+	}
+
+	// The defaults never add a second spelling of a handler's header.
+	raw = BuildResponse(200, "OK", map[string]string{"CONTENT-TYPE": "application/octet-stream"}, nil)
+	want = []string{"Content-Type: application/octet-stream", "Content-Length: 0"}
+	if got := headerLines(raw); !slices.Equal(got, want) {
+		t.Errorf("header lines %q, want %q", got, want)
+	}
+}
+
+func TestWithDefaultCharset(t *testing.T) {
+	for in, want := range map[string]string{
+		"text/plain":                      "text/plain; charset=utf-8",
+		"text/html":                       "text/html; charset=utf-8",
+		"text/csv;":                       "text/csv; charset=utf-8",
+		"TEXT/PLAIN":                      "TEXT/PLAIN; charset=utf-8",
+		"application/json":                "application/json; charset=utf-8",
+		"text/plain; format=flowed":       "text/plain; format=flowed; charset=utf-8",
+		"text/plain; charset=iso-8859-1":  "text/plain; charset=iso-8859-1",
+		"text/html; charset=UTF-8":        "text/html; charset=UTF-8",
+		`text/plain; CHARSET="utf-8"`:     `text/plain; CHARSET="utf-8"`,
+		"application/problem+json":        "application/problem+json",
+		"application/octet-stream":        "application/octet-stream",
+		"image/png":                       "image/png",
+		"multipart/form-data; boundary=x": "multipart/form-data; boundary=x",
+		"not a media type":                "not a media type",
+	} {
+		if got := withDefaultCharset(in); got != want {
+			t.Errorf("withDefaultCharset(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestBuildResponseCharset(t *testing.T) {
+	for _, tt := range []struct {
+		headers map[string]string
+		want    string
+	}{
+		{nil, "Content-Type: text/plain; charset=utf-8"},
+		{map[string]string{"content-type": "text/html"}, "Content-Type: text/html; charset=utf-8"},
+		{map[string]string{"Content-Type": "text/html; charset=shift_jis"}, "Content-Type: text/html; charset=shift_jis"},
+		{map[string]string{"Content-Type": "image/gif"}, "Content-Type: image/gif"},
+	} {
+		lines := headerLines(BuildResponse(200, "OK", tt.headers, []byte("x")))
+		if !slices.Contains(lines, tt.want) || strings.Count(strings.Join(lines, "\n"), "charset") > 1 {
+			t.Errorf("headers %v: lines %q, want %q once", tt.headers, lines, tt.want)
+		}
+	}
+}
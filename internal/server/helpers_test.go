@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+	"github.com/Abb133Se/httpServer/internal/testclient"
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// loadConfig loads the configuration in the environment, which tests
+// adjust with t.Setenv beforehand. The access log is off unless ACCESS_LOG
+// is set, keeping a line per request out of the test output.
+func loadConfig() *config.Config {
+	cfg := config.LoadConfig()
+	if os.Getenv("ACCESS_LOG") == "" {
+		cfg.AccessLog = "off"
+	}
+	return cfg
+}
+
+// startServer builds the server the binary runs for loadConfig and
+// serves it on an ephemeral loopback port until the test ends. It returns
+// the server and its address.
+func startServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	srv, err := NewServerFromConfig(loadConfig())
+	if err != nil {
+		t.Fatalf("building server: %v", err)
+	}
+	return srv, serve(t, srv)
+}
+
+// startServerWithRoutes serves a bare server for the configuration in
+// the environment, with only the routes register adds to its router, until
+// the test ends. It returns the server and its address.
+func startServerWithRoutes(t *testing.T, register func(*Router)) (*Server, string) {
+	t.Helper()
+	router := NewRouter()
+	register(router)
+	srv := NewServer(config.LoadConfig(), router)
+	return srv, serve(t, srv)
+}
+
+// serve serves srv on an ephemeral loopback port until the test ends and
+// returns its address.
+func serve(t *testing.T, srv *Server) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveListener(t, srv, listener)
+	return listener.Addr().String()
+}
+
+// serveListener serves srv on listener until the test ends, then closes
+// listener and stops srv.
+func serveListener(t *testing.T, srv *Server, listener net.Listener) {
+	t.Helper()
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(listener) }()
+	t.Cleanup(func() {
+		listener.Close()
+		if err := <-served; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+		stop(srv)
+	})
+}
+
+// stop shuts srv down, waiting for its connections and background tasks,
+// so none of its goroutines outlive the test: a later test changing the
+// log level (see logAtLevel) would race with them.
+func stop(srv *Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	srv.Shutdown(ctx, "test")
+}
+
+// waitFor polls cond until it holds, failing t if it does not within five
+// seconds. It is for conditions reached by other goroutines that tests
+// cannot otherwise synchronize with.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// chdirPublic changes into a fresh directory holding an empty "public"
+// directory, the files root, for the rest of the test, and returns its
+// path.
+func chdirPublic(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	public := filepath.Join(dir, "public")
+	if err := os.Mkdir(public, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+	return public
+}
+
+// dial connects a test client to addr, closing it when the test ends.
+func dial(t *testing.T, addr string) *testclient.Client {
+	t.Helper()
+	c, err := testclient.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// roundTrip sends one request on c and reads its response, failing t on
+// any error.
+func roundTrip(t *testing.T, c *testclient.Client, method, path string, headers map[string]string, body []byte) *testclient.Response {
+	t.Helper()
+	if err := c.SendRequest(method, path, headers, body); err != nil {
+		t.Fatalf("%s %s: sending: %v", method, path, err)
+	}
+	resp, err := c.ReadResponse()
+	if err != nil {
+		t.Fatalf("%s %s: reading response: %v", method, path, err)
+	}
+	return resp
+}
+
+// textResponse returns a 200 text/plain response with body, for handlers
+// registered by tests.
+func textResponse(body string) Response {
+	return Response{
+		Version: HTTPVersion,
+		Status:  200,
+		Reason:  "OK",
+		Headers: map[string]string{"Content-Type": "text/plain"},
+		Body:    []byte(body),
+	}
+}
+
+// logAtLevel sets the utils log level for the rest of the test. Call it
+// before starting a server, so no server goroutine reads the level while
+// it changes; the default, which logs only errors, is restored at the end.
+func logAtLevel(t *testing.T, level string) {
+	t.Helper()
+	utils.InitLogger(level)
+	t.Cleanup(func() { utils.InitLogger("") })
+}
+
+// captureLog returns what the utils logger writes to standard output while
+// fn runs. Other goroutines logging at the same time end up in the capture
+// too, so callers should look for their lines rather than compare it all.
+func captureLog(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		read <- data
+	}()
+	stdout := os.Stdout
+	os.Stdout = w
+	func() {
+		defer func() {
+			os.Stdout = stdout
+			w.Close()
+		}()
+		fn()
+	}()
+	return string(<-read)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// HostAllowList decides which Host headers the server answers. Since
+// routing looks only at the path, without it a DNS rebinding attack can
+// point a hostile name at the server and have a victim's browser talk to
+// it as if it were that hostile site.
+type HostAllowList struct {
+	exact         map[string]bool // hostnames and normalized IPs
+	wildcards     []string        // ".example.com" for "*.example.com"
+	allowLoopback bool
+}
+
+// NewHostAllowList builds an allow-list from hostnames ("example.com"),
+// wildcard patterns ("*.example.com", matching any subdomain but not
+// example.com itself) and IP addresses. Ports in entries are ignored.
+// With allowLoopback, meant for development, "localhost" and loopback
+// IPs are allowed too.
+func NewHostAllowList(entries []string, allowLoopback bool) (*HostAllowList, error) {
+	l := &HostAllowList{exact: make(map[string]bool), allowLoopback: allowLoopback}
+	for _, entry := range entries {
+		host := normalizeHost(entry)
+		if suffix, ok := strings.CutPrefix(host, "*."); ok {
+			if suffix == "" || strings.Contains(suffix, "*") {
+				return nil, fmt.Errorf("invalid host pattern %q", entry)
+			}
+			l.wildcards = append(l.wildcards, "."+suffix)
+			continue
+		}
+		if host == "" || strings.Contains(host, "*") {
+			return nil, fmt.Errorf("invalid host %q", entry)
+		}
+		l.exact[host] = true
+	}
+	return l, nil
+}
+
+// Allows reports whether a request with the given Host header, with or
+// without a port, may be served.
+func (l *HostAllowList) Allows(host string) bool {
+	host = normalizeHost(host)
+	if host == "" {
+		return false
+	}
+	if l.exact[host] {
+		return true
+	}
+	for _, suffix := range l.wildcards {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	if l.allowLoopback {
+		if host == "localhost" {
+			return true
+		}
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns the 421 response for a request whose Host is not allowed,
+// or nil.
+func (l *HostAllowList) Check(req *Request) *Response {
+	if l.Allows(req.Headers["host"]) {
+		return nil
+	}
+	Metrics.Counter("requests_misdirected_total").Inc()
+	utils.Warn("Rejected request for host %q from %s: %s %s", req.Headers["host"], req.RemoteAddr, req.Method, req.Path)
+	resp := MisdirectedRequestResponse()
+	return &resp
+}
+
+// SetAllowedHosts makes the server answer only requests whose Host is on
+// l, with 421 Misdirected Request before routing. nil allows every host.
+func (s *Server) SetAllowedHosts(l *HostAllowList) {
+	s.allowedHosts = l
+}
+
+// checkHost applies the allowed hosts to req, see SetAllowedHosts.
+func (s *Server) checkHost(req *Request) *Response {
+	if s.allowedHosts == nil {
+		return nil
+	}
+	return s.allowedHosts.Check(req)
+}
+
+// normalizeHost lowercases host and strips its port, any IPv6 brackets
+// and a trailing dot. IP addresses are put in canonical form.
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return host
+}
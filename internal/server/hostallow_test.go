@@ -0,0 +1,115 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+)
+
+func TestHostAllowList(t *testing.T) {
+	l, err := NewHostAllowList([]string{"example.com", "*.apps.example.org", "192.0.2.10", "[2001:db8::1]:8443", "Mixed.Case.NET."}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		host string
+		want bool
+	}{
+		{"example.com", true},
+		{"EXAMPLE.com:8080", true},
+		{"example.com.", true},
+		{"www.example.com", false},
+		{"example.com.evil.test", false},
+
+		// Wildcards match any depth of subdomain, but not the parent
+		// itself nor names merely ending in the same letters.
+		{"a.apps.example.org", true},
+		{"a.b.apps.example.org:443", true},
+		{"apps.example.org", false},
+		{"evilapps.example.org", false},
+		{"example.org", false},
+
+		{"192.0.2.10", true},
+		{"192.0.2.10:80", true},
+		{"192.0.2.11", false},
+		{"[2001:db8::1]", true},
+		{"[2001:0db8:0::1]:80", true},
+		{"mixed.case.net", true},
+
+		// Loopback is not allowed outside development.
+		{"localhost", false},
+		{"127.0.0.1", false},
+		{"", false},
+		{"  ", false},
+	} {
+		if got := l.Allows(tt.host); got != tt.want {
+			t.Errorf("Allows(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestHostAllowListLoopback(t *testing.T) {
+	l, err := NewHostAllowList([]string{"example.com"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"localhost", "localhost:8080", "127.0.0.1", "127.8.0.1:80", "[::1]:8080"} {
+		if !l.Allows(host) {
+			t.Errorf("Allows(%q) = false in development", host)
+		}
+	}
+	for _, host := range []string{"localhost.evil.test", "10.0.0.1", "0.0.0.0"} {
+		if l.Allows(host) {
+			t.Errorf("Allows(%q) = true, want only loopback added", host)
+		}
+	}
+}
+
+func TestHostAllowListInvalid(t *testing.T) {
+	for _, entry := range []string{"", "*.", "*", "*.*.example.com", "a*.example.com", "www.*.example.com"} {
+		if _, err := NewHostAllowList([]string{entry}, false); err == nil {
+			t.Errorf("NewHostAllowList(%q) succeeded, want an error", entry)
+		}
+	}
+}
+
+func TestServeAllowedHosts(t *testing.T) {
+	t.Setenv("ALLOWED_HOSTS", "example.com,*.example.com")
+	_, addr := startServer(t)
+	misdirected := Metrics.Counter("requests_misdirected_total")
+
+	for _, tt := range []struct {
+		host string
+		want int
+	}{
+		{"example.com", 200},
+		{"api.example.com:8080", 200},
+		{"rebind.attacker.test", 421},
+		{"example.com.attacker.test", 421},
+		{"127.0.0.1", 421},
+	} {
+		before := misdirected.Value()
+		c := dial(t, addr)
+		resp := roundTrip(t, c, "GET", "/healthz", map[string]string{"Host": tt.host}, nil)
+		if resp.Status != tt.want {
+			t.Errorf("Host %q: status %d, want %d", tt.host, resp.Status, tt.want)
+		}
+		counted := misdirected.Value() - before
+		if tt.want == 421 && counted != 1 || tt.want != 421 && counted != 0 {
+			t.Errorf("Host %q: requests_misdirected_total grew by %v", tt.host, counted)
+		}
+	}
+
+	t.Setenv("DEV_MODE", "true")
+	_, devAddr := startServer(t)
+	c := dial(t, devAddr)
+	if resp := roundTrip(t, c, "GET", "/healthz", map[string]string{"Host": "localhost:8080"}, nil); resp.Status != 200 {
+		t.Errorf("localhost in development: status %d, want 200", resp.Status)
+	}
+
+	t.Setenv("ALLOWED_HOSTS", "*.")
+	if _, err := NewServerFromConfig(config.LoadConfig()); err == nil || !strings.Contains(err.Error(), "ALLOWED_HOSTS") {
+		t.Errorf("NewServerFromConfig = %v, want an ALLOWED_HOSTS error", err)
+	}
+}
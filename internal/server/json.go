@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// Sentinel errors wrapped by BodyError. Use errors.Is to tell a body sent
+// with the wrong Content-Type from one that could not be decoded.
+var (
+	ErrBodyContentType = errors.New("unsupported body content type")
+	ErrBodyInvalid     = errors.New("invalid body")
+)
+
+// BodyError describes a request body BindJSON could not use. Its message
+// can be returned to the client as-is in a 400 response, see
+// BadRequestErrorResponse.
+type BodyError struct {
+	ContentType string // media type the body was sent with, "" if none
+	Err         error  // ErrBodyContentType or ErrBodyInvalid
+	Reason      string
+}
+
+func (e *BodyError) Error() string {
+	if errors.Is(e.Err, ErrBodyContentType) {
+		if e.ContentType == "" {
+			return "request body has no content type, want application/json"
+		}
+		return fmt.Sprintf("request body has content type %q, want application/json", e.ContentType)
+	}
+	return "request body is not valid JSON: " + e.Reason
+}
+
+func (e *BodyError) Unwrap() error {
+	return e.Err
+}
+
+// BindJSON decodes the request body into v, which must be a pointer. The
+// body must be sent as application/json or a "+json" type such as
+// application/merge-patch+json. Any failure is a *BodyError.
+//
+// Example:
+//
+//	var user struct{ Name string }
+//	if err := req.BindJSON(&user); err != nil {
+//	    return BadRequestErrorResponse(err)
+//	}
+func (r *Request) BindJSON(v any) error {
+	mediaType, _ := r.ContentType()
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return &BodyError{ContentType: mediaType, Err: ErrBodyContentType}
+	}
+	if len(r.Body) == 0 {
+		return &BodyError{ContentType: mediaType, Err: ErrBodyInvalid, Reason: "body is empty"}
+	}
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		return &BodyError{ContentType: mediaType, Err: ErrBodyInvalid, Reason: strings.TrimPrefix(err.Error(), "json: ")}
+	}
+	return nil
+}
+
+// JSONResponse renders v as a JSON response with the given status. Only
+// the exported fields of structs are encoded. If v cannot be encoded the
+// failure is logged and a 500 returned instead.
+func JSONResponse(status int, v any) Response {
+	body, err := json.Marshal(v)
+	if err != nil {
+		utils.Error("Failed to encode JSON response: %v", err)
+		return InternalServerErrorResponse()
+	}
+	return Response{
+		Version: HTTPVersion,
+		Status:  status,
+		Reason:  reasonPhrase(status),
+		Headers: map[string]string{"Content-Type": "application/json"},
+		Body:    body,
+	}
+}
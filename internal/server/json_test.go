@@ -0,0 +1,87 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBindJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantErr     error
+		wantMessage string
+	}{
+		{name: "json", contentType: "application/json", body: `{"name":"ada","age":36}`},
+		{name: "json with charset", contentType: "Application/JSON; charset=utf-8", body: `{"name":"ada"}`},
+		{name: "+json type", contentType: "application/merge-patch+json", body: `{"name":"ada"}`},
+		{name: "no content type", body: `{}`, wantErr: ErrBodyContentType,
+			wantMessage: "request body has no content type, want application/json"},
+		{name: "wrong content type", contentType: "text/plain", body: `{}`, wantErr: ErrBodyContentType,
+			wantMessage: `request body has content type "text/plain", want application/json`},
+		{name: "empty body", contentType: "application/json", wantErr: ErrBodyInvalid,
+			wantMessage: "request body is not valid JSON: body is empty"},
+		{name: "malformed", contentType: "application/json", body: `{"name":`, wantErr: ErrBodyInvalid,
+			wantMessage: "request body is not valid JSON: unexpected end of JSON input"},
+		{name: "wrong type", contentType: "application/json", body: `{"age":"old"}`, wantErr: ErrBodyInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Request{Headers: map[string]string{}, Body: []byte(tt.body)}
+			if tt.contentType != "" {
+				req.Headers["content-type"] = tt.contentType
+			}
+			var v struct {
+				Name string `json:"name"`
+				Age  int    `json:"age"`
+			}
+			err := req.BindJSON(&v)
+			if tt.wantErr == nil {
+				if err != nil || v.Name != "ada" {
+					t.Errorf("BindJSON = %v, decoded %+v", err, v)
+				}
+				return
+			}
+			var bodyErr *BodyError
+			if !errors.Is(err, tt.wantErr) || !errors.As(err, &bodyErr) {
+				t.Fatalf("BindJSON = %v, want a *BodyError wrapping %v", err, tt.wantErr)
+			}
+			if tt.wantMessage != "" && err.Error() != tt.wantMessage {
+				t.Errorf("message = %q, want %q", err.Error(), tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestJSONResponse(t *testing.T) {
+	resp := JSONResponse(201, map[string]any{"id": 7, "tags": []string{"a"}})
+	if resp.Status != 201 || resp.Reason != "Created" {
+		t.Errorf("status = %d %s, want 201 Created", resp.Status, resp.Reason)
+	}
+	if got := resp.Headers["Content-Type"]; got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got, want := string(resp.Body), `{"id":7,"tags":["a"]}`; got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+
+	if resp := JSONResponse(200, func() {}); resp.Status != 500 {
+		t.Errorf("unencodable value: status = %d, want 500", resp.Status)
+	}
+}
+
+func TestUserByIDAnswersJSON(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+
+	resp := roundTrip(t, c, "GET", "/user/42", keepAlive, nil)
+	if resp.Status != 200 || string(resp.Body) != `{"id":42}` || !strings.HasPrefix(resp.Header("Content-Type"), "application/json") {
+		t.Errorf("GET /user/42 = %d %q (%s), want 200 {\"id\":42}", resp.Status, resp.Body, resp.Header("Content-Type"))
+	}
+	if resp := roundTrip(t, c, "GET", "/user/abc", keepAlive, nil); resp.Status != 400 {
+		t.Errorf("GET /user/abc = %d, want 400", resp.Status)
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// PriorityLane keeps a few requests, such as load balancer health checks
+// and admin calls, answering while the server sheds load. Requests it
+// matches skip the global rate limit (RateLimitMiddleware) and, when
+// their route's concurrency limit is reached (see Route.MaxConcurrent),
+// run in slots reserved for the lane instead of being turned away.
+//
+// The lane is bounded so it cannot become a way around the limits: it
+// admits at most rate requests per second, and runs at most capacity of
+// them past full routes at once. Requests over either budget are handled
+// like any other request, and shed like them. Route rate limits, per-IP
+// connection limits, draining and maintenance mode apply to the lane too.
+type PriorityLane struct {
+	match   RequestPredicate
+	slots   *bulkhead
+	limiter *RateLimiter
+}
+
+// NewPriorityLane creates a lane for the requests matching match, with
+// capacity reserved slots, admitting at most rate requests per second.
+//
+// Example:
+//
+//	lane := server.NewPriorityLane(server.Or(server.PathPrefix("/healthz"), server.FromLoopback()), 4, 20)
+//	router.SetPriorityLane(lane)
+func NewPriorityLane(match RequestPredicate, capacity, rate int) *PriorityLane {
+	return &PriorityLane{
+		match: match,
+		slots: &bulkhead{
+			slots:    make(chan struct{}, max(capacity, 0)),
+			policy:   BulkheadReject,
+			inFlight: Metrics.Gauge("priority_lane_in_flight"),
+		},
+		limiter: NewRateLimiter(0, max(rate, 1), time.Second),
+	}
+}
+
+// SetPriorityLane makes the router give lane's requests priority. Nil
+// removes the lane.
+func (r *Router) SetPriorityLane(lane *PriorityLane) {
+	r.lane = lane
+}
+
+// admit reports whether req travels in the lane, charging it to the
+// lane's rate budget.
+func (l *PriorityLane) admit(req *Request) bool {
+	if !l.match(req) {
+		return false
+	}
+	if !l.limiter.Allow("").Allowed {
+		Metrics.Counter("priority_lane_throttled_total").Inc()
+		utils.Debug("Priority lane over its rate, handling normally: %s %s", req.Method, req.Path)
+		return false
+	}
+	Metrics.Counter("priority_lane_requests_total").Inc()
+	return true
+}
+
+// overflow returns the lane's slots, with one taken, for a priority
+// request whose route is saturated, or nil if req is not in the lane or
+// the lane is full too.
+func (r *Router) overflow(req *Request) *bulkhead {
+	if !req.priority || r.lane == nil {
+		return nil
+	}
+	if r.lane.slots.acquire("") != nil {
+		Metrics.Counter("priority_lane_full_total").Inc()
+		return nil
+	}
+	Metrics.Counter("priority_lane_bulkhead_bypassed_total").Inc()
+	return r.lane.slots
+}
+
+// priorityLane builds the lane described by the PRIORITY_LANE_* settings,
+// or returns nil if they select no requests.
+func priorityLane(cfg *config.Config) *PriorityLane {
+	var preds []RequestPredicate
+	for _, prefix := range cfg.PriorityLanePaths {
+		preds = append(preds, PathPrefix(prefix))
+	}
+	if cfg.PriorityLaneLoopback {
+		preds = append(preds, FromLoopback())
+	}
+	if len(preds) == 0 {
+		return nil
+	}
+	return NewPriorityLane(Or(preds...), cfg.PriorityLaneCapacity, cfg.PriorityLaneRate)
+}
+
+// FromLoopback matches requests whose peer, the address the connection
+// comes from rather than any forwarded client address, is a loopback
+// address.
+func FromLoopback() RequestPredicate {
+	return func(req *Request) bool {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	}
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+)
+
+// isProbe matches requests carrying "X-Probe: 1", standing in for health
+// checks.
+func isProbe(req *Request) bool { return req.Headers["x-probe"] == "1" }
+
+// routeAsync routes a GET of target on r in its own goroutine, as a probe
+// if asked to, and returns where its response will arrive.
+func routeAsync(r *Router, target string, asProbe bool) <-chan Response {
+	req := &Request{Method: "GET", Headers: map[string]string{}, RemoteAddr: "192.0.2.1:4000"}
+	if asProbe {
+		req.Headers["x-probe"] = "1"
+	}
+	req.setTarget(target)
+	done := make(chan Response, 1)
+	go func() { done <- r.Route(req) }()
+	return done
+}
+
+func TestPriorityLaneSaturation(t *testing.T) {
+	work, entered, release := blockingHandler("done")
+	r := NewRouter()
+	r.Handle("/work", "GET", work).MaxConcurrent(1)
+	r.SetPriorityLane(NewPriorityLane(isProbe, 2, 100))
+	laneInFlight := Metrics.Gauge("priority_lane_in_flight")
+	bypassed := Metrics.Counter("priority_lane_bulkhead_bypassed_total")
+	full := Metrics.Counter("priority_lane_full_total")
+	bypassedBefore, fullBefore := bypassed.Value(), full.Value()
+
+	// One ordinary request fills the route.
+	held := []<-chan Response{routeAsync(r, "/work", false)}
+	<-entered
+	if resp := <-routeAsync(r, "/work", false); resp.Status != 503 {
+		t.Fatalf("ordinary request to the full route = %d, want 503", resp.Status)
+	}
+
+	// Probes run past it in the lane's two slots...
+	for range 2 {
+		held = append(held, routeAsync(r, "/work", true))
+		<-entered
+	}
+	if n := laneInFlight.Value(); n != 2 {
+		t.Errorf("priority_lane_in_flight = %d, want 2", n)
+	}
+	if got := bypassed.Value() - bypassedBefore; got != 2 {
+		t.Errorf("priority_lane_bulkhead_bypassed_total grew by %v, want 2", got)
+	}
+
+	// ...and once those are taken too, a probe is shed like anything else.
+	if resp := <-routeAsync(r, "/work", true); resp.Status != 503 {
+		t.Errorf("probe with the lane full = %d, want 503", resp.Status)
+	}
+	if got := full.Value() - fullBefore; got != 1 {
+		t.Errorf("priority_lane_full_total grew by %v, want 1", got)
+	}
+	if resp := <-routeAsync(r, "/work", false); resp.Status != 503 {
+		t.Errorf("ordinary request with the lane full = %d, want 503", resp.Status)
+	}
+
+	close(release)
+	for _, done := range held {
+		if resp := <-done; resp.Status != 200 {
+			t.Errorf("held request = %d, want 200", resp.Status)
+		}
+	}
+	if n := laneInFlight.Value(); n != 0 {
+		t.Errorf("priority_lane_in_flight after the release = %d, want 0", n)
+	}
+	if resp := <-routeAsync(r, "/work", false); resp.Status != 200 {
+		t.Errorf("ordinary request after the release = %d, want 200", resp.Status)
+	}
+}
+
+func TestPriorityLaneRate(t *testing.T) {
+	work, entered, release := blockingHandler("done")
+	defer close(release)
+	r := NewRouter()
+	r.Handle("/work", "GET", work).MaxConcurrent(1)
+	r.SetPriorityLane(NewPriorityLane(isProbe, 10, 2))
+	throttled := Metrics.Counter("priority_lane_throttled_total")
+	before := throttled.Value()
+
+	routeAsync(r, "/work", false)
+	<-entered
+
+	// The lane has slots to spare but admits two probes a second: the
+	// third is handled, and shed, like an ordinary request.
+	for range 2 {
+		routeAsync(r, "/work", true)
+		<-entered
+	}
+	if resp := <-routeAsync(r, "/work", true); resp.Status != 503 {
+		t.Errorf("probe over the lane's rate = %d, want 503", resp.Status)
+	}
+	if got := throttled.Value() - before; got != 1 {
+		t.Errorf("priority_lane_throttled_total grew by %v, want 1", got)
+	}
+}
+
+func TestPriorityLaneSkipsRateLimit(t *testing.T) {
+	r := NewRouter()
+	r.UseBeforeBody(RateLimitMiddleware(NewRateLimiter(0, 1, time.Minute)))
+	r.Handle("/work", "GET", func(*Request) Response { return textResponse("done") })
+	r.SetPriorityLane(NewPriorityLane(isProbe, 1, 3))
+
+	if resp := <-routeAsync(r, "/work", false); resp.Status != 200 {
+		t.Fatalf("first request = %d, want 200", resp.Status)
+	}
+	if resp := <-routeAsync(r, "/work", false); resp.Status != 429 {
+		t.Errorf("ordinary request over RATE_LIMIT_GLOBAL = %d, want 429", resp.Status)
+	}
+	for i := range 3 {
+		if resp := <-routeAsync(r, "/work", true); resp.Status != 200 {
+			t.Errorf("probe %d over RATE_LIMIT_GLOBAL = %d, want 200", i+1, resp.Status)
+		}
+	}
+	if resp := <-routeAsync(r, "/work", true); resp.Status != 429 {
+		t.Errorf("probe over the lane's rate = %d, want 429", resp.Status)
+	}
+}
+
+func TestPriorityLaneFromConfig(t *testing.T) {
+	if lane := priorityLane(&config.Config{PriorityLaneCapacity: 4, PriorityLaneRate: 20}); lane != nil {
+		t.Error("lane built with no paths and no loopback, want none")
+	}
+
+	lane := priorityLane(&config.Config{
+		PriorityLanePaths:    []string{"/healthz", "/admin/"},
+		PriorityLaneCapacity: 4,
+		PriorityLaneRate:     20,
+	})
+	if lane == nil {
+		t.Fatal("no lane built for PRIORITY_LANE_PATHS")
+	}
+	if got := cap(lane.slots.slots); got != 4 {
+		t.Errorf("lane capacity = %d, want 4", got)
+	}
+	for _, tt := range []struct {
+		path, addr string
+		want       bool
+	}{
+		{"/healthz", "192.0.2.1:4000", true},
+		{"/admin", "192.0.2.1:4000", true},
+		{"/admin/stats", "192.0.2.1:4000", true},
+		{"/healthzz", "192.0.2.1:4000", false},
+		{"/work", "127.0.0.1:4000", false},
+	} {
+		req := &Request{Method: "GET", Headers: map[string]string{}, RemoteAddr: tt.addr}
+		req.setTarget(tt.path)
+		if got := lane.match(req); got != tt.want {
+			t.Errorf("lane matches %s from %s = %v, want %v", tt.path, tt.addr, got, tt.want)
+		}
+	}
+
+	lane = priorityLane(&config.Config{PriorityLaneLoopback: true, PriorityLaneCapacity: 1, PriorityLaneRate: 1})
+	req := &Request{Method: "GET", Headers: map[string]string{}, RemoteAddr: "127.0.0.1:4000"}
+	req.setTarget("/work")
+	if lane == nil || !lane.match(req) {
+		t.Error("PRIORITY_LANE_LOOPBACK lane does not match a loopback peer")
+	}
+}
+
+func TestFromLoopback(t *testing.T) {
+	for _, tt := range []struct {
+		addr string
+		want bool
+	}{
+		{"127.0.0.1:4000", true},
+		{"127.8.9.10:4000", true},
+		{"[::1]:4000", true},
+		{"::1", true},
+		{"192.0.2.1:4000", false},
+		{"[2001:db8::1]:4000", false},
+		{"localhost:4000", false},
+		{"", false},
+	} {
+		req := &Request{RemoteAddr: tt.addr, Headers: map[string]string{"x-forwarded-for": "127.0.0.1"}}
+		if got := FromLoopback()(req); got != tt.want {
+			t.Errorf("FromLoopback() for peer %q = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
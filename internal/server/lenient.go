@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// SetLenientParsing turns lenient request parsing on or off for requests
+// read from now on. It is off by default, see LENIENT_PARSING.
+//
+// Lenient parsing accepts requests from sloppy clients, such as embedded
+// devices, that strict parsing refuses with 400. It tolerates:
+//
+//   - bare LF line terminators instead of CRLF;
+//   - runs of spaces or tabs in the request line, and a missing space
+//     before the version ("GET /pathHTTP/1.1");
+//   - lowercase or mixed-case methods, which are uppercased;
+//   - whitespace between a header name and its colon, and stray
+//     whitespace or control characters around header lines and values.
+//
+// Every tolerated deviation is counted in a lenient_parse_*_total metric,
+// so the offending clients can be found and fixed. Framing checks are
+// never relaxed: conflicting Content-Length and Transfer-Encoding headers,
+// differing duplicate Content-Length headers and control characters inside
+// a header value are fatal in both modes.
+func (s *Server) SetLenientParsing(enabled bool) {
+	s.lenientParsing.Store(enabled)
+}
+
+// errBareLF is returned by strict parsing for a head line ending in a bare
+// LF.
+var errBareLF = errors.New("line not terminated by CRLF")
+
+// headOptions controls how readRequestHead parses a request head.
+type headOptions struct {
+	captureRaw bool // record RawRequestLine and RawHeaders
+	lenient    bool // apply the lenient parsing tolerances
+}
+
+// readHeadLine reads one line of the request head and strips its
+// terminator, which must be CRLF unless parsing is lenient. It also
+// returns the line as read, for raw capture.
+func readHeadLine(reader *bufio.Reader, lenient bool) (string, string, error) {
+	raw, err := reader.ReadString('\n')
+	if err != nil {
+		return "", raw, err
+	}
+	if line, ok := strings.CutSuffix(raw, "\r\n"); ok {
+		return line, raw, nil
+	}
+	if !lenient {
+		return "", raw, errBareLF
+	}
+	Metrics.Counter("lenient_parse_bare_lf_total").Inc()
+	return strings.TrimSuffix(raw, "\n"), raw, nil
+}
+
+// parseRequestLine splits a request line into method, target and version.
+// Strict parsing requires exactly one space between the three.
+func parseRequestLine(line string, lenient bool) (string, string, string, error) {
+	if !lenient {
+		parts := strings.Split(line, " ")
+		if len(parts) != 3 {
+			return "", "", "", fmt.Errorf("malformed request line: %s", line)
+		}
+		return parts[0], parts[1], parts[2], nil
+	}
+
+	parts := strings.Fields(line)
+	if strings.Join(parts, " ") != line {
+		Metrics.Counter("lenient_parse_whitespace_total").Inc()
+	}
+	if len(parts) == 2 {
+		if i := strings.LastIndex(strings.ToUpper(parts[1]), "HTTP/"); i > 0 {
+			Metrics.Counter("lenient_parse_request_line_total").Inc()
+			parts = []string{parts[0], parts[1][:i], parts[1][i:]}
+		}
+	}
+	if len(parts) != 3 {
+		return "", "", "", fmt.Errorf("malformed request line: %s", line)
+	}
+	method, version := parts[0], parts[2]
+	if upper := strings.ToUpper(method); upper != method && isToken(method) {
+		Metrics.Counter("lenient_parse_method_case_total").Inc()
+		method = upper
+	}
+	if upper := strings.ToUpper(version); upper != version {
+		Metrics.Counter("lenient_parse_request_line_total").Inc()
+		version = upper
+	}
+	return method, parts[1], version, nil
+}
+
+// parseHeaderLine splits a header line into its lowercased name and its
+// value with optional whitespace trimmed. ok is false for a line without
+// a colon, which the caller skips.
+func parseHeaderLine(line string, lenient bool) (key, value string, ok bool, err error) {
+	if lenient {
+		if trimmed := strings.TrimFunc(line, isSpaceOrControl); trimmed != line {
+			Metrics.Counter("lenient_parse_whitespace_total").Inc()
+			line = trimmed
+		}
+	} else if line[0] == ' ' || line[0] == '\t' {
+		return "", "", false, fmt.Errorf("obsolete line folding in header")
+	}
+
+	name, value, found := strings.Cut(line, ":")
+	if !found {
+		return "", "", false, nil
+	}
+	if trimmed := strings.TrimRight(name, " \t"); trimmed != name {
+		if !lenient {
+			return "", "", false, fmt.Errorf("whitespace before colon in header %q", trimmed)
+		}
+		Metrics.Counter("lenient_parse_whitespace_total").Inc()
+		name = trimmed
+	}
+
+	value = strings.Trim(value, " \t")
+	if lenient {
+		if trimmed := strings.TrimFunc(value, isSpaceOrControl); trimmed != value {
+			Metrics.Counter("lenient_parse_whitespace_total").Inc()
+			value = trimmed
+		}
+	}
+	if hasControlChar(value) {
+		return "", "", false, fmt.Errorf("control character in header %q", name)
+	}
+	return strings.ToLower(name), value, true, nil
+}
+
+// checkFraming refuses heads whose body length is ambiguous, a request
+// smuggling vector, and transfer codings other than chunked, whose
+// bodies could not be delimited. It applies in both parsing modes.
+func checkFraming(req *Request) error {
+	_, hasLength := req.Headers["content-length"]
+	encoding, hasEncoding := req.Headers["transfer-encoding"]
+	if hasEncoding && hasLength {
+		return NewHTTPError(400, "both Transfer-Encoding and Content-Length are set")
+	}
+	if hasEncoding && !req.isChunked() {
+		return NewHTTPError(501, fmt.Sprintf("unsupported Transfer-Encoding %q", encoding))
+	}
+	return nil
+}
+
+func isSpaceOrControl(r rune) bool {
+	return unicode.IsSpace(r) || unicode.IsControl(r)
+}
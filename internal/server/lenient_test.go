@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestLenientParsing(t *testing.T) {
+	tests := []struct {
+		name   string
+		head   string
+		metric string // counter the tolerance is counted in
+		method string
+		path   string
+		header string // "name: value" the lenient parse must produce
+		// verbatim is set when strict parsing reads the head too, but
+		// leaves the method or version as sent for routing to refuse.
+		verbatim bool
+	}{
+		{
+			name:   "bare LF",
+			head:   "GET /a HTTP/1.1\nHost: x\n\n",
+			metric: "lenient_parse_bare_lf_total",
+			method: "GET", path: "/a", header: "host: x",
+		},
+		{
+			name:   "bare LF after headers only",
+			head:   "GET /a HTTP/1.1\r\nHost: x\n\r\n",
+			metric: "lenient_parse_bare_lf_total",
+			method: "GET", path: "/a", header: "host: x",
+		},
+		{
+			name:   "runs of spaces in the request line",
+			head:   "GET  /a \tHTTP/1.1\r\nHost: x\r\n\r\n",
+			metric: "lenient_parse_whitespace_total",
+			method: "GET", path: "/a", header: "host: x",
+		},
+		{
+			name:   "no space before the version",
+			head:   "GET /aHTTP/1.1\r\nHost: x\r\n\r\n",
+			metric: "lenient_parse_request_line_total",
+			method: "GET", path: "/a", header: "host: x",
+		},
+		{
+			name:   "lowercase version",
+			head:   "GET /a http/1.1\r\nHost: x\r\n\r\n",
+			metric: "lenient_parse_request_line_total",
+			method: "GET", path: "/a", header: "host: x",
+			verbatim: true,
+		},
+		{
+			name:   "lowercase method",
+			head:   "get /a HTTP/1.1\r\nHost: x\r\n\r\n",
+			metric: "lenient_parse_method_case_total",
+			method: "GET", path: "/a", header: "host: x",
+			verbatim: true,
+		},
+		{
+			name:   "mixed-case method",
+			head:   "Post /a HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\n\r\n",
+			metric: "lenient_parse_method_case_total",
+			method: "POST", path: "/a", header: "host: x",
+			verbatim: true,
+		},
+		{
+			name:   "whitespace before the colon",
+			head:   "GET /a HTTP/1.1\r\nHost : x\r\n\r\n",
+			metric: "lenient_parse_whitespace_total",
+			method: "GET", path: "/a", header: "host: x",
+		},
+		{
+			name:   "leading whitespace on a header line",
+			head:   "GET /a HTTP/1.1\r\n Host: x\r\n\r\n",
+			metric: "lenient_parse_whitespace_total",
+			method: "GET", path: "/a", header: "host: x",
+		},
+		{
+			name:   "trailing control character on a value",
+			head:   "GET /a HTTP/1.1\r\nHost: x\x00\r\n\r\n",
+			metric: "lenient_parse_whitespace_total",
+			method: "GET", path: "/a", header: "host: x",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := readRequestHead(bufio.NewReader(strings.NewReader(tt.head)), headOptions{})
+			switch {
+			case tt.verbatim && err != nil:
+				t.Errorf("strict parsing: %v", err)
+			case tt.verbatim && req.Method == tt.method && req.Version == HTTPVersion:
+				t.Errorf("strict parsing normalized the request line to %s %s", req.Method, req.Version)
+			case !tt.verbatim && err == nil:
+				t.Errorf("strict parsing accepted the head: %s %s %v", req.Method, req.Path, req.Headers)
+			}
+
+			counter := Metrics.Counter(tt.metric)
+			before := counter.Value()
+			req, err = readRequestHead(bufio.NewReader(strings.NewReader(tt.head)), headOptions{lenient: true})
+			if err != nil {
+				t.Fatalf("lenient parsing: %v", err)
+			}
+			if req.Method != tt.method || req.Path != tt.path || req.Version != HTTPVersion {
+				t.Errorf("request line = %q %q %q, want %q %q %q", req.Method, req.Path, req.Version, tt.method, tt.path, HTTPVersion)
+			}
+			name, value, _ := strings.Cut(tt.header, ": ")
+			if got, ok := req.Headers[name]; !ok || got != value {
+				t.Errorf("header %q = %q, want %q (headers %v)", name, got, value, req.Headers)
+			}
+			if counter.Value() == before {
+				t.Errorf("%s not incremented", tt.metric)
+			}
+		})
+	}
+}
+
+func TestLenientParsingKeepsWellFormedRequests(t *testing.T) {
+	head := "GET /a HTTP/1.1\r\nHost: x\r\nAccept: */*\r\n\r\n"
+	for _, lenient := range []bool{false, true} {
+		req, err := readRequestHead(bufio.NewReader(strings.NewReader(head)), headOptions{lenient: lenient})
+		if err != nil {
+			t.Fatalf("lenient=%v: %v", lenient, err)
+		}
+		if req.Method != "GET" || req.Path != "/a" || req.Headers["accept"] != "*/*" {
+			t.Errorf("lenient=%v: parsed %s %s %v", lenient, req.Method, req.Path, req.Headers)
+		}
+	}
+}
+
+func TestLenientParsingKeepsFramingChecks(t *testing.T) {
+	for _, head := range []string{
+		"POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n",
+		"POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\nContent-Length: 4\r\n\r\n",
+		"POST /a HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: gzip\r\n\r\n",
+		"GET /a HTTP/1.1\r\nHost: x\r\nX-Smuggle: a\x00b\r\n\r\n",
+		"GET /a HTTP/1.1\nHost: x\nContent-Length: 3\nContent-Length: 4\n\n",
+	} {
+		for _, lenient := range []bool{false, true} {
+			if _, err := readRequestHead(bufio.NewReader(strings.NewReader(head)), headOptions{lenient: lenient}); err == nil {
+				t.Errorf("lenient=%v accepted %q", lenient, head)
+			}
+		}
+	}
+}
+
+func TestServeLenientParsing(t *testing.T) {
+	sloppy := "get /healthz HTTP/1.1\nHost: x\n\n"
+
+	_, strict := startServer(t)
+	c := dial(t, strict)
+	if err := c.SendRaw([]byte(sloppy)); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := c.ReadResponse(); err != nil || resp.Status != 400 {
+		t.Errorf("strict server: %v %v, want 400", resp, err)
+	}
+
+	t.Setenv("LENIENT_PARSING", "true")
+	_, lenient := startServer(t)
+	c = dial(t, lenient)
+	if err := c.SendRaw([]byte(sloppy)); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := c.ReadResponse(); err != nil || resp.Status != 200 {
+		t.Errorf("lenient server: %v %v, want 200", resp, err)
+	}
+}
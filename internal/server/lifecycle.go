@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/clock"
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// State is a stage in the server lifecycle, reported by the probe routes.
+type State int32
+
+const (
+	StateStarting State = iota
+	StateWarming
+	StateReady
+	StateDraining
+	StateStopped
+	// StateDegraded is reported by /readyz while the server is ready but
+	// a static root is unavailable or low on space, see FSProbe.
+	StateDegraded
+)
+
+func (st State) String() string {
+	switch st {
+	case StateStarting:
+		return "starting"
+	case StateWarming:
+		return "warming"
+	case StateReady:
+		return "ready"
+	case StateDraining:
+		return "draining"
+	case StateStopped:
+		return "stopped"
+	case StateDegraded:
+		return "degraded"
+	default:
+		return fmt.Sprintf("state(%d)", int32(st))
+	}
+}
+
+// WarmUpFunc prepares the server before it reports ready, e.g. priming a
+// cache. It should honor ctx, which is cancelled at the warm-up timeout.
+type WarmUpFunc func(ctx context.Context) error
+
+// warmUp is a registered warm-up function and its name for logging.
+type warmUp struct {
+	name string
+	fn   WarmUpFunc
+}
+
+// OnWarmUp registers a function that must finish before the server reports
+// ready. All warm-up functions run concurrently once the listener is open,
+// bounded by WARMUP_TIMEOUT.
+func (s *Server) OnWarmUp(name string, fn WarmUpFunc) {
+	s.warmUps = append(s.warmUps, warmUp{name: name, fn: fn})
+}
+
+// State returns the current lifecycle state.
+func (s *Server) State() State {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.state
+}
+
+// TimeInState returns how long the server has been in its current state.
+func (s *Server) TimeInState() time.Duration {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return clock.Since(s.clock, s.stateSince)
+}
+
+// setState moves the server to st. Transitions out of StateStopped and
+// backwards from StateDraining are ignored.
+func (s *Server) setState(st State) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	if s.state == st || s.state == StateStopped || (s.state == StateDraining && st != StateStopped) {
+		return
+	}
+	utils.Info("Server state: %s -> %s", s.state, st)
+	s.state = st
+	s.stateSince = s.clock.Now()
+
+	Metrics.Gauge("server_state").Set(int64(st))
+	Metrics.Gauge("server_state_since_seconds").Set(s.stateSince.Unix())
+}
+
+// runWarmUps executes the registered warm-up functions concurrently and
+// flips the server to ready once they finish.
+//
+// Returns:
+//   - error: The first failure if WARMUP_FAILURE is "fatal", otherwise nil.
+//     Non-fatal failures are logged and the server still becomes ready.
+func (s *Server) runWarmUps() error {
+	if len(s.warmUps) == 0 {
+		s.setState(StateReady)
+		return nil
+	}
+
+	s.setState(StateWarming)
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.WarmUpTimeout)
+	defer cancel()
+
+	errs := make([]error, len(s.warmUps))
+	var wg sync.WaitGroup
+	for i, w := range s.warmUps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			done := make(chan error, 1)
+			go func() { done <- w.fn(ctx) }()
+
+			select {
+			case err := <-done:
+				errs[i] = err
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+			}
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("warm-up %s failed: %w", w.name, errs[i])
+				return
+			}
+			utils.Info("Warm-up %s completed in %v", w.name, time.Since(start))
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if s.config.WarmUpFailFatal {
+			return err
+		}
+		utils.Warn("%v", err)
+	}
+
+	s.setState(StateReady)
+	return nil
+}
+
+// handleHealthz handles "/healthz": 200 unless the server has stopped.
+func (s *Server) handleHealthz(req *Request) Response {
+	st := s.State()
+	if st == StateStopped {
+		return probeResponse(503, "Service Unavailable", st)
+	}
+	return probeResponse(200, "OK", st)
+}
+
+// handleReadyz handles "/readyz": 200 only while the server is ready and
+// its static root is available and not low on space.
+func (s *Server) handleReadyz(req *Request) Response {
+	st := s.State()
+	if st == StateReady && s.filesProbe != nil && (!s.filesProbe.Healthy() || s.filesProbe.LowSpace()) {
+		st = StateDegraded
+	}
+	if st != StateReady {
+		return probeResponse(503, "Service Unavailable", st)
+	}
+	return probeResponse(200, "OK", st)
+}
+
+// probeResponse builds a plain-text probe response naming the state.
+func probeResponse(status int, reason string, st State) Response {
+	return Response{
+		Version: HTTPVersion,
+		Status:  status,
+		Reason:  reason,
+		Headers: map[string]string{
+			"Content-Type":  "text/plain",
+			"Cache-Control": "no-store",
+		},
+		Body: []byte(st.String()),
+	}
+}
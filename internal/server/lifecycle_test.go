@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// probe returns the status and body of GET path on a new connection.
+func probe(t *testing.T, addr, path string) (int, string) {
+	t.Helper()
+	resp := roundTrip(t, dial(t, addr), "GET", path, nil, nil)
+	return resp.Status, string(resp.Body)
+}
+
+func TestWarmUpGatesReadiness(t *testing.T) {
+	srv, err := NewServerFromConfig(loadConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	fastDone := make(chan struct{})
+	srv.OnWarmUp("slow", func(ctx context.Context) error {
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	srv.OnWarmUp("fast", func(context.Context) error {
+		close(fastDone)
+		return nil
+	})
+	addr := serve(t, srv)
+
+	<-fastDone
+	if status, body := probe(t, addr, "/readyz"); status != 503 || body != "warming" {
+		t.Errorf("/readyz while warming = %d %q, want 503 warming", status, body)
+	}
+	if status, _ := probe(t, addr, "/healthz"); status != 200 {
+		t.Errorf("/healthz while warming = %d, want 200", status)
+	}
+	if got := Metrics.Gauge("server_state").Value(); got != int64(StateWarming) {
+		t.Errorf("server_state = %d, want %d", got, StateWarming)
+	}
+
+	close(release)
+	waitFor(t, "readiness", func() bool { return srv.State() == StateReady })
+	if status, body := probe(t, addr, "/readyz"); status != 200 || body != "ready" {
+		t.Errorf("/readyz after warm-up = %d %q, want 200 ready", status, body)
+	}
+	if got := Metrics.Gauge("server_state").Value(); got != int64(StateReady) {
+		t.Errorf("server_state = %d, want %d", got, StateReady)
+	}
+}
+
+func TestWarmUpFailures(t *testing.T) {
+	// The hung warm-ups ignore their context; they are let go at the end.
+	hung := make(chan struct{})
+	t.Cleanup(func() { close(hung) })
+	for _, tt := range []struct {
+		name  string
+		fatal bool
+		fn    WarmUpFunc
+	}{
+		{"error, logged", false, func(context.Context) error { return errors.New("cache unavailable") }},
+		{"timeout, logged", false, func(context.Context) error { <-hung; return nil }},
+		{"error, fatal", true, func(context.Context) error { return errors.New("cache unavailable") }},
+		{"timeout, fatal", true, func(context.Context) error { <-hung; return nil }},
+	} {
+		cfg := loadConfig()
+		cfg.WarmUpTimeout = 50 * time.Millisecond
+		cfg.WarmUpFailFatal = tt.fatal
+		srv, err := NewServerFromConfig(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv.OnWarmUp("cache", tt.fn)
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		served := make(chan error, 1)
+		go func() { served <- srv.Serve(listener) }()
+
+		if tt.fatal {
+			select {
+			case err := <-served:
+				if err == nil || !strings.Contains(err.Error(), "warm-up cache failed") {
+					t.Errorf("%s: Serve = %v, want the warm-up failure", tt.name, err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: Serve still running after a fatal warm-up failure", tt.name)
+			}
+			if st := srv.State(); st == StateReady {
+				t.Errorf("%s: state = %v, want the server never ready", tt.name, st)
+			}
+			continue
+		}
+		waitFor(t, tt.name+" readiness", func() bool { return srv.State() == StateReady })
+		listener.Close()
+		if err := <-served; err != nil {
+			t.Errorf("%s: Serve = %v", tt.name, err)
+		}
+	}
+}
+
+func TestLifecycleDrainAndStop(t *testing.T) {
+	srv, addr := startServer(t)
+	waitFor(t, "readiness", func() bool { return srv.State() == StateReady })
+	c := dial(t, addr)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+	roundTrip(t, c, "GET", "/", keepAlive, nil)
+
+	srv.BeginDrain()
+	if resp := srv.handleReadyz(&Request{}); resp.Status != 503 || string(resp.Body) != "draining" {
+		t.Errorf("/readyz while draining = %d %q, want 503 draining", resp.Status, resp.Body)
+	}
+	if resp := srv.handleHealthz(&Request{}); resp.Status != 200 {
+		t.Errorf("/healthz while draining = %d, want 200", resp.Status)
+	}
+	srv.setState(StateReady)
+	if st := srv.State(); st != StateDraining {
+		t.Errorf("state after a backward transition = %v, want draining", st)
+	}
+
+	c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(ctx, TriggerAdmin)
+	if st := srv.State(); st != StateStopped {
+		t.Errorf("state after shutdown = %v, want stopped", st)
+	}
+	if status := srv.handleHealthz(&Request{}).Status; status != 503 {
+		t.Errorf("/healthz once stopped = %d, want 503", status)
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// AddLink adds an RFC 8288 link to rel at target to res's Link header,
+// joining it to the links already there with a comma. rel and the values
+// of params are sent as quoted strings; params whose name is not a token,
+// or whose value cannot be quoted, are left out. Characters target may not
+// carry inside "<...>" are percent-encoded.
+//
+// Example:
+//
+//	AddLink(&resp, "next", "/items?offset=20", map[string]string{"title": `Page "2"`})
+//	// Link: </items?offset=20>; rel="next"; title="Page \"2\""
+func AddLink(res *Response, rel, target string, params map[string]string) {
+	var b strings.Builder
+	b.WriteString("<" + escapeLinkTarget(target) + ">; rel=" + quoteLinkParam(rel))
+	for _, name := range slices.Sorted(maps.Keys(params)) {
+		value := params[name]
+		if !isToken(name) || strings.EqualFold(name, "rel") || validateHeaderValue(value) != nil {
+			utils.Warn("Dropping Link parameter %q=%q for %s", name, value, target)
+			continue
+		}
+		b.WriteString("; " + strings.ToLower(name) + "=" + quoteLinkParam(value))
+	}
+
+	if res.Headers == nil {
+		res.Headers = map[string]string{}
+	}
+	if existing := res.Headers["Link"]; existing != "" {
+		res.Headers["Link"] = existing + ", " + b.String()
+	} else {
+		res.Headers["Link"] = b.String()
+	}
+}
+
+// quoteLinkParam returns s as an RFC 9110 quoted-string.
+func quoteLinkParam(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// escapeLinkTarget percent-encodes the bytes of target that would end or
+// break the "<...>" holding it: controls, spaces, angle brackets and
+// non-ASCII.
+func escapeLinkTarget(target string) string {
+	var b strings.Builder
+	for i := 0; i < len(target); i++ {
+		c := target[i]
+		if c <= ' ' || c >= 0x7f || c == '<' || c == '>' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// Pagination describes the page of a collection a response carries: Limit
+// items from Offset, out of Total. A Limit of 0 means the whole
+// collection.
+type Pagination struct {
+	Total  int
+	Offset int
+	Limit  int
+}
+
+// ParsePagination reads the offset and limit query parameters of req.
+// limit defaults to defaultLimit and is capped at maxLimit, unless that is
+// 0. A defaultLimit of 0 leaves the collection unpaged unless the client
+// asks for pages, and lets it ask for everything with limit=0. Total is
+// left for the caller to fill in.
+func ParsePagination(req *Request, defaultLimit, maxLimit int) (Pagination, error) {
+	offset, err := req.QueryInt("offset", 0)
+	if err != nil {
+		return Pagination{}, err
+	}
+	limit, err := req.QueryInt("limit", defaultLimit)
+	if err != nil {
+		return Pagination{}, err
+	}
+	if offset < 0 {
+		return Pagination{}, &ParamError{Source: "query", Name: "offset", Value: strconv.Itoa(offset), Err: ErrParamInvalid, Reason: "must be at least 0"}
+	}
+	if limit < 1 && (limit != 0 || defaultLimit != 0) {
+		return Pagination{}, &ParamError{Source: "query", Name: "limit", Value: strconv.Itoa(limit), Err: ErrParamInvalid, Reason: "must be at least 1"}
+	}
+	if maxLimit > 0 {
+		limit = min(limit, maxLimit)
+	}
+	return Pagination{Offset: offset, Limit: limit}, nil
+}
+
+// Page returns the bounds of the page within a slice of Total items.
+func (p Pagination) Page() (start, end int) {
+	start = min(p.Offset, p.Total)
+	if p.Limit <= 0 {
+		return start, p.Total
+	}
+	return start, min(p.Offset+p.Limit, p.Total)
+}
+
+// Apply sets X-Total-Count on resp and, for a paged collection, adds Link
+// headers to its first, last, previous and next pages, see AddLink. The
+// page URLs are req's own, rebuilt with Request.BaseURL so they hold
+// behind a proxy, with the offset and limit query parameters replaced
+// and the others kept.
+func (p Pagination) Apply(req *Request, resp *Response) {
+	if resp.Headers == nil {
+		resp.Headers = map[string]string{}
+	}
+	resp.Headers["X-Total-Count"] = strconv.Itoa(p.Total)
+	if p.Limit <= 0 {
+		return
+	}
+	AddLink(resp, "first", p.URL(req, 0), nil)
+	if prev, ok := p.prev(); ok {
+		AddLink(resp, "prev", p.URL(req, prev), nil)
+	}
+	if next, ok := p.next(); ok {
+		AddLink(resp, "next", p.URL(req, next), nil)
+	}
+	AddLink(resp, "last", p.URL(req, p.last()), nil)
+}
+
+// prev returns the offset of the previous page, and whether there is one.
+// A page past the end is preceded by the last page.
+func (p Pagination) prev() (int, bool) {
+	if p.Limit <= 0 || p.Offset == 0 {
+		return 0, false
+	}
+	return max(min(p.Offset-p.Limit, p.last()), 0), true
+}
+
+// next returns the offset of the next page, and whether there is one.
+func (p Pagination) next() (int, bool) {
+	if p.Limit <= 0 || p.Offset+p.Limit >= p.Total {
+		return 0, false
+	}
+	return p.Offset + p.Limit, true
+}
+
+// last returns the offset of the last page.
+func (p Pagination) last() int {
+	if p.Limit <= 0 || p.Total == 0 {
+		return 0
+	}
+	return (p.Total - 1) / p.Limit * p.Limit
+}
+
+// URL returns the absolute URL, where the request allows one, of the page
+// of req's collection starting at offset.
+func (p Pagination) URL(req *Request, offset int) string {
+	query := url.Values{}
+	for key, values := range req.queryValues() {
+		query[key] = slices.Clone(values)
+	}
+	query.Set("offset", strconv.Itoa(offset))
+	query.Set("limit", strconv.Itoa(p.Limit))
+	return req.BaseURL() + req.Path + "?" + query.Encode()
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAddLink(t *testing.T) {
+	tests := []struct {
+		name   string
+		rel    string
+		target string
+		params map[string]string
+		want   string
+	}{
+		{"plain", "next", "/items?offset=20", nil, `</items?offset=20>; rel="next"`},
+		{"quoted params, sorted", "next", "/items", map[string]string{"type": "application/json", "title": `Page "2"`},
+			`</items>; rel="next"; title="Page \"2\""; type="application/json"`},
+		{"backslash", "help", "/docs", map[string]string{"title": `C:\docs`}, `</docs>; rel="help"; title="C:\\docs"`},
+		{"several rels", "prev first", "/items", nil, `</items>; rel="prev first"`},
+		{"param names lowercased", "next", "/items", map[string]string{"Title": "two"}, `</items>; rel="next"; title="two"`},
+		{"rel param dropped", "next", "/items", map[string]string{"REL": "last"}, `</items>; rel="next"`},
+		{"name not a token dropped", "next", "/items", map[string]string{"bad name": "x", "a;b": "y"}, `</items>; rel="next"`},
+		{"value with a line break dropped", "next", "/items", map[string]string{"title": "a\r\nSet-Cookie: x=1"}, `</items>; rel="next"`},
+		{"target escaped", "next", "/items/a b>\u00e9?q=<x>", nil, `</items/a%20b%3E%C3%A9?q=%3Cx%3E>; rel="next"`},
+		{"absolute target", "last", "https://example.com/items?offset=80&limit=20", nil,
+			`<https://example.com/items?offset=80&limit=20>; rel="last"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp Response
+			AddLink(&resp, tt.rel, tt.target, tt.params)
+			if got := resp.Headers["Link"]; got != tt.want {
+				t.Errorf("Link = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("joined", func(t *testing.T) {
+		resp := Response{Headers: map[string]string{"Link": `</style.css>; rel="preload"`}}
+		AddLink(&resp, "next", "/items?offset=20", nil)
+		AddLink(&resp, "last", "/items?offset=80", nil)
+		want := `</style.css>; rel="preload", </items?offset=20>; rel="next", </items?offset=80>; rel="last"`
+		if got := resp.Headers["Link"]; got != want {
+			t.Errorf("Link = %s, want %s", got, want)
+		}
+	})
+}
+
+// pageRequest returns a GET of target with Host example.com.
+func pageRequest(target string) *Request {
+	req := &Request{Method: "GET", Headers: map[string]string{"host": "example.com"}}
+	req.setTarget(target)
+	return req
+}
+
+// links returns the rels of a Link header mapped to their targets.
+func links(header string) map[string]string {
+	rels := map[string]string{}
+	if header == "" {
+		return rels
+	}
+	for _, link := range strings.Split(header, ", ") {
+		target, params, _ := strings.Cut(link, ">; ")
+		rel := strings.Trim(strings.TrimPrefix(params, "rel="), `"`)
+		rels[rel] = strings.TrimPrefix(target, "<")
+	}
+	return rels
+}
+
+func TestPaginationApply(t *testing.T) {
+	const base = "http://example.com/items?"
+	tests := []struct {
+		name  string
+		pages Pagination
+		want  map[string]string // rel to query
+	}{
+		{"first page", Pagination{Total: 95, Offset: 0, Limit: 20}, map[string]string{
+			"first": "limit=20&offset=0",
+			"next":  "limit=20&offset=20",
+			"last":  "limit=20&offset=80",
+		}},
+		{"middle page", Pagination{Total: 95, Offset: 40, Limit: 20}, map[string]string{
+			"first": "limit=20&offset=0",
+			"prev":  "limit=20&offset=20",
+			"next":  "limit=20&offset=60",
+			"last":  "limit=20&offset=80",
+		}},
+		{"last page", Pagination{Total: 95, Offset: 80, Limit: 20}, map[string]string{
+			"first": "limit=20&offset=0",
+			"prev":  "limit=20&offset=60",
+			"last":  "limit=20&offset=80",
+		}},
+		{"exactly full last page", Pagination{Total: 100, Offset: 80, Limit: 20}, map[string]string{
+			"first": "limit=20&offset=0",
+			"prev":  "limit=20&offset=60",
+			"last":  "limit=20&offset=80",
+		}},
+		{"unaligned offset", Pagination{Total: 95, Offset: 10, Limit: 20}, map[string]string{
+			"first": "limit=20&offset=0",
+			"prev":  "limit=20&offset=0",
+			"next":  "limit=20&offset=30",
+			"last":  "limit=20&offset=80",
+		}},
+		{"past the end", Pagination{Total: 95, Offset: 200, Limit: 20}, map[string]string{
+			"first": "limit=20&offset=0",
+			"prev":  "limit=20&offset=80",
+			"last":  "limit=20&offset=80",
+		}},
+		{"single page", Pagination{Total: 5, Offset: 0, Limit: 20}, map[string]string{
+			"first": "limit=20&offset=0",
+			"last":  "limit=20&offset=0",
+		}},
+		{"empty", Pagination{Total: 0, Offset: 0, Limit: 20}, map[string]string{
+			"first": "limit=20&offset=0",
+			"last":  "limit=20&offset=0",
+		}},
+		{"unpaged", Pagination{Total: 95, Limit: 0}, map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp Response
+			tt.pages.Apply(pageRequest("/items"), &resp)
+			if got := resp.Headers["X-Total-Count"]; got != strconv.Itoa(tt.pages.Total) {
+				t.Errorf("X-Total-Count = %q, want %d", got, tt.pages.Total)
+			}
+			got := links(resp.Headers["Link"])
+			if len(got) != len(tt.want) {
+				t.Errorf("Link = %s, want rels %v", resp.Headers["Link"], tt.want)
+			}
+			for rel, query := range tt.want {
+				if got[rel] != base+query {
+					t.Errorf("rel=%s links to %q, want %q", rel, got[rel], base+query)
+				}
+			}
+		})
+	}
+}
+
+func TestPaginationURL(t *testing.T) {
+	pages := Pagination{Total: 95, Offset: 40, Limit: 20}
+	tests := []struct {
+		name string
+		req  func() *Request
+		want string
+	}{
+		{"other parameters kept", func() *Request {
+			return pageRequest("/items?sort=name&offset=40&limit=20&tag=a&tag=b%20c")
+		}, "http://example.com/items?limit=20&offset=0&sort=name&tag=a&tag=b+c"},
+		{"no host", func() *Request {
+			req := pageRequest("/items")
+			delete(req.Headers, "host")
+			return req
+		}, "/items?limit=20&offset=0"},
+		{"behind a trusted proxy", func() *Request {
+			req := pageRequest("/items")
+			req.trusted = true
+			req.basePath = "/svc"
+			req.Headers["forwarded"] = `for=192.0.2.1;proto=https;host="api.example.com"`
+			return req
+		}, "https://api.example.com/svc/items?limit=20&offset=0"},
+		{"X-Forwarded headers from a trusted proxy", func() *Request {
+			req := pageRequest("/items")
+			req.trusted = true
+			req.Headers["x-forwarded-proto"] = "https"
+			req.Headers["x-forwarded-host"] = "api.example.com, internal:8080"
+			return req
+		}, "https://api.example.com/items?limit=20&offset=0"},
+		{"forwarding headers from an untrusted peer", func() *Request {
+			req := pageRequest("/items")
+			req.Headers["x-forwarded-proto"] = "https"
+			req.Headers["x-forwarded-host"] = "evil.example"
+			return req
+		}, "http://example.com/items?limit=20&offset=0"},
+	}
+	for _, tt := range tests {
+		if got := pages.URL(tt.req(), 0); got != tt.want {
+			t.Errorf("%s: URL = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParsePagination(t *testing.T) {
+	tests := []struct {
+		target            string
+		defaultLimit, max int
+		want              Pagination
+	}{
+		{"/items", 20, 100, Pagination{Offset: 0, Limit: 20}},
+		{"/items?offset=40&limit=10", 20, 100, Pagination{Offset: 40, Limit: 10}},
+		{"/items?limit=500", 20, 100, Pagination{Limit: 100}},
+		{"/items?limit=500", 20, 0, Pagination{Limit: 500}},
+		{"/items", 0, 0, Pagination{}},
+		{"/items?limit=0", 0, 0, Pagination{}},
+		{"/items?limit=5", 0, 0, Pagination{Limit: 5}},
+	}
+	for _, tt := range tests {
+		got, err := ParsePagination(pageRequest(tt.target), tt.defaultLimit, tt.max)
+		if err != nil || got != tt.want {
+			t.Errorf("ParsePagination(%s, %d, %d) = %+v, %v, want %+v", tt.target, tt.defaultLimit, tt.max, got, err, tt.want)
+		}
+	}
+
+	for _, target := range []string{"/items?offset=-1", "/items?limit=0", "/items?limit=-5", "/items?offset=x", "/items?limit=1.5"} {
+		_, err := ParsePagination(pageRequest(target), 20, 100)
+		var paramErr *ParamError
+		if !errors.As(err, &paramErr) {
+			t.Errorf("ParsePagination(%s) = %v, want a ParamError", target, err)
+		}
+	}
+}
+
+func TestPaginationPage(t *testing.T) {
+	for _, tt := range []struct {
+		pages      Pagination
+		start, end int
+	}{
+		{Pagination{Total: 95, Offset: 40, Limit: 20}, 40, 60},
+		{Pagination{Total: 95, Offset: 80, Limit: 20}, 80, 95},
+		{Pagination{Total: 95, Offset: 200, Limit: 20}, 95, 95},
+		{Pagination{Total: 95, Offset: 10, Limit: 0}, 10, 95},
+		{Pagination{Total: 0, Offset: 0, Limit: 20}, 0, 0},
+	} {
+		if start, end := tt.pages.Page(); start != tt.start || end != tt.end {
+			t.Errorf("%+v.Page() = %d, %d, want %d, %d", tt.pages, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestServeListingPagination(t *testing.T) {
+	public := chdirPublic(t)
+	docs := filepath.Join(public, "docs")
+	if err := os.Mkdir(docs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt"} {
+		if err := os.WriteFile(filepath.Join(docs, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("ENABLE_DIR_LISTING", "true")
+	_, addr := startServer(t)
+
+	resp := roundTrip(t, dial(t, addr), "GET", "/files/docs/?offset=2&limit=2",
+		map[string]string{"Accept": "application/json", "Host": "files.example"}, nil)
+	if resp.Status != 200 {
+		t.Fatalf("listing = %d, want 200", resp.Status)
+	}
+	if got := resp.Header("X-Total-Count"); got != "5" {
+		t.Errorf("X-Total-Count = %q, want 5", got)
+	}
+	const base = "http://files.example/files/docs/?"
+	want := map[string]string{
+		"first": base + "limit=2&offset=0",
+		"prev":  base + "limit=2&offset=0",
+		"next":  base + "limit=2&offset=4",
+		"last":  base + "limit=2&offset=4",
+	}
+	got := links(resp.Header("Link"))
+	for rel, target := range want {
+		if got[rel] != target {
+			t.Errorf("rel=%s links to %q, want %q", rel, got[rel], target)
+		}
+	}
+
+	if resp := roundTrip(t, dial(t, addr), "GET", "/files/docs/?limit=0", map[string]string{"Accept": "application/json"}, nil); resp.Status != 400 {
+		t.Errorf("listing with limit=0 = %d, want 400", resp.Status)
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// ListenerTimeouts overrides the server's connection timeouts for one
+// listener. Zero fields keep the server's value.
+type ListenerTimeouts struct {
+	ReadTimeout           time.Duration // READ_TIMEOUT
+	IdleTimeout           time.Duration // IDLE_TIMEOUT
+	WriteTimeout          time.Duration // WRITE_TIMEOUT
+	ConnectionTimeout     time.Duration // CONNECTION_TIMEOUT
+	MaxConnectionLifetime time.Duration // MAX_CONNECTION_LIFETIME
+}
+
+// binding is what the connections of one listener are served with.
+type binding struct {
+	router                *Router
+	tlsConfig             *tls.Config
+	readTimeout           time.Duration
+	idleTimeout           time.Duration
+	writeTimeout          time.Duration
+	connectionTimeout     time.Duration
+	maxConnectionLifetime time.Duration
+	maxRequests           int // MAX_REQUESTS_PER_CONN, 0 for unlimited
+
+	// public marks the main listener, the only one that ALLOWED_HOSTS and
+	// maintenance mode apply to.
+	public bool
+}
+
+// newBinding binds router to the server's timeouts, overridden by the
+// non-zero fields of timeouts.
+func (s *Server) newBinding(router *Router, timeouts ListenerTimeouts) *binding {
+	b := &binding{
+		router:                router,
+		readTimeout:           s.config.ReadTimeout,
+		idleTimeout:           s.config.IdleTimeout,
+		writeTimeout:          s.config.WriteTimeout,
+		connectionTimeout:     s.config.ConnectionTimeout,
+		maxConnectionLifetime: s.config.MaxConnectionLifetime,
+		maxRequests:           s.config.MaxRequestPerConn,
+	}
+	if timeouts.ReadTimeout > 0 {
+		b.readTimeout = timeouts.ReadTimeout
+	}
+	if timeouts.IdleTimeout > 0 {
+		b.idleTimeout = timeouts.IdleTimeout
+	}
+	if timeouts.WriteTimeout > 0 {
+		b.writeTimeout = timeouts.WriteTimeout
+	}
+	if timeouts.ConnectionTimeout > 0 {
+		b.connectionTimeout = timeouts.ConnectionTimeout
+	}
+	if timeouts.MaxConnectionLifetime > 0 {
+		b.maxConnectionLifetime = timeouts.MaxConnectionLifetime
+	}
+	return b
+}
+
+// setWriteDeadline makes writes to conn fail once timeout has passed from
+// now, or lifts the deadline if timeout is not positive.
+func setWriteDeadline(conn net.Conn, timeout time.Duration) {
+	if timeout <= 0 {
+		conn.SetWriteDeadline(time.Time{})
+		return
+	}
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+}
+
+// mainBinding is the binding of the listener passed to Serve.
+func (s *Server) mainBinding() *binding {
+	b := s.newBinding(s.router, ListenerTimeouts{})
+	b.tlsConfig = s.tlsConfig
+	b.public = true
+	return b
+}
+
+// AddListener serves router on a further plain TCP listener at addr,
+// alongside the main one. Its connections run router's own middleware
+// chain, not the main router's, so an admin listener can have its own
+// authentication and skip the public rate limits and access log, and
+// they bypass ALLOWED_HOSTS and maintenance mode, which guard the main
+// listener. Accept filters, post-processors, draining and graceful
+// shutdown span every listener. timeouts, if given, overrides the
+// server's connection timeouts for this listener.
+//
+// AddListener returns once the listener is open; it is served in the
+// background until Shutdown closes it.
+func (s *Server) AddListener(addr string, router *Router, timeouts ...ListenerTimeouts) (net.Addr, error) {
+	if err := router.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid router for %s: %w", addr, err)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start listener on %s: %w", addr, err)
+	}
+	var t ListenerTimeouts
+	if len(timeouts) > 0 {
+		t = timeouts[0]
+	}
+	b := s.newBinding(router, t)
+	s.trackListener(listener)
+	utils.Info("Listener started on %s", listener.Addr())
+	go func() {
+		defer listener.Close()
+		s.acceptLoop(listener, b)
+	}()
+	return listener.Addr(), nil
+}
+
+// acceptLoop accepts connections on listener and serves each with b in
+// its own goroutine, until listener is closed.
+func (s *Server) acceptLoop(listener net.Listener, b *binding) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			utils.Warn("Failed to accept connection: %v", err)
+			continue
+		}
+		ok, trusted := s.runAcceptFilters(conn)
+		if !ok {
+			conn.Close()
+			continue
+		}
+		release := s.trackConn(conn)
+		go func() {
+			defer release()
+			s.handleConnection(conn, trusted, b)
+		}()
+	}
+}
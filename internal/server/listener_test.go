@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+)
+
+// markResponses is middleware setting the X-Chain header, so a response
+// shows which router's chain it went through.
+func markResponses(name string) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) Response {
+			req.Set("chain", name)
+			resp := next(req)
+			resp.Headers["X-Chain"] = name
+			return resp
+		}
+	}
+}
+
+// startAdminListener serves a public router on the server's main listener
+// and an admin router, behind AdminAuth with token "secret", on a second
+// one. It returns the server and both addresses.
+func startAdminListener(t *testing.T) (srv *Server, public, admin string) {
+	t.Helper()
+	srv, public = startServerWithRoutes(t, func(r *Router) {
+		r.Use(markResponses("public"))
+		r.Handle("/hello", "GET", func(req *Request) Response {
+			return textResponse("hello " + req.GetString("chain"))
+		})
+	})
+	adminRouter := NewRouter()
+	adminRouter.Use(markResponses("admin"))
+	adminRouter.Handle("/admin/stats", "GET", AdminAuth("secret", func(req *Request) Response {
+		return textResponse("stats " + req.GetString("chain"))
+	}))
+	addr, err := srv.AddListener("127.0.0.1:0", adminRouter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, public, addr.String()
+}
+
+func TestListenersIsolated(t *testing.T) {
+	_, public, admin := startAdminListener(t)
+	token := map[string]string{"Authorization": "Bearer secret"}
+
+	for _, tt := range []struct {
+		name, addr, path string
+		headers          map[string]string
+		status           int
+		body, chain      string
+	}{
+		{"public route on public listener", public, "/hello", nil, 200, "hello public", "public"},
+		{"admin route on admin listener", admin, "/admin/stats", token, 200, "stats admin", "admin"},
+		{"admin auth is the admin chain's", admin, "/admin/stats", nil, 401, "", "admin"},
+		{"admin route absent from public listener", public, "/admin/stats", token, 404, "", "public"},
+		{"public route absent from admin listener", admin, "/hello", nil, 404, "", "admin"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := roundTrip(t, dial(t, tt.addr), "GET", tt.path, tt.headers, nil)
+			if resp.Status != tt.status {
+				t.Fatalf("status = %d, want %d", resp.Status, tt.status)
+			}
+			if tt.body != "" && string(resp.Body) != tt.body {
+				t.Errorf("body = %q, want %q", resp.Body, tt.body)
+			}
+			if got := resp.Header("X-Chain"); got != tt.chain {
+				t.Errorf("X-Chain = %q, want only the %s chain", got, tt.chain)
+			}
+		})
+	}
+}
+
+func TestAddListenerBypassesAllowedHosts(t *testing.T) {
+	srv, public, admin := startAdminListener(t)
+	allowed, err := NewHostAllowList([]string{"www.example.com"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetAllowedHosts(allowed)
+
+	headers := map[string]string{"Host": "127.0.0.1", "Authorization": "Bearer secret"}
+	if resp := roundTrip(t, dial(t, public), "GET", "/hello", headers, nil); resp.Status != 421 {
+		t.Errorf("public listener with a foreign Host = %d, want 421", resp.Status)
+	}
+	if resp := roundTrip(t, dial(t, admin), "GET", "/admin/stats", headers, nil); resp.Status != 200 {
+		t.Errorf("admin listener with a foreign Host = %d, want 200", resp.Status)
+	}
+}
+
+func TestListenerTimeoutOverrides(t *testing.T) {
+	srv := NewServer(config.LoadConfig(), NewRouter())
+	base := srv.newBinding(srv.router, ListenerTimeouts{})
+	b := srv.newBinding(srv.router, ListenerTimeouts{ReadTimeout: 7 * time.Second, ConnectionTimeout: time.Minute})
+	if b.readTimeout != 7*time.Second || b.connectionTimeout != time.Minute {
+		t.Errorf("overridden timeouts = %v, %v; want 7s, 1m", b.readTimeout, b.connectionTimeout)
+	}
+	if b.idleTimeout != base.idleTimeout || b.writeTimeout != base.writeTimeout || b.maxConnectionLifetime != base.maxConnectionLifetime {
+		t.Error("zero overrides did not keep the server's timeouts")
+	}
+	if b.public {
+		t.Error("an added listener's binding is marked public")
+	}
+}
+
+func TestShutdownSpansListeners(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	srv, _ := startServerWithRoutes(t, func(r *Router) {})
+	adminRouter := NewRouter()
+	adminRouter.Handle("/slow", "GET", func(req *Request) Response {
+		close(started)
+		<-release
+		return textResponse("done")
+	})
+	addr, err := srv.AddListener("127.0.0.1:0", adminRouter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := dial(t, addr.String())
+	if err := c.SendRequest("GET", "/slow", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	done := make(chan struct{})
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx, "test")
+		close(done)
+	}()
+
+	// The admin listener stops accepting, but its request in flight is
+	// waited for.
+	waitFor(t, "the admin listener to close", func() bool {
+		conn, err := net.DialTimeout("tcp", addr.String(), time.Second)
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	})
+	select {
+	case <-done:
+		t.Fatal("Shutdown returned with a request in flight on the admin listener")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	resp, err := c.ReadResponse()
+	if err != nil || resp.Status != 200 {
+		t.Fatalf("in-flight request: %v, %v", resp, err)
+	}
+	<-done
+}
+
+func TestAdminAddrGetsItsOwnRouter(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	t.Setenv("ADMIN_ADDR", "127.0.0.1:0")
+	srv, adminRouter, err := buildServer(loadConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adminRouter == srv.router {
+		t.Fatal("ADMIN_ADDR left the admin API on the main router")
+	}
+	for _, tt := range []struct {
+		router *Router
+		want   int
+	}{
+		{srv.router, 404},
+		{adminRouter, 401},
+	} {
+		if got := routeGET(tt.router, "/admin/config").Status; got != tt.want {
+			t.Errorf("GET /admin/config = %d, want %d", got, tt.want)
+		}
+	}
+	if got := routeGET(srv.router, "/healthz").Status; got != 200 {
+		t.Errorf("main router GET /healthz = %d, want 200", got)
+	}
+
+	t.Setenv("ADMIN_ADDR", "")
+	srv, adminRouter, err = buildServer(loadConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adminRouter != srv.router || routeGET(srv.router, "/admin/config").Status != 401 {
+		t.Error("without ADMIN_ADDR the admin API is not on the main router")
+	}
+}
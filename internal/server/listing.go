@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"html/template"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// Page sizes for directory listings, see dirListing.
+const (
+	DefaultListingLimit = 500
+	MaxListingLimit     = 5000
+)
+
+// DirEntry is one entry of a directory listing. Symbolic links are
+// described by the entry they point to when it lies inside the served
+// root, and as themselves otherwise.
+type DirEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	IsDir   bool      `json:"isDir"`
+	Href    string    `json:"-"`
+}
+
+// DirListing is the data a listing template is executed with.
+type DirListing struct {
+	Path    string // URL path of the directory, ending in "/"
+	Parent  string // URL path of the parent directory, "" at the mount root
+	Entries []DirEntry
+	Total   int // entries before pagination
+	Offset  int
+	Limit   int
+	Next    string // URL of the next page, "" on the last one
+	Prev    string // URL of the previous page, "" on the first one
+}
+
+// DefaultListingTemplate renders HTML directory listings unless a mount
+// sets ServeDirOptions.ListingTemplate.
+var DefaultListingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<title>Index of {{.Path}}</title>
+<h1>Index of {{.Path}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th></tr>
+{{- if .Parent}}
+<tr><td><a href="{{.Parent}}">../</a></td><td></td><td></td></tr>
+{{- end}}
+{{- range .Entries}}
+<tr><td><a href="{{.Href}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td>{{if not .IsDir}}{{.Size}}{{end}}</td><td>{{.ModTime.UTC.Format "2006-01-02 15:04:05"}}</td></tr>
+{{- end}}
+</table>
+{{- if or .Prev .Next}}
+<p>{{if .Prev}}<a href="{{.Prev}}">previous</a>{{end}} {{if .Next}}<a href="{{.Next}}">next</a>{{end}}</p>
+{{- end}}
+`))
+
+// dirListing lists the directory dirPath, found at rel under the mount of
+// root at prefix. Clients may pass:
+//
+//   - sort: "name" (the default), "size" or "mtime"
+//   - order: "asc" (the default) or "desc"
+//   - offset and limit to page through the entries; limit defaults to
+//     DefaultListingLimit and is capped at MaxListingLimit
+//
+// The listing is JSON, an array of DirEntry, when the client accepts
+// application/json, and HTML otherwise. X-Total-Count carries the number
+// of entries before pagination, and Link headers the other pages, see
+// Pagination.Apply. Dotfiles are left out unless the mount
+// shows hidden files, and directories come first if it asks for that.
+func dirListing(req *Request, prefix, rel, root, dirPath string, opts ServeDirOptions) Response {
+	sortKey, _, err := req.queryParam("sort")
+	if err != nil {
+		return BadRequestErrorResponse(err)
+	}
+	order, _, err := req.queryParam("order")
+	if err != nil {
+		return BadRequestErrorResponse(err)
+	}
+	var compare func(a, b DirEntry) int
+	switch sortKey {
+	case "", "name":
+		compare = func(a, b DirEntry) int { return strings.Compare(a.Name, b.Name) }
+	case "size":
+		compare = func(a, b DirEntry) int { return cmp.Compare(a.Size, b.Size) }
+	case "mtime":
+		compare = func(a, b DirEntry) int { return a.ModTime.Compare(b.ModTime) }
+	default:
+		return BadRequestErrorResponse(&ParamError{Source: "query", Name: "sort", Value: sortKey, Err: ErrParamInvalid, Reason: "must be name, size or mtime"})
+	}
+	if order != "" && order != "asc" && order != "desc" {
+		return BadRequestErrorResponse(&ParamError{Source: "query", Name: "order", Value: order, Err: ErrParamInvalid, Reason: "must be asc or desc"})
+	}
+	pages, err := ParsePagination(req, DefaultListingLimit, MaxListingLimit)
+	if err != nil {
+		return BadRequestErrorResponse(err)
+	}
+
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		utils.Warn("Failed to list directory: %s, error: %v", dirPath, err)
+		return NotFoundResponse()
+	}
+	base := prefix + "/"
+	if rel != "" {
+		base += encodeFilePath(rel) + "/"
+	}
+	entries := make([]DirEntry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if isReservedFile(dirEntry.Name()) || !opts.ShowHidden && strings.HasPrefix(dirEntry.Name(), ".") {
+			continue
+		}
+		entry, ok := listEntry(root, dirPath, dirEntry)
+		if !ok {
+			continue
+		}
+		entry.Href = base + encodeFilePath(entry.Name)
+		if entry.IsDir {
+			entry.Href += "/"
+		}
+		entries = append(entries, entry)
+	}
+	slices.SortStableFunc(entries, func(a, b DirEntry) int {
+		if opts.DirsFirst && a.IsDir != b.IsDir {
+			if a.IsDir {
+				return -1
+			}
+			return 1
+		}
+		c := compare(a, b)
+		if c == 0 {
+			c = strings.Compare(a.Name, b.Name)
+		}
+		if order == "desc" {
+			return -c
+		}
+		return c
+	})
+
+	pages.Total = len(entries)
+	start, end := pages.Page()
+	page := entries[start:end]
+	headers := map[string]string{"Vary": "Accept"}
+	var body []byte
+	if negotiateErrorFormat(req.Headers["accept"]) == "json" {
+		var err error
+		if body, err = json.Marshal(page); err != nil {
+			utils.Error("Failed to encode listing of %s: %v", dirPath, err)
+			return InternalServerErrorResponse()
+		}
+		headers["Content-Type"] = "application/json"
+	} else {
+		listing := &DirListing{Path: base, Entries: page, Total: pages.Total, Offset: pages.Offset, Limit: pages.Limit}
+		if rel != "" {
+			listing.Parent = path.Dir(strings.TrimSuffix(base, "/")) + "/"
+		}
+		if next, ok := pages.next(); ok {
+			listing.Next = pages.URL(req, next)
+		}
+		if prev, ok := pages.prev(); ok {
+			listing.Prev = pages.URL(req, prev)
+		}
+		tmpl := opts.ListingTemplate
+		if tmpl == nil {
+			tmpl = DefaultListingTemplate
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, listing); err != nil {
+			utils.Error("Failed to render listing of %s: %v", dirPath, err)
+			return InternalServerErrorResponse()
+		}
+		headers["Content-Type"] = "text/html; charset=utf-8"
+		body = buf.Bytes()
+	}
+
+	resp := Response{Version: HTTPVersion, Status: 200, Reason: "OK", Headers: headers}
+	if req.Method == "HEAD" {
+		resp.ContentLength = int64(len(body))
+	} else {
+		resp.Body = body
+	}
+	pages.Apply(req, &resp)
+	return resp
+}
+
+// listEntry describes dirEntry of dirPath. A symbolic link is described by
+// its target only when the target resolves inside root; otherwise by the
+// link itself, so the listing never reveals what lies outside. ok is false
+// for entries that vanished while listing.
+func listEntry(root, dirPath string, dirEntry os.DirEntry) (DirEntry, bool) {
+	info, err := dirEntry.Info()
+	if err != nil {
+		return DirEntry{}, false
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target := filepath.Join(dirPath, dirEntry.Name())
+		if withinRoot(root, target) {
+			if targetInfo, err := os.Stat(target); err == nil {
+				info = targetInfo
+			}
+		}
+	}
+	return DirEntry{Name: dirEntry.Name(), Size: info.Size(), ModTime: info.ModTime(), IsDir: info.IsDir()}, true
+}
+
+// withinRoot reports whether p, with symbolic links resolved, lies inside
+// root.
+func withinRoot(root, p string) bool {
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return false
+	}
+	resolved, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(resolvedRoot, resolved)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
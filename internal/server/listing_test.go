@@ -0,0 +1,222 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listingDir creates a directory to list: files of distinct sizes whose
+// modification times run opposite to their names, a subdirectory, a
+// dotfile, and a file name that needs escaping in URLs.
+func listingDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, f := range []struct{ name, content string }{
+		{"a.txt", "aaa"},
+		{"b c.txt", "b"},
+		{"c.txt", "cc"},
+		{".hidden", "h"},
+	} {
+		p := filepath.Join(dir, f.name)
+		writeTestFile(t, p, f.content)
+		mtime := base.Add(time.Duration(10-i) * time.Hour)
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "sub", "inner.txt"), "i")
+	return dir
+}
+
+// listDir requests the listing at target from a ServeDir handler for dir
+// mounted at /d, as JSON when json is set.
+func listDir(t *testing.T, dir string, opts ServeDirOptions, target string, json bool) Response {
+	t.Helper()
+	opts.Listing = true
+	r := NewRouter()
+	handler := ServeDir("/d", dir, opts)
+	r.Handle("/d", "GET", handler)
+	r.HandlePrefix("/d/", "GET", handler)
+	req := &Request{Method: "GET", Version: HTTPVersion, Headers: map[string]string{}}
+	if json {
+		req.Headers["accept"] = "application/json"
+	}
+	req.setTarget(target)
+	return r.Route(req)
+}
+
+// listNames returns the names in a JSON listing.
+func listNames(t *testing.T, resp Response) []string {
+	t.Helper()
+	if resp.Status != 200 {
+		t.Fatalf("status = %d %s, want 200", resp.Status, resp.Body)
+	}
+	var entries []DirEntry
+	if err := json.Unmarshal(resp.Body, &entries); err != nil {
+		t.Fatalf("decoding %s: %v", resp.Body, err)
+	}
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name
+	}
+	return names
+}
+
+func TestDirListingSort(t *testing.T) {
+	dir := listingDir(t)
+	// sub was created last, so it is the newest; as a directory its size
+	// depends on the filesystem, so size orders leave it out.
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"a.txt", "b c.txt", "c.txt", "sub"}},
+		{"?sort=name", []string{"a.txt", "b c.txt", "c.txt", "sub"}},
+		{"?sort=name&order=desc", []string{"sub", "c.txt", "b c.txt", "a.txt"}},
+		{"?sort=mtime", []string{"c.txt", "b c.txt", "a.txt", "sub"}},
+		{"?sort=mtime&order=desc", []string{"sub", "a.txt", "b c.txt", "c.txt"}},
+	} {
+		if got := listNames(t, listDir(t, dir, ServeDirOptions{}, "/d/"+tt.query, true)); !slices.Equal(got, tt.want) {
+			t.Errorf("listing%s = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(dir, "sub", "inner.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := listNames(t, listDir(t, dir, ServeDirOptions{}, "/d/?sort=size", true)), []string{"b c.txt", "c.txt", "a.txt"}; !slices.Equal(got, want) {
+		t.Errorf("listing by size = %v, want %v", got, want)
+	}
+	if got, want := listNames(t, listDir(t, dir, ServeDirOptions{}, "/d/?sort=size&order=desc", true)), []string{"a.txt", "c.txt", "b c.txt"}; !slices.Equal(got, want) {
+		t.Errorf("listing by size, descending = %v, want %v", got, want)
+	}
+}
+
+func TestDirListingSortTiesByName(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b", "c", "a"} {
+		writeTestFile(t, filepath.Join(dir, name), "same")
+	}
+	if got, want := listNames(t, listDir(t, dir, ServeDirOptions{}, "/d/?sort=size", true)), []string{"a", "b", "c"}; !slices.Equal(got, want) {
+		t.Errorf("equal sizes = %v, want %v", got, want)
+	}
+	if got, want := listNames(t, listDir(t, dir, ServeDirOptions{}, "/d/?sort=size&order=desc", true)), []string{"c", "b", "a"}; !slices.Equal(got, want) {
+		t.Errorf("equal sizes, descending = %v, want %v", got, want)
+	}
+}
+
+func TestDirListingDirsFirstAndHidden(t *testing.T) {
+	dir := listingDir(t)
+	opts := ServeDirOptions{DirsFirst: true}
+	if got, want := listNames(t, listDir(t, dir, opts, "/d/?order=desc", true)), []string{"sub", "c.txt", "b c.txt", "a.txt"}; !slices.Equal(got, want) {
+		t.Errorf("directories first, descending = %v, want %v", got, want)
+	}
+	if got, want := listNames(t, listDir(t, dir, opts, "/d/?sort=mtime", true)), []string{"sub", "c.txt", "b c.txt", "a.txt"}; !slices.Equal(got, want) {
+		t.Errorf("directories first by mtime = %v, want %v", got, want)
+	}
+	opts.ShowHidden = true
+	if got, want := listNames(t, listDir(t, dir, opts, "/d/", true)), []string{"sub", ".hidden", "a.txt", "b c.txt", "c.txt"}; !slices.Equal(got, want) {
+		t.Errorf("with hidden files = %v, want %v", got, want)
+	}
+}
+
+func TestDirListingInvalidParams(t *testing.T) {
+	dir := listingDir(t)
+	for _, query := range []string{"?sort=colour", "?sort=NAME", "?order=up", "?limit=-1", "?offset=x"} {
+		if resp := listDir(t, dir, ServeDirOptions{}, "/d/"+query, true); resp.Status != 400 {
+			t.Errorf("listing%s = %d, want 400", query, resp.Status)
+		}
+	}
+}
+
+func TestDirListingJSON(t *testing.T) {
+	dir := listingDir(t)
+	resp := listDir(t, dir, ServeDirOptions{}, "/d/?limit=2&offset=1", true)
+	if resp.Status != 200 {
+		t.Fatalf("status = %d, want 200", resp.Status)
+	}
+	if got := resp.Headers["Content-Type"]; got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	if got := resp.Headers["Vary"]; got != "Accept" {
+		t.Errorf("Vary = %q, want Accept", got)
+	}
+	if got := resp.Headers["X-Total-Count"]; got != "4" {
+		t.Errorf("X-Total-Count = %q, want 4 before paging", got)
+	}
+
+	// The field set and types are the JSON format's contract.
+	var entries []map[string]any
+	if err := json.Unmarshal(resp.Body, &entries); err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]any{
+		{"name": "b c.txt", "size": 1.0, "mtime": "2024-01-01T09:00:00Z", "isDir": false},
+		{"name": "c.txt", "size": 2.0, "mtime": "2024-01-01T08:00:00Z", "isDir": false},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("page = %s, want 2 entries", resp.Body)
+	}
+	for i := range want {
+		for key, value := range want[i] {
+			got := entries[i][key]
+			if key == "mtime" {
+				// Compare instants, whatever zone the filesystem reports.
+				gotTime, err := time.Parse(time.RFC3339Nano, got.(string))
+				if err != nil || !gotTime.Equal(mustParseTime(t, value.(string))) {
+					t.Errorf("entry %d: mtime = %v, want %v", i, got, value)
+				}
+				continue
+			}
+			if got != value {
+				t.Errorf("entry %d: %s = %v, want %v", i, key, got, value)
+			}
+		}
+		if len(entries[i]) != len(want[i]) {
+			t.Errorf("entry %d has fields %v, want exactly name, size, mtime and isDir", i, entries[i])
+		}
+	}
+}
+
+func mustParseTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestDirListingHTML(t *testing.T) {
+	dir := listingDir(t)
+	resp := listDir(t, dir, ServeDirOptions{}, "/d/", false)
+	if got := resp.Headers["Content-Type"]; got != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want HTML", got)
+	}
+	body := string(resp.Body)
+	for _, want := range []string{`href="/d/b%20c.txt"`, `>b c.txt</a>`, `href="/d/sub/"`, `>sub/</a>`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("HTML listing lacks %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "../") {
+		t.Error("mount root listing links to a parent")
+	}
+
+	resp = listDir(t, dir, ServeDirOptions{}, "/d/sub/", false)
+	if body := string(resp.Body); !strings.Contains(body, `href="/d/"`) || !strings.Contains(body, `href="/d/sub/inner.txt"`) {
+		t.Errorf("subdirectory listing lacks its parent or entry:\n%s", body)
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrUnsafeLocation is returned by SafeLocation for input that cannot be
+// turned into a safe Location.
+var ErrUnsafeLocation = errors.New("unsafe location")
+
+// SafeLocation builds a Location or redirect target from a trusted base,
+// such as "/files/" or "https://example.com/files/", and a user-supplied
+// path such as an uploaded file name. Every segment of userPart is
+// percent-encoded, so "%0d%0a", "?", "#" and non-ASCII characters arrive
+// as literal text rather than as header, query or fragment syntax.
+//
+// The result must stay where base points: a root-relative base yields a
+// root-relative URL that is not protocol-relative ("//host"), and an
+// absolute base a URL with the same scheme and host. Control characters,
+// invalid UTF-8, and empty, "." or ".." segments in userPart are refused
+// with an error wrapping ErrUnsafeLocation.
+func SafeLocation(base, userPart string) (string, error) {
+	if err := checkLocationText(base); err != nil {
+		return "", fmt.Errorf("%w: base: %v", ErrUnsafeLocation, err)
+	}
+	if err := checkLocationText(userPart); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnsafeLocation, err)
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("%w: base: %v", ErrUnsafeLocation, err)
+	}
+
+	for _, segment := range strings.Split(userPart, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", fmt.Errorf("%w: invalid path segment %q", ErrUnsafeLocation, segment)
+		}
+	}
+	location := base
+	if !strings.HasSuffix(location, "/") {
+		location += "/"
+	}
+	location += encodeFilePath(userPart)
+
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnsafeLocation, err)
+	}
+	if baseURL.IsAbs() {
+		if parsed.Scheme != baseURL.Scheme || parsed.Host != baseURL.Host {
+			return "", fmt.Errorf("%w: %q leaves %s://%s", ErrUnsafeLocation, location, baseURL.Scheme, baseURL.Host)
+		}
+		return location, nil
+	}
+	if parsed.Scheme != "" || parsed.Host != "" || !strings.HasPrefix(location, "/") || strings.HasPrefix(location, "//") || strings.HasPrefix(location, `/\`) {
+		return "", fmt.Errorf("%w: %q is not a root-relative URL", ErrUnsafeLocation, location)
+	}
+	return location, nil
+}
+
+// checkLocationText rejects invalid UTF-8 and control characters.
+func checkLocationText(s string) error {
+	if !utf8.ValidString(s) {
+		return errors.New("invalid UTF-8")
+	}
+	for _, r := range s {
+		if r < ' ' || r == 0x7f || (r >= 0x80 && r <= 0x9f) || r == '\u2028' || r == '\u2029' {
+			return fmt.Errorf("control character %U", r)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// responseSplittingPayloads are classic header injection attempts.
+var responseSplittingPayloads = []string{
+	"a\r\nSet-Cookie: evil=1",
+	"a\r\n\r\n<html>injected</html>",
+	"a\nLocation: https://evil.test",
+	"a\rX: y",
+	"%0d%0aSet-Cookie: evil=1",
+	"%0D%0A%0D%0A<script>",
+	"%250d%250a",
+	"\u2028Set-Cookie: evil=1",
+	"\u0085X: y",
+	"\x00",
+	"\xff\xfe",
+}
+
+func TestSafeLocation(t *testing.T) {
+	for _, tt := range []struct {
+		base, user, want string
+	}{
+		{"/files/", "report.pdf", "/files/report.pdf"},
+		{"/files", "a/b c.txt", "/files/a/b%20c.txt"},
+		{"/files/", "%0d%0aSet-Cookie: x", "/files/%250d%250aSet-Cookie:%20x"},
+		{"/files/", "what?#frag", "/files/what%3F%23frag"},
+		{"/files/", "naïve.txt", "/files/na%C3%AFve.txt"},
+		{"/files/", `back\slash`, "/files/back%5Cslash"},
+		{"/files/", "...", "/files/..."},
+		{"https://example.com/files/", "a b", "https://example.com/files/a%20b"},
+	} {
+		got, err := SafeLocation(tt.base, tt.user)
+		if err != nil || got != tt.want {
+			t.Errorf("SafeLocation(%q, %q) = %q, %v; want %q", tt.base, tt.user, got, err, tt.want)
+		}
+	}
+}
+
+func TestSafeLocationRefuses(t *testing.T) {
+	for _, tt := range []struct{ base, user string }{
+		{"/files/", "a\r\nSet-Cookie: evil=1"},
+		{"/files/", "a\nb"},
+		{"/files/", "tab\there"},
+		{"/files/", "\x7f"},
+		{"/files/", "\u0085"},
+		{"/files/", "line\u2028separator"},
+		{"/files/", "\xff"},
+		{"/files/", ""},
+		{"/files/", "a//b"},
+		{"/files/", "/etc/passwd"},
+		{"/files/", "a/../../admin"},
+		{"/files/", "./a"},
+		{"/files/", "a/"},
+		// The base must be safe and stay put too.
+		{"/files/\r\n", "a"},
+		{"/", "/evil.test"},
+		{"files/", "a"},
+		{`/\`, "evil.test"},
+	} {
+		got, err := SafeLocation(tt.base, tt.user)
+		if !errors.Is(err, ErrUnsafeLocation) {
+			t.Errorf("SafeLocation(%q, %q) = %q, %v; want ErrUnsafeLocation", tt.base, tt.user, got, err)
+		}
+	}
+}
+
+// checkOneHeaderBlock fails t unless raw is a response with exactly one
+// header block, of well-formed header lines, followed by body.
+func checkOneHeaderBlock(t *testing.T, raw []byte, body string) {
+	t.Helper()
+	head, rest, ok := strings.Cut(string(raw), "\r\n\r\n")
+	if !ok {
+		t.Fatalf("no end of headers in %q", raw)
+	}
+	lines := strings.Split(head, "\r\n")
+	for _, line := range lines[1:] {
+		name, _, ok := strings.Cut(line, ": ")
+		if !ok || !isToken(name) || strings.ContainsAny(line, "\r\n") || hasControlChar(line) {
+			t.Fatalf("malformed header line %q in %q", line, raw)
+		}
+	}
+	if rest != body {
+		t.Fatalf("body after the headers = %q, want %q", rest, body)
+	}
+}
+
+func FuzzSafeLocation(f *testing.F) {
+	for _, payload := range responseSplittingPayloads {
+		f.Add(payload)
+	}
+	f.Add("report.pdf")
+	f.Add("dir/sub/file name.txt")
+	f.Add("//evil.test")
+	f.Add("..")
+	f.Fuzz(func(t *testing.T, user string) {
+		location, err := SafeLocation("/files/", user)
+		if err != nil {
+			if !errors.Is(err, ErrUnsafeLocation) {
+				t.Fatalf("SafeLocation(%q) = %v, want ErrUnsafeLocation", user, err)
+			}
+			return
+		}
+		for i := 0; i < len(location); i++ {
+			if c := location[i]; c <= ' ' || c >= 0x7f {
+				t.Fatalf("SafeLocation(%q) = %q, with byte %#x", user, location, c)
+			}
+		}
+		parsed, err := url.Parse(location)
+		if err != nil {
+			t.Fatalf("SafeLocation(%q) = %q, which does not parse: %v", user, location, err)
+		}
+		// It names exactly the requested file, under the base.
+		if parsed.Scheme != "" || parsed.Host != "" || parsed.RawQuery != "" || parsed.Fragment != "" || parsed.Path != "/files/"+user {
+			t.Fatalf("SafeLocation(%q) = %q, which parses as %#v", user, location, parsed)
+		}
+		checkOneHeaderBlock(t, BuildResponse(201, "Created", map[string]string{"Location": location}, []byte("ok")), "ok")
+	})
+}
+
+func FuzzHeaderValue(f *testing.F) {
+	for _, payload := range responseSplittingPayloads {
+		f.Add("Location", payload)
+		f.Add("X-Custom", payload)
+	}
+	f.Add("Set-Cookie\r\nX", "v")
+	f.Add("Bad Name", "v")
+	f.Add("", "v")
+	f.Fuzz(func(t *testing.T, name, value string) {
+		raw := BuildResponse(200, "OK", map[string]string{name: value, "Content-Type": "text/plain"}, []byte("ok"))
+		checkOneHeaderBlock(t, raw, "ok")
+	})
+}
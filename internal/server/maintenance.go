@@ -0,0 +1,208 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// DefaultMaintenanceAllowPaths are served normally during maintenance
+// unless MAINTENANCE_ALLOW_PATHS says otherwise, so probes and the admin
+// API keep working.
+var DefaultMaintenanceAllowPaths = []string{"/healthz", "/readyz", "/admin/"}
+
+// MaintenanceState is the runtime maintenance switch. It is the body of
+// POST /admin/maintenance and the content of the state file.
+type MaintenanceState struct {
+	Enabled    bool     `json:"enabled"`
+	Message    string   `json:"message,omitempty"`
+	RetryAfter int      `json:"retry_after,omitempty"` // seconds
+	Prefixes   []string `json:"prefixes,omitempty"`    // empty means every path
+}
+
+// Maintenance answers matching requests with 503 while enabled, except for
+// allow-listed client addresses and paths. It is safe for concurrent use.
+type Maintenance struct {
+	mu         sync.RWMutex
+	state      MaintenanceState
+	allowNets  []*net.IPNet
+	allowPaths []string
+	stateFile  string
+}
+
+// NewMaintenance creates a Maintenance switch.
+//
+// Parameters:
+//   - allowIPs: Client IPs or CIDR ranges that bypass maintenance.
+//   - allowPaths: Path prefixes that bypass maintenance.
+//   - stateFile: File the state is persisted to, or "" to keep it in memory
+//     only. An existing file is loaded, so a restart preserves the state.
+func NewMaintenance(allowIPs, allowPaths []string, stateFile string) (*Maintenance, error) {
+	m := &Maintenance{allowPaths: allowPaths, stateFile: stateFile}
+	allowNets, err := parseIPNets(allowIPs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid maintenance allow list: %w", err)
+	}
+	m.allowNets = allowNets
+
+	if stateFile == "" {
+		return m, nil
+	}
+	data, err := os.ReadFile(stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &m.state); err != nil {
+		return nil, fmt.Errorf("invalid maintenance state file %s: %w", stateFile, err)
+	}
+	if m.state.Enabled {
+		utils.Warn("Maintenance mode restored from %s", stateFile)
+	}
+	return m, nil
+}
+
+// State returns the current maintenance state.
+func (m *Maintenance) State() MaintenanceState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+// Set replaces the maintenance state and persists it to the state file, if
+// any. The in-memory state changes even if persisting fails.
+func (m *Maintenance) Set(state MaintenanceState) error {
+	m.mu.Lock()
+	m.state = state
+	m.mu.Unlock()
+	utils.Info("Maintenance mode %s (prefixes=%v, retry-after=%ds)", onOff(state.Enabled), state.Prefixes, state.RetryAfter)
+
+	if m.stateFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := m.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.stateFile)
+}
+
+// check returns the 503 response for req if maintenance applies to it.
+// path is the request path relative to the router's base path.
+func (m *Maintenance) check(req *Request, path string) (Response, bool) {
+	state := m.State()
+	if !state.Enabled {
+		return Response{}, false
+	}
+	if len(state.Prefixes) > 0 && !hasAnyPrefix(path, state.Prefixes) {
+		return Response{}, false
+	}
+	if hasAnyPrefix(path, m.allowPaths) {
+		return Response{}, false
+	}
+	if ipInNets(clientKey(req), m.allowNets) {
+		return Response{}, false
+	}
+
+	problem := NewHTTPError(503, state.Message)
+	problem.Extensions = map[string]any{"maintenance": true}
+	resp := problem.Response()
+	if state.RetryAfter > 0 {
+		resp.Headers["Retry-After"] = strconv.Itoa(state.RetryAfter)
+	}
+	resp.Headers["Cache-Control"] = "no-store"
+	return resp, true
+}
+
+// maintenanceResponse applies the server's maintenance switch, if any, to
+// req before routing.
+func (s *Server) maintenanceResponse(req *Request) (Response, bool) {
+	if s.maintenance == nil {
+		return Response{}, false
+	}
+	path := req.Path
+	if s.router.normalize.Policy != NormalizeOff {
+		path = CanonicalPath(path)
+	}
+	if base := s.router.basePath; base != "" {
+		path = strings.TrimPrefix(path, base)
+	}
+	return s.maintenance.check(req, path)
+}
+
+// handleAdminMaintenance handles "/admin/maintenance". GET returns the
+// current state as JSON; POST replaces it with the MaintenanceState in the
+// request body, e.g. {"enabled": true, "message": "Back soon", "retry_after": 600}.
+func (s *Server) handleAdminMaintenance(req *Request) Response {
+	if req.Method == "POST" {
+		var state MaintenanceState
+		if err := json.Unmarshal(req.Body, &state); err != nil {
+			return BadRequestErrorResponse(fmt.Errorf("invalid maintenance state: %w", err))
+		}
+		if state.RetryAfter < 0 {
+			return BadRequestErrorResponse(errors.New("retry_after must not be negative"))
+		}
+		if err := s.maintenance.Set(state); err != nil {
+			utils.Error("Failed to persist maintenance state: %v", err)
+			return InternalServerErrorResponse()
+		}
+	}
+
+	body, _ := json.Marshal(s.maintenance.State())
+	return Response{
+		Version: HTTPVersion,
+		Status:  200,
+		Reason:  "OK",
+		Headers: map[string]string{"Content-Type": "application/json", "Cache-Control": "no-store"},
+		Body:    body,
+	}
+}
+
+// AdminAuth guards admin handlers with a static bearer token, compared in
+// constant time. Requests without the token get 401.
+func AdminAuth(token string, next HandlerFunc) HandlerFunc {
+	return func(req *Request) Response {
+		given, ok := strings.CutPrefix(req.Headers["authorization"], "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			utils.Warn("Rejected admin request from %s: %s %s", req.RemoteAddr, req.Method, req.Path)
+			resp := NewHTTPError(401, "admin token required").Response()
+			resp.Headers["WWW-Authenticate"] = `Bearer realm="admin"`
+			return resp
+		}
+		return next(req)
+	}
+}
+
+// hasAnyPrefix reports whether path lies under one of prefixes, matching
+// whole segments only: "/health" covers "/health" and "/health/live" but
+// not "/healthz-admin", and "/admin/" covers "/admin" too.
+func hasAnyPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		dir := strings.TrimSuffix(prefix, "/")
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
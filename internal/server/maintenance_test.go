@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestHasAnyPrefix(t *testing.T) {
+	prefixes := []string{"/health", "/admin/", "/api/v1"}
+	for path, want := range map[string]bool{
+		"/health":        true,
+		"/health/live":   true,
+		"/healthz-admin": false,
+		"/healthcheck":   false,
+		"/admin":         true,
+		"/admin/routes":  true,
+		"/administrator": false,
+		"/api/v1/users":  true,
+		"/api/v10":       false,
+		"/":              false,
+		"/other/health":  false,
+	} {
+		if got := hasAnyPrefix(path, prefixes); got != want {
+			t.Errorf("hasAnyPrefix(%q) = %v, want %v", path, got, want)
+		}
+	}
+	if !hasAnyPrefix("/anything", []string{"/"}) {
+		t.Error(`"/" does not cover every path`)
+	}
+}
+
+func TestMaintenanceViaAdminAPI(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	t.Setenv("MAINTENANCE_ALLOW_PATHS", "/healthz,/admin/")
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	auth := map[string]string{"Authorization": "Bearer secret", "Connection": "keep-alive"}
+
+	resp := roundTrip(t, c, "POST", "/admin/maintenance", auth, []byte(`{"enabled":true,"message":"Back soon","retry_after":120}`))
+	if resp.Status != 200 {
+		t.Fatalf("POST /admin/maintenance = %d %s", resp.Status, resp.Body)
+	}
+
+	for _, tt := range []struct {
+		path   string
+		status int
+	}{
+		{"/", 503},
+		{"/healthz", 200},
+		{"/healthz-admin", 503},
+		{"/admin/maintenance", 200},
+	} {
+		headers := map[string]string{"Connection": "keep-alive"}
+		if tt.path == "/admin/maintenance" {
+			headers = auth
+		}
+		resp := roundTrip(t, c, "GET", tt.path, headers, nil)
+		if resp.Status != tt.status {
+			t.Errorf("GET %s = %d, want %d", tt.path, resp.Status, tt.status)
+		}
+		if tt.status == 503 && resp.Header("Retry-After") != "120" {
+			t.Errorf("GET %s: Retry-After = %q, want 120", tt.path, resp.Header("Retry-After"))
+		}
+	}
+
+	resp = roundTrip(t, c, "GET", "/", map[string]string{"Accept": "application/json", "Connection": "keep-alive"}, nil)
+	var problem map[string]any
+	if err := json.Unmarshal(resp.Body, &problem); err != nil || resp.Header("Content-Type") != "application/problem+json" {
+		t.Fatalf("JSON maintenance body = %s %q, want a problem document", resp.Header("Content-Type"), resp.Body)
+	}
+	if problem["detail"] != "Back soon" || problem["maintenance"] != true {
+		t.Errorf("problem document = %v, want the message and maintenance: true", problem)
+	}
+
+	resp = roundTrip(t, c, "POST", "/admin/maintenance", auth, []byte(`{"enabled":false}`))
+	if resp.Status != 200 {
+		t.Fatalf("POST /admin/maintenance = %d %s", resp.Status, resp.Body)
+	}
+	if resp := roundTrip(t, c, "GET", "/", map[string]string{"Connection": "keep-alive"}, nil); resp.Status != 200 {
+		t.Errorf("GET / after maintenance = %d, want 200", resp.Status)
+	}
+}
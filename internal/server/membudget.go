@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// SmallBodyBytes is the size up to which request bodies are buffered even
+// when the memory budget is exhausted or the heap under pressure, so that
+// ordinary API calls keep working while large uploads are shed.
+const SmallBodyBytes = 64 << 10
+
+// memoryRetryAfter is the Retry-After hint sent with a body refused for
+// lack of memory.
+const memoryRetryAfter = time.Second
+
+// errMemoryBudget refuses a request body the memory budget has no room for.
+var errMemoryBudget = NewHTTPError(503, "the server is short of memory for this request body; retry later")
+
+// MemoryBudget accounts the memory requests hold in buffers: request
+// bodies, response bodies and stream buffers, reserved while the request
+// is read and held until its response is sent. Request bodies beyond
+// SmallBodyBytes are refused once the buffered total would exceed the
+// budget, or while the heap is under pressure, see Watch; everything else
+// is only counted, so small requests and responses are never affected.
+//
+// A nil *MemoryBudget accounts nothing and refuses nothing.
+type MemoryBudget struct {
+	limit    int64 // bytes, 0 for no limit
+	used     atomic.Int64
+	pressure atomic.Bool
+}
+
+// NewMemoryBudget returns a budget of limit bytes, 0 for no limit.
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	return &MemoryBudget{limit: limit}
+}
+
+// Reserve reserves n bytes for a request body and reports whether it may
+// be buffered. It fails for a body larger than SmallBodyBytes when the
+// budget cannot hold it or the heap is under pressure; failures are
+// counted in memory_budget_rejections_total.
+func (b *MemoryBudget) Reserve(n int64) bool {
+	return b.reserve(n, n > SmallBodyBytes)
+}
+
+// reserve reserves n bytes, checking the budget and heap pressure if
+// check is set.
+func (b *MemoryBudget) reserve(n int64, check bool) bool {
+	if b == nil || n <= 0 {
+		return true
+	}
+	if check {
+		if b.pressure.Load() {
+			Metrics.Counter("memory_budget_rejections_total").Inc()
+			return false
+		}
+		for {
+			used := b.used.Load()
+			if b.limit > 0 && used+n > b.limit {
+				Metrics.Counter("memory_budget_rejections_total").Inc()
+				return false
+			}
+			if b.used.CompareAndSwap(used, used+n) {
+				Metrics.Gauge("memory_buffered_bytes").Set(used + n)
+				return true
+			}
+		}
+	}
+	b.Hold(n)
+	return true
+}
+
+// Hold counts n bytes held without checking the budget, for memory that
+// is already allocated, such as a response body. It returns n, to be
+// passed to Release.
+func (b *MemoryBudget) Hold(n int64) int64 {
+	if b == nil || n <= 0 {
+		return 0
+	}
+	Metrics.Gauge("memory_buffered_bytes").Set(b.used.Add(n))
+	return n
+}
+
+// Release returns n bytes reserved or held to the budget.
+func (b *MemoryBudget) Release(n int64) {
+	if b == nil || n <= 0 {
+		return
+	}
+	Metrics.Gauge("memory_buffered_bytes").Set(b.used.Add(-n))
+}
+
+// Used returns the bytes currently reserved or held.
+func (b *MemoryBudget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.used.Load()
+}
+
+// Watch reads the Go heap size every interval until ctx is done, and
+// puts the budget under pressure, refusing request bodies beyond
+// SmallBodyBytes, while it exceeds heapLimit bytes. memory_pressure is 1
+// while it does, and memory_pressure_events_total counts the times it
+// started.
+func (b *MemoryBudget) Watch(ctx context.Context, heapLimit uint64, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var stats runtime.MemStats
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		runtime.ReadMemStats(&stats)
+		under := stats.HeapAlloc > heapLimit
+		if b.pressure.Swap(under) == under {
+			continue
+		}
+		if under {
+			Metrics.Gauge("memory_pressure").Set(1)
+			Metrics.Counter("memory_pressure_events_total").Inc()
+			utils.Warn("Heap at %d bytes exceeds %d; refusing request bodies over %d bytes", stats.HeapAlloc, heapLimit, SmallBodyBytes)
+		} else {
+			Metrics.Gauge("memory_pressure").Set(0)
+			utils.Info("Heap back at %d bytes; accepting large request bodies again", stats.HeapAlloc)
+		}
+	}
+}
+
+// reserveBody reserves n more bytes of the request's memory budget for
+// its body, returning errMemoryBudget if there is no room.
+func (r *Request) reserveBody(n int64) error {
+	if !r.memory.reserve(n, r.memoryHeld+n > SmallBodyBytes) {
+		utils.Warn("Refusing body of %s %s at %d bytes: memory budget exhausted (%d bytes buffered)", r.Method, r.Path, r.memoryHeld+n, r.memory.Used())
+		return errMemoryBudget
+	}
+	r.memoryHeld += n
+	return nil
+}
+
+// releaseMemory returns the memory the request reserved for its body.
+func (r *Request) releaseMemory() {
+	r.memory.Release(r.memoryHeld)
+	r.memoryHeld = 0
+}
+
+// budgetWriter reserves memory for every write to w, a buffer holding a
+// request body of unknown length, and fails with errMemoryBudget when the
+// budget has no room left.
+type budgetWriter struct {
+	w   io.Writer
+	req *Request
+}
+
+func (bw *budgetWriter) Write(p []byte) (int, error) {
+	if err := bw.req.reserveBody(int64(len(p))); err != nil {
+		return 0, err
+	}
+	return bw.w.Write(p)
+}
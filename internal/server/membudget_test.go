@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	b := NewMemoryBudget(3 * SmallBodyBytes)
+
+	if !b.Reserve(2 * SmallBodyBytes) {
+		t.Fatal("first large body refused with the budget empty")
+	}
+	if b.Reserve(2 * SmallBodyBytes) {
+		t.Error("second large body accepted beyond the budget")
+	}
+	// Small bodies and held responses are counted, never refused.
+	if !b.Reserve(SmallBodyBytes) {
+		t.Error("small body refused")
+	}
+	held := b.Hold(SmallBodyBytes)
+	if got, want := b.Used(), int64(4*SmallBodyBytes); got != want {
+		t.Errorf("Used = %d, want %d", got, want)
+	}
+	if got := Metrics.Gauge("memory_buffered_bytes").Value(); got != b.Used() {
+		t.Errorf("memory_buffered_bytes = %d, want %d", got, b.Used())
+	}
+
+	b.Release(held)
+	b.Release(SmallBodyBytes)
+	b.Release(2 * SmallBodyBytes)
+	if got := b.Used(); got != 0 {
+		t.Errorf("Used after releasing everything = %d, want 0", got)
+	}
+	if !b.Reserve(2 * SmallBodyBytes) {
+		t.Error("large body refused after the budget was released")
+	}
+}
+
+func TestMemoryBudgetNil(t *testing.T) {
+	var b *MemoryBudget
+	if !b.Reserve(1 << 30) {
+		t.Error("nil budget refused a body")
+	}
+	if n := b.Hold(1 << 30); n != 0 {
+		t.Errorf("nil budget Hold = %d, want 0", n)
+	}
+	b.Release(1 << 30)
+	if n := b.Used(); n != 0 {
+		t.Errorf("nil budget Used = %d, want 0", n)
+	}
+}
+
+func TestMemoryBudgetHeapPressure(t *testing.T) {
+	b := NewMemoryBudget(0)
+	events := Metrics.Counter("memory_pressure_events_total").Value()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.Watch(ctx, 0, time.Millisecond) // any heap is over a zero limit
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		Metrics.Gauge("memory_pressure").Set(0)
+	})
+
+	waitFor(t, "heap pressure", func() bool { return b.pressure.Load() })
+	if got := Metrics.Gauge("memory_pressure").Value(); got != 1 {
+		t.Errorf("memory_pressure = %d, want 1", got)
+	}
+	if got := Metrics.Counter("memory_pressure_events_total").Value(); got != events+1 {
+		t.Errorf("memory_pressure_events_total went from %d to %d, want one event", events, got)
+	}
+	if b.Reserve(SmallBodyBytes + 1) {
+		t.Error("large body accepted under heap pressure")
+	}
+	if !b.Reserve(SmallBodyBytes) {
+		t.Error("small body refused under heap pressure")
+	}
+}
+
+func TestMemoryBudgetRefusesBodyUnread(t *testing.T) {
+	_, addr := startServerWithClock(t, func(cfg *config.Config) {
+		cfg.BatchEnabled = true
+		cfg.MaxBufferedBytes = 2 * SmallBodyBytes
+	})
+	c := dial(t, addr)
+
+	// Only the headers are sent: the body is refused before it is read.
+	length := strconv.Itoa(3 * SmallBodyBytes)
+	resp := roundTrip(t, c, "POST", "/batch", map[string]string{"Content-Length": length}, nil)
+	if resp.Status != 503 {
+		t.Fatalf("status = %d, want 503", resp.Status)
+	}
+	if got := resp.Header("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+	if err := c.ExpectClose(); err != nil {
+		t.Error(err)
+	}
+
+	c = dial(t, addr)
+	resp = roundTrip(t, c, "POST", "/batch", nil, []byte(`[{"path":"/"}]`))
+	if resp.Status != 200 {
+		t.Errorf("small batch after the refusal: status = %d, want 200", resp.Status)
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MethodMode decides which request methods the router accepts.
+type MethodMode int
+
+const (
+	// MethodsLenient routes any method; unknown ones simply find no route.
+	// It is the default.
+	MethodsLenient MethodMode = iota
+
+	// MethodsStrict answers methods that are not valid tokens with 400 and
+	// methods outside the standard set and RegisterMethod with 501.
+	MethodsStrict
+)
+
+// standardMethods are the methods defined by RFC 9110 and RFC 5789.
+var standardMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "DELETE": true,
+	"CONNECT": true, "OPTIONS": true, "TRACE": true, "PATCH": true,
+}
+
+// ParseMethodMode converts "lenient" or "strict" into a MethodMode.
+func ParseMethodMode(name string) (MethodMode, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "lenient", "":
+		return MethodsLenient, nil
+	case "strict":
+		return MethodsStrict, nil
+	default:
+		return 0, fmt.Errorf("unknown method mode %q", name)
+	}
+}
+
+// SetMethodMode sets how the router treats unknown request methods.
+func (r *Router) SetMethodMode(mode MethodMode) {
+	r.methodMode = mode
+}
+
+// RegisterMethod adds a custom method, such as WebDAV's "PROPFIND", to the
+// set accepted in strict mode. Routes may use it like any standard method.
+//
+// Example:
+//
+//	router.RegisterMethod("PROPFIND")
+//	router.HandlePrefix("/files/", "PROPFIND", handlePropfind)
+func (r *Router) RegisterMethod(name string) {
+	if r.customMethods == nil {
+		r.customMethods = make(map[string]bool)
+	}
+	r.customMethods[strings.ToUpper(name)] = true
+}
+
+// knownMethod reports whether method is standard or registered.
+func (r *Router) knownMethod(method string) bool {
+	method = strings.ToUpper(method)
+	return standardMethods[method] || r.customMethods[method]
+}
+
+// checkMethod applies the method mode to req. It returns a non-nil error
+// response if the request must be rejected.
+func (r *Router) checkMethod(req *Request) *Response {
+	if r.methodMode != MethodsStrict {
+		return nil
+	}
+	if !isToken(req.Method) {
+		resp := NewHTTPError(400, fmt.Sprintf("invalid method %q", req.Method)).Response()
+		return &resp
+	}
+	if !r.knownMethod(req.Method) {
+		resp := NewHTTPError(501, fmt.Sprintf("method %s is not supported", req.Method)).Response()
+		return &resp
+	}
+	return nil
+}
+
+// allowedMethods lists the methods of every route matching path, plus
+// OPTIONS, and HEAD wherever GET is allowed, in sorted order. It returns nil if no route matches the path at
+// all. Routes registered without a method are skipped, since they accept
+// every method and never leave a request unmatched.
+func (r *Router) allowedMethods(path string) []string {
+	seen := make(map[string]bool)
+	for _, route := range r.routes {
+		if route.method != "" && routeMatchesPath(route, path) {
+			seen[route.method] = true
+		}
+	}
+	for _, group := range r.groups {
+		for _, route := range group.routes {
+			if route.method != "" && route.pattern == path {
+				seen[route.method] = true
+			}
+		}
+	}
+	if len(seen) == 0 {
+		return nil
+	}
+	seen["OPTIONS"] = true
+	if seen["GET"] {
+		seen["HEAD"] = true
+	}
+
+	methods := make([]string, 0, len(seen))
+	for method := range seen {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// routeMatchesPath reports whether route's pattern matches path, ignoring
+// its method.
+func routeMatchesPath(route *Route, path string) bool {
+	switch {
+	case route.regex != nil:
+		return route.regex.MatchString(path)
+	case hasParams(route.pattern):
+		return extractParams(route.pattern, path, nil) != nil
+	case route.isPrefix:
+		return strings.HasPrefix(path, route.pattern)
+	default:
+		return route.pattern == path
+	}
+}
+
+// isToken reports whether s is a non-empty RFC 9110 token.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
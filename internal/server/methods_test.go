@@ -0,0 +1,123 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+// methodRouter returns a router in mode with GET and POST on /a and a
+// PROPFIND route registered as a custom method.
+func methodRouter(mode MethodMode) *Router {
+	ok := func(req *Request) Response { return textResponse(req.Method) }
+	r := NewRouter()
+	r.SetMethodMode(mode)
+	r.RegisterMethod("propfind")
+	r.Handle("/a", "GET", ok)
+	r.Handle("/a", "POST", ok)
+	r.Handle("/dav", "PROPFIND", ok)
+	return r
+}
+
+func routeMethod(r *Router, method, target string) Response {
+	req := &Request{Method: method, Headers: map[string]string{}}
+	req.setTarget(target)
+	return r.Route(req)
+}
+
+func TestStrictMethods(t *testing.T) {
+	r := methodRouter(MethodsStrict)
+	for _, tt := range []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/a", 200},
+		{"POST", "/a", 200},
+		{"PROPFIND", "/dav", 200},
+		// Known methods without a route keep their usual answers.
+		{"PUT", "/a", 405},
+		{"GET", "/missing", 404},
+		{"PROPFIND", "/a", 405},
+		// Valid tokens outside the known set are not implemented.
+		{"GETT", "/a", 501},
+		{"BREW", "/missing", 501},
+		{"MKCOL", "/dav", 501},
+		// Anything that is not a token is malformed.
+		{"GE(T", "/a", 400},
+		{"GET/1", "/a", 400},
+		{"G\x00T", "/a", 400},
+		{"GÉT", "/a", 400},
+		{"", "/a", 400},
+	} {
+		resp := routeMethod(r, tt.method, tt.path)
+		if resp.Status != tt.status {
+			t.Errorf("%q %s = %d %q, want %d", tt.method, tt.path, resp.Status, resp.Body, tt.status)
+		}
+	}
+}
+
+func TestLenientMethods(t *testing.T) {
+	r := methodRouter(MethodsLenient)
+	for _, tt := range []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/a", 200},
+		{"PROPFIND", "/dav", 200},
+		{"GETT", "/a", 405},
+		{"GE(T", "/a", 405},
+		{"BREW", "/missing", 404},
+	} {
+		if resp := routeMethod(r, tt.method, tt.path); resp.Status != tt.status {
+			t.Errorf("%q %s = %d %q, want %d", tt.method, tt.path, resp.Status, resp.Body, tt.status)
+		}
+	}
+}
+
+func TestCustomMethodsInAllow(t *testing.T) {
+	r := methodRouter(MethodsStrict)
+	r.Handle("/dav", "GET", func(*Request) Response { return textResponse("") })
+
+	resp := routeMethod(r, "OPTIONS", "/dav")
+	if resp.Status != 204 || resp.Headers["Allow"] != "GET, HEAD, OPTIONS, PROPFIND" {
+		t.Errorf("OPTIONS /dav = %d Allow %q, want 204 listing PROPFIND", resp.Status, resp.Headers["Allow"])
+	}
+	resp = routeMethod(r, "DELETE", "/dav")
+	if resp.Status != 405 || !strings.Contains(resp.Headers["Allow"], "PROPFIND") {
+		t.Errorf("DELETE /dav = %d Allow %q, want 405 listing PROPFIND", resp.Status, resp.Headers["Allow"])
+	}
+}
+
+func TestParseMethodMode(t *testing.T) {
+	for in, want := range map[string]MethodMode{"": MethodsLenient, "lenient": MethodsLenient, " Strict ": MethodsStrict} {
+		if got, err := ParseMethodMode(in); err != nil || got != want {
+			t.Errorf("ParseMethodMode(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseMethodMode("loose"); err == nil {
+		t.Error(`ParseMethodMode("loose") succeeded`)
+	}
+}
+
+func TestServeStrictMethods(t *testing.T) {
+	t.Setenv("METHOD_MODE", "strict")
+	_, addr := startServer(t)
+	for _, tt := range []struct {
+		method string
+		status int
+	}{
+		{"GETT", 501},
+		{"GE(T", 400},
+	} {
+		c := dial(t, addr)
+		if err := c.SendRaw([]byte(tt.method + " / HTTP/1.1\r\nHost: x\r\n\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.ReadResponse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != tt.status {
+			t.Errorf("%s / = %d, want %d", tt.method, resp.Status, tt.status)
+		}
+	}
+}
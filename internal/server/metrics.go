@@ -0,0 +1,365 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Counter is a monotonically increasing integer metric, such as a number
+// of requests served. It is safe for concurrent use. Values that go down
+// are Gauges.
+type Counter struct {
+	v atomic.Int64
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+// Add adds n, which must not be negative, to the counter.
+func (c *Counter) Add(n int64) {
+	c.v.Add(n)
+}
+
+// Value returns the current counter value.
+func (c *Counter) Value() int64 {
+	return c.v.Load()
+}
+
+// Gauge is an integer metric that goes up and down, such as a number of
+// open streams or the size of a cache. It is safe for concurrent use.
+type Gauge struct {
+	v atomic.Int64
+}
+
+// Set replaces the gauge's value.
+func (g *Gauge) Set(n int64) {
+	g.v.Store(n)
+}
+
+// Add adds n, which may be negative, to the gauge.
+func (g *Gauge) Add(n int64) {
+	g.v.Add(n)
+}
+
+// Inc adds one to the gauge.
+func (g *Gauge) Inc() {
+	g.v.Add(1)
+}
+
+// Dec subtracts one from the gauge.
+func (g *Gauge) Dec() {
+	g.v.Add(-1)
+}
+
+// Value returns the gauge's current value.
+func (g *Gauge) Value() int64 {
+	return g.v.Load()
+}
+
+// MetricsRegistry holds named counters, gauges and histograms created on
+// first use.
+type MetricsRegistry struct {
+	mu         sync.Mutex
+	counters   map[string]*Counter
+	gauges     map[string]*Gauge
+	histograms map[string]*Histogram
+	collectors map[string]func() map[string]int64 // see Collect
+}
+
+// Metrics is the process-wide registry used by the server's subsystems.
+var Metrics = NewMetricsRegistry()
+
+// NewMetricsRegistry creates an empty registry.
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		counters:   make(map[string]*Counter),
+		gauges:     make(map[string]*Gauge),
+		histograms: make(map[string]*Histogram),
+		collectors: make(map[string]func() map[string]int64),
+	}
+}
+
+// Collect registers fn under key to compute series when metrics are
+// written, for values such as per-client statistics whose set of labels
+// keeps changing and would leave stale gauges behind. fn returns values
+// keyed by full metric name, which are exposed as gauges. Registering
+// again under key replaces fn; a nil fn removes it.
+func (m *MetricsRegistry) Collect(key string, fn func() map[string]int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if fn == nil {
+		delete(m.collectors, key)
+		return
+	}
+	m.collectors[key] = fn
+}
+
+// Counter returns the counter registered under name, creating it if needed.
+func (m *MetricsRegistry) Counter(name string) *Counter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.counters[name]
+	if !ok {
+		c = &Counter{}
+		m.counters[name] = c
+	}
+	return c
+}
+
+// Gauge returns the gauge registered under name, creating it if needed.
+func (m *MetricsRegistry) Gauge(name string) *Gauge {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	g, ok := m.gauges[name]
+	if !ok {
+		g = &Gauge{}
+		m.gauges[name] = g
+	}
+	return g
+}
+
+// Names returns the registered counter names in sorted order.
+func (m *MetricsRegistry) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.counters))
+	for name := range m.counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Snapshot returns the current value of every registered counter.
+func (m *MetricsRegistry) Snapshot() map[string]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snap := make(map[string]int64, len(m.counters))
+	for name, c := range m.counters {
+		snap[name] = c.Value()
+	}
+	return snap
+}
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the buckets
+// used by latency histograms.
+var DefaultLatencyBuckets = []float64{0.0005, 0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram counts observations into fixed buckets. It is safe for
+// concurrent use; observations only take atomic adds.
+type Histogram struct {
+	bounds []float64
+	counts []atomic.Int64 // one per bound, plus the +Inf bucket
+	count  atomic.Int64
+	sumNs  atomic.Int64 // sum of observed durations in nanoseconds
+}
+
+func newHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]atomic.Int64, len(bounds)+1)}
+}
+
+// Observe records one duration.
+func (h *Histogram) Observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(h.bounds, seconds)
+	h.counts[i].Add(1)
+	h.count.Add(1)
+	h.sumNs.Add(int64(d))
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() int64 {
+	return h.count.Load()
+}
+
+// Sum returns the total of all observed durations.
+func (h *Histogram) Sum() time.Duration {
+	return time.Duration(h.sumNs.Load())
+}
+
+// Quantile estimates the q-th quantile (0 < q <= 1) by linear
+// interpolation inside the bucket that contains it. Observations above the
+// last bound are reported as that bound. It returns 0 with no observations.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	total := h.count.Load()
+	if total == 0 {
+		return 0
+	}
+	rank := q * float64(total)
+	var cumulative float64
+	lower := 0.0
+	for i, bound := range h.bounds {
+		n := float64(h.counts[i].Load())
+		if cumulative+n >= rank && n > 0 {
+			seconds := lower + (bound-lower)*(rank-cumulative)/n
+			return time.Duration(seconds * float64(time.Second))
+		}
+		cumulative += n
+		lower = bound
+	}
+	return time.Duration(lower * float64(time.Second))
+}
+
+// cumulativeCounts returns the count of observations at or below each
+// bound, followed by the total.
+func (h *Histogram) cumulativeCounts() []int64 {
+	counts := make([]int64, len(h.counts))
+	var running int64
+	for i := range h.counts {
+		running += h.counts[i].Load()
+		counts[i] = running
+	}
+	return counts
+}
+
+// Histogram returns the latency histogram registered under name, creating
+// it with DefaultLatencyBuckets if needed. Like counters, the name may
+// carry labels, e.g. `route_duration_seconds{route="GET /user/:id"}`.
+func (m *MetricsRegistry) Histogram(name string) *Histogram {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.histograms[name]
+	if !ok {
+		h = newHistogram(DefaultLatencyBuckets)
+		m.histograms[name] = h
+	}
+	return h
+}
+
+// lookupHistogram returns the histogram registered under name without
+// creating it.
+func (m *MetricsRegistry) lookupHistogram(name string) (*Histogram, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.histograms[name]
+	return h, ok
+}
+
+// WritePrometheus writes every counter, gauge, collected series (see
+// Collect) and histogram in the Prometheus text exposition format. Series
+// are grouped into families by base name, sorted, each family introduced
+// by its # TYPE line. Histograms are written as _bucket, _sum (in seconds)
+// and _count series.
+func (m *MetricsRegistry) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	counters := make(map[string]*Counter, len(m.counters))
+	for name, c := range m.counters {
+		counters[name] = c
+	}
+	gauges := make(map[string]*Gauge, len(m.gauges))
+	for name, g := range m.gauges {
+		gauges[name] = g
+	}
+	histograms := make(map[string]*Histogram, len(m.histograms))
+	for name, h := range m.histograms {
+		histograms[name] = h
+	}
+	collectors := make([]func() map[string]int64, 0, len(m.collectors))
+	for _, key := range sortedKeys(m.collectors) {
+		collectors = append(collectors, m.collectors[key])
+	}
+	m.mu.Unlock()
+
+	families := make(map[string]*metricFamily)
+	family := func(name, kind string) *metricFamily {
+		base, _ := splitMetricName(name)
+		f, ok := families[base]
+		if !ok {
+			f = &metricFamily{kind: kind}
+			families[base] = f
+		}
+		return f
+	}
+	for _, name := range sortedKeys(counters) {
+		family(name, "counter").addf("%s %d\n", name, counters[name].Value())
+	}
+	for _, name := range sortedKeys(gauges) {
+		family(name, "gauge").addf("%s %d\n", name, gauges[name].Value())
+	}
+	for _, collect := range collectors {
+		values := collect()
+		for _, name := range sortedKeys(values) {
+			family(name, "gauge").addf("%s %d\n", name, values[name])
+		}
+	}
+	for _, name := range sortedKeys(histograms) {
+		h := histograms[name]
+		f := family(name, "histogram")
+		base, labels := splitMetricName(name)
+		counts := h.cumulativeCounts()
+		for i, bound := range h.bounds {
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			f.addf("%s_bucket%s %d\n", base, withLabel(labels, "le", le), counts[i])
+		}
+		f.addf("%s_bucket%s %d\n", base, withLabel(labels, "le", "+Inf"), counts[len(counts)-1])
+		f.addf("%s_sum%s %g\n", base, labels, h.Sum().Seconds())
+		f.addf("%s_count%s %d\n", base, labels, h.Count())
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, base := range sortedKeys(families) {
+		f := families[base]
+		fmt.Fprintf(bw, "# TYPE %s %s\n", base, f.kind)
+		bw.WriteString(f.lines.String())
+	}
+	return bw.Flush()
+}
+
+// metricFamily collects the exposition lines of the series sharing a base
+// name, which must be written together under one # TYPE line.
+type metricFamily struct {
+	kind  string // counter, gauge or histogram
+	lines strings.Builder
+}
+
+func (f *metricFamily) addf(format string, args ...any) {
+	fmt.Fprintf(&f.lines, format, args...)
+}
+
+// splitMetricName splits `name{labels}` into the base name and the label
+// block including braces, or "" if there is none.
+func splitMetricName(name string) (string, string) {
+	if i := strings.IndexByte(name, '{'); i >= 0 {
+		return name[:i], name[i:]
+	}
+	return name, ""
+}
+
+// withLabel adds key="value" to a label block.
+func withLabel(labels, key, value string) string {
+	pair := key + `="` + value + `"`
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return strings.TrimSuffix(labels, "}") + "," + pair + "}"
+}
+
+// MetricLabel formats a label value for use in a metric name, escaping
+// backslashes, quotes and newlines as the exposition format requires.
+func MetricLabel(key, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return key + `="` + value + `"`
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
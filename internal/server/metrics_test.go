@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePrometheusTypes(t *testing.T) {
+	m := NewMetricsRegistry()
+	m.Counter("requests_total").Add(3)
+	m.Counter(`errors_total{code="500"}`).Inc()
+	m.Counter(`errors_total{code="404"}`).Add(2)
+	m.Counter("errors_totalled").Inc() // sorts between the errors_total series
+	m.Gauge("cache_entries").Set(7)
+	m.Gauge("open_streams").Inc()
+	m.Gauge("open_streams").Inc()
+	m.Gauge("open_streams").Dec()
+	m.Collect("clients", func() map[string]int64 {
+		return map[string]int64{`client_in_flight{client="a"}`: 2}
+	})
+	m.Histogram(`latency_seconds{route="/"}`).Observe(time.Millisecond)
+
+	var buf bytes.Buffer
+	if err := m.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"# TYPE cache_entries gauge\ncache_entries 7\n",
+		"# TYPE client_in_flight gauge\nclient_in_flight{client=\"a\"} 2\n",
+		"# TYPE errors_total counter\nerrors_total{code=\"404\"} 2\nerrors_total{code=\"500\"} 1\n",
+		"# TYPE errors_totalled counter\nerrors_totalled 1\n",
+		"# TYPE latency_seconds histogram\nlatency_seconds_bucket{route=\"/\",le=\"0.0005\"} 0\n",
+		"latency_seconds_count{route=\"/\"} 1\n",
+		"# TYPE open_streams gauge\nopen_streams 1\n",
+		"# TYPE requests_total counter\nrequests_total 3\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "# TYPE errors_total "); n != 1 {
+		t.Errorf("errors_total has %d TYPE lines, want 1", n)
+	}
+}
+
+// parseMetrics returns the value of each series in a Prometheus text
+// exposition, skipping comments.
+func parseMetrics(t *testing.T, body []byte) map[string]int64 {
+	t.Helper()
+	values := make(map[string]int64)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if n, err := strconv.ParseInt(line[i+1:], 10, 64); err == nil {
+			values[line[:i]] = n
+		}
+	}
+	return values
+}
+
+func TestRouteMetricsSeries(t *testing.T) {
+	t.Setenv("METRICS_ENABLED", "true")
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+
+	scrape := func() map[string]int64 {
+		resp := roundTrip(t, c, "GET", "/metrics", keepAlive, nil)
+		if resp.Status != 200 {
+			t.Fatalf("GET /metrics = %d", resp.Status)
+		}
+		return parseMetrics(t, resp.Body)
+	}
+	before := scrape()
+	for _, path := range []string{"/", "/", "/user-agent", "/no/such/path"} {
+		roundTrip(t, c, "GET", path, keepAlive, nil)
+	}
+	after := scrape()
+
+	for series, want := range map[string]int64{
+		`route_requests_total{route="GET /"}`:           2,
+		`route_requests_total{route="GET /user-agent"}`: 1,
+		`route_requests_total{route="unmatched"}`:       1,
+	} {
+		if got := after[series] - before[series]; got != want {
+			t.Errorf("%s grew by %d, want %d", series, got, want)
+		}
+	}
+}
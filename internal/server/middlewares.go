@@ -1,6 +1,12 @@
 package server
 
-import "github.com/Abb133Se/httpServer/internal/utils"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
 
 func LoggingMiddleware(next HandlerFunc) HandlerFunc {
 	return func(req *Request) Response {
@@ -10,3 +16,91 @@ func LoggingMiddleware(next HandlerFunc) HandlerFunc {
 		return resp
 	}
 }
+
+// RequestIDMiddleware assigns every request an ID, taken from the client's
+// X-Request-ID header when present and generated otherwise. The ID is stored
+// under RequestIDKey and echoed in the X-Request-ID response header.
+//
+// Example:
+//
+//	func handler(req *Request) Response {
+//	    id := req.GetString(RequestIDKey)
+//	    ...
+//	}
+func RequestIDMiddleware(next HandlerFunc) HandlerFunc {
+	return func(req *Request) Response {
+		id := req.Headers["x-request-id"]
+		if id == "" {
+			id = newRequestID()
+		}
+		req.Set(RequestIDKey, id)
+
+		resp := next(req)
+		if resp.Headers == nil {
+			resp.Headers = make(map[string]string)
+		}
+		resp.Headers["X-Request-ID"] = id
+		return resp
+	}
+}
+
+// newRequestID returns a random 128-bit hex identifier.
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		utils.Warn("Failed to generate request ID: %v", err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// ClientCertMiddleware authorizes requests by verified client certificate.
+//
+// acl maps a certificate identity (subject common name or any DNS, email or
+// URI SAN) to the route prefixes it may access. Paths not covered by any
+// prefix in the ACL are public. Requests for a covered path are rejected
+// with 403 unless the request carries a verified certificate whose
+// identities grant that prefix; the granting identity is then stored under
+// PrincipalKey for the handler.
+//
+// Example:
+//
+//	router.Use(ClientCertMiddleware(map[string][]string{
+//	    "billing-service": {"/files/invoices/"},
+//	}))
+func ClientCertMiddleware(acl map[string][]string) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) Response {
+			if !aclCovers(acl, req.Path) {
+				return next(req)
+			}
+
+			for _, identity := range req.TLSState.Identities() {
+				for _, prefix := range acl[identity] {
+					if strings.HasPrefix(req.Path, prefix) {
+						req.Set(PrincipalKey, identity)
+						return next(req)
+					}
+				}
+			}
+
+			subject := "<none>"
+			if req.TLSState != nil && req.TLSState.PeerSubject != "" {
+				subject = req.TLSState.PeerSubject
+			}
+			utils.Warn("Client certificate %s not authorized for %s %s", subject, req.Method, req.Path)
+			return ForbiddenResponse()
+		}
+	}
+}
+
+// aclCovers reports whether any ACL entry protects path.
+func aclCovers(acl map[string][]string, path string) bool {
+	for _, prefixes := range acl {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
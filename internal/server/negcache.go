@@ -0,0 +1,124 @@
+package server
+
+import (
+	"container/list"
+	"errors"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/clock"
+)
+
+// Defaults for the negative cache of the files handler, see
+// FILES_NEGATIVE_CACHE_SIZE and FILES_NEGATIVE_CACHE_TTL.
+const (
+	DefaultNegativeCacheSize = 1024
+	DefaultNegativeCacheTTL  = 5 * time.Second
+)
+
+// negativeCache remembers paths recently found missing, so that floods of
+// requests for files that do not exist, as sent by crawlers and
+// vulnerability scanners, are answered without touching the filesystem.
+// It is a bounded LRU whose entries expire after a short TTL, which also
+// bounds how long a file created outside the server stays hidden. Writes
+// through the server invalidate the path at once, see forget.
+type negativeCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   list.List // front is most recently used; values are *negativeEntry
+	gen     uint64    // bumped by forget, see generation
+	clock   clock.Clock
+}
+
+type negativeEntry struct {
+	path    string
+	expires time.Time
+}
+
+// newNegativeCache creates a negative cache of size entries kept for ttl.
+// A size of 0 disables it.
+func newNegativeCache(size int, ttl time.Duration) *negativeCache {
+	return &negativeCache{size: size, ttl: ttl, entries: make(map[string]*list.Element), clock: clock.Real}
+}
+
+// setClock replaces the time source entries expire by.
+func (c *negativeCache) setClock(clk clock.Clock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clock = clk
+}
+
+// missing reports whether path is cached as missing, counting the lookup
+// as a hit or a miss.
+func (c *negativeCache) missing(path string) bool {
+	if c.size <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[path]; ok {
+		if c.clock.Now().Before(elem.Value.(*negativeEntry).expires) {
+			c.order.MoveToFront(elem)
+			Metrics.Counter("files_negative_cache_hits_total").Inc()
+			return true
+		}
+		c.remove(elem)
+	}
+	Metrics.Counter("files_negative_cache_misses_total").Inc()
+	return false
+}
+
+// generation returns a token to take before looking path up on disk and
+// pass to add, so that a lookup racing with a write never caches a path
+// the write has just created.
+func (c *negativeCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// add caches path as missing if err says it does not exist. Other errors,
+// such as a transient permission error, are not cached.
+func (c *negativeCache) add(path string, gen uint64, err error) {
+	if c.size <= 0 || !errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	if elem, ok := c.entries[path]; ok {
+		elem.Value.(*negativeEntry).expires = c.clock.Now().Add(c.ttl)
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[path] = c.order.PushFront(&negativeEntry{path: path, expires: c.clock.Now().Add(c.ttl)})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+	Metrics.Gauge("files_negative_cache_entries").Set(int64(c.order.Len()))
+}
+
+// forget drops path after a write so it is visible immediately.
+func (c *negativeCache) forget(path string) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if elem, ok := c.entries[path]; ok {
+		c.remove(elem)
+		Metrics.Gauge("files_negative_cache_entries").Set(int64(c.order.Len()))
+	}
+}
+
+// remove drops elem. c.mu must be held.
+func (c *negativeCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*negativeEntry).path)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/clock"
+)
+
+func TestNegativeCacheExpires(t *testing.T) {
+	fake := clock.NewManual(time.Unix(0, 0))
+	c := newNegativeCache(8, 5*time.Second)
+	c.setClock(fake)
+
+	c.add("/a", c.generation(), fs.ErrNotExist)
+	if !c.missing("/a") {
+		t.Fatal("fresh miss not cached")
+	}
+	fake.Advance(4 * time.Second)
+	if !c.missing("/a") {
+		t.Error("miss expired before its TTL")
+	}
+	fake.Advance(time.Second)
+	if c.missing("/a") {
+		t.Error("miss still cached after its TTL")
+	}
+}
+
+func TestNegativeCacheOnlyCachesNotExist(t *testing.T) {
+	c := newNegativeCache(8, time.Minute)
+	c.add("/denied", c.generation(), os.ErrPermission)
+	if c.missing("/denied") {
+		t.Error("a permission error was cached as missing")
+	}
+}
+
+func TestNegativeCacheForget(t *testing.T) {
+	c := newNegativeCache(8, time.Minute)
+	gen := c.generation()
+	c.add("/a", gen, fs.ErrNotExist)
+	c.forget("/a")
+	if c.missing("/a") {
+		t.Error("forgotten path still cached")
+	}
+
+	// A lookup that started before the write must not cache the path.
+	c.add("/a", gen, fs.ErrNotExist)
+	if c.missing("/a") {
+		t.Error("a lookup racing a write cached the path")
+	}
+}
+
+func TestNegativeCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newNegativeCache(2, time.Minute)
+	for _, path := range []string{"/a", "/b"} {
+		c.add(path, c.generation(), fs.ErrNotExist)
+	}
+	c.missing("/a")
+	c.add("/c", c.generation(), fs.ErrNotExist)
+	if c.missing("/b") {
+		t.Error("least recently used entry survived")
+	}
+	if !c.missing("/a") || !c.missing("/c") {
+		t.Error("recent entries were evicted")
+	}
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// NormalizePolicy says what the router does with a request whose path is
+// not in canonical form, see CanonicalPath.
+type NormalizePolicy int
+
+const (
+	// NormalizeOff routes paths as sent.
+	NormalizeOff NormalizePolicy = iota
+
+	// NormalizeRewrite routes the request by its canonical path, which
+	// middleware and the handler then see in req.Path. Suits APIs, whose
+	// clients may not follow redirects.
+	NormalizeRewrite
+
+	// NormalizeRedirect answers with a redirect to the canonical path:
+	// 301 for GET and HEAD, 308 for other methods so the body is sent
+	// again. Suits browsers, so they show and bookmark one URL.
+	NormalizeRedirect
+)
+
+// ParseNormalizePolicy parses "off", "rewrite" or "redirect".
+func ParseNormalizePolicy(s string) (NormalizePolicy, error) {
+	switch strings.ToLower(s) {
+	case "", "off":
+		return NormalizeOff, nil
+	case "rewrite":
+		return NormalizeRewrite, nil
+	case "redirect":
+		return NormalizeRedirect, nil
+	}
+	return NormalizeOff, fmt.Errorf("unknown normalization policy %q, want off, rewrite or redirect", s)
+}
+
+// NormalizeOptions configure request normalization, see
+// Router.SetNormalization.
+type NormalizeOptions struct {
+	// Policy applies to routes whose group sets none, see
+	// RouteGroup.Normalize.
+	Policy NormalizePolicy
+
+	// LowercaseHost rewrites the Host header to lower case.
+	LowercaseHost bool
+}
+
+// SetNormalization makes the router bring every request path into
+// canonical form, see CanonicalPath, before matching it to a route, so
+// that every spelling of a path gets the same route, ACL decision, rate
+// limit and priority. What happens to a request sent with another
+// spelling depends on the policy of the route it matches. A Policy of
+// NormalizeOff turns normalization off, for groups too.
+//
+// Example:
+//
+//	router.SetNormalization(server.NormalizeOptions{Policy: server.NormalizeRewrite})
+//	router.Group("/docs").Normalize(server.NormalizeRedirect)
+func (r *Router) SetNormalization(opts NormalizeOptions) {
+	r.normalize = opts
+}
+
+// Normalize sets the normalization policy of the group's routes, while
+// the router normalizes paths at all, see Router.SetNormalization.
+func (g *RouteGroup) Normalize(policy NormalizePolicy) *RouteGroup {
+	g.normalize = &policy
+	return g
+}
+
+// normalizeRequest brings req's path, and its host if configured, into
+// canonical form, keeping the path as sent for normalizeRedirect. It runs
+// before the base path is stripped.
+func (r *Router) normalizeRequest(req *Request) {
+	if r.normalize.Policy == NormalizeOff {
+		return
+	}
+	if r.normalize.LowercaseHost {
+		if host, ok := req.Headers["host"]; ok {
+			req.Headers["host"] = strings.ToLower(host)
+		}
+	}
+	if canonical := CanonicalPath(req.Path); canonical != req.Path {
+		Metrics.Counter("router_paths_normalized_total").Inc()
+		utils.Debug("Normalized path %q to %q", req.Path, canonical)
+		req.sentPath = req.Path
+		req.Path = canonical
+	}
+}
+
+// normalizeRedirect returns the redirect to the canonical path for a
+// request matched to route that was sent with another spelling, if the
+// route's policy asks for one.
+func (r *Router) normalizeRedirect(req *Request, route *Route) *Response {
+	if req.sentPath == "" {
+		return nil
+	}
+	policy := r.normalize.Policy
+	if route != nil && route.group != nil && route.group.normalize != nil {
+		policy = *route.group.normalize
+	}
+	if policy != NormalizeRedirect {
+		return nil
+	}
+	status, reason := 308, "Permanent Redirect"
+	if method := strings.ToUpper(req.Method); method == "GET" || method == "HEAD" {
+		status, reason = 301, "Moved Permanently"
+	}
+	location := req.basePath + req.Path
+	if req.basePath != "" && req.Path == "/" && !strings.HasSuffix(req.sentPath, "/") {
+		location = req.basePath
+	}
+	if req.RawQuery != "" {
+		location += "?" + req.RawQuery
+	}
+	return &Response{
+		Version: HTTPVersion,
+		Status:  status,
+		Reason:  reason,
+		Headers: map[string]string{"Location": location, "Content-Type": "text/plain"},
+		Body:    []byte(reason),
+	}
+}
+
+// CanonicalPath returns the canonical form of a request path, following
+// RFC 3986 section 6.2.2: percent-encoded unreserved characters (letters,
+// digits, "-", ".", "_" and "~") are decoded and other percent-encodings
+// get upper-case hex digits, so reserved characters such as an encoded
+// "/" stay encoded; runs of slashes are collapsed; and "." and ".."
+// segments are resolved, never climbing above the root. A trailing slash
+// is kept.
+//
+// Example:
+//
+//	CanonicalPath("/files//a/./b/%7euser/%2f") // "/files/a/b/~user/%2F"
+func CanonicalPath(path string) string {
+	if path == "" || path[0] != '/' {
+		// "*" and absolute-form targets are left alone.
+		return path
+	}
+	var b strings.Builder
+	b.Grow(len(path))
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c != '%' || i+2 >= len(path) || !isHex(path[i+1]) || !isHex(path[i+2]) {
+			b.WriteByte(c)
+			continue
+		}
+		decoded := unhex(path[i+1])<<4 | unhex(path[i+2])
+		if isUnreserved(decoded) {
+			b.WriteByte(decoded)
+		} else {
+			b.WriteByte('%')
+			b.WriteString(strings.ToUpper(path[i+1 : i+3]))
+		}
+		i += 2
+	}
+
+	segments := strings.Split(b.String(), "/")
+	out := make([]string, 0, len(segments))
+	trailing := false
+	for _, segment := range segments[1:] {
+		trailing = segment == "" || segment == "." || segment == ".."
+		switch segment {
+		case "", ".":
+		case "..":
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+		default:
+			out = append(out, segment)
+		}
+	}
+	canonical := "/" + strings.Join(out, "/")
+	if trailing && len(out) > 0 {
+		canonical += "/"
+	}
+	return canonical
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// isUnreserved reports whether c is an RFC 3986 unreserved character.
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
@@ -0,0 +1,226 @@
+package server
+
+import "testing"
+
+func TestCanonicalPath(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"/", "/"},
+		{"/files/a/b", "/files/a/b"},
+		{"/files//a/./b/%7euser/%2f", "/files/a/b/~user/%2F"},
+
+		// Duplicate slashes.
+		{"//files///a", "/files/a"},
+		{"//", "/"},
+		{"/files/a//", "/files/a/"},
+
+		// Dot segments, never above the root.
+		{"/files/./a", "/files/a"},
+		{"/files/x/../a", "/files/a"},
+		{"/files/a/.", "/files/a/"},
+		{"/files/a/..", "/files/"},
+		{"/files/..", "/"},
+		{"/../../etc/passwd", "/etc/passwd"},
+		{"/files/...", "/files/..."},
+		{"/files/..a/.b", "/files/..a/.b"},
+
+		// Percent-encoded unreserved characters are decoded, dots included,
+		// so their segments resolve like plain ones.
+		{"/files/a/%62", "/files/a/b"},
+		{"/files/%41%2d%5F%7E%30", "/files/A-_~0"},
+		{"/public/%2e%2e/admin", "/admin"},
+		{"/public/%2E/admin", "/public/admin"},
+
+		// Reserved and other characters stay encoded, with upper-case hex.
+		{"/files/a%2fb", "/files/a%2Fb"},
+		{"/files/a%2Fb/..", "/files/"},
+		{"/files/r%c3%a9sum%c3%a9", "/files/r%C3%A9sum%C3%A9"},
+		{"/files/a%20b", "/files/a%20b"},
+		{"/files/%3F%23", "/files/%3F%23"},
+
+		// Malformed escapes are left as sent.
+		{"/files/%zz", "/files/%zz"},
+		{"/files/%4", "/files/%4"},
+		{"/files/%", "/files/%"},
+
+		// Not origin-form.
+		{"*", "*"},
+		{"", ""},
+		{"http://example.com//a", "http://example.com//a"},
+	}
+	for _, tt := range tests {
+		got := CanonicalPath(tt.path)
+		if got != tt.want {
+			t.Errorf("CanonicalPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+		if again := CanonicalPath(got); again != got {
+			t.Errorf("CanonicalPath(%q) = %q, not a fixed point", got, again)
+		}
+	}
+}
+
+func TestParseNormalizePolicy(t *testing.T) {
+	for s, want := range map[string]NormalizePolicy{
+		"":         NormalizeOff,
+		"off":      NormalizeOff,
+		"rewrite":  NormalizeRewrite,
+		"Redirect": NormalizeRedirect,
+	} {
+		if got, err := ParseNormalizePolicy(s); err != nil || got != want {
+			t.Errorf("ParseNormalizePolicy(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseNormalizePolicy("canonical"); err == nil {
+		t.Error("ParseNormalizePolicy(\"canonical\") succeeded, want an error")
+	}
+}
+
+// normalizeRouter returns a router normalizing with opts, whose routes
+// answer with the path and host they saw.
+func normalizeRouter(opts NormalizeOptions) *Router {
+	seen := func(req *Request) Response {
+		return textResponse(req.Path + " " + req.Params["name"] + " " + req.Headers["host"] + " " + req.RawQuery)
+	}
+	r := NewRouter()
+	r.SetNormalization(opts)
+	r.Handle("/", "GET", seen)
+	r.Handle("/files/:name", "GET", seen)
+	r.Handle("/files/:name", "POST", seen)
+	r.Group("/docs").Normalize(NormalizeRedirect).Handle("/a", seen)
+	r.Group("/api").Normalize(NormalizeRewrite).Handle("/a", seen)
+	r.Group("/admin").Use(requireToken).Handle("/x", seen)
+	return r
+}
+
+// routeHost routes method target on r with the given Host header.
+func routeHost(r *Router, method, target, host string) Response {
+	req := &Request{Method: method, Headers: map[string]string{"host": host}}
+	req.setTarget(target)
+	return r.Route(req)
+}
+
+func TestNormalizeRequest(t *testing.T) {
+	r := normalizeRouter(NormalizeOptions{Policy: NormalizeRewrite})
+	normalized := Metrics.Counter("router_paths_normalized_total")
+
+	for _, tt := range []struct {
+		target string
+		want   string
+		counts bool
+	}{
+		{"/files/a", "/files/a a Host.example ", false},
+		{"/files//a", "/files/a a Host.example ", true},
+		{"/files/./a", "/files/a a Host.example ", true},
+		{"/files/x/../a", "/files/a a Host.example ", true},
+		{"/files/%61", "/files/a a Host.example ", true},
+		{"//files/a?x=%2F&y=1", "/files/a a Host.example x=%2F&y=1", true},
+		{"/files/a/b/../../c/../a", "/files/a a Host.example ", true},
+		// An encoded slash stays part of the segment.
+		{"/files/x%2f..", "/files/x%2F.. x%2F.. Host.example ", true},
+	} {
+		before := normalized.Value()
+		resp := routeHost(r, "GET", tt.target, "Host.example")
+		if resp.Status != 200 || string(resp.Body) != tt.want {
+			t.Errorf("GET %s = %d %q, want 200 %q", tt.target, resp.Status, resp.Body, tt.want)
+		}
+		if grew := normalized.Value() > before; grew != tt.counts {
+			t.Errorf("GET %s: router_paths_normalized_total grew = %v, want %v", tt.target, grew, tt.counts)
+		}
+	}
+
+	t.Run("lowercase host", func(t *testing.T) {
+		r := normalizeRouter(NormalizeOptions{Policy: NormalizeRewrite, LowercaseHost: true})
+		if resp := routeHost(r, "GET", "/files/a", "Host.EXAMPLE:8080"); string(resp.Body) != "/files/a a host.example:8080 " {
+			t.Errorf("body = %q, want the host lower-cased", resp.Body)
+		}
+	})
+
+	t.Run("off", func(t *testing.T) {
+		r := normalizeRouter(NormalizeOptions{Policy: NormalizeOff, LowercaseHost: true})
+		before := normalized.Value()
+		if resp := routeHost(r, "GET", "/files/%61", "Host.example"); string(resp.Body) != "/files/%61 %61 Host.example " {
+			t.Errorf("GET /files/%%61 = %d %q, want the path and host as sent", resp.Status, resp.Body)
+		}
+		if resp := routeHost(r, "GET", "/files//a", "Host.example"); resp.Status == 200 {
+			t.Errorf("GET /files//a = %d %q, want it unrouted without normalization", resp.Status, resp.Body)
+		}
+		if resp := routeHost(r, "GET", "/docs//a", "Host.example"); resp.Status == 301 {
+			t.Error("group policy applied with normalization off")
+		}
+		if normalized.Value() != before {
+			t.Error("router_paths_normalized_total grew with normalization off")
+		}
+	})
+
+	t.Run("ACL on the canonical path", func(t *testing.T) {
+		// Spellings of an /admin path reach the group's middleware, rather
+		// than slipping past it to another route or a 404.
+		for _, target := range []string{"/admin/x", "/admin//x", "/public/../admin/x", "/%61dmin/x", "/x/%2e%2e/admin/x"} {
+			if resp := routeHost(r, "GET", target, "Host.example"); resp.Status != 401 {
+				t.Errorf("GET %s = %d, want the group's 401", target, resp.Status)
+			}
+		}
+	})
+}
+
+func TestNormalizeRedirect(t *testing.T) {
+	r := normalizeRouter(NormalizeOptions{Policy: NormalizeRedirect})
+	for _, tt := range []struct {
+		method, target string
+		status         int
+		location       string
+	}{
+		{"GET", "/files/a", 200, ""},
+		{"GET", "/files//a", 301, "/files/a"},
+		{"GET", "/files/x/../a?x=1&y=%2F", 301, "/files/a?x=1&y=%2F"},
+		{"GET", "/files/%61", 301, "/files/a"},
+		{"HEAD", "/files//a", 301, "/files/a"},
+		{"POST", "/files//a", 308, "/files/a"},
+		{"GET", "//", 301, "/"},
+		// Only requests that match a route are redirected.
+		{"GET", "/nowhere//a", 404, ""},
+		{"DELETE", "/files//a", 405, ""},
+		// Group policies override the router's.
+		{"GET", "/docs//a", 301, "/docs/a"},
+		{"GET", "/api//a", 200, ""},
+	} {
+		resp := routeMethod(r, tt.method, tt.target)
+		if resp.Status != tt.status || resp.Headers["Location"] != tt.location {
+			t.Errorf("%s %s = %d to %q, want %d to %q", tt.method, tt.target, resp.Status, resp.Headers["Location"], tt.status, tt.location)
+		}
+	}
+
+	t.Run("group redirect under a rewriting router", func(t *testing.T) {
+		r := normalizeRouter(NormalizeOptions{Policy: NormalizeRewrite})
+		if resp := routeGET(r, "/docs/./a"); resp.Status != 301 || resp.Headers["Location"] != "/docs/a" {
+			t.Errorf("GET /docs/./a = %d to %q, want 301 to /docs/a", resp.Status, resp.Headers["Location"])
+		}
+		if resp := routeGET(r, "/files/./a"); resp.Status != 200 {
+			t.Errorf("GET /files/./a = %d, want it rewritten", resp.Status)
+		}
+	})
+
+	t.Run("base path", func(t *testing.T) {
+		r := normalizeRouter(NormalizeOptions{Policy: NormalizeRedirect})
+		r.SetBasePath("/svc")
+		for _, tt := range []struct {
+			target, location string
+		}{
+			{"/svc//files/a", "/svc/files/a"},
+			{"//svc/files/a?x=1", "/svc/files/a?x=1"},
+			{"/svc/x/../files/a", "/svc/files/a"},
+			{"/svc/.", "/svc"},
+			{"/svc/./", "/svc/"},
+			{"/svc//", "/svc/"},
+		} {
+			resp := routeGET(r, tt.target)
+			if resp.Status != 301 || resp.Headers["Location"] != tt.location {
+				t.Errorf("GET %s = %d to %q, want 301 to %q", tt.target, resp.Status, resp.Headers["Location"], tt.location)
+			}
+		}
+		if resp := routeGET(r, "/other/../svc/files/a"); resp.Status != 301 || resp.Headers["Location"] != "/svc/files/a" {
+			t.Errorf("GET /other/../svc/files/a = %d to %q, want 301 to /svc/files/a", resp.Status, resp.Headers["Location"])
+		}
+	})
+}
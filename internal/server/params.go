@@ -0,0 +1,240 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sentinel errors wrapped by ParamError. Use errors.Is to distinguish a
+// parameter that was not supplied from one that could not be converted.
+var (
+	ErrParamMissing = errors.New("missing parameter")
+	ErrParamInvalid = errors.New("invalid parameter")
+)
+
+// ParamError describes a path or query parameter that is missing or could
+// not be converted to the requested type.
+//
+// Its message names the offending parameter so it can be returned to the
+// client as-is in a 400 response.
+type ParamError struct {
+	Source string // "path" or "query"
+	Name   string
+	Value  string
+	Err    error // ErrParamMissing or ErrParamInvalid
+	Reason string
+}
+
+func (e *ParamError) Error() string {
+	if errors.Is(e.Err, ErrParamMissing) {
+		return fmt.Sprintf("%s parameter %q is required", e.Source, e.Name)
+	}
+	return fmt.Sprintf("%s parameter %q has invalid value %q: %s", e.Source, e.Name, e.Value, e.Reason)
+}
+
+func (e *ParamError) Unwrap() error {
+	return e.Err
+}
+
+// ParamInt returns the named path parameter converted to an int.
+func (r *Request) ParamInt(name string) (int, error) {
+	raw, err := r.pathParam(name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, invalidParam("path", name, raw, err)
+	}
+	return n, nil
+}
+
+// ParamInt64 returns the named path parameter converted to an int64.
+func (r *Request) ParamInt64(name string) (int64, error) {
+	raw, err := r.pathParam(name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, invalidParam("path", name, raw, err)
+	}
+	return n, nil
+}
+
+// ParamUUID returns the named path parameter validated as a canonical
+// 8-4-4-4-12 hex UUID, normalized to lowercase.
+func (r *Request) ParamUUID(name string) (string, error) {
+	raw, err := r.pathParam(name)
+	if err != nil {
+		return "", err
+	}
+	if !isUUID(raw) {
+		return "", &ParamError{Source: "path", Name: name, Value: raw, Err: ErrParamInvalid, Reason: "not a UUID"}
+	}
+	return strings.ToLower(raw), nil
+}
+
+// QueryInt returns the named query parameter converted to an int.
+//
+// If the parameter is absent, def is returned with a nil error. If it is
+// present but malformed (including empty), def is returned with a *ParamError.
+// A repeated parameter is resolved by the route's QueryPolicy (first value
+// by default), as for every single-value Query helper.
+func (r *Request) QueryInt(name string, def int) (int, error) {
+	raw, ok, err := r.queryParam(name)
+	if err != nil || !ok {
+		return def, err
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return def, invalidParam("query", name, raw, err)
+	}
+	return n, nil
+}
+
+// QueryBool returns the named query parameter converted to a bool.
+//
+// Accepted values are those understood by strconv.ParseBool. A key present
+// with no value (e.g. "?verbose") is treated as true.
+func (r *Request) QueryBool(name string, def bool) (bool, error) {
+	raw, ok, err := r.queryParam(name)
+	if err != nil || !ok {
+		return def, err
+	}
+	if raw == "" {
+		return true, nil
+	}
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		return def, invalidParam("query", name, raw, err)
+	}
+	return b, nil
+}
+
+// QueryTime returns the named query parameter parsed with the given layout.
+// A missing parameter yields a *ParamError wrapping ErrParamMissing.
+func (r *Request) QueryTime(name, layout string) (time.Time, error) {
+	raw, ok, err := r.queryParam(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !ok {
+		return time.Time{}, &ParamError{Source: "query", Name: name, Err: ErrParamMissing}
+	}
+	t, err := time.Parse(layout, raw)
+	if err != nil {
+		return time.Time{}, &ParamError{Source: "query", Name: name, Value: raw, Err: ErrParamInvalid, Reason: "expected layout " + layout}
+	}
+	return t, nil
+}
+
+// QueryStrings returns every value supplied for a repeated query key, in
+// the order they appear, regardless of the duplicate QueryPolicy. It returns
+// nil if the key is absent.
+func (r *Request) QueryStrings(name string) []string {
+	return r.queryValues()[name]
+}
+
+// pathParam returns a path parameter or a ParamError if it is missing.
+func (r *Request) pathParam(name string) (string, error) {
+	raw, ok := r.Params[name]
+	if !ok {
+		return "", &ParamError{Source: "path", Name: name, Err: ErrParamMissing}
+	}
+	return raw, nil
+}
+
+// queryParam returns the value of a query parameter chosen by the request's
+// QueryPolicy and whether the key was present at all. Under QueryCollectAll
+// a repeated key yields a *ParamError.
+func (r *Request) queryParam(name string) (string, bool, error) {
+	values, ok := r.queryValues()[name]
+	if !ok || len(values) == 0 {
+		return "", false, nil
+	}
+	switch {
+	case len(values) == 1:
+		return values[0], true, nil
+	case r.queryPolicy == QueryLastWins:
+		return values[len(values)-1], true, nil
+	case r.queryPolicy == QueryCollectAll || r.queryPolicy == QueryReject:
+		return "", true, &ParamError{Source: "query", Name: name, Value: strings.Join(values, ","), Err: ErrParamInvalid, Reason: "repeated parameter"}
+	default:
+		return values[0], true, nil
+	}
+}
+
+// QueryParam returns the first value of the query parameter name, or ""
+// if it is absent.
+func (r *Request) QueryParam(name string) string {
+	if values := r.queryValues()[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// setTarget splits a request target into Path, RawQuery and Query.
+func (r *Request) setTarget(target string) {
+	r.Path, r.RawQuery, _ = strings.Cut(target, "?")
+	r.Query = nil
+	r.queryValues()
+}
+
+// target returns the request target, the path and query string.
+func (r *Request) target() string {
+	if r.RawQuery == "" {
+		return r.Path
+	}
+	return r.Path + "?" + r.RawQuery
+}
+
+// queryValues returns Query, parsing it from RawQuery if it is unset.
+// Pairs with a malformed escape or a semicolon are skipped; the rest are
+// kept.
+func (r *Request) queryValues() url.Values {
+	if r.Query == nil {
+		// ParseQuery returns the well-formed pairs along with the error
+		// for the first bad one.
+		r.Query, _ = url.ParseQuery(r.RawQuery)
+	}
+	return r.Query
+}
+
+// invalidParam wraps a strconv conversion failure in a ParamError.
+func invalidParam(source, name, value string, err error) *ParamError {
+	reason := "not a valid number"
+	var numErr *strconv.NumError
+	if errors.As(err, &numErr) && errors.Is(numErr.Err, strconv.ErrRange) {
+		reason = "out of range"
+	} else if value == "" {
+		reason = "empty value"
+	} else if errors.As(err, &numErr) && numErr.Func == "ParseBool" {
+		reason = "not a boolean"
+	}
+	return &ParamError{Source: source, Name: name, Value: value, Err: ErrParamInvalid, Reason: reason}
+}
+
+// isUUID reports whether s is a UUID in canonical 8-4-4-4-12 hex form.
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+				return false
+			}
+		}
+	}
+	return true
+}
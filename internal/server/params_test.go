@@ -0,0 +1,356 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// paramRequest returns a request with the path parameter id set to id,
+// unless it is "-", and the query string query.
+func paramRequest(id, query string) *Request {
+	req := &Request{Params: map[string]string{}, RawQuery: query}
+	if id != "-" {
+		req.Params["id"] = id
+	}
+	return req
+}
+
+// checkParamErr reports whether err is nil when want is, and otherwise a
+// *ParamError wrapping want whose message contains reason.
+func checkParamErr(t *testing.T, what string, err, want error, reason string) {
+	t.Helper()
+	if want == nil {
+		if err != nil {
+			t.Errorf("%s: err = %v, want nil", what, err)
+		}
+		return
+	}
+	var paramErr *ParamError
+	if !errors.As(err, &paramErr) || !errors.Is(err, want) {
+		t.Errorf("%s: err = %v, want a *ParamError wrapping %v", what, err, want)
+		return
+	}
+	if !strings.Contains(err.Error(), reason) {
+		t.Errorf("%s: message %q does not contain %q", what, err, reason)
+	}
+}
+
+func TestParamInt(t *testing.T) {
+	for _, tt := range []struct {
+		id     string
+		want   int
+		err    error
+		reason string
+	}{
+		{"42", 42, nil, ""},
+		{"-7", -7, nil, ""},
+		{"007", 7, nil, ""},
+		{"-", 0, ErrParamMissing, `path parameter "id" is required`},
+		{"", 0, ErrParamInvalid, "empty value"},
+		{"abc", 0, ErrParamInvalid, `invalid value "abc": not a valid number`},
+		{"4.2", 0, ErrParamInvalid, "not a valid number"},
+		{"1e3", 0, ErrParamInvalid, "not a valid number"},
+		{" 1", 0, ErrParamInvalid, "not a valid number"},
+		{"99999999999999999999", 0, ErrParamInvalid, "out of range"},
+	} {
+		got, err := paramRequest(tt.id, "").ParamInt("id")
+		checkParamErr(t, "ParamInt("+tt.id+")", err, tt.err, tt.reason)
+		if got != tt.want {
+			t.Errorf("ParamInt(%q) = %d, want %d", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestParamInt64(t *testing.T) {
+	for _, tt := range []struct {
+		id     string
+		want   int64
+		err    error
+		reason string
+	}{
+		{"9223372036854775807", 9223372036854775807, nil, ""},
+		{"-9223372036854775808", -9223372036854775808, nil, ""},
+		{"9223372036854775808", 0, ErrParamInvalid, "out of range"},
+		{"-9223372036854775809", 0, ErrParamInvalid, "out of range"},
+		{"0x10", 0, ErrParamInvalid, "not a valid number"},
+		{"", 0, ErrParamInvalid, "empty value"},
+		{"-", 0, ErrParamMissing, "is required"},
+	} {
+		got, err := paramRequest(tt.id, "").ParamInt64("id")
+		checkParamErr(t, "ParamInt64("+tt.id+")", err, tt.err, tt.reason)
+		if got != tt.want {
+			t.Errorf("ParamInt64(%q) = %d, want %d", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestParamUUID(t *testing.T) {
+	for _, tt := range []struct {
+		id, want string
+		err      error
+	}{
+		{"123e4567-e89b-12d3-a456-426614174000", "123e4567-e89b-12d3-a456-426614174000", nil},
+		{"123E4567-E89B-12D3-A456-426614174000", "123e4567-e89b-12d3-a456-426614174000", nil},
+		{"123e4567e89b12d3a456426614174000", "", ErrParamInvalid},
+		{"123e4567-e89b-12d3-a456-42661417400", "", ErrParamInvalid},
+		{"123e4567-e89b-12d3-a456-4266141740000", "", ErrParamInvalid},
+		{"g23e4567-e89b-12d3-a456-426614174000", "", ErrParamInvalid},
+		{"123e4567_e89b-12d3-a456-426614174000", "", ErrParamInvalid},
+		{"{23e4567-e89b-12d3-a456-42661417400}", "", ErrParamInvalid},
+		{"", "", ErrParamInvalid},
+		{"-", "", ErrParamMissing},
+	} {
+		got, err := paramRequest(tt.id, "").ParamUUID("id")
+		checkParamErr(t, "ParamUUID("+tt.id+")", err, tt.err, "")
+		if got != tt.want {
+			t.Errorf("ParamUUID(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestQueryInt(t *testing.T) {
+	for _, tt := range []struct {
+		query  string
+		want   int
+		err    error
+		reason string
+	}{
+		{"", 10, nil, ""},
+		{"page=3", 3, nil, ""},
+		{"other=3", 10, nil, ""},
+		{"page=", 10, ErrParamInvalid, "empty value"},
+		{"page", 10, ErrParamInvalid, "empty value"},
+		{"page=three", 10, ErrParamInvalid, `query parameter "page" has invalid value "three"`},
+		{"page=%2B5", 5, nil, ""},
+		{"page=99999999999999999999", 10, ErrParamInvalid, "out of range"},
+		{"page=1&page=2", 1, nil, ""},
+	} {
+		got, err := paramRequest("-", tt.query).QueryInt("page", 10)
+		checkParamErr(t, "QueryInt("+tt.query+")", err, tt.err, tt.reason)
+		if got != tt.want {
+			t.Errorf("QueryInt(%q) = %d, want %d", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestQueryBool(t *testing.T) {
+	for _, tt := range []struct {
+		query string
+		def   bool
+		want  bool
+		err   error
+	}{
+		{"", true, true, nil},
+		{"", false, false, nil},
+		{"verbose", false, true, nil},
+		{"verbose=", false, true, nil},
+		{"verbose=true", false, true, nil},
+		{"verbose=1", false, true, nil},
+		{"verbose=F", true, false, nil},
+		{"verbose=yes", false, false, ErrParamInvalid},
+		{"verbose=2", true, true, ErrParamInvalid},
+	} {
+		got, err := paramRequest("-", tt.query).QueryBool("verbose", tt.def)
+		checkParamErr(t, "QueryBool("+tt.query+")", err, tt.err, "not a boolean")
+		if got != tt.want {
+			t.Errorf("QueryBool(%q, %v) = %v, want %v", tt.query, tt.def, got, tt.want)
+		}
+	}
+}
+
+func TestQueryTime(t *testing.T) {
+	for _, tt := range []struct {
+		query string
+		want  time.Time
+		err   error
+	}{
+		{"since=2026-03-01", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), nil},
+		{"", time.Time{}, ErrParamMissing},
+		{"since=", time.Time{}, ErrParamInvalid},
+		{"since=2026-02-30", time.Time{}, ErrParamInvalid},
+		{"since=yesterday", time.Time{}, ErrParamInvalid},
+	} {
+		got, err := paramRequest("-", tt.query).QueryTime("since", time.DateOnly)
+		reason := "expected layout " + time.DateOnly
+		if tt.err == ErrParamMissing {
+			reason = `query parameter "since" is required`
+		}
+		checkParamErr(t, "QueryTime("+tt.query+")", err, tt.err, reason)
+		if !got.Equal(tt.want) {
+			t.Errorf("QueryTime(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestQueryStrings(t *testing.T) {
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"", nil},
+		{"tag=a", []string{"a"}},
+		{"tag=a&other=x&tag=b&tag=", []string{"a", "b", ""}},
+		{"tag=a%20b&tag=c%2Cd", []string{"a b", "c,d"}},
+		{"tag=%zz", nil},
+	} {
+		if got := paramRequest("-", tt.query).QueryStrings("tag"); !slices.Equal(got, tt.want) {
+			t.Errorf("QueryStrings(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestQueryRepeatedUnderPolicy(t *testing.T) {
+	req := paramRequest("-", "page=1&page=2")
+	req.queryPolicy = QueryLastWins
+	if got, err := req.QueryInt("page", 0); err != nil || got != 2 {
+		t.Errorf("last wins: QueryInt = %d, %v; want 2", got, err)
+	}
+	req.queryPolicy = QueryCollectAll
+	if _, err := req.QueryInt("page", 0); !errors.Is(err, ErrParamInvalid) || !strings.Contains(err.Error(), "repeated parameter") {
+		t.Errorf("collect all: QueryInt err = %v, want a repeated parameter error", err)
+	}
+}
+
+func TestUserByIDParamErrors(t *testing.T) {
+	_, addr := startServer(t)
+	for _, tt := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/user/42", 200, `{"id":42}`},
+		{"/user/abc", 400, `path parameter "id" has invalid value "abc"`},
+		{"/user/99999999999999999999", 400, "out of range"},
+	} {
+		resp := roundTrip(t, dial(t, addr), "GET", tt.path, nil, nil)
+		if resp.Status != tt.status || !strings.Contains(string(resp.Body), tt.body) {
+			t.Errorf("GET %s = %d %q, want %d containing %q", tt.path, resp.Status, resp.Body, tt.status, tt.body)
+		}
+	}
+}
+
+func TestOptionalTrailingParam(t *testing.T) {
+	echo := func(req *Request) Response { return textResponse(fmt.Sprint(req.Params)) }
+	r := NewRouter()
+	r.Handle("/reports/summary", "GET", func(*Request) Response { return textResponse("summary") })
+	r.Handle("/reports/:year/:month?", "GET", echo).ParamDefault("month", "01")
+	r.Handle("/logs/:day?", "GET", echo)
+	r.Handle("/users/:id/:tab?", "GET", echo).ParamDefault("tab", "")
+
+	for _, tt := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		// Present.
+		{"/reports/2024/05", 200, "map[month:05 year:2024]"},
+		{"/logs/mon", 200, "map[day:mon]"},
+		// Absent, with a default.
+		{"/reports/2024", 200, "map[month:01 year:2024]"},
+		{"/reports/2024/", 200, "map[month:01 year:2024]"},
+		{"/users/7", 200, "map[id:7 tab:]"},
+		// Absent, without a default: missing from the map.
+		{"/logs", 200, "map[]"},
+		{"/logs/", 200, "map[]"},
+		// Required segments and extra segments still count.
+		{"/reports", 404, ""},
+		{"/reports/2024/05/extra", 404, ""},
+		{"/logs/mon/extra", 404, ""},
+		// A literal sibling registered first wins over the parameter.
+		{"/reports/summary", 200, "summary"},
+	} {
+		resp := routeGET(r, tt.path)
+		if resp.Status != tt.status || (tt.status == 200 && string(resp.Body) != tt.body) {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.path, resp.Status, resp.Body, tt.status, tt.body)
+		}
+	}
+}
+
+func TestOptionalParamDefaultsNotShared(t *testing.T) {
+	defaults := map[string]string{"month": "01"}
+	params := extractParams("/reports/:year/:month?", "/reports/2024", defaults)
+	params["month"] = "changed"
+	if defaults["month"] != "01" {
+		t.Errorf("changing a request's params changed the route default to %q", defaults["month"])
+	}
+	if params := extractParams("/reports/:year/:month?", "/reports/2024/05", defaults); params["month"] != "05" {
+		t.Errorf("month = %q, want the path's value over the default", params["month"])
+	}
+}
+
+func TestSetTargetQuery(t *testing.T) {
+	for _, tt := range []struct {
+		target, path, rawQuery string
+		query                  string // fmt.Sprint of Query
+	}{
+		{"/files/report.txt?download=1", "/files/report.txt", "download=1", "map[download:[1]]"},
+		{"/s?q=hello%20world", "/s", "q=hello%20world", "map[q:[hello world]]"},
+		{"/s?q=a+b", "/s", "q=a+b", "map[q:[a b]]"},
+		{"/s?q=a%2Bb", "/s", "q=a%2Bb", "map[q:[a+b]]"},
+		{"/s?q=%E2%82%AC", "/s", "q=%E2%82%AC", "map[q:[\u20ac]]"},
+		{"/s?na%6De=x&a+b=c", "/s", "na%6De=x&a+b=c", "map[a b:[c] name:[x]]"},
+		{"/s?tag=a&tag=b&tag=", "/s", "tag=a&tag=b&tag=", "map[tag:[a b ]]"},
+		{"/s?flag", "/s", "flag", "map[flag:[]]"},
+		{"/s?flag=", "/s", "flag=", "map[flag:[]]"},
+		{"/s?=v", "/s", "=v", "map[:[v]]"},
+		{"/s?a=1&&b=2&", "/s", "a=1&&b=2&", "map[a:[1] b:[2]]"},
+		{"/s?q=a?b=c", "/s", "q=a?b=c", "map[q:[a?b=c]]"},
+		{"/s?", "/s", "", "map[]"},
+		{"/s", "/s", "", "map[]"},
+		{"/a%20b?x=1", "/a%20b", "x=1", "map[x:[1]]"},
+		// Malformed pairs are skipped, keeping the rest.
+		{"/s?q=%zz&ok=1", "/s", "q=%zz&ok=1", "map[ok:[1]]"},
+		{"/s?bad%=1&ok=1", "/s", "bad%=1&ok=1", "map[ok:[1]]"},
+		{"/s?a=1;b=2&ok=1", "/s", "a=1;b=2&ok=1", "map[ok:[1]]"},
+	} {
+		req := &Request{}
+		req.setTarget(tt.target)
+		if req.Path != tt.path || req.RawQuery != tt.rawQuery {
+			t.Errorf("setTarget(%q): Path, RawQuery = %q, %q; want %q, %q", tt.target, req.Path, req.RawQuery, tt.path, tt.rawQuery)
+		}
+		if got := fmt.Sprint(req.Query); got != tt.query {
+			t.Errorf("setTarget(%q): Query = %s, want %s", tt.target, got, tt.query)
+		}
+		if got := req.target(); got != strings.TrimSuffix(tt.target, "?") {
+			t.Errorf("target() = %q, want %q", got, tt.target)
+		}
+	}
+}
+
+func TestQueryParam(t *testing.T) {
+	req := &Request{}
+	req.setTarget("/s?tag=a&tag=b&empty=&q=x+y")
+	for name, want := range map[string]string{"tag": "a", "empty": "", "q": "x y", "missing": ""} {
+		if got := req.QueryParam(name); got != want {
+			t.Errorf("QueryParam(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	// Query is parsed on first use when only RawQuery is set.
+	req = &Request{RawQuery: "id=7&id=8"}
+	if got := req.QueryStrings("id"); !slices.Equal(got, []string{"7", "8"}) {
+		t.Errorf("QueryStrings(\"id\") = %q, want [7 8]", got)
+	}
+}
+
+func TestServeQueryRouting(t *testing.T) {
+	_, addr := startServerWithRoutes(t, func(r *Router) {
+		r.Handle("/search", "GET", func(req *Request) Response {
+			return textResponse(fmt.Sprintf("%q %q", req.Path, req.QueryParam("q")))
+		})
+	})
+	for target, want := range map[string]string{
+		"/search?q=a+b%21":   `"/search" "a b!"`,
+		"/search?":           `"/search" ""`,
+		"/search?q=%zz&q=ok": `"/search" "ok"`,
+	} {
+		resp := roundTrip(t, dial(t, addr), "GET", target, nil, nil)
+		if resp.Status != 200 || string(resp.Body) != want {
+			t.Errorf("GET %s = %d %s, want 200 %s", target, resp.Status, resp.Body, want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Abb133Se/httpServer/internal/filelock"
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// pathLocks serializes mutating requests per file path. Locks are created
+// on demand and dropped once no request holds or waits for them, so the
+// registry only ever holds paths being written, and writes to different
+// paths never wait on each other.
+type pathLocks struct {
+	mu    sync.Mutex
+	locks map[string]*pathLock
+}
+
+type pathLock struct {
+	mu   sync.Mutex
+	refs int // holders and waiters, guarded by pathLocks.mu
+}
+
+func newPathLocks() *pathLocks {
+	return &pathLocks{locks: make(map[string]*pathLock)}
+}
+
+// lockFilePrefix starts the names of the lock files taken next to their
+// targets by cross-process locking. Such files are never served.
+const lockFilePrefix = ".filelock-"
+
+// isLockFile reports whether name is the base name of a lock file.
+func isLockFile(name string) bool {
+	return strings.HasPrefix(name, lockFilePrefix)
+}
+
+// lockWrite serializes a write or delete of filePath with the others
+// through f, and, with cross-process locking on, with other servers
+// through a lock file next to it. It returns the function releasing both,
+// or a 423 response once the lock file has been held elsewhere for
+// f.lockTimeout.
+func (f *publicFiles) lockWrite(filePath string) (func(), *Response) {
+	unlock := f.writes.lock(filePath)
+	if f.lockTimeout <= 0 {
+		return unlock, nil
+	}
+	lockPath := filepath.Join(filepath.Dir(filePath), lockFilePrefix+filepath.Base(filePath))
+	lock, err := filelock.Acquire(lockPath, f.lockTimeout)
+	if err != nil {
+		unlock()
+		if errors.Is(err, filelock.ErrTimeout) {
+			Metrics.Counter("files_lock_timeouts_total").Inc()
+			utils.Warn("Timed out waiting for lock on %s", filePath)
+			resp := NewHTTPError(423, "the file is being modified by another server").Response()
+			resp.Headers["Retry-After"] = "1"
+			return nil, &resp
+		}
+		utils.Error("Failed to lock %s: %v", filePath, err)
+		resp := InternalServerErrorResponse()
+		return nil, &resp
+	}
+	return func() {
+		if err := lock.Release(); err != nil {
+			utils.Warn("Failed to release lock on %s: %v", filePath, err)
+		}
+		unlock()
+	}, nil
+}
+
+// lock blocks until path is free and returns the function releasing it.
+// path should be cleaned and absolute so every spelling of a file maps to
+// one lock.
+func (p *pathLocks) lock(path string) func() {
+	p.mu.Lock()
+	l, ok := p.locks[path]
+	if !ok {
+		l = &pathLock{}
+		p.locks[path] = l
+	}
+	l.refs++
+	p.mu.Unlock()
+
+	if !l.mu.TryLock() {
+		Metrics.Counter("files_write_lock_waits_total").Inc()
+		l.mu.Lock()
+	}
+	return func() {
+		l.mu.Unlock()
+		p.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(p.locks, path)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// checkWritePreconditions evaluates If-Match and If-None-Match for a
+// request about to modify filePath, against the file's current ETag. It
+// must run while the path is locked, so no other write can slip in between
+// the check and the write. It returns the 412 response for a stale writer,
+// or nil.
+//
+// "If-Match: *" requires the file to exist and "If-None-Match: *" requires
+// it not to, which lets clients create a file without overwriting one
+// created concurrently.
+func checkWritePreconditions(req *Request, filePath string) *Response {
+	ifMatch, hasIfMatch := req.Headers["if-match"]
+	ifNoneMatch, hasIfNoneMatch := req.Headers["if-none-match"]
+	if !hasIfMatch && !hasIfNoneMatch {
+		return nil
+	}
+
+	current := ""
+	info, err := os.Stat(filePath)
+	switch {
+	case err == nil && info.Mode().IsRegular():
+		current = fileETag(info)
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		resp := InternalServerErrorResponse()
+		return &resp
+	}
+
+	if hasIfMatch && !etagListMatches(ifMatch, current) {
+		Metrics.Counter("files_precondition_failed_total").Inc()
+		resp := NewHTTPError(412, fmt.Sprintf("%s does not match the current version", ifMatch)).Response()
+		return &resp
+	}
+	if hasIfNoneMatch && etagListMatches(ifNoneMatch, current) {
+		Metrics.Counter("files_precondition_failed_total").Inc()
+		resp := NewHTTPError(412, "the file already exists in the given version").Response()
+		return &resp
+	}
+	return nil
+}
+
+// etagListMatches reports whether an If-Match style list of entity tags
+// matches current, using strong comparison: weak tags never match. "*"
+// matches any existing file; current is "" for a missing file.
+func etagListMatches(list, current string) bool {
+	if current == "" {
+		return false
+	}
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
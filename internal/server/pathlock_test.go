@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/testclient"
+)
+
+// TestCrossProcessLockingBetweenServers runs two independent servers on
+// one public directory, as two processes would, and checks that a write
+// through one waits for the other's lock file rather than for in-process
+// state the two do not share.
+func TestCrossProcessLockingBetweenServers(t *testing.T) {
+	public := chdirPublic(t)
+	t.Setenv("FILES_CROSS_PROCESS_LOCKING", "true")
+	t.Setenv("FILES_LOCK_TIMEOUT", "1")
+	srvA, _ := startServer(t)
+	srvB, addrB := startServer(t)
+	if srvA.files.writes == srvB.files.writes {
+		t.Fatal("the servers share their write locks")
+	}
+	b := dial(t, addrB)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+
+	unlock, errResp := srvA.files.lockWrite(filepath.Join(public, "shared.txt"))
+	if errResp != nil {
+		t.Fatalf("locking through the first server: %d", errResp.Status)
+	}
+	resp := roundTrip(t, b, "PUT", "/files/shared.txt", keepAlive, []byte("from B"))
+	if resp.Status != 423 || resp.Header("Retry-After") != "1" {
+		t.Errorf("PUT while the other server writes = %d (Retry-After %q), want 423", resp.Status, resp.Header("Retry-After"))
+	}
+
+	unlock()
+	if resp := roundTrip(t, b, "PUT", "/files/shared.txt", keepAlive, []byte("from B")); resp.Status != 200 {
+		t.Fatalf("PUT once the lock is released = %d, want 200", resp.Status)
+	}
+	if data, err := os.ReadFile(filepath.Join(public, "shared.txt")); err != nil || string(data) != "from B" {
+		t.Errorf("file holds %q (%v), want %q", data, err, "from B")
+	}
+	if entries, _ := filepath.Glob(filepath.Join(public, lockFilePrefix+"*")); len(entries) != 0 {
+		t.Errorf("lock files left behind: %v", entries)
+	}
+}
+
+// racePUTs sends one PUT of each body to path at once, each on its own
+// connection with the given headers, and returns the statuses.
+func racePUTs(t *testing.T, addr, path string, headers map[string]string, bodies [][]byte) []int {
+	t.Helper()
+	clients := make([]*testclient.Client, len(bodies))
+	for i := range clients {
+		clients[i] = dial(t, addr)
+	}
+	start := make(chan struct{})
+	statuses := make(chan int, len(bodies))
+	for i, c := range clients {
+		go func() {
+			<-start
+			if err := c.SendRequest("PUT", path, headers, bodies[i]); err != nil {
+				statuses <- -1
+				return
+			}
+			resp, err := c.ReadResponse()
+			if err != nil {
+				statuses <- -1
+				return
+			}
+			statuses <- resp.Status
+		}()
+	}
+	close(start)
+	var got []int
+	for range bodies {
+		got = append(got, <-statuses)
+	}
+	slices.Sort(got)
+	return got
+}
+
+func TestRacingPUTsWithIfMatch(t *testing.T) {
+	public := chdirPublic(t)
+	writeTestFile(t, filepath.Join(public, "report.csv"), "v0")
+	_, addr := startServer(t)
+	etag := roundTrip(t, dial(t, addr), "GET", "/files/report.csv", nil, nil).Header("ETag")
+	if etag == "" {
+		t.Fatal("GET returned no ETag")
+	}
+
+	// Every writer read the same version; only the first to get the lock
+	// may replace it. Distinct lengths give every version its own ETag.
+	const writers = 8
+	bodies := make([][]byte, writers)
+	for i := range bodies {
+		bodies[i] = []byte(strings.Repeat(string(rune('a'+i)), 10+i))
+	}
+	got := racePUTs(t, addr, "/files/report.csv", map[string]string{"If-Match": etag}, bodies)
+	want := append([]int{200}, slices.Repeat([]int{412}, writers-1)...)
+	if !slices.Equal(got, want) {
+		t.Fatalf("statuses = %v, want one 200 and %d 412", got, writers-1)
+	}
+
+	data, err := os.ReadFile(filepath.Join(public, "report.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(bodies, func(b []byte) bool { return string(b) == string(data) }) {
+		t.Errorf("file holds %q, want one writer's body", data)
+	}
+}
+
+func TestRacingCreatesWithIfNoneMatch(t *testing.T) {
+	public := chdirPublic(t)
+	_, addr := startServer(t)
+	bodies := [][]byte{[]byte("first"), []byte("second"), []byte("third"), []byte("fourth")}
+	got := racePUTs(t, addr, "/files/new.txt", map[string]string{"If-None-Match": "*"}, bodies)
+	if want := []int{200, 412, 412, 412}; !slices.Equal(got, want) {
+		t.Fatalf("statuses = %v, want %v", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(public, "new.txt")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRacingPUTsDoNotInterleave(t *testing.T) {
+	public := chdirPublic(t)
+	_, addr := startServer(t)
+	const size = 256 << 10
+	bodies := make([][]byte, 4)
+	for i := range bodies {
+		bodies[i] = bytes.Repeat([]byte{byte('a' + i)}, size)
+	}
+	for _, status := range racePUTs(t, addr, "/files/big.bin", nil, bodies) {
+		if status != 200 {
+			t.Errorf("PUT = %d, want 200", status)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(public, "big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != size || !bytes.Equal(data, bytes.Repeat(data[:1], size)) {
+		t.Errorf("file of %d bytes mixes writers, want one writer's %d bytes", len(data), size)
+	}
+}
+
+func TestPathLocks(t *testing.T) {
+	locks := newPathLocks()
+	unlockA := locks.lock("/public/a")
+
+	// Another path does not wait for the first.
+	otherDone := make(chan struct{})
+	go func() {
+		locks.lock("/public/b")()
+		close(otherDone)
+	}()
+	select {
+	case <-otherDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("locking another path waited for /public/a")
+	}
+
+	waits := Metrics.Counter("files_write_lock_waits_total")
+	before := waits.Value()
+	sameDone := make(chan struct{})
+	go func() {
+		locks.lock("/public/a")()
+		close(sameDone)
+	}()
+	waitFor(t, "the second writer to wait", func() bool { return waits.Value() > before })
+	select {
+	case <-sameDone:
+		t.Fatal("the same path was locked twice")
+	default:
+	}
+	unlockA()
+	<-sameDone
+
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	if len(locks.locks) != 0 {
+		t.Errorf("registry holds %d locks after every release, want 0", len(locks.locks))
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import "time"
+
+// UseBeforeBody adds middleware to the pre-body phase. It runs once the
+// request headers have been read and routed but before the body is,
+// ahead of the middleware added with Use, so checks such as
+// authentication, size limits and rate limits can refuse an upload
+// without receiving it. A client that sent "Expect: 100-continue" is only
+// told to continue once every pre-body middleware has passed.
+//
+// Pre-body middleware must decide from the headers alone: req.Body is
+// still empty. A middleware passes by calling next; headers it adds to
+// the response next returns are copied onto the final response. Any
+// other response is sent instead of reading the body, and the connection
+// is closed if a body was declared.
+//
+// Example:
+//
+//	router.UseBeforeBody(RateLimitMiddleware(limiter))
+func (r *Router) UseBeforeBody(mw MiddlewareFunc) {
+	r.beforeBody = append(r.beforeBody, mw)
+}
+
+// BeforeBody adds pre-body middleware for this route only, run after the
+// router's and before the route's rate limit. See Router.UseBeforeBody.
+func (rt *Route) BeforeBody(mws ...MiddlewareFunc) *Route {
+	rt.beforeBody = append(rt.beforeBody, mws...)
+	return rt
+}
+
+// CheckBeforeBody runs the pre-body phase for a request whose headers have
+// been read but not its body: it routes the request and runs the pre-body
+// middleware. It returns the response to send instead of reading the
+// body, or nil to read it and call Route, which then skips the pre-body
+// middleware. When no route takes the request, the router's 404 or 405
+// is returned wrapped in the middleware added with Use.
+func (r *Router) CheckBeforeBody(req *Request) *Response {
+	if table := r.tableFor(req); table != r {
+		return table.CheckBeforeBody(req)
+	}
+	start := time.Now()
+	route, errResp := r.resolve(req)
+	if errResp != nil {
+		resp := r.fallback(req, errResp)
+		r.addCORSHeaders(req, &resp)
+		observeRoute(nil, time.Since(start))
+		return &resp
+	}
+	req.beforeBodyDone = true
+	rejection := r.runBeforeBody(req, route)
+	if rejection != nil {
+		r.addCORSHeaders(req, rejection)
+		observeRoute(route, time.Since(start))
+	}
+	return rejection
+}
+
+// runBeforeBody runs the router's and route's pre-body middleware around a
+// handler that only records that it was reached. It returns the response
+// of the middleware that refused req, or nil if all of them passed.
+func (r *Router) runBeforeBody(req *Request, route *Route) *Response {
+	mws := append(r.beforeBody[:len(r.beforeBody):len(r.beforeBody)], route.beforeBody...)
+	if route.rateLimit != nil {
+		mws = append(mws, route.rateLimitMiddleware)
+	}
+	if len(mws) == 0 {
+		return nil
+	}
+
+	passed := false
+	handler := func(req *Request) Response {
+		passed = true
+		return Response{Headers: make(map[string]string)}
+	}
+	for i := len(mws) - 1; i >= 0; i-- {
+		handler = mws[i](handler)
+	}
+
+	resp := handler(req)
+	if !passed {
+		Metrics.Counter("requests_rejected_before_body_total").Inc()
+		return &resp
+	}
+	req.beforeBodyHeaders = resp.Headers
+	return nil
+}
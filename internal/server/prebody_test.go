@@ -0,0 +1,252 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/testclient"
+)
+
+// requireToken is pre-body middleware refusing requests without the
+// "Authorization: Bearer secret" header.
+func requireToken(next HandlerFunc) HandlerFunc {
+	return func(req *Request) Response {
+		if req.Headers["authorization"] != "Bearer secret" {
+			return NewHTTPError(401, "missing token").Response()
+		}
+		return next(req)
+	}
+}
+
+// uploadRouter registers PUT and POST /upload behind requireToken,
+// reporting each body the handler receives on bodies.
+func uploadRouter(bodies chan<- string) func(*Router) {
+	return func(r *Router) {
+		handler := func(req *Request) Response {
+			bodies <- string(req.Body)
+			return textResponse("stored")
+		}
+		r.Handle("/upload", "PUT", handler).BeforeBody(requireToken)
+		r.Handle("/upload", "POST", handler).BeforeBody(requireToken)
+	}
+}
+
+// sendHead opens a raw connection to addr and sends a request head
+// declaring a body without sending it. It returns the connection and a
+// reader that, unlike testclient.Client, sees interim responses.
+func sendHead(t *testing.T, addr, head string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte(head)); err != nil {
+		t.Fatal(err)
+	}
+	return conn, bufio.NewReader(conn)
+}
+
+// expectClosed fails t unless the server closes conn with nothing more
+// to read.
+func expectClosed(t *testing.T, reader *bufio.Reader) {
+	t.Helper()
+	if b, err := reader.ReadByte(); err == nil {
+		t.Errorf("read %q after the response, want the connection closed", b)
+	}
+}
+
+func TestExpectContinueRejectedBeforeBody(t *testing.T) {
+	bodies := make(chan string, 1)
+	_, addr := startServerWithRoutes(t, uploadRouter(bodies))
+
+	tests := []struct {
+		name       string
+		method     string
+		headers    string
+		length     int64
+		wantStatus int
+	}{
+		{"missing token", "PUT", "", 1 << 20, 401},
+		{"wrong token", "PUT", "Authorization: Bearer guess\r\n", 1 << 20, 401},
+		{"over MaxBodySize", "POST", "Authorization: Bearer secret\r\n", MaxBodySize + 1, 413},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rejected := Metrics.Counter("requests_rejected_before_body_total")
+			before := rejected.Value()
+
+			head := fmt.Sprintf("%s /upload HTTP/1.1\r\nHost: x\r\n%sContent-Length: %d\r\nExpect: 100-continue\r\n\r\n",
+				tt.method, tt.headers, tt.length)
+			_, reader := sendHead(t, addr, head)
+
+			// The first thing back must be the final response: a 100
+			// Continue here would invite the client to send the body.
+			resp, err := testclient.ReadResponse(reader, tt.method)
+			if err != nil {
+				t.Fatalf("reading response: %v", err)
+			}
+			if resp.Status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.Status, tt.wantStatus)
+			}
+			if got := resp.Header("Connection"); !strings.EqualFold(got, "close") {
+				t.Errorf("Connection = %q, want close", got)
+			}
+			expectClosed(t, reader)
+
+			if tt.wantStatus == 401 {
+				if got := rejected.Value() - before; got != 1 {
+					t.Errorf("requests_rejected_before_body_total grew by %v, want 1", got)
+				}
+			}
+			select {
+			case body := <-bodies:
+				t.Errorf("handler ran with a %d byte body", len(body))
+			default:
+			}
+		})
+	}
+}
+
+func TestExpectContinueAccepted(t *testing.T) {
+	bodies := make(chan string, 1)
+	_, addr := startServerWithRoutes(t, uploadRouter(bodies))
+
+	conn, reader := sendHead(t, addr,
+		"PUT /upload HTTP/1.1\r\nHost: x\r\nAuthorization: Bearer secret\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n")
+
+	interim, err := testclient.ReadResponse(reader, "PUT")
+	if err != nil {
+		t.Fatalf("reading interim response: %v", err)
+	}
+	if interim.Status != 100 {
+		t.Fatalf("first status = %d, want 100 Continue", interim.Status)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := testclient.ReadResponse(reader, "PUT")
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	if resp.Status != 200 {
+		t.Fatalf("status = %d, want 200", resp.Status)
+	}
+	if body := <-bodies; body != "hello" {
+		t.Errorf("handler body = %q, want %q", body, "hello")
+	}
+}
+
+func TestRejectedBeforeBodyWithoutExpect(t *testing.T) {
+	bodies := make(chan string, 1)
+	_, addr := startServerWithRoutes(t, uploadRouter(bodies))
+
+	// Without Expect the client may already be sending the body; the
+	// server answers without reading it and closes the connection rather
+	// than parse the body as the next request.
+	_, reader := sendHead(t, addr,
+		"PUT /upload HTTP/1.1\r\nHost: x\r\nConnection: keep-alive\r\nContent-Length: 1048576\r\n\r\npartial")
+
+	resp, err := testclient.ReadResponse(reader, "PUT")
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	if resp.Status != 401 {
+		t.Fatalf("status = %d, want 401", resp.Status)
+	}
+	expectClosed(t, reader)
+	select {
+	case <-bodies:
+		t.Error("handler ran for a rejected upload")
+	default:
+	}
+}
+
+func TestBeforeBodyOrder(t *testing.T) {
+	var calls callLog
+	r := NewRouter()
+	r.Use(calls.mw("m"))
+	r.UseBeforeBody(calls.mw("x"))
+	r.UseBeforeBody(func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) Response {
+			calls = append(calls, "y>")
+			if req.Headers["authorization"] == "" {
+				calls = append(calls, "deny")
+				return NewHTTPError(401, "missing token").Response()
+			}
+			resp := next(req)
+			resp.Headers["X-Checked"] = "y"
+			calls = append(calls, "<y")
+			return resp
+		}
+	})
+	r.Handle("/upload", "PUT", calls.handler()).BeforeBody(calls.mw("z1"), calls.mw("z2"))
+	r.Handle("/other", "PUT", calls.handler())
+
+	newReq := func(target string, authorized bool) *Request {
+		req := &Request{Method: "PUT", Path: target, Headers: map[string]string{}}
+		if authorized {
+			req.Headers["authorization"] = "Bearer secret"
+		}
+		req.setTarget(target)
+		return req
+	}
+
+	t.Run("headers, then body", func(t *testing.T) {
+		req := newReq("/upload", true)
+		if rejection := r.CheckBeforeBody(req); rejection != nil {
+			t.Fatalf("CheckBeforeBody = %d, want nil", rejection.Status)
+		}
+		if got, want := calls.take(), "x> y> z1> z2> <z2 <z1 <y <x"; got != want {
+			t.Errorf("pre-body phase ran %q, want %q", got, want)
+		}
+		resp := r.Route(req)
+		if got, want := calls.take(), "m> handler <m"; got != want {
+			t.Errorf("Route ran %q, want %q without the pre-body chain again", got, want)
+		}
+		if resp.Headers["X-Checked"] != "y" {
+			t.Error("header added by pre-body middleware missing from the response")
+		}
+	})
+
+	t.Run("Route alone", func(t *testing.T) {
+		// Callers routing a complete request, such as batches, get both
+		// phases from Route.
+		r.Route(newReq("/upload", true))
+		if got, want := calls.take(), "x> y> z1> z2> <z2 <z1 <y <x m> handler <m"; got != want {
+			t.Errorf("Route ran %q, want %q", got, want)
+		}
+	})
+
+	t.Run("route without its own", func(t *testing.T) {
+		r.Route(newReq("/other", true))
+		if got, want := calls.take(), "x> y> <y <x m> handler <m"; got != want {
+			t.Errorf("Route ran %q, want %q", got, want)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		rejection := r.CheckBeforeBody(newReq("/upload", false))
+		if rejection == nil || rejection.Status != 401 {
+			t.Fatalf("CheckBeforeBody = %v, want a 401", rejection)
+		}
+		if got, want := calls.take(), "x> y> deny <x"; got != want {
+			t.Errorf("pre-body phase ran %q, want %q", got, want)
+		}
+	})
+
+	t.Run("unrouted", func(t *testing.T) {
+		rejection := r.CheckBeforeBody(newReq("/nowhere", true))
+		if rejection == nil || rejection.Status != 404 {
+			t.Fatalf("CheckBeforeBody = %v, want a 404", rejection)
+		}
+		if got, want := calls.take(), "m> <m"; got != want {
+			t.Errorf("ran %q, want only the Use chain around the 404", got)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package server
+
+import "strings"
+
+// RequestPredicate reports whether a request meets a condition, see When.
+// Predicates run for every request passing the middleware they guard, so
+// they must be cheap and must not modify the request.
+type RequestPredicate func(req *Request) bool
+
+// When applies mw only to requests matching pred; others go straight to
+// the next handler, without running mw or allocating. Predicates compose
+// with And, Or and Not:
+//
+//	router.Use(server.When(server.And(server.PathPrefix("/api"), server.MethodIn("POST", "PUT", "DELETE")), csrf))
+func When(pred RequestPredicate, mw MiddlewareFunc) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		wrapped := mw(next)
+		return func(req *Request) Response {
+			if pred(req) {
+				return wrapped(req)
+			}
+			return next(req)
+		}
+	}
+}
+
+// PathPrefix matches requests whose path is prefix or lies below it,
+// matching whole segments: PathPrefix("/api") matches "/api" and
+// "/api/users" but not "/apis", and PathPrefix("/api/") matches "/api"
+// too. Behind a base path (see Router.SetBasePath) the path is matched
+// with the base path stripped.
+func PathPrefix(prefix string) RequestPredicate {
+	path := strings.TrimSuffix(prefix, "/")
+	dir := path + "/"
+	return func(req *Request) bool {
+		return req.Path == path || strings.HasPrefix(req.Path, dir)
+	}
+}
+
+// MethodIn matches requests using one of methods, compared case-insensitively.
+func MethodIn(methods ...string) RequestPredicate {
+	return func(req *Request) bool {
+		for _, m := range methods {
+			if strings.EqualFold(req.Method, m) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// HeaderEquals matches requests carrying header name with exactly value.
+func HeaderEquals(name, value string) RequestPredicate {
+	name = strings.ToLower(name)
+	return func(req *Request) bool {
+		v, ok := req.Headers[name]
+		return ok && v == value
+	}
+}
+
+// HostMatches matches requests whose Host is pattern, ignoring case and
+// port, or, for a pattern like "*.example.com", any subdomain of it (but
+// not example.com itself), as in ALLOWED_HOSTS.
+func HostMatches(pattern string) RequestPredicate {
+	pattern = normalizeHost(pattern)
+	suffix, wildcard := strings.CutPrefix(pattern, "*.")
+	suffix = "." + suffix
+	return func(req *Request) bool {
+		host := normalizeHost(req.Headers["host"])
+		if wildcard {
+			return strings.HasSuffix(host, suffix)
+		}
+		return host != "" && host == pattern
+	}
+}
+
+// And matches requests matching every one of preds, checked in order
+// until one fails. And() matches every request.
+func And(preds ...RequestPredicate) RequestPredicate {
+	return func(req *Request) bool {
+		for _, pred := range preds {
+			if !pred(req) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or matches requests matching any of preds, checked in order until one
+// matches. Or() matches no request.
+func Or(preds ...RequestPredicate) RequestPredicate {
+	return func(req *Request) bool {
+		for _, pred := range preds {
+			if pred(req) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not matches the requests pred does not.
+func Not(pred RequestPredicate) RequestPredicate {
+	return func(req *Request) bool {
+		return !pred(req)
+	}
+}
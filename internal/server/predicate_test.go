@@ -0,0 +1,166 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+// predReq builds a request for predicate tests.
+func predReq(method, path string, headers map[string]string) *Request {
+	req := &Request{Method: method, Path: path, Headers: map[string]string{}}
+	for name, value := range headers {
+		req.Headers[name] = value
+	}
+	return req
+}
+
+func TestPredicates(t *testing.T) {
+	tests := []struct {
+		name string
+		pred RequestPredicate
+		req  *Request
+		want bool
+	}{
+		{"prefix itself", PathPrefix("/api"), predReq("GET", "/api", nil), true},
+		{"below prefix", PathPrefix("/api"), predReq("GET", "/api/users/7", nil), true},
+		{"sibling sharing letters", PathPrefix("/api"), predReq("GET", "/apis", nil), false},
+		{"other path", PathPrefix("/api"), predReq("GET", "/web/api", nil), false},
+		{"slash prefix, bare path", PathPrefix("/api/"), predReq("GET", "/api", nil), true},
+		{"slash prefix, below", PathPrefix("/api/"), predReq("GET", "/api/x", nil), true},
+		{"root prefix", PathPrefix("/"), predReq("GET", "/anything", nil), true},
+
+		{"method listed", MethodIn("POST", "PUT"), predReq("PUT", "/", nil), true},
+		{"method case", MethodIn("post"), predReq("POST", "/", nil), true},
+		{"method not listed", MethodIn("POST", "PUT"), predReq("GET", "/", nil), false},
+		{"no methods", MethodIn(), predReq("GET", "/", nil), false},
+
+		{"header equal", HeaderEquals("X-Mode", "test"), predReq("GET", "/", map[string]string{"x-mode": "test"}), true},
+		{"header value case", HeaderEquals("X-Mode", "test"), predReq("GET", "/", map[string]string{"x-mode": "Test"}), false},
+		{"header missing", HeaderEquals("X-Mode", ""), predReq("GET", "/", nil), false},
+		{"header empty", HeaderEquals("X-Mode", ""), predReq("GET", "/", map[string]string{"x-mode": ""}), true},
+
+		{"host exact", HostMatches("api.example.com"), predReq("GET", "/", map[string]string{"host": "API.example.com:8443"}), true},
+		{"host other", HostMatches("api.example.com"), predReq("GET", "/", map[string]string{"host": "www.example.com"}), false},
+		{"host missing", HostMatches("api.example.com"), predReq("GET", "/", nil), false},
+		{"wildcard subdomain", HostMatches("*.example.com"), predReq("GET", "/", map[string]string{"host": "a.b.example.com"}), true},
+		{"wildcard apex", HostMatches("*.example.com"), predReq("GET", "/", map[string]string{"host": "example.com"}), false},
+		{"wildcard lookalike", HostMatches("*.example.com"), predReq("GET", "/", map[string]string{"host": "badexample.com"}), false},
+	}
+	for _, tt := range tests {
+		if got := tt.pred(tt.req); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPredicateCombinators(t *testing.T) {
+	yes := func(*Request) bool { return true }
+	no := func(*Request) bool { return false }
+	req := predReq("GET", "/", nil)
+	for _, tt := range []struct {
+		name string
+		pred RequestPredicate
+		want bool
+	}{
+		{"And()", And(), true},
+		{"And(yes)", And(yes), true},
+		{"And(yes, yes)", And(yes, yes), true},
+		{"And(yes, no)", And(yes, no), false},
+		{"And(no, yes)", And(no, yes), false},
+		{"And(no, no)", And(no, no), false},
+		{"Or()", Or(), false},
+		{"Or(no)", Or(no), false},
+		{"Or(yes, no)", Or(yes, no), true},
+		{"Or(no, yes)", Or(no, yes), true},
+		{"Or(no, no)", Or(no, no), false},
+		{"Or(yes, yes)", Or(yes, yes), true},
+		{"Not(yes)", Not(yes), false},
+		{"Not(no)", Not(no), true},
+		{"Not(And(yes, no))", Not(And(yes, no)), true},
+		{"And(Or(no, yes), Not(no))", And(Or(no, yes), Not(no)), true},
+	} {
+		if got := tt.pred(req); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Both stop at the first predicate deciding the result.
+	var calls []string
+	named := func(name string, result bool) RequestPredicate {
+		return func(*Request) bool {
+			calls = append(calls, name)
+			return result
+		}
+	}
+	And(named("a", true), named("b", false), named("c", true))(req)
+	Or(named("d", false), named("e", true), named("f", false))(req)
+	if got := fmt.Sprint(calls); got != "[a b d e]" {
+		t.Errorf("predicates evaluated: %s, want [a b d e]", got)
+	}
+}
+
+func TestWhenSkipDoesNotAllocate(t *testing.T) {
+	allocating := func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) Response {
+			req.Set("seen", make([]byte, 64))
+			return next(req)
+		}
+	}
+	next := func(req *Request) Response { return Response{Status: 204} }
+	h := When(And(PathPrefix("/api"), MethodIn("POST")), allocating)(next)
+
+	req := predReq("GET", "/web", nil)
+	if allocs := testing.AllocsPerRun(100, func() { h(req) }); allocs != 0 {
+		t.Errorf("skipped middleware: %v allocations per request, want 0", allocs)
+	}
+	if _, ok := req.Get("seen"); ok {
+		t.Error("middleware ran for a request its predicate does not match")
+	}
+	req = predReq("POST", "/api/x", nil)
+	h(req)
+	if _, ok := req.Get("seen"); !ok {
+		t.Error("middleware skipped for a request its predicate matches")
+	}
+}
+
+// requireCSRFToken refuses requests without an X-CSRF-Token header.
+func requireCSRFToken(next HandlerFunc) HandlerFunc {
+	return func(req *Request) Response {
+		if req.Headers["x-csrf-token"] == "" {
+			return NewHTTPError(403, "missing CSRF token").Response()
+		}
+		return next(req)
+	}
+}
+
+func TestWhenAppliesCSRFToMutatingAPIRequests(t *testing.T) {
+	r := NewRouter()
+	r.SetBasePath("/svc")
+	r.Use(When(And(PathPrefix("/api"), MethodIn("POST", "PUT", "DELETE")), requireCSRFToken))
+	ok := func(req *Request) Response { return textResponse("ok") }
+	r.HandlePrefix("/api/", "", ok)
+	r.HandlePrefix("/web/", "", ok)
+
+	for _, tt := range []struct {
+		method, target string
+		token          bool
+		want           int
+	}{
+		{"POST", "/svc/api/items", false, 403},
+		{"PUT", "/svc/api/items/1", false, 403},
+		{"DELETE", "/svc/api/items/1", false, 403},
+		{"POST", "/svc/api/items", true, 200},
+		{"GET", "/svc/api/items", false, 200},
+		{"HEAD", "/svc/api/items", false, 200},
+		{"POST", "/svc/web/form", false, 200},
+	} {
+		req := &Request{Method: tt.method, Path: tt.target, Headers: map[string]string{}}
+		if tt.token {
+			req.Headers["x-csrf-token"] = "t0k3n"
+		}
+		req.setTarget(tt.target)
+		if got := r.Route(req).Status; got != tt.want {
+			t.Errorf("%s %s (token %v) = %d, want %d", tt.method, tt.target, tt.token, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// DefaultBodyPreviewSize is the number of body bytes captured by
+// BodyPreview unless SetBodyPreviewLimit chooses otherwise.
+const DefaultBodyPreviewSize = 4 * 1024
+
+// BodyPreview is a bounded prefix of a request body, safe to log.
+type BodyPreview struct {
+	Data      []byte // at most the preview limit
+	Size      int    // full body size in bytes
+	Truncated bool   // Data is shorter than the body
+	Binary    bool   // Data is not printable UTF-8 text
+}
+
+// String renders the preview for logs: quoted text, or a size summary for
+// binary content, with a truncation marker when needed.
+func (p BodyPreview) String() string {
+	var s string
+	if p.Binary {
+		s = fmt.Sprintf("<binary, %d bytes>", len(p.Data))
+	} else {
+		s = strconv.Quote(string(p.Data))
+	}
+	if p.Truncated {
+		s += fmt.Sprintf(" (truncated, %d bytes total)", p.Size)
+	}
+	return s
+}
+
+// SetBodyPreviewLimit changes how many bytes BodyPreview captures for this
+// request. A limit of 0 or less restores DefaultBodyPreviewSize.
+func (r *Request) SetBodyPreviewLimit(n int) {
+	r.previewLimit = n
+}
+
+// BodyPreview returns the first bytes of the request body for logging and
+// error reports. It never consumes or copies the body the handler reads,
+// and works whether or not the handler looked at the body.
+func (r *Request) BodyPreview() BodyPreview {
+	limit := r.previewLimit
+	if limit <= 0 {
+		limit = DefaultBodyPreviewSize
+	}
+
+	data := r.Body
+	if len(data) > limit {
+		data = data[:limit]
+	}
+	return BodyPreview{
+		Data:      data,
+		Size:      len(r.Body),
+		Truncated: len(data) < len(r.Body),
+		Binary:    isBinary(data, len(data) < len(r.Body)),
+	}
+}
+
+// isBinary reports whether data looks like something other than text:
+// invalid UTF-8 or control characters other than tab, CR and LF. When the
+// data was cut short, a rune split at the end is not counted against it.
+func isBinary(data []byte, truncated bool) bool {
+	for i := 0; i < len(data); {
+		c, size := utf8.DecodeRune(data[i:])
+		if c == utf8.RuneError && size <= 1 {
+			if truncated && !utf8.FullRune(data[i:]) {
+				return false
+			}
+			return true
+		}
+		if (c < 0x20 && c != '\t' && c != '\n' && c != '\r') || c == 0x7f {
+			return true
+		}
+		i += size
+	}
+	return false
+}
+
+// DumpMiddleware logs each request line, its headers and a body preview of
+// at most limit bytes at debug level. A limit of 0 keeps the request's
+// current preview limit. Requests with a raw capture are dumped as
+// received, preserving header order and spelling. The values of
+// DefaultRedactHeaders, such as Authorization and Cookie, are replaced by
+// Redacted either way.
+func DumpMiddleware(limit int) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) Response {
+			if limit > 0 {
+				req.SetBodyPreviewLimit(limit)
+			}
+			utils.Debug("Dump: %s", dumpRequest(req))
+			return next(req)
+		}
+	}
+}
+
+// dumpRequest renders req for DumpMiddleware.
+func dumpRequest(req *Request) string {
+	if req.RawRequestLine != "" {
+		raw := make([]string, len(req.RawHeaders))
+		for i, line := range req.RawHeaders {
+			name, _, ok := strings.Cut(line, ":")
+			if ok && isRedactedHeader(name) {
+				line = name + ": " + Redacted
+			}
+			raw[i] = line
+		}
+		return fmt.Sprintf("%q headers=%q body=%s", req.RawRequestLine, raw, req.BodyPreview())
+	}
+	headers := make(map[string]string, len(req.Headers))
+	for name, value := range req.Headers {
+		if isRedactedHeader(name) {
+			value = Redacted
+		}
+		headers[name] = value
+	}
+	return fmt.Sprintf("%s %s %s headers=%v body=%s", req.Method, req.Path, req.Version, headers, req.BodyPreview())
+}
+
+// isRedactedHeader reports whether the header name is one of
+// DefaultRedactHeaders, whose values are kept out of logs.
+func isRedactedHeader(name string) bool {
+	return slices.Contains(DefaultRedactHeaders, strings.ToLower(strings.TrimSpace(name)))
+}
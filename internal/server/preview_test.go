@@ -0,0 +1,94 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBodyPreview(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		body      string
+		limit     int
+		data      string
+		truncated bool
+		binary    bool
+	}{
+		{"empty", "", 8, "", false, false},
+		{"short text", "hello", 8, "hello", false, false},
+		{"exactly the limit", "12345678", 8, "12345678", false, false},
+		{"truncated text", "hello, world", 5, "hello", true, false},
+		{"default limit", strings.Repeat("a", DefaultBodyPreviewSize+1), 0, strings.Repeat("a", DefaultBodyPreviewSize), true, false},
+		{"text with tabs and newlines", "a\tb\r\nc", 16, "a\tb\r\nc", false, false},
+		{"control byte", "a\x00b", 16, "a\x00b", false, true},
+		{"invalid UTF-8", "a\xffb", 16, "a\xffb", false, true},
+		{"DEL", "a\x7f", 16, "a\x7f", false, true},
+		{"multibyte text", "héllo wörld", 32, "héllo wörld", false, false},
+		// "é" is two bytes; a cut between them is the limit's doing.
+		{"rune split by the limit", "hé", 2, "h\xc3", true, false},
+		{"rune cut short in the body", "h\xc3", 16, "h\xc3", false, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Request{Body: []byte(tt.body)}
+			req.SetBodyPreviewLimit(tt.limit)
+			p := req.BodyPreview()
+			if string(p.Data) != tt.data || p.Size != len(tt.body) || p.Truncated != tt.truncated || p.Binary != tt.binary {
+				t.Errorf("BodyPreview = {%q %d truncated=%t binary=%t}, want {%q %d truncated=%t binary=%t}",
+					p.Data, p.Size, p.Truncated, p.Binary, tt.data, len(tt.body), tt.truncated, tt.binary)
+			}
+			if string(req.Body) != tt.body {
+				t.Errorf("Body changed to %q", req.Body)
+			}
+		})
+	}
+}
+
+func TestBodyPreviewString(t *testing.T) {
+	for _, tt := range []struct {
+		preview BodyPreview
+		want    string
+	}{
+		{BodyPreview{Data: []byte("hi\n"), Size: 3}, `"hi\n"`},
+		{BodyPreview{Data: []byte("hel"), Size: 5, Truncated: true}, `"hel" (truncated, 5 bytes total)`},
+		{BodyPreview{Data: []byte{0, 1}, Size: 2, Binary: true}, "<binary, 2 bytes>"},
+		{BodyPreview{Data: []byte{0, 1}, Size: 9, Binary: true, Truncated: true}, "<binary, 2 bytes> (truncated, 9 bytes total)"},
+	} {
+		if got := tt.preview.String(); got != tt.want {
+			t.Errorf("String() = %s, want %s", got, tt.want)
+		}
+	}
+}
+
+func TestDumpRedactsSecrets(t *testing.T) {
+	secrets := []string{"Bearer s3cret", "session=s3cret", "Basic s3cret"}
+	parsed := &Request{
+		Method:  "POST",
+		Path:    "/login",
+		Version: HTTPVersion,
+		Headers: map[string]string{
+			"authorization":       secrets[0],
+			"cookie":              secrets[1],
+			"proxy-authorization": secrets[2],
+			"user-agent":          "dump-test",
+		},
+		Body: []byte("user=a"),
+	}
+	raw := &Request{
+		RawRequestLine: "POST /login HTTP/1.1",
+		RawHeaders:     []string{"Authorization: " + secrets[0], "COOKIE:" + secrets[1], "Proxy-Authorization:  " + secrets[2], "User-Agent: dump-test"},
+	}
+	for name, req := range map[string]*Request{"parsed": parsed, "raw": raw} {
+		dump := dumpRequest(req)
+		for _, secret := range secrets {
+			if strings.Contains(dump, secret) {
+				t.Errorf("%s dump %s leaks %q", name, dump, secret)
+			}
+		}
+		if strings.Count(dump, Redacted) != 3 || !strings.Contains(dump, "dump-test") {
+			t.Errorf("%s dump %s, want the three secrets redacted and the rest kept", name, dump)
+		}
+	}
+	if parsed.Headers["authorization"] != secrets[0] {
+		t.Error("dumping changed the request's headers")
+	}
+}
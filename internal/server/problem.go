@@ -0,0 +1,250 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// HTTPError is an error that carries an HTTP status and renders as an
+// RFC 9457 problem document when the client accepts JSON.
+//
+// Handlers return it via its Response method so that handler errors are
+// negotiated exactly like the framework's own 404/405/400 responses.
+//
+// Example:
+//
+//	return NewHTTPError(409, "file is locked").Response()
+type HTTPError struct {
+	Status     int
+	Title      string
+	Detail     string
+	Type       string         // URI identifying the problem type, "about:blank" if empty
+	Extensions map[string]any // extra members merged into the problem document
+}
+
+// NewHTTPError creates an HTTPError for status with an optional detail.
+// The title defaults to the standard reason phrase for status.
+func NewHTTPError(status int, detail string) *HTTPError {
+	return newProblem(status, reasonPhrase(status), detail)
+}
+
+func newProblem(status int, title, detail string) *HTTPError {
+	return &HTTPError{Status: status, Title: title, Detail: detail}
+}
+
+func (e *HTTPError) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("%d %s: %s", e.Status, e.Title, e.Detail)
+	}
+	return fmt.Sprintf("%d %s", e.Status, e.Title)
+}
+
+// Response converts the error into a plain-text Response. The server
+// re-renders it as problem+json or HTML when the client negotiates so.
+func (e *HTTPError) Response() Response {
+	body := fmt.Sprintf("%d %s", e.Status, e.Title)
+	if e.Detail != "" {
+		body += ": " + e.Detail
+	}
+	return Response{
+		Version: HTTPVersion,
+		Status:  e.Status,
+		Reason:  e.Title,
+		Headers: map[string]string{"Content-Type": "text/plain"},
+		Body:    []byte(body),
+		problem: e,
+	}
+}
+
+// renderProblem rewrites the body of an error response in the format the
+// client prefers according to its Accept header:
+//   - application/problem+json or application/json: an RFC 9457 document
+//     whose instance member carries the request ID when one is known.
+//   - text/html: the page {status}.html from ERROR_PAGES_DIR, if present.
+//   - anything else: the existing plain-text body.
+func (s *Server) renderProblem(req *Request, resp *Response) {
+	if resp.problem == nil || resp.StreamFunc != nil {
+		return
+	}
+	resp.Headers["Vary"] = "Accept"
+
+	switch negotiateErrorFormat(req.Headers["accept"]) {
+	case "json":
+		doc := map[string]any{}
+		for k, v := range resp.problem.Extensions {
+			doc[k] = v
+		}
+		problemType := resp.problem.Type
+		if problemType == "" {
+			problemType = "about:blank"
+		}
+		doc["type"] = problemType
+		doc["title"] = resp.problem.Title
+		doc["status"] = resp.problem.Status
+		if resp.problem.Detail != "" {
+			doc["detail"] = resp.problem.Detail
+		}
+		id := req.GetString(RequestIDKey)
+		if id == "" {
+			id = req.Headers["x-request-id"] // unrouted requests skip middleware
+		}
+		if id != "" {
+			doc["instance"] = id
+		}
+
+		body, err := json.Marshal(doc)
+		if err != nil {
+			utils.Warn("Failed to encode problem document: %v", err)
+			return
+		}
+		resp.Body = body
+		resp.Headers["Content-Type"] = "application/problem+json"
+
+	case "html":
+		if s.config.ErrorPagesDir == "" {
+			return
+		}
+		page := filepath.Join(s.config.ErrorPagesDir, strconv.Itoa(resp.Status)+".html")
+		body, err := os.ReadFile(page)
+		if err != nil {
+			utils.Debug("No error page for %d: %v", resp.Status, err)
+			return
+		}
+		resp.Body = body
+		resp.Headers["Content-Type"] = "text/html"
+
+	default:
+		return
+	}
+
+	if _, ok := resp.Headers["Content-Length"]; ok {
+		resp.Headers["Content-Length"] = strconv.Itoa(len(resp.Body))
+	}
+}
+
+// negotiateErrorFormat picks "json", "html" or "text" from an Accept header.
+//
+// Only explicitly listed JSON or HTML media types select those formats; a
+// bare "*/*" keeps the plain-text default so curl output stays readable.
+func negotiateErrorFormat(accept string) string {
+	if accept == "" {
+		return "text"
+	}
+
+	var jsonQ, htmlQ, textQ float64
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, q := parseMediaRange(part)
+		switch mediaType {
+		case "application/problem+json", "application/json":
+			jsonQ = max(jsonQ, q)
+		case "text/html":
+			htmlQ = max(htmlQ, q)
+		case "text/plain":
+			textQ = max(textQ, q)
+		}
+	}
+
+	switch {
+	case jsonQ > 0 && jsonQ >= htmlQ && jsonQ >= textQ:
+		return "json"
+	case htmlQ > 0 && htmlQ >= textQ:
+		return "html"
+	default:
+		return "text"
+	}
+}
+
+// parseMediaRange splits one Accept element into its lowercased media type
+// and quality value (1 when absent, 0 when malformed).
+func parseMediaRange(part string) (string, float64) {
+	fields := strings.Split(part, ";")
+	mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+	q := 1.0
+	for _, param := range fields[1:] {
+		name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok || !strings.EqualFold(name, "q") {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			parsed = 0
+		}
+		q = parsed
+	}
+	return mediaType, q
+}
+
+// reasonPhrase returns the standard reason phrase for common status codes,
+// or "Error" for others.
+func reasonPhrase(status int) string {
+	switch status {
+	case 200:
+		return "OK"
+	case 201:
+		return "Created"
+	case 202:
+		return "Accepted"
+	case 204:
+		return "No Content"
+	case 400:
+		return "Bad Request"
+	case 401:
+		return "Unauthorized"
+	case 403:
+		return "Forbidden"
+	case 404:
+		return "Not Found"
+	case 405:
+		return "Method Not Allowed"
+	case 406:
+		return "Not Acceptable"
+	case 408:
+		return "Request Timeout"
+	case 409:
+		return "Conflict"
+	case 411:
+		return "Length Required"
+	case 412:
+		return "Precondition Failed"
+	case 413:
+		return "Content Too Large"
+	case 414:
+		return "URI Too Long"
+	case 415:
+		return "Unsupported Media Type"
+	case 416:
+		return "Range Not Satisfiable"
+	case 417:
+		return "Expectation Failed"
+	case 421:
+		return "Misdirected Request"
+	case 422:
+		return "Unprocessable Content"
+	case 423:
+		return "Locked"
+	case 429:
+		return "Too Many Requests"
+	case 431:
+		return "Request Header Fields Too Large"
+	case 500:
+		return "Internal Server Error"
+	case 501:
+		return "Not Implemented"
+	case 502:
+		return "Bad Gateway"
+	case 503:
+		return "Service Unavailable"
+	case 504:
+		return "Gateway Timeout"
+	case 507:
+		return "Insufficient Storage"
+	default:
+		return "Error"
+	}
+}
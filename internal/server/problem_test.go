@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+)
+
+func TestNegotiateErrorFormat(t *testing.T) {
+	for _, tt := range []struct{ accept, want string }{
+		{"", "text"},
+		{"*/*", "text"},
+		{"application/json", "json"},
+		{"application/problem+json", "json"},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", "html"},
+		{"text/html;q=0.5, application/json;q=0.9", "json"},
+		{"application/json;q=0.5, text/plain", "text"},
+		{"text/html, application/json", "json"}, // a tie goes to JSON
+		{"application/json;q=0", "text"},
+		{"application/json;q=abc", "text"},
+		{"TEXT/HTML", "html"},
+	} {
+		if got := negotiateErrorFormat(tt.accept); got != tt.want {
+			t.Errorf("negotiateErrorFormat(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestNotFoundRepresentations(t *testing.T) {
+	pages := t.TempDir()
+	if err := os.WriteFile(filepath.Join(pages, "404.html"), []byte("<h1>Lost</h1>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ERROR_PAGES_DIR", pages)
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	for _, tt := range []struct {
+		accept, contentType, body string
+	}{
+		{"", "text/plain; charset=utf-8", "404 Not Found"},
+		{"*/*", "text/plain; charset=utf-8", "404 Not Found"},
+		{"text/html", "text/html; charset=utf-8", "<h1>Lost</h1>"},
+	} {
+		resp := roundTrip(t, c, "GET", "/missing", map[string]string{"Connection": "keep-alive", "Accept": tt.accept}, nil)
+		if resp.Status != 404 || resp.Header("Content-Type") != tt.contentType || string(resp.Body) != tt.body {
+			t.Errorf("Accept %q: %d %s %q, want 404 %s %q", tt.accept, resp.Status, resp.Header("Content-Type"), resp.Body, tt.contentType, tt.body)
+		}
+		if resp.Header("Vary") != "Accept" {
+			t.Errorf("Accept %q: Vary = %q, want Accept", tt.accept, resp.Header("Vary"))
+		}
+	}
+
+	resp := roundTrip(t, c, "GET", "/missing", map[string]string{"Connection": "keep-alive", "Accept": "application/json"}, nil)
+	if resp.Header("Content-Type") != "application/problem+json" {
+		t.Fatalf("Content-Type = %q, want application/problem+json", resp.Header("Content-Type"))
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(resp.Body, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["type"] != "about:blank" || doc["title"] != "Not Found" || doc["status"] != 404.0 {
+		t.Errorf("problem document = %v", doc)
+	}
+	if id := resp.Header("X-Request-Id"); id == "" || doc["instance"] != id {
+		t.Errorf("instance = %v, want the request ID %q", doc["instance"], id)
+	}
+}
+
+func TestHTTPErrorProblemDocument(t *testing.T) {
+	srv := NewServer(&config.Config{}, NewRouter())
+	httpErr := NewHTTPError(409, "file is locked")
+	httpErr.Type = "https://example.com/problems/locked"
+	httpErr.Extensions = map[string]any{"path": "/files/a.txt", "status": 200}
+	resp := httpErr.Response()
+	req := &Request{Headers: map[string]string{"accept": "application/json", "x-request-id": "req-1"}}
+	srv.finalizeResponse(req, &resp)
+
+	var doc map[string]any
+	if err := json.Unmarshal(resp.Body, &doc); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"type":     "https://example.com/problems/locked",
+		"title":    "Conflict",
+		"status":   409.0, // the extension cannot override a standard member
+		"detail":   "file is locked",
+		"instance": "req-1",
+		"path":     "/files/a.txt",
+	}
+	for name, value := range want {
+		if doc[name] != value {
+			t.Errorf("%s = %v, want %v", name, doc[name], value)
+		}
+	}
+
+	// Without ERROR_PAGES_DIR an HTML client gets the plain text.
+	resp = httpErr.Response()
+	req.Headers["accept"] = "text/html"
+	srv.finalizeResponse(req, &resp)
+	if string(resp.Body) != "409 Conflict: file is locked" || resp.Headers["Content-Type"] != "text/plain" {
+		t.Errorf("HTML without error pages = %s %q", resp.Headers["Content-Type"], resp.Body)
+	}
+}
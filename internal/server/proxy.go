@@ -0,0 +1,349 @@
+package server
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Abb133Se/httpServer/internal/cachecontrol"
+	"github.com/Abb133Se/httpServer/internal/config"
+	"github.com/Abb133Se/httpServer/internal/httpclient"
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// hopByHopHeaders describe a single connection rather than the message,
+// so the proxy never forwards them in either direction (RFC 9110 section
+// 7.6.1). Headers named in a Connection header are dropped too.
+var hopByHopHeaders = map[string]bool{
+	"connection":          true,
+	"keep-alive":          true,
+	"proxy-authenticate":  true,
+	"proxy-authorization": true,
+	"proxy-connection":    true,
+	"te":                  true,
+	"trailer":             true,
+	"transfer-encoding":   true,
+	"upgrade":             true,
+}
+
+// proxyMaxDecodedBytes caps a body the proxy decompresses for a client,
+// so a small compressed response cannot expand without bound.
+const proxyMaxDecodedBytes = httpclient.DefaultMaxResponseBytes
+
+// proxyTimeoutDetail describes a 504 for each phase of an upstream exchange
+// that ends before the response is committed. A body timeout comes later
+// and aborts the relayed body instead.
+var proxyTimeoutDetail = map[string]string{
+	httpclient.PhaseDial:    "timed out connecting to the upstream server",
+	httpclient.PhaseHeaders: "timed out waiting for the upstream response headers",
+}
+
+// ProxyHandler returns a handler forwarding requests under prefix to
+// upstream, a base URL such as "http://backend:8080/v1", through client:
+// "/api/users?page=2" under prefix "/api" becomes
+// "http://backend:8080/v1/users?page=2". Hop-by-hop headers are dropped
+// both ways, and the upstream sees the client in X-Forwarded-For,
+// X-Forwarded-Host and X-Forwarded-Proto.
+//
+// The client's Accept-Encoding goes upstream unchanged, and a compressed
+// response is passed back as it came when the client accepts its coding.
+// Otherwise a gzip or deflate body is decompressed on the client's behalf,
+// unless the upstream sent Cache-Control: no-transform. Either way an
+// encoded response gets "Vary: Accept-Encoding".
+//
+// The upstream body is relayed as it arrives rather than read into
+// memory first: with the upstream's Content-Length when it is passed on
+// as it came, and chunked when it had none or is decoded on the way.
+//
+// An upstream that cannot be reached or answers with garbage gets the
+// client a 502 Bad Gateway, and one that exceeds client's dial or header
+// timeout a 504 Gateway Timeout naming the phase. Once the headers have
+// been relayed, an upstream failing or exceeding the body timeout aborts
+// the response, closing the client's connection.
+func ProxyHandler(prefix, upstream string, client *httpclient.Client) HandlerFunc {
+	base := strings.TrimSuffix(upstream, "/")
+	return func(req *Request) Response {
+		target := base + "/" + strings.TrimPrefix(strings.TrimPrefix(req.Path, prefix), "/")
+		if req.RawQuery != "" {
+			target += "?" + req.RawQuery
+		}
+		out := &httpclient.Request{
+			Method: req.Method,
+			URL:    target,
+			Header: proxyRequestHeaders(req),
+			Body:   req.Body,
+		}
+		up, err := client.DoStream(req.Context(), out)
+		if err != nil {
+			Metrics.Counter("proxy_errors_total").Inc()
+			utils.Warn("Proxying %s %s to %s failed: %v", req.Method, req.Path, target, err)
+			var timeout *httpclient.TimeoutError
+			if errors.As(err, &timeout) && proxyTimeoutDetail[timeout.Phase] != "" {
+				Metrics.Counter("proxy_timeouts_total").Inc()
+				return NewHTTPError(504, proxyTimeoutDetail[timeout.Phase]).Response()
+			}
+			return NewHTTPError(502, "the upstream server did not answer").Response()
+		}
+		Metrics.Counter("proxy_requests_total").Inc()
+		utils.Debug("Proxied %s %s to %s: %d", req.Method, req.Path, target, up.Status)
+		// The body is closed by the stream relaying it, or once the
+		// request is done if the response never gets to run it.
+		context.AfterFunc(req.Context(), func() { up.BodyStream.Close() })
+		return proxyResponse(req, up)
+	}
+}
+
+// proxyRequestHeaders returns the headers of req to send upstream.
+func proxyRequestHeaders(req *Request) map[string]string {
+	header := make(map[string]string, len(req.Headers)+3)
+	connection := req.Headers["connection"]
+	for name, value := range req.Headers {
+		if isHopByHop(name, connection) || name == "host" || name == "content-length" {
+			continue
+		}
+		header[canonicalHeaderKey(name)] = value
+	}
+
+	client := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+	if prior := req.Headers["x-forwarded-for"]; prior != "" {
+		client = prior + ", " + client
+	}
+	header["X-Forwarded-For"] = client
+	header["X-Forwarded-Host"] = req.Host()
+	header["X-Forwarded-Proto"] = "http"
+	if req.TLSState != nil {
+		header["X-Forwarded-Proto"] = "https"
+	}
+	return header
+}
+
+// proxyResponse converts the upstream response up into the response to
+// req, relaying its body and decoding it on the way if req's client does
+// not accept its coding.
+func proxyResponse(req *Request, up *httpclient.Response) Response {
+	headers := make(map[string]string, len(up.Header))
+	connection := up.Header["connection"]
+	for name, value := range up.Header {
+		if isHopByHop(name, connection) || name == "content-length" {
+			continue
+		}
+		headers[canonicalHeaderKey(name)] = value
+	}
+	reason := up.Reason
+	if reason == "" {
+		reason = reasonPhrase(up.Status)
+	}
+	resp := Response{
+		Version: HTTPVersion,
+		Status:  up.Status,
+		Reason:  reason,
+		Headers: headers,
+	}
+	length, lengthErr := strconv.ParseInt(up.Get("content-length"), 10, 64)
+	hasBody := req.Method != "HEAD" && statusAllowsBody(up.Status) && (lengthErr != nil || length > 0)
+	if req.Method == "HEAD" && lengthErr == nil {
+		resp.ContentLength = length
+	}
+	if !hasBody {
+		up.BodyStream.Close()
+	}
+
+	coding := strings.ToLower(strings.TrimSpace(up.Get("content-encoding")))
+	decode := coding != "" && coding != "identity"
+	if decode {
+		addVary(&resp, "Accept-Encoding")
+		switch {
+		case req.AcceptsEncoding(coding):
+			decode = false
+		case !canDecode(coding):
+			decode = false
+		default:
+			if _, ok := cachecontrol.Parse(up.Get("cache-control")).Other["no-transform"]; ok {
+				utils.Debug("Passing %s %s through in %s: the upstream forbids transformations", req.Method, req.Path, coding)
+				decode = false
+			}
+		}
+	}
+
+	if decode && req.Method == "HEAD" {
+		// The decoded length is unknown without the body; GET would send
+		// it chunked.
+		delete(resp.Headers, "Content-Encoding")
+		resp.Headers["Transfer-Encoding"] = "chunked"
+		resp.ContentLength = 0
+		weakenETag(&resp)
+		return resp
+	}
+	if !hasBody {
+		return resp
+	}
+	if !decode {
+		if lengthErr == nil {
+			resp.Headers["Content-Length"] = strconv.FormatInt(length, 10)
+		}
+		resp.StreamFunc = relayBody(up.BodyStream, req)
+		return resp
+	}
+
+	decoded, err := newDecoder(up.BodyStream, coding)
+	if errors.Is(err, io.EOF) {
+		// An empty body has nothing to decode.
+		up.BodyStream.Close()
+		delete(resp.Headers, "Content-Encoding")
+		return resp
+	}
+	if err != nil {
+		up.BodyStream.Close()
+		Metrics.Counter("proxy_errors_total").Inc()
+		utils.Warn("Failed to decode %s response to %s %s: %v", coding, req.Method, req.Path, err)
+		return NewHTTPError(502, "the upstream response could not be decoded").Response()
+	}
+	Metrics.Counter("proxy_responses_decoded_total").Inc()
+	delete(resp.Headers, "Content-Encoding")
+	weakenETag(&resp)
+	resp.StreamFunc = relayBody(&decodedBody{r: decoded, upstream: up.BodyStream, remaining: proxyMaxDecodedBytes}, req)
+	return resp
+}
+
+// relayBody returns the StreamFunc copying the upstream body to the client
+// and closing it once done. What each read from the upstream returns is
+// flushed to the client, so a body the upstream trickles out, such as an
+// event stream, reaches the client at the same pace.
+func relayBody(body io.ReadCloser, req *Request) func(io.Writer) error {
+	return func(w io.Writer) error {
+		defer body.Close()
+		flusher, canFlush := w.(interface{ Flush() error })
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := body.Read(buf)
+			if n > 0 {
+				if _, err := w.Write(buf[:n]); err != nil {
+					return err
+				}
+				if canFlush {
+					if err := flusher.Flush(); err != nil {
+						return err
+					}
+				}
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				Metrics.Counter("proxy_errors_total").Inc()
+				utils.Warn("Relaying the upstream body of %s %s failed: %v", req.Method, req.Path, err)
+				return err
+			}
+		}
+	}
+}
+
+// isHopByHop reports whether the header name is hop-by-hop, either always
+// or because connection, a Connection header value, lists it.
+func isHopByHop(name, connection string) bool {
+	if hopByHopHeaders[name] {
+		return true
+	}
+	for _, listed := range strings.Split(connection, ",") {
+		if strings.EqualFold(strings.TrimSpace(listed), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// canDecode reports whether the proxy can decode the content coding.
+func canDecode(coding string) bool {
+	switch coding {
+	case "gzip", "x-gzip", "deflate":
+		return true
+	}
+	return false
+}
+
+// newDecoder returns a reader decoding body, encoded with coding, which
+// canDecode must accept. It reads the coding's header, so a body that is
+// not in the coding fails here, before the response is committed, and
+// an empty body fails with io.EOF.
+func newDecoder(body io.Reader, coding string) (io.Reader, error) {
+	br := bufio.NewReader(body)
+	if coding == "deflate" {
+		// "deflate" is zlib-wrapped (RFC 9110 section 8.4.1.2), but some
+		// servers send the raw format.
+		header, err := br.Peek(2)
+		if err != nil {
+			return nil, err
+		}
+		if header[0]&0x0f != 8 || (uint16(header[0])<<8|uint16(header[1]))%31 != 0 {
+			return flate.NewReader(br), nil
+		}
+		return zlib.NewReader(br)
+	}
+	return gzip.NewReader(br)
+}
+
+// decodedBody reads a body decoded by the proxy, failing once it grows
+// past its limit. Closing it closes the upstream body below the decoder.
+type decodedBody struct {
+	r         io.Reader
+	upstream  io.Closer
+	remaining int64
+}
+
+func (d *decodedBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > d.remaining+1 {
+		p = p[:d.remaining+1]
+	}
+	n, err := d.r.Read(p)
+	if d.remaining -= int64(n); d.remaining < 0 {
+		return 0, fmt.Errorf("decoded body exceeds %d bytes", proxyMaxDecodedBytes)
+	}
+	return n, err
+}
+
+func (d *decodedBody) Close() error {
+	return d.upstream.Close()
+}
+
+// weakenETag weakens resp's strong ETag: a decoded body is a different
+// representation, as compression makes one.
+func weakenETag(resp *Response) {
+	if etag := resp.Headers["ETag"]; strings.HasPrefix(etag, `"`) {
+		resp.Headers["ETag"] = "W/" + etag
+	}
+}
+
+// RegisterProxyMounts validates the PROXY_MOUNTS entries and registers a
+// ProxyHandler for every method under each prefix, sending requests
+// through client. Like static mounts, proxy mounts should be registered
+// before other routes so they take precedence.
+func RegisterProxyMounts(router *Router, mounts []config.ProxyMountConfig, client *httpclient.Client) error {
+	for _, mount := range mounts {
+		prefix := "/" + strings.Trim(mount.Prefix, "/")
+		if prefix == "/" || !strings.HasPrefix(mount.Prefix, "/") {
+			return fmt.Errorf("proxy mount %s -> %s: prefix must start with / and name a path", mount.Prefix, mount.Upstream)
+		}
+		u, err := url.Parse(mount.Upstream)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" {
+			return fmt.Errorf("proxy mount %s -> %s: upstream must be an http or https URL without a query", mount.Prefix, mount.Upstream)
+		}
+		handler := ProxyHandler(prefix, mount.Upstream, client)
+		router.Handle(prefix, "", handler)
+		router.HandlePrefix(prefix+"/", "GET", handler).Doc(RouteDoc{Summary: "Proxied to " + mount.Upstream})
+		router.HandlePrefix(prefix+"/", "", handler)
+		utils.Info("Proxy mount %s -> %s", prefix, mount.Upstream)
+	}
+	return nil
+}
@@ -0,0 +1,378 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+	"github.com/Abb133Se/httpServer/internal/httpclient"
+	"github.com/Abb133Se/httpServer/internal/testclient"
+)
+
+// stubUpstream is an HTTP/1.1 server for proxy tests, answering every
+// request with respond and remembering the last request it got.
+type stubUpstream struct {
+	addr string
+
+	mu     sync.Mutex
+	target string
+	header map[string]string
+}
+
+// startUpstream serves respond on an ephemeral loopback port until the
+// test ends. respond returns the raw response for a request target.
+func startUpstream(t *testing.T, respond func(target string) string) *stubUpstream {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	u := &stubUpstream{addr: listener.Addr().String()}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go u.serve(conn, respond)
+		}
+	}()
+	return u
+}
+
+func (u *stubUpstream) serve(conn net.Conn, respond func(string) string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return
+		}
+		header := make(map[string]string)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line = strings.TrimRight(line, "\r\n"); line == "" {
+				break
+			}
+			name, value, _ := strings.Cut(line, ":")
+			header[strings.ToLower(name)] = strings.TrimSpace(value)
+		}
+		length, _ := strconv.Atoi(header["content-length"])
+		r.Discard(length)
+		u.mu.Lock()
+		u.target, u.header = fields[1], header
+		u.mu.Unlock()
+		if _, err := conn.Write([]byte(respond(fields[1]))); err != nil {
+			return
+		}
+	}
+}
+
+// last returns the target and headers of the last request received.
+func (u *stubUpstream) last() (string, map[string]string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.target, u.header
+}
+
+// startProxy serves a server proxying "/api" to u's "/v1".
+func startProxy(t *testing.T, u *stubUpstream) string {
+	t.Helper()
+	t.Setenv("PROXY_MOUNTS", "/api=http://"+u.addr+"/v1")
+	_, addr := startServer(t)
+	return addr
+}
+
+func gzipped(t *testing.T, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// upstreamResponse returns a raw 200 response with headers and body.
+func upstreamResponse(headers, body string) string {
+	return "HTTP/1.1 200 OK\r\n" + headers + "Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+}
+
+func TestProxyForwardsRequest(t *testing.T) {
+	u := startUpstream(t, func(string) string {
+		return upstreamResponse("Connection: X-Up-Hop\r\nX-Up-Hop: 1\r\nKeep-Alive: timeout=5\r\nX-Up: 1\r\n", "hello")
+	})
+	addr := startProxy(t, u)
+
+	resp := roundTrip(t, dial(t, addr), "GET", "/api/users?page=2", map[string]string{
+		"Connection":      "X-Hop",
+		"X-Hop":           "secret",
+		"X-Kept":          "yes",
+		"Accept-Encoding": "gzip;q=0.5, br",
+	}, nil)
+	if resp.Status != 200 || string(resp.Body) != "hello" {
+		t.Fatalf("GET = %d %q, want 200 hello", resp.Status, resp.Body)
+	}
+	if resp.Header("X-Up") != "1" || resp.Header("X-Up-Hop") != "" || resp.Header("Keep-Alive") != "" {
+		t.Errorf("response headers = %v, want X-Up without the upstream's hop-by-hop headers", resp.Headers)
+	}
+
+	target, header := u.last()
+	if target != "/v1/users?page=2" {
+		t.Errorf("upstream target = %q, want /v1/users?page=2", target)
+	}
+	if header["x-kept"] != "yes" || header["x-hop"] != "" {
+		t.Errorf("upstream headers = %v, want X-Kept without the hop-by-hop X-Hop", header)
+	}
+	if got := header["accept-encoding"]; got != "gzip;q=0.5, br" {
+		t.Errorf("upstream Accept-Encoding = %q, want the client's", got)
+	}
+	if header["x-forwarded-for"] != "127.0.0.1" || header["x-forwarded-host"] != "127.0.0.1" || header["x-forwarded-proto"] != "http" {
+		t.Errorf("upstream X-Forwarded headers = %v", header)
+	}
+}
+
+func TestProxyEncodings(t *testing.T) {
+	const text = "a body long enough to be worth compressing, or so the upstream thinks"
+	compressed := gzipped(t, text)
+	responses := map[string]string{
+		"/v1/gzip":         upstreamResponse("Content-Encoding: gzip\r\nETag: \"v1\"\r\n", compressed),
+		"/v1/no-transform": upstreamResponse("Content-Encoding: gzip\r\nCache-Control: no-transform\r\n", compressed),
+		"/v1/chunked": "HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			strconv.FormatInt(int64(len(compressed[:10])), 16) + "\r\n" + compressed[:10] + "\r\n" +
+			strconv.FormatInt(int64(len(compressed[10:])), 16) + "\r\n" + compressed[10:] + "\r\n0\r\n\r\n",
+		"/v1/plain-chunked": "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n",
+	}
+	u := startUpstream(t, func(target string) string { return responses[target] })
+	addr := startProxy(t, u)
+	c := dial(t, addr)
+	gzipOK := map[string]string{"Connection": "keep-alive", "Accept-Encoding": "gzip"}
+	identity := map[string]string{"Connection": "keep-alive", "Accept-Encoding": "identity"}
+
+	// Bodies passed through keep the upstream's Content-Length; decoded
+	// and chunked ones are relayed chunked.
+	for _, tt := range []struct {
+		name, path string
+		headers    map[string]string
+		body       string
+		encoding   string
+		sized      bool
+	}{
+		{"pass-through", "/api/gzip", gzipOK, compressed, "gzip", true},
+		{"decompressed for the client", "/api/gzip", identity, text, "", false},
+		{"no-transform", "/api/no-transform", identity, compressed, "gzip", true},
+		{"chunked and decompressed", "/api/chunked", identity, text, "", false},
+		{"chunked pass-through", "/api/chunked", gzipOK, compressed, "gzip", false},
+	} {
+		resp := roundTrip(t, c, "GET", tt.path, tt.headers, nil)
+		if resp.Status != 200 || string(resp.Body) != tt.body {
+			t.Errorf("%s: GET %s = %d, %d body bytes; want 200 and %d", tt.name, tt.path, resp.Status, len(resp.Body), len(tt.body))
+			continue
+		}
+		if got := resp.Header("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s: Content-Encoding = %q, want %q", tt.name, got, tt.encoding)
+		}
+		if tt.sized && (resp.Header("Content-Length") != strconv.Itoa(len(tt.body)) || resp.Header("Transfer-Encoding") != "") {
+			t.Errorf("%s: Content-Length %q, Transfer-Encoding %q; want the upstream's length of %d", tt.name, resp.Header("Content-Length"), resp.Header("Transfer-Encoding"), len(tt.body))
+		}
+		if !tt.sized && (resp.Header("Content-Length") != "" || resp.Header("Transfer-Encoding") != "chunked") {
+			t.Errorf("%s: Content-Length %q, Transfer-Encoding %q; want it chunked", tt.name, resp.Header("Content-Length"), resp.Header("Transfer-Encoding"))
+		}
+		if got := resp.Header("Vary"); got != "Accept-Encoding" {
+			t.Errorf("%s: Vary = %q, want Accept-Encoding", tt.name, got)
+		}
+	}
+
+	if resp := roundTrip(t, c, "GET", "/api/gzip", identity, nil); resp.Header("ETag") != `W/"v1"` {
+		t.Errorf("decompressed ETag = %q, want it weakened", resp.Header("ETag"))
+	}
+	resp := roundTrip(t, c, "GET", "/api/plain-chunked", identity, nil)
+	if string(resp.Body) != "hello world" || resp.Header("Vary") != "" {
+		t.Errorf("plain chunked upstream: %q, headers %v", resp.Body, resp.Headers)
+	}
+
+	// HEAD cannot know the decoded length, so it announces GET's chunked
+	// framing rather than an empty body.
+	resp = roundTrip(t, c, "HEAD", "/api/gzip", identity, nil)
+	if resp.Header("Content-Length") != "" || resp.Header("Transfer-Encoding") != "chunked" || resp.Header("Content-Encoding") != "" {
+		t.Errorf("HEAD decoded: headers %v, want chunked without Content-Length or Content-Encoding", resp.Headers)
+	}
+	resp = roundTrip(t, c, "HEAD", "/api/gzip", gzipOK, nil)
+	if resp.Header("Content-Length") != strconv.Itoa(len(compressed)) || resp.Header("Content-Encoding") != "gzip" {
+		t.Errorf("HEAD passed through: headers %v, want the upstream's Content-Length", resp.Headers)
+	}
+}
+
+func TestProxyStreamsBody(t *testing.T) {
+	// The upstream sends half its body and holds the rest back until the
+	// proxy's client has seen the first half.
+	release := make(chan struct{})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			if line, err := r.ReadString('\n'); err != nil || line == "\r\n" {
+				break
+			}
+		}
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nfirst"))
+		<-release
+		conn.Write([]byte("-last"))
+	}()
+	t.Setenv("PROXY_MOUNTS", "/api=http://"+listener.Addr().String())
+	_, addr := startServer(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(testclient.BuildRequest("GET", "/api/x", addr, nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	raw := bufio.NewReader(conn)
+	var head strings.Builder
+	for !strings.HasSuffix(head.String(), "\r\n\r\n") {
+		b, err := raw.ReadByte()
+		if err != nil {
+			t.Fatalf("reading the head: %v", err)
+		}
+		head.WriteByte(b)
+	}
+	if !strings.Contains(head.String(), "Content-Length: 10") {
+		t.Errorf("head %q, want the upstream's Content-Length", head.String())
+	}
+	first := make([]byte, 5)
+	if _, err := io.ReadFull(raw, first); err != nil || string(first) != "first" {
+		t.Fatalf("first half = %q, %v before the upstream sent the rest", first, err)
+	}
+	close(release)
+	last := make([]byte, 5)
+	if _, err := io.ReadFull(raw, last); err != nil || string(last) != "-last" {
+		t.Errorf("second half = %q, %v", last, err)
+	}
+}
+
+func TestProxyUnreachableUpstream(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	t.Setenv("PROXY_MOUNTS", "/api=http://"+addr)
+	_, srvAddr := startServer(t)
+
+	if resp := roundTrip(t, dial(t, srvAddr), "GET", "/api/x", nil, nil); resp.Status != 502 {
+		t.Errorf("GET with the upstream down = %d, want 502", resp.Status)
+	}
+}
+
+func TestRegisterProxyMountsRejectsBadUpstream(t *testing.T) {
+	for _, upstream := range []string{"ftp://host/", "http://", "http://host/?q=1", "backend:8080"} {
+		t.Setenv("PROXY_MOUNTS", "/api="+upstream)
+		if _, err := NewServerFromConfig(config.LoadConfig()); err == nil {
+			t.Errorf("upstream %q accepted", upstream)
+		}
+	}
+}
+
+// stallingUpstream accepts connections, reads a request head from each,
+// sends sent and then stays silent until the test ends.
+func stallingUpstream(t *testing.T, sent string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+		listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					if line, err := r.ReadString('\n'); err != nil || line == "\r\n" {
+						break
+					}
+				}
+				conn.Write([]byte(sent))
+				<-done
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestProxyUpstreamTimeouts(t *testing.T) {
+	client := httpclient.New(httpclient.Options{
+		DialTimeout:     100 * time.Millisecond,
+		HeaderTimeout:   100 * time.Millisecond,
+		BodyIdleTimeout: 100 * time.Millisecond,
+	})
+	t.Cleanup(client.CloseIdleConnections)
+
+	for _, tt := range []struct {
+		phase, upstream string
+	}{
+		// A TLS upstream that never answers the handshake stalls the dial.
+		{"connecting", "https://" + stallingUpstream(t, "")},
+		{"headers", "http://" + stallingUpstream(t, "")},
+	} {
+		handler := ProxyHandler("/api", tt.upstream, client)
+		resp := handler(&Request{Method: "GET", Path: "/api/x", Headers: map[string]string{"host": "proxy"}})
+		if resp.Status != 504 || !strings.Contains(string(resp.Body), tt.phase) {
+			t.Errorf("upstream stalled in %s: %d %q, want 504 naming the phase", tt.phase, resp.Status, resp.Body)
+		}
+	}
+
+	// A body stalling after the headers were relayed aborts the stream.
+	handler := ProxyHandler("/api", "http://"+stallingUpstream(t, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello"), client)
+	resp := handler(&Request{Method: "GET", Path: "/api/x", Headers: map[string]string{"host": "proxy"}})
+	if resp.Status != 200 || resp.StreamFunc == nil {
+		t.Fatalf("upstream stalled in body: %d, want 200 relayed as a stream", resp.Status)
+	}
+	var body bytes.Buffer
+	var timeout *httpclient.TimeoutError
+	if err := resp.StreamFunc(&body); !errors.As(err, &timeout) || timeout.Phase != httpclient.PhaseBody {
+		t.Errorf("stalled body: stream error %v, want a body timeout", err)
+	}
+	if body.String() != "hello" {
+		t.Errorf("relayed %q before the stall, want hello", body.String())
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// QueryPolicy decides how a query parameter supplied more than once, as in
+// "?id=1&id=2", is resolved by the typed Query helpers.
+type QueryPolicy int
+
+const (
+	// QueryFirstWins uses the first value. It is the default.
+	QueryFirstWins QueryPolicy = iota + 1
+
+	// QueryLastWins uses the last value.
+	QueryLastWins
+
+	// QueryReject answers requests repeating any parameter with 400.
+	QueryReject
+
+	// QueryCollectAll keeps every value for QueryStrings, and makes the
+	// single-value helpers return a *ParamError for repeated parameters
+	// rather than guess which one was meant.
+	QueryCollectAll
+)
+
+// Default limits on the query string, applied unless SetQueryLimits
+// changes them. A request line longer than MaxRequestLineLength is
+// refused with 414 before the query is looked at.
+const (
+	DefaultMaxQueryParams = 100
+	DefaultMaxQueryLength = 8 * 1024
+)
+
+// ParseQueryPolicy converts a policy name ("first", "last", "reject" or
+// "all") into a QueryPolicy.
+func ParseQueryPolicy(name string) (QueryPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "first", "":
+		return QueryFirstWins, nil
+	case "last":
+		return QueryLastWins, nil
+	case "reject":
+		return QueryReject, nil
+	case "all":
+		return QueryCollectAll, nil
+	default:
+		return 0, fmt.Errorf("unknown query policy %q", name)
+	}
+}
+
+// OnDuplicateQuery sets how the route resolves repeated query parameters,
+// overriding the router default set with SetQueryPolicy.
+func (rt *Route) OnDuplicateQuery(policy QueryPolicy) *Route {
+	rt.queryPolicy = policy
+	return rt
+}
+
+// SetQueryPolicy sets the duplicate query parameter policy for routes that
+// do not declare their own.
+func (r *Router) SetQueryPolicy(policy QueryPolicy) {
+	r.queryPolicy = policy
+}
+
+// SetQueryLimits caps the number of query parameters and the length of the
+// raw query string. Requests over the length cap get 414 and requests over
+// the parameter cap get 400. A value of 0 disables that limit.
+func (r *Router) SetQueryLimits(maxParams, maxLength int) {
+	r.maxQueryParams = maxParams
+	r.maxQueryLength = maxLength
+}
+
+// checkQuery applies the query limits and the matched route's duplicate
+// policy to req. It returns a non-nil error response if the request must be
+// rejected.
+func (r *Router) checkQuery(req *Request, matched *Route) *Response {
+	req.queryPolicy = r.queryPolicy
+	if matched != nil && matched.queryPolicy != 0 {
+		req.queryPolicy = matched.queryPolicy
+	}
+	if req.queryPolicy == 0 {
+		req.queryPolicy = QueryFirstWins
+	}
+
+	if r.maxQueryLength > 0 && len(req.RawQuery) > r.maxQueryLength {
+		resp := NewHTTPError(414, fmt.Sprintf("query string exceeds %d bytes", r.maxQueryLength)).Response()
+		return &resp
+	}
+
+	values := req.queryValues()
+	count := 0
+	for name, vs := range values {
+		count += len(vs)
+		if req.queryPolicy == QueryReject && len(vs) > 1 {
+			resp := BadRequestErrorResponse(&ParamError{Source: "query", Name: name, Value: strings.Join(vs, ","), Err: ErrParamInvalid, Reason: "repeated parameter"})
+			return &resp
+		}
+	}
+	if r.maxQueryParams > 0 && count > r.maxQueryParams {
+		resp := NewHTTPError(400, fmt.Sprintf("query has %d parameters, limit is %d", count, r.maxQueryParams)).Response()
+		return &resp
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// queryRouter returns a router whose routes answer with the "id" query
+// parameter as read by QueryInt and QueryStrings, one route per policy
+// plus "/default" without one of its own.
+func queryRouter() *Router {
+	r := NewRouter()
+	handler := func(req *Request) Response {
+		id, err := req.QueryInt("id", 0)
+		if err != nil {
+			return BadRequestErrorResponse(err)
+		}
+		return textResponse(fmt.Sprintf("%d %v", id, req.QueryStrings("id")))
+	}
+	r.Handle("/default", "GET", handler)
+	r.Handle("/first", "GET", handler).OnDuplicateQuery(QueryFirstWins)
+	r.Handle("/last", "GET", handler).OnDuplicateQuery(QueryLastWins)
+	r.Handle("/reject", "GET", handler).OnDuplicateQuery(QueryReject)
+	r.Handle("/all", "GET", handler).OnDuplicateQuery(QueryCollectAll)
+	return r
+}
+
+func routeQuery(r *Router, target string) Response {
+	req := &Request{Method: "GET", Headers: map[string]string{}}
+	req.setTarget(target)
+	return r.Route(req)
+}
+
+func TestQueryPolicies(t *testing.T) {
+	for _, tt := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/default", 200, "1 [1 2]"},
+		{"/first", 200, "1 [1 2]"},
+		{"/last", 200, "2 [1 2]"},
+		{"/reject", 400, `query parameter "id"`},
+		{"/all", 400, "repeated parameter"},
+	} {
+		resp := routeQuery(queryRouter(), tt.path+"?id=1&id=2")
+		if resp.Status != tt.status || !strings.Contains(string(resp.Body), tt.body) {
+			t.Errorf("%s?id=1&id=2 = %d %q, want %d containing %q", tt.path, resp.Status, resp.Body, tt.status, tt.body)
+		}
+		// A single value reads the same under every policy.
+		if resp := routeQuery(queryRouter(), tt.path+"?id=7&other=1&other=2"); tt.path != "/reject" && string(resp.Body) != "7 [7]" {
+			t.Errorf("%s?id=7 = %d %q, want 7 [7]", tt.path, resp.Status, resp.Body)
+		}
+	}
+	// Reject applies to any repeated parameter, not just those read.
+	if resp := routeQuery(queryRouter(), "/reject?id=7&other=1&other=2"); resp.Status != 400 {
+		t.Errorf("/reject with a repeated unread parameter = %d, want 400", resp.Status)
+	}
+}
+
+func TestQueryPolicyRouterDefault(t *testing.T) {
+	r := queryRouter()
+	r.SetQueryPolicy(QueryReject)
+	if resp := routeQuery(r, "/default?id=1&id=2"); resp.Status != 400 {
+		t.Errorf("router default reject: %d %q, want 400", resp.Status, resp.Body)
+	}
+	// A route's own policy overrides the router's.
+	if resp := routeQuery(r, "/last?id=1&id=2"); resp.Status != 200 || string(resp.Body) != "2 [1 2]" {
+		t.Errorf("route policy over router default: %d %q, want last wins", resp.Status, resp.Body)
+	}
+}
+
+func TestQueryLimits(t *testing.T) {
+	r := queryRouter()
+	r.SetQueryLimits(3, 20)
+	for _, tt := range []struct {
+		query  string
+		status int
+	}{
+		{"id=1&a=2&b=3", 200},
+		{"id=1&a=2&b=3&c=4", 400},
+		{"id=1&id=2&id=3&id=4", 400},
+		{"id=1&a=" + strings.Repeat("x", 13), 200},
+		{"id=1&a=" + strings.Repeat("x", 14), 414},
+	} {
+		if resp := routeQuery(r, "/default?"+tt.query); resp.Status != tt.status {
+			t.Errorf("?%s = %d %q, want %d", tt.query, resp.Status, resp.Body, tt.status)
+		}
+	}
+
+	r.SetQueryLimits(0, 0)
+	if resp := routeQuery(r, "/default?id=1&"+strings.Repeat("a=1&", 500)); resp.Status != 200 {
+		t.Errorf("with limits disabled: %d, want 200", resp.Status)
+	}
+}
+
+func TestServeQueryTooLong(t *testing.T) {
+	_, addr := startServer(t)
+	for _, tt := range []struct {
+		length int
+		status int
+	}{
+		{1000, 200},
+		{MaxRequestLineLength, 414},
+		{5000, 414},
+	} {
+		target := "/?q=" + strings.Repeat("x", tt.length)
+		if resp := roundTrip(t, dial(t, addr), "GET", target, nil, nil); resp.Status != tt.status {
+			t.Errorf("GET with a %d-byte query = %d, want %d", tt.length, resp.Status, tt.status)
+		}
+	}
+}
+
+func TestParseQueryPolicy(t *testing.T) {
+	for name, want := range map[string]QueryPolicy{
+		"":         QueryFirstWins,
+		"first":    QueryFirstWins,
+		" LAST ":   QueryLastWins,
+		"reject":   QueryReject,
+		"all":      QueryCollectAll,
+		"whatever": 0,
+	} {
+		got, err := ParseQueryPolicy(name)
+		if got != want || (err != nil) != (want == 0) {
+			t.Errorf("ParseQueryPolicy(%q) = %v, %v; want %v", name, got, err, want)
+		}
+	}
+}
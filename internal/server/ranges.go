@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/httpdate"
+)
+
+// byteRange is a single satisfiable byte range with inclusive bounds.
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+// length returns the number of bytes covered by the range.
+func (r byteRange) length() int64 {
+	return r.end - r.start + 1
+}
+
+// contentRange formats the range as a Content-Range header value.
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size)
+}
+
+// fileETag returns a strong ETag derived from a file's size and modification time.
+//
+// The same file state always yields the same tag, so a HEAD, a full GET and
+// a later ranged resume all agree on the validator.
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+// fileLastModified returns the Last-Modified header value for a file.
+func fileLastModified(info os.FileInfo) string {
+	return httpdate.FormatHTTPDate(info.ModTime())
+}
+
+// parseByteRange parses a single-range "Range: bytes=..." header value
+// against a representation of the given size.
+//
+// Supported forms are "bytes=start-end", "bytes=start-" and the suffix form
+// "bytes=-n". Multi-range requests are not supported.
+//
+// Returns:
+//   - byteRange: The resolved range, clamped to the representation size.
+//   - bool (ok): False if the header is malformed or not a single range; the
+//     caller should ignore the header and serve the full body.
+//   - bool (satisfiable): False if the range lies outside the representation;
+//     the caller should respond with 416.
+func parseByteRange(header string, size int64) (byteRange, bool, bool) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return byteRange{}, false, false
+	}
+
+	startStr, endStr, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return byteRange{}, false, false
+	}
+	startStr = strings.TrimSpace(startStr)
+	endStr = strings.TrimSpace(endStr)
+
+	if startStr == "" {
+		suffix, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || suffix < 0 {
+			return byteRange{}, false, false
+		}
+		if suffix == 0 || size == 0 {
+			return byteRange{}, true, false
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return byteRange{start: size - suffix, end: size - 1}, true, true
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return byteRange{}, false, false
+	}
+
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return byteRange{}, false, false
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+
+	if start >= size {
+		return byteRange{}, true, false
+	}
+	return byteRange{start: start, end: end}, true, true
+}
+
+// ifRangeMatches evaluates an If-Range header against the current validators.
+//
+// An entity-tag value must match etag using strong comparison, so weak tags
+// never match. A date value, in any format accepted by httpdate, must equal
+// lastModified exactly, and only counts while lastModified is a strong
+// validator: at least a second older than now, the time of the response
+// (RFC 9110, section 13.1.5). A file written in the same second as the
+// first download could change again without its Last-Modified moving, so
+// a younger date never matches. A zero lastModified means there is none.
+// An empty header always matches, meaning the Range header applies
+// unconditionally.
+func ifRangeMatches(header, etag string, lastModified, now time.Time) bool {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return true
+	}
+
+	if strings.HasPrefix(header, "W/") {
+		return false
+	}
+	if strings.HasPrefix(header, `"`) {
+		return header == etag
+	}
+
+	if lastModified.IsZero() || now.Sub(lastModified) < time.Second {
+		return false
+	}
+	date, err := httpdate.ParseHTTPDate(header)
+	if err != nil {
+		return false
+	}
+	return date.Equal(lastModified.UTC().Truncate(time.Second))
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/httpdate"
+)
+
+func TestIfRangeMatches(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-time.Hour).Add(250 * time.Millisecond)
+	young := now.Add(-500 * time.Millisecond)
+	etag := `"abc-10"`
+
+	for _, tt := range []struct {
+		name, header string
+		lastModified time.Time
+		want         bool
+	}{
+		{"no header", "", old, true},
+		{"matching etag", `"abc-10"`, old, true},
+		{"other etag", `"abc-11"`, old, false},
+		{"weak etag", `W/"abc-10"`, old, false},
+		{"matching date", httpdate.FormatHTTPDate(old), old, true},
+		{"matching RFC 850 date", old.UTC().Format("Monday, 02-Jan-06 15:04:05 GMT"), old, true},
+		{"other date", httpdate.FormatHTTPDate(old.Add(time.Second)), old, false},
+		{"date of a file modified within the second", httpdate.FormatHTTPDate(young), young, false},
+		{"date without Last-Modified", httpdate.FormatHTTPDate(old), time.Time{}, false},
+		{"garbage", "yesterday", old, false},
+	} {
+		if got := ifRangeMatches(tt.header, etag, tt.lastModified, now); got != tt.want {
+			t.Errorf("%s: ifRangeMatches(%q) = %v, want %v", tt.name, tt.header, got, tt.want)
+		}
+	}
+}
+
+// resume asks for the rest of path after its first 5 bytes, on the
+// condition in ifRange.
+func resume(t *testing.T, addr, path, ifRange string) (int, string) {
+	t.Helper()
+	resp := roundTrip(t, dial(t, addr), "GET", path, map[string]string{"Range": "bytes=5-", "If-Range": ifRange}, nil)
+	return resp.Status, string(resp.Body)
+}
+
+func TestRangeResumeAfterModification(t *testing.T) {
+	public := chdirPublic(t)
+	file := filepath.Join(public, "big.txt")
+	if err := os.WriteFile(file, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, addr := startServer(t)
+
+	first := roundTrip(t, dial(t, addr), "GET", "/files/big.txt", nil, nil)
+	etag, lastModified := first.Header("ETag"), first.Header("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("GET sent ETag %q, Last-Modified %q; want both", etag, lastModified)
+	}
+	// The file changes within the second: its Last-Modified may not move.
+	if err := os.WriteFile(file, []byte("abcdefghij"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, ifRange := range []string{etag, lastModified} {
+		if status, body := resume(t, addr, "/files/big.txt", ifRange); status != 200 || body != "abcdefghij" {
+			t.Errorf("resume with If-Range %s after a change = %d %q, want the new file in full", ifRange, status, body)
+		}
+	}
+}
+
+func TestRangeResumeUnchanged(t *testing.T) {
+	public := chdirPublic(t)
+	file := filepath.Join(public, "big.txt")
+	if err := os.WriteFile(file, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Only a file older than a second has a strong Last-Modified.
+	hourAgo := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(file, hourAgo, hourAgo); err != nil {
+		t.Fatal(err)
+	}
+	_, addr := startServer(t)
+
+	first := roundTrip(t, dial(t, addr), "GET", "/files/big.txt", nil, nil)
+	for _, ifRange := range []string{first.Header("ETag"), first.Header("Last-Modified")} {
+		if status, body := resume(t, addr, "/files/big.txt", ifRange); status != 206 || body != "56789" {
+			t.Errorf("resume with If-Range %s = %d %q, want 206 56789", ifRange, status, body)
+		}
+	}
+}
+
+func TestTenantRangeResume(t *testing.T) {
+	a, _, addr := startTenantServer(t, "0")
+	file := filepath.Join(a, "big.txt")
+	if err := os.WriteFile(file, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	first := roundTrip(t, dial(t, addr), "GET", "/files/a/big.txt", nil, nil)
+	etag := first.Header("ETag")
+	if etag == "" || first.Header("Last-Modified") == "" || first.Header("Accept-Ranges") != "bytes" {
+		t.Fatalf("tenant GET headers = %v, want validators and Accept-Ranges", first.Headers)
+	}
+	head := roundTrip(t, dial(t, addr), "HEAD", "/files/a/big.txt", nil, nil)
+	if head.Header("ETag") != etag {
+		t.Errorf("HEAD ETag = %q, want GET's %q", head.Header("ETag"), etag)
+	}
+	if status, body := resume(t, addr, "/files/a/big.txt", etag); status != 206 || body != "56789" {
+		t.Errorf("resume = %d %q, want 206 56789", status, body)
+	}
+
+	if err := os.WriteFile(file, []byte("abcdefghij"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if status, body := resume(t, addr, "/files/a/big.txt", etag); status != 200 || body != "abcdefghij" {
+		t.Errorf("resume after a change = %d %q, want the new file in full", status, body)
+	}
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/clock"
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// RateDecision is the outcome of a rate limit check, used to populate the
+// RateLimit-* response headers.
+type RateDecision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	Reset     time.Duration // time until the current window ends
+	Scope     string        // "client" or "global": the limit reported
+	Route     string        // the route of a Route.RateLimit, "" for RateLimitMiddleware
+}
+
+// window counts requests within one fixed time window.
+type window struct {
+	start time.Time
+	count int
+}
+
+// RateLimiter enforces fixed-window request limits per client key and,
+// optionally, across all clients. When both apply, the stricter one wins:
+// a request is rejected if either budget is exhausted, and the headers
+// report whichever budget has fewer requests remaining.
+type RateLimiter struct {
+	mu          sync.Mutex
+	limit       int
+	globalLimit int
+	period      time.Duration
+	clock       clock.Clock
+
+	clients   map[string]*window
+	global    window
+	lastSweep time.Time
+}
+
+// NewRateLimiter creates a limiter allowing limit requests per client key
+// and globalLimit requests in total per period. A limit of 0 disables that
+// scope.
+func NewRateLimiter(limit, globalLimit int, period time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:       limit,
+		globalLimit: globalLimit,
+		period:      period,
+		clock:       clock.Real,
+		clients:     make(map[string]*window),
+	}
+}
+
+// SetClock replaces the limiter's time source, so tests can advance a
+// clock.Manual instead of sleeping through windows.
+func (l *RateLimiter) SetClock(c clock.Clock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.clock = c
+}
+
+// Allow records a request for key and reports whether it may proceed.
+func (l *RateLimiter) Allow(key string) RateDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	l.sweep(now)
+
+	decision := RateDecision{Allowed: true, Remaining: math.MaxInt}
+	var windows []*window
+
+	if l.limit > 0 {
+		w, ok := l.clients[key]
+		if !ok {
+			w = &window{}
+			l.clients[key] = w
+		}
+		l.check(w, l.limit, "client", now, &decision)
+		windows = append(windows, w)
+	}
+	if l.globalLimit > 0 {
+		l.check(&l.global, l.globalLimit, "global", now, &decision)
+		windows = append(windows, &l.global)
+	}
+
+	if decision.Allowed {
+		for _, w := range windows {
+			w.count++
+		}
+		decision.Remaining--
+	}
+	return decision
+}
+
+// check rolls w over if its period has elapsed and folds its state into
+// decision, keeping the scope with the fewest remaining requests. When both
+// scopes are exhausted the later reset wins, since the client must wait
+// for both.
+func (l *RateLimiter) check(w *window, limit int, scope string, now time.Time, decision *RateDecision) {
+	if w.start.IsZero() || now.Sub(w.start) >= l.period {
+		w.start = now
+		w.count = 0
+	}
+
+	remaining := limit - w.count
+	reset := w.start.Add(l.period).Sub(now)
+	stricter := remaining < decision.Remaining
+	if remaining <= 0 {
+		stricter = decision.Allowed || reset > decision.Reset
+		decision.Allowed = false
+	}
+	if stricter {
+		decision.Limit = limit
+		decision.Remaining = remaining
+		decision.Reset = reset
+		decision.Scope = scope
+	}
+}
+
+// sweep drops client windows that have expired, at most once per period.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.period {
+		return
+	}
+	l.lastSweep = now
+	for key, w := range l.clients {
+		if now.Sub(w.start) >= l.period {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// RateLimitMiddleware rejects requests exceeding limiter's budget with 429.
+//
+// Every response carries RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers. Rejections additionally carry Retry-After and a
+// problem body naming the limit that was hit. Clients are keyed by IP.
+func RateLimitMiddleware(limiter *RateLimiter) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) Response {
+			if req.priority {
+				return next(req)
+			}
+			decision := limiter.Allow(clientKey(req))
+			req.Set(RateDecisionKey, decision)
+			if !decision.Allowed {
+				utils.Warn("Rate limit (%s) exceeded for %s: %s %s", decision.Scope, clientKey(req), req.Method, req.Path)
+				resp := TooManyRequestsResponse(decision)
+				setRateLimitHeaders(&resp, decision)
+				return resp
+			}
+
+			resp := next(req)
+			setRateLimitHeaders(&resp, decision)
+			return resp
+		}
+	}
+}
+
+// setRateLimitHeaders writes the RateLimit-* headers for decision.
+func setRateLimitHeaders(resp *Response, decision RateDecision) {
+	if decision.Scope == "" {
+		return
+	}
+	if resp.Headers == nil {
+		resp.Headers = make(map[string]string)
+	}
+	resp.Headers["RateLimit-Limit"] = strconv.Itoa(decision.Limit)
+	resp.Headers["RateLimit-Remaining"] = strconv.Itoa(max(decision.Remaining, 0))
+	resp.Headers["RateLimit-Reset"] = strconv.Itoa(ceilSeconds(decision.Reset))
+}
+
+// TooManyRequestsResponse builds the 429 response for a rejected decision.
+func TooManyRequestsResponse(decision RateDecision) Response {
+	detail := fmt.Sprintf("%s rate limit of %d requests exceeded", decision.Scope, decision.Limit)
+	if decision.Route != "" {
+		detail = fmt.Sprintf("%s rate limit of %d requests for %s exceeded", decision.Scope, decision.Limit, decision.Route)
+	}
+	problem := NewHTTPError(429, detail)
+	problem.Extensions = map[string]any{
+		"scope":       decision.Scope,
+		"limit":       decision.Limit,
+		"retry_after": ceilSeconds(decision.Reset),
+	}
+	if decision.Route != "" {
+		problem.Extensions["route"] = decision.Route
+	}
+	resp := problem.Response()
+	resp.Headers["Retry-After"] = strconv.Itoa(ceilSeconds(decision.Reset))
+	return resp
+}
+
+// clientKey returns the rate limit key for a request: the client IP.
+func clientKey(req *Request) string {
+	return req.ClientIP()
+}
+
+// ceilSeconds rounds d up to whole seconds.
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/clock"
+	"github.com/Abb133Se/httpServer/internal/config"
+)
+
+func TestRateLimiterWindows(t *testing.T) {
+	fake := clock.NewManual(time.Unix(0, 0))
+	l := NewRateLimiter(2, 0, time.Minute)
+	l.SetClock(fake)
+
+	for i, want := range []RateDecision{
+		{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Minute, Scope: "client"},
+		{Allowed: true, Limit: 2, Remaining: 0, Reset: time.Minute, Scope: "client"},
+		{Allowed: false, Limit: 2, Remaining: 0, Reset: time.Minute, Scope: "client"},
+	} {
+		if got := l.Allow("a"); got != want {
+			t.Errorf("request %d: Allow = %+v, want %+v", i+1, got, want)
+		}
+	}
+	if got := l.Allow("b"); !got.Allowed {
+		t.Errorf("another client was refused: %+v", got)
+	}
+
+	fake.Advance(45 * time.Second)
+	if got := l.Allow("a"); got.Allowed || got.Reset != 15*time.Second {
+		t.Errorf("45s in: Allow = %+v, want refused with 15s to reset", got)
+	}
+	fake.Advance(15 * time.Second)
+	if got := l.Allow("a"); !got.Allowed || got.Remaining != 1 || got.Reset != time.Minute {
+		t.Errorf("after the window: Allow = %+v, want a fresh window", got)
+	}
+}
+
+func TestRateLimiterGlobalScope(t *testing.T) {
+	fake := clock.NewManual(time.Unix(0, 0))
+	l := NewRateLimiter(5, 3, time.Minute)
+	l.SetClock(fake)
+
+	for _, key := range []string{"a", "b", "c"} {
+		if got := l.Allow(key); !got.Allowed {
+			t.Fatalf("Allow(%q) = %+v, want allowed", key, got)
+		}
+	}
+	got := l.Allow("d")
+	if got.Allowed || got.Scope != "global" || got.Limit != 3 {
+		t.Errorf("fourth client: Allow = %+v, want refused by the global limit", got)
+	}
+
+	fake.Advance(time.Minute)
+	if got := l.Allow("d"); !got.Allowed || got.Scope != "global" || got.Remaining != 2 {
+		t.Errorf("after the window: Allow = %+v, want allowed with 2 global requests left", got)
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	fake := clock.NewManual(time.Unix(0, 0))
+	l := NewRateLimiter(3, 0, time.Minute)
+	l.SetClock(fake)
+	handler := RateLimitMiddleware(l)(func(*Request) Response { return textResponse("ok") })
+	request := func() Response {
+		return handler(&Request{Method: "GET", Path: "/", RemoteAddr: "192.0.2.1:1234", Headers: map[string]string{}})
+	}
+
+	for i, tt := range []struct {
+		status           int
+		remaining, reset string
+	}{
+		{200, "2", "60"},
+		{200, "1", "60"},
+		{200, "0", "60"},
+		{429, "0", "60"},
+	} {
+		resp := request()
+		if resp.Status != tt.status || resp.Headers["RateLimit-Limit"] != "3" ||
+			resp.Headers["RateLimit-Remaining"] != tt.remaining || resp.Headers["RateLimit-Reset"] != tt.reset {
+			t.Errorf("request %d: %d with RateLimit %s/%s reset %s; want %d with 3/%s reset %s", i+1, resp.Status,
+				resp.Headers["RateLimit-Remaining"], resp.Headers["RateLimit-Limit"], resp.Headers["RateLimit-Reset"],
+				tt.status, tt.remaining, tt.reset)
+		}
+	}
+
+	// Reset counts down to the window boundary, rounded up.
+	fake.Advance(40*time.Second + 500*time.Millisecond)
+	resp := request()
+	if resp.Status != 429 || resp.Headers["RateLimit-Reset"] != "20" || resp.Headers["Retry-After"] != "20" {
+		t.Errorf("40.5s in: %d, Reset %q, Retry-After %q; want 429, 20, 20",
+			resp.Status, resp.Headers["RateLimit-Reset"], resp.Headers["Retry-After"])
+	}
+	fake.Advance(20 * time.Second)
+	if resp := request(); resp.Status != 200 || resp.Headers["RateLimit-Remaining"] != "2" {
+		t.Errorf("next window: %d with %s remaining, want 200 with 2", resp.Status, resp.Headers["RateLimit-Remaining"])
+	}
+}
+
+func TestTooManyRequestsProblem(t *testing.T) {
+	resp := TooManyRequestsResponse(RateDecision{Limit: 3, Reset: 1500 * time.Millisecond, Scope: "global", Route: "GET /api/"})
+	if resp.Status != 429 || resp.Headers["Retry-After"] != "2" {
+		t.Errorf("429 = %d, Retry-After %q; want 429, 2", resp.Status, resp.Headers["Retry-After"])
+	}
+	srv := NewServer(&config.Config{}, NewRouter())
+	srv.finalizeResponse(&Request{Headers: map[string]string{"accept": "application/problem+json"}}, &resp)
+	var doc map[string]any
+	if err := json.Unmarshal(resp.Body, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["detail"] != "global rate limit of 3 requests for GET /api/ exceeded" ||
+		doc["scope"] != "global" || doc["limit"] != 3.0 || doc["retry_after"] != 2.0 || doc["route"] != "GET /api/" {
+		t.Errorf("problem document = %v", doc)
+	}
+}
+
+func TestRateLimitServer(t *testing.T) {
+	t.Setenv("RATE_LIMIT", "2")
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+
+	for i, want := range []int{200, 200, 429} {
+		resp := roundTrip(t, c, "GET", "/", keepAlive, nil)
+		if resp.Status != want || resp.Header("RateLimit-Limit") != "2" {
+			t.Errorf("request %d = %d, RateLimit-Limit %q; want %d, 2", i+1, resp.Status, resp.Header("RateLimit-Limit"), want)
+		}
+	}
+}
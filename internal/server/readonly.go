@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// Allow values advertised by the files API in each mode.
+const (
+	filesAllow         = "GET, HEAD, POST, PUT, DELETE, OPTIONS"
+	filesReadOnlyAllow = "GET, HEAD, OPTIONS"
+)
+
+// filesWriteMethods are refused with 403 while the files API is read-only,
+// including WebDAV-style methods the API does not implement, so a client
+// learns the files cannot be modified rather than that it used the wrong
+// method.
+var filesWriteMethods = map[string]bool{
+	"POST": true, "PUT": true, "DELETE": true, "PATCH": true, "MOVE": true, "COPY": true,
+}
+
+// ReadOnlySwitch makes a files handler refuse modifications. It can be
+// flipped at any time; requests already being handled are not affected.
+type ReadOnlySwitch struct {
+	on atomic.Bool
+}
+
+// Set turns read-only mode on or off.
+func (s *ReadOnlySwitch) Set(readOnly bool) {
+	if s.on.Swap(readOnly) != readOnly {
+		utils.Info("Files API read-only mode set to %t", readOnly)
+	}
+}
+
+// Enabled reports whether read-only mode is on.
+func (s *ReadOnlySwitch) Enabled() bool {
+	return s.on.Load()
+}
+
+// Guard wraps a files handler. While read-only mode is on, write methods
+// get 403 and OPTIONS and 405 responses advertise only the read methods.
+//
+// Example:
+//
+//	router.HandlePrefix("/files/", "", readOnly.Guard(files.handle))
+func (s *ReadOnlySwitch) Guard(next HandlerFunc) HandlerFunc {
+	return func(req *Request) Response {
+		method := strings.ToUpper(req.Method)
+		if !s.Enabled() {
+			if method == "OPTIONS" {
+				return OptionsResponse(filesAllow)
+			}
+			return next(req)
+		}
+
+		switch {
+		case filesWriteMethods[method]:
+			utils.Warn("Refused %s %s: files API is read-only", req.Method, req.Path)
+			Metrics.Counter("files_read_only_rejections_total").Inc()
+			return NewHTTPError(403, "the files API is in read-only mode; files cannot be created, changed or deleted").Response()
+		case method == "OPTIONS":
+			return OptionsResponse(filesReadOnlyAllow)
+		case method == "GET" || method == "HEAD":
+			return next(req)
+		default:
+			return MethodNotAllowedResponse(filesReadOnlyAllow)
+		}
+	}
+}
+
+// handleAdminFiles handles "/admin/files". GET returns the files API mode
+// as JSON; POST changes it, e.g. {"read_only": true}.
+func (s *Server) handleAdminFiles(req *Request) Response {
+	if req.Method == "POST" {
+		var mode struct {
+			ReadOnly *bool `json:"read_only"`
+		}
+		if err := json.Unmarshal(req.Body, &mode); err != nil {
+			return BadRequestErrorResponse(fmt.Errorf("invalid files mode: %w", err))
+		}
+		if mode.ReadOnly == nil {
+			return BadRequestErrorResponse(errors.New("read_only is required"))
+		}
+		s.filesReadOnly.Set(*mode.ReadOnly)
+	}
+	return jsonNoStore(map[string]bool{"read_only": s.filesReadOnly.Enabled()})
+}
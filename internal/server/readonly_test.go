@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadOnlyGuard(t *testing.T) {
+	var readOnly ReadOnlySwitch
+	reached := ""
+	handler := readOnly.Guard(func(req *Request) Response {
+		reached = req.Method
+		return textResponse("handled")
+	})
+	call := func(method string) Response {
+		reached = ""
+		req := &Request{Method: method, Headers: map[string]string{}}
+		req.setTarget("/files/a.txt")
+		return handler(req)
+	}
+
+	for _, method := range []string{"GET", "POST", "DELETE"} {
+		if resp := call(method); resp.Status != 200 || reached != method {
+			t.Errorf("writable: %s = %d, want it passed to the handler", method, resp.Status)
+		}
+	}
+	if resp := call("OPTIONS"); resp.Headers["Allow"] != filesAllow {
+		t.Errorf("writable: OPTIONS Allow = %q, want %q", resp.Headers["Allow"], filesAllow)
+	}
+
+	readOnly.Set(true)
+	for _, method := range []string{"POST", "PUT", "DELETE", "PATCH", "MOVE", "COPY", "put"} {
+		if resp := call(method); resp.Status != 403 || reached != "" {
+			t.Errorf("read-only: %s = %d (handler reached: %t), want 403 before the handler", method, resp.Status, reached != "")
+		}
+	}
+	for _, method := range []string{"GET", "HEAD"} {
+		if resp := call(method); resp.Status != 200 || reached != method {
+			t.Errorf("read-only: %s = %d, want it passed to the handler", method, resp.Status)
+		}
+	}
+	if resp := call("OPTIONS"); resp.Status != 204 || resp.Headers["Allow"] != filesReadOnlyAllow {
+		t.Errorf("read-only: OPTIONS = %d Allow %q, want 204 %q", resp.Status, resp.Headers["Allow"], filesReadOnlyAllow)
+	}
+	if resp := call("PROPFIND"); resp.Status != 405 || resp.Headers["Allow"] != filesReadOnlyAllow {
+		t.Errorf("read-only: PROPFIND = %d Allow %q, want 405 %q", resp.Status, resp.Headers["Allow"], filesReadOnlyAllow)
+	}
+
+	readOnly.Set(false)
+	if resp := call("PUT"); resp.Status != 200 || reached != "PUT" {
+		t.Errorf("writable again: PUT = %d, want it passed to the handler", resp.Status)
+	}
+}
+
+func TestServeFilesReadOnlyToggle(t *testing.T) {
+	public := chdirPublic(t)
+	file := filepath.Join(public, "a.txt")
+	if err := os.WriteFile(file, []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ADMIN_TOKEN", "secret")
+	_, addr := startServer(t)
+	admin := map[string]string{"Authorization": "Bearer secret"}
+	request := func(method, path string, headers map[string]string, body string) int {
+		t.Helper()
+		return roundTrip(t, dial(t, addr), method, path, headers, []byte(body)).Status
+	}
+	setReadOnly := func(on bool) {
+		t.Helper()
+		body := `{"read_only": false}`
+		if on {
+			body = `{"read_only": true}`
+		}
+		if status := request("POST", "/admin/files", admin, body); status != 200 {
+			t.Fatalf("POST /admin/files = %d, want 200", status)
+		}
+	}
+
+	setReadOnly(true)
+	for _, method := range []string{"PUT", "POST", "DELETE", "PATCH"} {
+		if status := request(method, "/files/a.txt", nil, "changed"); status != 403 {
+			t.Errorf("read-only: %s /files/a.txt = %d, want 403", method, status)
+		}
+	}
+	if data, err := os.ReadFile(file); err != nil || string(data) != "original" {
+		t.Errorf("file after refused writes = %q, %v; want it untouched", data, err)
+	}
+	if status := request("GET", "/files/a.txt", nil, ""); status != 200 {
+		t.Errorf("read-only: GET /files/a.txt = %d, want 200", status)
+	}
+	resp := roundTrip(t, dial(t, addr), "OPTIONS", "/files/a.txt", nil, nil)
+	if got := resp.Header("Allow"); got != filesReadOnlyAllow {
+		t.Errorf("read-only: Allow = %q, want %q", got, filesReadOnlyAllow)
+	}
+	resp = roundTrip(t, dial(t, addr), "GET", "/admin/config", admin, nil)
+	var cfg struct {
+		FilesReadOnly bool `json:"files_read_only"`
+	}
+	if err := json.Unmarshal(resp.Body, &cfg); err != nil || !cfg.FilesReadOnly {
+		t.Errorf("/admin/config = %s (%v), want files_read_only true", resp.Body, err)
+	}
+
+	setReadOnly(false)
+	if status := request("DELETE", "/files/a.txt", nil, ""); status >= 300 {
+		t.Errorf("writable again: DELETE /files/a.txt = %d, want success", status)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("file after DELETE: %v, want it gone", err)
+	}
+}
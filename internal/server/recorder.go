@@ -0,0 +1,370 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// RecordingVersion is the version of the spool file format written by
+// Recorder. Readers refuse recordings of other versions.
+const RecordingVersion = 1
+
+// RecordingExt is the file extension of spool files.
+const RecordingExt = ".rec.json"
+
+// Redacted replaces the value of a redacted header in a recording.
+const Redacted = "[REDACTED]"
+
+// DefaultRedactHeaders are the headers a Recorder always redacts.
+var DefaultRedactHeaders = []string{"authorization", "proxy-authorization", "cookie", "set-cookie", "x-api-key"}
+
+// Recording is one request and the response it got, as bytes on the wire.
+// It is the content of a spool file, encoded as JSON; Request and Response
+// are base64 strings there.
+type Recording struct {
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"` // when the request head had been read
+
+	// Connection metadata. ConnRequest counts the requests read on the
+	// connection, this one included.
+	RemoteAddr  string    `json:"remote_addr"`
+	LocalAddr   string    `json:"local_addr"`
+	TLS         bool      `json:"tls"`
+	ConnStart   time.Time `json:"conn_start"`
+	ConnRequest int       `json:"conn_request"`
+
+	// Request holds the bytes read from the connection for the request,
+	// before parsing, and Response the bytes written back. A truncated
+	// side was cut at the recorder's size cap.
+	Request           []byte   `json:"request"`
+	RequestTruncated  bool     `json:"request_truncated,omitempty"`
+	Response          []byte   `json:"response"`
+	ResponseTruncated bool     `json:"response_truncated,omitempty"`
+	RedactedHeaders   []string `json:"redacted_headers,omitempty"` // lowercased names whose values were replaced
+}
+
+// ReadRecording reads the spool file at path.
+func ReadRecording(path string) (*Recording, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rec Recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("%s: invalid recording: %w", path, err)
+	}
+	if rec.Version != RecordingVersion {
+		return nil, fmt.Errorf("%s: recording version %d, want %d", path, rec.Version, RecordingVersion)
+	}
+	return &rec, nil
+}
+
+// Recorder writes sampled requests and their responses, as received and
+// sent, to a spool directory, one file per exchange (see Recording), for
+// replaying them later. It is off until enabled, see /admin/recorder.
+//
+// Sensitive headers are redacted before anything is written. Each side
+// of an exchange is cut at maxBytes, and nothing more is written once the
+// spool holds maxSpool bytes.
+type Recorder struct {
+	dir      string
+	maxBytes int
+	maxSpool int64
+	redact   map[string]bool
+
+	on      atomic.Bool
+	percent atomic.Int32
+
+	mu    sync.Mutex
+	spool int64 // bytes in the spool directory
+	seq   uint64
+}
+
+// NewRecorder creates a Recorder spooling to dir, creating it if needed,
+// that records percent of the requests once enabled. redact names headers
+// to redact besides DefaultRedactHeaders.
+func NewRecorder(dir string, percent, maxBytes int, maxSpool int64, redact []string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("invalid recorder spool: %w", err)
+	}
+	r := &Recorder{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxSpool: maxSpool,
+		redact:   make(map[string]bool),
+	}
+	for _, name := range append(slices.Clone(DefaultRedactHeaders), redact...) {
+		r.redact[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	r.SetSamplePercent(percent)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recorder spool: %w", err)
+	}
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && strings.HasSuffix(entry.Name(), RecordingExt) {
+			r.spool += info.Size()
+		}
+	}
+	return r, nil
+}
+
+// SetEnabled turns recording on or off for requests read from now on.
+func (r *Recorder) SetEnabled(on bool) {
+	if r.on.Swap(on) != on {
+		utils.Info("Request recording set to %t, spooling to %s", on, r.dir)
+	}
+}
+
+// Enabled reports whether recording is on.
+func (r *Recorder) Enabled() bool {
+	return r.on.Load()
+}
+
+// SetSamplePercent sets the share of requests recorded, clamped to 0-100.
+func (r *Recorder) SetSamplePercent(percent int) {
+	r.percent.Store(int32(min(max(percent, 0), 100)))
+}
+
+// SamplePercent returns the share of requests recorded.
+func (r *Recorder) SamplePercent() int {
+	return int(r.percent.Load())
+}
+
+// begin starts recording the next request read from conn, or returns nil
+// if it is not to be recorded. Every recordExchange method is a no-op on
+// nil.
+func (r *Recorder) begin(conn net.Conn, connStart time.Time) *recordExchange {
+	if r == nil || !r.Enabled() || rand.Intn(100) >= r.SamplePercent() {
+		return nil
+	}
+	_, isTLS := conn.(*tls.Conn)
+	return &recordExchange{
+		recorder: r,
+		rec: Recording{
+			Version:    RecordingVersion,
+			RemoteAddr: conn.RemoteAddr().String(),
+			LocalAddr:  conn.LocalAddr().String(),
+			TLS:        isTLS,
+			ConnStart:  connStart,
+		},
+		in:  cappedBuffer{limit: r.maxBytes},
+		out: cappedBuffer{limit: r.maxBytes},
+	}
+}
+
+// recordExchange collects one exchange for its Recorder.
+type recordExchange struct {
+	recorder *Recorder
+	rec      Recording
+	in, out  cappedBuffer
+}
+
+// connSource reads a connection, copying everything read into the
+// exchange being recorded, if any. Bytes of a pipelined request read
+// ahead with the one before it are recorded with that one.
+type connSource struct {
+	conn net.Conn
+	rec  *recordExchange
+
+	stash []byte // read by watch ahead of the next request
+	err   error  // ended the watch read, returned once stash is drained
+}
+
+func (s *connSource) Read(p []byte) (int, error) {
+	if len(s.stash) > 0 {
+		n := copy(p, s.stash)
+		s.stash = s.stash[n:]
+		if s.rec != nil {
+			s.rec.in.Write(p[:n])
+		}
+		return n, nil
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	n, err := s.conn.Read(p)
+	if s.rec != nil {
+		s.rec.in.Write(p[:n])
+	}
+	return n, err
+}
+
+// sink returns the connection the response is to be written to: conn,
+// with everything written to it copied into the recording.
+func (x *recordExchange) sink(conn net.Conn) net.Conn {
+	if x == nil {
+		return conn
+	}
+	return &teeConn{Conn: conn, w: &x.out}
+}
+
+// headRead stamps the recording once the request head has been read, the
+// connRequest-th on its connection.
+func (x *recordExchange) headRead(now time.Time, connRequest int) {
+	if x == nil {
+		return
+	}
+	x.rec.Time = now
+	x.rec.ConnRequest = connRequest
+}
+
+// finish redacts the exchange and writes it to the spool.
+func (x *recordExchange) finish() {
+	if x == nil {
+		return
+	}
+	r := x.recorder
+	rec := x.rec
+	var redacted map[string]bool
+	rec.Request, redacted = redactHead(x.in.buf.Bytes(), r.redact, nil)
+	rec.Response, redacted = redactHead(x.out.buf.Bytes(), r.redact, redacted)
+	rec.RequestTruncated, rec.ResponseTruncated = x.in.truncated, x.out.truncated
+	for name := range redacted {
+		rec.RedactedHeaders = append(rec.RedactedHeaders, name)
+	}
+	slices.Sort(rec.RedactedHeaders)
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		utils.Error("Failed to encode recording: %v", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.spool+int64(len(data)) > r.maxSpool {
+		Metrics.Counter("recorder_dropped_total").Inc()
+		utils.Debug("Recorder spool %s is full; dropping recording", r.dir)
+		return
+	}
+	r.seq++
+	name := fmt.Sprintf("%s-%06d%s", rec.Time.UTC().Format("20060102T150405.000000000Z"), r.seq, RecordingExt)
+	if err := os.WriteFile(filepath.Join(r.dir, name), data, 0600); err != nil {
+		Metrics.Counter("recorder_errors_total").Inc()
+		utils.Warn("Failed to write recording: %v", err)
+		return
+	}
+	r.spool += int64(len(data))
+	Metrics.Counter("recorder_recordings_total").Inc()
+}
+
+// redactHead replaces the values of the header lines named in names in the
+// head of the raw message msg, adding the names it redacted to seen. The
+// body is left as it is. A message cut before the end of its head is
+// treated as all head.
+func redactHead(msg []byte, names, seen map[string]bool) ([]byte, map[string]bool) {
+	end := bytes.Index(msg, []byte("\r\n\r\n"))
+	if lf := bytes.Index(msg, []byte("\n\n")); lf >= 0 && (end < 0 || lf < end) {
+		end = lf
+	}
+	if end < 0 {
+		end = len(msg)
+	}
+	lines := bytes.SplitAfter(msg[:end], []byte("\n"))
+	var out bytes.Buffer
+	out.Grow(len(msg))
+	for i, line := range lines {
+		name, _, ok := bytes.Cut(line, []byte(":"))
+		if i == 0 || !ok || !names[strings.ToLower(strings.TrimSpace(string(name)))] {
+			out.Write(line)
+			continue
+		}
+		if seen == nil {
+			seen = make(map[string]bool)
+		}
+		seen[strings.ToLower(strings.TrimSpace(string(name)))] = true
+		out.Write(name)
+		out.WriteString(": " + Redacted)
+		switch {
+		case bytes.HasSuffix(line, []byte("\r\n")):
+			out.WriteString("\r\n")
+		case bytes.HasSuffix(line, []byte("\n")):
+			out.WriteString("\n")
+		}
+	}
+	out.Write(msg[end:])
+	return out.Bytes(), seen
+}
+
+// cappedBuffer keeps the first limit bytes written to it and notes
+// whether more came. Writes never fail.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:max(room, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// teeConn copies everything written to a connection to w.
+type teeConn struct {
+	net.Conn
+	w io.Writer
+}
+
+func (c *teeConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.w.Write(p[:n])
+	return n, err
+}
+
+// handleAdminRecorder shows the recorder state and, on POST, changes it:
+// {"enabled": true, "sample_percent": 10}. Either field may be left out.
+func (s *Server) handleAdminRecorder(req *Request) Response {
+	if s.recorder == nil {
+		return NewHTTPError(404, "request recording is not configured, see RECORD_DIR").Response()
+	}
+	if req.Method == "POST" {
+		var change struct {
+			Enabled       *bool `json:"enabled"`
+			SamplePercent *int  `json:"sample_percent"`
+		}
+		if err := json.Unmarshal(req.Body, &change); err != nil {
+			return BadRequestErrorResponse(fmt.Errorf("invalid recorder settings: %w", err))
+		}
+		if change.Enabled == nil && change.SamplePercent == nil {
+			return BadRequestErrorResponse(errors.New("enabled or sample_percent is required"))
+		}
+		if change.SamplePercent != nil {
+			s.recorder.SetSamplePercent(*change.SamplePercent)
+		}
+		if change.Enabled != nil {
+			s.recorder.SetEnabled(*change.Enabled)
+		}
+	}
+	s.recorder.mu.Lock()
+	spool := s.recorder.spool
+	s.recorder.mu.Unlock()
+	return jsonNoStore(map[string]any{
+		"enabled":         s.recorder.Enabled(),
+		"sample_percent":  s.recorder.SamplePercent(),
+		"dir":             s.recorder.dir,
+		"spool_bytes":     spool,
+		"spool_max_bytes": s.recorder.maxSpool,
+	})
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// ReloadRoutes re-reads the .env file (see config.ReloadConfig) and swaps
+// the main router's routes for the ones it configures, such as
+// STATIC_MOUNTS, ROUTE_RATE_LIMITS or DOCS_ENABLED (see Router.Swap). If
+// the new routes fail to build or validate, the running ones are kept and
+// the error is returned. Everything else, including the admin API,
+// middleware, listeners and the files API's trash and event broker, keeps
+// its startup configuration until the server is restarted.
+func (s *Server) ReloadRoutes() error {
+	cfg, err := config.ReloadConfig()
+	if err == nil {
+		err = s.router.Swap(func(r *Router) error {
+			return s.registerRoutes(r, cfg, s.config.AdminAddr == "")
+		})
+	}
+	if err != nil {
+		utils.Warn("Route reload rejected, keeping the current routes: %v", err)
+		return err
+	}
+	return nil
+}
+
+// handleAdminRoutesReload handles POST /admin/routes/reload, answering 422
+// with the problems found if the reloaded routes were rejected.
+func (s *Server) handleAdminRoutesReload(req *Request) Response {
+	if err := s.ReloadRoutes(); err != nil {
+		return NewHTTPError(422, fmt.Sprintf("routes not reloaded: %v", err)).Response()
+	}
+	utils.Info("Admin reloaded routes")
+	return Response{
+		Version: HTTPVersion,
+		Status:  200,
+		Reason:  "OK",
+		Headers: map[string]string{"Content-Type": "text/plain", "Cache-Control": "no-store"},
+		Body:    fmt.Appendf(nil, "routes reloaded: %d routes\n", s.router.current().routeCount()),
+	}
+}
+
+// reloadOnSignal calls ReloadRoutes on every SIGHUP until the server has
+// shut down.
+func (s *Server) reloadOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-signals:
+			utils.Info("Received SIGHUP, reloading routes")
+			s.ReloadRoutes()
+		case <-s.shutdownDone:
+			return
+		}
+	}
+}
@@ -2,10 +2,13 @@ package server
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net"
+	"net/url"
 	"strconv"
 	"strings"
 
@@ -18,12 +21,68 @@ import (
 // optional body of the request. Header keys are normalized to
 // lowercase.
 type Request struct {
-	Method  string
+	Method string
+
+	// Path is the path component of the request target, as sent; the
+	// query string is split off into RawQuery and Query.
 	Path    string
 	Version string
+
+	// RawQuery is the request target's query string, without the "?" and
+	// not decoded. Query holds its parameters, percent-decoded, with the
+	// values of a repeated key in the order they were sent; use
+	// QueryParam or the Query* accessors to read them.
+	RawQuery string
+	Query    map[string][]string
+
 	Headers map[string]string
 	Body    []byte
 	Params  map[string]string
+
+	// Trailers holds the trailer fields sent after a chunked body, keyed
+	// by lowercased name, or is nil.
+	Trailers map[string]string
+
+	// TLSState describes the TLS session the request arrived on, or is nil
+	// for plaintext connections.
+	TLSState *TLSInfo
+
+	// RemoteAddr is the network address of the client, "ip:port".
+	RemoteAddr string
+
+	// RawRequestLine and RawHeaders hold the request line and header lines
+	// exactly as received, without line terminators and in wire order.
+	// They are only filled in when raw capture is enabled (see
+	// Server.SetRawCapture) and are capped at MaxRawCaptureBytes in total;
+	// a capped capture ends with RawCaptureTruncated.
+	RawRequestLine string
+	RawHeaders     []string
+
+	queryPolicy QueryPolicy    // set by the router from the matched route
+	route       *Route         // the matched route, nil if none
+	table       *Router        // the route table req is routed with, see Router.Swap
+	resolved    bool           // route and resolveErr are set, see Router.resolve
+	priority    bool           // travels in the router's priority lane, see PriorityLane
+	resolveErr  *Response      // response for a request no route accepts
+	basePath    string         // mount prefix stripped from Path, see BasePath
+	sentPath    string         // Path as sent, if normalization changed it
+	values      map[string]any // request-scoped store, see Set and Get
+	bodyStream  *bodyStream    // the unread body, on a route with StreamBody
+
+	memory     *MemoryBudget // accounts the body, see MAX_BUFFERED_BYTES
+	memoryHeld int64         // bytes of memory reserved for the body
+
+	releaseSlot func() // frees the bulkhead slot a stream holds, see holdSlotForStream
+
+	previewLimit   int          // bytes captured by BodyPreview, 0 for the default
+	trusted        bool         // arrived on a connection an accept filter trusted
+	trustedProxies []*net.IPNet // skipped in X-Forwarded-For by ClientIP
+
+	beforeBodyDone    bool              // the pre-body middleware has run
+	beforeBodyHeaders map[string]string // headers it set on a passing request
+
+	ctx   context.Context // cancelled once the response is sent or the client goes away
+	tasks *taskGroup      // tracks Go; nil outside a Server
 }
 
 const (
@@ -36,53 +95,94 @@ const (
 	MaxRequestLineLength = 4096     // 4 KB max for request line
 	MaxHeaderLineLength  = 8192     // 8 KB max for each header line
 	MaxBodySize          = 10 << 20 // 10 MB max body
+
+	// MaxRawCaptureBytes caps the request line and header bytes kept by
+	// raw capture.
+	MaxRawCaptureBytes = 16 << 10
+
+	// RawCaptureTruncated is appended to RawHeaders when the capture cap
+	// was reached.
+	RawCaptureTruncated = "[raw capture truncated]"
 )
 
 // ParseRequest reads and parses an HTTP/1.1 request from a TCP connection.
 //
 // It reads the request line, headers, and optionally the body if a
-// valid Content-Length header is present. Chunked transfer encoding
-// is not supported.
+// valid Content-Length header is present or it is sent with
+// "Transfer-Encoding: chunked", which is decoded into Body. Bodies are
+// handled per DefaultBodyPolicies: refused bodies yield an *HTTPError
+// before any of the body is read. A client sending "Expect: 100-continue"
+// is told to continue once the body has been accepted.
 func ParseRequest(conn net.Conn) (*Request, error) {
 	reader := bufio.NewReader(conn)
+	req, err := readRequestHead(reader, headOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if err := readRequestBody(reader, conn, req, DefaultBodyPolicies); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
 
-	requestLine, err := reader.ReadString('\n')
+// readRequestHead reads the request line and headers, leaving the body,
+// if any, unread in reader. opts selects raw capture and lenient parsing.
+func readRequestHead(reader *bufio.Reader, opts headOptions) (*Request, error) {
+	requestLine, rawLine, err := readHeadLine(reader, opts.lenient)
 	if err != nil {
 		if errors.Is(err, io.EOF) {
 			utils.Debug("Client closed connection before sending request")
 			return nil, err
 		}
-		utils.Error("Failed to read request line: %v", err)
+		if errors.Is(err, errBareLF) {
+			utils.Warn("Malformed request line: %v", err)
+			return nil, fmt.Errorf("malformed request line: %w", err)
+		}
+		logReadError("request line", err)
 		return nil, fmt.Errorf("failed to read request line: %w", err)
 	}
 
-	requestLine = strings.TrimSpace(requestLine)
 	if len(requestLine) > MaxRequestLineLength {
 		utils.Warn("Request line too long: %d bytes", len(requestLine))
-		return nil, fmt.Errorf("request line too long")
+		return nil, NewHTTPError(414, fmt.Sprintf("request line exceeds %d bytes", MaxRequestLineLength))
 	}
 
-	parts := strings.Split(requestLine, " ")
-	if len(parts) != 3 {
+	method, target, version, err := parseRequestLine(requestLine, opts.lenient)
+	if err != nil {
 		utils.Warn("Malformed request line: %s", requestLine)
-		return nil, fmt.Errorf("malformed request line: %s", requestLine)
+		return nil, err
 	}
 
 	req := &Request{
-		Method:  parts[0],
-		Path:    parts[1],
-		Version: parts[2],
+		Method:  method,
+		Version: version,
 		Headers: make(map[string]string),
 	}
+	req.setTarget(target)
+
+	var capture *rawCapture
+	if opts.captureRaw {
+		capture = &rawCapture{remaining: MaxRawCaptureBytes}
+		req.RawRequestLine = capture.take(rawLine)
+		if capture.truncated {
+			req.RawHeaders = []string{RawCaptureTruncated}
+		}
+	}
 
 	for {
-		line, err := reader.ReadString('\n')
+		line, raw, err := readHeadLine(reader, opts.lenient)
 		if err != nil {
-			utils.Error("Failed to read header: %v", err)
+			if errors.Is(err, errBareLF) {
+				utils.Warn("Malformed header line: %v", err)
+				return nil, fmt.Errorf("malformed header line: %w", err)
+			}
+			logReadError("header", err)
 			return nil, fmt.Errorf("failed to read header: %w", err)
 		}
-		line = strings.TrimSpace(line)
-		if line == "" {
+		if capture != nil && strings.TrimSpace(line) != "" {
+			capture.appendTo(&req.RawHeaders, raw)
+		}
+		if line == "" || (opts.lenient && strings.TrimFunc(line, isSpaceOrControl) == "") {
 			break
 		}
 
@@ -91,38 +191,267 @@ func ParseRequest(conn net.Conn) (*Request, error) {
 			return nil, fmt.Errorf("header line too long")
 		}
 
-		headerParts := strings.SplitN(line, ":", 2)
-		if len(headerParts) == 2 {
-			key := strings.TrimSpace(headerParts[0])
-			value := strings.TrimSpace(headerParts[1])
-			req.Headers[strings.ToLower(key)] = value
-		} else {
+		key, value, ok, err := parseHeaderLine(line, opts.lenient)
+		if err != nil {
+			utils.Warn("Malformed header line: %v", err)
+			return nil, NewHTTPError(400, err.Error())
+		}
+		if !ok {
 			utils.Warn("Skipping malformed header line: %s", line)
+			continue
 		}
+		if prev, dup := req.Headers[key]; dup && key == "content-length" && prev != value {
+			utils.Warn("Conflicting Content-Length headers: %q and %q", prev, value)
+			return nil, NewHTTPError(400, "conflicting Content-Length headers")
+		}
+		req.Headers[key] = value
 	}
 
-	if val, ok := req.Headers["content-length"]; ok {
-		contentLength, err := strconv.Atoi(val)
-		if err != nil {
-			utils.Error("Invalid Content-Length: %v", err)
-			return nil, fmt.Errorf("invalid Content-Length: %w", err)
+	if err := checkFraming(req); err != nil {
+		utils.Warn("Ambiguous request framing: %v", err)
+		return nil, err
+	}
+
+	if expect, ok := req.Headers["expect"]; ok && !strings.EqualFold(expect, "100-continue") {
+		utils.Warn("Unsupported expectation: %s", expect)
+		return nil, NewHTTPError(417, fmt.Sprintf("unsupported expectation %q", expect))
+	}
+
+	utils.Debug("Parsed request head: method=%s, path=%s, headers=%v", req.Method, req.Path, req.Headers)
+	return req, nil
+}
+
+// readRequestBody reads the body declared by req's Content-Length from
+// reader, applying the method's body policy first so a refused body is
+// never read. If the client expects 100-continue, the interim response is
+// written to w right before the body is read. Chunked bodies are read by
+// readChunkedRequestBody.
+func readRequestBody(reader *bufio.Reader, w io.Writer, req *Request, bodyPolicies map[string]BodyPolicy) error {
+	if req.isChunked() {
+		return readChunkedRequestBody(reader, w, req, bodyPolicies)
+	}
+	contentLength, err := req.declaredLength()
+	if err != nil {
+		utils.Error("Invalid Content-Length: %v", err)
+		return err
+	}
+	policy, err := checkBodyPolicy(req.Method, contentLength, bodyPolicies)
+	if err != nil || contentLength == 0 {
+		return err
+	}
+	if policy.Action == BodyAllow {
+		// Refused before 100 Continue, so the client need not send it.
+		if err := req.reserveBody(contentLength); err != nil {
+			return err
 		}
+	}
+
+	if err := sendContinue(w, req); err != nil {
+		return err
+	}
 
-		if contentLength > MaxBodySize {
-			utils.Warn("Request body too large: %d bytes", contentLength)
-			return nil, fmt.Errorf("request body too large")
+	if policy.Action != BodyAllow {
+		if _, err := io.CopyN(io.Discard, reader, contentLength); err != nil {
+			return fmt.Errorf("failed to drain body: %w", err)
 		}
+		utils.Debug("Drained %d byte body on %s", contentLength, req.Method)
+		return nil
+	}
 
-		body := make([]byte, contentLength)
-		_, err = io.ReadFull(reader, body)
-		if err != nil {
-			utils.Error("Failed to read request body: %v", err)
-			return nil, fmt.Errorf("failed to read body: %w", err)
+	body := make([]byte, contentLength)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		logReadError("request body", err)
+		return fmt.Errorf("failed to read body: %w", err)
+	}
+	utils.Debug("Request body size: %d bytes", len(body))
+	req.Body = body
+	return nil
+}
+
+// declaredLength returns the body length from the Content-Length header,
+// 0 when there is none.
+func (r *Request) declaredLength() (int64, error) {
+	val, ok := r.Headers["content-length"]
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid Content-Length: %q", val)
+	}
+	return n, nil
+}
+
+// sendContinue writes "100 Continue" to w if the client waits for it.
+func sendContinue(w io.Writer, req *Request) error {
+	if !req.expectsContinue() {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "%s 100 Continue%s%s", HTTPVersion, CRLF, CRLF); err != nil {
+		return fmt.Errorf("failed to send 100 Continue: %w", err)
+	}
+	utils.Debug("Sent 100 Continue for %s %s", req.Method, req.Path)
+	return nil
+}
+
+// expectsContinue reports whether the client waits for "100 Continue"
+// before sending the body. HTTP/1.0 clients never get interim responses.
+func (r *Request) expectsContinue() bool {
+	return r.Version == HTTPVersion && strings.EqualFold(r.Headers["expect"], "100-continue")
+}
+
+// rawCapture keeps raw request lines until its byte budget runs out.
+type rawCapture struct {
+	remaining int
+	truncated bool
+}
+
+// take returns line without its terminator, cut to the remaining budget.
+func (c *rawCapture) take(line string) string {
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) > c.remaining {
+		line = line[:c.remaining]
+		c.truncated = true
+	}
+	c.remaining -= len(line)
+	return line
+}
+
+// appendTo adds line to lines, followed by RawCaptureTruncated if the
+// budget ran out on it. Later lines are dropped.
+func (c *rawCapture) appendTo(lines *[]string, line string) {
+	if c.truncated {
+		return
+	}
+	if kept := c.take(line); kept != "" {
+		*lines = append(*lines, kept)
+	}
+	if c.truncated {
+		*lines = append(*lines, RawCaptureTruncated)
+	}
+}
+
+// ContentType parses the request's Content-Type header into a lowercase
+// media type and its parameters, e.g. "multipart/form-data" and
+// {"boundary": "xyz"}. It returns "" and nil if the header is absent or
+// malformed.
+func (r *Request) ContentType() (string, map[string]string) {
+	raw := r.Headers["content-type"]
+	if raw == "" {
+		return "", nil
+	}
+	mediaType, params, err := mime.ParseMediaType(raw)
+	if err != nil {
+		utils.Debug("Malformed Content-Type %q: %v", raw, err)
+		return "", nil
+	}
+	return mediaType, params
+}
+
+// Context returns the request's context. It is cancelled when the response
+// has been sent, writing it to the client fails or the client closes the
+// connection while the request is handled, so handlers can stop work
+// nobody will see the result of. context.Cause tells a client that went
+// away apart from other cancellations.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// Host returns the host named by the request's Host header, lowercased and
+// without a port. It is derived from each request on its own, since
+// keep-alive clients may address different hosts over one connection.
+func (r *Request) Host() string {
+	host := strings.ToLower(r.Headers["host"])
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.Trim(host, "[]")
+}
+
+// BaseURL returns the scheme and host the client addressed, followed by
+// the base path the router stripped from Path, e.g.
+// "https://example.com/api", so that BaseURL() + Path is the URL of the
+// request. On a connection marked trusted by an accept filter (see
+// AcceptTrusted), the proxy's Forwarded header, or else its
+// X-Forwarded-Proto and X-Forwarded-Host, name them; otherwise those
+// headers are ignored. Without a usable host it returns just the base
+// path, so URLs built on it are root-relative.
+func (r *Request) BaseURL() string {
+	scheme := "http"
+	if r.TLSState != nil {
+		scheme = "https"
+	}
+	host := r.Headers["host"]
+	if r.trusted {
+		proto, forwardedHost := forwardedOrigin(r.Headers)
+		if strings.EqualFold(proto, "http") || strings.EqualFold(proto, "https") {
+			scheme = strings.ToLower(proto)
+		}
+		if forwardedHost != "" {
+			host = forwardedHost
 		}
-		utils.Debug("Request body size: %d bytes", len(req.Body))
-		req.Body = body
 	}
+	if u, err := url.Parse("//" + host); host == "" || err != nil || u.Host != host || u.User != nil || u.Path != "" {
+		return r.basePath
+	}
+	return scheme + "://" + host + r.basePath
+}
 
-	utils.Debug("Parsed request: method=%s, path=%s, headers=%v", req.Method, req.Path, req.Headers)
-	return req, nil
+// forwardedOrigin returns the protocol and host the client used, as told
+// by the first hop of the Forwarded header (RFC 7239), or by
+// X-Forwarded-Proto and X-Forwarded-Host if it is absent.
+func forwardedOrigin(headers map[string]string) (proto, host string) {
+	forwarded, ok := headers["forwarded"]
+	if !ok {
+		first := func(list string) string {
+			value, _, _ := strings.Cut(list, ",")
+			return strings.TrimSpace(value)
+		}
+		return first(headers["x-forwarded-proto"]), first(headers["x-forwarded-host"])
+	}
+	hop, _, _ := strings.Cut(forwarded, ",")
+	for _, pair := range strings.Split(hop, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		value = strings.Trim(value, `"`)
+		switch strings.ToLower(name) {
+		case "proto":
+			proto = value
+		case "host":
+			host = value
+		}
+	}
+	return proto, host
+}
+
+// ClientIP returns the IP of the client that sent the request. On a
+// connection marked trusted by an accept filter (see AcceptTrusted),
+// X-Forwarded-For is read from the right, where each proxy appended the
+// peer it saw, and the first address that is not itself a trusted proxy
+// (see Server.TrustProxies) is returned. Entries left of it were written
+// by the client and are never believed. Otherwise the header is ignored,
+// because any client can send it, and the connection's peer address is
+// returned.
+func (r *Request) ClientIP() string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !r.trusted {
+		return host
+	}
+	hops := strings.Split(r.Headers["x-forwarded-for"], ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break // garbage from an untrusted hop; keep the last trusted one
+		}
+		host = ip.String()
+		if !ipInNets(host, r.trustedProxies) {
+			break
+		}
+	}
+	return host
 }
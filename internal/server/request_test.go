@@ -0,0 +1,213 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func mustNets(t *testing.T, entries ...string) []*net.IPNet {
+	t.Helper()
+	nets, err := parseIPNets(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nets
+}
+
+func TestClientIP(t *testing.T) {
+	proxies := mustNets(t, "10.0.0.0/8")
+	tests := []struct {
+		name    string
+		trusted bool
+		xff     string
+		want    string
+	}{
+		{"untrusted peer ignores header", false, "1.2.3.4", "192.0.2.1"},
+		{"no header", true, "", "192.0.2.1"},
+		{"single hop", true, "203.0.113.7", "203.0.113.7"},
+		{"spoofed leftmost entry", true, "1.2.3.4, 203.0.113.7", "203.0.113.7"},
+		{"chain of trusted proxies", true, "1.2.3.4, 203.0.113.7, 10.0.0.2, 10.0.0.3", "203.0.113.7"},
+		{"only trusted proxies", true, "10.0.0.2, 10.0.0.3", "10.0.0.2"},
+		{"garbage before a proxy", true, "nonsense, 10.0.0.2", "10.0.0.2"},
+		{"garbage last", true, "203.0.113.7, nonsense", "192.0.2.1"},
+		{"IPv6", true, "2001:db8::1", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Request{
+				RemoteAddr:     "192.0.2.1:5555",
+				Headers:        map[string]string{},
+				trusted:        tt.trusted,
+				trustedProxies: proxies,
+			}
+			if tt.xff != "" {
+				req.Headers["x-forwarded-for"] = tt.xff
+			}
+			if got := req.ClientIP(); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpoofedForwardedForKeepsRateLimitKey(t *testing.T) {
+	newReq := func(xff string) *Request {
+		return &Request{
+			RemoteAddr:     "10.0.0.1:5555",
+			Headers:        map[string]string{"x-forwarded-for": xff},
+			trusted:        true,
+			trustedProxies: mustNets(t, "10.0.0.1"),
+		}
+	}
+	honest := clientKey(newReq("203.0.113.7"))
+	for _, spoofed := range []string{"1.1.1.1, 203.0.113.7", "127.0.0.1, 203.0.113.7", "8.8.8.8, 9.9.9.9, 203.0.113.7"} {
+		if got := clientKey(newReq(spoofed)); got != honest {
+			t.Errorf("X-Forwarded-For %q: clientKey = %q, want %q", spoofed, got, honest)
+		}
+	}
+}
+
+func TestBatchSubRequestKeepsForwardedHeaders(t *testing.T) {
+	outer := &Request{
+		RemoteAddr:     "10.0.0.1:5555",
+		Headers:        map[string]string{"x-forwarded-for": "203.0.113.7"},
+		trusted:        true,
+		trustedProxies: mustNets(t, "10.0.0.1"),
+	}
+	sub, err := newBatchRequest(outer, BatchItem{
+		Path:    "/",
+		Headers: map[string]string{"X-Forwarded-For": "127.0.0.1", "X-Forwarded-Host": "evil.example"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sub.ClientIP(); got != "203.0.113.7" {
+		t.Errorf("sub-request ClientIP() = %q, want the batch request's", got)
+	}
+	if _, ok := sub.Headers["x-forwarded-host"]; ok {
+		t.Error("sub-request kept its own X-Forwarded-Host")
+	}
+}
+
+func TestRequestContentType(t *testing.T) {
+	for _, tt := range []struct {
+		header    string
+		mediaType string
+		params    map[string]string
+	}{
+		{"", "", nil},
+		{"text/plain", "text/plain", map[string]string{}},
+		{"Text/HTML; Charset=ISO-8859-1", "text/html", map[string]string{"charset": "ISO-8859-1"}},
+		{`multipart/form-data; boundary="----a b"`, "multipart/form-data", map[string]string{"boundary": "----a b"}},
+		{"application/json;charset=utf-8", "application/json", map[string]string{"charset": "utf-8"}},
+		{"text/plain; charset", "", nil},
+		{"/", "", nil},
+	} {
+		req := &Request{Headers: map[string]string{}}
+		if tt.header != "" {
+			req.Headers["content-type"] = tt.header
+		}
+		mediaType, params := req.ContentType()
+		if mediaType != tt.mediaType || !maps.Equal(params, tt.params) || (params == nil) != (tt.params == nil) {
+			t.Errorf("ContentType() for %q = %q %v, want %q %v", tt.header, mediaType, params, tt.mediaType, tt.params)
+		}
+	}
+}
+
+func TestRawCapture(t *testing.T) {
+	head := "GET /a%2Fb?q=1 HTTP/1.1\r\n" +
+		"user-agent: probe/1.0\r\n" +
+		"Host:example.com\r\n" +
+		"X-Dup: one\r\n" +
+		"ACCEPT:   */*  \r\n" +
+		"X-Dup: two\r\n" +
+		"\r\n"
+	want := []string{"user-agent: probe/1.0", "Host:example.com", "X-Dup: one", "ACCEPT:   */*  ", "X-Dup: two"}
+
+	req, err := readRequestHead(bufio.NewReader(strings.NewReader(head)), headOptions{captureRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.RawRequestLine != "GET /a%2Fb?q=1 HTTP/1.1" {
+		t.Errorf("RawRequestLine = %q, want the line as sent", req.RawRequestLine)
+	}
+	if !slices.Equal(req.RawHeaders, want) {
+		t.Errorf("RawHeaders = %q, want %q", req.RawHeaders, want)
+	}
+	if req.Headers["x-dup"] != "two" || req.Headers["accept"] != "*/*" {
+		t.Errorf("parsed headers = %v, capture must not change them", req.Headers)
+	}
+
+	req, err = readRequestHead(bufio.NewReader(strings.NewReader(head)), headOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.RawRequestLine != "" || req.RawHeaders != nil {
+		t.Errorf("without capture: RawRequestLine = %q, RawHeaders = %q; want neither", req.RawRequestLine, req.RawHeaders)
+	}
+}
+
+func TestRawCaptureCap(t *testing.T) {
+	var head strings.Builder
+	head.WriteString("GET / HTTP/1.1\r\nHost: x\r\n")
+	line := strings.Repeat("v", 1000)
+	for i := range 20 {
+		fmt.Fprintf(&head, "X-Fill-%02d: %s\r\n", i, line)
+	}
+	head.WriteString("\r\n")
+
+	req, err := readRequestHead(bufio.NewReader(strings.NewReader(head.String())), headOptions{captureRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Headers) != 21 {
+		t.Errorf("parsed %d headers, want all 21 despite the capture cap", len(req.Headers))
+	}
+	n := len(req.RawHeaders)
+	if n < 2 || req.RawHeaders[n-1] != RawCaptureTruncated {
+		t.Fatalf("RawHeaders ends with %q, want the truncation marker", req.RawHeaders[n-1])
+	}
+	captured := len(req.RawRequestLine)
+	for i, raw := range req.RawHeaders[:n-1] {
+		captured += len(raw)
+		if want := fmt.Sprintf("X-Fill-%02d: ", i-1); i > 0 && !strings.HasPrefix(raw, want) {
+			t.Errorf("RawHeaders[%d] = %.20q..., want it to start with %q", i, raw, want)
+		}
+	}
+	if captured != MaxRawCaptureBytes {
+		t.Errorf("captured %d bytes, want exactly the %d byte cap", captured, MaxRawCaptureBytes)
+	}
+}
+
+func TestRawCaptureServer(t *testing.T) {
+	srv, addr := startServerWithRoutes(t, func(r *Router) {
+		r.Handle("/raw", "GET", func(req *Request) Response {
+			return textResponse(req.RawRequestLine + "|" + strings.Join(req.RawHeaders, "|"))
+		})
+	})
+	c := dial(t, addr)
+	send := func() string {
+		t.Helper()
+		if err := c.SendRaw([]byte("GET /raw HTTP/1.1\r\nzeta: 1\r\nHost: x\r\nAlpha:2\r\nConnection: keep-alive\r\n\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.ReadResponse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(resp.Body)
+	}
+
+	if got := send(); got != "|" {
+		t.Errorf("capture off: handler saw %q, want nothing captured", got)
+	}
+	srv.SetRawCapture(true)
+	if got, want := send(), "GET /raw HTTP/1.1|zeta: 1|Host: x|Alpha:2|Connection: keep-alive"; got != want {
+		t.Errorf("capture on: handler saw %q, want %q", got, want)
+	}
+}
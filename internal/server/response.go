@@ -21,16 +21,62 @@ import (
 //   - Headers: Response headers as a key-value map.
 //   - Body:    The response body content as a string.
 type Response struct {
-	Version    string
-	Status     int
-	Reason     string
-	Headers    map[string]string
-	Body       []byte
+	Version string
+	Status  int
+	Reason  string
+	Headers map[string]string
+	Body    []byte
+
+	// StreamFunc, if set, produces the body incrementally. By the time it
+	// is called the status line and headers have been sent, so the response
+	// is committed: a failure can no longer change the status. Returning an
+	// error aborts the body instead. The connection is closed without the
+	// terminal zero-length chunk, so clients see a truncated response. If
+	// the response declares "Trailer: X-Stream-Error", the body is instead
+	// terminated with that trailer carrying the error code (see StreamError).
+	// Long-running streams should stop when req.Context() is done. The
+	// writer is a *StreamWriter (see AsStreamWriter), which queues writes
+	// so a client that stops reading can be detected and worked around.
 	StreamFunc func(io.Writer) error
+
+	// ContentLength declares the size of a body that was not produced,
+	// typically for HEAD, where building the body would be wasted work. It
+	// is sent as Content-Length when Body is empty and no Content-Length
+	// header is set; no body bytes are ever written for it.
+	ContentLength int64
+
+	// ChunkSize is how many bytes a chunked StreamFunc may buffer before a
+	// chunk is emitted, so many small writes become few large chunks.
+	// Flush on the stream writer emits buffered data immediately. Zero uses
+	// the server's STREAM_CHUNK_SIZE (DefaultChunkSize outside a Server);
+	// 1 emits every write as its own chunk.
+	ChunkSize int
+
+	// cancel, if set, is called when writing the body to the client fails,
+	// cancelling the request context that StreamFunc observes.
+	cancel func()
+
+	// stream describes a StreamFunc's request for the server's stream
+	// monitoring, see StreamWriter.
+	stream *streamOptions
+
+	// problem describes an error response so it can be re-rendered in the
+	// representation the client negotiated (see renderProblem).
+	problem *HTTPError
 }
 
+// DefaultChunkSize is the chunk aggregation size used when a response does
+// not set ChunkSize.
+const DefaultChunkSize = 8 << 10
+
+// ChunkedWriter frames writes with chunked transfer coding. Writes smaller
+// than its chunk size are aggregated until the buffer fills or Flush is
+// called, since every chunk costs a size line and CRLF on the wire.
 type ChunkedWriter struct {
-	w *bufio.Writer
+	w    *bufio.Writer
+	size int
+	buf  []byte
+	err  error // first write error; every later write returns it
 }
 
 // BuildResponse constructs a raw HTTP response string from the provided parameters.
@@ -52,6 +98,10 @@ type ChunkedWriter struct {
 // Behavior:
 //   - Automatically sets "Content-Length" based on body size.
 //   - Defaults "Content-Type" to "text/plain" if none is specified.
+//   - Appends "; charset=utf-8" to text/* and application/json types that
+//     name no charset.
+//   - Canonicalizes header names and merges keys differing only in case
+//     (see canonicalHeaders), so defaults never duplicate a handler's header.
 //   - Constructs the response in the correct HTTP/1.1 format.
 //
 // Example:
@@ -73,17 +123,19 @@ func BuildResponse(status int, reason string, headers map[string]string, body []
 	}
 
 	// If not set by handler, set default headers
-	if _, ok := headers["Content-Type"]; !ok {
-		headers["Content-Type"] = "text/plain"
+	fields := canonicalHeaders(headers)
+	if !hasHeaderField(fields, "Content-Type") {
+		fields = append(fields, headerField{"Content-Type", "text/plain"})
 	}
-	if _, ok := headers["Content-Length"]; !ok {
-		headers["Content-Length"] = strconv.Itoa(len(body))
+	if !hasHeaderField(fields, "Content-Length") {
+		fields = append(fields, headerField{"Content-Length", strconv.Itoa(len(body))})
 	}
+	applyDefaultCharset(fields)
 
 	var sb strings.Builder
 	sb.WriteString(fmt.Sprintf("%s %d %s%s", HTTPVersion, status, reason, CRLF))
-	for k, v := range headers {
-		sb.WriteString(fmt.Sprintf("%s: %s%s", k, v, CRLF))
+	for _, f := range fields {
+		sb.WriteString(fmt.Sprintf("%s: %s%s", f.name, f.value, CRLF))
 	}
 	sb.WriteString(CRLF)
 
@@ -104,10 +156,18 @@ func BuildResponse(status int, reason string, headers map[string]string, body []
 //   - res:  The HTTP response to send.
 //
 // Returns:
+//   - int64: The bytes written to the connection: status line, headers and
+//     body, streamed chunks included, even when writing failed part way.
 //   - error: Any error encountered while writing to the connection.
 //
 // Behavior:
-//   - Calls BuildResponse to format the response.
+//   - Writes canonical header names, merging case-insensitive duplicates.
+//   - Appends "; charset=utf-8" to text/* and application/json types that
+//     name no charset.
+//   - Uses chunked encoding for a StreamFunc without a Content-Length.
+//   - Adds Content-Length to a buffered body that lacks one, so the
+//     response stays framed on keep-alive connections. Without a body,
+//     ContentLength is used instead.
 //   - Sends the response over the TCP connection.
 //
 // Example:
@@ -120,35 +180,69 @@ func BuildResponse(status int, reason string, headers map[string]string, body []
 //	    Body:    "Hello, world!",
 //	}
 //
-//	if err := server.SendResponse(conn, res); err != nil {
+//	if _, err := server.SendResponse(conn, res); err != nil {
 //	    log.Printf("failed to send response: %v", err)
 //	}
-func SendResponse(conn net.Conn, res Response) error {
+func SendResponse(conn net.Conn, res Response) (int64, error) {
+	counted := &countingConn{Conn: conn}
+	err := writeResponse(counted, res)
+	return counted.n, err
+}
+
+// writeResponse writes res to conn, see SendResponse.
+func writeResponse(conn net.Conn, res Response) error {
 	writer := bufio.NewWriter(conn)
 
 	fmt.Fprintf(writer, "%s %d %s%s", res.Version, res.Status, res.Reason, CRLF)
 
-	if res.StreamFunc != nil {
-		res.Headers["Transfer-Encoding"] = "chunked"
+	// A stream of known length is written as-is; otherwise it is chunked.
+	fields := canonicalHeaders(res.Headers)
+	applyDefaultCharset(fields)
+	sized := hasHeaderField(fields, "Content-Length")
+	if res.StreamFunc != nil && !sized {
+		fields = append(fields, headerField{"Transfer-Encoding", "chunked"})
+	}
+	if res.StreamFunc == nil && !sized && !hasHeaderField(fields, "Transfer-Encoding") && statusAllowsBody(res.Status) {
+		length := int64(len(res.Body))
+		if length == 0 {
+			length = res.ContentLength
+		}
+		fields = append(fields, headerField{"Content-Length", strconv.FormatInt(length, 10)})
 	}
 
-	for k, v := range res.Headers {
-		fmt.Fprintf(writer, "%s: %s%s", k, v, CRLF)
+	for _, f := range fields {
+		fmt.Fprintf(writer, "%s: %s%s", f.name, f.value, CRLF)
 	}
 	fmt.Fprintf(writer, "%s", CRLF)
 	writer.Flush()
 
+	if res.StreamFunc != nil && sized {
+		if err := runStream(conn, res, &abortWriter{w: writer, cancel: res.cancel}); err != nil {
+			return abortStream(writer, nil, err)
+		}
+		return writer.Flush()
+	}
+
 	if res.StreamFunc != nil {
-		chunkedWriter := NewChunkedWriter(writer)
-		if err := res.StreamFunc(chunkedWriter); err != nil {
-			utils.Error("Stram error: %v", err)
+		chunkSize := res.ChunkSize
+		if chunkSize == 0 {
+			chunkSize = DefaultChunkSize
+		}
+		chunkedWriter := NewChunkedWriterSize(writer, chunkSize)
+		if err := runStream(conn, res, &abortWriter{w: chunkedWriter, cancel: res.cancel}); err != nil {
+			if declaresTrailer(fields, streamErrorTrailer) {
+				return abortStream(writer, chunkedWriter, err)
+			}
+			chunkedWriter.emit()
+			return abortStream(writer, nil, err)
+		}
+		if err := chunkedWriter.Close(); err != nil {
 			return err
 		}
-		chunkedWriter.Close()
 		return writer.Flush()
 	}
 
-	if len(res.Body) > 0 {
+	if len(res.Body) > 0 && statusAllowsBody(res.Status) {
 		_, err := writer.Write(res.Body)
 		if err != nil {
 			return err
@@ -157,23 +251,154 @@ func SendResponse(conn net.Conn, res Response) error {
 	return writer.Flush()
 }
 
+// countingConn counts the bytes written to a connection.
+type countingConn struct {
+	net.Conn
+	n int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// runStream runs res.StreamFunc with a StreamWriter sending to w, and
+// waits until everything it wrote has been sent. It returns the
+// StreamFunc's error, or else the first failed write.
+func runStream(conn net.Conn, res Response, w io.Writer) error {
+	sw := newStreamWriter(conn, w, res.stream)
+	err := res.StreamFunc(sw)
+	if closeErr := sw.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// stripHEADBody turns resp into the answer to a HEAD request. The body is
+// dropped but its length and framing headers are kept, so they match what
+// GET would send.
+func stripHEADBody(resp *Response) {
+	if len(resp.Body) > 0 {
+		resp.ContentLength = int64(len(resp.Body))
+		resp.Body = nil
+	}
+	if resp.StreamFunc != nil {
+		if !hasHeaderField(canonicalHeaders(resp.Headers), "Content-Length") {
+			resp.Headers["Transfer-Encoding"] = "chunked"
+		}
+		resp.StreamFunc = nil
+	}
+}
+
+// statusAllowsBody reports whether a response with status may carry a body
+// (and therefore a Content-Length): 1xx, 204 and 304 never do.
+func statusAllowsBody(status int) bool {
+	return status >= 200 && status != 204 && status != 304
+}
+
+// NewChunkedWriter creates a ChunkedWriter that emits every write as its
+// own chunk.
 func NewChunkedWriter(w *bufio.Writer) *ChunkedWriter {
-	return &ChunkedWriter{w: w}
+	return &ChunkedWriter{w: w, size: 1}
+}
+
+// NewChunkedWriterSize creates a ChunkedWriter that buffers writes until
+// size bytes are pending. A size of 1 or less disables aggregation.
+func NewChunkedWriterSize(w *bufio.Writer, size int) *ChunkedWriter {
+	if size < 1 {
+		size = 1
+	}
+	return &ChunkedWriter{w: w, size: size}
 }
 
 func (cw *ChunkedWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
 	if len(p) == 0 {
 		return 0, nil
 	}
 
-	fmt.Fprintf(cw.w, "%x%s", len(p), CRLF)
-	cw.w.Write(p)
-	cw.w.WriteString(CRLF)
+	// A write that fills a chunk by itself skips the copy into buf.
+	if len(cw.buf) == 0 && len(p) >= cw.size {
+		if err := cw.writeChunk(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.size {
+		if err := cw.emit(); err != nil {
+			return 0, err
+		}
+	}
 	return len(p), nil
 }
 
+// emit writes the buffered data as one chunk.
+func (cw *ChunkedWriter) emit() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	err := cw.writeChunk(cw.buf)
+	cw.buf = cw.buf[:0]
+	return err
+}
+
+// writeChunk writes p as one chunk. Once a write has failed, typically
+// because the client disconnected, it keeps returning that error without
+// writing, so the StreamFunc sees it on its next write and can stop.
+func (cw *ChunkedWriter) writeChunk(p []byte) error {
+	if cw.err != nil {
+		return cw.err
+	}
+	Metrics.Counter("stream_chunks_total").Inc()
+	if _, err := fmt.Fprintf(cw.w, "%x%s", len(p), CRLF); err != nil {
+		cw.err = err
+		return err
+	}
+	if _, err := cw.w.Write(p); err != nil {
+		cw.err = err
+		return err
+	}
+	if _, err := cw.w.WriteString(CRLF); err != nil {
+		cw.err = err
+		return err
+	}
+	return nil
+}
+
 func (cw *ChunkedWriter) Close() error {
+	if err := cw.emit(); err != nil {
+		return err
+	}
 	// Write zero-length chunk to signal end
 	_, err := cw.w.WriteString("0\r\n\r\n")
 	return err
 }
+
+// CloseWithTrailers ends the chunked body with the given trailer fields.
+func (cw *ChunkedWriter) CloseWithTrailers(trailers map[string]string) error {
+	cw.emit()
+	cw.w.WriteString("0" + CRLF)
+	for _, f := range canonicalHeaders(trailers) {
+		fmt.Fprintf(cw.w, "%s: %s%s", f.name, f.value, CRLF)
+	}
+	_, err := cw.w.WriteString(CRLF)
+	return err
+}
+
+// Flush emits any aggregated data as a chunk and sends it to the client,
+// so that delayed writes reach it as they are produced.
+func (cw *ChunkedWriter) Flush() error {
+	if err := cw.emit(); err != nil {
+		return err
+	}
+	if err := cw.w.Flush(); err != nil {
+		cw.err = err
+		return err
+	}
+	return nil
+}
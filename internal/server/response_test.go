@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// chunkSizes splits the chunked body in wire into the sizes of its chunks,
+// the terminating zero-size chunk included.
+func chunkSizes(t *testing.T, wire string) []int {
+	t.Helper()
+	var sizes []int
+	for wire != "" {
+		line, rest, ok := strings.Cut(wire, "\r\n")
+		if !ok {
+			t.Fatalf("unterminated chunk size line in %q", wire)
+		}
+		size, err := strconv.ParseInt(line, 16, 64)
+		if err != nil || int64(len(rest)) < size+2 || rest[size:size+2] != "\r\n" {
+			t.Fatalf("malformed chunk at %q", wire)
+		}
+		sizes = append(sizes, int(size))
+		wire = rest[size+2:]
+		if size == 0 {
+			if wire != "" {
+				t.Fatalf("data after the last chunk: %q", wire)
+			}
+			break
+		}
+	}
+	return sizes
+}
+
+func TestChunkedWriterAggregation(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		size   int
+		writes []int
+		sizes  []int
+	}{
+		{"unbuffered", 1, []int{3, 5, 1}, []int{3, 5, 1, 0}},
+		{"size below one", 0, []int{3, 5}, []int{3, 5, 0}},
+		{"small writes aggregate", 8, []int{3, 3, 3, 1}, []int{9, 1, 0}},
+		{"exactly one chunk", 8, []int{4, 4}, []int{8, 0}},
+		{"large write bypasses the buffer", 8, []int{20}, []int{20, 0}},
+		{"large write after buffered data", 8, []int{2, 20}, []int{22, 0}},
+		{"remainder emitted on Close", 8, []int{5}, []int{5, 0}},
+		{"empty writes", 8, []int{0, 0}, []int{0}},
+		{"nothing written", 8, nil, []int{0}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			bw := bufio.NewWriter(&out)
+			cw := NewChunkedWriterSize(bw, tt.size)
+			var body []byte
+			for i, n := range tt.writes {
+				p := bytes.Repeat([]byte{byte('a' + i)}, n)
+				body = append(body, p...)
+				if written, err := cw.Write(p); written != n || err != nil {
+					t.Fatalf("Write(%d bytes) = %d, %v", n, written, err)
+				}
+			}
+			if err := cw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			bw.Flush()
+			if got := chunkSizes(t, out.String()); !slices.Equal(got, tt.sizes) {
+				t.Errorf("chunks %v, want %v", got, tt.sizes)
+			}
+			var decoded bytes.Buffer
+			if _, _, err := readChunkedBody(bufio.NewReader(&out), &decoded, 1<<20); err != nil || !bytes.Equal(decoded.Bytes(), body) {
+				t.Errorf("decoded %q, %v; want %q", decoded.Bytes(), err, body)
+			}
+		})
+	}
+}
+
+func TestChunkedWriterFlushEmitsPartialChunk(t *testing.T) {
+	var out bytes.Buffer
+	cw := NewChunkedWriterSize(bufio.NewWriter(&out), 1024)
+	cw.Write([]byte("tick"))
+	if out.Len() != 0 {
+		t.Fatalf("%q sent before Flush, want the write held back", out.String())
+	}
+	if err := cw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "4\r\ntick\r\n" {
+		t.Errorf("after Flush %q, want the pending data as one chunk", out.String())
+	}
+	cw.Flush()
+	if out.String() != "4\r\ntick\r\n" {
+		t.Errorf("second Flush sent %q, want no empty chunk", out.String())
+	}
+}
+
+func TestChunkedWriterStopsAfterError(t *testing.T) {
+	failing := &failingWriter{after: 1}
+	cw := NewChunkedWriter(bufio.NewWriterSize(failing, 16))
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		_, err = cw.Write(bytes.Repeat([]byte("x"), 32))
+	}
+	if !errors.Is(err, errBrokenPipe) {
+		t.Fatalf("Write = %v, want the connection's error", err)
+	}
+	calls := failing.calls
+	if _, err := cw.Write([]byte("more")); !errors.Is(err, errBrokenPipe) || failing.calls != calls {
+		t.Errorf("Write after failure = %v with %d more writes, want the same error and none", err, failing.calls-calls)
+	}
+}
+
+var errBrokenPipe = errors.New("broken pipe")
+
+// failingWriter fails every write after the first after ones.
+type failingWriter struct {
+	after int
+	calls int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	f.calls++
+	if f.calls > f.after {
+		return 0, errBrokenPipe
+	}
+	return len(p), nil
+}
+
+func BenchmarkChunkedWriter(b *testing.B) {
+	p := bytes.Repeat([]byte("x"), 64)
+	for _, size := range []int{1, 4 << 10, 32 << 10} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			bw := bufio.NewWriter(io.Discard)
+			b.SetBytes(int64(len(p)))
+			b.ReportAllocs()
+			cw := NewChunkedWriterSize(bw, size)
+			for b.Loop() {
+				cw.Write(p)
+			}
+			cw.Close()
+		})
+	}
+}
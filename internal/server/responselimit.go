@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// errResponseTooLarge aborts a stream that exceeded its response size limit.
+var errResponseTooLarge = &StreamError{Code: "response_too_large"}
+
+// MaxResponseSize limits the body the route's handler may produce to n
+// bytes, overriding the router default set with SetMaxResponseSize. A
+// negative value removes the limit for this route.
+func (rt *Route) MaxResponseSize(n int64) *Route {
+	rt.maxResponseSize = n
+	return rt
+}
+
+// SetMaxResponseSize sets the largest body, in bytes, any handler may
+// produce. 0 (the default) means unlimited.
+func (r *Router) SetMaxResponseSize(n int64) {
+	r.maxResponseSize = n
+}
+
+// responseLimitFor returns the response size limit for route, or 0.
+func (r *Router) responseLimitFor(route *Route) int64 {
+	if route != nil && route.maxResponseSize != 0 {
+		return max(route.maxResponseSize, 0)
+	}
+	return r.maxResponseSize
+}
+
+// enforceResponseLimit replaces a buffered body over limit with a 500, and
+// makes a streamed body abort once it writes more than limit bytes.
+func enforceResponseLimit(resp *Response, route *Route, limit int64) {
+	if limit <= 0 {
+		return
+	}
+
+	size := int64(len(resp.Body))
+	if resp.StreamFunc != nil {
+		// A stream that announces its length can be rejected up front.
+		size, _ = strconv.ParseInt(resp.Headers["Content-Length"], 10, 64)
+	}
+	if size > limit {
+		utils.Error("Handler for %s %s produced %d byte body, limit is %d", route.method, route.pattern, size, limit)
+		*resp = NewHTTPError(500, "response exceeds the configured size limit").Response()
+		return
+	}
+
+	if resp.StreamFunc != nil {
+		stream := resp.StreamFunc
+		resp.StreamFunc = func(w io.Writer) error {
+			lw := &limitWriter{w: w, remaining: limit}
+			err := stream(lw)
+			if lw.exceeded {
+				utils.Error("Stream for %s %s exceeded %d bytes", route.method, route.pattern, limit)
+			}
+			return err
+		}
+	}
+}
+
+// limitWriter passes writes through until remaining bytes are used up and
+// then fails every further write.
+type limitWriter struct {
+	w         io.Writer
+	remaining int64
+	exceeded  bool
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining {
+		l.exceeded = true
+		return 0, fmt.Errorf("%w: limit reached", errResponseTooLarge)
+	}
+	n, err := l.w.Write(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// Unwrap returns the underlying writer, see AsStreamWriter.
+func (l *limitWriter) Unwrap() io.Writer {
+	return l.w
+}
+
+// Flush forwards to the underlying writer when it supports flushing.
+func (l *limitWriter) Flush() error {
+	if flusher, ok := l.w.(interface{ Flush() error }); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
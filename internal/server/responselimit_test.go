@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// limitRouter serves GET /body with a buffered body of size bytes and GET
+// /stream with a stream writing size bytes in 10 byte pieces, announcing
+// its length when announce is set. The router limit is global; route, if
+// not zero, overrides it on both routes.
+func limitRouter(size int, global, route int64, announce bool) *Router {
+	r := NewRouter()
+	r.SetMaxResponseSize(global)
+	body := r.Handle("/body", "GET", func(*Request) Response {
+		return textResponse(strings.Repeat("x", size))
+	})
+	stream := r.Handle("/stream", "GET", func(*Request) Response {
+		resp := Response{Version: HTTPVersion, Status: 200, Reason: "OK", Headers: map[string]string{}}
+		if announce {
+			resp.Headers["Content-Length"] = strconv.Itoa(size)
+		}
+		resp.StreamFunc = func(w io.Writer) error {
+			for written := 0; written < size; written += 10 {
+				if _, err := w.Write(bytes.Repeat([]byte("x"), min(10, size-written))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return resp
+	})
+	if route != 0 {
+		body.MaxResponseSize(route)
+		stream.MaxResponseSize(route)
+	}
+	return r
+}
+
+func TestResponseLimitBuffered(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		size          int
+		global, route int64
+		status        int
+	}{
+		{"unlimited", 1000, 0, 0, 200},
+		{"under the global limit", 100, 100, 0, 200},
+		{"over the global limit", 101, 100, 0, 500},
+		{"route limit raises the global one", 150, 100, 200, 200},
+		{"route limit lowers the global one", 150, 1000, 100, 500},
+		{"route limit without a global one", 150, 0, 100, 500},
+		{"route opts out of the global limit", 1000, 100, -1, 200},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := routeGET(limitRouter(tt.size, tt.global, tt.route, false), "/body")
+			if resp.Status != tt.status {
+				t.Fatalf("GET /body = %d, want %d", resp.Status, tt.status)
+			}
+			if tt.status == 200 && len(resp.Body) != tt.size {
+				t.Errorf("body is %d bytes, want %d", len(resp.Body), tt.size)
+			}
+			if tt.status == 500 && bytes.Contains(resp.Body, []byte("xxx")) {
+				t.Errorf("500 body %q carries the oversized response", resp.Body)
+			}
+		})
+	}
+}
+
+func TestResponseLimitStreamed(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		size          int
+		global, route int64
+		fails         bool
+	}{
+		{"unlimited", 1000, 0, 0, false},
+		{"under the global limit", 100, 100, 0, false},
+		{"over the global limit", 105, 100, 0, true},
+		{"route limit raises the global one", 150, 100, 200, false},
+		{"route limit lowers the global one", 150, 1000, 100, true},
+		{"route opts out of the global limit", 1000, 100, -1, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := routeGET(limitRouter(tt.size, tt.global, tt.route, false), "/stream")
+			if resp.Status != 200 || resp.StreamFunc == nil {
+				t.Fatalf("GET /stream = %d, want a 200 stream: its length is unknown up front", resp.Status)
+			}
+			var out bytes.Buffer
+			err := resp.StreamFunc(&out)
+			if !tt.fails {
+				if err != nil || out.Len() != tt.size {
+					t.Errorf("stream = %d bytes, %v; want %d bytes", out.Len(), err, tt.size)
+				}
+				return
+			}
+			if !errors.Is(err, errResponseTooLarge) {
+				t.Errorf("stream error = %v, want errResponseTooLarge", err)
+			}
+			limit := tt.global
+			if tt.route > 0 {
+				limit = tt.route
+			}
+			if int64(out.Len()) > limit {
+				t.Errorf("stream wrote %d bytes past the %d byte limit", out.Len(), limit)
+			}
+		})
+	}
+}
+
+func TestResponseLimitAnnouncedStream(t *testing.T) {
+	if resp := routeGET(limitRouter(150, 100, 0, true), "/stream"); resp.Status != 500 || resp.StreamFunc != nil {
+		t.Errorf("GET /stream announcing 150 bytes = %d, want 500 before streaming", resp.Status)
+	}
+	if resp := routeGET(limitRouter(150, 100, 200, true), "/stream"); resp.Status != 200 || resp.StreamFunc == nil {
+		t.Errorf("GET /stream announcing 150 bytes under a 200 byte route limit = %d, want the stream", resp.Status)
+	}
+}
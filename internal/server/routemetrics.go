@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// unmatchedRouteLabel aggregates every request that matched no route, so
+// unknown paths cannot grow the number of series.
+const unmatchedRouteLabel = "unmatched"
+
+// routeLabel identifies a route in metrics by its registered method and
+// pattern, e.g. "GET /user/:id", never by the raw request path.
+func routeLabel(rt *Route) string {
+	if rt == nil {
+		return unmatchedRouteLabel
+	}
+	method := rt.method
+	if method == "" {
+		method = "ANY"
+	}
+	pattern := rt.pattern
+	if rt.regex != nil {
+		pattern = rt.regex.String()
+	}
+	return method + " " + pattern
+}
+
+// routeRequestsMetric and routeDurationMetric name the per-route series.
+func routeRequestsMetric(label string) string {
+	return "route_requests_total{" + MetricLabel("route", label) + "}"
+}
+
+func routeDurationMetric(label string) string {
+	return "route_duration_seconds{" + MetricLabel("route", label) + "}"
+}
+
+// observeRoute records one request handled by rt (nil if unmatched).
+func observeRoute(rt *Route, elapsed time.Duration) {
+	label := routeLabel(rt)
+	Metrics.Counter(routeRequestsMetric(label)).Inc()
+	Metrics.Histogram(routeDurationMetric(label)).Observe(elapsed)
+}
+
+// handleMetrics handles "/metrics" with the Prometheus text exposition of
+// the process-wide registry.
+func handleMetrics(req *Request) Response {
+	var buf bytes.Buffer
+	if err := Metrics.WritePrometheus(&buf); err != nil {
+		utils.Error("Failed to render metrics: %v", err)
+		return InternalServerErrorResponse()
+	}
+	return Response{
+		Version: HTTPVersion,
+		Status:  200,
+		Reason:  "OK",
+		Headers: map[string]string{
+			"Content-Type":  "text/plain; version=0.0.4",
+			"Cache-Control": "no-store",
+		},
+		Body: buf.Bytes(),
+	}
+}
+
+// handleAdminRoutes handles "/admin/routes", listing every
+// registered route with its request count and latency percentiles.
+func (r *Router) handleAdminRoutes(req *Request) Response {
+	var b strings.Builder
+	counts := Metrics.Snapshot()
+	line := func(label string) {
+		h, ok := Metrics.lookupHistogram(routeDurationMetric(label))
+		if !ok {
+			fmt.Fprintf(&b, "%-40s requests=0\n", label)
+			return
+		}
+		fmt.Fprintf(&b, "%-40s requests=%d p50=%v p95=%v p99=%v\n", label,
+			counts[routeRequestsMetric(label)],
+			h.Quantile(0.50).Round(time.Microsecond),
+			h.Quantile(0.95).Round(time.Microsecond),
+			h.Quantile(0.99).Round(time.Microsecond))
+	}
+	table := r.current()
+	for _, rt := range table.routes {
+		line(routeLabel(rt))
+	}
+	for _, g := range table.groups {
+		for _, rt := range g.routes {
+			line(routeLabel(rt))
+		}
+	}
+	line(unmatchedRouteLabel)
+
+	return Response{
+		Version: HTTPVersion,
+		Status:  200,
+		Reason:  "OK",
+		Headers: map[string]string{"Content-Type": "text/plain", "Cache-Control": "no-store"},
+		Body:    []byte(b.String()),
+	}
+}
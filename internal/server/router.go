@@ -1,10 +1,18 @@
 package server
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"regexp"
+	"runtime/debug"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Abb133Se/httpServer/internal/utils"
+	"github.com/Abb133Se/httpServer/internal/version"
 )
 
 // HandlerFunc defines the function signature for all HTTP route handlers.
@@ -25,26 +33,80 @@ import (
 //	}
 type HandlerFunc func(req *Request) Response
 
+// MiddlewareFunc wraps a handler, running code before and after it or
+// answering in its place. See Router.Use.
 type MiddlewareFunc func(next HandlerFunc) HandlerFunc
 
 type Route struct {
 	pattern   string
 	method    string
 	handler   HandlerFunc
-	paramKeys []string       // for path parameters
-	regex     *regexp.Regexp // compiled regex if it's a regex route
+	paramKeys []string          // for path parameters
+	defaults  map[string]string // values for absent optional parameters
+	regex     *regexp.Regexp    // compiled regex if it's a regex route
 	isPrefix  bool
+	wildcard  bool // the last segment is a "*name" wildcard, see HandleWild
+
+	maxConcurrent  int
+	bulkheadPolicy BulkheadPolicy
+	bulkheadWait   time.Duration
+	bulkheadOnce   sync.Once
+	bulkhead       *bulkhead
+
+	doc             RouteDoc
+	beforeBody      []MiddlewareFunc
+	rateLimit       *routeRateLimit
+	noCompression   bool
+	streamBody      bool // see StreamBody
+	unbatchable     bool
+	queryPolicy     QueryPolicy
+	maxResponseSize int64
+	cors            *CORSOptions
+	group           *RouteGroup // the group the route was registered on, if any
+	router          *Router
+	middlewares     []MiddlewareFunc // see Use
+
+	streamStallTimeout time.Duration // see StreamStallTimeout
 }
 
 type Router struct {
 	routes      []*Route
 	groups      []*RouteGroup
 	middlewares []MiddlewareFunc
+	beforeBody  []MiddlewareFunc
+
+	defaultMaxConcurrent int
+	queryPolicy          QueryPolicy
+	maxQueryParams       int
+	maxQueryLength       int
+	basePath             string
+	maxResponseSize      int64
+	methodMode           MethodMode
+	customMethods        map[string]bool
+	cors                 *CORSPolicy
+	traceMode            MiddlewareTraceMode // see SetMiddlewareTrace
+	traceToken           string
+	lane                 *PriorityLane     // see SetPriorityLane
+	fairQueue            *FairQueueOptions // see SetFairQueue
+	normalize            NormalizeOptions  // see SetNormalization
+
+	swapMu sync.Mutex             // serializes Swap
+	live   atomic.Pointer[Router] // table published by Swap, nil before the first
 }
 
 type RouteGroup struct {
-	prefix string
-	routes []*Route
+	prefix      string
+	routes      []*Route
+	middlewares []MiddlewareFunc // see Use
+	router      *Router
+
+	rps     float64 // rate limit applied to the group's routes, see RateLimit
+	burst   int
+	rateKey RateKeyFunc
+
+	cors *CORSOptions // applied to the group's routes, see CORS
+
+	normalize *NormalizePolicy // overrides the router's policy, see Normalize
 }
 
 // NewRouter creates and initializes a new Router.
@@ -57,28 +119,81 @@ func NewRouter() *Router {
 		routes:      []*Route{},
 		groups:      []*RouteGroup{},
 		middlewares: []MiddlewareFunc{},
+
+		queryPolicy:    QueryFirstWins,
+		maxQueryParams: DefaultMaxQueryParams,
+		maxQueryLength: DefaultMaxQueryLength,
 	}
 }
 
 // Handle registers a handler for an exact path and HTTP method.
 //
+// The path may contain ":name" parameter segments, and its last segment may
+// be an optional parameter written ":name?": "/reports/:year/:month?"
+// matches both "/reports/2024/05" and "/reports/2024". Like every route,
+// it competes with its siblings in registration order, so register a
+// literal route such as "/reports/summary" before it to keep it reachable.
+// A trailing "*name" wildcard segment is accepted too, see HandleWild,
+// which also checks where the wildcard is.
+//
 // Parameters:
 //   - path:    Exact match path (e.g., "/").
 //   - method:  HTTP method (e.g., "GET", "POST").
 //   - handler: The handler function to execute for this path+method.
-func (r *Router) Handle(path, method string, handler HandlerFunc) {
+//
+// Returns:
+//   - *Route: The registered route, for chaining route options.
+func (r *Router) Handle(path, method string, handler HandlerFunc) *Route {
 	method = strings.ToUpper(method)
 	route := &Route{
 		pattern: path,
 		method:  method,
 		handler: handler,
+		router:  r,
 	}
+	parts := strings.Split(path, "/")
+	_, route.wildcard = wildcardParam(parts[len(parts)-1])
 	r.routes = append(r.routes, route)
 	utils.Debug("Registered route: %s %s", method, path)
+	return route
 }
 
-func (r *Router) Use(mw MiddlewareFunc) {
-	r.middlewares = append(r.middlewares, mw)
+// HandleWild registers a handler for a path whose last segment is a
+// "*name" wildcard, capturing the rest of the path: "/static/*filepath"
+// matches "/static/css/site.css" with req.Params["filepath"] set to
+// "css/site.css". The wildcard matches one or more segments, never an
+// empty remainder, so "/static/" does not match.
+//
+// Wildcard routes are only tried once no exact, parameterized, prefix or
+// regex route matches, whatever the registration order, so
+// "/static/:name" still serves "/static/logo.png" when registered after
+// "/static/*filepath".
+//
+// Returns:
+//   - *Route: The registered route, for chaining route options.
+//   - error:  A wrapped ErrWildcardNotLast, registering nothing, if a
+//     wildcard is not the last segment of path.
+func (r *Router) HandleWild(path, method string, handler HandlerFunc) (*Route, error) {
+	if err := wildcardProblem(path); err != nil {
+		return nil, err
+	}
+	return r.Handle(path, method, handler), nil
+}
+
+// Use appends middleware to the router's chain. The chain wraps the
+// handler of every route, and the router's own 404, 405 and automatic
+// OPTIONS responses, in registration order: the first middleware added
+// runs outermost. Middleware added with a group's Use runs inside the
+// router's chain.
+//
+// Example:
+//
+//	router.Use(RequestIDMiddleware, LoggingMiddleware)
+func (r *Router) Use(mws ...MiddlewareFunc) {
+	for _, mw := range mws {
+		r.warnDuplicateMiddleware(mw, "globally", nil, nil)
+		r.middlewares = append(r.middlewares, mw)
+	}
 }
 
 // HandlePrefix registers a handler for all routes beginning with a prefix.
@@ -96,8 +211,10 @@ func (r *Router) Use(mw MiddlewareFunc) {
 // 	utils.Debug("Registered prefix route: %s %s", method, path)
 // }
 
+// Group returns a group of routes sharing prefix, registered with the
+// group's Handle.
 func (r *Router) Group(prefix string) *RouteGroup {
-	group := &RouteGroup{prefix: prefix}
+	group := &RouteGroup{prefix: prefix, router: r}
 	r.groups = append(r.groups, group)
 	return group
 }
@@ -111,127 +228,391 @@ func (r *Router) HandleRegex(pattern string, handler HandlerFunc) error {
 		pattern: pattern,
 		handler: handler,
 		regex:   re,
+		router:  r,
 	}
 	r.routes = append(r.routes, route)
 	utils.Debug("Registered regex route: %s", pattern)
 	return nil
 }
 
-func (g *RouteGroup) Handle(path string, handler HandlerFunc) {
+// Use appends middleware to the group's chain, which wraps the handlers
+// of the group's routes only, inside the router's chain (see Router.Use).
+func (g *RouteGroup) Use(mws ...MiddlewareFunc) *RouteGroup {
+	for _, mw := range mws {
+		g.router.warnDuplicateMiddleware(mw, "on group "+g.prefix, g, nil)
+		g.middlewares = append(g.middlewares, mw)
+	}
+	return g
+}
+
+// Use appends middleware to the route's own chain, which runs inside the
+// router's and the group's (see Router.Use), right around the handler.
+func (rt *Route) Use(mws ...MiddlewareFunc) *Route {
+	for _, mw := range mws {
+		rt.router.warnDuplicateMiddleware(mw, "on route "+rt.describe(), rt.group, rt)
+		rt.middlewares = append(rt.middlewares, mw)
+	}
+	return rt
+}
+
+func (g *RouteGroup) Handle(path string, handler HandlerFunc) *Route {
 	fullPath := g.prefix + path
 	route := &Route{
 		pattern: fullPath,
 		handler: handler,
+		group:   g,
+		router:  g.router,
+	}
+	if g.rps > 0 {
+		route.RateLimit(g.rps, g.burst).RateLimitBy(g.rateKey)
+	}
+	if g.cors != nil {
+		route.CORS(*g.cors)
 	}
 	g.routes = append(g.routes, route)
 	utils.Debug("Registered grouped route: %s", fullPath)
+	return route
 }
 
-// Route dispatches a request to the appropriate handler.
+// Route dispatches a request to the appropriate handler. Any query string
+// is ignored for matching. The time spent matching and the request count
+// and latency of the matched route pattern (or "unmatched") are recorded
+// in Metrics.
 //
 // Matching priority:
 //  1. Exact match
 //  2. Prefix match
-//  3. 404 Not Found if no match
-//  4. 405 Method Not Allowed if method unsupported
+//     (a HEAD request with no HEAD route uses the GET route; the server
+//     sends its headers and Content-Length without the body)
+//  3. For a path registered only under other methods: an automatic
+//     OPTIONS response, or 405 Method Not Allowed, with an Allow header
+//     listing the registered methods (custom ones included)
+//  4. 404 Not Found if no match
+//
+// After a Swap, requests are routed with the table it published.
+//
+// With CORS enabled (SetCORS), preflight requests are answered before
+// matching and every response gets the CORS headers for its origin.
+//
+// In strict method mode (SetMethodMode) invalid methods get 400 and
+// unregistered ones 501 before any matching.
 //
 // Parameters:
 //   - req: The parsed HTTP request to route.
 //
 // Returns:
 //   - Response: The response from the matched handler, or a generated error response.
-func (r *Router) Route(req *Request) Response {
-	var handler HandlerFunc
+func (r *Router) Route(req *Request) (resp Response) {
+	if table := r.tableFor(req); table != r {
+		return table.Route(req)
+	}
+	var matched *Route
+	start := time.Now()
+	defer func() { observeRoute(matched, time.Since(start)) }()
+	defer r.addCORSHeaders(req, &resp)
+
+	matched, errResp := r.resolve(req)
+	if errResp != nil {
+		return r.fallback(req, errResp)
+	}
+	if !req.beforeBodyDone {
+		if rejection := r.runBeforeBody(req, matched); rejection != nil {
+			return *rejection
+		}
+	}
+	finalHandler := r.chain(req, matched, matched.handler)
 
+	if bh := r.bulkheadFor(matched); bh != nil {
+		client := clientKey(req)
+		if err := bh.acquire(client); err != nil {
+			if bh = r.overflow(req); bh == nil {
+				if errors.Is(err, errClientQueueFull) {
+					utils.Warn("Route %s %s: too many requests queued from %s; rejecting %s", matched.method, matched.pattern, client, req.Path)
+					return ClientQueueFullResponse(bulkheadRetryAfter)
+				}
+				utils.Warn("Route %s %s saturated; rejecting %s", matched.method, matched.pattern, req.Path)
+				return ServiceUnavailableResponse(bulkheadRetryAfter)
+			}
+		}
+		releaseNow := true
+		defer func() {
+			if releaseNow {
+				bh.release(client)
+			}
+		}()
+		finalHandler = holdSlotForStream(finalHandler, bh, client, &releaseNow)
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			raw := ""
+			if req.RawRequestLine != "" {
+				raw = fmt.Sprintf("\n  raw: %q %q", req.RawRequestLine, req.RawHeaders)
+			}
+			utils.Error("Recovered from panic in handler: %v\n  version: %s\n  request: %s %s (id=%s)%s\n  body: %s\n%s",
+				rec, version.Get(), req.Method, req.Path, req.GetString(RequestIDKey), raw, req.BodyPreview(), debug.Stack())
+			resp = InternalServerErrorResponse()
+		}
+	}()
+
+	resp = finalHandler(req)
+
+	if resp.Status == 0 {
+		utils.Warn("Handler returned empty response, using internal server error")
+		return InternalServerErrorResponse()
+	}
+
+	if resp.Headers == nil && len(req.beforeBodyHeaders) > 0 {
+		resp.Headers = make(map[string]string)
+	}
+	for name, value := range req.beforeBodyHeaders {
+		if _, ok := resp.Headers[name]; !ok {
+			resp.Headers[name] = value
+		}
+	}
+	enforceResponseLimit(&resp, matched, r.responseLimitFor(matched))
+	r.prefixLocation(&resp)
+	return resp
+}
+
+// chain wraps handler in the middleware of route, then of its group, if
+// any, and then of the router, so the router's first middleware runs
+// outermost. route is nil for the router's own responses. When req is
+// traced (see SetMiddlewareTrace), every layer records its timings.
+func (r *Router) chain(req *Request, route *Route, handler HandlerFunc) HandlerFunc {
+	trace := r.traceFor(req)
+	if trace != nil {
+		name := "fallback"
+		if route != nil {
+			name = funcName(route.handler)
+		}
+		handler = trace.wrap("handler", name, handler)
+	}
+	wrap := func(level string, mws []MiddlewareFunc) {
+		for i := len(mws) - 1; i >= 0; i-- {
+			handler = mws[i](handler)
+			if trace != nil {
+				handler = trace.wrap(level, funcName(mws[i]), handler)
+			}
+		}
+	}
+	if route != nil {
+		wrap("route", route.middlewares)
+		if route.group != nil {
+			wrap("group", route.group.middlewares)
+		}
+	}
+	wrap("global", r.middlewares)
+	if trace != nil {
+		return trace.finish(handler)
+	}
+	return handler
+}
+
+// fallback runs the router's middleware around resp, a response the
+// router produced itself because no handler could take the request.
+func (r *Router) fallback(req *Request, resp *Response) Response {
+	return r.chain(req, nil, func(*Request) Response { return *resp })(req)
+}
+
+// resolve strips the base path, checks the method, matches req to a route
+// and checks its query. It returns the route, or the response to send
+// when there is none. The result is kept on req, so CheckBeforeBody and
+// Route resolve a request only once.
+func (r *Router) resolve(req *Request) (*Route, *Response) {
+	if req.resolved {
+		return req.route, req.resolveErr
+	}
+	req.resolved = true
+	req.route, req.resolveErr = r.resolveRoute(req)
+	if r.lane != nil {
+		req.priority = r.lane.admit(req)
+	}
+	return req.route, req.resolveErr
+}
+
+func (r *Router) resolveRoute(req *Request) (*Route, *Response) {
+	start := time.Now()
+	r.normalizeRequest(req)
+	if !r.stripBasePath(req) {
+		utils.Warn("Request outside base path %s: %s %s", r.basePath, req.Method, req.Path)
+		resp := NotFoundResponse()
+		return nil, &resp
+	}
+	if errResp := r.checkMethod(req); errResp != nil {
+		utils.Warn("Rejected method %q: %s", req.Method, req.Path)
+		return nil, errResp
+	}
+	path := req.Path
+
+	method := strings.ToUpper(req.Method)
+	if method == "OPTIONS" && r.cors != nil {
+		if resp := r.preflight(req, path); resp != nil {
+			return nil, resp
+		}
+	}
+	matched := r.match(req, method, path)
+	if matched == nil && method == "HEAD" {
+		// HEAD falls back to the GET route; the server drops the body.
+		matched = r.match(req, "GET", path)
+	}
+
+	Metrics.Histogram("router_match_duration_seconds").Observe(time.Since(start))
+
+	if matched == nil {
+		var resp Response
+		if allowed := r.allowedMethods(path); allowed != nil {
+			allow := strings.Join(allowed, ", ")
+			if method == "OPTIONS" {
+				resp = OptionsResponse(allow)
+			} else {
+				utils.Warn("Method not allowed: %s %s (allow: %s)", req.Method, req.Path, allow)
+				resp = MethodNotAllowedResponse(allow)
+			}
+		} else {
+			utils.Warn("Route not found for method: %s %s", req.Method, req.Path)
+			resp = NotFoundResponse()
+		}
+		return nil, &resp
+	}
+
+	if resp := r.normalizeRedirect(req, matched); resp != nil {
+		utils.Debug("Redirecting %s %s to its canonical path", req.Method, req.sentPath)
+		return matched, resp
+	}
+	if errResp := r.checkQuery(req, matched); errResp != nil {
+		utils.Warn("Rejected query for %s %s: %d %s", req.Method, req.Path, errResp.Status, errResp.Reason)
+		return matched, errResp
+	}
+	return matched, nil
+}
+
+// match returns the first route registering method (or any method) that
+// matches path, storing its path parameters in req.Params.
+func (r *Router) match(req *Request, method, path string) *Route {
+	// The first matching wildcard route is kept for when nothing else
+	// matches, see HandleWild.
+	var wild *Route
+	var wildParams map[string]string
 	for _, route := range r.routes {
-		if route.method != "" && route.method != strings.ToUpper(req.Method) {
+		if route.method != "" && route.method != method {
 			continue
 		}
-		if route.regex != nil && route.regex.MatchString(req.Path) {
+		if route.regex != nil && route.regex.MatchString(path) {
 			utils.Debug("Routing to regex route: %s", route.pattern)
-			handler = route.handler
-			break
+			return route
+		}
+		if route.wildcard {
+			if wild == nil {
+				if params := extractParams(route.pattern, path, nil); params != nil {
+					wild, wildParams = route, params
+				}
+			}
+			continue
 		}
 		if strings.Contains(route.pattern, ":") {
-			params := extractParams(route.pattern, req.Path)
+			params := extractParams(route.pattern, path, route.defaults)
 			if params != nil {
 				req.Params = params
 				utils.Debug("Routing to parameterized route: %s", route.pattern)
-				handler = route.handler
-				break
+				return route
 			}
 		}
-		if route.pattern == req.Path {
+		if route.pattern == path {
 			utils.Debug("Routing to exact match: %s", route.pattern)
-			handler = route.handler
-			break
+			return route
 		}
-		if route.isPrefix && strings.HasPrefix(req.Path, route.pattern) {
+		if route.isPrefix && strings.HasPrefix(path, route.pattern) {
 			utils.Debug("Routing to prefix route: %s", route.pattern)
-			handler = route.handler
-			break
+			return route
 		}
 	}
 
-	if handler == nil {
-		for _, group := range r.groups {
-			for _, route := range group.routes {
-				if route.method != "" && route.method != strings.ToUpper(req.Method) {
-					continue
-				}
-				if route.pattern == req.Path {
-					handler = route.handler
-					break
-				}
+	for _, group := range r.groups {
+		for _, route := range group.routes {
+			if route.method != "" && route.method != method {
+				continue
 			}
-			if handler != nil {
-				break
+			if route.pattern == path {
+				return route
 			}
 		}
 	}
 
-	if handler == nil {
-		utils.Warn("Route not found for method: %s %s", req.Method, req.Path)
-		return NotFoundResponse()
-	}
-
-	finalHandler := handler
-	for i := len(r.middlewares) - 1; i >= 0; i-- {
-		finalHandler = r.middlewares[i](finalHandler)
-
-	}
-
-	defer func() {
-		if rec := recover(); rec != nil {
-			utils.Error("Recovered from panic in handler: %v", rec)
-		}
-	}()
-
-	resp := finalHandler(req)
-
-	if resp.Status == 0 {
-		utils.Warn("Handler returned empty response, using internal server error")
-		return InternalServerErrorResponse()
+	if wild != nil {
+		req.Params = wildParams
+		utils.Debug("Routing to wildcard route: %s", wild.pattern)
+		return wild
 	}
-
-	return resp
+	return nil
 }
 
-func (r *Router) HandlePrefix(prefix, method string, handler HandlerFunc) {
+func (r *Router) HandlePrefix(prefix, method string, handler HandlerFunc) *Route {
 	method = strings.ToUpper(method)
 	route := &Route{
 		pattern:  prefix,
 		method:   method,
 		handler:  handler,
 		isPrefix: true,
+		router:   r,
 	}
 	r.routes = append(r.routes, route)
 	utils.Debug("Registered prefix route: %s %s", method, prefix)
+	return route
+}
+
+// ParamDefault sets the value req.Params holds for the optional parameter
+// name when the request path omits it. Without a default an absent
+// optional parameter is missing from req.Params altogether.
+//
+// Example:
+//
+//	router.Handle("/reports/:year/:month?", "GET", reports).ParamDefault("month", "01")
+func (rt *Route) ParamDefault(name, value string) *Route {
+	if rt.defaults == nil {
+		rt.defaults = make(map[string]string)
+	}
+	rt.defaults[name] = value
+	return rt
 }
 
-func extractParams(pattern, path string) map[string]string {
+// extractParams matches path against a parameterized pattern, returning
+// the parameters or nil if it does not match. A trailing optional
+// parameter may be absent from path, or empty; it then takes its value
+// from defaults, if there is one. A trailing wildcard takes the rest of
+// path, which must not be empty.
+func extractParams(pattern, path string, defaults map[string]string) map[string]string {
 	patternParts := strings.Split(pattern, "/")
 	pathParts := strings.Split(path, "/")
+
+	last := len(patternParts) - 1
+	if wildcard, ok := wildcardParam(patternParts[last]); ok {
+		if len(pathParts) <= last {
+			return nil
+		}
+		rest := strings.Join(pathParts[last:], "/")
+		if rest == "" {
+			return nil
+		}
+		params := extractParams(strings.Join(patternParts[:last], "/"), strings.Join(pathParts[:last], "/"), nil)
+		if params != nil {
+			params[wildcard] = rest
+		}
+		return params
+	}
+	optional, isOptional := optionalParam(patternParts[last])
+	if isOptional {
+		if len(pathParts) == last || (len(pathParts) == last+1 && pathParts[last] == "") {
+			params := extractParams(strings.Join(patternParts[:last], "/"), strings.Join(pathParts[:last], "/"), nil)
+			if params != nil {
+				if value, ok := defaults[optional]; ok {
+					params[optional] = value
+				}
+			}
+			return params
+		}
+		patternParts[last] = ":" + optional
+	}
 	if len(patternParts) != len(pathParts) {
 		return nil
 	}
@@ -247,6 +628,30 @@ func extractParams(pattern, path string) map[string]string {
 	return params
 }
 
+// optionalParam returns the name of the optional parameter segment
+// ":name?", and whether segment is one.
+func optionalParam(segment string) (string, bool) {
+	if !strings.HasPrefix(segment, ":") || !strings.HasSuffix(segment, "?") || len(segment) < 3 {
+		return "", false
+	}
+	return segment[1 : len(segment)-1], true
+}
+
+// wildcardParam returns the name of the wildcard segment "*name", and
+// whether segment is one.
+func wildcardParam(segment string) (string, bool) {
+	if !strings.HasPrefix(segment, "*") || len(segment) < 2 {
+		return "", false
+	}
+	return segment[1:], true
+}
+
+// hasParams reports whether pattern has parameter or wildcard segments,
+// and so must be matched with extractParams.
+func hasParams(pattern string) bool {
+	return strings.Contains(pattern, ":") || strings.Contains(pattern, "/*")
+}
+
 func GetAllowedMethods(methods map[string]HandlerFunc) string {
 	var allowed []string
 	for m := range methods {
@@ -254,3 +659,38 @@ func GetAllowedMethods(methods map[string]HandlerFunc) string {
 	}
 	return strings.Join(allowed, ", ")
 }
+
+// holdSlotForStream wraps next so that a streaming response keeps its
+// bulkhead slot until the stream finishes instead of releasing it when the
+// handler returns. *releaseNow is cleared when ownership of the slot moves
+// to the stream.
+//
+// A stream that never runs, because the response is to HEAD or is replaced
+// before it is sent, cannot free the slot, so the release is also left on
+// the request for the server to call once the response is sent or dropped
+// (see Request.releaseHeldSlot). Whichever comes first frees the slot.
+func holdSlotForStream(next HandlerFunc, bh *bulkhead, client string, releaseNow *bool) HandlerFunc {
+	return func(req *Request) Response {
+		resp := next(req)
+		if resp.StreamFunc != nil {
+			stream := resp.StreamFunc
+			*releaseNow = false
+			var once sync.Once
+			release := func() { once.Do(func() { bh.release(client) }) }
+			req.releaseSlot = release
+			resp.StreamFunc = func(w io.Writer) error {
+				defer release()
+				return stream(w)
+			}
+		}
+		return resp
+	}
+}
+
+// releaseHeldSlot frees the bulkhead slot r's streaming response holds, if
+// it is still held.
+func (r *Request) releaseHeldSlot() {
+	if r.releaseSlot != nil {
+		r.releaseSlot()
+	}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// callLog records the order in which middleware and handlers run.
+type callLog []string
+
+// mw returns middleware logging "name>" on the way in and "<name" on the
+// way out.
+func (l *callLog) mw(name string) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) Response {
+			*l = append(*l, name+">")
+			resp := next(req)
+			*l = append(*l, "<"+name)
+			return resp
+		}
+	}
+}
+
+// handler returns a handler logging "handler".
+func (l *callLog) handler() HandlerFunc {
+	return func(req *Request) Response {
+		*l = append(*l, "handler")
+		return textResponse("ok")
+	}
+}
+
+// take returns the log so far, joined by spaces, and clears it.
+func (l *callLog) take() string {
+	s := strings.Join(*l, " ")
+	*l = nil
+	return s
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var calls callLog
+	r := NewRouter()
+	r.Use(calls.mw("a"), calls.mw("b"))
+	r.Use(calls.mw("c"))
+	r.Handle("/plain", "GET", calls.handler())
+	r.Handle("/items/:id", "GET", calls.handler())
+	if err := r.HandleRegex(`^/re/[0-9]+$`, calls.handler()); err != nil {
+		t.Fatal(err)
+	}
+	r.Handle("/own", "GET", calls.handler()).Use(calls.mw("r1"), calls.mw("r2"))
+	api := r.Group("/api").Use(calls.mw("g1"))
+	api.Handle("/list", calls.handler())
+	api.Handle("/one", calls.handler()).Use(calls.mw("r"))
+	// Group middleware added after a route still wraps it.
+	api.Use(calls.mw("g2"))
+	r.Group("/other").Handle("/list", calls.handler())
+
+	tests := []struct {
+		method, target string
+		want           string
+	}{
+		{"GET", "/plain", "a> b> c> handler <c <b <a"},
+		{"GET", "/items/7", "a> b> c> handler <c <b <a"},
+		{"GET", "/re/42", "a> b> c> handler <c <b <a"},
+		{"GET", "/own", "a> b> c> r1> r2> handler <r2 <r1 <c <b <a"},
+		{"GET", "/api/list", "a> b> c> g1> g2> handler <g2 <g1 <c <b <a"},
+		{"GET", "/api/one", "a> b> c> g1> g2> r> handler <r <g2 <g1 <c <b <a"},
+		{"GET", "/other/list", "a> b> c> handler <c <b <a"},
+		// The router's own responses get the router's chain only.
+		{"GET", "/nowhere", "a> b> c> <c <b <a"},
+		{"DELETE", "/plain", "a> b> c> <c <b <a"},
+		{"OPTIONS", "/own", "a> b> c> <c <b <a"},
+	}
+	for _, tt := range tests {
+		routeMethod(r, tt.method, tt.target)
+		if got := calls.take(); got != tt.want {
+			t.Errorf("%s %s ran %q, want %q", tt.method, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestMiddlewareSeesFallbackResponses(t *testing.T) {
+	var statuses []int
+	r := NewRouter()
+	r.Use(func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) Response {
+			resp := next(req)
+			statuses = append(statuses, resp.Status)
+			resp.Headers["X-Seen"] = "1"
+			return resp
+		}
+	})
+	r.Handle("/only-get", "GET", func(req *Request) Response { return textResponse("ok") })
+
+	for _, tt := range []struct {
+		method, target string
+		want           int
+	}{
+		{"GET", "/only-get", 200},
+		{"GET", "/missing", 404},
+		{"POST", "/only-get", 405},
+		{"OPTIONS", "/only-get", 204},
+	} {
+		resp := routeMethod(r, tt.method, tt.target)
+		if resp.Status != tt.want || resp.Headers["X-Seen"] != "1" {
+			t.Errorf("%s %s = %d with X-Seen %q, want %d through the middleware",
+				tt.method, tt.target, resp.Status, resp.Headers["X-Seen"], tt.want)
+		}
+	}
+	if !slices.Equal(statuses, []int{200, 404, 405, 204}) {
+		t.Errorf("middleware saw statuses %v", statuses)
+	}
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	var calls callLog
+	r := NewRouter()
+	r.Use(calls.mw("outer"))
+	r.Use(func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) Response {
+			calls = append(calls, "deny")
+			return NewHTTPError(403, "denied").Response()
+		}
+	})
+	r.Use(calls.mw("inner"))
+	r.Handle("/x", "GET", calls.handler())
+
+	if resp := routeGET(r, "/x"); resp.Status != 403 {
+		t.Errorf("status = %d, want 403", resp.Status)
+	}
+	if got := calls.take(); got != "outer> deny <outer" {
+		t.Errorf("ran %q, want the chain to stop at deny", got)
+	}
+}
+
+func TestWildcardRoutes(t *testing.T) {
+	echo := func(name string) HandlerFunc {
+		return func(req *Request) Response { return textResponse(name + " " + fmt.Sprint(req.Params)) }
+	}
+	r := NewRouter()
+	for _, pattern := range []string{"/static/*filepath", "/files/*path", "/users/:id/*rest"} {
+		if _, err := r.HandleWild(pattern, "GET", echo(pattern)); err != nil {
+			t.Fatalf("HandleWild(%q) = %v", pattern, err)
+		}
+	}
+	// Registered after the wildcard, and still preferred to it.
+	r.Handle("/static/:name", "GET", echo("/static/:name"))
+
+	for _, tt := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		// The rest of the path, however many segments.
+		{"/static/css/site.css", 200, "/static/*filepath map[filepath:css/site.css]"},
+		{"/files/a", 200, "/files/*path map[path:a]"},
+		{"/files/a/b/", 200, "/files/*path map[path:a/b/]"},
+		{"/users/7/posts/3", 200, "/users/:id/*rest map[id:7 rest:posts/3]"},
+		// A parameter route wins over a wildcard whatever the order.
+		{"/static/logo.png", 200, "/static/:name map[name:logo.png]"},
+		// An empty remainder does not match.
+		{"/files/", 404, ""},
+		{"/files", 404, ""},
+		{"/users/7/", 404, ""},
+		{"/users/7", 404, ""},
+	} {
+		resp := routeGET(r, tt.path)
+		if resp.Status != tt.status || (tt.status == 200 && string(resp.Body) != tt.body) {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.path, resp.Status, resp.Body, tt.status, tt.body)
+		}
+	}
+}
+
+func TestWildcardNotLast(t *testing.T) {
+	r := NewRouter()
+	for _, pattern := range []string{"/a/*rest/b", "/*all/x"} {
+		route, err := r.HandleWild(pattern, "GET", func(*Request) Response { return textResponse("wild") })
+		if !errors.Is(err, ErrWildcardNotLast) || route != nil {
+			t.Errorf("HandleWild(%q) = %v, %v, want ErrWildcardNotLast and no route", pattern, route, err)
+		}
+	}
+	if resp := routeGET(r, "/a/x/b"); resp.Status != 404 {
+		t.Errorf("GET /a/x/b = %d, want 404 with nothing registered", resp.Status)
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// RateKeyFunc returns the key a rate limit counts a request under.
+type RateKeyFunc func(req *Request) string
+
+// RateKeyClientIP keys requests by client IP. It is the default.
+func RateKeyClientIP(req *Request) string {
+	return clientKey(req)
+}
+
+// RateKeyPrincipal keys requests by the authenticated identity stored
+// under PrincipalKey, such as the one ClientCertMiddleware sets, so every
+// client of one principal shares a budget. Anonymous requests fall back
+// to the client IP. The principal must be set by pre-body middleware (see
+// Router.UseBeforeBody), which runs before route rate limits.
+func RateKeyPrincipal(req *Request) string {
+	if principal := req.GetString(PrincipalKey); principal != "" {
+		return "principal:" + principal
+	}
+	return clientKey(req)
+}
+
+// routeRateLimit is the limiter attached to a route with Route.RateLimit.
+type routeRateLimit struct {
+	limiter *RateLimiter
+	key     RateKeyFunc
+}
+
+// RateLimit limits the route to rps requests per second on average, with
+// bursts of up to burst requests, per client. It shares the fixed-window
+// RateLimiter: each client gets burst requests per window of burst/rps
+// seconds, so 5 requests a minute is RateLimit(5.0/60, 5). Every route
+// counts on its own, independently of other routes and of the RATE_LIMIT
+// limiter. A rate or burst of 0 removes the limit.
+//
+// Route limits run in the pre-body phase, after the router's pre-body
+// middleware. Requests beyond the limit get a 429 naming the route.
+func (rt *Route) RateLimit(rps float64, burst int) *Route {
+	if rps <= 0 || burst <= 0 {
+		rt.rateLimit = nil
+		return rt
+	}
+	key := RateKeyFunc(RateKeyClientIP)
+	if rt.rateLimit != nil {
+		key = rt.rateLimit.key
+	}
+	window := time.Duration(float64(burst) / rps * float64(time.Second))
+	rt.rateLimit = &routeRateLimit{limiter: NewRateLimiter(burst, 0, window), key: key}
+	return rt
+}
+
+// RateLimitBy sets how the route's rate limit tells clients apart, e.g.
+// RateKeyPrincipal. It must follow RateLimit; a nil key is ignored.
+func (rt *Route) RateLimitBy(key RateKeyFunc) *Route {
+	if rt.rateLimit != nil && key != nil {
+		rt.rateLimit.key = key
+	}
+	return rt
+}
+
+// RateLimit applies Route.RateLimit to every route in the group, including
+// routes added later. Each route still counts on its own.
+func (g *RouteGroup) RateLimit(rps float64, burst int) *RouteGroup {
+	g.rps, g.burst = rps, burst
+	for _, route := range g.routes {
+		route.RateLimit(rps, burst).RateLimitBy(g.rateKey)
+	}
+	return g
+}
+
+// RateLimitBy applies Route.RateLimitBy to every route in the group.
+func (g *RouteGroup) RateLimitBy(key RateKeyFunc) *RouteGroup {
+	g.rateKey = key
+	for _, route := range g.routes {
+		route.RateLimitBy(key)
+	}
+	return g
+}
+
+// rateLimitMiddleware enforces the route's rate limit.
+func (rt *Route) rateLimitMiddleware(next HandlerFunc) HandlerFunc {
+	return func(req *Request) Response {
+		key := rt.rateLimit.key(req)
+		decision := rt.rateLimit.limiter.Allow(key)
+		decision.Route = strings.TrimSpace(rt.method + " " + rt.pattern)
+		req.Set(RateDecisionKey, decision)
+		if !decision.Allowed {
+			Metrics.Counter("route_rate_limited_total").Inc()
+			utils.Warn("Rate limit for %s exceeded by %s", decision.Route, key)
+			resp := TooManyRequestsResponse(decision)
+			setRateLimitHeaders(&resp, decision)
+			return resp
+		}
+		resp := next(req)
+		setRateLimitHeaders(&resp, decision)
+		return resp
+	}
+}
+
+// applyRouteRateLimits attaches the ROUTE_RATE_LIMITS entries to the
+// registered routes. An entry without a method applies to every route
+// registered with its pattern; an entry matching no route is an error.
+func applyRouteRateLimits(r *Router, limits []config.RouteRateLimitConfig) error {
+	for _, limit := range limits {
+		matched := false
+		for _, route := range r.routes {
+			if route.pattern != limit.Pattern || (limit.Method != "" && route.method != limit.Method) {
+				continue
+			}
+			route.RateLimit(limit.RPS, limit.Burst)
+			if limit.ByPrincipal {
+				route.RateLimitBy(RateKeyPrincipal)
+			}
+			matched = true
+		}
+		if !matched {
+			return fmt.Errorf("no route %s", strings.TrimSpace(limit.Method+" "+limit.Pattern))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+)
+
+// limitedRouter registers GET and POST /login and GET /user/:id.
+func limitedRouter() *Router {
+	r := NewRouter()
+	ok := func(req *Request) Response { return textResponse("ok") }
+	r.Handle("/login", "GET", ok)
+	r.Handle("/login", "POST", ok)
+	r.Handle("/user/:id", "GET", ok)
+	return r
+}
+
+// routeAs routes a request for target as principal, or anonymously when
+// principal is empty, and returns the status.
+func routeAs(r *Router, method, target, principal string) int {
+	req := &Request{Method: method, Headers: map[string]string{}}
+	req.setTarget(target)
+	if principal != "" {
+		req.Set(PrincipalKey, principal)
+	}
+	return r.Route(req).Status
+}
+
+func TestApplyRouteRateLimits(t *testing.T) {
+	r := limitedRouter()
+	limits := config.RouteRateLimitConfig{Method: "POST", Pattern: "/login", RPS: 1.0 / 60, Burst: 1}
+	if err := applyRouteRateLimits(r, []config.RouteRateLimitConfig{limits}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []int{200, 429} {
+		if got := routeAs(r, "POST", "/login", ""); got != want {
+			t.Errorf("POST /login #%d = %d, want %d", i+1, got, want)
+		}
+	}
+	// The method narrowed the entry to POST.
+	for i := 0; i < 3; i++ {
+		if got := routeAs(r, "GET", "/login", ""); got != 200 {
+			t.Errorf("GET /login #%d = %d, want it unlimited", i+1, got)
+		}
+	}
+
+	resp := routeMethod(r, "POST", "/login")
+	if !strings.Contains(string(resp.Body), "POST /login") {
+		t.Errorf("429 body %q does not name the route", resp.Body)
+	}
+}
+
+func TestApplyRouteRateLimitsEveryMethod(t *testing.T) {
+	r := limitedRouter()
+	limits := []config.RouteRateLimitConfig{{Pattern: "/login", RPS: 1, Burst: 1}}
+	if err := applyRouteRateLimits(r, limits); err != nil {
+		t.Fatal(err)
+	}
+	// Each route matching the pattern gets its own bucket.
+	for _, method := range []string{"GET", "POST"} {
+		if got := routeAs(r, method, "/login", ""); got != 200 {
+			t.Errorf("first %s /login = %d, want 200", method, got)
+		}
+		if got := routeAs(r, method, "/login", ""); got != 429 {
+			t.Errorf("second %s /login = %d, want 429", method, got)
+		}
+	}
+}
+
+func TestApplyRouteRateLimitsByPrincipal(t *testing.T) {
+	r := limitedRouter()
+	limits := []config.RouteRateLimitConfig{{Method: "GET", Pattern: "/user/:id", RPS: 1, Burst: 1, ByPrincipal: true}}
+	if err := applyRouteRateLimits(r, limits); err != nil {
+		t.Fatal(err)
+	}
+	// All requests come from the same IP; only the principal tells them
+	// apart, and any concrete path counts against the one pattern.
+	for _, tt := range []struct {
+		target, principal string
+		want              int
+	}{
+		{"/user/1", "alice", 200},
+		{"/user/2", "alice", 429},
+		{"/user/1", "bob", 200},
+		{"/user/1", "", 200},
+		{"/user/1", "", 429},
+	} {
+		if got := routeAs(r, "GET", tt.target, tt.principal); got != tt.want {
+			t.Errorf("GET %s as %q = %d, want %d", tt.target, tt.principal, got, tt.want)
+		}
+	}
+}
+
+func TestApplyRouteRateLimitsUnknownRoute(t *testing.T) {
+	for _, limit := range []config.RouteRateLimitConfig{
+		{Pattern: "/logout", RPS: 1, Burst: 1},
+		{Method: "DELETE", Pattern: "/login", RPS: 1, Burst: 1},
+		// Patterns match as registered, not as paths.
+		{Pattern: "/user/1", RPS: 1, Burst: 1},
+	} {
+		err := applyRouteRateLimits(limitedRouter(), []config.RouteRateLimitConfig{limit})
+		if err == nil || !strings.Contains(err.Error(), limit.Pattern) {
+			t.Errorf("applyRouteRateLimits(%+v) = %v, want an error naming the route", limit, err)
+		}
+	}
+}
+
+func TestRouteRateLimitsFromConfig(t *testing.T) {
+	t.Setenv("ROUTE_RATE_LIMITS", "GET /healthz=1/m:2")
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+	for i, want := range []int{200, 200, 429} {
+		if resp := roundTrip(t, c, "GET", "/healthz", keepAlive, nil); resp.Status != want {
+			t.Errorf("GET /healthz #%d = %d, want %d", i+1, resp.Status, want)
+		}
+	}
+	// /readyz has a bucket of its own, unlimited.
+	if resp := roundTrip(t, c, "GET", "/readyz", keepAlive, nil); resp.Status == 429 {
+		t.Error("GET /readyz was rate limited by the /healthz entry")
+	}
+
+	t.Setenv("ROUTE_RATE_LIMITS", "GET /nowhere=1:1")
+	if _, err := NewServerFromConfig(config.LoadConfig()); err == nil || !strings.Contains(err.Error(), "ROUTE_RATE_LIMITS") {
+		t.Errorf("NewServerFromConfig with an unknown route = %v, want a ROUTE_RATE_LIMITS error", err)
+	}
+}
@@ -1,14 +1,23 @@
 package server
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Abb133Se/httpServer/internal/clock"
 	"github.com/Abb133Se/httpServer/internal/config"
+	"github.com/Abb133Se/httpServer/internal/httpclient"
 	"github.com/Abb133Se/httpServer/internal/utils"
 )
 
@@ -22,14 +31,22 @@ import (
 //   - "/" → handleRoot
 //   - "/echo/{message}" → handleEcho
 //   - "/user-agent" → handleUserAgent
-//   - "/files/{filename}" → handleFiles (GET, POST, PUT, DELETE, HEAD, OPTIONS)
+//   - "/files/{filename}" → publicFiles.handle (GET, POST, PUT, DELETE, HEAD, OPTIONS)
+//   - "/files/{tenant}/{filename}" → TenantFiles.Handle when FILES_TENANTS is set
+//   - "/files-meta/{filename}" → FileMetadata.Handle when FILES_META_ENABLED is set
+//   - each PROXY_MOUNTS prefix → ProxyHandler, forwarding to its upstream
 //
 // Parameters:
 //   - port: The address and port to bind the server on (e.g., ":8080").
 //
 // Returns:
-//   - error: Only if the TCP listener fails to start. Otherwise, this function
-//     blocks indefinitely until externally terminated.
+//   - error: If setup or the TCP listener fails. Otherwise, this function
+//     blocks until SIGINT, SIGTERM or POST /admin/shutdown has shut the
+//     server down gracefully (see Server.Shutdown). Either way a shutdown
+//     report is logged before it returns.
+//
+// SIGHUP and POST /admin/routes/reload reload the routes from the .env
+// file, see Server.ReloadRoutes.
 //
 // Example:
 //
@@ -37,53 +54,802 @@ import (
 //	    log.Fatalf("Server failed: %v", err)
 //	}
 func StartServer(port string, config *config.Config) error {
-	listener, err := net.Listen("tcp", port)
+	return StartServerContext(context.Background(), port, config)
+}
+
+// StartServerContext is StartServer, also shutting the server down
+// gracefully, like SIGTERM does, once ctx is done. It returns when the
+// shutdown has completed, so embedding programs and tests can stop the
+// server without signals.
+func StartServerContext(ctx context.Context, port string, config *config.Config) (err error) {
+	srv, adminRouter, err := buildServer(config)
+	defer func() {
+		if err != nil {
+			srv.LogReport(srv.Report(TriggerFatal, err))
+		}
+	}()
 	if err != nil {
-		return fmt.Errorf("failed to start server on port %s: %w", port, err)
+		return err
 	}
-	defer listener.Close()
+	if adminRouter != srv.router {
+		if _, err := srv.AddListener(config.AdminAddr, adminRouter, ListenerTimeouts{
+			ReadTimeout:       config.AdminReadTimeout,
+			ConnectionTimeout: config.AdminConnectionTimeout,
+		}); err != nil {
+			return fmt.Errorf("invalid ADMIN_ADDR: %w", err)
+		}
+	}
+
+	logBanner(port, config)
+	go srv.shutdownOnSignal()
+	go srv.shutdownOnDone(ctx)
+	go srv.reloadOnSignal()
+	if config.TLSCertFile != "" && config.TLSKeyFile != "" {
+		tlsConfig, err := buildTLSConfig(config)
+		if err != nil {
+			return err
+		}
+		if warning, err := certValidity(tlsConfig, time.Now(), config.TLSExpiryWarning); err != nil {
+			utils.Warn("%v", err)
+		} else if warning != "" {
+			utils.Warn("%s", warning)
+		}
+		err = srv.ListenAndServeTLS(port, tlsConfig)
+	} else {
+		err = srv.ListenAndServe(port)
+	}
+	if err != nil {
+		return err
+	}
+	<-srv.shutdownDone
+	return nil
+}
 
-	utils.Info("Server started on %s", port)
+// NewServerFromConfig builds the Server StartServer runs for config, with
+// its routes and middleware, without listening. The admin API is left
+// out when config.AdminAddr gives it a listener of its own. Replays use it
+// to get a server like the one a recording was made on, see
+// internal/replay.
+func NewServerFromConfig(config *config.Config) (*Server, error) {
+	srv, _, err := buildServer(config)
+	return srv, err
+}
 
+// buildServer builds the server for config and returns it with the router
+// the admin API is registered on, the server's own one unless
+// config.AdminAddr is set. The server is returned even on error, for the
+// shutdown report.
+func buildServer(config *config.Config) (srv *Server, adminRouter *Router, err error) {
 	router := NewRouter()
-	setupRoutes(router)
+	srv = NewServer(config, router)
+	queryPolicy, err := ParseQueryPolicy(config.QueryDuplicates)
+	if err != nil {
+		return srv, nil, fmt.Errorf("invalid QUERY_DUPLICATES: %w", err)
+	}
+	router.SetQueryPolicy(queryPolicy)
+	methodMode, err := ParseMethodMode(config.MethodMode)
+	if err != nil {
+		return srv, nil, fmt.Errorf("invalid METHOD_MODE: %w", err)
+	}
+	router.SetMethodMode(methodMode)
+	traceMode, err := ParseMiddlewareTraceMode(config.MiddlewareTrace)
+	if err != nil {
+		return srv, nil, fmt.Errorf("invalid MIDDLEWARE_TRACE: %w", err)
+	}
+	router.SetMiddlewareTrace(traceMode, config.AdminToken)
+	router.SetQueryLimits(config.QueryMaxParams, config.QueryMaxLength)
+	router.SetBasePath(config.BasePath)
+	normalizePolicy, err := ParseNormalizePolicy(config.NormalizePaths)
+	if err != nil {
+		return srv, nil, fmt.Errorf("invalid NORMALIZE_PATHS: %w", err)
+	}
+	router.SetNormalization(NormalizeOptions{Policy: normalizePolicy, LowercaseHost: config.NormalizeHost})
+	router.SetMaxResponseSize(config.MaxResponseSize)
+	if len(config.CORSAllowedOrigins) > 0 {
+		router.SetCORS(&CORSPolicy{
+			AllowedOrigins:   config.CORSAllowedOrigins,
+			AllowedMethods:   config.CORSAllowedMethods,
+			AllowedHeaders:   config.CORSAllowedHeaders,
+			ExposedHeaders:   config.CORSExposedHeaders,
+			AllowCredentials: config.CORSAllowCredentials,
+			MaxAge:           config.CORSMaxAge,
+		})
+	}
+	srv.bodyPolicies, err = BodyPoliciesFromConfig(config.BodyPolicies)
+	if err != nil {
+		return srv, nil, fmt.Errorf("invalid BODY_POLICIES: %w", err)
+	}
+	if len(config.BannedIPs) > 0 {
+		ban, err := BanFilter(config.BannedIPs)
+		if err != nil {
+			return srv, nil, fmt.Errorf("invalid BANNED_IPS: %w", err)
+		}
+		srv.OnAccept(ban)
+	}
+	if config.MaxConnsPerIP > 0 {
+		srv.OnAccept(srv.MaxConnsPerIP(config.MaxConnsPerIP))
+	}
+	if len(config.TrustedProxies) > 0 {
+		if err := srv.TrustProxies(config.TrustedProxies); err != nil {
+			return srv, nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+		}
+	}
+	srv.SetResponseHeaderLimits(config.MaxResponseHeaderBytes, config.MaxResponseHeaders)
+	srv.filesReadOnly.Set(config.FilesReadOnly)
+	srv.files.misses = newNegativeCache(config.FilesNegativeCacheSize, config.FilesNegativeCacheTTL)
+	if config.FilesCrossProcessLocking {
+		srv.files.lockTimeout = config.FilesLockTimeout
+	}
+	srv.files.listing = config.EnableDirListing
+	if config.FilesTrashDir != "" {
+		srv.trash, err = NewTrash(config.FilesTrashDir, config.FilesTrashTTL)
+		if err != nil {
+			return srv, nil, err
+		}
+		srv.trash.files = srv.files
+		go srv.trash.Run(srv.baseCtx, trashSweepInterval(config.FilesTrashTTL))
+	}
+	if config.FilesMetaEnabled {
+		if len(config.FilesTenants) > 0 {
+			return srv, nil, errors.New("FILES_META_ENABLED is not supported together with FILES_TENANTS")
+		}
+		srv.filesMeta = NewFileMetadata(srv.files.meta, config.FilesMetaMaxBytes, config.FilesMetaHeaders)
+		srv.filesMeta.files = srv.files
+		if srv.trash != nil {
+			srv.filesMeta.SetTrash(srv.trash)
+		}
+		if config.FilesMetaSweepInterval > 0 {
+			go srv.filesMeta.Run(srv.baseCtx, getPublicDir(), config.FilesMetaSweepInterval)
+		}
+	}
+	if info, err := os.Stat(getPublicDir()); err == nil && info.IsDir() && config.FilesHealthInterval > 0 && len(config.FilesTenants) == 0 {
+		srv.filesProbe = NewFSProbe(getPublicDir(), config.FilesHealthSentinel, config.FilesHealthInterval)
+		srv.filesProbe.SetLowSpaceThreshold(config.FilesLowSpaceBytes)
+		srv.filesProbe.Check()
+		go srv.filesProbe.Run(srv.baseCtx)
+	}
+	if config.SSEEnabled {
+		srv.SetBroker(NewBroker())
+	}
+	if config.MaxBufferedBytes > 0 || config.MemoryHeapLimit > 0 {
+		srv.memory = NewMemoryBudget(config.MaxBufferedBytes)
+		if config.MemoryHeapLimit > 0 {
+			go srv.memory.Watch(srv.baseCtx, uint64(config.MemoryHeapLimit), time.Second)
+		}
+	}
+	allowPaths := config.MaintenanceAllowPaths
+	if len(allowPaths) == 0 {
+		allowPaths = DefaultMaintenanceAllowPaths
+	}
+	srv.maintenance, err = NewMaintenance(config.MaintenanceAllowIPs, allowPaths, config.MaintenanceStateFile)
+	if err != nil {
+		return srv, nil, err
+	}
+	// With ADMIN_ADDR the admin API and metrics get a router of their own,
+	// served on that address only, so the public middleware (access log,
+	// rate limits) does not apply to them.
+	adminRouter = router
+	if config.AdminAddr != "" {
+		adminRouter = NewRouter()
+		adminRouter.SetMiddlewareTrace(traceMode, config.AdminToken)
+	}
+	if adminRouter != router {
+		srv.registerAdminRoutes(adminRouter, config)
+	}
+	if config.ServerHeader {
+		srv.AfterResponse(ServerHeader())
+	}
+	if config.Compression {
+		srv.EnableCompression(config.CompressExcludeTypes)
+	}
+	extraHeaders, err := ParseExtraHeaders(config.ExtraResponseHeaders)
+	if err != nil {
+		return srv, nil, fmt.Errorf("invalid EXTRA_RESPONSE_HEADERS: %w", err)
+	}
+	if err := validateHeaderValue(config.AltSvc); config.AltSvc != "" && err != nil {
+		return srv, nil, fmt.Errorf("invalid ALT_SVC: %w", err)
+	}
+	if len(extraHeaders) > 0 || config.AltSvc != "" || config.H2CAdvertise {
+		srv.AfterResponse(AdvertiseHeaders(Advertisement{
+			AltSvc: config.AltSvc,
+			H2C:    config.H2CAdvertise,
+			Extra:  extraHeaders,
+		}))
+	}
+	if err := srv.registerRoutes(router, config, adminRouter == router); err != nil {
+		return srv, nil, err
+	}
+	if err := router.Validate(); err != nil {
+		return srv, nil, fmt.Errorf("invalid route configuration:\n%w", err)
+	}
+	router.Use(RequestIDMiddleware)
 	router.Use(LoggingMiddleware)
+	if config.DumpRequests {
+		router.Use(DumpMiddleware(0))
+	}
+	var sinks []EventSink
+	if config.EventLog != "" {
+		w, err := openLog(config.EventLog)
+		if err != nil {
+			return srv, nil, fmt.Errorf("invalid EVENT_LOG: %w", err)
+		}
+		sinks = append(sinks, NewJSONEventSink(w))
+	}
+	if config.AccessLog != "" && config.AccessLog != "off" {
+		w, err := openLog(config.AccessLog)
+		if err != nil {
+			return srv, nil, fmt.Errorf("invalid ACCESS_LOG: %w", err)
+		}
+		accessLog, err := NewAccessLogSink(w, config.AccessLogFormat)
+		if err != nil {
+			return srv, nil, fmt.Errorf("invalid ACCESS_LOG_FORMAT: %w", err)
+		}
+		sinks = append(sinks, accessLog)
+	}
+	if len(sinks) > 0 {
+		srv.SetEventSink(MultiEventSink(sinks...), config.EventLogHeaders)
+	}
+	srv.SetRawCapture(config.DebugCaptureRaw)
+	srv.SetLenientParsing(config.LenientParsing)
+	if len(config.AllowedHosts) > 0 {
+		allowed, err := NewHostAllowList(config.AllowedHosts, config.DevMode)
+		if err != nil {
+			return srv, nil, fmt.Errorf("invalid ALLOWED_HOSTS: %w", err)
+		}
+		srv.SetAllowedHosts(allowed)
+	}
+	switch config.JSONFields {
+	case "off":
+	case "lenient", "strict":
+		router.Use(ShapeJSONMiddleware(config.JSONFields == "strict"))
+	default:
+		return srv, nil, fmt.Errorf("invalid JSON_FIELDS: %q is not off, lenient or strict", config.JSONFields)
+	}
+	if len(config.ClientCertACL) > 0 {
+		router.UseBeforeBody(ClientCertMiddleware(config.ClientCertACL))
+	}
+	if config.RateLimit > 0 || config.RateLimitGlobal > 0 {
+		router.UseBeforeBody(RateLimitMiddleware(NewRateLimiter(config.RateLimit, config.RateLimitGlobal, config.RateLimitWindow)))
+	}
+	if lane := priorityLane(config); lane != nil {
+		router.SetPriorityLane(lane)
+	}
+	router.SetDefaultMaxConcurrent(config.MaxConcurrentRequests)
+	if config.FairQueue {
+		router.SetFairQueue(&FairQueueOptions{
+			ClientInFlight: config.FairQueueClientInFlight,
+			ClientQueue:    config.FairQueueClientDepth,
+			Wait:           config.FairQueueWait,
+			TopClients:     config.FairQueueTopClients,
+		})
+	}
 
-	for {
-		conn, err := listener.Accept()
+	if config.RecordDir != "" {
+		recorder, err := NewRecorder(config.RecordDir, config.RecordSamplePercent, config.RecordMaxBytes, config.RecordSpoolMaxBytes, config.RecordRedactHeaders)
 		if err != nil {
-			utils.Warn("Failed to accept connection: %v", err)
-			continue
+			return srv, nil, err
+		}
+		recorder.SetEnabled(config.RecordEnabled)
+		srv.SetRecorder(recorder)
+	}
+	if adminRouter != router {
+		adminRouter.Use(RequestIDMiddleware)
+	}
+	return srv, adminRouter, nil
+}
+
+// openLog returns the writer for a log destination: standard output for
+// "stdout", otherwise the named file, opened for appending.
+func openLog(dest string) (io.Writer, error) {
+	if dest == "stdout" {
+		return os.Stdout, nil
+	}
+	return os.OpenFile(dest, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+}
+
+// registerRoutes registers the routes config sets up for the main listener
+// on router: static mounts, the files API, health probes, docs and the
+// like, with their rate limits. With admin set, the admin API and metrics,
+// which do not change on reload, are registered on it too. StartServer
+// runs it on the main router, and ReloadRoutes on a table for Swap.
+func (s *Server) registerRoutes(router *Router, config *config.Config, admin bool) error {
+	if err := RegisterStaticMounts(router, config.StaticMounts); err != nil {
+		return fmt.Errorf("invalid STATIC_MOUNTS: %w", err)
+	}
+	if err := RegisterProxyMounts(router, config.ProxyMounts, s.proxyClient); err != nil {
+		return fmt.Errorf("invalid PROXY_MOUNTS: %w", err)
+	}
+	if err := setupRoutes(router, config, s.files, &s.filesReadOnly, s.trash, s.filesMeta, s.filesProbe, s.broker); err != nil {
+		return err
+	}
+	if s.broker != nil {
+		router.Handle("/events", "GET", s.broker.SSEHandler("files.*")).Unbatchable().Doc(RouteDoc{
+			Summary: "Server-Sent Events for file changes and admin broadcasts",
+			Params:  []ParamDoc{{Name: "topic", In: "query", Description: `Topic pattern, "*" matching one segment (default "files.*")`}},
+		})
+	}
+	if config.BatchEnabled {
+		router.Handle("/batch", "POST", BatchHandler(router, config.BatchMaxRequests)).Unbatchable().Doc(RouteDoc{Summary: "Run a JSON array of requests in one round trip"})
+	}
+	router.Handle("/healthz", "GET", s.handleHealthz).Doc(RouteDoc{Summary: "Liveness probe"})
+	router.Handle("/readyz", "GET", s.handleReadyz).Doc(RouteDoc{Summary: "Readiness probe"})
+	if admin {
+		s.registerAdminRoutes(router, s.config)
+	}
+	if config.VersionEndpoint {
+		router.Handle("/version", "GET", handleVersion).Doc(RouteDoc{Summary: "Build version, commit and Go runtime"})
+	}
+	if config.DocsEnabled {
+		router.Handle("/docs", "GET", router.DocsHandler(nil)).Doc(RouteDoc{Summary: "This page"})
+	}
+	if err := applyRouteRateLimits(router, config.RouteRateLimits); err != nil {
+		return fmt.Errorf("invalid ROUTE_RATE_LIMITS: %w", err)
+	}
+	return nil
+}
+
+// registerAdminRoutes registers the admin API, if ADMIN_TOKEN is set, and
+// /metrics on router.
+func (s *Server) registerAdminRoutes(router *Router, config *config.Config) {
+	if config.AdminToken != "" {
+		adminMaintenance := AdminAuth(config.AdminToken, s.handleAdminMaintenance)
+		router.Handle("/admin/maintenance", "GET", adminMaintenance).Doc(RouteDoc{Summary: "Show maintenance mode"})
+		router.Handle("/admin/maintenance", "POST", adminMaintenance).Doc(RouteDoc{Summary: "Switch maintenance mode on or off"})
+		router.Handle("/admin/routes", "GET", AdminAuth(config.AdminToken, s.router.handleAdminRoutes)).Doc(RouteDoc{Summary: "Routes with request counts and latency percentiles"})
+		router.Handle("/admin/routes/reload", "POST", AdminAuth(config.AdminToken, s.handleAdminRoutesReload)).Doc(RouteDoc{Summary: "Reload the routes configured in .env"})
+		router.Handle("/admin/files", "GET", AdminAuth(config.AdminToken, s.handleAdminFiles)).Doc(RouteDoc{Summary: "Show whether the files API is read-only"})
+		router.Handle("/admin/files", "POST", AdminAuth(config.AdminToken, s.handleAdminFiles)).Doc(RouteDoc{Summary: "Switch files API read-only mode on or off"})
+		if s.trash != nil {
+			router.Handle("/admin/trash", "GET", AdminAuth(config.AdminToken, s.handleAdminTrash)).Doc(RouteDoc{Summary: "List deleted files kept in the trash"})
+			router.Handle("/admin/trash/:id/restore", "POST", AdminAuth(config.AdminToken, s.handleAdminTrashRestore)).Doc(RouteDoc{
+				Summary: "Restore a deleted file to its original path",
+				Params:  []ParamDoc{{Name: "id", In: "path", Description: "Trash entry ID from the X-Trash-Id header"}},
+			})
+		}
+		router.Handle("/admin/shutdown", "POST", AdminAuth(config.AdminToken, s.handleAdminShutdown)).Doc(RouteDoc{Summary: "Shut the server down gracefully"})
+		router.Handle("/admin/streams", "GET", AdminAuth(config.AdminToken, s.handleAdminStreams)).Doc(RouteDoc{Summary: "Active response streams and how well their clients keep up"})
+		router.Handle("/admin/recorder", "GET", AdminAuth(config.AdminToken, s.handleAdminRecorder)).Doc(RouteDoc{Summary: "Show request recording state"})
+		router.Handle("/admin/recorder", "POST", AdminAuth(config.AdminToken, s.handleAdminRecorder)).Doc(RouteDoc{Summary: "Switch request recording on or off, or change its sample rate"})
+		router.Handle("/admin/config", "GET", AdminAuth(config.AdminToken, s.handleAdminConfig)).Doc(RouteDoc{Summary: "Build version and effective limits"})
+		if s.broker != nil {
+			router.Handle("/admin/events", "POST", AdminAuth(config.AdminToken, s.handleAdminEvents)).Doc(RouteDoc{Summary: "Publish an event to /events subscribers"})
+		}
+	}
+	if config.MetricsEnabled {
+		router.Handle("/metrics", "GET", handleMetrics).Doc(RouteDoc{Summary: "Metrics in the Prometheus text format"})
+	}
+}
+
+// PostProcessor inspects or mutates a response right before it is sent.
+//
+// Post-processors run for every response written by the server, including
+// router-generated 404/405 responses and 400 responses for requests that
+// could not be parsed. In the latter case req is a placeholder whose Method
+// and Path are empty, since no request line was successfully read.
+type PostProcessor func(req *Request, resp *Response)
+
+// Server binds a Router and configuration to the TCP connection loop.
+//
+// It owns cross-cutting behavior that must apply to every response regardless
+// of how routing and middleware are arranged, such as post-processors.
+type Server struct {
+	config         *config.Config
+	router         *Router
+	postProcessors []PostProcessor
+	transforms     []bodyTransform
+
+	allowedHosts *HostAllowList
+
+	compressEnabled bool
+	compressExclude []string
+
+	// draining is set once shutdown begins. It is consulted by every
+	// connection loop to close keep-alive connections politely.
+	draining atomic.Bool
+
+	warmUps    []warmUp
+	stateMu    sync.Mutex
+	state      State
+	stateSince time.Time
+
+	// bodyPolicies decides per method how request bodies are read.
+	bodyPolicies map[string]BodyPolicy
+
+	// clock drives connection age accounting (ConnectionTimeout and
+	// MAX_CONNECTION_LIFETIME) and lifecycle timestamps, see SetClock.
+	clock clock.Clock
+
+	// baseCtx parents every request context; cancelBase is called by
+	// StopTasks to cancel them all. tasks tracks Request.Go goroutines.
+	baseCtx    context.Context
+	cancelBase context.CancelFunc
+	tasks      taskGroup
+
+	// trash, if set, receives files deleted through /files/, see
+	// FILES_TRASH_DIR.
+	trash *Trash
+
+	// filesMeta, if set, serves file metadata and sweeps expired files,
+	// see FILES_META_ENABLED.
+	filesMeta *FileMetadata
+
+	// memory, if set, accounts buffered bodies against MAX_BUFFERED_BYTES
+	// and MEMORY_HEAP_LIMIT.
+	memory *MemoryBudget
+
+	// files is the state of the "/files/" handler.
+	files *publicFiles
+
+	// proxyClient sends the requests of PROXY_MOUNTS upstream, keeping
+	// connections to each upstream alive between them.
+	proxyClient *httpclient.Client
+
+	// filesReadOnly makes the files API refuse modifications, see
+	// FILES_READ_ONLY and /admin/files.
+	filesReadOnly ReadOnlySwitch
+
+	// filesProbe, if set, watches the public directory, see
+	// FILES_HEALTH_INTERVAL.
+	filesProbe *FSProbe
+
+	// maxHeaderBytes and maxHeaderCount bound every response's header
+	// block, see SetResponseHeaderLimits.
+	maxHeaderBytes int
+	maxHeaderCount int
+
+	// acceptFilters run on every accepted connection, see OnAccept.
+	acceptFilters []AcceptFilter
+
+	// connsMu guards the open connection bookkeeping: counts per client
+	// IP, the connections and listeners Shutdown closes. connWG tracks
+	// connection goroutines.
+	connsMu   sync.Mutex
+	connsByIP map[string]int
+	openConns map[net.Conn]struct{}
+	listeners []net.Listener
+	connWG    sync.WaitGroup
+
+	// startedAt, stats and shutdownDone feed and end the shutdown report,
+	// see Shutdown.
+	startedAt    time.Time
+	stats        serverStats
+	shutdownDone chan struct{}
+
+	// trustedProxies are the proxies ClientIP skips in X-Forwarded-For,
+	// see TrustProxies.
+	trustedProxies []*net.IPNet
+
+	// streams tracks the active response streams, see /admin/streams.
+	streams *streamRegistry
+
+	// maintenance, if set, answers requests with 503 before routing while
+	// maintenance mode is on.
+	maintenance *Maintenance
+
+	// captureRaw makes the parser keep raw request and header lines, see
+	// SetRawCapture.
+	captureRaw atomic.Bool
+
+	// recorder, if set, records sampled requests and responses for
+	// replay, see SetRecorder.
+	recorder *Recorder
+
+	// lenientParsing makes the parser tolerate sloppy clients, see
+	// SetLenientParsing.
+	lenientParsing atomic.Bool
+
+	// broker, if set, is closed when draining starts, see SetBroker.
+	broker *Broker
+
+	// events, if set, receives a RequestEvent per request, see
+	// SetEventSink.
+	events atomic.Pointer[eventConfig]
+
+	// tlsConfig is set by ListenAndServeTLS; connections then complete a
+	// TLS handshake before the first request is read.
+	tlsConfig *tls.Config
+}
+
+// NewServer creates a Server that dispatches requests to router.
+func NewServer(config *config.Config, router *Router) *Server {
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	proxyClient := httpclient.New(httpclient.Options{
+		DialTimeout:     config.ProxyDialTimeout,
+		HeaderTimeout:   config.ProxyHeaderTimeout,
+		BodyIdleTimeout: config.ProxyBodyTimeout,
+	})
+	return &Server{
+		config:         config,
+		router:         router,
+		state:          StateStarting,
+		stateSince:     time.Now(),
+		clock:          clock.Real,
+		bodyPolicies:   DefaultBodyPolicies,
+		maxHeaderBytes: DefaultMaxResponseHeaderBytes,
+		maxHeaderCount: DefaultMaxResponseHeaders,
+		startedAt:      time.Now(),
+		shutdownDone:   make(chan struct{}),
+		streams:        newStreamRegistry(),
+		files:          newPublicFiles(),
+		proxyClient:    proxyClient,
+		baseCtx:        baseCtx,
+		cancelBase:     cancelBase,
+	}
+}
+
+// SetClock replaces the server's time source for connection age
+// accounting, lifecycle timestamps and the expiry of cached file misses.
+// Socket read deadlines always use the wall clock, since the network
+// stack enforces them.
+func (s *Server) SetClock(c clock.Clock) {
+	s.clock = c
+	s.files.misses.setClock(c)
+	if s.trash != nil {
+		s.trash.SetClock(c)
+	}
+	if s.filesProbe != nil {
+		s.filesProbe.SetClock(c)
+	}
+	if s.filesMeta != nil {
+		s.filesMeta.SetClock(c)
+	}
+}
+
+// SetRecorder makes the server record requests on its public listeners
+// with r while r is enabled. Set it before serving.
+func (s *Server) SetRecorder(r *Recorder) {
+	s.recorder = r
+}
+
+// SetRawCapture turns raw request capture on or off for requests read from
+// now on. While on, every Request carries its RawRequestLine and
+// RawHeaders for the dump middleware and crash reports. It is off by
+// default because it costs an allocation per header line.
+func (s *Server) SetRawCapture(enabled bool) {
+	s.captureRaw.Store(enabled)
+}
+
+// AfterResponse registers a post-processor that runs on every response right
+// before it is written to the connection. Post-processors run in registration
+// order and may modify the response headers and body.
+//
+// Example:
+//
+//	srv.AfterResponse(func(req *Request, resp *Response) {
+//	    resp.Headers["Server"] = "httpServer"
+//	})
+func (s *Server) AfterResponse(pp PostProcessor) {
+	s.postProcessors = append(s.postProcessors, pp)
+}
+
+// BeginDrain switches the server into draining mode.
+//
+// Responses to requests already being processed get "Connection: close",
+// and requests that arrive afterwards on existing keep-alive connections
+// are answered with 503 and a Retry-After of the configured drain timeout
+// before the connection is closed.
+func (s *Server) BeginDrain() {
+	if s.draining.CompareAndSwap(false, true) {
+		utils.Info("Draining connections (Retry-After %v)", s.config.DrainTimeout)
+		s.setState(StateDraining)
+	}
+}
+
+// IsDraining reports whether BeginDrain has been called.
+func (s *Server) IsDraining() bool {
+	return s.draining.Load()
+}
+
+// ListenAndServe listens on the TCP address addr and serves each accepted
+// connection in its own goroutine.
+//
+// Returns:
+//   - error: If the TCP listener fails to start or a fatal warm-up fails.
+//     Otherwise, this function blocks indefinitely until externally terminated.
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start server on port %s: %w", addr, err)
+	}
+
+	utils.Info("Server started on %s", addr)
+	return s.Serve(listener)
+}
+
+// ListenAndServeTLS is like ListenAndServe but wraps every accepted
+// connection in TLS using tlsConfig. The handshake is performed eagerly and
+// must finish within TLS_HANDSHAKE_TIMEOUT, so clients that connect and
+// stall cannot hold a connection open.
+func (s *Server) ListenAndServeTLS(addr string, tlsConfig *tls.Config) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start TLS server on port %s: %w", addr, err)
+	}
+	s.tlsConfig = tlsConfig
+
+	utils.Info("TLS server started on %s (client auth: %s)", addr, s.config.TLSClientAuth)
+	return s.Serve(listener)
+}
+
+// Serve runs the warm-up functions and accepts connections on listener,
+// serving each in its own goroutine. It lets tests and embedders supply
+// their own listener, such as one on port :0 or one already wrapped with
+// tls.NewListener.
+//
+// Returns:
+//   - error: The fatal warm-up error, if any, or nil once the listener is
+//     closed. Closing the listener is the way to stop Serve.
+func (s *Server) Serve(listener net.Listener) error {
+	defer listener.Close()
+	s.trackListener(listener)
+
+	warmUpErr := make(chan error, 1)
+	go func() {
+		if err := s.runWarmUps(); err != nil {
+			utils.Error("Fatal warm-up failure: %v", err)
+			warmUpErr <- err
+			listener.Close()
 		}
-		go handleConnection(conn, router, config)
+	}()
+
+	s.acceptLoop(listener, s.mainBinding())
+	s.setState(StateStopped)
+	select {
+	case err := <-warmUpErr:
+		return err
+	default:
+		return nil
+	}
+}
+
+// finalizeResponse renders error responses in the negotiated format, then
+// runs the registered body transformers and post-processors on resp and
+// finally compresses it.
+func (s *Server) finalizeResponse(req *Request, resp *Response) {
+	if resp.Headers == nil {
+		resp.Headers = make(map[string]string)
 	}
+	s.renderProblem(req, resp)
+	s.applyTransforms(req, resp)
+	for _, pp := range s.postProcessors {
+		pp(req, resp)
+	}
+	s.compress(req, resp)
 }
 
-func setupRoutes(router *Router) {
+func setupRoutes(router *Router, config *config.Config, files *publicFiles, readOnly *ReadOnlySwitch, trash *Trash, meta *FileMetadata, probe *FSProbe, broker *Broker) error {
+	filesHandler := files.handle
+	if len(config.FilesTenants) > 0 {
+		tenantFiles, err := NewTenantFiles(config.FilesTenants)
+		if err != nil {
+			return fmt.Errorf("failed to set up tenant files: %w", err)
+		}
+		filesHandler = tenantFiles.Handle
+	}
+	if trash != nil {
+		if len(config.FilesTenants) > 0 {
+			return errors.New("FILES_TRASH_DIR is not supported together with FILES_TENANTS")
+		}
+		filesHandler = SoftDelete(trash, filesHandler)
+	}
+	if broker != nil {
+		filesHandler = PublishFileEvents(broker, filesHandler)
+	}
+	if meta != nil {
+		filesHandler = meta.Headers(filesHandler)
+	}
+	filesHandler = readOnly.Guard(filesHandler)
+	if probe != nil {
+		filesHandler = probe.Guard(filesHandler)
+	}
+	var uploadGuards []MiddlewareFunc
+	if len(config.FilesTenants) == 0 {
+		uploadGuards = append(uploadGuards, DiskSpaceGuard(getPublicDir(), config.FilesUploadSpaceMargin))
+	}
+
 	router.Handle("/", "GET", handleRoot)
 	router.Handle("/", "HEAD", handleRoot)
 	router.Handle("/", "OPTIONS", handleRoot)
 
-	router.Handle("/echo/", "GET", handleEcho)
-	router.Handle("/echo/", "HEAD", handleEcho)
-	router.Handle("/echo/", "OPTIONS", handleEcho)
+	router.HandlePrefix("/echo/", "GET", handleEcho)
+	router.HandlePrefix("/echo/", "HEAD", handleEcho)
+	router.HandlePrefix("/echo/", "OPTIONS", handleEcho)
 
 	router.Handle("/user-agent", "GET", handleUserAgent)
 	router.Handle("/user-agent", "HEAD", handleUserAgent)
 	router.Handle("/user-agent", "OPTIONS", handleUserAgent)
 
-	router.HandlePrefix("/files/", "GET", handleFiles)
-	router.HandlePrefix("/files/", "HEAD", handleFiles)
-	router.HandlePrefix("/files/", "POST", handleFiles)
-	router.HandlePrefix("/files/", "PUT", handleFiles)
-	router.HandlePrefix("/files/", "DELETE", handleFiles)
-	router.HandlePrefix("/files/", "OPTIONS", handleFiles)
+	router.HandlePrefix("/files/", "GET", filesHandler).Doc(RouteDoc{
+		Summary: "Download, upload and delete files",
+		Description: "GET supports Range and If-Range. POST and PUT store the request body; " +
+			"DELETE removes the file.",
+	})
+	router.HandlePrefix("/files/", "HEAD", filesHandler)
+	router.HandlePrefix("/files/", "POST", filesHandler).BeforeBody(uploadGuards...).StreamBody()
+	router.HandlePrefix("/files/", "PUT", filesHandler).BeforeBody(uploadGuards...).StreamBody()
+	router.HandlePrefix("/files/", "DELETE", filesHandler)
+	router.HandlePrefix("/files/", "OPTIONS", filesHandler)
+	// Every other method reaches the handler too, so read-only mode can
+	// answer PATCH, MOVE and COPY with 403 and the rest with its own Allow.
+	router.HandlePrefix("/files/", "", filesHandler)
 
-	router.HandleRegex(`^/user/\d+$`, handleUserByID)
+	if meta != nil {
+		metaHandler := meta.Handle(readOnly)
+		if probe != nil {
+			metaHandler = probe.Guard(metaHandler)
+		}
+		router.HandlePrefix("/files-meta/", "GET", metaHandler).Doc(RouteDoc{
+			Summary: "Read and change the metadata of a file",
+			Description: "GET returns the file's metadata as a JSON object of strings. PUT replaces it, " +
+				"PATCH merges into it (null removes a key) and DELETE clears it. " +
+				`An "expires" key in RFC 3339 has the file deleted once that time passes.`,
+		})
+		router.HandlePrefix("/files-meta/", "", metaHandler)
+	}
+
+	router.Handle("/user/:id", "GET", handleUserByID).Doc(RouteDoc{
+		Summary: "Look up a user",
+		Params:  []ParamDoc{{Name: "id", In: "path", Description: "Numeric user ID"}},
+	})
+
+	router.Handle("/stream", "GET", handleStream).Unbatchable()
 
-	router.Handle("/stream", "GET", handleStream)
+	router.Handle("/generate", "GET", handleGenerate(config.GenerateMaxBytes)).Doc(RouteDoc{
+		Summary:     "Stream a deterministic synthetic payload",
+		Description: "Honors Range requests; the same seed always yields the same bytes.",
+		Params: []ParamDoc{
+			{Name: "size", In: "query", Description: "Payload size, e.g. 10MB"},
+			{Name: "chunk", In: "query", Description: "Chunk size for chunked transfer"},
+			{Name: "delay", In: "query", Description: "Pause between chunks, e.g. 10ms"},
+			{Name: "seed", In: "query", Description: "Generator seed"},
+		},
+	}).Unbatchable()
+	router.Handle("/generate", "HEAD", handleGenerate(config.GenerateMaxBytes))
 
 	utils.Info("All routes registered successfully")
+	return nil
+}
+
+// connectionExpiry returns when a connection accepted at start should stop
+// being kept alive, or the zero time if lifetime is 0 (unlimited). The
+// lifetime is spread by up to ±jitterPercent so that clients connected at
+// the same moment do not all reconnect in lockstep.
+func connectionExpiry(start time.Time, lifetime time.Duration, jitterPercent int) time.Time {
+	if lifetime <= 0 {
+		return time.Time{}
+	}
+	if jitterPercent > 0 {
+		spread := float64(lifetime) * float64(min(jitterPercent, 100)) / 100
+		lifetime += time.Duration(spread * (2*rand.Float64() - 1))
+	}
+	return start.Add(lifetime)
+}
+
+// rejectMalformed answers a request that could not be read, or whose body
+// was refused, with its *HTTPError status, 408 if the client was too slow
+// to send it, or 400, and closes the connection afterwards. The request's
+// event, if any, is emitted.
+func (s *Server) rejectMalformed(conn net.Conn, b *binding, req *Request, err error, ev *RequestEvent) {
+	utils.Warn("Malformed or oversized request: %v", err)
+	resp := BadRequestResponse()
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		resp = httpErr.Response()
+	} else if errors.Is(err, os.ErrDeadlineExceeded) {
+		resp = NewHTTPError(408, "the request was not received in time").Response()
+	}
+	if errors.Is(err, errMemoryBudget) {
+		resp.Headers["Retry-After"] = fmt.Sprint(int(memoryRetryAfter.Seconds()))
+	}
+	resp.Headers["Connection"] = "close"
+	s.finalizeResponse(req, &resp)
+
+	if sendErr := s.sendResponse(conn, b, resp, ev); sendErr != nil {
+		logSendError(fmt.Sprintf("%d response", resp.Status), sendErr)
+	} else {
+		s.countResponse(resp.Status)
+	}
+	s.finishEvent(ev, req)
 }
 
 // handleConnection manages the lifecycle of a single client TCP connection.
@@ -93,85 +859,243 @@ func setupRoutes(router *Router) {
 //
 // Flow:
 //  1. Sets a read deadline of 5 seconds to prevent hanging connections.
-//  2. Parses the HTTP request using ParseRequest.
-//  3. Routes the request via the provided Router.
+//  2. Reads the request line and headers.
+//  3. Routes the request and runs the pre-body middleware (see
+//     Router.UseBeforeBody); only if they pass is the body read, after a
+//     "100 Continue" if the client asked for one, and the request handled.
 //  4. Adds the appropriate "Connection" header based on the request.
-//  5. Sends the response and repeats if "Connection: keep-alive".
-//  6. Terminates on "Connection: close" or any read/send error.
+//  5. Runs the registered post-processors on the response.
+//  6. Sends the response and repeats if "Connection: keep-alive".
+//  7. Terminates on "Connection: close" or any read/send error.
 //
 // Parameters:
 //   - conn: TCP connection representing the client session.
-//   - router: Router instance responsible for dispatching requests.
+//   - trusted: Whether an accept filter marked the peer as a trusted proxy.
+//   - b: The router and timeouts of the listener conn was accepted on.
 //
 // Behavior:
 //   - Closes the connection after inactivity or errors.
-//   - Logs requests and responses with status and reason.
+//   - Answers with "Connection: close" once the connection is older than
+//     MAX_CONNECTION_LIFETIME (see connectionExpiry).
+//   - Logs requests and responses with host, status and reason.
+//   - Keeps no per-connection request state: everything derived from a
+//     request (host, client address, TLS details, context) is stored on
+//     that Request, so consecutive requests for different hosts on one
+//     keep-alive connection are treated independently.
 //   - Handles EOF gracefully when the client disconnects.
 //
 // Example:
 //
-//	go handleConnection(conn, router)
-func handleConnection(conn net.Conn, router *Router, config *config.Config) {
+//	go s.handleConnection(conn, false, s.mainBinding())
+func (s *Server) handleConnection(conn net.Conn, trusted bool, b *binding) {
 	defer conn.Close()
+	startTime := s.clock.Now()
+	config := s.config
+	expires := connectionExpiry(startTime, b.maxConnectionLifetime, config.ConnectionLifetimeJitter)
+
+	if b.tlsConfig != nil {
+		tlsConn, err := s.handshake(conn)
+		if err != nil {
+			return
+		}
+		conn = tlsConn
+	}
 
-	startTime := time.Now()
 	requestCount := 0
 
 	defer func() {
-		utils.Info("Connection closed after %d requests, duration: %v", requestCount, time.Since(startTime))
+		utils.Info("Connection closed after %d requests, duration: %v", requestCount, clock.Since(s.clock, startTime))
 	}()
 
+	// One reader serves the whole connection, so pipelined requests
+	// buffered with the previous one are not lost.
+	source := &connSource{conn: conn}
+	reader := bufio.NewReader(source)
 	for {
-		conn.SetReadDeadline(time.Now().Add(config.ReadTimeout))
-
-		if time.Since(startTime) > config.ConnectionTimeout {
+		if clock.Since(s.clock, startTime) > b.connectionTimeout {
 			utils.Warn("Connection timeout reached; closing connection")
 			return
 		}
 
-		if requestCount > config.MaxRequestPerConn {
-			utils.Warn("Max requests per connection reached (%d); closing connection", config.MaxRequestPerConn)
+		var rec *recordExchange
+		if b.public {
+			rec = s.recorder.begin(conn, startTime)
 		}
+		source.rec = rec
 
-		req, err := ParseRequest(conn)
+		// Between requests on a keep-alive connection the client gets the
+		// idle timeout to start the next one, then the read timeout to send
+		// it.
+		if requestCount > 0 && reader.Buffered() == 0 {
+			conn.SetReadDeadline(time.Now().Add(b.idleTimeout))
+			if _, err := reader.Peek(1); err != nil {
+				if errors.Is(err, os.ErrDeadlineExceeded) {
+					utils.Debug("Closing keep-alive connection idle for %v", b.idleTimeout)
+				} else {
+					utils.Debug("Connection closed by client")
+				}
+				return
+			}
+		}
+		conn.SetReadDeadline(time.Now().Add(b.readTimeout))
+		req, err := readRequestHead(reader, headOptions{captureRaw: s.captureRaw.Load(), lenient: s.lenientParsing.Load()})
 		if err != nil {
-			if errors.Is(err, io.EOF) {
+			if errors.Is(err, io.EOF) || isPeerDisconnect(err) {
 				utils.Debug("Connection closed by client")
 				return
 			}
-			utils.Warn("Malformed or oversized request: %v", err)
-			resp := Response{
-				Version: HTTPVersion,
-				Status:  400,
-				Reason:  "Bad Request",
-				Headers: map[string]string{"Content-Type": "text/plain"},
-				Body:    []byte("400 Bad Request"),
-			}
+			ev := s.startEvent(conn)
+			ev.fail(headErrorCode(err), err)
+			s.rejectMalformed(rec.sink(conn), b, &Request{Headers: map[string]string{}}, err, ev)
+			rec.finish()
+			return
+		}
+		requestCount++
+		rec.headRead(s.clock.Now(), requestCount)
+		ev := s.startEvent(conn)
+		req.RemoteAddr = conn.RemoteAddr().String()
+		req.trusted = trusted
+		req.trustedProxies = s.trustedProxies
+		ctx, cancelCause := context.WithCancelCause(s.baseCtx)
+		cancel := func() { cancelCause(nil) }
+		req.ctx = ctx
+		req.tasks = &s.tasks
+		req.memory = s.memory
+		req.SetBodyPreviewLimit(config.BodyPreviewBytes)
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			req.TLSState = newTLSInfo(tlsConn.ConnectionState())
+		}
+		s.setEventRequest(ev, req)
+		utils.Info("Incoming request: %s %s (host=%s)", req.Method, req.Path, req.Host())
 
-			if sendErr := SendResponse(conn, resp); sendErr != nil {
-				utils.Warn("Failed to send 400 response: %v", sendErr)
+		if s.IsDraining() {
+			utils.Debug("Rejecting request during drain: %s %s", req.Method, req.Path)
+			resp := ServiceUnavailableResponse(config.DrainTimeout)
+			resp.Headers["Connection"] = "close"
+			s.finalizeResponse(req, &resp)
+			if err := s.sendResponse(conn, b, resp, ev); err != nil {
+				logSendError("503 response", err)
+			} else {
+				s.countResponse(resp.Status)
 			}
+			s.finishEvent(ev, req)
+			cancel()
 			return
 		}
-		utils.Info("Incoming request: %s %s", req.Method, req.Path)
 
-		resp := router.Route(req)
+		// Routing and the pre-body middleware run on the headers alone; the
+		// body is read only if they let the request through.
+		bodyRead := false
+		var resp Response
+		inMaintenance := false
+		var rejection *Response
+		stopWatch := func() {}
+		if b.public {
+			rejection = s.checkHost(req)
+			if rejection == nil {
+				resp, inMaintenance = s.maintenanceResponse(req)
+			}
+		}
+		if rejection != nil {
+			resp = *rejection
+		} else if !inMaintenance {
+			if rejection := b.router.CheckBeforeBody(req); rejection != nil {
+				resp = *rejection
+			} else {
+				streamed, err := openRequestBody(reader, conn, req, s.bodyPolicies)
+				if err == nil && !streamed {
+					err = readRequestBody(reader, conn, req, s.bodyPolicies)
+				}
+				if err != nil {
+					req.releaseMemory()
+					ev.fail(bodyErrorCode(err), err)
+					if isPeerDisconnect(err) {
+						s.finishEvent(ev, req)
+					} else {
+						s.rejectMalformed(rec.sink(conn), b, req, err, ev)
+						rec.finish()
+					}
+					cancel()
+					return
+				}
+				if ev != nil {
+					ev.BodyRead = s.clock.Now()
+				}
+				// A streamed body is read by the handler, so the connection
+				// cannot be watched meanwhile.
+				if reader.Buffered() == 0 && !streamed {
+					stopWatch = source.watch(cancelCause)
+				}
+				resp = b.router.Route(req)
+				bodyRead = !streamed || req.bodyStream.finish()
+			}
+		}
+		if resp.Headers == nil {
+			resp.Headers = make(map[string]string)
+		}
+		if ev != nil {
+			ev.Handled = s.clock.Now()
+		}
 
 		connectionHeader := strings.ToLower(req.Headers["connection"])
+		if s.IsDraining() {
+			connectionHeader = "close"
+		}
+		if n, err := req.declaredLength(); (n > 0 || err != nil || req.isChunked()) && !bodyRead {
+			utils.Debug("Closing connection: request body was not read")
+			connectionHeader = "close"
+		}
+		if b.maxRequests > 0 && requestCount >= b.maxRequests {
+			utils.Debug("Connection served its %d requests; closing after this response", b.maxRequests)
+			connectionHeader = "close"
+		}
+		if !expires.IsZero() && s.clock.Now().After(expires) {
+			utils.Debug("Connection lifetime exceeded after %v; closing after this response", clock.Since(s.clock, startTime))
+			connectionHeader = "close"
+		}
 		if connectionHeader == "keep-alive" {
 			resp.Headers["Connection"] = "keep-alive"
 		} else {
 			resp.Headers["Connection"] = "close"
 		}
+		s.finalizeResponse(req, &resp)
+		if strings.EqualFold(req.Method, "HEAD") {
+			stripHEADBody(&resp)
+		}
+		s.enforceHeaderLimits(req, &resp)
+		resp.cancel = cancel
+		if resp.StreamFunc != nil {
+			resp.stream = s.streamOptions(req, b.writeTimeout)
+		}
+		if resp.ChunkSize == 0 {
+			resp.ChunkSize = config.StreamChunkSize
+		}
 
-		if err := SendResponse(conn, resp); err != nil {
-			utils.Warn("Failed to send response: %v", err)
+		held := s.memory.Hold(int64(len(resp.Body)))
+		if resp.StreamFunc != nil {
+			held += s.memory.Hold(int64(resp.ChunkSize))
+		}
+		err = s.sendResponse(rec.sink(conn), b, resp, ev)
+		s.memory.Release(held)
+		req.releaseMemory()
+		req.releaseHeldSlot()
+		stopWatch()
+		cancel()
+		s.finishEvent(ev, req)
+		rec.finish()
+		if err != nil {
+			logSendError("response", err)
 			return
 		}
+		s.countResponse(resp.Status)
 
-		utils.Info("Response sent: %s %s -> %d %s", req.Method, req.Path, resp.Status, resp.Reason)
+		tag := ""
+		if inMaintenance {
+			tag = " [maintenance]"
+		}
+		utils.Info("Response sent: %s %s (host=%s) -> %d %s%s", req.Method, req.Path, req.Host(), resp.Status, resp.Reason, tag)
 
-		if connectionHeader == "close" {
+		if connectionCloses(resp.Headers["Connection"]) {
 			utils.Debug("Closing connection as per header")
 			return
 		}
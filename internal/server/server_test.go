@@ -0,0 +1,481 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/clock"
+	"github.com/Abb133Se/httpServer/internal/config"
+	"github.com/Abb133Se/httpServer/internal/testclient"
+)
+
+func TestServeKeepAlive(t *testing.T) {
+	srv, addr := startServer(t)
+	c := dial(t, addr)
+
+	keepAlive := map[string]string{"Connection": "keep-alive", "User-Agent": "keepalive-test"}
+	for _, tt := range []struct{ path, body string }{
+		{"/", "Welcome to my HTTP server"},
+		{"/user-agent", "keepalive-test"},
+	} {
+		resp := roundTrip(t, c, "GET", tt.path, keepAlive, nil)
+		if resp.Status != 200 || string(resp.Body) != tt.body {
+			t.Fatalf("GET %s = %d %q, want 200 %q", tt.path, resp.Status, resp.Body, tt.body)
+		}
+		if got := resp.Header("Connection"); got != "keep-alive" {
+			t.Errorf("GET %s: Connection = %q, want keep-alive", tt.path, got)
+		}
+	}
+	if err := c.ExpectEmpty(50 * time.Millisecond); err != nil {
+		t.Error(err)
+	}
+	if n := srv.ConnsFrom("127.0.0.1"); n != 1 {
+		t.Errorf("open connections = %d, want both requests on one", n)
+	}
+}
+
+func TestServeNoContentKeepAlive(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	// A 204 ends with its head: anything after it would be read as the
+	// start of the next response.
+	if err := c.SendRaw([]byte("OPTIONS / HTTP/1.1\r\nHost: x\r\nConnection: keep-alive\r\n\r\n" +
+		"GET / HTTP/1.1\r\nHost: x\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	options, err := c.ReadResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Status != 204 || len(options.Body) != 0 || options.Header("Allow") != "GET, HEAD, OPTIONS" {
+		t.Errorf("OPTIONS / = %d %q, Allow %q; want an empty 204 with Allow", options.Status, options.Body, options.Header("Allow"))
+	}
+	resp, err := c.ReadResponse()
+	if err != nil {
+		t.Fatalf("reading the response after the 204: %v", err)
+	}
+	if resp.Status != 200 || string(resp.Body) != "Welcome to my HTTP server" {
+		t.Errorf("GET / after OPTIONS = %d %q, want 200", resp.Status, resp.Body)
+	}
+	if err := c.ExpectClose(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServeKeepAliveDifferentHosts(t *testing.T) {
+	sites := map[string]string{"a.example": "site a", "b.example": "site b"}
+	router := NewRouter()
+	router.Handle("/whoami", "GET", func(req *Request) Response {
+		site, ok := sites[req.Host()]
+		if !ok {
+			return NotFoundResponse()
+		}
+		return textResponse(site + " for " + req.ClientIP())
+	})
+	srv := NewServer(config.LoadConfig(), router)
+	if err := srv.TrustProxies([]string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	c := dial(t, serve(t, srv))
+
+	for _, tt := range []struct{ host, forwarded, want string }{
+		{"a.example", "203.0.113.1", "site a for 203.0.113.1"},
+		{"B.Example:8080", "203.0.113.2", "site b for 203.0.113.2"},
+		{"a.example", "", "site a for 127.0.0.1"},
+	} {
+		headers := map[string]string{"Host": tt.host, "Connection": "keep-alive"}
+		if tt.forwarded != "" {
+			headers["X-Forwarded-For"] = tt.forwarded
+		}
+		resp := roundTrip(t, c, "GET", "/whoami", headers, nil)
+		if resp.Status != 200 || string(resp.Body) != tt.want {
+			t.Errorf("GET /whoami for %s = %d %q, want 200 %q", tt.host, resp.Status, resp.Body, tt.want)
+		}
+	}
+	if resp := roundTrip(t, c, "GET", "/whoami", map[string]string{"Host": "c.example", "Connection": "keep-alive"}, nil); resp.Status != 404 {
+		t.Errorf("GET /whoami for an unknown host = %d, want 404", resp.Status)
+	}
+	if n := srv.ConnsFrom("127.0.0.1"); n != 1 {
+		t.Errorf("open connections = %d, want all requests on one", n)
+	}
+}
+
+func TestServeClosesWithoutKeepAlive(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	resp := roundTrip(t, c, "GET", "/", map[string]string{"Connection": "close"}, nil)
+	if got := resp.Header("Connection"); got != "close" {
+		t.Errorf("Connection = %q, want close", got)
+	}
+	if err := c.ExpectClose(); err != nil {
+		t.Error(err)
+	}
+}
+
+// startServerWithClock is startServer with adjust applied to the
+// configuration and a manual clock driving the server, which it returns
+// with the server's address.
+func startServerWithClock(t *testing.T, adjust func(*config.Config)) (*clock.Manual, string) {
+	t.Helper()
+	cfg := loadConfig()
+	adjust(cfg)
+	srv, err := NewServerFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("building server: %v", err)
+	}
+	fake := clock.NewManual(time.Now())
+	srv.SetClock(fake)
+	return fake, serve(t, srv)
+}
+
+func TestServeMaxConnectionLifetime(t *testing.T) {
+	fake, addr := startServerWithClock(t, func(cfg *config.Config) {
+		cfg.MaxConnectionLifetime = time.Minute
+		cfg.ConnectionLifetimeJitter = 0
+	})
+	c := dial(t, addr)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+
+	if resp := roundTrip(t, c, "GET", "/", keepAlive, nil); resp.Header("Connection") != "keep-alive" {
+		t.Fatalf("young connection: Connection = %q, want keep-alive", resp.Header("Connection"))
+	}
+	fake.Advance(time.Minute + time.Second)
+	if resp := roundTrip(t, c, "GET", "/", keepAlive, nil); resp.Header("Connection") != "close" {
+		t.Errorf("expired connection: Connection = %q, want close", resp.Header("Connection"))
+	}
+	if err := c.ExpectClose(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServeConnectionTimeout(t *testing.T) {
+	fake, addr := startServerWithClock(t, func(cfg *config.Config) {
+		cfg.ConnectionTimeout = time.Minute
+	})
+	c := dial(t, addr)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+
+	roundTrip(t, c, "GET", "/", keepAlive, nil)
+	fake.Advance(2 * time.Minute)
+	// The request already being read is answered; the age is checked
+	// before waiting for the next one.
+	roundTrip(t, c, "GET", "/", keepAlive, nil)
+	if err := c.ExpectClose(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServeMaxRequestsPerConn(t *testing.T) {
+	_, addr := startServerWithClock(t, func(cfg *config.Config) {
+		cfg.MaxRequestPerConn = 3
+	})
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+
+	c := dial(t, addr)
+	for i := 1; i <= 3; i++ {
+		resp := roundTrip(t, c, "GET", "/", keepAlive, nil)
+		want := "keep-alive"
+		if i == 3 {
+			want = "close"
+		}
+		if resp.Status != 200 || resp.Header("Connection") != want {
+			t.Errorf("request %d = %d, Connection %q; want 200, %s", i, resp.Status, resp.Header("Connection"), want)
+		}
+	}
+	if err := c.ExpectClose(); err != nil {
+		t.Error(err)
+	}
+
+	// Pipelined requests past the limit are left unanswered.
+	c = dial(t, addr)
+	var pipeline []byte
+	for range 4 {
+		pipeline = append(pipeline, testclient.BuildRequest("GET", "/", addr, keepAlive, nil)...)
+	}
+	if err := c.SendRaw(pipeline); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		if resp, err := c.ReadResponse(); err != nil || resp.Status != 200 {
+			t.Fatalf("pipelined response %d = %v, %v; want 200", i, resp, err)
+		}
+	}
+	if err := c.ExpectClose(); err != nil {
+		t.Errorf("after the third pipelined response: %v", err)
+	}
+}
+
+func TestServeUnlimitedRequestsPerConn(t *testing.T) {
+	_, addr := startServerWithClock(t, func(cfg *config.Config) {
+		cfg.MaxRequestPerConn = 0
+	})
+	c := dial(t, addr)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+	// Past the default of 100.
+	for i := 1; i <= 150; i++ {
+		if resp := roundTrip(t, c, "GET", "/", keepAlive, nil); resp.Header("Connection") != "keep-alive" {
+			t.Fatalf("request %d: Connection = %q, want keep-alive", i, resp.Header("Connection"))
+		}
+	}
+}
+
+func TestServeKeepAliveIdleTimeout(t *testing.T) {
+	_, addr := startServerWithClock(t, func(cfg *config.Config) {
+		cfg.IdleTimeout = 200 * time.Millisecond
+		cfg.ReadTimeout = 5 * time.Second
+	})
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+
+	t.Run("closed once idle", func(t *testing.T) {
+		c := dial(t, addr)
+		roundTrip(t, c, "GET", "/", keepAlive, nil)
+		time.Sleep(100 * time.Millisecond)
+		roundTrip(t, c, "GET", "/", keepAlive, nil)
+
+		start := time.Now()
+		if err := c.ExpectClose(); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > 2*time.Second {
+			t.Errorf("idle connection closed after %v, want about IDLE_TIMEOUT", elapsed)
+		}
+	})
+
+	t.Run("first request", func(t *testing.T) {
+		// A new connection waits READ_TIMEOUT, not IDLE_TIMEOUT, for its
+		// first request.
+		c := dial(t, addr)
+		time.Sleep(400 * time.Millisecond)
+		if resp := roundTrip(t, c, "GET", "/", keepAlive, nil); resp.Status != 200 {
+			t.Errorf("first request after a pause = %d, want 200", resp.Status)
+		}
+	})
+
+	t.Run("slow request head", func(t *testing.T) {
+		// IDLE_TIMEOUT bounds the wait for a request to start; once it
+		// has, READ_TIMEOUT applies to the rest of it.
+		c := dial(t, addr)
+		roundTrip(t, c, "GET", "/", keepAlive, nil)
+		time.Sleep(100 * time.Millisecond)
+		if err := c.SendRaw([]byte("GET / HTTP/1.1\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(400 * time.Millisecond)
+		if err := c.SendRaw([]byte("Host: x\r\nConnection: keep-alive\r\n\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		if resp, err := c.ReadResponse(); err != nil || resp.Status != 200 {
+			t.Errorf("request started within IDLE_TIMEOUT = %v, %v; want 200", resp, err)
+		}
+	})
+}
+
+func TestServeSlowBodyTimesOut(t *testing.T) {
+	chdirPublic(t)
+	_, addr := startServerWithClock(t, func(cfg *config.Config) {
+		cfg.ReadTimeout = 200 * time.Millisecond
+	})
+	c := dial(t, addr)
+
+	// Half the body arrives, then the client stalls past the read timeout.
+	resp := roundTrip(t, c, "PUT", "/files/slow.txt", map[string]string{"Content-Length": "10"}, []byte("hello"))
+	if resp.Status != 408 {
+		t.Errorf("status = %d, want 408", resp.Status)
+	}
+	if err := c.ExpectClose(); err != nil {
+		t.Error(err)
+	}
+
+	// A body that ends before its length is malformed, not slow.
+	c = dial(t, addr)
+	if err := c.SendRaw([]byte("PUT /files/bad.txt HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := c.ReadResponse(); err != nil || resp.Status != 400 {
+		t.Errorf("malformed chunked body: %v, %v; want 400", resp, err)
+	}
+}
+
+func TestServePostProcessorsOnServerResponses(t *testing.T) {
+	srv, err := NewServerFromConfig(loadConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen []string
+	var mu sync.Mutex
+	srv.AfterResponse(func(req *Request, resp *Response) {
+		resp.Headers["X-Post"] = "1"
+		mu.Lock()
+		seen = append(seen, req.Method+" "+req.Path)
+		mu.Unlock()
+	})
+	srv.AfterResponse(func(req *Request, resp *Response) {
+		resp.Headers["X-Post"] += ",2"
+	})
+	addr := serve(t, srv)
+
+	c := dial(t, addr)
+	if err := c.SendRaw([]byte("GARBAGE\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.ReadResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != 400 || resp.Header("X-Post") != "1,2" {
+		t.Errorf("parse error: %d with X-Post %q, want 400 with 1,2", resp.Status, resp.Header("X-Post"))
+	}
+
+	resp = roundTrip(t, dial(t, addr), "GET", "/no/such/route", nil, nil)
+	if resp.Status != 404 || resp.Header("X-Post") != "1,2" {
+		t.Errorf("unknown route: %d with X-Post %q, want 404 with 1,2", resp.Status, resp.Header("X-Post"))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{" ", "GET /no/such/route"}; !slices.Equal(seen, want) {
+		t.Errorf("post-processor saw %q, want %q", seen, want)
+	}
+}
+
+// startServerWithWriteTimeout serves a bare server with the routes
+// register adds and the given WRITE_TIMEOUT, returning its address.
+// Unless sendBuffer is 0, it caps each connection's socket send buffer at
+// that many bytes.
+func startServerWithWriteTimeout(t *testing.T, timeout time.Duration, sendBuffer int, register func(*Router)) string {
+	t.Helper()
+	cfg := config.LoadConfig()
+	cfg.WriteTimeout = timeout
+	router := NewRouter()
+	register(router)
+	srv := NewServer(cfg, router)
+	if sendBuffer == 0 {
+		return serve(t, srv)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveListener(t, srv, sendBufferListener{listener, sendBuffer})
+	return listener.Addr().String()
+}
+
+// sendBufferListener caps the socket send buffer of the connections it
+// accepts.
+type sendBufferListener struct {
+	net.Listener
+	size int
+}
+
+func (l sendBufferListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		conn.(*net.TCPConn).SetWriteBuffer(l.size)
+	}
+	return conn, err
+}
+
+func TestServeWriteTimeoutStalledReader(t *testing.T) {
+	logAtLevel(t, "warn")
+	addr := startServerWithWriteTimeout(t, 200*time.Millisecond, 0, func(r *Router) {
+		r.Handle("/big", "GET", func(req *Request) Response {
+			return textResponse(strings.Repeat("x", 64<<20))
+		})
+	})
+	timeouts := Metrics.Counter("write_timeouts_total")
+	before := timeouts.Value()
+
+	// The client sends its request and then reads nothing, so the response
+	// fills the socket buffers and the server's write blocks.
+	start := time.Now()
+	logged := captureLog(t, func() {
+		conn, _ := sendHead(t, addr, "GET /big HTTP/1.1\r\nHost: x\r\n\r\n")
+		waitFor(t, "the write to time out", func() bool { return timeouts.Value() > before })
+		elapsed := time.Since(start)
+		if elapsed < 200*time.Millisecond {
+			t.Errorf("gave up after %v, before WRITE_TIMEOUT", elapsed)
+		}
+
+		// The connection was closed: draining it ends well short of the
+		// whole body.
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := io.Copy(io.Discard, conn)
+		if err != nil && !isPeerDisconnect(err) {
+			t.Errorf("reading after the timeout: %v, want the connection closed", err)
+		}
+		if n >= 64<<20 {
+			t.Errorf("read the whole %d byte response despite the timeout", n)
+		}
+	})
+	if !strings.Contains(logged, "[WARN]") || !strings.Contains(logged, "Write timeout sending") {
+		t.Errorf("log = %q, want a write timeout warning", logged)
+	}
+}
+
+func TestServeWriteTimeoutRenewedPerChunk(t *testing.T) {
+	const chunks, chunkSize = 128, 32 << 10
+	addr := startServerWithWriteTimeout(t, 500*time.Millisecond, 64<<10, func(r *Router) {
+		r.Handle("/stream", "GET", func(req *Request) Response {
+			return Response{
+				Version: HTTPVersion,
+				Status:  200,
+				Reason:  "OK",
+				Headers: map[string]string{"Content-Type": "application/octet-stream"},
+				StreamFunc: func(w io.Writer) error {
+					chunk := bytes.Repeat([]byte("y"), chunkSize)
+					for range chunks {
+						if _, err := w.Write(chunk); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+			}
+		})
+	})
+	timeouts := Metrics.Counter("write_timeouts_total")
+	before := timeouts.Value()
+
+	// The client keeps reading, slowly enough that the whole stream takes
+	// several times WRITE_TIMEOUT. The server's send buffer is capped:
+	// a blocked write only resumes once a third of the buffer has drained,
+	// and loopback buffers autotuned to megabytes would take longer than
+	// the timeout to drain at this pace.
+	start := time.Now()
+	_, reader := sendHead(t, addr, "GET /stream HTTP/1.1\r\nHost: x\r\n\r\n")
+	var raw bytes.Buffer
+	buf := make([]byte, 32<<10)
+	for {
+		time.Sleep(10 * time.Millisecond)
+		n, err := reader.Read(buf)
+		raw.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("slow but steady reader: %v after %d bytes", err, raw.Len())
+		}
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("stream took %v, want it to outlast WRITE_TIMEOUT", elapsed)
+	}
+	resp, err := testclient.ReadResponse(bufio.NewReader(&raw), "GET")
+	if err != nil {
+		t.Fatalf("decoding the response: %v", err)
+	}
+	if len(resp.Body) != chunks*chunkSize {
+		t.Errorf("received %d bytes, want all %d", len(resp.Body), chunks*chunkSize)
+	}
+	if timeouts.Value() != before {
+		t.Error("write_timeouts_total grew for a client that kept reading")
+	}
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// FieldsParam is the query parameter ShapeJSONMiddleware reads the field
+// selection from.
+const FieldsParam = "fields"
+
+// ErrUnknownField is returned by ShapeJSONStrict for a selected field the
+// document does not have.
+var ErrUnknownField = errors.New("unknown field")
+
+// fieldSet is a parsed field selection. A field mapped to nil is selected
+// whole; otherwise only its listed subfields are kept.
+type fieldSet map[string]fieldSet
+
+// ShapeJSON keeps only the selected fields of a JSON document. fields is
+// a comma-separated list of paths, with dots selecting nested fields and
+// brackets grouping several subfields of one field:
+//
+//	id,name,files.size          // same as id,name,files[size]
+//	owner[id,name],files[size]
+//
+// Arrays are filtered element by element, so "files.size" keeps the size
+// of every file. Selected fields the document does not have are dropped
+// silently; see ShapeJSONStrict.
+func ShapeJSON(body []byte, fields string) ([]byte, error) {
+	return shapeJSON(body, fields, false)
+}
+
+// ShapeJSONStrict is ShapeJSON, but a selected field missing from the
+// document, or a subfield selected on a value that is not an object, is
+// an error wrapping ErrUnknownField.
+func ShapeJSONStrict(body []byte, fields string) ([]byte, error) {
+	return shapeJSON(body, fields, true)
+}
+
+func shapeJSON(body []byte, fields string, strict bool) ([]byte, error) {
+	selection, err := parseFields(fields)
+	if err != nil {
+		return nil, err
+	}
+	return selection.shape(body, strict)
+}
+
+// shape filters the JSON document body by fs.
+func (fs fieldSet) shape(body []byte, strict bool) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var doc any
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON document: %w", err)
+	}
+	shaped, err := fs.apply(doc, "", strict)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(shaped)
+}
+
+// apply filters v, found at path, by fs.
+func (fs fieldSet) apply(v any, path string, strict bool) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(fs))
+		for name, sub := range fs {
+			value, ok := v[name]
+			if !ok {
+				if strict {
+					return nil, fmt.Errorf("%w: %s", ErrUnknownField, joinFieldPath(path, name))
+				}
+				continue
+			}
+			if sub != nil {
+				shaped, err := sub.apply(value, joinFieldPath(path, name), strict)
+				if err != nil {
+					return nil, err
+				}
+				value = shaped
+			}
+			out[name] = value
+		}
+		return out, nil
+	case []any:
+		out := make([]any, len(v))
+		for i, element := range v {
+			shaped, err := fs.apply(element, path+"["+strconv.Itoa(i)+"]", strict)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = shaped
+		}
+		return out, nil
+	default:
+		if strict {
+			return nil, fmt.Errorf("%w: %s is not an object", ErrUnknownField, path)
+		}
+		return v, nil
+	}
+}
+
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// parseFields parses a field selection expression.
+func parseFields(expr string) (fieldSet, error) {
+	p := fieldParser{expr: expr}
+	fs, err := p.list()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.expr) {
+		return nil, fmt.Errorf("unexpected %q at offset %d", p.expr[p.pos], p.pos)
+	}
+	return fs, nil
+}
+
+// fieldParser is a recursive descent parser for field selections:
+//
+//	list = item { "," item }
+//	item = name [ "." item | "[" list "]" ]
+type fieldParser struct {
+	expr string
+	pos  int
+}
+
+func (p *fieldParser) list() (fieldSet, error) {
+	fs := make(fieldSet)
+	for {
+		name, sub, err := p.item()
+		if err != nil {
+			return nil, err
+		}
+		fs.add(name, sub)
+		if p.pos >= len(p.expr) || p.expr[p.pos] != ',' {
+			return fs, nil
+		}
+		p.pos++
+	}
+}
+
+func (p *fieldParser) item() (string, fieldSet, error) {
+	start := p.pos
+	for p.pos < len(p.expr) && !strings.ContainsRune(".,[]", rune(p.expr[p.pos])) {
+		p.pos++
+	}
+	name := strings.TrimSpace(p.expr[start:p.pos])
+	if name == "" {
+		return "", nil, fmt.Errorf("empty field name at offset %d", start)
+	}
+	if p.pos >= len(p.expr) {
+		return name, nil, nil
+	}
+
+	switch p.expr[p.pos] {
+	case '.':
+		p.pos++
+		subName, subSub, err := p.item()
+		if err != nil {
+			return "", nil, err
+		}
+		sub := make(fieldSet)
+		sub.add(subName, subSub)
+		return name, sub, nil
+	case '[':
+		p.pos++
+		sub, err := p.list()
+		if err != nil {
+			return "", nil, err
+		}
+		if p.pos >= len(p.expr) || p.expr[p.pos] != ']' {
+			return "", nil, fmt.Errorf("missing ] for %q", name)
+		}
+		p.pos++
+		return name, sub, nil
+	}
+	return name, nil, nil
+}
+
+// add selects name with the subfields sub, merging with an earlier
+// selection of the same field. Selecting a field whole wins over selecting
+// some of its subfields.
+func (fs fieldSet) add(name string, sub fieldSet) {
+	existing, ok := fs[name]
+	switch {
+	case !ok:
+		fs[name] = sub
+	case existing == nil || sub == nil:
+		fs[name] = nil
+	default:
+		for subName, subSub := range sub {
+			existing.add(subName, subSub)
+		}
+	}
+}
+
+// ShapeJSONMiddleware shapes JSON responses by the "fields" query
+// parameter (see ShapeJSON), with strict selecting ShapeJSONStrict.
+// Requests without the parameter pass through untouched, as do streaming,
+// non-2xx, encoded and non-JSON responses. A malformed expression, or in
+// strict mode an unknown field, is answered with 400.
+func ShapeJSONMiddleware(strict bool) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) Response {
+			fields, ok, err := req.queryParam(FieldsParam)
+			if err != nil {
+				return BadRequestErrorResponse(err)
+			}
+			if !ok {
+				return next(req)
+			}
+			selection, err := parseFields(fields)
+			if err != nil {
+				return BadRequestErrorResponse(&ParamError{Source: "query", Name: FieldsParam, Value: fields, Err: ErrParamInvalid, Reason: err.Error()})
+			}
+
+			resp := next(req)
+			if !shapeable(&resp) {
+				return resp
+			}
+			body, err := selection.shape(resp.Body, strict)
+			if errors.Is(err, ErrUnknownField) {
+				return BadRequestErrorResponse(&ParamError{Source: "query", Name: FieldsParam, Value: fields, Err: ErrParamInvalid, Reason: err.Error()})
+			}
+			if err != nil {
+				utils.Warn("Not shaping response for %s: %v", req.Path, err)
+				return resp
+			}
+
+			resp.Body = body
+			if _, ok := resp.Headers["Content-Length"]; ok {
+				resp.Headers["Content-Length"] = strconv.Itoa(len(body))
+			}
+			delete(resp.Headers, "ETag")
+			return resp
+		}
+	}
+}
+
+// shapeable reports whether resp is a buffered, successful JSON response.
+func shapeable(resp *Response) bool {
+	if resp.StreamFunc != nil || len(resp.Body) == 0 || resp.Status < 200 || resp.Status > 299 {
+		return false
+	}
+	if resp.Headers["Content-Encoding"] != "" {
+		return false
+	}
+	mediaType, _, _ := strings.Cut(resp.Headers["Content-Type"], ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+)
+
+const shapeDoc = `{
+	"id": 7,
+	"name": "report",
+	"owner": {"id": 1, "name": "ada", "email": "ada@example.com"},
+	"files": [
+		{"name": "a.txt", "size": 10, "meta": {"type": "text"}},
+		{"name": "b.bin", "size": 20, "meta": {"type": "binary"}}
+	],
+	"tags": ["x", "y"]
+}`
+
+func TestShapeJSON(t *testing.T) {
+	tests := []struct {
+		fields string
+		want   string
+	}{
+		{"id,name", `{"id":7,"name":"report"}`},
+		{"owner.name", `{"owner":{"name":"ada"}}`},
+		{"owner[id,name]", `{"owner":{"id":1,"name":"ada"}}`},
+		{"files.size", `{"files":[{"size":10},{"size":20}]}`},
+		{"files[name,meta.type]", `{"files":[{"meta":{"type":"text"},"name":"a.txt"},{"meta":{"type":"binary"},"name":"b.bin"}]}`},
+		// Two selections of one field are merged.
+		{"owner.id,owner.name", `{"owner":{"id":1,"name":"ada"}}`},
+		// Selecting the field whole wins, in either order.
+		{"owner.id,owner", `{"owner":{"email":"ada@example.com","id":1,"name":"ada"}}`},
+		{"owner,owner.id", `{"owner":{"email":"ada@example.com","id":1,"name":"ada"}}`},
+		{" id , name ", `{"id":7,"name":"report"}`},
+		// Unknown fields are dropped, at any depth.
+		{"id,missing", `{"id":7}`},
+		{"owner.missing", `{"owner":{}}`},
+		{"files.missing", `{"files":[{},{}]}`},
+		// A subfield of a scalar keeps the scalar.
+		{"id.sub", `{"id":7}`},
+		{"tags.sub", `{"tags":["x","y"]}`},
+	}
+	for _, tt := range tests {
+		got, err := ShapeJSON([]byte(shapeDoc), tt.fields)
+		if err != nil {
+			t.Errorf("ShapeJSON(%q): %v", tt.fields, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("ShapeJSON(%q) = %s, want %s", tt.fields, got, tt.want)
+		}
+	}
+}
+
+func TestShapeJSONTopLevelArray(t *testing.T) {
+	got, err := ShapeJSON([]byte(`[{"id":1,"x":2},{"id":3,"x":4}]`), "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"id":1},{"id":3}]`; string(got) != want {
+		t.Errorf("ShapeJSON = %s, want %s", got, want)
+	}
+}
+
+func TestShapeJSONKeepsNumbers(t *testing.T) {
+	// Large integers must not lose precision through float64.
+	got, err := ShapeJSON([]byte(`{"id":9007199254740993,"x":1}`), "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"id":9007199254740993}`; string(got) != want {
+		t.Errorf("ShapeJSON = %s, want %s", got, want)
+	}
+}
+
+func TestShapeJSONStrict(t *testing.T) {
+	got, err := ShapeJSONStrict([]byte(shapeDoc), "owner[id],files.size")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"files":[{"size":10},{"size":20}],"owner":{"id":1}}`; string(got) != want {
+		t.Errorf("ShapeJSONStrict = %s, want %s", got, want)
+	}
+
+	for _, tt := range []struct {
+		fields, path string
+	}{
+		{"id,missing", "missing"},
+		{"owner.missing", "owner.missing"},
+		{"files.missing", "files[0].missing"},
+		{"id.sub", "id is not an object"},
+		{"tags.sub", "tags[0] is not an object"},
+	} {
+		_, err := ShapeJSONStrict([]byte(shapeDoc), tt.fields)
+		if !errors.Is(err, ErrUnknownField) {
+			t.Errorf("ShapeJSONStrict(%q) = %v, want ErrUnknownField", tt.fields, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.path) {
+			t.Errorf("ShapeJSONStrict(%q) error %q does not mention %q", tt.fields, err, tt.path)
+		}
+	}
+}
+
+func TestShapeJSONMalformed(t *testing.T) {
+	for _, fields := range []string{
+		"",
+		",",
+		"id,",
+		"id,,name",
+		"owner.",
+		".id",
+		"owner[id",
+		"owner[]",
+		"owner[id]]",
+		"id]",
+		"owner[id]name",
+	} {
+		if _, err := ShapeJSON([]byte(shapeDoc), fields); err == nil || errors.Is(err, ErrUnknownField) {
+			t.Errorf("ShapeJSON(%q) = %v, want a syntax error", fields, err)
+		}
+	}
+
+	if _, err := ShapeJSON([]byte(`{"id":`), "id"); err == nil {
+		t.Error("ShapeJSON accepted an invalid document")
+	}
+}
+
+// shapeRouter serves shapeDoc at /doc and non-JSON, streamed and error
+// responses next to it, shaped by ShapeJSONMiddleware.
+func shapeRouter(strict bool) *Router {
+	r := NewRouter()
+	r.Use(ShapeJSONMiddleware(strict))
+	r.Handle("/doc", "GET", func(req *Request) Response {
+		resp := JSONResponse(200, map[string]any{"id": 7, "name": "report", "owner": map[string]any{"id": 1}})
+		resp.Headers["Content-Length"] = "999"
+		resp.Headers["ETag"] = `"v1"`
+		return resp
+	})
+	r.Handle("/text", "GET", func(req *Request) Response {
+		return textResponse(`{"id":7,"name":"report"}`)
+	})
+	r.Handle("/stream", "GET", func(req *Request) Response {
+		return Response{
+			Version:    HTTPVersion,
+			Status:     200,
+			Reason:     "OK",
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			StreamFunc: func(w io.Writer) error { _, err := io.WriteString(w, `{"id":7}`); return err },
+		}
+	})
+	r.Handle("/missing", "GET", func(req *Request) Response {
+		return JSONResponse(404, map[string]any{"error": "not found", "id": 7})
+	})
+	return r
+}
+
+func TestShapeJSONMiddleware(t *testing.T) {
+	r := shapeRouter(false)
+
+	resp := routeGET(r, "/doc?fields=id,owner.id")
+	if resp.Status != 200 {
+		t.Fatalf("status = %d, want 200", resp.Status)
+	}
+	if want := `{"id":7,"owner":{"id":1}}`; string(resp.Body) != want {
+		t.Errorf("body = %s, want %s", resp.Body, want)
+	}
+	if got := resp.Headers["Content-Length"]; got != "25" {
+		t.Errorf("Content-Length = %q, want the shaped length 25", got)
+	}
+	if etag, ok := resp.Headers["ETag"]; ok {
+		t.Errorf("ETag %q kept on a shaped body", etag)
+	}
+
+	// Without the parameter nothing changes.
+	resp = routeGET(r, "/doc")
+	if !strings.Contains(string(resp.Body), `"name"`) || resp.Headers["ETag"] != `"v1"` {
+		t.Errorf("unshaped response changed: %s %v", resp.Body, resp.Headers)
+	}
+
+	// Unknown fields are dropped in lenient mode.
+	if resp := routeGET(r, "/doc?fields=id,nope"); resp.Status != 200 || string(resp.Body) != `{"id":7}` {
+		t.Errorf("lenient unknown field: %d %s, want 200 {\"id\":7}", resp.Status, resp.Body)
+	}
+
+	// Responses that are not buffered successful JSON pass through.
+	if resp := routeGET(r, "/text?fields=id"); !strings.Contains(string(resp.Body), "report") {
+		t.Errorf("text response was shaped: %s", resp.Body)
+	}
+	if resp := routeGET(r, "/stream?fields=name"); resp.StreamFunc == nil {
+		t.Error("streamed response lost its StreamFunc")
+	}
+	if resp := routeGET(r, "/missing?fields=id"); !strings.Contains(string(resp.Body), "not found") {
+		t.Errorf("404 response was shaped: %s", resp.Body)
+	}
+}
+
+func TestShapeJSONMiddlewareErrors(t *testing.T) {
+	called := false
+	r := NewRouter()
+	r.Use(ShapeJSONMiddleware(true))
+	r.Handle("/doc", "GET", func(req *Request) Response {
+		called = true
+		return JSONResponse(200, map[string]any{"id": 7})
+	})
+
+	// A malformed expression is refused before the handler runs.
+	resp := routeGET(r, "/doc?fields=id,")
+	if resp.Status != 400 || called {
+		t.Errorf("malformed fields: status %d, handler called %v; want 400 without the handler", resp.Status, called)
+	}
+	if !strings.Contains(string(resp.Body), FieldsParam) {
+		t.Errorf("400 body %s does not name the parameter", resp.Body)
+	}
+
+	// In strict mode an unknown field is refused too.
+	resp = routeGET(r, "/doc?fields=id,nope")
+	if resp.Status != 400 || !strings.Contains(string(resp.Body), "nope") {
+		t.Errorf("strict unknown field: %d %s, want 400 naming it", resp.Status, resp.Body)
+	}
+	if resp := routeGET(r, "/doc?fields=id"); resp.Status != 200 || string(resp.Body) != `{"id":7}` {
+		t.Errorf("strict known field: %d %s", resp.Status, resp.Body)
+	}
+}
+
+func TestJSONFieldsConfig(t *testing.T) {
+	t.Setenv("JSON_FIELDS", "sometimes")
+	if _, err := NewServerFromConfig(config.LoadConfig()); err == nil || !strings.Contains(err.Error(), "JSON_FIELDS") {
+		t.Errorf("NewServerFromConfig = %v, want a JSON_FIELDS error", err)
+	}
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+	"github.com/Abb133Se/httpServer/internal/version"
+)
+
+// Shutdown triggers recorded in the ShutdownReport.
+const (
+	TriggerSignal  = "signal"
+	TriggerAdmin   = "admin"
+	TriggerContext = "context done"
+	TriggerFatal   = "fatal error"
+)
+
+// serverStats are the counters behind the shutdown report.
+type serverStats struct {
+	requests        atomic.Int64
+	statusClasses   [6]atomic.Int64 // index 1..5 for 1xx..5xx, 0 for anything else
+	openConns       atomic.Int64
+	peakConns       atomic.Int64
+	forceClosed     atomic.Int64
+	abandonedTasks  atomic.Int64
+	shutdownStarted atomic.Bool
+}
+
+// countResponse records a response sent with status.
+func (s *Server) countResponse(status int) {
+	s.stats.requests.Add(1)
+	class := status / 100
+	if class < 1 || class > 5 {
+		class = 0
+	}
+	s.stats.statusClasses[class].Add(1)
+}
+
+// ShutdownReport summarizes a server's life. Fields describing subsystems
+// that never started are simply zero.
+type ShutdownReport struct {
+	Trigger                string           `json:"trigger"`
+	Error                  string           `json:"error,omitempty"`
+	StartedAt              time.Time        `json:"started_at"`
+	StoppedAt              time.Time        `json:"stopped_at"`
+	Uptime                 string           `json:"uptime"`
+	Version                string           `json:"version"`
+	Requests               int64            `json:"requests"`
+	StatusClasses          map[string]int64 `json:"status_classes"`
+	PeakConnections        int64            `json:"peak_connections"`
+	OpenConnections        int64            `json:"open_connections"`
+	ForceClosedConnections int64            `json:"force_closed_connections"`
+	AbandonedTasks         int64            `json:"abandoned_tasks"`
+}
+
+// Report builds a ShutdownReport from the server's counters. err, if not
+// nil, is the failure that ended the server.
+func (s *Server) Report(trigger string, err error) ShutdownReport {
+	now := s.clock.Now()
+	report := ShutdownReport{
+		Trigger:                trigger,
+		StartedAt:              s.startedAt,
+		StoppedAt:              now,
+		Uptime:                 now.Sub(s.startedAt).Round(time.Millisecond).String(),
+		Version:                version.Get().String(),
+		Requests:               s.stats.requests.Load(),
+		StatusClasses:          make(map[string]int64),
+		PeakConnections:        s.stats.peakConns.Load(),
+		OpenConnections:        s.stats.openConns.Load(),
+		ForceClosedConnections: s.stats.forceClosed.Load(),
+		AbandonedTasks:         s.stats.abandonedTasks.Load(),
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	for class := 1; class <= 5; class++ {
+		report.StatusClasses[fmt.Sprintf("%dxx", class)] = s.stats.statusClasses[class].Load()
+	}
+	if other := s.stats.statusClasses[0].Load(); other > 0 {
+		report.StatusClasses["other"] = other
+	}
+	return report
+}
+
+// LogReport logs report at Info level and, if SHUTDOWN_REPORT_FILE is
+// set, writes it there as JSON.
+func (s *Server) LogReport(report ShutdownReport) {
+	utils.Info("Shutdown report: trigger=%q uptime=%s version=%s requests=%d 1xx=%d 2xx=%d 3xx=%d 4xx=%d 5xx=%d peak_connections=%d force_closed=%d abandoned_tasks=%d error=%q",
+		report.Trigger, report.Uptime, report.Version, report.Requests,
+		report.StatusClasses["1xx"], report.StatusClasses["2xx"], report.StatusClasses["3xx"],
+		report.StatusClasses["4xx"], report.StatusClasses["5xx"],
+		report.PeakConnections, report.ForceClosedConnections, report.AbandonedTasks, report.Error)
+
+	if s.config == nil || s.config.ShutdownReportFile == "" {
+		return
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err == nil {
+		err = os.WriteFile(s.config.ShutdownReportFile, data, 0644)
+	}
+	if err != nil {
+		utils.Error("Failed to write shutdown report to %s: %v", s.config.ShutdownReportFile, err)
+	}
+}
+
+// Shutdown stops the server gracefully: it stops accepting connections,
+// lets open connections finish their current request, then cancels and
+// waits for background tasks. Connections still open when ctx is done are
+// closed forcibly. The shutdown report is logged and returned; trigger
+// names what asked for the shutdown (see TriggerSignal). Only the first
+// call does the work; later ones return the report of a shutdown still in
+// progress as it stands.
+func (s *Server) Shutdown(ctx context.Context, trigger string) ShutdownReport {
+	if !s.stats.shutdownStarted.CompareAndSwap(false, true) {
+		return s.Report(trigger, nil)
+	}
+	utils.Info("Shutting down (%s)", trigger)
+	s.BeginDrain()
+
+	s.connsMu.Lock()
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	s.connsMu.Unlock()
+	if s.broker != nil {
+		s.broker.Close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.connWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.connsMu.Lock()
+		for conn := range s.openConns {
+			conn.Close()
+			s.stats.forceClosed.Add(1)
+		}
+		s.connsMu.Unlock()
+		utils.Warn("Force-closed %d connections at the drain deadline", s.stats.forceClosed.Load())
+	}
+
+	if err := s.StopTasks(ctx); err != nil {
+		s.stats.abandonedTasks.Store(s.ActiveTasks())
+	}
+	s.proxyClient.CloseIdleConnections()
+	s.setState(StateStopped)
+
+	report := s.Report(trigger, nil)
+	s.LogReport(report)
+	close(s.shutdownDone)
+	return report
+}
+
+// shutdownOnSignal shuts the server down on SIGINT or SIGTERM, allowing
+// open connections up to the drain timeout.
+func (s *Server) shutdownOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	signal.Stop(signals)
+	utils.Info("Received %v", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.DrainTimeout)
+	defer cancel()
+	s.Shutdown(ctx, TriggerSignal)
+}
+
+// shutdownOnDone shuts the server down once ctx is done, allowing open
+// connections up to the drain timeout. It returns early if the server
+// shuts down for another reason.
+func (s *Server) shutdownOnDone(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-s.shutdownDone:
+		return
+	}
+	drainCtx, cancel := context.WithTimeout(context.Background(), s.config.DrainTimeout)
+	defer cancel()
+	s.Shutdown(drainCtx, TriggerContext)
+}
+
+// handleAdminShutdown handles "/admin/shutdown" by starting a graceful
+// shutdown after the response has been sent.
+func (s *Server) handleAdminShutdown(req *Request) Response {
+	// Drain before answering, so this very connection is closed after the
+	// response instead of being held open until the drain deadline.
+	s.BeginDrain()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.DrainTimeout)
+		defer cancel()
+		s.Shutdown(ctx, TriggerAdmin)
+	}()
+	return Response{
+		Version: HTTPVersion,
+		Status:  202,
+		Reason:  "Accepted",
+		Headers: map[string]string{"Content-Type": "text/plain", "Connection": "close"},
+		Body:    []byte("Shutting down"),
+	}
+}
+
+// trackListener records l so Shutdown can close it.
+func (s *Server) trackListener(l net.Listener) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	s.listeners = append(s.listeners, l)
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+	"github.com/Abb133Se/httpServer/internal/testclient"
+)
+
+// blockingHandler returns a handler that signals on entered and then waits
+// for release to be closed before answering with body.
+func blockingHandler(body string) (h HandlerFunc, entered chan struct{}, release chan struct{}) {
+	entered, release = make(chan struct{}, 16), make(chan struct{})
+	h = func(*Request) Response {
+		entered <- struct{}{}
+		<-release
+		return textResponse(body)
+	}
+	return h, entered, release
+}
+
+func TestShutdownDrainsKeepAliveConnections(t *testing.T) {
+	t.Setenv("DRAIN_TIMEOUT", "7")
+	var entered, release chan struct{}
+	srv, addr := startServerWithRoutes(t, func(r *Router) {
+		var slow HandlerFunc
+		slow, entered, release = blockingHandler("slow")
+		r.Handle("/slow", "GET", slow)
+		r.Handle("/fast", "GET", func(*Request) Response { return textResponse("fast") })
+	})
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+
+	busy, idle := dial(t, addr), dial(t, addr)
+	for _, c := range []*testclient.Client{busy, idle} {
+		if resp := roundTrip(t, c, "GET", "/fast", keepAlive, nil); resp.Header("Connection") != "keep-alive" {
+			t.Fatalf("before shutdown: Connection = %q, want keep-alive", resp.Header("Connection"))
+		}
+	}
+	if err := busy.SendRequest("GET", "/slow", keepAlive, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-entered
+
+	reported := make(chan ShutdownReport)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		reported <- srv.Shutdown(ctx, TriggerAdmin)
+	}()
+	waitFor(t, "draining", srv.IsDraining)
+
+	// A request arriving on an idle keep-alive connection is turned away.
+	resp := roundTrip(t, idle, "GET", "/fast", keepAlive, nil)
+	if resp.Status != 503 || resp.Header("Retry-After") != "7" || resp.Header("Connection") != "close" {
+		t.Errorf("request during the drain = %d, Retry-After %q, Connection %q; want 503, 7, close",
+			resp.Status, resp.Header("Retry-After"), resp.Header("Connection"))
+	}
+	if err := idle.ExpectClose(); err != nil {
+		t.Error(err)
+	}
+
+	// The request in progress completes, and its connection then closes.
+	close(release)
+	resp, err := busy.ReadResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != 200 || string(resp.Body) != "slow" || resp.Header("Connection") != "close" {
+		t.Errorf("request in progress = %d %q, Connection %q; want 200 slow, close", resp.Status, resp.Body, resp.Header("Connection"))
+	}
+	if err := busy.ExpectClose(); err != nil {
+		t.Error(err)
+	}
+
+	report := <-reported
+	if report.ForceClosedConnections != 0 {
+		t.Errorf("%d connections force-closed, want every one drained", report.ForceClosedConnections)
+	}
+}
+
+func TestShutdownReport(t *testing.T) {
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+	t.Setenv("SHUTDOWN_REPORT_FILE", reportFile)
+	srv, addr := startServerWithRoutes(t, func(r *Router) {
+		r.Handle("/ok", "GET", func(*Request) Response { return textResponse("ok") })
+		r.Handle("/moved", "GET", func(*Request) Response {
+			return Response{Version: HTTPVersion, Status: 301, Reason: "Moved Permanently", Headers: map[string]string{"Location": "/ok"}}
+		})
+		r.Handle("/boom", "GET", func(*Request) Response { return InternalServerErrorResponse() })
+	})
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+
+	// Three connections open at once, then requests of every class.
+	a, b, c := dial(t, addr), dial(t, addr), dial(t, addr)
+	for _, tt := range []struct {
+		client *testclient.Client
+		path   string
+	}{
+		{a, "/ok"}, {b, "/ok"}, {c, "/ok"}, {a, "/moved"}, {b, "/missing"}, {c, "/boom"},
+	} {
+		roundTrip(t, tt.client, "GET", tt.path, keepAlive, nil)
+	}
+	for _, client := range []*testclient.Client{a, b, c} {
+		client.Close()
+	}
+	waitFor(t, "connections to close", func() bool { return srv.Report("", nil).OpenConnections == 0 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	report := srv.Shutdown(ctx, TriggerAdmin)
+
+	if report.Trigger != TriggerAdmin || report.Error != "" {
+		t.Errorf("Trigger %q, Error %q; want %q and none", report.Trigger, report.Error, TriggerAdmin)
+	}
+	if report.Requests != 6 {
+		t.Errorf("Requests = %d, want 6", report.Requests)
+	}
+	want := map[string]int64{"1xx": 0, "2xx": 3, "3xx": 1, "4xx": 1, "5xx": 1}
+	for class, n := range want {
+		if report.StatusClasses[class] != n {
+			t.Errorf("StatusClasses = %v, want %v", report.StatusClasses, want)
+			break
+		}
+	}
+	var total int64
+	for _, n := range report.StatusClasses {
+		total += n
+	}
+	if total != report.Requests {
+		t.Errorf("status classes add up to %d, want the %d requests", total, report.Requests)
+	}
+	if report.PeakConnections != 3 || report.OpenConnections != 0 || report.ForceClosedConnections != 0 || report.AbandonedTasks != 0 {
+		t.Errorf("connections: peak %d, open %d, force-closed %d, abandoned tasks %d; want 3, 0, 0, 0",
+			report.PeakConnections, report.OpenConnections, report.ForceClosedConnections, report.AbandonedTasks)
+	}
+	if report.StartedAt.IsZero() || report.StoppedAt.Before(report.StartedAt) || report.Uptime == "" || report.Version == "" {
+		t.Errorf("report %+v, want start and stop times, uptime and version", report)
+	}
+
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written ShutdownReport
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatalf("report file %s: %v", data, err)
+	}
+	if written.Requests != report.Requests || written.Trigger != report.Trigger || written.StatusClasses["4xx"] != 1 {
+		t.Errorf("report file %s, want the returned report", data)
+	}
+}
+
+func TestReportBeforeServing(t *testing.T) {
+	srv := NewServer(config.LoadConfig(), NewRouter())
+	report := srv.Report(TriggerFatal, errors.New("listen failed"))
+	if report.Trigger != TriggerFatal || report.Error != "listen failed" || report.Requests != 0 || report.PeakConnections != 0 {
+		t.Errorf("report of a server that never served = %+v", report)
+	}
+	if len(report.StatusClasses) != 5 {
+		t.Errorf("StatusClasses = %v, want every class present and zero", report.StatusClasses)
+	}
+	srv.LogReport(report) // no report file configured: only logged
+}
+
+func TestStartServerContextDrains(t *testing.T) {
+	public := chdirPublic(t)
+	t.Setenv("DRAIN_TIMEOUT", "5")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	returned := make(chan error, 1)
+	go func() { returned <- StartServerContext(ctx, addr, loadConfig()) }()
+	accepting := func() bool {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}
+	waitFor(t, "the server to listen", accepting)
+
+	// An upload whose head the server has read, as the 100 Continue
+	// shows, is in flight when the context ends.
+	conn, reader := sendHead(t, addr,
+		"PUT /files/upload.txt HTTP/1.1\r\nHost: x\r\nConnection: keep-alive\r\nContent-Length: 10\r\nExpect: 100-continue\r\n\r\n")
+	if interim, err := testclient.ReadResponse(reader, "PUT"); err != nil || interim.Status != 100 {
+		t.Fatalf("interim response = %v, %v; want 100 Continue", interim, err)
+	}
+	cancel()
+	waitFor(t, "the listener to close", func() bool { return !accepting() })
+	select {
+	case err := <-returned:
+		t.Fatalf("StartServerContext returned %v with an upload in flight", err)
+	default:
+	}
+
+	if _, err := conn.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := testclient.ReadResponse(reader, "PUT")
+	if err != nil {
+		t.Fatalf("in-flight upload: %v", err)
+	}
+	if resp.Status != 200 || resp.Header("Connection") != "close" {
+		t.Errorf("in-flight upload = %d, Connection %q; want 200, close", resp.Status, resp.Header("Connection"))
+	}
+	expectClosed(t, reader)
+	if data, err := os.ReadFile(filepath.Join(public, "upload.txt")); err != nil || string(data) != "0123456789" {
+		t.Errorf("uploaded file = %q, %v; want the whole body", data, err)
+	}
+
+	select {
+	case err := <-returned:
+		if err != nil {
+			t.Errorf("StartServerContext = %v, want nil after a graceful shutdown", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("StartServerContext did not return after the drain")
+	}
+}
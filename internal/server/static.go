@@ -0,0 +1,218 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// ServeDirOptions configures a directory served with ServeDir.
+type ServeDirOptions struct {
+	// Listing answers requests for a directory without an index file
+	// with a listing of its entries, instead of 404. See dirListing for
+	// the query parameters it takes.
+	Listing bool
+
+	// ListingTemplate, if set, renders HTML listings instead of
+	// DefaultListingTemplate. It is executed with a *DirListing.
+	ListingTemplate *template.Template
+
+	// ShowHidden includes dotfiles in listings.
+	ShowHidden bool
+
+	// DirsFirst lists directories before files, whatever the sort order.
+	DirsFirst bool
+
+	// Index is the file served for a directory, if present.
+	Index string
+
+	// MaxAge, if positive, is sent as "Cache-Control: public, max-age".
+	MaxAge time.Duration
+
+	// Attachment makes browsers download files rather than display them.
+	Attachment bool
+
+	// Writable accepts PUT and POST to create or replace files and DELETE
+	// to remove them. Mounts are read-only otherwise.
+	Writable bool
+}
+
+// ServeDir returns a handler serving the files under dir at prefix, so
+// that "{prefix}/css/site.css" serves dir/css/site.css. Register it for
+// both prefix and prefix + "/" (see RegisterStaticMounts). File names are
+// checked like those of /files/, and GET honors Range requests.
+func ServeDir(prefix, dir string, opts ServeDirOptions) HandlerFunc {
+	prefix = strings.TrimSuffix(prefix, "/")
+	allow := "GET, HEAD, OPTIONS"
+	if opts.Writable {
+		allow = "GET, HEAD, POST, PUT, DELETE, OPTIONS"
+	}
+
+	return func(req *Request) Response {
+		rel, err := decodeFilePath(strings.Trim(strings.TrimPrefix(req.Path, prefix), "/"))
+		if err != nil {
+			return BadRequestErrorResponse(err)
+		}
+		filePath := dir
+		if isTraversal(rel) {
+			Metrics.Counter("files_traversal_rejected_total").Inc()
+			utils.Warn("Rejected path traversal: %s %q", req.Method, rel)
+			return ForbiddenResponse()
+		}
+		if rel != "" {
+			clean, ok := cleanRelativePath(rel)
+			if !ok {
+				utils.Warn("Rejected unsafe file name: %s %q", req.Method, rel)
+				return BadRequestErrorResponse(fmt.Errorf("invalid file name %q", rel))
+			}
+			if isReservedFile(filepath.Base(clean)) {
+				return NotFoundResponse()
+			}
+			filePath = filepath.Join(dir, clean)
+		}
+
+		switch req.Method {
+		case "GET", "HEAD":
+			return serveDirEntry(req, prefix, rel, dir, filePath, opts)
+		case "POST", "PUT":
+			if !opts.Writable || rel == "" {
+				return MethodNotAllowedResponse(allow)
+			}
+			result, err := CopyBodyToFile(req, filePath, CopyOptions{})
+			if err != nil {
+				utils.Error("Failed to write file: %s, error: %v", filePath, err)
+				var httpErr *HTTPError
+				if errors.As(err, &httpErr) {
+					return httpErr.Response()
+				}
+				return InternalServerErrorResponse()
+			}
+			utils.Info("File %s successfully written (%d bytes)", filePath, result.Bytes)
+			if req.Method == "POST" {
+				headers := map[string]string{}
+				if location, err := SafeLocation(prefix+"/", rel); err == nil {
+					headers["Location"] = location
+				}
+				return Response{Version: HTTPVersion, Status: 201, Reason: "Created", Headers: headers}
+			}
+			return Response{Version: HTTPVersion, Status: 204, Reason: "No Content", Headers: map[string]string{}}
+		case "DELETE":
+			if !opts.Writable || rel == "" {
+				return MethodNotAllowedResponse(allow)
+			}
+			if err := os.Remove(filePath); err != nil {
+				utils.Warn("Failed to delete file: %s, error: %v", filePath, err)
+				return NotFoundResponse()
+			}
+			return Response{Version: HTTPVersion, Status: 204, Reason: "No Content", Headers: map[string]string{}}
+		case "OPTIONS":
+			return OptionsResponse(allow)
+		default:
+			return MethodNotAllowedResponse(allow)
+		}
+	}
+}
+
+// serveDirEntry answers GET and HEAD for filePath, found at rel under the
+// mount at prefix.
+func serveDirEntry(req *Request, prefix, rel, dir, filePath string, opts ServeDirOptions) Response {
+	info, err := os.Stat(filePath)
+	if err != nil || !withinRoot(dir, filePath) {
+		return NotFoundResponse()
+	}
+	if info.IsDir() {
+		if opts.Index != "" {
+			indexPath := filepath.Join(filePath, opts.Index)
+			if indexInfo, err := os.Stat(indexPath); err == nil && indexInfo.Mode().IsRegular() && withinRoot(dir, indexPath) {
+				filePath, info = indexPath, indexInfo
+			}
+		}
+		if info.IsDir() {
+			if !opts.Listing {
+				return NotFoundResponse()
+			}
+			return dirListing(req, prefix, rel, dir, filePath, opts)
+		}
+	}
+
+	var resp Response
+	if req.Method == "HEAD" {
+		resp = Response{Version: HTTPVersion, Status: 200, Reason: "OK", Headers: fileHeaders(filePath, info), ContentLength: info.Size()}
+	} else {
+		var data []byte
+		if info.Size() <= coalesceMaxBytes {
+			data, err = os.ReadFile(filePath)
+			if err != nil {
+				utils.Warn("Failed to read file: %s, error: %v", filePath, err)
+				return NotFoundResponse()
+			}
+		}
+		resp = fileResponse(req, filePath, info, data)
+	}
+	if opts.MaxAge > 0 {
+		resp.Headers["Cache-Control"] = "public, max-age=" + strconv.FormatInt(int64(opts.MaxAge/time.Second), 10)
+	}
+	if opts.Attachment {
+		resp.Headers["Content-Disposition"] = attachmentDisposition(filepath.Base(filePath))
+	}
+	return resp
+}
+
+// RegisterStaticMounts validates the STATIC_MOUNTS entries and registers
+// a ServeDir handler for each. Every directory must exist, and no prefix
+// may equal or contain another, since which mount served a path would
+// then depend on registration order. Mounts should be registered before
+// other routes so they take precedence.
+func RegisterStaticMounts(router *Router, mounts []config.StaticMountConfig) error {
+	prefixes := make([]string, len(mounts))
+	for i, mount := range mounts {
+		prefix := "/" + strings.Trim(mount.Prefix, "/")
+		if prefix == "/" || !strings.HasPrefix(mount.Prefix, "/") {
+			return fmt.Errorf("static mount %s -> %s: prefix must start with / and name a path", mount.Prefix, mount.Dir)
+		}
+		info, err := os.Stat(mount.Dir)
+		if err != nil {
+			return fmt.Errorf("static mount %s -> %s: %w", mount.Prefix, mount.Dir, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("static mount %s -> %s: not a directory", mount.Prefix, mount.Dir)
+		}
+		for j := range i {
+			if prefix == prefixes[j] || strings.HasPrefix(prefix+"/", prefixes[j]+"/") || strings.HasPrefix(prefixes[j]+"/", prefix+"/") {
+				return fmt.Errorf("static mounts %s -> %s and %s -> %s collide", mounts[j].Prefix, mounts[j].Dir, mount.Prefix, mount.Dir)
+			}
+		}
+		prefixes[i] = prefix
+	}
+
+	for i, mount := range mounts {
+		dir, err := filepath.Abs(mount.Dir)
+		if err != nil {
+			return fmt.Errorf("static mount %s -> %s: %w", mount.Prefix, mount.Dir, err)
+		}
+		handler := ServeDir(prefixes[i], dir, ServeDirOptions{
+			Listing:    mount.Listing,
+			ShowHidden: mount.ShowHidden,
+			Index:      mount.Index,
+			MaxAge:     mount.MaxAge,
+			Attachment: mount.Attachment,
+			Writable:   mount.Writable,
+		})
+		router.Handle(prefixes[i], "", handler)
+		router.HandlePrefix(prefixes[i]+"/", "GET", handler).Doc(RouteDoc{Summary: "Static files from " + mount.Dir})
+		route := router.HandlePrefix(prefixes[i]+"/", "", handler)
+		if mount.Writable {
+			route.StreamBody()
+		}
+		utils.Info("Static mount %s -> %s", prefixes[i], dir)
+	}
+	return nil
+}
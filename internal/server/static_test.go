@@ -0,0 +1,115 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+)
+
+func TestRegisterStaticMountsValidation(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	for _, d := range []string{a, b} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(dir, "file")
+	writeTestFile(t, file, "x")
+
+	tests := []struct {
+		name   string
+		mounts []config.StaticMountConfig
+		want   []string // substrings of the error, none for success
+	}{
+		{"distinct", []config.StaticMountConfig{{Prefix: "/a", Dir: a}, {Prefix: "/ab", Dir: b}}, nil},
+		{"trailing slash", []config.StaticMountConfig{{Prefix: "/a/", Dir: a}}, nil},
+		{"root prefix", []config.StaticMountConfig{{Prefix: "/", Dir: a}}, []string{"prefix must start with /"}},
+		{"relative prefix", []config.StaticMountConfig{{Prefix: "assets", Dir: a}}, []string{"prefix must start with /"}},
+		{"missing directory", []config.StaticMountConfig{{Prefix: "/a", Dir: filepath.Join(dir, "none")}}, []string{"/a", "none"}},
+		{"file", []config.StaticMountConfig{{Prefix: "/a", Dir: file}}, []string{"not a directory"}},
+		{"same prefix", []config.StaticMountConfig{{Prefix: "/static", Dir: a}, {Prefix: "/static/", Dir: b}},
+			[]string{"/static -> " + a, "/static/ -> " + b, "collide"}},
+		{"nested", []config.StaticMountConfig{{Prefix: "/static", Dir: a}, {Prefix: "/static/img", Dir: b}},
+			[]string{"/static -> " + a, "/static/img -> " + b}},
+		{"nested the other way", []config.StaticMountConfig{{Prefix: "/static/img", Dir: a}, {Prefix: "/static", Dir: b}},
+			[]string{"/static/img -> " + a, "/static -> " + b}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRouter()
+			err := RegisterStaticMounts(r, tt.mounts)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("RegisterStaticMounts: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("RegisterStaticMounts succeeded, want an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+			if len(r.routes) != 0 {
+				t.Errorf("%d routes registered despite the error", len(r.routes))
+			}
+		})
+	}
+}
+
+func TestServeStaticMounts(t *testing.T) {
+	dir := t.TempDir()
+	assets, downloads := filepath.Join(dir, "assets"), filepath.Join(dir, "downloads")
+	for _, d := range []string{assets, downloads} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The same name in both, so a response shows which mount served it.
+	writeTestFile(t, filepath.Join(assets, "app.js"), "from assets")
+	writeTestFile(t, filepath.Join(downloads, "app.js"), "from downloads")
+	t.Setenv("STATIC_MOUNTS", "/assets="+assets+";max-age=1y,/downloads="+downloads+";listing;attachment;max-age=60")
+	_, addr := startServer(t)
+
+	for _, tt := range []struct {
+		path, body, cache, disposition string
+	}{
+		{"/assets/app.js", "from assets", "public, max-age=31536000", ""},
+		{"/downloads/app.js", "from downloads", "public, max-age=60", "attachment"},
+	} {
+		resp := roundTrip(t, dial(t, addr), "GET", tt.path, nil, nil)
+		if resp.Status != 200 || string(resp.Body) != tt.body {
+			t.Errorf("GET %s = %d %q, want 200 %q", tt.path, resp.Status, resp.Body, tt.body)
+		}
+		if got := resp.Header("Cache-Control"); got != tt.cache {
+			t.Errorf("GET %s: Cache-Control = %q, want %q", tt.path, got, tt.cache)
+		}
+		if got := resp.Header("Content-Disposition"); !strings.HasPrefix(got, tt.disposition) || (tt.disposition == "") != (got == "") {
+			t.Errorf("GET %s: Content-Disposition = %q, want %q", tt.path, got, tt.disposition)
+		}
+	}
+
+	// Listing is per mount too.
+	if resp := roundTrip(t, dial(t, addr), "GET", "/assets/", nil, nil); resp.Status != 404 {
+		t.Errorf("GET /assets/ = %d, want 404 without listing", resp.Status)
+	}
+	if resp := roundTrip(t, dial(t, addr), "GET", "/downloads/", nil, nil); resp.Status != 200 || !strings.Contains(string(resp.Body), "app.js") {
+		t.Errorf("GET /downloads/ = %d, want a listing with app.js", resp.Status)
+	}
+	// Mounts are read-only unless marked writable.
+	if resp := roundTrip(t, dial(t, addr), "PUT", "/assets/new.js", nil, []byte("x")); resp.Status != 405 {
+		t.Errorf("PUT /assets/new.js = %d, want 405", resp.Status)
+	}
+
+	t.Setenv("STATIC_MOUNTS", "/static="+assets+",/static/dl="+downloads)
+	_, err := NewServerFromConfig(config.LoadConfig())
+	if err == nil || !strings.Contains(err.Error(), "/static -> "+assets) || !strings.Contains(err.Error(), "/static/dl -> "+downloads) {
+		t.Errorf("NewServerFromConfig with colliding mounts = %v, want an error naming both", err)
+	}
+}
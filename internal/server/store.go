@@ -0,0 +1,42 @@
+package server
+
+// Keys used by the built-in middleware for request-scoped values.
+const (
+	// RequestIDKey holds the request ID string set by RequestIDMiddleware.
+	RequestIDKey = "request_id"
+
+	// PrincipalKey holds the authenticated identity string set by
+	// ClientCertMiddleware.
+	PrincipalKey = "principal"
+
+	// RateDecisionKey holds the RateDecision of the last rate limit the
+	// request passed through, set by RateLimitMiddleware and route rate
+	// limits.
+	RateDecisionKey = "rate_decision"
+)
+
+// Set stores a request-scoped value under key, replacing any previous value.
+//
+// The store lets middleware hand data to the handlers it wraps without
+// smuggling it through req.Headers. It is created on first use and lives
+// only as long as the request. It is not safe for concurrent use: values
+// must not be read or written from goroutines that outlive the handler.
+func (r *Request) Set(key string, value any) {
+	if r.values == nil {
+		r.values = make(map[string]any)
+	}
+	r.values[key] = value
+}
+
+// Get returns the request-scoped value stored under key and whether it was
+// present.
+func (r *Request) Get(key string) (any, bool) {
+	value, ok := r.values[key]
+	return value, ok
+}
+
+// GetString returns the value stored under key if it is a string, or "".
+func (r *Request) GetString(key string) string {
+	value, _ := r.values[key].(string)
+	return value
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRequestStore(t *testing.T) {
+	req := &Request{}
+	if value, ok := req.Get("user"); value != nil || ok {
+		t.Errorf("Get on a fresh request = %v, %t; want nil, false", value, ok)
+	}
+	if got := req.GetString("user"); got != "" {
+		t.Errorf("GetString of a missing key = %q, want empty", got)
+	}
+
+	req.Set("user", "ana")
+	req.Set("user", "bob")
+	if value, ok := req.Get("user"); value != "bob" || !ok {
+		t.Errorf("Get after two Sets = %v, %t; want the last value", value, ok)
+	}
+	req.Set("count", 3)
+	if got := req.GetString("count"); got != "" {
+		t.Errorf("GetString of an int = %q, want empty", got)
+	}
+	if value, ok := req.Get("count"); value != 3 || !ok {
+		t.Errorf("Get of an int = %v, %t", value, ok)
+	}
+	req.Set("nothing", nil)
+	if value, ok := req.Get("nothing"); value != nil || !ok {
+		t.Errorf("Get of a nil value = %v, %t; want nil, true", value, ok)
+	}
+}
+
+func TestRequestStoreFlowsFromMiddleware(t *testing.T) {
+	authenticate := func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) Response {
+			if user := req.Headers["x-user"]; user != "" {
+				req.Set(PrincipalKey, user)
+			}
+			return next(req)
+		}
+	}
+	_, addr := startServerWithRoutes(t, func(r *Router) {
+		r.Use(RequestIDMiddleware, authenticate)
+		r.Handle("/whoami", "GET", func(req *Request) Response {
+			_, ok := req.Get(PrincipalKey)
+			return textResponse(fmt.Sprintf("%s %t %s", req.GetString(PrincipalKey), ok, req.GetString(RequestIDKey)))
+		})
+	})
+	c := dial(t, addr)
+
+	resp := roundTrip(t, c, "GET", "/whoami", map[string]string{"Connection": "keep-alive", "X-User": "ana", "X-Request-ID": "r1"}, nil)
+	if string(resp.Body) != "ana true r1" {
+		t.Errorf("first request: %q, want the middleware's values", resp.Body)
+	}
+	// The next request on the connection starts with an empty store.
+	resp = roundTrip(t, c, "GET", "/whoami", map[string]string{"X-Request-ID": "r2"}, nil)
+	if string(resp.Body) != " false r2" {
+		t.Errorf("second request: %q, want no principal carried over", resp.Body)
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// streamErrorTrailer is the trailer that carries the code of an aborted
+// stream when the response declares it in its Trailer header.
+const streamErrorTrailer = "X-Stream-Error"
+
+// StreamError is returned by a StreamFunc to abort the body with a short
+// machine-readable code, reported in the X-Stream-Error trailer.
+//
+// Example:
+//
+//	if err := rows.Err(); err != nil {
+//	    return &StreamError{Code: "db_cursor", Err: err}
+//	}
+type StreamError struct {
+	Code string
+	Err  error
+}
+
+func (e *StreamError) Error() string {
+	if e.Err == nil {
+		return e.Code
+	}
+	return fmt.Sprintf("%s: %v", e.Code, e.Err)
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+// streamErrorCode returns the trailer code for err: the Code of a
+// StreamError, or "internal" for any other error.
+func streamErrorCode(err error) string {
+	var streamErr *StreamError
+	if errors.As(err, &streamErr) && streamErr.Code != "" {
+		return streamErr.Code
+	}
+	return "internal"
+}
+
+// abortWriter is the writer handed to a StreamFunc. It cancels the request
+// context as soon as a write to the client fails.
+type abortWriter struct {
+	w      io.Writer
+	cancel func()
+}
+
+func (a *abortWriter) Write(p []byte) (int, error) {
+	n, err := a.w.Write(p)
+	if err != nil && a.cancel != nil {
+		a.cancel()
+	}
+	return n, err
+}
+
+// Flush forwards to the underlying writer so streams can push data out
+// between delayed writes.
+func (a *abortWriter) Flush() error {
+	flusher, ok := a.w.(interface{ Flush() error })
+	if !ok {
+		return nil
+	}
+	err := flusher.Flush()
+	if err != nil && a.cancel != nil {
+		a.cancel()
+	}
+	return err
+}
+
+// abortStream finishes a response whose StreamFunc failed. Data already
+// produced is flushed so the client sees exactly where the body stopped.
+// With a chunked writer the body is terminated by the X-Stream-Error
+// trailer; otherwise no terminator is written and the caller must close the
+// connection, which it does because an error is returned. When the client
+// has disconnected there is no one left to tell, so nothing is written.
+func abortStream(w *bufio.Writer, chunked *ChunkedWriter, err error) error {
+	if isPeerDisconnect(err) {
+		return fmt.Errorf("stream aborted: %w", err)
+	}
+	Metrics.Counter("streams_aborted_total").Inc()
+	utils.Error("Stream aborted: %v", err)
+
+	if chunked != nil {
+		chunked.CloseWithTrailers(map[string]string{streamErrorTrailer: streamErrorCode(err)})
+	}
+	w.Flush()
+	return fmt.Errorf("stream aborted: %w", err)
+}
+
+// declaresTrailer reports whether the Trailer header lists name.
+func declaresTrailer(fields []headerField, name string) bool {
+	for _, f := range fields {
+		if f.name != "Trailer" {
+			continue
+		}
+		for _, declared := range strings.Split(f.value, ",") {
+			if strings.EqualFold(strings.TrimSpace(declared), name) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startStreamServer serves GET /fail, a chunked stream that writes
+// "partial" and then fails with failure, declaring the X-Stream-Error trailer
+// if trailer is set.
+func startStreamServer(t *testing.T, failure error, trailer bool) string {
+	t.Helper()
+	_, addr := startServerWithRoutes(t, func(r *Router) {
+		r.Handle("/fail", "GET", func(req *Request) Response {
+			resp := Response{
+				Version: HTTPVersion,
+				Status:  200,
+				Reason:  "OK",
+				Headers: map[string]string{"Content-Type": "text/plain"},
+				StreamFunc: func(w io.Writer) error {
+					if _, err := io.WriteString(w, "partial"); err != nil {
+						return err
+					}
+					return failure
+				},
+			}
+			if trailer {
+				resp.Headers["Trailer"] = "X-Stream-Error"
+			}
+			return resp
+		})
+	})
+	return addr
+}
+
+// readUntilClose sends a keep-alive GET for path to addr and returns
+// everything the server sends until it closes the connection.
+func readUntilClose(t *testing.T, addr, path string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: x\r\nConnection: keep-alive\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("reading until the server closes: %v (read %q)", err, data)
+	}
+	return string(data)
+}
+
+func TestStreamAbortWithoutTerminalChunk(t *testing.T) {
+	addr := startStreamServer(t, errors.New("cursor failed"), false)
+	aborted := Metrics.Counter("streams_aborted_total").Value()
+
+	raw := readUntilClose(t, addr, "/fail")
+	head, body, _ := strings.Cut(raw, "\r\n\r\n")
+	if !strings.HasPrefix(head, "HTTP/1.1 200 OK") || !strings.Contains(head, "Transfer-Encoding: chunked") {
+		t.Fatalf("head %q, want a committed chunked 200", head)
+	}
+	if body != "7\r\npartial\r\n" {
+		t.Errorf("body %q, want the data written before the error and no terminal chunk", body)
+	}
+	if got := Metrics.Counter("streams_aborted_total").Value(); got <= aborted {
+		t.Errorf("streams_aborted_total = %d, want it counted", got)
+	}
+}
+
+func TestStreamAbortTrailer(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		code string
+	}{
+		{&StreamError{Code: "db_cursor", Err: errors.New("cursor failed")}, "db_cursor"},
+		{errors.New("anything else"), "internal"},
+	} {
+		addr := startStreamServer(t, tt.err, true)
+		raw := readUntilClose(t, addr, "/fail")
+		_, body, _ := strings.Cut(raw, "\r\n\r\n")
+		if want := "7\r\npartial\r\n0\r\nX-Stream-Error: " + tt.code + "\r\n\r\n"; body != want {
+			t.Errorf("%v: body %q, want %q", tt.err, body, want)
+		}
+	}
+}
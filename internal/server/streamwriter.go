@@ -0,0 +1,410 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// DefaultStreamBuffer is how many bytes a StreamWriter queues for a client
+// before Write blocks and TryWrite refuses.
+const DefaultStreamBuffer = 64 << 10
+
+// DefaultStreamStallAfter is how long a write to the client may take before
+// the stream counts as stalled, when the server sets no other value.
+const DefaultStreamStallAfter = 10 * time.Second
+
+// streamSendSize is the most a StreamWriter writes to the client at once,
+// so that a client reading slowly but steadily is not taken for stalled.
+const streamSendSize = 32 << 10
+
+// errStreamStalled is the StreamError code of a stream closed because its
+// client stopped reading, see Route.StreamStallTimeout.
+const errStreamStalled = "stalled"
+
+// StreamStats describes a response stream and how well its client keeps up,
+// as listed by /admin/streams.
+type StreamStats struct {
+	ID           uint64    `json:"id"`
+	Method       string    `json:"method,omitempty"`
+	Path         string    `json:"path,omitempty"`
+	Route        string    `json:"route,omitempty"`
+	Client       string    `json:"client,omitempty"`
+	RequestID    string    `json:"request_id,omitempty"`
+	Started      time.Time `json:"started"`
+	BytesWritten int64     `json:"bytes_written"`           // handed to the connection
+	Buffered     int       `json:"buffered"`                // queued, not yet written
+	LastWrite    time.Time `json:"last_write,omitzero"`     // last write that completed
+	Stalled      bool      `json:"stalled"`                 // a write has been blocked for the stall threshold
+	WriteBlocked string    `json:"write_blocked,omitempty"` // how long the current write has taken
+}
+
+// streamOptions are set by the server on a streamed response, see
+// Server.streamOptions.
+type streamOptions struct {
+	registry     *streamRegistry
+	stats        StreamStats // request details
+	stallAfter   time.Duration
+	stallTimeout time.Duration
+	writeTimeout time.Duration
+}
+
+// StreamWriter is the writer a StreamFunc receives. Writes are queued, up
+// to DefaultStreamBuffer bytes, and sent to the client by a goroutine of
+// its own, so a client that stops reading blocks that goroutine rather
+// than the StreamFunc, which can keep producing, check Stalled and drop
+// what is not worth sending with TryWrite:
+//
+//	sw, _ := server.AsStreamWriter(w)
+//	if sw != nil && sw.Stalled() && event.LowPriority {
+//	    return nil // skip it rather than queue behind a stuck client
+//	}
+//
+// A failed write is reported by the next Write, TryWrite or Flush. A
+// write blocked for longer than the route's stall timeout (see
+// Route.StreamStallTimeout), or else WRITE_TIMEOUT, ends the stream; as
+// the deadline is renewed for every write, a stream lasts as long as its
+// client keeps reading.
+type StreamWriter struct {
+	w    io.Writer
+	conn net.Conn
+
+	stallAfter   time.Duration
+	stallTimeout time.Duration
+	writeTimeout time.Duration
+	registry     *streamRegistry
+	onStall      atomic.Pointer[func(StreamStats)]
+
+	mu      sync.Mutex
+	cond    *sync.Cond // signalled when the queue or state changes
+	queue   []byte
+	spare   []byte // the drained queue, reused
+	flush   bool
+	closed  bool
+	err     error
+	writing time.Time // start of the write in progress, zero if none
+	stalled bool
+	stats   StreamStats
+	done    chan struct{}
+}
+
+// newStreamWriter starts a StreamWriter sending to w, which writes to conn.
+// opts may be nil outside a Server.
+func newStreamWriter(conn net.Conn, w io.Writer, opts *streamOptions) *StreamWriter {
+	sw := &StreamWriter{w: w, conn: conn, stallAfter: DefaultStreamStallAfter, done: make(chan struct{})}
+	if opts != nil {
+		sw.stats = opts.stats
+		sw.registry = opts.registry
+		sw.stallTimeout = opts.stallTimeout
+		sw.writeTimeout = opts.writeTimeout
+		if opts.stallAfter > 0 {
+			sw.stallAfter = opts.stallAfter
+		}
+	}
+	sw.stats.Started = time.Now()
+	sw.cond = sync.NewCond(&sw.mu)
+	if sw.registry != nil {
+		sw.registry.add(sw)
+	}
+	go sw.run()
+	return sw
+}
+
+// AsStreamWriter returns the StreamWriter behind w, the writer passed to a
+// StreamFunc, looking through writers that wrap it with an Unwrap method.
+func AsStreamWriter(w io.Writer) (*StreamWriter, bool) {
+	for {
+		switch v := w.(type) {
+		case *StreamWriter:
+			return v, true
+		case interface{ Unwrap() io.Writer }:
+			w = v.Unwrap()
+		default:
+			return nil, false
+		}
+	}
+}
+
+// Write queues p, blocking while the queue is full.
+func (sw *StreamWriter) Write(p []byte) (int, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	for sw.err == nil && len(sw.queue) > 0 && len(sw.queue)+len(p) > DefaultStreamBuffer {
+		sw.cond.Wait()
+	}
+	if sw.err != nil {
+		return 0, sw.err
+	}
+	sw.queue = append(sw.queue, p...)
+	sw.cond.Broadcast()
+	return len(p), nil
+}
+
+// TryWrite queues p if that does not have to wait for the client, and
+// reports whether it did. p is never queued in part.
+func (sw *StreamWriter) TryWrite(p []byte) (bool, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.err != nil {
+		return false, sw.err
+	}
+	if len(sw.queue) > 0 && len(sw.queue)+len(p) > DefaultStreamBuffer {
+		Metrics.Counter("stream_writes_refused_total").Inc()
+		return false, nil
+	}
+	sw.queue = append(sw.queue, p...)
+	sw.cond.Broadcast()
+	return true, nil
+}
+
+// Flush asks for the queued data to be sent to the client now, rather than
+// aggregated into larger chunks. It does not wait for it to be sent.
+func (sw *StreamWriter) Flush() error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.err != nil {
+		return sw.err
+	}
+	sw.flush = true
+	sw.cond.Broadcast()
+	return nil
+}
+
+// Stalled reports whether the client has not accepted a write for the
+// stall threshold (STREAM_STALL_AFTER).
+func (sw *StreamWriter) Stalled() bool {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.stalled
+}
+
+// OnStall registers fn to be called, from another goroutine, whenever the
+// stream becomes stalled.
+func (sw *StreamWriter) OnStall(fn func(StreamStats)) {
+	sw.onStall.Store(&fn)
+}
+
+// Stats returns the stream's current statistics.
+func (sw *StreamWriter) Stats() StreamStats {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	stats := sw.stats
+	stats.Buffered = len(sw.queue)
+	stats.Stalled = sw.stalled
+	if !sw.writing.IsZero() {
+		stats.WriteBlocked = time.Since(sw.writing).Round(time.Millisecond).String()
+	}
+	return stats
+}
+
+// run sends the queue to the client until close is called and the queue
+// is empty.
+func (sw *StreamWriter) run() {
+	defer close(sw.done)
+	stallTimer := time.AfterFunc(time.Hour, sw.checkStall)
+	stallTimer.Stop()
+	defer stallTimer.Stop()
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	for {
+		for len(sw.queue) == 0 && !sw.flush && !sw.closed {
+			sw.cond.Wait()
+		}
+		if sw.err != nil {
+			// Nobody is listening any more: drop what is queued.
+			sw.queue, sw.flush = sw.queue[:0], false
+		}
+		if len(sw.queue) == 0 && !sw.flush {
+			return
+		}
+		data, flush := sw.queue, sw.flush
+		sw.queue, sw.spare, sw.flush = sw.spare[:0], nil, false
+		sw.writing = time.Now()
+		sw.cond.Broadcast()
+		sw.mu.Unlock()
+
+		n, err := sw.send(data, flush, stallTimer)
+		stallTimer.Stop()
+
+		sw.mu.Lock()
+		sw.spare = data[:0]
+		sw.writing = time.Time{}
+		sw.stats.BytesWritten += int64(n)
+		if n > 0 {
+			sw.stats.LastWrite = time.Now()
+		}
+		if sw.stalled {
+			sw.stalled = false
+			Metrics.Gauge("streams_stalled").Dec()
+			if err == nil {
+				utils.Info("Stream %d to %s resumed", sw.stats.ID, sw.stats.Client)
+			}
+		}
+		if err != nil && sw.err == nil {
+			sw.err = err
+		}
+		sw.cond.Broadcast()
+	}
+}
+
+// send writes data to the client and flushes it if asked to. Each
+// streamSendSize bytes must be written within the stall timeout, or else
+// the write timeout, if there is one, and restart the stall timer.
+func (sw *StreamWriter) send(data []byte, flush bool, stallTimer *time.Timer) (int, error) {
+	timeout := sw.writeTimeout
+	if sw.stallTimeout != 0 {
+		timeout = sw.stallTimeout
+	}
+	var n int
+	var err error
+	for len(data) > 0 && err == nil {
+		piece := data[:min(len(data), streamSendSize)]
+		if n > 0 {
+			sw.mu.Lock()
+			sw.writing = time.Now()
+			sw.mu.Unlock()
+		}
+		stallTimer.Reset(sw.stallAfter)
+		if sw.conn != nil {
+			setWriteDeadline(sw.conn, timeout)
+		}
+		var written int
+		written, err = sw.w.Write(piece)
+		n += written
+		data = data[written:]
+	}
+	if err == nil && flush {
+		if flusher, ok := sw.w.(interface{ Flush() error }); ok {
+			err = flusher.Flush()
+		}
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		Metrics.Counter("streams_stall_terminated_total").Inc()
+		utils.Warn("Closing stream %d to %s: no progress for %v", sw.stats.ID, sw.stats.Client, timeout)
+		err = &StreamError{Code: errStreamStalled, Err: err}
+	}
+	return n, err
+}
+
+// checkStall marks the stream stalled if the current write has taken the
+// stall threshold.
+func (sw *StreamWriter) checkStall() {
+	sw.mu.Lock()
+	if sw.stalled || sw.writing.IsZero() || time.Since(sw.writing) < sw.stallAfter {
+		sw.mu.Unlock()
+		return
+	}
+	sw.stalled = true
+	sw.mu.Unlock()
+
+	Metrics.Gauge("streams_stalled").Inc()
+	Metrics.Counter("streams_stalls_total").Inc()
+	stats := sw.Stats()
+	utils.Warn("Stream %d to %s stalled: no write progress for %v (%d bytes queued)", stats.ID, stats.Client, sw.stallAfter, stats.Buffered)
+	if fn := sw.onStall.Load(); fn != nil {
+		(*fn)(stats)
+	}
+}
+
+// close waits until everything queued has been sent, or has failed, and
+// returns the first error.
+func (sw *StreamWriter) close() error {
+	sw.mu.Lock()
+	sw.closed = true
+	sw.cond.Broadcast()
+	sw.mu.Unlock()
+	<-sw.done
+	if sw.registry != nil {
+		sw.registry.remove(sw)
+	}
+	return sw.err
+}
+
+// streamRegistry tracks a server's active streams.
+type streamRegistry struct {
+	mu      sync.Mutex
+	streams map[*StreamWriter]struct{}
+	nextID  atomic.Uint64
+}
+
+func newStreamRegistry() *streamRegistry {
+	return &streamRegistry{streams: make(map[*StreamWriter]struct{})}
+}
+
+func (r *streamRegistry) add(sw *StreamWriter) {
+	sw.stats.ID = r.nextID.Add(1)
+	r.mu.Lock()
+	r.streams[sw] = struct{}{}
+	Metrics.Gauge("streams_active").Set(int64(len(r.streams)))
+	r.mu.Unlock()
+}
+
+func (r *streamRegistry) remove(sw *StreamWriter) {
+	r.mu.Lock()
+	delete(r.streams, sw)
+	Metrics.Gauge("streams_active").Set(int64(len(r.streams)))
+	r.mu.Unlock()
+}
+
+// list returns the statistics of every active stream, oldest first.
+func (r *streamRegistry) list() []StreamStats {
+	r.mu.Lock()
+	streams := make([]*StreamWriter, 0, len(r.streams))
+	for sw := range r.streams {
+		streams = append(streams, sw)
+	}
+	r.mu.Unlock()
+
+	stats := make([]StreamStats, len(streams))
+	for i, sw := range streams {
+		stats[i] = sw.Stats()
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
+
+// StreamStallTimeout closes the route's streams once a write to the client
+// has made no progress for d, overriding STREAM_STALL_TIMEOUT and
+// WRITE_TIMEOUT. A negative d never closes them.
+func (rt *Route) StreamStallTimeout(d time.Duration) *Route {
+	rt.streamStallTimeout = d
+	return rt
+}
+
+// streamOptions returns the options for streaming the response to req on
+// a connection with the given write timeout.
+func (s *Server) streamOptions(req *Request, writeTimeout time.Duration) *streamOptions {
+	opts := &streamOptions{
+		registry: s.streams,
+		stats: StreamStats{
+			Method:    req.Method,
+			Path:      req.Path,
+			Client:    req.ClientIP(),
+			RequestID: req.GetString(RequestIDKey),
+		},
+		stallAfter:   s.config.StreamStallAfter,
+		stallTimeout: s.config.StreamStallTimeout,
+		writeTimeout: writeTimeout,
+	}
+	if req.route != nil {
+		opts.stats.Route = req.route.describe()
+		if req.route.streamStallTimeout != 0 {
+			opts.stallTimeout = req.route.streamStallTimeout
+		}
+	}
+	return opts
+}
+
+// handleAdminStreams handles GET /admin/streams, listing the active
+// streams as JSON.
+func (s *Server) handleAdminStreams(req *Request) Response {
+	return jsonNoStore(s.streams.list())
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+)
+
+// pipeStream starts a StreamWriter sending to one end of a pipe and
+// returns it with the other end, which the test reads from, or not, as a
+// client would.
+func pipeStream(t *testing.T, opts *streamOptions) (*StreamWriter, net.Conn) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return newStreamWriter(server, server, opts), client
+}
+
+func TestStreamWriterStallAndResume(t *testing.T) {
+	stalledStreams := Metrics.Gauge("streams_stalled")
+	stalls := Metrics.Counter("streams_stalls_total")
+	gaugeBefore, stallsBefore := stalledStreams.Value(), stalls.Value()
+
+	sw, client := pipeStream(t, &streamOptions{stats: StreamStats{Client: "203.0.113.9"}, stallAfter: 50 * time.Millisecond})
+	notified := make(chan StreamStats, 1)
+	sw.OnStall(func(stats StreamStats) { notified <- stats })
+
+	sw.Write([]byte("hello"))
+	sw.Flush()
+	var stats StreamStats
+	select {
+	case stats = <-notified:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnStall not called for a client that does not read")
+	}
+	if !stats.Stalled || stats.WriteBlocked == "" || stats.BytesWritten != 0 || stats.Client != "203.0.113.9" {
+		t.Errorf("stats at the stall = %+v, want stalled, blocked, nothing written", stats)
+	}
+	if !sw.Stalled() {
+		t.Error("Stalled() = false after the stall")
+	}
+	if got := stalledStreams.Value() - gaugeBefore; got != 1 {
+		t.Errorf("streams_stalled grew by %d, want 1", got)
+	}
+	if got := stalls.Value() - stallsBefore; got != 1 {
+		t.Errorf("streams_stalls_total grew by %d, want 1", got)
+	}
+
+	// The client catches up.
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the stream to resume", func() bool { return !sw.Stalled() })
+	if got := stalledStreams.Value(); got != gaugeBefore {
+		t.Errorf("streams_stalled = %d after resuming, want %d", got, gaugeBefore)
+	}
+	stats = sw.Stats()
+	if stats.BytesWritten != 5 || stats.LastWrite.IsZero() || stats.WriteBlocked != "" {
+		t.Errorf("stats after resuming = %+v, want 5 bytes written and no write blocked", stats)
+	}
+	if err := sw.close(); err != nil {
+		t.Errorf("close = %v", err)
+	}
+}
+
+func TestStreamWriterBackPressure(t *testing.T) {
+	sw, client := pipeStream(t, nil)
+	refused := Metrics.Counter("stream_writes_refused_total")
+	before := refused.Value()
+
+	// The first write goes to the client, which is not reading, so the
+	// next ones stay queued.
+	sw.Write([]byte("first"))
+	waitFor(t, "the first write to block", func() bool { return sw.Stats().WriteBlocked != "" })
+	if ok, err := sw.TryWrite(make([]byte, DefaultStreamBuffer)); !ok || err != nil {
+		t.Fatalf("TryWrite into an empty queue = %v, %v; want it queued", ok, err)
+	}
+	if got := sw.Stats().Buffered; got != DefaultStreamBuffer {
+		t.Errorf("Buffered = %d, want %d", got, DefaultStreamBuffer)
+	}
+	if ok, err := sw.TryWrite([]byte("low priority")); ok || err != nil {
+		t.Errorf("TryWrite into a full queue = %v, %v; want it refused", ok, err)
+	}
+	if got := refused.Value() - before; got != 1 {
+		t.Errorf("stream_writes_refused_total grew by %d, want 1", got)
+	}
+
+	wrote := make(chan error, 1)
+	go func() {
+		_, err := sw.Write([]byte("must arrive"))
+		wrote <- err
+	}()
+	select {
+	case <-wrote:
+		t.Fatal("Write returned with the queue full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	received := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(client)
+		received <- string(data)
+	}()
+	if err := <-wrote; err != nil {
+		t.Fatalf("Write once the client reads = %v", err)
+	}
+	if err := sw.close(); err != nil {
+		t.Fatalf("close = %v", err)
+	}
+	client.Close()
+	data := <-received
+	if !strings.HasPrefix(data, "first") || !strings.HasSuffix(data, "must arrive") || strings.Contains(data, "low priority") {
+		t.Errorf("client received %d bytes, want the blocking writes and not the refused one", len(data))
+	}
+}
+
+func TestStreamWriterStallTimeout(t *testing.T) {
+	terminated := Metrics.Counter("streams_stall_terminated_total")
+	before := terminated.Value()
+	sw, _ := pipeStream(t, &streamOptions{stallAfter: 20 * time.Millisecond, stallTimeout: 100 * time.Millisecond})
+
+	start := time.Now()
+	sw.Write([]byte("never read"))
+	sw.Flush()
+	var err error
+	waitFor(t, "the stream to fail", func() bool {
+		_, err = sw.Write([]byte("more"))
+		return err != nil
+	})
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("stream closed after %v, before the stall timeout", elapsed)
+	}
+	var streamErr *StreamError
+	if !errors.As(err, &streamErr) || streamErr.Code != errStreamStalled {
+		t.Errorf("Write after the timeout = %v, want a %q StreamError", err, errStreamStalled)
+	}
+	if ok, err := sw.TryWrite([]byte("x")); ok || err == nil {
+		t.Errorf("TryWrite after the timeout = %v, %v; want the error", ok, err)
+	}
+	if err := sw.Flush(); err == nil {
+		t.Error("Flush after the timeout succeeded")
+	}
+	if got := terminated.Value() - before; got != 1 {
+		t.Errorf("streams_stall_terminated_total grew by %d, want 1", got)
+	}
+	if err := sw.close(); !errors.As(err, &streamErr) {
+		t.Errorf("close = %v, want the stall error", err)
+	}
+}
+
+func TestStreamRegistry(t *testing.T) {
+	registry := newStreamRegistry()
+	first, _ := pipeStream(t, &streamOptions{registry: registry, stats: StreamStats{Path: "/a"}})
+	second, _ := pipeStream(t, &streamOptions{registry: registry, stats: StreamStats{Path: "/b"}})
+
+	list := registry.list()
+	if len(list) != 2 || list[0].Path != "/a" || list[1].Path != "/b" || list[0].ID >= list[1].ID {
+		t.Fatalf("list = %+v, want /a then /b with increasing IDs", list)
+	}
+	if got := Metrics.Gauge("streams_active").Value(); got != 2 {
+		t.Errorf("streams_active = %d, want 2", got)
+	}
+	first.close()
+	if list := registry.list(); len(list) != 1 || list[0].Path != "/b" {
+		t.Errorf("list after closing /a = %+v", list)
+	}
+	second.close()
+	if got := Metrics.Gauge("streams_active").Value(); got != 0 {
+		t.Errorf("streams_active = %d after closing both, want 0", got)
+	}
+}
+
+func TestServeStalledStream(t *testing.T) {
+	cfg := config.LoadConfig()
+	cfg.StreamStallAfter = 100 * time.Millisecond
+	router := NewRouter()
+	srv := NewServer(cfg, router)
+	stopped := make(chan error, 1)
+	router.Handle("/firehose", "GET", func(req *Request) Response {
+		return Response{
+			Version: HTTPVersion,
+			Status:  200,
+			Reason:  "OK",
+			Headers: map[string]string{"Content-Type": "application/octet-stream"},
+			StreamFunc: func(w io.Writer) error {
+				chunk := make([]byte, 16<<10)
+				for {
+					if _, err := w.Write(chunk); err != nil {
+						stopped <- err
+						return err
+					}
+				}
+			},
+		}
+	}).StreamStallTimeout(500 * time.Millisecond)
+	router.Handle("/admin/streams", "GET", srv.handleAdminStreams)
+	addr := serve(t, srv)
+
+	// A client that sends its request and never reads.
+	sendHead(t, addr, "GET /firehose HTTP/1.1\r\nHost: x\r\n\r\n")
+
+	var listed []StreamStats
+	waitFor(t, "the stream to be listed as stalled", func() bool {
+		resp := roundTrip(t, dial(t, addr), "GET", "/admin/streams", nil, nil)
+		listed = nil
+		if err := json.Unmarshal(resp.Body, &listed); err != nil {
+			t.Fatalf("GET /admin/streams: %v", err)
+		}
+		return len(listed) == 1 && listed[0].Stalled
+	})
+	got := listed[0]
+	if got.Route != "GET /firehose" || got.Client != "127.0.0.1" || got.BytesWritten == 0 || got.Buffered == 0 || got.WriteBlocked == "" {
+		t.Errorf("listed stream = %+v", got)
+	}
+
+	// The route's stall timeout then ends the stream.
+	select {
+	case err := <-stopped:
+		var streamErr *StreamError
+		if !errors.As(err, &streamErr) || streamErr.Code != errStreamStalled {
+			t.Errorf("StreamFunc write error = %v, want a %q StreamError", err, errStreamStalled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stalled stream not closed at the route's stall timeout")
+	}
+	waitFor(t, "the stream to be unlisted", func() bool { return len(srv.streams.list()) == 0 })
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// swapTable registers the routes of table generation gen: /stable in
+// every generation, and /even or /odd depending on gen.
+func swapTable(gen int) func(r *Router) error {
+	return func(r *Router) error {
+		r.Handle("/stable", "GET", func(req *Request) Response {
+			return textResponse(fmt.Sprint(gen))
+		})
+		if gen%2 == 0 {
+			r.Handle("/even", "GET", func(req *Request) Response { return textResponse("even") })
+		} else {
+			r.Handle("/odd", "GET", func(req *Request) Response { return textResponse("odd") })
+		}
+		return nil
+	}
+}
+
+func TestSwapUnderLoad(t *testing.T) {
+	r := NewRouter()
+	var wrapped atomic.Int64
+	r.Use(func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) Response {
+			wrapped.Add(1)
+			return next(req)
+		}
+	})
+	if err := r.Swap(swapTable(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	var requests atomic.Int64
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			last := 0
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// A route in every table is always found, on tables only
+				// ever moving forward.
+				resp := routeGET(r, "/stable")
+				requests.Add(1)
+				if resp.Status != 200 {
+					t.Errorf("GET /stable = %d during swaps", resp.Status)
+					return
+				}
+				gen := 0
+				fmt.Sscan(string(resp.Body), &gen)
+				if gen < last {
+					t.Errorf("GET /stable served by generation %d after %d", gen, last)
+					return
+				}
+				last = gen
+
+				// A request's two phases use one table, even if a swap
+				// lands between them: one routed by the pre-body phase is
+				// never lost to the next table by Route.
+				req := &Request{Method: "GET", Headers: map[string]string{}}
+				req.setTarget([]string{"/even", "/odd"}[requests.Load()%2])
+				if r.CheckBeforeBody(req) != nil {
+					continue
+				}
+				if resp := r.Route(req); resp.Status != 200 {
+					t.Errorf("GET %s = %d after passing the pre-body phase", req.Path, resp.Status)
+					return
+				}
+			}
+		})
+	}
+
+	// Swap until plenty of requests have raced the swaps.
+	for gen := 1; gen <= 200 || requests.Load() < 5000; gen++ {
+		if err := r.Swap(swapTable(gen)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if wrapped.Load() < requests.Load() {
+		t.Errorf("Use middleware ran %d times for %d requests, want it carried into every table", wrapped.Load(), requests.Load())
+	}
+}
+
+func TestSwapInFlight(t *testing.T) {
+	r := NewRouter()
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	r.Handle("/slow", "GET", func(req *Request) Response {
+		close(entered)
+		<-release
+		return textResponse("old")
+	})
+
+	done := make(chan Response)
+	go func() { done <- routeGET(r, "/slow") }()
+	<-entered
+	if err := r.Swap(func(r *Router) error {
+		r.Handle("/slow", "GET", func(req *Request) Response { return textResponse("new") })
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(routeGET(r, "/slow").Body); got != "new" {
+		t.Errorf("request after the swap served %q, want new", got)
+	}
+	close(release)
+	if got := string((<-done).Body); got != "old" {
+		t.Errorf("request in flight during the swap served %q, want old", got)
+	}
+}
+
+func TestSwapRejected(t *testing.T) {
+	r := NewRouter()
+	if err := r.Swap(swapTable(0)); err != nil {
+		t.Fatal(err)
+	}
+	failed := Metrics.Counter("router_swaps_failed_total")
+	before := failed.Value()
+
+	errBuild := errors.New("routes file unreadable")
+	if err := r.Swap(func(r *Router) error {
+		r.Handle("/half", "GET", func(req *Request) Response { return textResponse("half") })
+		return errBuild
+	}); !errors.Is(err, errBuild) {
+		t.Errorf("Swap with a failing build = %v, want its error", err)
+	}
+	err := r.Swap(func(r *Router) error {
+		r.Handle("/ok", "GET", func(req *Request) Response { return textResponse("ok") })
+		return r.HandleRegex(`/unanchored`, func(req *Request) Response { return textResponse("x") })
+	})
+	if !errors.Is(err, ErrUnanchoredRegex) {
+		t.Errorf("Swap with an invalid table = %v, want ErrUnanchoredRegex", err)
+	}
+	if got := failed.Value() - before; got != 2 {
+		t.Errorf("router_swaps_failed_total grew by %d, want 2", got)
+	}
+
+	// Neither rejected table was published, even in part.
+	for target, want := range map[string]int{"/stable": 200, "/even": 200, "/half": 404, "/ok": 404} {
+		if got := routeGET(r, target).Status; got != want {
+			t.Errorf("GET %s = %d after rejected swaps, want %d", target, got, want)
+		}
+	}
+}
+
+func TestServeRoutesReload(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	// ReloadConfig sets the variables it reads from .env; setting them
+	// here too restores them when the test ends.
+	t.Setenv("DOCS_ENABLED", "false")
+	t.Setenv("STATIC_MOUNTS", "")
+	t.Setenv("ADMIN_TOKEN", "secret")
+	t.Setenv("ACCESS_LOG", "off")
+	writeEnv := func(content string) {
+		if err := os.WriteFile(".env", []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeEnv("DOCS_ENABLED=false\n")
+	_, addr := startServer(t)
+	admin := map[string]string{"Authorization": "Bearer secret"}
+
+	if resp := roundTrip(t, dial(t, addr), "GET", "/docs", nil, nil); resp.Status != 404 {
+		t.Fatalf("GET /docs before reloading = %d, want 404", resp.Status)
+	}
+	writeEnv("DOCS_ENABLED=true\n")
+	resp := roundTrip(t, dial(t, addr), "POST", "/admin/routes/reload", admin, nil)
+	if resp.Status != 200 || !strings.HasPrefix(string(resp.Body), "routes reloaded") {
+		t.Fatalf("reload = %d %q, want 200", resp.Status, resp.Body)
+	}
+	if resp := roundTrip(t, dial(t, addr), "GET", "/docs", nil, nil); resp.Status != 200 {
+		t.Errorf("GET /docs after reloading = %d, want 200", resp.Status)
+	}
+
+	writeEnv("DOCS_ENABLED=false\nSTATIC_MOUNTS=/a=public,/a=public\n")
+	resp = roundTrip(t, dial(t, addr), "POST", "/admin/routes/reload", admin, nil)
+	if resp.Status != 422 || !strings.Contains(string(resp.Body), "STATIC_MOUNTS") {
+		t.Errorf("reload of an invalid config = %d %q, want 422 naming STATIC_MOUNTS", resp.Status, resp.Body)
+	}
+	if resp := roundTrip(t, dial(t, addr), "GET", "/docs", nil, nil); resp.Status != 200 {
+		t.Errorf("GET /docs after a rejected reload = %d, want the previous routes kept", resp.Status)
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// taskGroup tracks the background goroutines started with Request.Go.
+type taskGroup struct {
+	wg     sync.WaitGroup
+	active atomic.Int64
+}
+
+// defaultTasks tracks tasks of requests that were not read by a Server,
+// such as requests built by hand in tests.
+var defaultTasks taskGroup
+
+// start runs fn in a tracked goroutine. A panic in fn is logged and
+// recovered so it cannot take the process down.
+func (g *taskGroup) start(ctx context.Context, fn func(ctx context.Context)) {
+	g.wg.Add(1)
+	g.active.Add(1)
+	Metrics.Counter("background_tasks_started_total").Inc()
+	Metrics.Gauge("background_tasks_active").Inc()
+
+	go func() {
+		defer func() {
+			if rec := recover(); rec != nil {
+				utils.Error("Recovered from panic in background task: %v\n%s", rec, debug.Stack())
+			}
+			g.active.Add(-1)
+			Metrics.Gauge("background_tasks_active").Dec()
+			g.wg.Done()
+		}()
+		fn(ctx)
+	}()
+}
+
+// wait blocks until every task has finished or ctx is done.
+func (g *taskGroup) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Go runs fn in a goroutine tracked by the server, instead of a bare go
+// statement inside a handler. fn receives the request context, which is
+// cancelled when the response has been sent, when writing to the client
+// fails, and when the server stops its tasks (see Server.StopTasks), so fn
+// must return promptly once ctx is done.
+//
+// Example:
+//
+//	req.Go(func(ctx context.Context) {
+//	    for {
+//	        select {
+//	        case <-ctx.Done():
+//	            return
+//	        case ev := <-events:
+//	            out <- ev
+//	        }
+//	    }
+//	})
+func (r *Request) Go(fn func(ctx context.Context)) {
+	tasks := r.tasks
+	if tasks == nil {
+		tasks = &defaultTasks
+	}
+	tasks.start(r.Context(), fn)
+}
+
+// ActiveTasks returns the number of Request.Go tasks still running. Tests
+// can assert it returns to zero to detect leaked goroutines.
+func (s *Server) ActiveTasks() int64 {
+	return s.tasks.active.Load()
+}
+
+// WaitTasks waits up to timeout for all Request.Go tasks to finish,
+// without cancelling them. It returns an error naming how many are still
+// running at the deadline; tests use it as a leak check.
+func (s *Server) WaitTasks(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := s.tasks.wait(ctx); err != nil {
+		return fmt.Errorf("%d background tasks still running after %v", s.ActiveTasks(), timeout)
+	}
+	return nil
+}
+
+// StopTasks is the shutdown step for background tasks: it cancels the
+// context of every request, and so of every Request.Go task, then waits
+// for the tasks to return until ctx is done. Tasks that ignore
+// cancellation are abandoned, counted in background_tasks_abandoned_total,
+// and reported in the returned error.
+func (s *Server) StopTasks(ctx context.Context) error {
+	s.cancelBase()
+	if err := s.tasks.wait(ctx); err != nil {
+		abandoned := s.ActiveTasks()
+		Metrics.Counter("background_tasks_abandoned_total").Add(abandoned)
+		utils.Warn("Abandoning %d background tasks: %v", abandoned, err)
+		return fmt.Errorf("abandoned %d background tasks: %w", abandoned, err)
+	}
+	return nil
+}
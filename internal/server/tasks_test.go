@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestGoEndsWithResponse(t *testing.T) {
+	ended := make(chan error, 1)
+	srv, addr := startServerWithRoutes(t, func(r *Router) {
+		r.Handle("/work", "GET", func(req *Request) Response {
+			req.Go(func(ctx context.Context) {
+				<-ctx.Done()
+				ended <- ctx.Err()
+			})
+			return textResponse("started")
+		})
+	})
+	c := dial(t, addr)
+
+	if resp := roundTrip(t, c, "GET", "/work", map[string]string{"Connection": "keep-alive"}, nil); resp.Status != 200 {
+		t.Fatalf("GET /work = %d, want 200", resp.Status)
+	}
+	select {
+	case err := <-ended:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("task context ended with %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("task outlived its request")
+	}
+	if err := srv.WaitTasks(5 * time.Second); err != nil {
+		t.Error(err)
+	}
+	if n := srv.ActiveTasks(); n != 0 {
+		t.Errorf("ActiveTasks = %d, want 0", n)
+	}
+}
+
+func TestStreamTaskStopsOnDisconnect(t *testing.T) {
+	srv, addr := startServerWithRoutes(t, func(r *Router) {
+		r.Handle("/stream", "GET", handleStream)
+	})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, "GET /stream HTTP/1.1\r\nHost: x\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading the stream: %v", err)
+		}
+		if strings.HasPrefix(line, "Chunk 1") {
+			break
+		}
+	}
+	if n := srv.ActiveTasks(); n != 1 {
+		t.Errorf("ActiveTasks while streaming = %d, want the producer", n)
+	}
+
+	// The stream has nine seconds to go; the producer must stop as soon
+	// as the next write finds the client gone.
+	conn.Close()
+	if err := srv.WaitTasks(3 * time.Second); err != nil {
+		t.Errorf("after the client left: %v", err)
+	}
+}
+
+func TestStopTasksAbandonsStubbornTask(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	srv, addr := startServerWithRoutes(t, func(r *Router) {
+		r.Handle("/stubborn", "GET", func(req *Request) Response {
+			req.Go(func(context.Context) {
+				close(started)
+				<-release // ignores cancellation
+			})
+			return textResponse("started")
+		})
+	})
+	t.Cleanup(func() { close(release) })
+	c := dial(t, addr)
+	roundTrip(t, c, "GET", "/stubborn", nil, nil)
+	<-started
+
+	abandoned := Metrics.Counter("background_tasks_abandoned_total").Value()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	if err := srv.StopTasks(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("StopTasks = %v, want it to give up at the deadline", err)
+	}
+	if waited := time.Since(begin); waited < 50*time.Millisecond {
+		t.Errorf("StopTasks returned after %v, want it to wait for the deadline", waited)
+	}
+	if got := Metrics.Counter("background_tasks_abandoned_total").Value(); got != abandoned+1 {
+		t.Errorf("background_tasks_abandoned_total = %d, want %d", got, abandoned+1)
+	}
+	if n := srv.ActiveTasks(); n != 1 {
+		t.Errorf("ActiveTasks = %d, want the abandoned task still counted", n)
+	}
+}
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+	"github.com/Abb133Se/httpServer/internal/diskspace"
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+var errQuotaExceeded = errors.New("tenant quota exceeded")
+
+// tenant holds the storage root and quota accounting for a single tenant.
+//
+// used is the total number of bytes currently stored under root, counting
+// uploads in progress, whose size is reserved before they are written so
+// that concurrent uploads cannot overshoot the quota. It is guarded by mu.
+type tenant struct {
+	name     string
+	root     string
+	maxBytes int64
+
+	mu   sync.Mutex
+	used int64
+}
+
+// TenantFiles serves the files API for several isolated tenants.
+//
+// Requests to /files/{tenant}/{filename} are resolved against the tenant's
+// own root directory. Each tenant has an optional byte quota; uploads that
+// would exceed it are rejected with 507 Insufficient Storage.
+type TenantFiles struct {
+	tenants map[string]*tenant
+	writes  *pathLocks // writes and deletes in progress, across tenants
+}
+
+// NewTenantFiles builds a TenantFiles handler from the configured tenants.
+//
+// Each tenant root is created if missing, and its current usage is
+// initialized by walking the directory tree.
+//
+// Returns:
+//   - error: If a root cannot be created or walked, or a tenant is defined twice.
+func NewTenantFiles(tenants []config.TenantConfig) (*TenantFiles, error) {
+	tf := &TenantFiles{tenants: make(map[string]*tenant), writes: newPathLocks()}
+
+	for _, tc := range tenants {
+		if _, exists := tf.tenants[tc.Name]; exists {
+			return nil, fmt.Errorf("tenant %s defined more than once", tc.Name)
+		}
+
+		root, err := filepath.Abs(tc.Root)
+		if err != nil {
+			return nil, fmt.Errorf("invalid root for tenant %s: %w", tc.Name, err)
+		}
+		if err := os.MkdirAll(root, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create root for tenant %s: %w", tc.Name, err)
+		}
+
+		used, err := dirSize(root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute usage for tenant %s: %w", tc.Name, err)
+		}
+
+		tf.tenants[tc.Name] = &tenant{
+			name:     tc.Name,
+			root:     root,
+			maxBytes: tc.MaxBytes,
+			used:     used,
+		}
+		utils.Info("Tenant %s mounted at %s (used %d bytes, quota %d bytes)", tc.Name, root, used, tc.MaxBytes)
+	}
+
+	return tf, nil
+}
+
+// Handle serves requests to "/files/{tenant}/{filename}".
+//
+// Supported methods mirror publicFiles.handle: GET, HEAD, POST, PUT, DELETE, OPTIONS.
+// Downloads carry the same validators as the public files and honor
+// Range and If-Range, see fileResponse.
+//
+// Error Handling:
+//   - 400 Bad Request: No filename specified, or one that is not valid
+//     UTF-8 once decoded.
+//   - 403 Forbidden: The filename resolves outside the tenant root.
+//   - 404 Not Found: Unknown tenant, or file does not exist (GET/DELETE).
+//   - 507 Insufficient Storage: The upload would exceed the tenant quota,
+//     or the disk is full.
+func (tf *TenantFiles) Handle(req *Request) Response {
+	rest, err := decodeFilePath(strings.TrimPrefix(req.Path, "/files/"))
+	if err != nil {
+		utils.Warn("Rejected file name: %s %s: %v", req.Method, req.Path, err)
+		return BadRequestErrorResponse(err)
+	}
+	name, filename, _ := strings.Cut(rest, "/")
+
+	t, ok := tf.tenants[name]
+	if !ok {
+		utils.Warn("Unknown tenant requested: %s %s", req.Method, req.Path)
+		return NotFoundResponse()
+	}
+
+	if filename == "" {
+		utils.Warn("Tenant file request with no filename: %s %s", req.Method, req.Path)
+		return Response{
+			Version: HTTPVersion,
+			Status:  400,
+			Reason:  "Bad Request",
+			Headers: map[string]string{"Content-Type": "text/plain"},
+			Body:    []byte("No file specified"),
+		}
+	}
+
+	filePath, ok := t.resolve(filename)
+	if !ok {
+		utils.Warn("Tenant %s path escapes root: %s", t.name, filename)
+		return ForbiddenResponse()
+	}
+
+	switch req.Method {
+	case "HEAD":
+		info, err := os.Stat(filePath)
+		if err != nil || info.IsDir() {
+			utils.Warn("Tenant %s file not found: %s", t.name, filePath)
+			return NotFoundResponse()
+		}
+		return Response{
+			Version:       HTTPVersion,
+			Status:        200,
+			Reason:        "OK",
+			Headers:       fileHeaders(filePath, info),
+			ContentLength: info.Size(),
+		}
+
+	case "GET":
+		info, err := os.Stat(filePath)
+		if err != nil || info.IsDir() {
+			utils.Warn("Tenant %s file not found: %s", t.name, filePath)
+			return NotFoundResponse()
+		}
+		return fileResponse(req, filePath, info, nil)
+
+	case "POST", "PUT":
+		size := int64(len(req.Body))
+		if req.bodyStream != nil {
+			size, _ = req.declaredLength()
+		}
+		unlock := tf.writes.lock(filePath)
+		err := t.write(filePath, req.BodyReader(), size)
+		unlock()
+		if err != nil {
+			var httpErr *HTTPError
+			switch {
+			case errors.Is(err, errQuotaExceeded):
+				utils.Warn("Tenant %s quota exceeded writing %s (%d bytes)", t.name, filePath, size)
+				return InsufficientStorageResponse()
+			case diskspace.IsFull(err):
+				utils.Error("Disk full writing tenant file: %s", filePath)
+				return InsufficientStorageResponse()
+			case errors.As(err, &httpErr):
+				utils.Warn("Failed to write tenant file: %s, error: %v", filePath, err)
+				return httpErr.Response()
+			}
+			utils.Error("Failed to write tenant file: %s, error: %v", filePath, err)
+			return InternalServerErrorResponse()
+		}
+		status, reason := 201, "Created"
+		if req.Method == "PUT" {
+			status, reason = 200, "OK"
+		}
+		return Response{
+			Version: HTTPVersion,
+			Status:  status,
+			Reason:  reason,
+			Headers: map[string]string{"Content-Type": "text/plain"},
+			Body:    []byte("File written successfully"),
+		}
+
+	case "DELETE":
+		unlock := tf.writes.lock(filePath)
+		err := t.remove(filePath)
+		unlock()
+		if err != nil {
+			utils.Warn("Failed to delete tenant file: %s, error: %v", filePath, err)
+			return NotFoundResponse()
+		}
+		return Response{
+			Version: HTTPVersion,
+			Status:  204,
+			Reason:  "No Content",
+			Headers: map[string]string{},
+		}
+
+	case "OPTIONS":
+		return OptionsResponse("GET, HEAD, POST, PUT, DELETE, OPTIONS")
+
+	default:
+		return MethodNotAllowedResponse("GET, HEAD, POST, PUT, DELETE, OPTIONS")
+	}
+}
+
+// resolve maps a client-supplied filename to an absolute path inside the
+// tenant root. It reports false if the name is a traversal attempt (see
+// isTraversal), fails cleanRelativePath, or would escape the root.
+func (t *tenant) resolve(filename string) (string, bool) {
+	if isTraversal(filename) {
+		return "", false
+	}
+	rel, ok := cleanRelativePath(filename)
+	if !ok {
+		return "", false
+	}
+	return joinWithin(t.root, rel)
+}
+
+// write stores the size bytes read from src at path, creating its parent
+// directories. The difference in size is charged against the quota before
+// anything is written, and given back if the write fails; the file is
+// replaced atomically, see copyToFile. The caller holds the path's lock.
+func (t *tenant) write(path string, src io.Reader, size int64) error {
+	t.mu.Lock()
+	var existing int64
+	if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+		existing = info.Size()
+	}
+	delta := size - existing
+	if t.maxBytes > 0 && t.used+delta > t.maxBytes {
+		t.mu.Unlock()
+		return errQuotaExceeded
+	}
+	t.used += delta
+	t.mu.Unlock()
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		_, err = copyToFile(src, size, path, CopyOptions{})
+	}
+	if err != nil {
+		t.mu.Lock()
+		t.used -= delta
+		t.mu.Unlock()
+	}
+	return err
+}
+
+// remove deletes the file at path and releases its bytes from the quota.
+// The caller holds the path's lock.
+func (t *tenant) remove(path string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if info.Mode().IsRegular() {
+		t.used -= info.Size()
+	}
+	return nil
+}
+
+// dirSize returns the total size of all regular files below root.
+func dirSize(root string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(root, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
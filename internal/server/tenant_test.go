@@ -0,0 +1,229 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+)
+
+// startTenantServer serves the files API for tenants "a", with a quota of
+// quota bytes, and "b", without one, in fresh roots it returns.
+func startTenantServer(t *testing.T, quota string) (a, b, addr string) {
+	t.Helper()
+	chdirPublic(t)
+	dir := t.TempDir()
+	a, b = filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	t.Setenv("FILES_TENANTS", "a="+a+":"+quota+",b="+b)
+	_, addr = startServer(t)
+	return a, b, addr
+}
+
+func TestTenantIsolation(t *testing.T) {
+	_, b, addr := startTenantServer(t, "0")
+	if err := os.WriteFile(filepath.Join(b, "secret.txt"), []byte("b's secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := dial(t, addr)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+
+	if resp := roundTrip(t, c, "GET", "/files/b/secret.txt", keepAlive, nil); resp.Status != 200 || string(resp.Body) != "b's secret" {
+		t.Fatalf("own tenant: GET = %d %q, want 200", resp.Status, resp.Body)
+	}
+	for _, path := range []string{
+		"/files/a/../b/secret.txt",
+		"/files/a/%2e%2e/b/secret.txt",
+		"/files/a/%2E%2E%2Fb%2Fsecret.txt",
+		"/files/a/..%2fb%2fsecret.txt",
+		"/files/a/sub/../../b/secret.txt",
+	} {
+		resp := roundTrip(t, c, "GET", path, keepAlive, nil)
+		if resp.Status == 200 || strings.Contains(string(resp.Body), "secret") {
+			t.Errorf("GET %s = %d %q, want it refused", path, resp.Status, resp.Body)
+		}
+		resp = roundTrip(t, c, "PUT", path, keepAlive, []byte("overwritten"))
+		if resp.Status < 400 {
+			t.Errorf("PUT %s = %d, want it refused", path, resp.Status)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(b, "secret.txt")); err != nil || string(data) != "b's secret" {
+		t.Errorf("b's file = %q, %v after traversal attempts", data, err)
+	}
+
+	if resp := roundTrip(t, c, "GET", "/files/c/secret.txt", keepAlive, nil); resp.Status != 404 {
+		t.Errorf("unknown tenant: GET = %d, want 404", resp.Status)
+	}
+	if resp := roundTrip(t, c, "PUT", "/files/c/x.txt", keepAlive, []byte("x")); resp.Status != 404 {
+		t.Errorf("unknown tenant: PUT = %d, want 404", resp.Status)
+	}
+}
+
+func TestTenantQuota(t *testing.T) {
+	a, _, addr := startTenantServer(t, "10")
+	c := dial(t, addr)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+
+	put := func(name, body string) int {
+		t.Helper()
+		return roundTrip(t, c, "PUT", "/files/a/"+name, keepAlive, []byte(body)).Status
+	}
+	if status := put("one.txt", "123456"); status != 200 {
+		t.Fatalf("PUT within quota = %d, want 200", status)
+	}
+	resp := roundTrip(t, c, "PUT", "/files/a/two.txt", keepAlive, []byte("12345"))
+	if resp.Status != 507 {
+		t.Fatalf("PUT over quota = %d, want 507", resp.Status)
+	}
+	if _, err := os.Stat(filepath.Join(a, "two.txt")); err == nil {
+		t.Error("refused upload was stored")
+	}
+	if status := put("two.txt", "1234"); status != 200 {
+		t.Errorf("PUT filling the quota exactly = %d, want 200", status)
+	}
+
+	// Overwriting only charges the difference in size.
+	if status := put("one.txt", "12"); status != 200 {
+		t.Errorf("shrinking overwrite = %d, want 200", status)
+	}
+	if status := put("two.txt", "12345678"); status != 200 {
+		t.Errorf("overwrite into the freed space = %d, want 200", status)
+	}
+	if status := put("one.txt", "123"); status != 507 {
+		t.Errorf("growing overwrite over quota = %d, want 507", status)
+	}
+
+	// Deleting gives the file's bytes back.
+	if resp := roundTrip(t, c, "DELETE", "/files/a/two.txt", keepAlive, nil); resp.Status != 204 {
+		t.Fatalf("DELETE = %d, want 204", resp.Status)
+	}
+	if status := put("three.txt", "12345678"); status != 200 {
+		t.Errorf("PUT after delete = %d, want 200", status)
+	}
+}
+
+func TestTenantUsageFromDisk(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{"x.txt": "1234", "sub/y.txt": "123456"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tf, err := NewTenantFiles([]config.TenantConfig{{Name: "a", Root: root, MaxBytes: 12}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ten := tf.tenants["a"]
+	if ten.used != 10 {
+		t.Errorf("usage at startup = %d, want 10", ten.used)
+	}
+	if err := ten.write(filepath.Join(root, "z.txt"), strings.NewReader("123"), 3); err != errQuotaExceeded {
+		t.Errorf("write over quota = %v, want errQuotaExceeded", err)
+	}
+
+	if _, err := NewTenantFiles([]config.TenantConfig{{Name: "a", Root: root}, {Name: "a", Root: root}}); err == nil {
+		t.Error("tenant defined twice was accepted")
+	}
+}
+
+func TestTenantQuotaConcurrentWrites(t *testing.T) {
+	root := t.TempDir()
+	tf, err := NewTenantFiles([]config.TenantConfig{{Name: "a", Root: root, MaxBytes: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ten := tf.tenants["a"]
+
+	var wg sync.WaitGroup
+	var stored atomic.Int64
+	for i := range 40 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ten.write(filepath.Join(root, fmt.Sprintf("%d.txt", i)), strings.NewReader("x"), 1) == nil {
+				stored.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if stored.Load() != 10 || ten.used != 10 {
+		t.Errorf("%d writes stored, %d bytes used; want 10 of each", stored.Load(), ten.used)
+	}
+}
+
+func TestTenantNestedFiles(t *testing.T) {
+	a, _, addr := startTenantServer(t, "100")
+	c := dial(t, addr)
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+
+	if resp := roundTrip(t, c, "PUT", "/files/a/x/y/z.txt", keepAlive, []byte("nested")); resp.Status != 200 {
+		t.Fatalf("PUT into new directories = %d %q, want 200", resp.Status, resp.Body)
+	}
+	if data, err := os.ReadFile(filepath.Join(a, "x", "y", "z.txt")); err != nil || string(data) != "nested" {
+		t.Errorf("stored file = %q, %v", data, err)
+	}
+	resp := roundTrip(t, c, "GET", "/files/a/x/y/z.txt", keepAlive, nil)
+	if resp.Status != 200 || string(resp.Body) != "nested" {
+		t.Errorf("GET = %d %q, want 200 nested", resp.Status, resp.Body)
+	}
+	if resp := roundTrip(t, c, "GET", "/files/a/x/y/z.txt", map[string]string{"Connection": "keep-alive", "Range": "bytes=2-3"}, nil); resp.Status != 206 || string(resp.Body) != "st" {
+		t.Errorf("GET range = %d %q, want 206 st", resp.Status, resp.Body)
+	}
+	entries, err := os.ReadDir(filepath.Join(a, "x", "y"))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("directory holds %v, %v; want only the file, no temporary left behind", entries, err)
+	}
+}
+
+func TestTenantFailedWriteKeepsFileAndQuota(t *testing.T) {
+	root := t.TempDir()
+	tf, err := NewTenantFiles([]config.TenantConfig{{Name: "a", Root: root, MaxBytes: 20}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ten := tf.tenants["a"]
+	path := filepath.Join(root, "f.txt")
+	if err := ten.write(path, strings.NewReader("12345"), 5); err != nil {
+		t.Fatal(err)
+	}
+
+	// The body ends before the declared size: the old content and the
+	// reservation for the new one both survive unchanged.
+	err = ten.write(path, strings.NewReader("abc"), 10)
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.Status != 400 {
+		t.Fatalf("short write = %v, want a 400 HTTPError", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "12345" {
+		t.Errorf("file after failed write = %q, %v; want the old content", data, err)
+	}
+	if ten.used != 5 {
+		t.Errorf("usage after failed write = %d, want 5", ten.used)
+	}
+}
+
+func TestTenantGetStreamsFile(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "big.bin"), make([]byte, 1<<20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tf, err := NewTenantFiles([]config.TenantConfig{{Name: "a", Root: root}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := tf.Handle(&Request{Method: "GET", Path: "/files/a/big.bin", Headers: map[string]string{}})
+	if resp.Status != 200 || resp.Body != nil || resp.StreamFunc == nil {
+		t.Errorf("GET = %d with %d body bytes and StreamFunc %t, want the file streamed", resp.Status, len(resp.Body), resp.StreamFunc != nil)
+	}
+	if got := resp.Headers["Content-Length"]; got != "1048576" {
+		t.Errorf("Content-Length = %q, want 1048576", got)
+	}
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// Client certificate policies accepted in TLS_CLIENT_AUTH.
+const (
+	ClientAuthNone             = "none"
+	ClientAuthVerifyIfGiven    = "verify_if_given"
+	ClientAuthRequireAndVerify = "require_and_verify"
+)
+
+// TLSInfo describes the TLS session a request arrived on.
+//
+// The Peer* fields are only populated when the client presented a
+// certificate that passed verification against the configured client CAs.
+type TLSInfo struct {
+	Version     uint16
+	CipherSuite uint16
+	ServerName  string
+
+	PeerSubject     string
+	PeerCommonName  string
+	PeerSANs        []string
+	PeerFingerprint string // hex SHA-256 of the leaf certificate
+}
+
+// Identities returns the names a client certificate can be matched by:
+// its subject common name followed by its DNS, email and URI SANs.
+func (t *TLSInfo) Identities() []string {
+	if t == nil || t.PeerSubject == "" {
+		return nil
+	}
+	ids := make([]string, 0, len(t.PeerSANs)+1)
+	if t.PeerCommonName != "" {
+		ids = append(ids, t.PeerCommonName)
+	}
+	return append(ids, t.PeerSANs...)
+}
+
+// newTLSInfo extracts request-visible details from a completed handshake.
+func newTLSInfo(state tls.ConnectionState) *TLSInfo {
+	info := &TLSInfo{
+		Version:     state.Version,
+		CipherSuite: state.CipherSuite,
+		ServerName:  state.ServerName,
+	}
+	if len(state.PeerCertificates) == 0 {
+		return info
+	}
+
+	leaf := state.PeerCertificates[0]
+	sum := sha256.Sum256(leaf.Raw)
+	info.PeerSubject = leaf.Subject.String()
+	info.PeerCommonName = leaf.Subject.CommonName
+	info.PeerFingerprint = hex.EncodeToString(sum[:])
+	info.PeerSANs = append(info.PeerSANs, leaf.DNSNames...)
+	info.PeerSANs = append(info.PeerSANs, leaf.EmailAddresses...)
+	for _, uri := range leaf.URIs {
+		info.PeerSANs = append(info.PeerSANs, uri.String())
+	}
+	return info
+}
+
+// buildTLSConfig creates the server TLS configuration from cfg.
+//
+// Client certificates are verified by verifyClientCert rather than by the
+// TLS stack so that rejected certificates can be logged with the subject
+// the client presented:
+//   - "require_and_verify": a valid certificate is required; the handshake
+//     fails otherwise.
+//   - "verify_if_given": a certificate is optional, but one that is
+//     presented must be valid. Authorization is left to ClientCertMiddleware.
+//   - "none": client certificates are not requested.
+func buildTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS key pair: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	switch cfg.TLSClientAuth {
+	case "", ClientAuthNone:
+		return tlsConfig, nil
+	case ClientAuthVerifyIfGiven:
+		tlsConfig.ClientAuth = tls.RequestClientCert
+	case ClientAuthRequireAndVerify:
+		tlsConfig.ClientAuth = tls.RequireAnyClientCert
+	default:
+		return nil, fmt.Errorf("unknown TLS_CLIENT_AUTH policy %q", cfg.TLSClientAuth)
+	}
+
+	if cfg.TLSClientCAFile == "" {
+		return nil, errors.New("TLS_CLIENT_CA_FILE is required when client authentication is enabled")
+	}
+	pemData, err := os.ReadFile(cfg.TLSClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("no certificates found in %s", cfg.TLSClientCAFile)
+	}
+
+	tlsConfig.VerifyPeerCertificate = verifyClientCert(pool)
+	return tlsConfig, nil
+}
+
+// certValidity checks the validity period of the server certificate in
+// tlsConfig at now. A certificate that has expired or is not valid yet is
+// an error; one expiring within warn yields a warning.
+func certValidity(tlsConfig *tls.Config, now time.Time, warn time.Duration) (warning string, err error) {
+	if len(tlsConfig.Certificates) == 0 {
+		return "", nil
+	}
+	leaf := tlsConfig.Certificates[0].Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(tlsConfig.Certificates[0].Certificate[0]); err != nil {
+			return "", fmt.Errorf("failed to parse TLS certificate: %w", err)
+		}
+	}
+	switch {
+	case now.After(leaf.NotAfter):
+		return "", fmt.Errorf("TLS certificate for %s expired on %s", leaf.Subject.CommonName, leaf.NotAfter.UTC().Format(time.RFC3339))
+	case now.Before(leaf.NotBefore):
+		return "", fmt.Errorf("TLS certificate for %s is not valid before %s", leaf.Subject.CommonName, leaf.NotBefore.UTC().Format(time.RFC3339))
+	case leaf.NotAfter.Sub(now) < warn:
+		return fmt.Sprintf("TLS certificate for %s expires soon, on %s", leaf.Subject.CommonName, leaf.NotAfter.UTC().Format(time.RFC3339)), nil
+	}
+	return "", nil
+}
+
+// verifyClientCert returns a VerifyPeerCertificate callback that checks the
+// presented chain against roots. An empty chain is accepted; the TLS stack
+// has already enforced presence when the policy requires it.
+func verifyClientCert(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return nil
+		}
+
+		certs := make([]*x509.Certificate, 0, len(rawCerts))
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				utils.Warn("Client certificate rejected: unparseable certificate: %v", err)
+				return err
+			}
+			certs = append(certs, cert)
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		_, err := certs[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		if err != nil {
+			utils.Warn("Client certificate rejected: subject=%q: %v", certs[0].Subject.String(), err)
+			return err
+		}
+		return nil
+	}
+}
+
+// handshake wraps conn in TLS and completes the handshake within the
+// configured timeout. Failures are logged and counted in
+// tls_handshake_failures_total by reason.
+func (s *Server) handshake(conn net.Conn) (*tls.Conn, error) {
+	tlsConn := tls.Server(conn, s.tlsConfig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.TLSHandshakeTimeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		reason := handshakeFailureReason(err)
+		Metrics.Counter(fmt.Sprintf("tls_handshake_failures_total{reason=%q}", reason)).Inc()
+		utils.Warn("TLS handshake with %s failed (%s): %v", conn.RemoteAddr(), reason, err)
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// handshakeFailureReason classifies a handshake error as "timeout",
+// "client_closed", "bad_cert" or "protocol".
+func handshakeFailureReason(err error) string {
+	var netErr net.Error
+	var certInvalid x509.CertificateInvalidError
+	var unknownAuthority x509.UnknownAuthorityError
+	var alert tls.AlertError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, io.EOF), errors.Is(err, net.ErrClosed):
+		return "client_closed"
+	case errors.As(err, &certInvalid), errors.As(err, &unknownAuthority), errors.As(err, &alert) && isCertAlert(alert):
+		return "bad_cert"
+	case strings.Contains(err.Error(), "certificate"):
+		return "bad_cert"
+	default:
+		return "protocol"
+	}
+}
+
+// isCertAlert reports whether a TLS alert concerns a certificate.
+func isCertAlert(alert tls.AlertError) bool {
+	switch alert {
+	case 42, 43, 44, 45, 46, 48, 116: // bad/unsupported/revoked/expired/unknown certificate, unknown CA, certificate required
+		return true
+	}
+	return false
+}
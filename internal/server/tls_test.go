@@ -0,0 +1,340 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+	"github.com/Abb133Se/httpServer/internal/testclient"
+)
+
+// testCert is a test certificate with its private key.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert creates a certificate from template, signed by parent, or
+// self-signed if parent is nil.
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func newTestCA(t *testing.T, name string) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+}
+
+func newClientCert(t *testing.T, ca *testCert, name string, dnsNames ...string) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name, Organization: []string{"Tests"}},
+		DNSNames:    dnsNames,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, ca)
+}
+
+// writePEM writes the certificate and its key to dir and returns their
+// paths.
+func (c *testCert) writePEM(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key, Leaf: c.cert}
+}
+
+// mtlsServer serves the configured server over TLS with client
+// certificates verified against ca under policy, and the ACL acl. Besides
+// the usual routes it has "/tls-info", answering with the verified peer's
+// identities. It returns the server's address and the CA pool clients
+// verify it with.
+func mtlsServer(t *testing.T, ca *testCert, policy, acl string) (string, *x509.CertPool) {
+	t.Helper()
+	dir := t.TempDir()
+	serverCA := newTestCA(t, "Server CA")
+	serverCert := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, serverCA)
+	certFile, keyFile := serverCert.writePEM(t, dir, "server")
+	caFile, _ := ca.writePEM(t, dir, "client-ca")
+	t.Setenv("TLS_CERT_FILE", certFile)
+	t.Setenv("TLS_KEY_FILE", keyFile)
+	t.Setenv("TLS_CLIENT_CA_FILE", caFile)
+	t.Setenv("TLS_CLIENT_AUTH", policy)
+	t.Setenv("CLIENT_CERT_ACL", acl)
+
+	cfg := loadConfig()
+	srv, err := NewServerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.tlsConfig, err = buildTLSConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.router.Handle("/tls-info", "GET", func(req *Request) Response {
+		info := req.TLSState
+		return textResponse(info.PeerSubject + "|" + strings.Join(info.Identities(), ",") + "|" + info.PeerFingerprint)
+	})
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCA.cert)
+	return serve(t, srv), roots
+}
+
+// dialTLS connects to addr over TLS presenting cert, if not nil. The
+// handshake error, if any, is returned rather than failing t.
+func dialTLS(t *testing.T, addr string, roots *x509.CertPool, cert *testCert) (*testclient.Client, error) {
+	t.Helper()
+	cfg := &tls.Config{RootCAs: roots}
+	if cert != nil {
+		cfg.Certificates = []tls.Certificate{cert.tlsCertificate()}
+	}
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { conn.Close() })
+	// TLS 1.3 reports a rejected client certificate on the first read, so
+	// finish the handshake's exchange before handing the connection out.
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); err != nil && !isTimeout(err) {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+	return testclient.NewClient(conn), nil
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+func TestMTLSRequireAndVerify(t *testing.T) {
+	ca := newTestCA(t, "Client CA")
+	addr, roots := mtlsServer(t, ca, ClientAuthRequireAndVerify, "")
+	good := newClientCert(t, ca, "svc-a", "a.internal")
+	rogue := newClientCert(t, newTestCA(t, "Rogue CA"), "svc-a", "a.internal")
+
+	c, err := dialTLS(t, addr, roots, good)
+	if err != nil {
+		t.Fatalf("handshake with a valid certificate: %v", err)
+	}
+	resp := roundTrip(t, c, "GET", "/tls-info", nil, nil)
+	subject, rest, _ := strings.Cut(string(resp.Body), "|")
+	identities, fingerprint, _ := strings.Cut(rest, "|")
+	if subject != "CN=svc-a,O=Tests" || identities != "svc-a,a.internal" || len(fingerprint) != 64 {
+		t.Errorf("peer = %q, identities %q, fingerprint %q", subject, identities, fingerprint)
+	}
+
+	for name, cert := range map[string]*testCert{"no certificate": nil, "untrusted certificate": rogue} {
+		if _, err := dialTLS(t, addr, roots, cert); err == nil {
+			t.Errorf("%s: handshake succeeded, want it refused", name)
+		}
+	}
+}
+
+func TestMTLSVerifyIfGivenWithACL(t *testing.T) {
+	ca := newTestCA(t, "Client CA")
+	addr, roots := mtlsServer(t, ca, ClientAuthVerifyIfGiven, "a.internal=/echo/,svc-b=/user-agent")
+	svcA := newClientCert(t, ca, "svc-a", "a.internal")
+
+	if _, err := dialTLS(t, addr, roots, newClientCert(t, newTestCA(t, "Rogue CA"), "svc-b")); err == nil {
+		t.Error("handshake with an untrusted certificate succeeded, want it refused")
+	}
+
+	anonymous, err := dialTLS(t, addr, roots, nil)
+	if err != nil {
+		t.Fatalf("handshake without a certificate: %v", err)
+	}
+	withCert, err := dialTLS(t, addr, roots, svcA)
+	if err != nil {
+		t.Fatalf("handshake with a valid certificate: %v", err)
+	}
+	keepAlive := map[string]string{"Connection": "keep-alive"}
+	for _, tt := range []struct {
+		name   string
+		c      *testclient.Client
+		path   string
+		status int
+	}{
+		{"anonymous, unprotected path", anonymous, "/", 200},
+		{"anonymous, protected path", anonymous, "/echo/hi", 403},
+		{"by SAN, allowed prefix", withCert, "/echo/hi", 200},
+		{"by SAN, other identity's prefix", withCert, "/user-agent", 403},
+		{"by SAN, unprotected path", withCert, "/", 200},
+	} {
+		if resp := roundTrip(t, tt.c, "GET", tt.path, keepAlive, nil); resp.Status != tt.status {
+			t.Errorf("%s: GET %s = %d, want %d", tt.name, tt.path, resp.Status, tt.status)
+		}
+	}
+}
+
+func TestBuildTLSConfigRejectsBadPolicies(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "CA")
+	certFile, keyFile := ca.writePEM(t, dir, "server")
+	empty := filepath.Join(dir, "empty.pem")
+	os.WriteFile(empty, nil, 0600)
+
+	for _, tt := range []struct {
+		name, policy, caFile, want string
+	}{
+		{"unknown policy", "sometimes", certFile, "unknown TLS_CLIENT_AUTH"},
+		{"no CA bundle", ClientAuthRequireAndVerify, "", "TLS_CLIENT_CA_FILE is required"},
+		{"empty CA bundle", ClientAuthVerifyIfGiven, empty, "no certificates found"},
+	} {
+		_, err := buildTLSConfig(&config.Config{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSClientAuth: tt.policy, TLSClientCAFile: tt.caFile})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want it to mention %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// tlsServer serves the configured server, with adjust applied to its
+// configuration, over TLS with a certificate for 127.0.0.1. It returns the
+// server's address and the CA pool clients verify it with.
+func tlsServer(t *testing.T, adjust func(*config.Config)) (string, *x509.CertPool) {
+	t.Helper()
+	ca := newTestCA(t, "Server CA")
+	cert := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, ca)
+	cfg := loadConfig()
+	adjust(cfg)
+	srv, err := NewServerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert.tlsCertificate()}}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	return serve(t, srv), roots
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	addr, _ := tlsServer(t, func(cfg *config.Config) {
+		cfg.TLSHandshakeTimeout = 200 * time.Millisecond
+		cfg.ReadTimeout = 10 * time.Second
+	})
+	timeouts := Metrics.Counter(`tls_handshake_failures_total{reason="timeout"}`)
+
+	for name, sent := range map[string]string{
+		"nothing sent":           "",
+		"handshake record begun": "\x16\x03\x01\x00",
+	} {
+		before := timeouts.Value()
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.Write([]byte(sent))
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		start := time.Now()
+		_, err = conn.Read(make([]byte, 1))
+		if isTimeout(err) {
+			t.Fatalf("%s: connection still open after 5s, want it released after the handshake timeout", name)
+		}
+		if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+			t.Errorf("%s: closed after %v, before the handshake timeout", name, elapsed)
+		}
+		waitFor(t, "the handshake timeout to be counted", func() bool { return timeouts.Value() > before })
+	}
+}
+
+func TestTLSHeadTimeoutAfterHandshake(t *testing.T) {
+	addr, roots := tlsServer(t, func(cfg *config.Config) {
+		cfg.TLSHandshakeTimeout = 200 * time.Millisecond
+		cfg.ReadTimeout = time.Second
+	})
+
+	// The handshake deadline no longer applies once it completed: a client
+	// may take longer than it to send its request, within the read timeout.
+	c, err := dialTLS(t, addr, roots, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(400 * time.Millisecond)
+	if resp := roundTrip(t, c, "GET", "/", nil, nil); resp.Status != 200 {
+		t.Errorf("request sent after the handshake timeout = %d, want 200", resp.Status)
+	}
+
+	// A head that stops arriving part way is cut off by the read timeout.
+	c, err = dialTLS(t, addr, roots, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SendRaw([]byte("GET / HTTP/1.1\r\nHost: x\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	resp, err := c.ReadResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != 408 {
+		t.Errorf("stalled head = %d, want 408", resp.Status)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("408 after %v, want it at the read timeout", elapsed)
+	}
+	if err := c.ExpectClose(); err != nil {
+		t.Error(err)
+	}
+}
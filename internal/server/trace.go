@@ -0,0 +1,214 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// MiddlewareTraceMode selects which requests record a middleware trace.
+type MiddlewareTraceMode int
+
+const (
+	// TraceOff records no traces. It is the default.
+	TraceOff MiddlewareTraceMode = iota
+
+	// TraceOnRequest traces requests carrying "X-Debug-Middleware: 1" that
+	// come from a loopback address or carry the admin bearer token.
+	TraceOnRequest
+
+	// TraceAlways traces every request.
+	TraceAlways
+)
+
+// ParseMiddlewareTraceMode converts "off", "header" or "always" into a
+// MiddlewareTraceMode.
+func ParseMiddlewareTraceMode(name string) (MiddlewareTraceMode, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "off", "":
+		return TraceOff, nil
+	case "header":
+		return TraceOnRequest, nil
+	case "always":
+		return TraceAlways, nil
+	default:
+		return 0, fmt.Errorf("unknown middleware trace mode %q", name)
+	}
+}
+
+// SetMiddlewareTrace sets which requests are traced. A traced request
+// records, for every middleware layer and the handler, its level
+// ("global", "group", "route" or "handler"), name and the times it was
+// entered and left, relative to the start of the chain. The trace is
+// returned in the X-Middleware-Trace response header and logged, e.g.
+//
+//	global:server.RequestIDMiddleware 0us-412us, global:server.LoggingMiddleware 5us-398us, handler:server.handleRoot 21us-80us
+//
+// Layers are listed in the order they were entered. adminToken, if set,
+// also admits TraceOnRequest headers from non-loopback clients that
+// present it as a bearer token.
+func (r *Router) SetMiddlewareTrace(mode MiddlewareTraceMode, adminToken string) {
+	r.traceMode = mode
+	r.traceToken = adminToken
+}
+
+// middlewareTrace collects the layers a traced request passed through.
+type middlewareTrace struct {
+	start time.Time
+	spans []*traceSpan
+}
+
+type traceSpan struct {
+	level, name string
+	enter, exit time.Duration
+}
+
+// traceFor returns a new trace if req is to be traced, or nil.
+func (r *Router) traceFor(req *Request) *middlewareTrace {
+	switch r.traceMode {
+	case TraceAlways:
+	case TraceOnRequest:
+		if req.Headers["x-debug-middleware"] != "1" || !r.mayTrace(req) {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return &middlewareTrace{}
+}
+
+// mayTrace reports whether req may ask for a trace: it comes from a
+// loopback address or carries the admin token.
+func (r *Router) mayTrace(req *Request) bool {
+	if ip := net.ParseIP(req.ClientIP()); ip != nil && ip.IsLoopback() {
+		return true
+	}
+	if r.traceToken == "" {
+		return false
+	}
+	given, ok := strings.CutPrefix(req.Headers["authorization"], "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(given), []byte(r.traceToken)) == 1
+}
+
+// wrap records the time next is entered and left as a span.
+func (t *middlewareTrace) wrap(level, name string, next HandlerFunc) HandlerFunc {
+	return func(req *Request) Response {
+		span := &traceSpan{level: level, name: name, enter: time.Since(t.start)}
+		t.spans = append(t.spans, span)
+		defer func() { span.exit = time.Since(t.start) }()
+		return next(req)
+	}
+}
+
+// finish wraps the whole chain: it starts the clock and adds the trace to
+// the response.
+func (t *middlewareTrace) finish(next HandlerFunc) HandlerFunc {
+	return func(req *Request) Response {
+		t.start = time.Now()
+		resp := next(req)
+		parts := make([]string, len(t.spans))
+		for i, span := range t.spans {
+			parts[i] = fmt.Sprintf("%s:%s %dus-%dus", span.level, span.name, span.enter.Microseconds(), span.exit.Microseconds())
+		}
+		trace := strings.Join(parts, ", ")
+		if resp.Headers == nil {
+			resp.Headers = make(map[string]string)
+		}
+		resp.Headers["X-Middleware-Trace"] = trace
+		utils.Info("Middleware trace for %s %s: %s", req.Method, req.Path, trace)
+		return resp
+	}
+}
+
+// funcName names a middleware or handler after its function, without the
+// package path: "server.LoggingMiddleware". Closures are named after the
+// function that created them, so DumpMiddleware(0) is
+// "server.DumpMiddleware".
+func funcName(fn any) string {
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return "unknown"
+	}
+	name := f.Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	// Closures end in ".func1", or in ".1" where the function creating
+	// them was inlined.
+	for {
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			break
+		}
+		suffix := strings.TrimPrefix(name[i+1:], "func")
+		if suffix == "" || strings.Trim(suffix, "0123456789") != "" {
+			break
+		}
+		name = name[:i]
+	}
+	return strings.TrimSuffix(name, "-fm")
+}
+
+// describe names the route for log messages, e.g. "GET /files/".
+func (rt *Route) describe() string {
+	return strings.TrimSpace(rt.method + " " + rt.pattern)
+}
+
+// warnDuplicateMiddleware warns if mw, about to be added at level, is
+// already part of a chain it will share with it: the router's, group's
+// or route's (either of which may be nil), or, for router and group
+// middleware, that of a route below them. Such middleware would run twice
+// for the same request. Middleware is compared by function, so two
+// instances from the same constructor, such as DumpMiddleware(0), count
+// as duplicates.
+func (r *Router) warnDuplicateMiddleware(mw MiddlewareFunc, level string, group *RouteGroup, route *Route) {
+	ptr := reflect.ValueOf(mw).Pointer()
+	contains := func(mws []MiddlewareFunc) bool {
+		for _, m := range mws {
+			if reflect.ValueOf(m).Pointer() == ptr {
+				return true
+			}
+		}
+		return false
+	}
+	var conflicts []string
+	if contains(r.middlewares) {
+		conflicts = append(conflicts, "globally")
+	}
+	groups := r.groups
+	if group != nil {
+		groups = []*RouteGroup{group}
+	} else if route != nil {
+		groups = nil
+	}
+	for _, g := range groups {
+		if contains(g.middlewares) {
+			conflicts = append(conflicts, "on group "+g.prefix)
+		}
+	}
+	routes := r.routes
+	if route != nil {
+		routes = []*Route{route}
+	} else if group != nil {
+		routes = group.routes
+	} else {
+		for _, g := range r.groups {
+			routes = append(routes[:len(routes):len(routes)], g.routes...)
+		}
+	}
+	for _, rt := range routes {
+		if contains(rt.middlewares) {
+			conflicts = append(conflicts, "on route "+rt.describe())
+		}
+	}
+	if len(conflicts) > 0 {
+		Metrics.Counter("middleware_duplicates_total").Inc()
+		utils.Warn("Middleware %s added %s is already registered %s; it will run twice", funcName(mw), level, strings.Join(conflicts, ", "))
+	}
+}
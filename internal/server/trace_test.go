@@ -0,0 +1,229 @@
+package server
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+)
+
+func traceGlobal(next HandlerFunc) HandlerFunc { return next }
+func traceGroup(next HandlerFunc) HandlerFunc  { return next }
+func traceRoute(next HandlerFunc) HandlerFunc  { return next }
+func traceHandler(req *Request) Response       { return textResponse("ok") }
+
+// traceRouter traces requests in mode, with admin token "secret", and
+// serves /api/item with one middleware at each level.
+func traceRouter(mode MiddlewareTraceMode) *Router {
+	r := NewRouter()
+	r.SetMiddlewareTrace(mode, "secret")
+	r.Use(traceGlobal)
+	api := r.Group("/api").Use(traceGroup)
+	api.Handle("/item", traceHandler).Use(traceRoute)
+	return r
+}
+
+// traceSpanPattern matches one layer of an X-Middleware-Trace header.
+var traceSpanPattern = regexp.MustCompile(`^(\w+:\S+) (\d+)us-(\d+)us$`)
+
+// traceLayers parses an X-Middleware-Trace header into its layers, failing
+// t unless the layers nest: each is entered no earlier and left no later
+// than the one before it.
+func traceLayers(t *testing.T, header string) []string {
+	t.Helper()
+	var layers []string
+	lastEnter, lastExit := -1, 1<<62
+	for _, span := range strings.Split(header, ", ") {
+		m := traceSpanPattern.FindStringSubmatch(span)
+		if m == nil {
+			t.Fatalf("malformed trace span %q in %q", span, header)
+		}
+		enter, _ := strconv.Atoi(m[2])
+		exit, _ := strconv.Atoi(m[3])
+		if enter < lastEnter || exit > lastExit || exit < enter {
+			t.Errorf("span %q does not nest in the one before it: %q", span, header)
+		}
+		lastEnter, lastExit = enter, exit
+		layers = append(layers, m[1])
+	}
+	return layers
+}
+
+func TestParseMiddlewareTraceMode(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want MiddlewareTraceMode
+	}{
+		{"", TraceOff},
+		{"off", TraceOff},
+		{"header", TraceOnRequest},
+		{" Always ", TraceAlways},
+	} {
+		got, err := ParseMiddlewareTraceMode(tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseMiddlewareTraceMode(%q) = %v, %v; want %v", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := ParseMiddlewareTraceMode("sometimes"); err == nil {
+		t.Error("ParseMiddlewareTraceMode(\"sometimes\") succeeded")
+	}
+}
+
+func TestMiddlewareTraceOrder(t *testing.T) {
+	r := traceRouter(TraceAlways)
+	resp := routeGET(r, "/api/item")
+	got := strings.Join(traceLayers(t, resp.Headers["X-Middleware-Trace"]), ", ")
+	want := "global:server.traceGlobal, group:server.traceGroup, route:server.traceRoute, handler:server.traceHandler"
+	if got != want {
+		t.Errorf("trace layers = %q\nwant %q", got, want)
+	}
+
+	// The router's own responses trace its chain around the fallback.
+	resp = routeGET(r, "/nowhere")
+	got = strings.Join(traceLayers(t, resp.Headers["X-Middleware-Trace"]), ", ")
+	if want := "global:server.traceGlobal, handler:fallback"; got != want {
+		t.Errorf("404 trace layers = %q, want %q", got, want)
+	}
+}
+
+func TestMiddlewareTraceModes(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		mode       MiddlewareTraceMode
+		remoteAddr string
+		headers    map[string]string
+		traced     bool
+	}{
+		{"off ignores the header", TraceOff, "127.0.0.1:5000", map[string]string{"x-debug-middleware": "1"}, false},
+		{"header from loopback", TraceOnRequest, "127.0.0.1:5000", map[string]string{"x-debug-middleware": "1"}, true},
+		{"header from IPv6 loopback", TraceOnRequest, "[::1]:5000", map[string]string{"x-debug-middleware": "1"}, true},
+		{"no header", TraceOnRequest, "127.0.0.1:5000", nil, false},
+		{"header set to 0", TraceOnRequest, "127.0.0.1:5000", map[string]string{"x-debug-middleware": "0"}, false},
+		{"header from remote client", TraceOnRequest, "203.0.113.9:5000", map[string]string{"x-debug-middleware": "1"}, false},
+		{"header with admin token", TraceOnRequest, "203.0.113.9:5000",
+			map[string]string{"x-debug-middleware": "1", "authorization": "Bearer secret"}, true},
+		{"header with wrong token", TraceOnRequest, "203.0.113.9:5000",
+			map[string]string{"x-debug-middleware": "1", "authorization": "Bearer guess"}, false},
+		{"always", TraceAlways, "203.0.113.9:5000", nil, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := traceRouter(tt.mode)
+			req := &Request{Method: "GET", Path: "/api/item", Headers: map[string]string{}, RemoteAddr: tt.remoteAddr}
+			for name, value := range tt.headers {
+				req.Headers[name] = value
+			}
+			req.setTarget("/api/item")
+			_, traced := r.Route(req).Headers["X-Middleware-Trace"]
+			if traced != tt.traced {
+				t.Errorf("traced = %v, want %v", traced, tt.traced)
+			}
+		})
+	}
+}
+
+func TestFuncName(t *testing.T) {
+	var r *Router
+	for _, tt := range []struct {
+		fn   any
+		want string
+	}{
+		{LoggingMiddleware, "server.LoggingMiddleware"},
+		{traceHandler, "server.traceHandler"},
+		{DumpMiddleware(0), "server.DumpMiddleware"},
+		{func() MiddlewareFunc { return DumpMiddleware(0) }(), "server.DumpMiddleware"}, // inlined
+		{func(next HandlerFunc) HandlerFunc { return next }, "server.TestFuncName"},
+		{r.Route, "server.(*Router).Route"},
+	} {
+		if got := funcName(tt.fn); got != tt.want {
+			t.Errorf("funcName = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestWarnDuplicateMiddleware(t *testing.T) {
+	logAtLevel(t, "warn")
+	other := func(next HandlerFunc) HandlerFunc { return next }
+	for _, tt := range []struct {
+		name     string
+		register func(r *Router)
+		want     string // expected in the warning, "" for none
+	}{
+		{"distinct middleware", func(r *Router) {
+			r.Use(traceGlobal, traceGroup)
+			r.Group("/api").Use(traceRoute)
+		}, ""},
+		{"twice globally", func(r *Router) {
+			r.Use(traceGlobal)
+			r.Use(traceGlobal)
+		}, "server.traceGlobal added globally is already registered globally"},
+		{"global then group", func(r *Router) {
+			r.Use(traceGlobal)
+			r.Group("/api").Use(traceGlobal)
+		}, "added on group /api is already registered globally"},
+		{"group then its route", func(r *Router) {
+			api := r.Group("/api").Use(traceGroup)
+			api.Handle("/item", traceHandler).Use(traceGroup)
+		}, "added on route /api/item is already registered on group /api"},
+		{"route then global", func(r *Router) {
+			r.Handle("/item", "GET", traceHandler).Use(traceRoute)
+			r.Use(traceRoute)
+		}, "added globally is already registered on route GET /item"},
+		{"grouped route then global", func(r *Router) {
+			r.Group("/api").Handle("/item", traceHandler).Use(traceRoute)
+			r.Use(traceRoute)
+		}, "added globally is already registered on route /api/item"},
+		{"same constructor twice", func(r *Router) {
+			r.Use(DumpMiddleware(0))
+			r.Handle("/item", "GET", traceHandler).Use(DumpMiddleware(64))
+		}, "server.DumpMiddleware added on route GET /item is already registered globally"},
+		{"route in another group", func(r *Router) {
+			r.Group("/api").Use(traceGroup)
+			r.Group("/web").Handle("/item", traceHandler).Use(traceGroup)
+		}, ""},
+		{"two routes", func(r *Router) {
+			r.Handle("/a", "GET", traceHandler).Use(traceRoute)
+			r.Handle("/b", "GET", traceHandler).Use(traceRoute, other)
+		}, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			duplicates := Metrics.Counter("middleware_duplicates_total")
+			before := duplicates.Value()
+			logged := captureLog(t, func() { tt.register(NewRouter()) })
+			warned := duplicates.Value() > before
+			if tt.want == "" {
+				if warned || strings.Contains(logged, "[WARN]") {
+					t.Errorf("unexpected warning: %q", logged)
+				}
+				return
+			}
+			if !warned {
+				t.Error("middleware_duplicates_total not incremented")
+			}
+			if !strings.Contains(logged, tt.want) || !strings.Contains(logged, "it will run twice") {
+				t.Errorf("log = %q, want a warning containing %q", logged, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeMiddlewareTrace(t *testing.T) {
+	t.Setenv("MIDDLEWARE_TRACE", "header")
+	_, addr := startServer(t)
+
+	resp := roundTrip(t, dial(t, addr), "GET", "/healthz", map[string]string{"X-Debug-Middleware": "1"}, nil)
+	layers := traceLayers(t, resp.Header("X-Middleware-Trace"))
+	if len(layers) < 3 || layers[0] != "global:server.RequestIDMiddleware" || layers[1] != "global:server.LoggingMiddleware" ||
+		!strings.HasPrefix(layers[len(layers)-1], "handler:") {
+		t.Errorf("trace layers = %q, want the configured chain around the handler", layers)
+	}
+	if resp := roundTrip(t, dial(t, addr), "GET", "/healthz", nil, nil); resp.Header("X-Middleware-Trace") != "" {
+		t.Error("request without X-Debug-Middleware was traced")
+	}
+
+	t.Setenv("MIDDLEWARE_TRACE", "sometimes")
+	if _, err := NewServerFromConfig(config.LoadConfig()); err == nil || !strings.Contains(err.Error(), "MIDDLEWARE_TRACE") {
+		t.Errorf("NewServerFromConfig with an unknown trace mode: %v, want a MIDDLEWARE_TRACE error", err)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// MaxTransformBodySize is the largest buffered body passed to body
+// transformers. Larger responses are sent untransformed.
+const MaxTransformBodySize = 4 << 20 // 4 MB
+
+// BodyTransformer rewrites a buffered response body and returns the result.
+type BodyTransformer func(req *Request, body []byte) []byte
+
+// bodyTransform pairs a transformer with the content types it applies to.
+type bodyTransform struct {
+	contentTypePrefix string
+	fn                BodyTransformer
+}
+
+// TransformBody registers a transformer for buffered responses whose
+// Content-Type starts with contentTypePrefix (e.g. "text/html").
+//
+// Transformers run in registration order before the AfterResponse
+// post-processors. They are skipped for streaming responses, partial (206)
+// responses, responses with a Content-Encoding, and bodies larger than
+// MaxTransformBodySize. When a body changes, Content-Length is recomputed
+// and any ETag is dropped since it no longer describes the bytes sent.
+//
+// Example:
+//
+//	srv.TransformBody("text/html", InjectBeforeTag("</body>", "<script src=\"/a.js\"></script>"))
+func (s *Server) TransformBody(contentTypePrefix string, f BodyTransformer) {
+	s.transforms = append(s.transforms, bodyTransform{
+		contentTypePrefix: strings.ToLower(contentTypePrefix),
+		fn:                f,
+	})
+}
+
+// applyTransforms runs the matching body transformers on resp.
+func (s *Server) applyTransforms(req *Request, resp *Response) {
+	if len(s.transforms) == 0 || resp.StreamFunc != nil || len(resp.Body) == 0 {
+		return
+	}
+	if resp.Status == 206 || resp.Headers["Content-Encoding"] != "" {
+		return
+	}
+	if len(resp.Body) > MaxTransformBodySize {
+		utils.Debug("Skipping body transforms for %s: body is %d bytes", req.Path, len(resp.Body))
+		return
+	}
+
+	contentType := strings.ToLower(resp.Headers["Content-Type"])
+	changed := false
+	for _, t := range s.transforms {
+		if !strings.HasPrefix(contentType, t.contentTypePrefix) {
+			continue
+		}
+		body := t.fn(req, resp.Body)
+		if !bytes.Equal(body, resp.Body) {
+			resp.Body = body
+			changed = true
+		}
+	}
+
+	if changed {
+		if _, ok := resp.Headers["Content-Length"]; ok {
+			resp.Headers["Content-Length"] = strconv.Itoa(len(resp.Body))
+		}
+		delete(resp.Headers, "ETag")
+	}
+}
+
+// InjectBeforeTag returns a BodyTransformer that inserts snippet immediately
+// before the last occurrence of tag, matched case-insensitively. If the tag
+// is missing, the snippet is appended to the end of the body.
+func InjectBeforeTag(tag, snippet string) BodyTransformer {
+	lowerTag := []byte(strings.ToLower(tag))
+	return func(_ *Request, body []byte) []byte {
+		idx := bytes.LastIndex(bytes.ToLower(body), lowerTag)
+		if idx < 0 {
+			idx = len(body)
+		}
+
+		out := make([]byte, 0, len(body)+len(snippet))
+		out = append(out, body[:idx]...)
+		out = append(out, snippet...)
+		out = append(out, body[idx:]...)
+		return out
+	}
+}
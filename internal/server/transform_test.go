@@ -0,0 +1,73 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Abb133Se/httpServer/internal/config"
+)
+
+func TestInjectBeforeTag(t *testing.T) {
+	inject := InjectBeforeTag("</body>", "<script></script>")
+	for _, tt := range []struct{ body, want string }{
+		{"<html><body>hi</body></html>", "<html><body>hi<script></script></body></html>"},
+		{"<BODY>hi</BODY>", "<BODY>hi<script></script></BODY>"},
+		{"<p>a</body></p></body>", "<p>a</body></p><script></script></body>"},
+		{"no closing tag", "no closing tag<script></script>"},
+		{"", "<script></script>"},
+	} {
+		if got := string(inject(nil, []byte(tt.body))); got != tt.want {
+			t.Errorf("inject into %q = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestTransformBody(t *testing.T) {
+	const page = "<html><body>hi</body></html>"
+	router := NewRouter()
+	respond := func(headers map[string]string, body string) HandlerFunc {
+		return func(*Request) Response {
+			resp := textResponse(body)
+			for name, value := range headers {
+				resp.Headers[name] = value
+			}
+			return resp
+		}
+	}
+	router.Handle("/page", "GET", respond(map[string]string{"Content-Type": "text/html; charset=utf-8", "ETag": `"v1"`}, page))
+	router.Handle("/encoded", "GET", respond(map[string]string{"Content-Type": "text/html", "Content-Encoding": "gzip", "ETag": `"v1"`}, page))
+	router.Handle("/text", "GET", respond(map[string]string{"ETag": `"v1"`}, "</body>"))
+	router.Handle("/huge", "GET", respond(map[string]string{"Content-Type": "text/html"}, strings.Repeat("x", MaxTransformBodySize+1)))
+	srv := NewServer(config.LoadConfig(), router)
+	srv.TransformBody("text/html", InjectBeforeTag("</body>", "<b>1</b>"))
+	srv.TransformBody("TEXT/HTML", InjectBeforeTag("</body>", "<b>2</b>"))
+	addr := serve(t, srv)
+	c := dial(t, addr)
+	keepAlive := map[string]string{"Connection": "keep-alive", "Accept-Encoding": "gzip"}
+
+	resp := roundTrip(t, c, "GET", "/page", keepAlive, nil)
+	want := "<html><body>hi<b>1</b><b>2</b></body></html>"
+	if string(resp.Body) != want {
+		t.Errorf("transformed body = %q, want %q", resp.Body, want)
+	}
+	if got := resp.Header("Content-Length"); got != strconv.Itoa(len(want)) {
+		t.Errorf("Content-Length = %q, want %d", got, len(want))
+	}
+	if resp.Header("ETag") != "" {
+		t.Errorf("ETag %q kept for a transformed body", resp.Header("ETag"))
+	}
+
+	for _, tt := range []struct{ path, why string }{
+		{"/encoded", "encoded"},
+		{"/text", "not HTML"},
+	} {
+		resp := roundTrip(t, c, "GET", tt.path, keepAlive, nil)
+		if strings.Contains(string(resp.Body), "<b>") || resp.Header("ETag") != `"v1"` {
+			t.Errorf("%s response: body %q, ETag %q; want it untouched", tt.why, resp.Body, resp.Header("ETag"))
+		}
+	}
+	if resp := roundTrip(t, c, "GET", "/huge", keepAlive, nil); len(resp.Body) != MaxTransformBodySize+1 {
+		t.Errorf("oversized body is %d bytes, want it untouched", len(resp.Body))
+	}
+}
@@ -0,0 +1,329 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/clock"
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// Errors returned by Trash.Restore.
+var (
+	ErrTrashEntryNotFound = errors.New("trash entry not found")
+	ErrRestoreConflict    = errors.New("a file already exists at the original path")
+)
+
+// trashIDHeader names the trash entry of a soft-deleted file in the DELETE
+// response.
+const trashIDHeader = "X-Trash-Id"
+
+// trashMetaSuffix is appended to an entry ID to name its sidecar file.
+const trashMetaSuffix = ".json"
+
+// TrashEntry describes a soft-deleted file. It is stored as JSON in a
+// sidecar file next to the trashed file.
+type TrashEntry struct {
+	ID           string    `json:"id"`
+	OriginalPath string    `json:"original_path"`
+	DeletedAt    time.Time `json:"deleted_at"`
+	Size         int64     `json:"size"`
+
+	// Metadata is the file's metadata when it was deleted, restored with
+	// it, see MetadataStore.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Trash keeps deleted files for a retention period so they can be
+// restored. The trash directory must be on the same filesystem as the
+// files, since entries are moved in and out with a rename. It is safe for
+// concurrent use.
+type Trash struct {
+	mu    sync.Mutex
+	dir   string
+	ttl   time.Duration
+	clock clock.Clock
+	files *publicFiles // told about restored files, and holds their metadata
+}
+
+// NewTrash creates a Trash in dir, creating the directory if needed.
+// Entries older than ttl are removed by Purge; a ttl of 0 keeps them
+// forever.
+func NewTrash(dir string, ttl time.Duration) (*Trash, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create trash directory: %w", err)
+	}
+	return &Trash{dir: dir, ttl: ttl, clock: clock.Real, files: newPublicFiles()}, nil
+}
+
+// SetClock replaces the time source used to stamp and expire entries.
+func (t *Trash) SetClock(c clock.Clock) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clock = c
+}
+
+// Move moves the file at path into the trash, with its metadata, and
+// returns its entry.
+func (t *Trash) Move(path string) (TrashEntry, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	if info.IsDir() {
+		return TrashEntry{}, fmt.Errorf("%s is a directory", path)
+	}
+
+	now := t.clock.Now().UTC()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	entry := TrashEntry{
+		ID:           now.Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix),
+		OriginalPath: path,
+		DeletedAt:    now,
+		Size:         info.Size(),
+	}
+	entry.Metadata, err = t.files.meta.Get(path)
+	if err != nil {
+		return TrashEntry{}, err
+	}
+
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	if err := os.WriteFile(t.metaPath(entry.ID), meta, 0644); err != nil {
+		return TrashEntry{}, err
+	}
+	if err := os.Rename(path, t.dataPath(entry.ID)); err != nil {
+		os.Remove(t.metaPath(entry.ID))
+		return TrashEntry{}, err
+	}
+	if err := t.files.meta.Delete(path); err != nil {
+		utils.Warn("Failed to delete metadata of %s: %v", path, err)
+	}
+	Metrics.Counter("files_trashed_total").Inc()
+	return entry, nil
+}
+
+// List returns the entries in the trash, oldest first.
+func (t *Trash) List() ([]TrashEntry, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.list()
+}
+
+// list reads every sidecar in the trash directory. t.mu must be held.
+func (t *Trash) list() ([]TrashEntry, error) {
+	dirEntries, err := os.ReadDir(t.dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := []TrashEntry{}
+	for _, de := range dirEntries {
+		id, ok := strings.CutSuffix(de.Name(), trashMetaSuffix)
+		if !ok {
+			continue
+		}
+		entry, err := t.load(id)
+		if err != nil {
+			utils.Warn("Skipping unreadable trash entry %s: %v", id, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].DeletedAt.Before(entries[j].DeletedAt) })
+	return entries, nil
+}
+
+// Restore moves the entry id back to its original path. It fails with
+// ErrRestoreConflict rather than overwrite a file created there since.
+func (t *Trash) Restore(id string) (TrashEntry, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, err := t.load(id)
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	if _, err := os.Stat(entry.OriginalPath); err == nil {
+		return TrashEntry{}, ErrRestoreConflict
+	}
+	if err := os.MkdirAll(filepath.Dir(entry.OriginalPath), 0755); err != nil {
+		return TrashEntry{}, err
+	}
+	if err := os.Rename(t.dataPath(id), entry.OriginalPath); err != nil {
+		return TrashEntry{}, err
+	}
+	if err := t.files.meta.Put(entry.OriginalPath, entry.Metadata); err != nil {
+		utils.Warn("Failed to restore metadata of %s: %v", entry.OriginalPath, err)
+	}
+	os.Remove(t.metaPath(id))
+	t.files.forget(entry.OriginalPath)
+	Metrics.Counter("files_restored_total").Inc()
+	return entry, nil
+}
+
+// Purge permanently removes entries older than the retention period and
+// returns how many were removed.
+func (t *Trash) Purge() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ttl <= 0 {
+		return 0
+	}
+
+	entries, err := t.list()
+	if err != nil {
+		utils.Error("Failed to list trash: %v", err)
+		return 0
+	}
+	cutoff := t.clock.Now().Add(-t.ttl)
+	purged := 0
+	for _, entry := range entries {
+		if entry.DeletedAt.After(cutoff) {
+			continue
+		}
+		if err := os.Remove(t.dataPath(entry.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			utils.Error("Failed to purge trash entry %s: %v", entry.ID, err)
+			continue
+		}
+		os.Remove(t.metaPath(entry.ID))
+		purged++
+	}
+	if purged > 0 {
+		Metrics.Counter("files_trash_purged_total").Add(int64(purged))
+		utils.Info("Purged %d expired trash entries", purged)
+	}
+	return purged
+}
+
+// Run purges expired entries every interval until ctx is done.
+func (t *Trash) Run(ctx context.Context, interval time.Duration) {
+	for {
+		t.mu.Lock()
+		c := t.clock
+		t.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.After(interval):
+			t.Purge()
+		}
+	}
+}
+
+// load reads the sidecar of entry id. t.mu must be held.
+func (t *Trash) load(id string) (TrashEntry, error) {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return TrashEntry{}, ErrTrashEntryNotFound
+	}
+	data, err := os.ReadFile(t.metaPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return TrashEntry{}, ErrTrashEntryNotFound
+	}
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	var entry TrashEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return TrashEntry{}, err
+	}
+	return entry, nil
+}
+
+func (t *Trash) dataPath(id string) string { return filepath.Join(t.dir, id) }
+func (t *Trash) metaPath(id string) string { return filepath.Join(t.dir, id+trashMetaSuffix) }
+
+// trashSweepInterval is how often expired entries are looked for: a tenth
+// of the retention period, between a second and an hour.
+func trashSweepInterval(ttl time.Duration) time.Duration {
+	return min(max(ttl/10, time.Second), time.Hour)
+}
+
+// SoftDelete wraps a "/files/" handler so that DELETE moves the file into
+// trash instead of removing it. The response names the entry in the
+// X-Trash-Id header. Other methods are passed to next.
+func SoftDelete(trash *Trash, next HandlerFunc) HandlerFunc {
+	return func(req *Request) Response {
+		if !strings.EqualFold(req.Method, "DELETE") {
+			return next(req)
+		}
+		filePath, errResp := publicFilePath(req)
+		if errResp != nil {
+			return *errResp
+		}
+		unlock, errResp := trash.files.lockWrite(filePath)
+		if errResp != nil {
+			return *errResp
+		}
+		defer unlock()
+		if resp := checkWritePreconditions(req, filePath); resp != nil {
+			return *resp
+		}
+		entry, err := trash.Move(filePath)
+		trash.files.forget(filePath)
+		if err != nil {
+			utils.Error("Failed to move file to trash: %s, error: %v", filePath, err)
+			return NotFoundResponse()
+		}
+		utils.Info("Moved file %s to trash as %s", filePath, entry.ID)
+		return Response{
+			Version: HTTPVersion,
+			Status:  204,
+			Reason:  "No Content",
+			Headers: map[string]string{trashIDHeader: entry.ID},
+		}
+	}
+}
+
+// handleAdminTrash handles "/admin/trash", listing the trash as JSON,
+// oldest first. It lists every entry unless offset or limit ask for a
+// page, see Pagination.
+func (s *Server) handleAdminTrash(req *Request) Response {
+	pages, err := ParsePagination(req, 0, 0)
+	if err != nil {
+		return BadRequestErrorResponse(err)
+	}
+	entries, err := s.trash.List()
+	if err != nil {
+		utils.Error("Failed to list trash: %v", err)
+		return InternalServerErrorResponse()
+	}
+	pages.Total = len(entries)
+	start, end := pages.Page()
+	resp := jsonNoStore(entries[start:end])
+	pages.Apply(req, &resp)
+	return resp
+}
+
+// handleAdminTrashRestore handles "/admin/trash/:id/restore", moving the
+// entry back to where it was deleted from.
+func (s *Server) handleAdminTrashRestore(req *Request) Response {
+	entry, err := s.trash.Restore(req.Params["id"])
+	switch {
+	case errors.Is(err, ErrTrashEntryNotFound):
+		return NotFoundResponse()
+	case errors.Is(err, ErrRestoreConflict):
+		return NewHTTPError(409, err.Error()).Response()
+	case err != nil:
+		utils.Error("Failed to restore trash entry %s: %v", req.Params["id"], err)
+		return InternalServerErrorResponse()
+	}
+	utils.Info("Restored trash entry %s to %s", entry.ID, entry.OriginalPath)
+	return jsonNoStore(entry)
+}
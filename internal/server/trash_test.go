@@ -0,0 +1,177 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/clock"
+)
+
+// newTestTrash returns a trash with a manual clock and a directory of
+// files to delete, on the same filesystem.
+func newTestTrash(t *testing.T, ttl time.Duration) (*Trash, *clock.Manual, string) {
+	t.Helper()
+	root := t.TempDir()
+	trash, err := NewTrash(filepath.Join(root, "trash"), ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := clock.NewManual(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))
+	trash.SetClock(fake)
+	files := filepath.Join(root, "files")
+	if err := os.Mkdir(files, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return trash, fake, files
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTrashMoveNameCollision(t *testing.T) {
+	trash, _, files := newTestTrash(t, 0)
+	path := filepath.Join(files, "a.txt")
+
+	// The same path deleted twice at the same instant still gets two
+	// entries, neither overwriting the other.
+	var entries []TrashEntry
+	for _, content := range []string{"first", "second"} {
+		writeTestFile(t, path, content)
+		entry, err := trash.Move(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s after Move: %v, want it gone", path, err)
+		}
+		entries = append(entries, entry)
+	}
+	if entries[0].ID == entries[1].ID {
+		t.Fatalf("both deletions got ID %s", entries[0].ID)
+	}
+	for i, content := range []string{"first", "second"} {
+		data, err := os.ReadFile(trash.dataPath(entries[i].ID))
+		if err != nil || string(data) != content {
+			t.Errorf("trashed %s = %q, %v; want %q", entries[i].ID, data, err, content)
+		}
+		if entries[i].OriginalPath != path || entries[i].Size != int64(len(content)) {
+			t.Errorf("entry %+v, want original path %s and size %d", entries[i], path, len(content))
+		}
+	}
+
+	listed, err := trash.List()
+	if err != nil || len(listed) != 2 {
+		t.Fatalf("List = %v, %v; want both entries", listed, err)
+	}
+}
+
+func TestTrashRestore(t *testing.T) {
+	trash, fake, files := newTestTrash(t, 0)
+	path := filepath.Join(files, "sub", "a.txt")
+	if err := os.Mkdir(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, path, "old")
+	old, err := trash.Move(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.Advance(time.Second)
+	writeTestFile(t, path, "new")
+	newer, err := trash.Move(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listed, _ := trash.List(); len(listed) != 2 || listed[0].ID != old.ID {
+		t.Errorf("List = %v, want the older entry first", listed)
+	}
+
+	// The directory is gone too; Restore recreates it.
+	os.Remove(filepath.Dir(path))
+	if _, err := trash.Restore(old.ID); err != nil {
+		t.Fatalf("Restore(%s) = %v", old.ID, err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "old" {
+		t.Errorf("restored file = %q, %v; want %q", data, err, "old")
+	}
+	if _, err := trash.Restore(newer.ID); !errors.Is(err, ErrRestoreConflict) {
+		t.Errorf("Restore over an existing file = %v, want ErrRestoreConflict", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "old" {
+		t.Errorf("file after the refused restore = %q, want it untouched", data)
+	}
+	for _, id := range []string{old.ID, "", "../" + newer.ID, ".", newer.ID + trashMetaSuffix} {
+		if _, err := trash.Restore(id); !errors.Is(err, ErrTrashEntryNotFound) {
+			t.Errorf("Restore(%q) = %v, want ErrTrashEntryNotFound", id, err)
+		}
+	}
+}
+
+func TestTrashPurge(t *testing.T) {
+	trash, fake, files := newTestTrash(t, time.Hour)
+	path := filepath.Join(files, "a.txt")
+	writeTestFile(t, path, "a")
+	expired, _ := trash.Move(path)
+	fake.Advance(30 * time.Minute)
+	writeTestFile(t, path, "b")
+	kept, _ := trash.Move(path)
+
+	fake.Advance(30 * time.Minute)
+	if n := trash.Purge(); n != 1 {
+		t.Fatalf("Purge = %d, want the hour-old entry only", n)
+	}
+	if _, err := os.Stat(trash.dataPath(expired.ID)); !os.IsNotExist(err) {
+		t.Errorf("purged entry: %v, want it removed", err)
+	}
+	if listed, _ := trash.List(); len(listed) != 1 || listed[0].ID != kept.ID {
+		t.Errorf("List after Purge = %v, want only %s", listed, kept.ID)
+	}
+}
+
+func TestServeSoftDelete(t *testing.T) {
+	public := chdirPublic(t)
+	t.Setenv("FILES_TRASH_DIR", filepath.Join(filepath.Dir(public), "trash"))
+	t.Setenv("ADMIN_TOKEN", "secret")
+	_, addr := startServer(t)
+	admin := map[string]string{"Authorization": "Bearer secret"}
+
+	var ids []string
+	for _, content := range []string{"first", "second"} {
+		writeTestFile(t, filepath.Join(public, "a.txt"), content)
+		resp := roundTrip(t, dial(t, addr), "DELETE", "/files/a.txt", nil, nil)
+		if resp.Status != 204 || resp.Header(trashIDHeader) == "" {
+			t.Fatalf("DELETE /files/a.txt = %d %s %q, want 204 naming the trash entry", resp.Status, trashIDHeader, resp.Header(trashIDHeader))
+		}
+		ids = append(ids, resp.Header(trashIDHeader))
+		if resp := roundTrip(t, dial(t, addr), "GET", "/files/a.txt", nil, nil); resp.Status != 404 {
+			t.Errorf("GET after DELETE = %d, want 404", resp.Status)
+		}
+	}
+	if ids[0] == ids[1] {
+		t.Fatalf("both deletions got trash ID %s", ids[0])
+	}
+
+	resp := roundTrip(t, dial(t, addr), "GET", "/admin/trash", admin, nil)
+	var listed []TrashEntry
+	if err := json.Unmarshal(resp.Body, &listed); err != nil || len(listed) != 2 {
+		t.Fatalf("GET /admin/trash = %s (%v), want both entries", resp.Body, err)
+	}
+
+	if resp := roundTrip(t, dial(t, addr), "POST", "/admin/trash/"+ids[0]+"/restore", admin, nil); resp.Status != 200 {
+		t.Fatalf("restore = %d %s, want 200", resp.Status, resp.Body)
+	}
+	if resp := roundTrip(t, dial(t, addr), "GET", "/files/a.txt", nil, nil); resp.Status != 200 || string(resp.Body) != "first" {
+		t.Errorf("GET after restore = %d %q, want the first version", resp.Status, resp.Body)
+	}
+	if resp := roundTrip(t, dial(t, addr), "POST", "/admin/trash/"+ids[1]+"/restore", admin, nil); resp.Status != 409 {
+		t.Errorf("restoring over the restored file = %d, want 409", resp.Status)
+	}
+}
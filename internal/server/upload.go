@@ -0,0 +1,322 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Abb133Se/httpServer/internal/clock"
+	"github.com/Abb133Se/httpServer/internal/utils"
+)
+
+// defaultProgressInterval is how often, in bytes, CopyBodyToFile reports
+// progress unless CopyOptions.ProgressInterval says otherwise.
+const defaultProgressInterval = 64 * 1024
+
+// ErrUploadAborted is wrapped by the error CopyBodyToFile returns when a
+// progress callback or the minimum rate policy stops an upload.
+var ErrUploadAborted = errors.New("upload aborted")
+
+// UploadProgress is passed to CopyOptions.Progress as an upload advances.
+type UploadProgress struct {
+	Written  int64 // bytes written so far
+	Total    int64 // declared Content-Length, or -1 if unknown
+	Elapsed  time.Duration
+	BytesPer float64 // average rate in bytes per second
+}
+
+// CopyOptions controls CopyBodyToFile.
+type CopyOptions struct {
+	// ProgressInterval is the number of bytes between Progress calls.
+	// Zero means 64KB.
+	ProgressInterval int64
+
+	// Progress, if set, is called every ProgressInterval bytes and once at
+	// the end. Returning an error aborts the upload; the destination is
+	// left untouched.
+	Progress func(UploadProgress) error
+
+	// MinRate aborts uploads averaging fewer bytes per second once
+	// MinRateGrace has passed. Zero disables the check.
+	MinRate      float64
+	MinRateGrace time.Duration
+
+	// Perm is the mode of the written file. Zero means 0644.
+	Perm os.FileMode
+
+	// Clock times the upload for progress and MinRate. Nil means
+	// clock.Real.
+	Clock clock.Clock
+}
+
+// CopyResult describes a completed upload.
+type CopyResult struct {
+	Bytes    int64
+	SHA256   string // hex-encoded digest of the written content
+	Duration time.Duration
+	Created  bool // dst did not exist before
+}
+
+// CopyBodyToFile writes the request body to dst atomically: the data goes
+// to a temporary file in the same directory, which is synced and renamed
+// over dst only once the whole body has arrived and matches the declared
+// Content-Length. On any failure dst is unchanged and the temporary file is
+// removed. The body is read through req.BodyReader, so on a route marked
+// with StreamBody progress is reported, and MinRate applied, while the
+// client is still sending it.
+//
+// Returns:
+//   - CopyResult: size, SHA-256 digest and duration of the upload.
+//   - error: An *HTTPError with status 400 if the body is shorter or longer
+//     than Content-Length, 408 if the client stopped sending it before the
+//     read timeout, 507 if the disk filled up, an error wrapping
+//     ErrUploadAborted if the progress callback or MinRate stopped the
+//     upload, or the underlying filesystem or connection error.
+//
+// Example:
+//
+//	result, err := CopyBodyToFile(req, path, CopyOptions{
+//	    Progress: func(p UploadProgress) error {
+//	        utils.Debug("%d/%d bytes", p.Written, p.Total)
+//	        return nil
+//	    },
+//	})
+func CopyBodyToFile(req *Request, dst string, opts CopyOptions) (CopyResult, error) {
+	total := int64(-1)
+	if value, ok := req.Headers["content-length"]; ok {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+			total = n
+		}
+	}
+	return copyToFile(req.BodyReader(), total, dst, opts)
+}
+
+// copyToFile is CopyBodyToFile for the body read from src, which is to be
+// total bytes long, or any length if total is -1.
+func copyToFile(src io.Reader, total int64, dst string, opts CopyOptions) (CopyResult, error) {
+	if opts.ProgressInterval <= 0 {
+		opts.ProgressInterval = defaultProgressInterval
+	}
+	if opts.Perm == 0 {
+		opts.Perm = 0644
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real
+	}
+
+	_, statErr := os.Stat(dst)
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return CopyResult{}, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	digest := sha256.New()
+	pw := &progressWriter{
+		w:        io.MultiWriter(tmp, digest),
+		opts:     opts,
+		total:    total,
+		start:    opts.Clock.Now(),
+		nextTick: opts.ProgressInterval,
+	}
+	written, err := io.Copy(pw, src)
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		// The client closed its side early; reported as too short below.
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		utils.Warn("Upload to %s timed out after %d bytes", dst, written)
+		return CopyResult{}, NewHTTPError(408, "the request body was not received in time")
+	case err != nil:
+		if errors.Is(err, ErrUploadAborted) {
+			Metrics.Counter("uploads_aborted_total").Inc()
+		}
+		return CopyResult{}, storageError(err)
+	}
+	if total >= 0 && written != total {
+		Metrics.Counter("uploads_length_mismatch_total").Inc()
+		return CopyResult{}, NewHTTPError(400, fmt.Sprintf("body has %d bytes, Content-Length declared %d", written, total))
+	}
+	if err := pw.report(); err != nil {
+		Metrics.Counter("uploads_aborted_total").Inc()
+		return CopyResult{}, err
+	}
+
+	if err := tmp.Chmod(opts.Perm); err != nil {
+		return CopyResult{}, err
+	}
+	// Filesystems that allocate lazily may only report a full disk now.
+	if err := tmp.Sync(); err != nil {
+		return CopyResult{}, storageError(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return CopyResult{}, storageError(err)
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return CopyResult{}, err
+	}
+	committed = true
+
+	result := CopyResult{
+		Bytes:    written,
+		SHA256:   hexDigest(digest),
+		Duration: clock.Since(opts.Clock, pw.start),
+		Created:  os.IsNotExist(statErr),
+	}
+	utils.Debug("Wrote %d bytes to %s in %v (sha256 %s)", result.Bytes, dst, result.Duration, result.SHA256)
+	return result, nil
+}
+
+// progressWriter counts bytes on their way to the destination and applies
+// the progress callback and minimum rate policy.
+type progressWriter struct {
+	w        io.Writer
+	opts     CopyOptions
+	total    int64
+	start    time.Time
+	written  int64
+	nextTick int64
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.written += int64(n)
+	if err != nil {
+		return n, err
+	}
+	if p.written < p.nextTick {
+		return n, nil
+	}
+	// One large write may cross several intervals; report it once.
+	p.nextTick = (p.written/p.opts.ProgressInterval + 1) * p.opts.ProgressInterval
+	return n, p.report()
+}
+
+// report invokes the progress callback and checks the minimum rate.
+func (p *progressWriter) report() error {
+	elapsed := clock.Since(p.opts.Clock, p.start)
+	progress := UploadProgress{Written: p.written, Total: p.total, Elapsed: elapsed}
+	if elapsed > 0 {
+		progress.BytesPer = float64(p.written) / elapsed.Seconds()
+	}
+
+	if p.opts.MinRate > 0 && elapsed > p.opts.MinRateGrace && progress.BytesPer < p.opts.MinRate {
+		return fmt.Errorf("%w: %.0f bytes/s is below the minimum of %.0f", ErrUploadAborted, progress.BytesPer, p.opts.MinRate)
+	}
+	if p.opts.Progress != nil {
+		if err := p.opts.Progress(progress); err != nil {
+			return fmt.Errorf("%w: %v", ErrUploadAborted, err)
+		}
+	}
+	return nil
+}
+
+func hexDigest(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// StreamBody has the route's handler read request bodies sent with a
+// Content-Length from the connection as they arrive, through
+// Request.BodyReader, rather than the server reading them into Body
+// before calling it. CopyBodyToFile then writes an upload to disk, and
+// reports its progress, while it is still being received, and without
+// holding it in memory. Chunked bodies, and bodies the method's body
+// policy drains or refuses, are still read up front.
+//
+// What the handler leaves unread of a body is drained after it returns
+// if it is no more than 64KB; otherwise the connection is closed after the
+// response.
+func (rt *Route) StreamBody() *Route {
+	rt.streamBody = true
+	return rt
+}
+
+// BodyReader returns a reader for the request body. On a route marked
+// with StreamBody it reads the body from the connection, can be read only
+// once, and fails with io.ErrUnexpectedEOF if the client closes the
+// connection before sending all of it. Otherwise it reads Body.
+func (r *Request) BodyReader() io.Reader {
+	if r.bodyStream != nil {
+		return r.bodyStream
+	}
+	return bytes.NewReader(r.Body)
+}
+
+// bodyStream reads a body of known length from the connection for
+// BodyReader.
+type bodyStream struct {
+	r         io.Reader
+	remaining int64
+	err       error // ended the body early, returned by later reads
+}
+
+func (b *bodyStream) Read(p []byte) (int, error) {
+	if b.remaining == 0 {
+		return 0, io.EOF
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.r.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining == 0 {
+		return n, nil
+	}
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	b.err = err
+	return n, err
+}
+
+// finish drains what the handler left of the body if that is no more than
+// defaultBodyLimit bytes, and reports whether the whole body has been read,
+// so the connection can go on to the next request.
+func (b *bodyStream) finish() bool {
+	if b.remaining > 0 && b.remaining <= defaultBodyLimit && b.err == nil {
+		io.Copy(io.Discard, b)
+	}
+	return b.remaining == 0
+}
+
+// openRequestBody readies req's body to be streamed to its handler when
+// the route it was routed to is marked with StreamBody, applying the body
+// policy and sending 100 Continue as readRequestBody would. It reports
+// false when the body is to be read by readRequestBody instead: when the
+// route does not stream, the body is chunked or empty, or the policy does
+// not simply allow it.
+func openRequestBody(reader *bufio.Reader, w io.Writer, req *Request, bodyPolicies map[string]BodyPolicy) (bool, error) {
+	if req.route == nil || !req.route.streamBody || req.isChunked() {
+		return false, nil
+	}
+	n, err := req.declaredLength()
+	if err != nil || n == 0 {
+		return false, nil
+	}
+	if policy, err := checkBodyPolicy(req.Method, n, bodyPolicies); err != nil || policy.Action != BodyAllow {
+		return false, nil
+	}
+	if err := sendContinue(w, req); err != nil {
+		return false, err
+	}
+	req.bodyStream = &bodyStream{r: reader, remaining: n}
+	utils.Debug("Streaming %d byte body of %s %s to its handler", n, req.Method, req.Path)
+	return true, nil
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// uploadRequest returns a PUT request carrying body and declaring length
+// bytes.
+func uploadRequest(body []byte, length int) *Request {
+	return &Request{
+		Method:  "PUT",
+		Path:    "/upload",
+		Headers: map[string]string{"content-length": strconv.Itoa(length)},
+		Body:    body,
+	}
+}
+
+// leftovers returns the names of the files in dir other than keep.
+func leftovers(t *testing.T, dir, keep string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Name() != keep {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
+
+func TestCopyBodyToFileChecksum(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "upload.bin")
+	body := bytes.Repeat([]byte("0123456789"), 10000)
+
+	var reports []int64
+	result, err := CopyBodyToFile(uploadRequest(body, len(body)), dst, CopyOptions{
+		ProgressInterval: 16 << 10,
+		Progress: func(p UploadProgress) error {
+			reports = append(reports, p.Written)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(written)
+	if result.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("SHA256 = %s, want the digest of the written file %x", result.SHA256, sum)
+	}
+	if !bytes.Equal(written, body) || result.Bytes != int64(len(body)) || !result.Created {
+		t.Errorf("wrote %d bytes (result %+v), want the %d byte body in a new file", len(written), result, len(body))
+	}
+	if len(reports) == 0 || reports[len(reports)-1] != int64(len(body)) {
+		t.Errorf("progress reports %v, want the last at %d bytes", reports, len(body))
+	}
+	if names := leftovers(t, dir, "upload.bin"); len(names) != 0 {
+		t.Errorf("temporary files left behind: %v", names)
+	}
+}
+
+func TestCopyBodyToFileAbortsHalfway(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "upload.bin")
+	if err := os.WriteFile(dst, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	body := bytes.Repeat([]byte("a"), 64<<10)
+
+	var aborted int64
+	_, err := CopyBodyToFile(uploadRequest(body, len(body)), dst, CopyOptions{
+		ProgressInterval: 1024,
+		Progress: func(p UploadProgress) error {
+			if p.Written >= p.Total/2 {
+				aborted = p.Written
+				return errors.New("enough")
+			}
+			return nil
+		},
+	})
+	if !errors.Is(err, ErrUploadAborted) {
+		t.Fatalf("CopyBodyToFile = %v, want ErrUploadAborted", err)
+	}
+	if aborted < int64(len(body))/2 {
+		t.Errorf("aborted at %d bytes, want at least half of %d", aborted, len(body))
+	}
+	if data, err := os.ReadFile(dst); err != nil || string(data) != "old" {
+		t.Errorf("destination after abort = %q, %v; want it unchanged", data, err)
+	}
+	if names := leftovers(t, dir, "upload.bin"); len(names) != 0 {
+		t.Errorf("temporary files left behind: %v", names)
+	}
+}
+
+func TestCopyBodyToFileLengthMismatch(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "upload.bin")
+
+	_, err := CopyBodyToFile(uploadRequest(bytes.Repeat([]byte("a"), 1000), 4096), dst, CopyOptions{})
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.Status != 400 {
+		t.Fatalf("1000 of 4096 bytes = %v, want 400", err)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("destination after a short body: %v, want it never created", err)
+	}
+	if names := leftovers(t, dir, ""); len(names) != 0 {
+		t.Errorf("temporary files left behind: %v", names)
+	}
+}
+
+// startUploadServer serves PUT /upload, a streamed route writing its body
+// to a file in a fresh directory with progress every KB, which it returns
+// with the server's address. Each progress report is sent to progress, and
+// the upload is aborted with 499 once abort returns true.
+func startUploadServer(t *testing.T, progress chan<- int64, abort func(written int64) bool) (string, string) {
+	t.Helper()
+	dst := filepath.Join(t.TempDir(), "upload.bin")
+	_, addr := startServerWithRoutes(t, func(r *Router) {
+		r.Handle("/upload", "PUT", func(req *Request) Response {
+			result, err := CopyBodyToFile(req, dst, CopyOptions{
+				ProgressInterval: 1024,
+				Progress: func(p UploadProgress) error {
+					progress <- p.Written
+					if abort(p.Written) {
+						return errors.New("enough")
+					}
+					return nil
+				},
+			})
+			var httpErr *HTTPError
+			switch {
+			case errors.Is(err, ErrUploadAborted):
+				return Response{Version: HTTPVersion, Status: 499, Reason: "Aborted", Headers: map[string]string{}}
+			case errors.As(err, &httpErr):
+				return httpErr.Response()
+			case err != nil:
+				return InternalServerErrorResponse()
+			}
+			return textResponse(fmt.Sprint(result.Bytes))
+		}).StreamBody()
+	})
+	return dst, addr
+}
+
+// uploadHead returns the head of a PUT /upload declaring length bytes.
+func uploadHead(length int) []byte {
+	return fmt.Appendf(nil, "PUT /upload HTTP/1.1\r\nHost: x\r\nContent-Length: %d\r\n\r\n", length)
+}
+
+func TestCopyBodyToFileStreams(t *testing.T) {
+	progress := make(chan int64, 64)
+	dst, addr := startUploadServer(t, progress, func(int64) bool { return false })
+	c := dial(t, addr)
+
+	const size = 8 << 10
+	if err := c.SendRaw(append(uploadHead(size), bytes.Repeat([]byte("a"), 2048)...)); err != nil {
+		t.Fatal(err)
+	}
+	// The handler reports progress on the first half while the client
+	// still holds back the rest.
+	select {
+	case written := <-progress:
+		if written >= size {
+			t.Fatalf("first progress at %d bytes, want it before the body is complete", written)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no progress before the body was complete")
+	}
+	if err := c.SendRaw(bytes.Repeat([]byte("a"), size-2048)); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.ReadResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != 200 || string(resp.Body) != fmt.Sprint(size) {
+		t.Fatalf("PUT = %d %q, want 200 %d", resp.Status, resp.Body, size)
+	}
+	if info, err := os.Stat(dst); err != nil || info.Size() != size {
+		t.Errorf("stored file = %v, %v; want %d bytes", info, err, size)
+	}
+}
+
+func TestCopyBodyToFileAbortsMidUpload(t *testing.T) {
+	progress := make(chan int64, 64)
+	dst, addr := startUploadServer(t, progress, func(written int64) bool { return written >= 1024 })
+	c := dial(t, addr)
+
+	const size = 1 << 20
+	if err := c.SendRaw(append(uploadHead(size), bytes.Repeat([]byte("a"), 4096)...)); err != nil {
+		t.Fatal(err)
+	}
+	// The rest of the body is never sent: the answer must not wait for it.
+	resp, err := c.ReadResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != 499 {
+		t.Fatalf("aborted PUT = %d, want the handler's 499", resp.Status)
+	}
+	if written := <-progress; written >= size {
+		t.Errorf("aborted at %d bytes, want before the body was complete", written)
+	}
+	if got := resp.Header("Connection"); got != "close" {
+		t.Errorf("Connection = %q, want close with the body unread", got)
+	}
+	if err := c.ExpectClose(); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("destination after abort: %v, want it never created", err)
+	}
+}
+
+func TestCopyBodyToFileShortBody(t *testing.T) {
+	progress := make(chan int64, 64)
+	dst, addr := startUploadServer(t, progress, func(int64) bool { return false })
+	c := dial(t, addr)
+
+	if err := c.SendRaw(append(uploadHead(4096), bytes.Repeat([]byte("a"), 1000)...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.ReadResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != 400 {
+		t.Errorf("PUT of 1000 of 4096 bytes = %d, want 400", resp.Status)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("destination after short body: %v, want it never created", err)
+	}
+}
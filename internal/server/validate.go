@@ -0,0 +1,168 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Validation errors reported by Router.Validate. Each problem found is
+// wrapped around one of these so callers can test for it with errors.Is.
+var (
+	ErrPrefixNoTrailingSlash = errors.New("prefix route without trailing slash")
+	ErrDuplicateParam        = errors.New("duplicate path parameter")
+	ErrUnanchoredRegex       = errors.New("regex route not anchored")
+	ErrUnmatchableRegex      = errors.New("regex route can never match")
+	ErrGroupPrefix           = errors.New("group prefix must start with /")
+	ErrShadowedRoute         = errors.New("route shadowed by earlier route")
+	ErrUnknownMethod         = errors.New("route method not registered")
+	ErrOptionalParam         = errors.New("optional path parameter not last")
+	ErrParamDefault          = errors.New("default for a parameter that is not optional")
+	ErrWildcardNotLast       = errors.New("wildcard path segment not last")
+)
+
+// Validate lints the registered routes for common misconfigurations.
+//
+// Checks performed:
+//   - Prefix routes must end in "/" so "/files" does not also match "/filesystem".
+//   - Parameterized routes must not repeat a parameter name (e.g. /a/:id/b/:id).
+//   - Only the last segment may be an optional parameter (":name?"), and
+//     ParamDefault may only name that parameter.
+//   - Only the last segment may be a wildcard ("*name").
+//   - Regex routes must be anchored with "^" and the anchor must be followed
+//     by "/" (request paths always start with a slash).
+//   - Group prefixes must start with "/".
+//   - In strict method mode, route methods must be standard or registered
+//     with RegisterMethod, or the route could never be reached.
+//   - Routes must not be unreachable because an earlier prefix or regex route
+//     with the same method already matches them.
+//
+// Returns:
+//   - error: nil if the routes are valid, otherwise an error joining every
+//     problem found.
+func (r *Router) Validate() error {
+	var problems []error
+
+	for i, route := range r.routes {
+		switch {
+		case route.regex != nil:
+			if !strings.HasPrefix(route.pattern, "^") {
+				problems = append(problems, fmt.Errorf("%w: %q should start with ^", ErrUnanchoredRegex, route.pattern))
+			} else if rest := route.pattern[1:]; !strings.HasPrefix(rest, "/") && !strings.HasPrefix(rest, `\/`) {
+				problems = append(problems, fmt.Errorf("%w: %q does not begin with / after ^", ErrUnmatchableRegex, route.pattern))
+			}
+		case route.isPrefix:
+			if !strings.HasSuffix(route.pattern, "/") {
+				problems = append(problems, fmt.Errorf("%w: %s %q", ErrPrefixNoTrailingSlash, route.method, route.pattern))
+			}
+		}
+
+		if r.methodMode == MethodsStrict && route.method != "" && !r.knownMethod(route.method) {
+			problems = append(problems, fmt.Errorf("%w: %s %q", ErrUnknownMethod, route.method, route.pattern))
+		}
+
+		if name := duplicateParam(route.pattern); name != "" {
+			problems = append(problems, fmt.Errorf("%w: %q repeats :%s", ErrDuplicateParam, route.pattern, name))
+		}
+
+		problems = append(problems, optionalParamProblems(route)...)
+
+		if route.regex == nil && !route.isPrefix {
+			if err := wildcardProblem(route.pattern); err != nil {
+				problems = append(problems, err)
+			}
+		}
+
+		if route.regex == nil {
+			if earlier := r.shadowingRoute(i); earlier != nil {
+				problems = append(problems, fmt.Errorf("%w: %s %q is unreachable behind %q",
+					ErrShadowedRoute, route.method, route.pattern, earlier.pattern))
+			}
+		}
+	}
+
+	for _, group := range r.groups {
+		if !strings.HasPrefix(group.prefix, "/") {
+			problems = append(problems, fmt.Errorf("%w: %q", ErrGroupPrefix, group.prefix))
+		}
+	}
+
+	return errors.Join(problems...)
+}
+
+// shadowingRoute returns the first route registered before r.routes[i]
+// that would always be selected instead of it, or nil if there is none.
+func (r *Router) shadowingRoute(i int) *Route {
+	route := r.routes[i]
+	for _, earlier := range r.routes[:i] {
+		if earlier.method != "" && earlier.method != route.method {
+			continue
+		}
+		switch {
+		case earlier.isPrefix:
+			if strings.HasPrefix(route.pattern, earlier.pattern) {
+				return earlier
+			}
+		case earlier.regex != nil:
+			if !route.isPrefix && !hasParams(route.pattern) && earlier.regex.MatchString(route.pattern) {
+				return earlier
+			}
+		}
+	}
+	return nil
+}
+
+// duplicateParam returns the first parameter name that appears more than
+// once in a route pattern, or "" if all names are unique.
+func duplicateParam(pattern string) string {
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(pattern, "/") {
+		name, ok := wildcardParam(part)
+		if !ok {
+			if !strings.HasPrefix(part, ":") {
+				continue
+			}
+			name = strings.TrimSuffix(part[1:], "?")
+		}
+		if seen[name] {
+			return name
+		}
+		seen[name] = true
+	}
+	return ""
+}
+
+// optionalParamProblems checks the placement of route's optional parameter
+// and that its defaults name it.
+func optionalParamProblems(route *Route) []error {
+	if route.regex != nil || route.isPrefix {
+		return nil
+	}
+	var problems []error
+	parts := strings.Split(route.pattern, "/")
+	for _, part := range parts[:len(parts)-1] {
+		if _, ok := optionalParam(part); ok {
+			problems = append(problems, fmt.Errorf("%w: %q", ErrOptionalParam, route.pattern))
+			break
+		}
+	}
+	optional, _ := optionalParam(parts[len(parts)-1])
+	for name := range route.defaults {
+		if name != optional {
+			problems = append(problems, fmt.Errorf("%w: %q has a default for :%s", ErrParamDefault, route.pattern, name))
+		}
+	}
+	return problems
+}
+
+// wildcardProblem returns a wrapped ErrWildcardNotLast if a segment of
+// pattern other than the last is a wildcard, or nil.
+func wildcardProblem(pattern string) error {
+	parts := strings.Split(pattern, "/")
+	for _, part := range parts[:len(parts)-1] {
+		if _, ok := wildcardParam(part); ok {
+			return fmt.Errorf("%w: %q", ErrWildcardNotLast, pattern)
+		}
+	}
+	return nil
+}
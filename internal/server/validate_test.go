@@ -0,0 +1,101 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRouterValidate(t *testing.T) {
+	handler := func(*Request) Response { return Response{Status: 200} }
+
+	for _, tt := range []struct {
+		name     string
+		register func(r *Router)
+		want     error
+		message  string
+	}{
+		{"prefix without slash", func(r *Router) {
+			r.HandlePrefix("/files", "GET", handler)
+		}, ErrPrefixNoTrailingSlash, `prefix route without trailing slash: GET "/files"`},
+		{"duplicate param", func(r *Router) {
+			r.Handle("/a/:id/b/:id", "GET", handler)
+		}, ErrDuplicateParam, `duplicate path parameter: "/a/:id/b/:id" repeats :id`},
+		{"duplicate wildcard name", func(r *Router) {
+			r.Handle("/a/:path/*path", "GET", handler)
+		}, ErrDuplicateParam, `duplicate path parameter: "/a/:path/*path" repeats :path`},
+		{"unanchored regex", func(r *Router) {
+			r.HandleRegex(`/users/\d+$`, handler)
+		}, ErrUnanchoredRegex, `regex route not anchored: "/users/\\d+$" should start with ^`},
+		{"regex without leading slash", func(r *Router) {
+			r.HandleRegex(`^users/\d+$`, handler)
+		}, ErrUnmatchableRegex, `regex route can never match: "^users/\\d+$" does not begin with / after ^`},
+		{"group prefix", func(r *Router) {
+			r.Group("api").Handle("/x", handler)
+		}, ErrGroupPrefix, `group prefix must start with /: "api"`},
+		{"shadowed by prefix", func(r *Router) {
+			r.HandlePrefix("/static/", "GET", handler)
+			r.Handle("/static/logo.png", "GET", handler)
+		}, ErrShadowedRoute, `route shadowed by earlier route: GET "/static/logo.png" is unreachable behind "/static/"`},
+		{"shadowed by regex", func(r *Router) {
+			r.HandleRegex(`^/users/.*$`, handler)
+			r.Handle("/users/me", "GET", handler)
+		}, ErrShadowedRoute, `route shadowed by earlier route: GET "/users/me" is unreachable behind "^/users/.*$"`},
+		{"unknown method in strict mode", func(r *Router) {
+			r.SetMethodMode(MethodsStrict)
+			r.Handle("/x", "BREW", handler)
+		}, ErrUnknownMethod, `route method not registered: BREW "/x"`},
+		{"optional param not last", func(r *Router) {
+			r.Handle("/a/:id?/b", "GET", handler)
+		}, ErrOptionalParam, `optional path parameter not last: "/a/:id?/b"`},
+		{"default for a required param", func(r *Router) {
+			r.Handle("/a/:id", "GET", handler).ParamDefault("id", "1")
+		}, ErrParamDefault, `default for a parameter that is not optional: "/a/:id" has a default for :id`},
+		{"wildcard not last", func(r *Router) {
+			r.Handle("/a/*rest/b", "GET", handler)
+		}, ErrWildcardNotLast, `wildcard path segment not last: "/a/*rest/b"`},
+	} {
+		r := NewRouter()
+		tt.register(r)
+		err := r.Validate()
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: Validate() = %v, want %v", tt.name, err, tt.want)
+			continue
+		}
+		if got := err.Error(); got != tt.message {
+			t.Errorf("%s: message = %q, want %q", tt.name, got, tt.message)
+		}
+	}
+}
+
+func TestRouterValidateJoinsProblems(t *testing.T) {
+	handler := func(*Request) Response { return Response{Status: 200} }
+	r := NewRouter()
+	r.HandlePrefix("/files", "GET", handler)
+	r.Handle("/a/:id/b/:id", "GET", handler)
+	r.Handle("/static/:name?", "GET", handler).ParamDefault("name", "index.html")
+	r.HandlePrefix("/static/", "GET", handler)
+
+	err := r.Validate()
+	if !errors.Is(err, ErrPrefixNoTrailingSlash) || !errors.Is(err, ErrDuplicateParam) {
+		t.Fatalf("Validate() = %v, want both problems", err)
+	}
+	if n := len(strings.Split(err.Error(), "\n")); n != 2 {
+		t.Errorf("Validate() reported %d problems, want 2:\n%v", n, err)
+	}
+
+	if err := NewRouter().Validate(); err != nil {
+		t.Errorf("empty router: Validate() = %v", err)
+	}
+}
+
+func TestAddListenerValidatesRouter(t *testing.T) {
+	srv, _ := startServer(t)
+	r := NewRouter()
+	r.HandlePrefix("/files", "GET", func(*Request) Response { return Response{Status: 200} })
+
+	_, err := srv.AddListener("127.0.0.1:0", r)
+	if !errors.Is(err, ErrPrefixNoTrailingSlash) {
+		t.Errorf("AddListener with an invalid router = %v, want %v", err, ErrPrefixNoTrailingSlash)
+	}
+}
@@ -0,0 +1,394 @@
+// Package testclient is a raw HTTP/1.1 client for exercising the server at
+// the byte level: pipelining, slow senders, partial headers and framing
+// checks that a regular HTTP client hides.
+//
+// A Client wraps one TCP connection and can be reused for several
+// exchanges:
+//
+//	c, err := testclient.Dial("localhost:8080")
+//	if err != nil { ... }
+//	defer c.Close()
+//	c.SendRequest("GET", "/healthz", nil, nil)
+//	resp, err := c.ReadResponse()
+//	...
+//	err = c.ExpectEmpty(100 * time.Millisecond) // no stray bytes after the body
+package testclient
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds every read and write unless SetTimeout changes it.
+const DefaultTimeout = 5 * time.Second
+
+// Response is a parsed HTTP response. Header names are lowercased; a
+// header repeated on the wire keeps its values joined by ", ", except
+// Set-Cookie whose values are joined by "\n".
+type Response struct {
+	Version  string
+	Status   int
+	Reason   string
+	Headers  map[string]string
+	Body     []byte
+	Trailers map[string]string
+	Chunked  bool
+}
+
+// Header returns the value of the named header, case-insensitively.
+func (r *Response) Header(name string) string {
+	return r.Headers[strings.ToLower(name)]
+}
+
+// Client is a single connection to a server.
+type Client struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	sendDelay time.Duration
+	timeout   time.Duration
+
+	// pending holds the methods of requests not yet answered, oldest
+	// first, so ReadResponse knows that a HEAD response has no body.
+	pending []string
+}
+
+// Dial opens a TCP connection to addr.
+func Dial(addr string) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, DefaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient wraps an existing connection, such as one end of net.Pipe or a
+// TLS connection.
+func NewClient(conn net.Conn) *Client {
+	return &Client{conn: conn, reader: bufio.NewReader(conn), timeout: DefaultTimeout}
+}
+
+// SetSendDelay makes SendRaw write one byte at a time, sleeping perByte
+// after each, to simulate a slow client. Zero sends in one write.
+func (c *Client) SetSendDelay(perByte time.Duration) {
+	c.sendDelay = perByte
+}
+
+// SetTimeout changes the deadline applied to each read and write.
+func (c *Client) SetTimeout(d time.Duration) {
+	c.timeout = d
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// CloseWrite half-closes the connection so the server sees EOF while the
+// client can still read. It is a no-op for connections that do not support
+// it.
+func (c *Client) CloseWrite() error {
+	if cw, ok := c.conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// SendRaw writes data to the connection exactly as given.
+func (c *Client) SendRaw(data []byte) error {
+	c.trackRequests(data)
+	if c.sendDelay <= 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+		_, err := c.conn.Write(data)
+		return err
+	}
+	for i := range data {
+		c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+		if _, err := c.conn.Write(data[i : i+1]); err != nil {
+			return err
+		}
+		time.Sleep(c.sendDelay)
+	}
+	return nil
+}
+
+// SendRequest writes a request with the given headers and body. Host is
+// filled in from the connection's remote address and Content-Length from
+// the body unless headers set them (or Transfer-Encoding). Headers are
+// written in sorted order so the wire bytes are deterministic.
+func (c *Client) SendRequest(method, path string, headers map[string]string, body []byte) error {
+	return c.SendRaw(BuildRequest(method, path, c.conn.RemoteAddr().String(), headers, body))
+}
+
+// BuildRequest returns the wire bytes of a request, as sent by
+// SendRequest.
+func BuildRequest(method, path, host string, headers map[string]string, body []byte) []byte {
+	var hasHost, hasLength bool
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		switch strings.ToLower(name) {
+		case "host":
+			hasHost = true
+		case "content-length", "transfer-encoding":
+			hasLength = true
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\n", method, path)
+	if !hasHost {
+		fmt.Fprintf(&b, "Host: %s\r\n", host)
+	}
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s: %s\r\n", name, headers[name])
+	}
+	if !hasLength && (len(body) > 0 || method == "POST" || method == "PUT" || method == "PATCH") {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n", len(body))
+	}
+	b.WriteString("\r\n")
+	b.Write(body)
+	return b.Bytes()
+}
+
+// trackRequests records the method of every request line found in data so
+// ReadResponse knows which responses answer HEAD. Raw bytes that do not
+// start a request (body bytes, partial sends) are ignored.
+func (c *Client) trackRequests(data []byte) {
+	for _, line := range bytes.Split(data, []byte("\r\n")) {
+		fields := strings.Fields(string(line))
+		if len(fields) == 3 && strings.HasPrefix(fields[2], "HTTP/") && isToken(fields[0]) {
+			c.pending = append(c.pending, fields[0])
+		}
+	}
+}
+
+// ReadResponse reads the next response from the connection. Interim 1xx
+// responses are skipped. The body is framed by Transfer-Encoding: chunked,
+// Content-Length, or connection close, in that order of precedence.
+func (c *Client) ReadResponse() (*Response, error) {
+	method := ""
+	if len(c.pending) > 0 {
+		method = c.pending[0]
+	}
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+		resp, err := ReadResponse(c.reader, method)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Status >= 100 && resp.Status < 200 && resp.Status != 101 {
+			continue
+		}
+		if len(c.pending) > 0 {
+			c.pending = c.pending[1:]
+		}
+		return resp, nil
+	}
+}
+
+// ExpectClose waits until the server closes the connection and fails if
+// any bytes arrive first.
+func (c *Client) ExpectClose() error {
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	extra, err := io.ReadAll(c.reader)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return fmt.Errorf("connection still open after %s", c.timeout)
+		}
+		if !errors.Is(err, net.ErrClosed) && !isReset(err) {
+			return err
+		}
+	}
+	if len(extra) > 0 {
+		return fmt.Errorf("%d unexpected bytes before close: %q", len(extra), truncate(extra))
+	}
+	return nil
+}
+
+// ExpectEmpty fails if the server has sent bytes that no response has
+// consumed, which points at a framing bug such as a wrong Content-Length.
+// It waits up to wait for stray bytes to arrive.
+func (c *Client) ExpectEmpty(wait time.Duration) error {
+	if n := c.reader.Buffered(); n > 0 {
+		extra, _ := c.reader.Peek(n)
+		return fmt.Errorf("%d unread bytes: %q", n, truncate(extra))
+	}
+	c.conn.SetReadDeadline(time.Now().Add(wait))
+	defer c.conn.SetReadDeadline(time.Time{})
+	if _, err := c.reader.Peek(1); err == nil {
+		n := c.reader.Buffered()
+		extra, _ := c.reader.Peek(n)
+		return fmt.Errorf("%d unread bytes: %q", n, truncate(extra))
+	}
+	return nil
+}
+
+// ReadResponse parses one response from r. method is the request method
+// it answers; responses to HEAD never have a body. It is exported
+// separately from Client so canned bytes can be parsed directly.
+func ReadResponse(r *bufio.Reader, method string) (*Response, error) {
+	statusLine, err := readLine(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading status line: %w", err)
+	}
+	resp, err := parseStatusLine(statusLine)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Headers, err = readHeaders(r); err != nil {
+		return nil, err
+	}
+
+	if !hasBody(method, resp.Status) {
+		return resp, nil
+	}
+	if strings.EqualFold(lastListItem(resp.Headers["transfer-encoding"]), "chunked") {
+		resp.Chunked = true
+		resp.Body, resp.Trailers, err = readChunked(r)
+		return resp, err
+	}
+	if value, ok := resp.Headers["content-length"]; ok {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid Content-Length %q", value)
+		}
+		resp.Body = make([]byte, n)
+		if _, err := io.ReadFull(r, resp.Body); err != nil {
+			return nil, fmt.Errorf("reading body: %w", err)
+		}
+		return resp, nil
+	}
+	resp.Body, err = io.ReadAll(r)
+	return resp, err
+}
+
+func parseStatusLine(line string) (*Response, error) {
+	version, rest, ok := strings.Cut(line, " ")
+	if !ok || !strings.HasPrefix(version, "HTTP/") {
+		return nil, fmt.Errorf("malformed status line %q", line)
+	}
+	code, reason, _ := strings.Cut(rest, " ")
+	status, err := strconv.Atoi(code)
+	if err != nil || len(code) != 3 {
+		return nil, fmt.Errorf("malformed status code in %q", line)
+	}
+	return &Response{Version: version, Status: status, Reason: reason}, nil
+}
+
+// readHeaders reads header lines up to and including the blank line.
+func readHeaders(r *bufio.Reader) (map[string]string, error) {
+	headers := make(map[string]string)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, fmt.Errorf("reading headers: %w", err)
+		}
+		if line == "" {
+			return headers, nil
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || name == "" || !isToken(name) {
+			return nil, fmt.Errorf("malformed header line %q", line)
+		}
+		name, value = strings.ToLower(name), strings.TrimSpace(value)
+		if prev, ok := headers[name]; ok {
+			sep := ", "
+			if name == "set-cookie" {
+				sep = "\n"
+			}
+			value = prev + sep + value
+		}
+		headers[name] = value
+	}
+}
+
+// readChunked reads a chunked body and its trailers.
+func readChunked(r *bufio.Reader) ([]byte, map[string]string, error) {
+	var body bytes.Buffer
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading chunk size: %w", err)
+		}
+		sizeField, _, _ := strings.Cut(line, ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+		if err != nil || size < 0 {
+			return nil, nil, fmt.Errorf("invalid chunk size %q", line)
+		}
+		if size == 0 {
+			trailers, err := readHeaders(r)
+			if err != nil {
+				return nil, nil, fmt.Errorf("reading trailers: %w", err)
+			}
+			return body.Bytes(), trailers, nil
+		}
+		if _, err := io.CopyN(&body, r, size); err != nil {
+			return nil, nil, fmt.Errorf("reading chunk: %w", err)
+		}
+		crlf, err := readLine(r)
+		if err != nil || crlf != "" {
+			return nil, nil, fmt.Errorf("missing CRLF after chunk data")
+		}
+	}
+}
+
+// readLine reads a CRLF-terminated line and strips the terminator. A bare
+// LF is rejected, since the server must always send CRLF.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		if errors.Is(err, io.EOF) && line != "" {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", fmt.Errorf("line not terminated by CRLF: %q", line)
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+// hasBody reports whether a response to method with status carries a body.
+func hasBody(method string, status int) bool {
+	if method == "HEAD" {
+		return false
+	}
+	return !(status >= 100 && status < 200 || status == 204 || status == 304)
+}
+
+// lastListItem returns the last element of a comma-separated header value.
+func lastListItem(value string) string {
+	items := strings.Split(value, ",")
+	return strings.TrimSpace(items[len(items)-1])
+}
+
+func isToken(s string) bool {
+	for _, c := range s {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune("()<>@,;:\\\"/[]?={}", c) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isReset(err error) bool {
+	return strings.Contains(err.Error(), "connection reset")
+}
+
+// truncate shortens b for error messages.
+func truncate(b []byte) []byte {
+	if len(b) > 64 {
+		return b[:64]
+	}
+	return b
+}
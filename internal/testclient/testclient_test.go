@@ -0,0 +1,248 @@
+package testclient
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parse(t *testing.T, raw, method string) (*Response, error) {
+	t.Helper()
+	return ReadResponse(bufio.NewReader(strings.NewReader(raw)), method)
+}
+
+func TestReadResponse(t *testing.T) {
+	tests := []struct {
+		name, raw, method string
+		want              Response
+	}{
+		{
+			name:   "content length",
+			raw:    "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\nhello",
+			method: "GET",
+			want: Response{Version: "HTTP/1.1", Status: 200, Reason: "OK",
+				Headers: map[string]string{"content-type": "text/plain", "content-length": "5"}, Body: []byte("hello")},
+		},
+		{
+			name:   "chunked with extensions and trailers",
+			raw:    "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n1\r\n!\r\n0\r\nX-Checksum: abc\r\n\r\n",
+			method: "GET",
+			want: Response{Version: "HTTP/1.1", Status: 200, Reason: "OK",
+				Headers: map[string]string{"transfer-encoding": "chunked"}, Body: []byte("hello!"),
+				Trailers: map[string]string{"x-checksum": "abc"}, Chunked: true},
+		},
+		{
+			name:   "chunked wins over content length",
+			raw:    "HTTP/1.1 200 OK\r\nContent-Length: 99\r\nTransfer-Encoding: gzip, chunked\r\n\r\n2\r\nhi\r\n0\r\n\r\n",
+			method: "GET",
+			want: Response{Version: "HTTP/1.1", Status: 200, Reason: "OK",
+				Headers: map[string]string{"content-length": "99", "transfer-encoding": "gzip, chunked"}, Body: []byte("hi"),
+				Trailers: map[string]string{}, Chunked: true},
+		},
+		{
+			name:   "delimited by close",
+			raw:    "HTTP/1.0 200 OK\r\n\r\nuntil the end",
+			method: "GET",
+			want: Response{Version: "HTTP/1.0", Status: 200, Reason: "OK",
+				Headers: map[string]string{}, Body: []byte("until the end")},
+		},
+		{
+			name:   "HEAD has no body",
+			raw:    "HTTP/1.1 200 OK\r\nContent-Length: 11\r\n\r\n",
+			method: "HEAD",
+			want: Response{Version: "HTTP/1.1", Status: 200, Reason: "OK",
+				Headers: map[string]string{"content-length": "11"}},
+		},
+		{
+			name:   "204 has no body",
+			raw:    "HTTP/1.1 204 No Content\r\n\r\n",
+			method: "DELETE",
+			want:   Response{Version: "HTTP/1.1", Status: 204, Reason: "No Content", Headers: map[string]string{}},
+		},
+		{
+			name:   "repeated headers",
+			raw:    "HTTP/1.1 200 OK\r\nVary: Accept\r\nvary: Origin\r\nSet-Cookie: a=1\r\nSet-Cookie: b=2\r\nContent-Length: 0\r\n\r\n",
+			method: "GET",
+			want: Response{Version: "HTTP/1.1", Status: 200, Reason: "OK",
+				Headers: map[string]string{"vary": "Accept, Origin", "set-cookie": "a=1\nb=2", "content-length": "0"}, Body: []byte{}},
+		},
+		{
+			name:   "empty reason",
+			raw:    "HTTP/1.1 404\r\nContent-Length: 0\r\n\r\n",
+			method: "GET",
+			want: Response{Version: "HTTP/1.1", Status: 404,
+				Headers: map[string]string{"content-length": "0"}, Body: []byte{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parse(t, tt.raw, tt.method)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadResponseErrors(t *testing.T) {
+	tests := []struct{ name, raw string }{
+		{"bare LF", "HTTP/1.1 200 OK\nContent-Length: 0\n\n"},
+		{"not HTTP", "HTTX/1.1 200 OK\r\n\r\n"},
+		{"short status code", "HTTP/1.1 20 OK\r\n\r\n"},
+		{"header without colon", "HTTP/1.1 200 OK\r\nBroken\r\n\r\n"},
+		{"space in header name", "HTTP/1.1 200 OK\r\nBad Name: x\r\n\r\n"},
+		{"negative length", "HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n"},
+		{"short body", "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort"},
+		{"bad chunk size", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n"},
+		{"chunk without CRLF", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhiX\r\n0\r\n\r\n"},
+		{"truncated headers", "HTTP/1.1 200 OK\r\nContent-Le"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp, err := parse(t, tt.raw, "GET"); err == nil {
+				t.Errorf("parsed %q as %+v, want an error", tt.raw, resp)
+			}
+		})
+	}
+}
+
+func TestReadResponseLeavesNextResponse(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader(
+		"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nhi" +
+			"HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nbye"))
+	for _, want := range []string{"hi", "bye"} {
+		resp, err := ReadResponse(r, "GET")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Body) != want {
+			t.Errorf("body = %q, want %q", resp.Body, want)
+		}
+	}
+	if _, err := ReadResponse(r, "GET"); err == nil {
+		t.Error("read a third response from two")
+	}
+}
+
+func TestBuildRequest(t *testing.T) {
+	got := string(BuildRequest("POST", "/files/a", "example.com", map[string]string{"X-B": "2", "X-A": "1"}, []byte("body")))
+	want := "POST /files/a HTTP/1.1\r\nHost: example.com\r\nX-A: 1\r\nX-B: 2\r\nContent-Length: 4\r\n\r\nbody"
+	if got != want {
+		t.Errorf("BuildRequest = %q, want %q", got, want)
+	}
+
+	got = string(BuildRequest("GET", "/", "example.com", map[string]string{"Host": "other"}, nil))
+	if want := "GET / HTTP/1.1\r\nHost: other\r\n\r\n"; got != want {
+		t.Errorf("BuildRequest with Host = %q, want %q", got, want)
+	}
+}
+
+// cannedServer accepts one connection, reads until the client has sent
+// wantRequests request heads, and writes reply.
+func cannedServer(t *testing.T, wantRequests int, reply string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var got strings.Builder
+		buf := make([]byte, 1024)
+		for strings.Count(got.String(), "\r\n\r\n") < wantRequests {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			got.Write(buf[:n])
+		}
+		io.WriteString(conn, reply)
+		time.Sleep(100 * time.Millisecond)
+	}()
+	return listener.Addr().String()
+}
+
+func TestClientPipelinesAndTracksHEAD(t *testing.T) {
+	addr := cannedServer(t, 2,
+		"HTTP/1.1 100 Continue\r\n\r\n"+
+			"HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\n"+
+			"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	pipelined := append(BuildRequest("HEAD", "/a", addr, nil, nil), BuildRequest("GET", "/b", addr, nil, nil)...)
+	if err := c.SendRaw(pipelined); err != nil {
+		t.Fatal(err)
+	}
+	head, err := c.ReadResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Status != 200 || len(head.Body) != 0 || head.Header("Content-Length") != "4" {
+		t.Errorf("HEAD response = %+v, want 200 with Content-Length 4 and no body", head)
+	}
+	get, err := c.ReadResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(get.Body) != "ok" {
+		t.Errorf("GET body = %q, want %q", get.Body, "ok")
+	}
+	if err := c.ExpectEmpty(20 * time.Millisecond); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClientExpectEmptyCatchesStrayBytes(t *testing.T) {
+	addr := cannedServer(t, 1, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nokEXTRA")
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.SendRequest("GET", "/", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReadResponse(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ExpectEmpty(100 * time.Millisecond); err == nil || !strings.Contains(err.Error(), "EXTRA") {
+		t.Errorf("ExpectEmpty = %v, want it to report the stray bytes", err)
+	}
+}
+
+func TestClientExpectClose(t *testing.T) {
+	addr := cannedServer(t, 1, "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 2\r\n\r\nok")
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetTimeout(2 * time.Second)
+
+	if err := c.SendRequest("GET", "/", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReadResponse(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ExpectClose(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxFilenameBytes is the default length limit applied by SanitizeFilename,
+// the common maximum for a single path component on Linux, macOS and
+// Windows.
+const MaxFilenameBytes = 255
+
+// ErrInvalidFilename is returned when nothing usable is left of a filename
+// after sanitizing, e.g. for "", "..", or a name made only of control
+// characters.
+var ErrInvalidFilename = errors.New("invalid filename")
+
+// FilenameOptions controls SanitizeFilenameWith.
+type FilenameOptions struct {
+	// MaxBytes limits the UTF-8 length of the result. Zero means
+	// MaxFilenameBytes.
+	MaxBytes int
+
+	// ASCII transliterates accented Latin letters to their base letter and
+	// replaces any other non-ASCII character with "_".
+	ASCII bool
+}
+
+// windowsInvalid lists the printable characters Windows refuses in
+// filenames, in addition to control characters.
+const windowsInvalid = `<>:"/\|?*`
+
+// windowsReserved holds device names Windows reserves regardless of
+// extension, so "con.txt" is as unusable as "CON".
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFilename turns a client-supplied filename into one that is safe
+// to join onto a directory on any platform, using the default options.
+//
+// It strips directory components (both "/" and "\" separators), removes
+// control characters, invalid UTF-8 and characters Windows rejects, trims
+// leading spaces and trailing dots and spaces, prefixes Windows device
+// names such as "CON" or "lpt1.txt" with "_", and truncates to
+// MaxFilenameBytes without splitting a UTF-8 sequence, keeping the
+// extension where possible. The result never depends on the locale.
+//
+// Returns ErrInvalidFilename if the name is empty, "." or "..", or made up
+// entirely of dots or removed characters.
+//
+// Example:
+//
+//	name, err := utils.SanitizeFilename(`..\..\report:2024.pdf`)
+//	// name == "report2024.pdf"
+func SanitizeFilename(name string) (string, error) {
+	return SanitizeFilenameWith(name, FilenameOptions{})
+}
+
+// SanitizeFilenameWith is SanitizeFilename with explicit options.
+func SanitizeFilenameWith(name string, opts FilenameOptions) (string, error) {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = MaxFilenameBytes
+	}
+
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+
+	var b strings.Builder
+	for len(name) > 0 {
+		r, size := utf8.DecodeRuneInString(name)
+		name = name[size:]
+		switch {
+		case r == utf8.RuneError && size <= 1:
+			continue
+		case unicode.IsControl(r) || strings.ContainsRune(windowsInvalid, r):
+			continue
+		case r >= utf8.RuneSelf && opts.ASCII:
+			if base, ok := transliterate(r); ok {
+				b.WriteString(base)
+			} else {
+				b.WriteByte('_')
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	clean := strings.TrimLeft(b.String(), " ")
+	clean = strings.TrimRight(clean, ". ")
+	if strings.Trim(clean, ".") == "" {
+		return "", ErrInvalidFilename
+	}
+
+	base, _, _ := strings.Cut(clean, ".")
+	if windowsReserved[strings.ToUpper(strings.TrimRight(base, " "))] {
+		clean = "_" + clean
+	}
+	return truncateFilename(clean, opts.MaxBytes), nil
+}
+
+// truncateFilename shortens name to at most maxBytes bytes on a rune
+// boundary. The extension is kept when it is short enough to leave room
+// for part of the stem.
+func truncateFilename(name string, maxBytes int) string {
+	if len(name) <= maxBytes {
+		return name
+	}
+	ext := ""
+	if i := strings.LastIndexByte(name, '.'); i > 0 && len(name)-i < maxBytes/2 {
+		name, ext = name[:i], name[i:]
+	}
+	limit := maxBytes - len(ext)
+	for limit > 0 && !utf8.RuneStart(name[limit]) {
+		limit--
+	}
+	return strings.TrimRight(name[:limit], ". ") + ext
+}
+
+// transliterate maps an accented Latin letter to its ASCII base letter.
+func transliterate(r rune) (string, bool) {
+	for base, variants := range latinFolds {
+		if strings.ContainsRune(variants, r) {
+			return base, true
+		}
+	}
+	switch r {
+	case 'ß':
+		return "ss", true
+	case 'Æ':
+		return "AE", true
+	case 'æ':
+		return "ae", true
+	case 'Œ':
+		return "OE", true
+	case 'œ':
+		return "oe", true
+	}
+	return "", false
+}
+
+// latinFolds lists Latin-1 Supplement and Latin Extended-A letters by the
+// ASCII letter they fold to.
+var latinFolds = map[string]string{
+	"A": "ÀÁÂÃÄÅĀĂĄ",
+	"a": "àáâãäåāăą",
+	"C": "ÇĆĈĊČ",
+	"c": "çćĉċč",
+	"D": "ĎĐ",
+	"d": "ďđ",
+	"E": "ÈÉÊËĒĔĖĘĚ",
+	"e": "èéêëēĕėęě",
+	"G": "ĜĞĠĢ",
+	"g": "ĝğġģ",
+	"H": "ĤĦ",
+	"h": "ĥħ",
+	"I": "ÌÍÎÏĨĪĬĮİ",
+	"i": "ìíîïĩīĭįı",
+	"J": "Ĵ",
+	"j": "ĵ",
+	"K": "Ķ",
+	"k": "ķ",
+	"L": "ĹĻĽĿŁ",
+	"l": "ĺļľŀł",
+	"N": "ÑŃŅŇ",
+	"n": "ñńņň",
+	"O": "ÒÓÔÕÖØŌŎŐ",
+	"o": "òóôõöøōŏő",
+	"R": "ŔŖŘ",
+	"r": "ŕŗř",
+	"S": "ŚŜŞŠ",
+	"s": "śŝşš",
+	"T": "ŢŤŦ",
+	"t": "ţťŧ",
+	"U": "ÙÚÛÜŨŪŬŮŰŲ",
+	"u": "ùúûüũūŭůűų",
+	"W": "Ŵ",
+	"w": "ŵ",
+	"Y": "ÝŶŸ",
+	"y": "ýÿŷ",
+	"Z": "ŹŻŽ",
+	"z": "źżž",
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	for _, tt := range []struct {
+		name, in, want string
+	}{
+		{"plain", "report.pdf", "report.pdf"},
+		{"hidden file", ".profile", ".profile"},
+		{"spaces inside", "my notes.txt", "my notes.txt"},
+
+		// Traversal and directory components.
+		{"unix traversal", "../../etc/passwd", "passwd"},
+		{"windows traversal", `..\..\report:2024.pdf`, "report2024.pdf"},
+		{"mixed separators", `a/b\c/d.txt`, "d.txt"},
+		{"absolute unix path", "/etc/shadow", "shadow"},
+		{"drive letter", `C:\Windows\win.ini`, "win.ini"},
+		{"drive-relative", "C:evil.txt", "Cevil.txt"},
+		{"UNC path", `\\server\share\x.txt`, "x.txt"},
+
+		// Injection: control characters and NUL.
+		{"NUL truncation", "evil.php\x00.jpg", "evil.php.jpg"},
+		{"header injection", "a\r\nSet-Cookie: x=1", "aSet-Cookie x=1"},
+		{"escape sequence", "\x1b[31mred.txt", "[31mred.txt"},
+		{"C1 control", "line\u0085break.txt", "linebreak.txt"},
+		{"DEL", "a\x7fb.txt", "ab.txt"},
+		{"invalid UTF-8", "bad\xff\xfe.txt", "bad.txt"},
+		{"windows-invalid characters", `what?<>|*".txt`, "what.txt"},
+
+		// Trimming.
+		{"leading spaces", "   a.txt", "a.txt"},
+		{"trailing dots and spaces", "a.txt. . ", "a.txt"},
+
+		// Windows device names.
+		{"device name", "CON", "_CON"},
+		{"device name any case", "con", "_con"},
+		{"device name with extension", "lpt1.txt", "_lpt1.txt"},
+		{"device name with two extensions", "Aux.tar.gz", "_Aux.tar.gz"},
+		{"device name with trailing space", "NUL .txt", "_NUL .txt"},
+		{"device name after traversal", "../com9", "_com9"},
+		{"longer than a device name", "COM10", "COM10"},
+		{"device name as a prefix", "CONSOLE.txt", "CONSOLE.txt"},
+
+		// Unicode is kept as is: no case mapping or normalization, so the
+		// result is the same under any locale.
+		{"emoji", "📄 notes.txt", "📄 notes.txt"},
+		{"accented", "café.txt", "café.txt"},
+		{"turkish dotted capital I", "İSTANBUL.txt", "İSTANBUL.txt"},
+		{"turkish dotless i", "ıi.txt", "ıi.txt"},
+		{"decomposed accent", "cafe\u0301.txt", "cafe\u0301.txt"},
+		{"CJK", "日本語.txt", "日本語.txt"},
+		{"right-to-left text", "ملف.txt", "ملف.txt"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SanitizeFilename(tt.in)
+			if err != nil || got != tt.want {
+				t.Errorf("SanitizeFilename(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeFilenameInvalid(t *testing.T) {
+	for _, in := range []string{
+		"", ".", "..", "...", "   ", ". .",
+		"dir/", `dir\`, "a/..", `a\.`,
+		"\x00", "\r\n\t", "\xff\xfe", `<>:"|?*`, "?.",
+	} {
+		if got, err := SanitizeFilename(in); !errors.Is(err, ErrInvalidFilename) {
+			t.Errorf("SanitizeFilename(%q) = %q, %v; want ErrInvalidFilename", in, got, err)
+		}
+	}
+}
+
+func TestSanitizeFilenameLength(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		in       string
+		maxBytes int
+		want     string
+	}{
+		{"exactly the limit", strings.Repeat("a", 251) + ".txt", 0, strings.Repeat("a", 251) + ".txt"},
+		{"300 bytes keeps the extension", strings.Repeat("a", 296) + ".txt", 0, strings.Repeat("a", 251) + ".txt"},
+		{"300 bytes of two-byte runes", strings.Repeat("é", 150), 0, strings.Repeat("é", 127)},
+		{"300 bytes of emoji", strings.Repeat("😀", 75), 0, strings.Repeat("😀", 63)},
+		{"emoji before the extension", strings.Repeat("😀", 75) + ".png", 0, strings.Repeat("😀", 62) + ".png"},
+		{"extension too long to keep", "a." + strings.Repeat("x", 300), 0, "a." + strings.Repeat("x", 253)},
+		{"custom limit", "abcdefghijkl.txt", 10, "abcdef.txt"},
+		{"cut before trailing dots", "abcd..efgh.txt", 10, "abcd.txt"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SanitizeFilenameWith(tt.in, FilenameOptions{MaxBytes: tt.maxBytes})
+			if err != nil || got != tt.want {
+				t.Errorf("SanitizeFilenameWith(%d bytes, %d) = %q (%d bytes), %v; want %q (%d bytes)",
+					len(tt.in), tt.maxBytes, got, len(got), err, tt.want, len(tt.want))
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("result %q is not valid UTF-8", got)
+			}
+		})
+	}
+}
+
+func TestSanitizeFilenameASCII(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"café.txt", "cafe.txt"},
+		{"Ærøskøbing.txt", "AEroskobing.txt"},
+		{"straße.txt", "strasse.txt"},
+		{"İSTANBUL.txt", "ISTANBUL.txt"},
+		{"ıi.txt", "ii.txt"},
+		{"cafe\u0301.txt", "cafe_.txt"},
+		{"日本.txt", "__.txt"},
+		{"📄 notes.txt", "_ notes.txt"},
+		{"../señor/año.md", "ano.md"},
+	} {
+		got, err := SanitizeFilenameWith(tt.in, FilenameOptions{ASCII: true})
+		if err != nil || got != tt.want {
+			t.Errorf("SanitizeFilenameWith(%q, ASCII) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
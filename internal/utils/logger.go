@@ -1,5 +1,6 @@
 // Package utils provides shared utility functions for logging and diagnostics.
-// It includes a lightweight logger with configurable verbosity levels.
+// It includes a lightweight logger with configurable verbosity levels and a
+// filename sanitizer for client-supplied names.
 package utils
 
 import (
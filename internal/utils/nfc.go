@@ -0,0 +1,107 @@
+package utils
+
+import "unicode/utf8"
+
+// ComposeLatin returns s with Latin letters followed by combining accents
+// replaced by their precomposed forms, "e\u0301" becoming "é", as Unicode
+// normalization form C (NFC) does. Clients such as macOS send names in
+// decomposed form, so composing them makes the same name compare, and be
+// stored, the same whichever way it arrived.
+//
+// Only the compositions of the Latin blocks (Latin-1 Supplement to Latin
+// Extended-B, and Latin Extended Additional) are applied, each mark to the
+// letter directly before it, which covers accents given in canonical
+// order. Other text is returned unchanged.
+func ComposeLatin(s string) string {
+	if isASCII(s) {
+		return s
+	}
+	out := make([]rune, 0, len(s))
+	for _, r := range s {
+		if n := len(out); n > 0 {
+			if composed, ok := latinComposition[[2]rune{out[n-1], r}]; ok {
+				out[n-1] = composed
+				continue
+			}
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// latinComposition maps a letter and a combining mark to the precomposed
+// letter, built from latinCompositions.
+var latinComposition = func() map[[2]rune]rune {
+	m := make(map[[2]rune]rune)
+	for mark, forms := range latinCompositions {
+		bases, composed := []rune(forms[0]), []rune(forms[1])
+		for i, base := range bases {
+			m[[2]rune{base, mark}] = composed[i]
+		}
+	}
+	return m
+}()
+
+// latinCompositions lists, by combining mark, the letters it composes with
+// and the precomposed letters they form, in the same order.
+var latinCompositions = map[rune][2]string{
+	// combining grave accent
+	0x0300: {"AEIOUaeiouÜüNnĒēŌōWwÂâĂăÊêÔôƠơƯưYy", "ÀÈÌÒÙàèìòùǛǜǸǹḔḕṐṑẀẁẦầẰằỀềỒồỜờỪừỲỳ"},
+	// combining acute accent
+	0x0301: {"AEIOUYaeiouyCcLlNnRrSsZzÜüGgÅåÆæØøÇçĒēÏïKkMmÕõŌōPpŨũWwÂâĂăÊêÔôƠơƯư", "ÁÉÍÓÚÝáéíóúýĆćĹĺŃńŔŕŚśŹźǗǘǴǵǺǻǼǽǾǿḈḉḖḗḮḯḰḱḾḿṌṍṒṓṔṕṸṹẂẃẤấẮắẾếỐốỚớỨứ"},
+	// combining circumflex accent
+	0x0302: {"AEIOUaeiouCcGgHhJjSsWwYyZzẠạẸẹỌọ", "ÂÊÎÔÛâêîôûĈĉĜĝĤĥĴĵŜŝŴŵŶŷẐẑẬậỆệỘộ"},
+	// combining tilde
+	0x0303: {"ANOanoIiUuVvÂâĂăEeÊêÔôƠơƯưYy", "ÃÑÕãñõĨĩŨũṼṽẪẫẴẵẼẽỄễỖỗỠỡỮữỸỹ"},
+	// combining macron
+	0x0304: {"AaEeIiOoUuÜüÄäȦȧÆæǪǫÖöÕõȮȯYyGgḶḷṚṛ", "ĀāĒēĪīŌōŪūǕǖǞǟǠǡǢǣǬǭȪȫȬȭȰȱȲȳḠḡḸḹṜṝ"},
+	// combining breve
+	0x0306: {"AaEeGgIiOoUuȨȩẠạ", "ĂăĔĕĞğĬĭŎŏŬŭḜḝẶặ"},
+	// combining dot above
+	0x0307: {"CcEeGgIZzAaOoBbDdFfHhMmNnPpRrSsŚśŠšṢṣTtWwXxYyſ", "ĊċĖėĠġİŻżȦȧȮȯḂḃḊḋḞḟḢḣṀṁṄṅṖṗṘṙṠṡṤṥṦṧṨṩṪṫẆẇẊẋẎẏẛ"},
+	// combining diaeresis
+	0x0308: {"AEIOUaeiouyYHhÕõŪūWwXxt", "ÄËÏÖÜäëïöüÿŸḦḧṎṏṺṻẄẅẌẍẗ"},
+	// combining hook above
+	0x0309: {"AaÂâĂăEeÊêIiOoÔôƠơUuƯưYy", "ẢảẨẩẲẳẺẻỂểỈỉỎỏỔổỞởỦủỬửỶỷ"},
+	// combining ring above
+	0x030A: {"AaUuwy", "ÅåŮůẘẙ"},
+	// combining double acute accent
+	0x030B: {"OoUu", "ŐőŰű"},
+	// combining caron
+	0x030C: {"CcDdEeLlNnRrSsTtZzAaIiOoUuÜüGgKkƷʒjHh", "ČčĎďĚěĽľŇňŘřŠšŤťŽžǍǎǏǐǑǒǓǔǙǚǦǧǨǩǮǯǰȞȟ"},
+	// combining double grave accent
+	0x030F: {"AaEeIiOoRrUu", "ȀȁȄȅȈȉȌȍȐȑȔȕ"},
+	// combining inverted breve
+	0x0311: {"AaEeIiOoRrUu", "ȂȃȆȇȊȋȎȏȒȓȖȗ"},
+	// combining horn
+	0x031B: {"OoUu", "ƠơƯư"},
+	// combining dot below
+	0x0323: {"BbDdHhKkLlMmNnRrSsTtVvWwZzAaEeIiOoƠơUuƯưYy", "ḄḅḌḍḤḥḲḳḶḷṂṃṆṇṚṛṢṣṬṭṾṿẈẉẒẓẠạẸẹỊịỌọỢợỤụỰựỴỵ"},
+	// combining diaeresis below
+	0x0324: {"Uu", "Ṳṳ"},
+	// combining ring below
+	0x0325: {"Aa", "Ḁḁ"},
+	// combining comma below
+	0x0326: {"SsTt", "ȘșȚț"},
+	// combining cedilla
+	0x0327: {"CcGgKkLlNnRrSsTtEeDdHh", "ÇçĢģĶķĻļŅņŖŗŞşŢţȨȩḐḑḨḩ"},
+	// combining ogonek
+	0x0328: {"AaEeIiUuOo", "ĄąĘęĮįŲųǪǫ"},
+	// combining circumflex accent below
+	0x032D: {"DdEeLlNnTtUu", "ḒḓḘḙḼḽṊṋṰṱṶṷ"},
+	// combining breve below
+	0x032E: {"Hh", "Ḫḫ"},
+	// combining tilde below
+	0x0330: {"EeIiUu", "ḚḛḬḭṴṵ"},
+	// combining macron below
+	0x0331: {"BbDdKkLlNnRrTtZzh", "ḆḇḎḏḴḵḺḻṈṉṞṟṮṯẔẕẖ"},
+}
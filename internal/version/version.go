@@ -0,0 +1,79 @@
+// Package version reports what build of the server is running.
+//
+// The values are set at link time:
+//
+//	go build -ldflags "\
+//	    -X github.com/Abb133Se/httpServer/internal/version.Version=1.4.0 \
+//	    -X github.com/Abb133Se/httpServer/internal/version.Commit=$(git rev-parse --short HEAD) \
+//	    -X github.com/Abb133Se/httpServer/internal/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" \
+//	    ./cmd/server
+//
+// Without ldflags the commit and build date fall back to the VCS stamp Go
+// embeds in the binary, and anything still unknown reads "dev".
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set with -ldflags "-X ...". Empty means unknown.
+var (
+	Version   string
+	Commit    string
+	BuildDate string
+)
+
+// Unknown is reported for any value not set at build time.
+const Unknown = "dev"
+
+// Info describes the running build.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+// Get returns the build information, never with empty fields.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = shortCommit(setting.Value)
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+	for _, field := range []*string{&info.Version, &info.Commit, &info.BuildDate} {
+		if *field == "" {
+			*field = Unknown
+		}
+	}
+	return info
+}
+
+// String formats the build as "dev (commit 1a2b3c4, built 2024-05-01T10:00:00Z)".
+func (i Info) String() string {
+	return fmt.Sprintf("%s (commit %s, built %s)", i.Version, i.Commit, i.BuildDate)
+}
+
+func shortCommit(rev string) string {
+	if len(rev) > 12 {
+		return rev[:12]
+	}
+	return rev
+}
@@ -0,0 +1,49 @@
+package version
+
+import (
+	"runtime"
+	"testing"
+)
+
+// setVersion sets the link-time variables for the test and restores them
+// afterwards.
+func setVersion(t *testing.T, version, commit, buildDate string) {
+	t.Helper()
+	saved := [3]string{Version, Commit, BuildDate}
+	Version, Commit, BuildDate = version, commit, buildDate
+	t.Cleanup(func() { Version, Commit, BuildDate = saved[0], saved[1], saved[2] })
+}
+
+func TestGetWithoutLdflags(t *testing.T) {
+	setVersion(t, "", "", "")
+	info := Get()
+	// Test binaries carry no VCS stamp, so nothing can fill the gaps.
+	if info.Version != Unknown || info.Commit != Unknown || info.BuildDate != Unknown {
+		t.Errorf("Get() = %+v, want %q for every unset value", info, Unknown)
+	}
+	if info.GoVersion != runtime.Version() || info.OS != runtime.GOOS || info.Arch != runtime.GOARCH {
+		t.Errorf("Get() = %+v, want the running Go version and platform", info)
+	}
+}
+
+func TestGetWithLdflags(t *testing.T) {
+	setVersion(t, "1.4.0", "1a2b3c4", "2024-05-01T10:00:00Z")
+	info := Get()
+	if info.Version != "1.4.0" || info.Commit != "1a2b3c4" || info.BuildDate != "2024-05-01T10:00:00Z" {
+		t.Errorf("Get() = %+v, want the values set at link time", info)
+	}
+	if got, want := info.String(), "1.4.0 (commit 1a2b3c4, built 2024-05-01T10:00:00Z)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestShortCommit(t *testing.T) {
+	for rev, want := range map[string]string{
+		"1a2b3c4": "1a2b3c4",
+		"1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b": "1a2b3c4d5e6f",
+	} {
+		if got := shortCommit(rev); got != want {
+			t.Errorf("shortCommit(%q) = %q, want %q", rev, got, want)
+		}
+	}
+}